@@ -0,0 +1,219 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package audit defines the events that are recorded in the audit log
+// of the identity service, along with the interfaces used to store
+// them.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// An EventType identifies the kind of action an Event records.
+type EventType string
+
+const (
+	// Login events are recorded when a user completes, or fails
+	// to complete, an interactive login with an identity
+	// provider.
+	Login EventType = "login"
+
+	// Discharge events are recorded when a third-party caveat is
+	// discharged, or a discharge is refused.
+	Discharge EventType = "discharge"
+
+	// SetGroups events are recorded when the groups of a user are
+	// replaced. The groups that were gained and lost are recorded
+	// in Add and Remove.
+	SetGroups EventType = "set-groups"
+
+	// ModifyGroups events are recorded when groups are added to,
+	// or removed from, a user.
+	ModifyGroups EventType = "modify-groups"
+
+	// PutSSHKeys events are recorded when SSH keys are added to a
+	// user.
+	PutSSHKeys EventType = "put-ssh-keys"
+
+	// DeleteSSHKeys events are recorded when SSH keys are removed
+	// from a user.
+	DeleteSSHKeys EventType = "delete-ssh-keys"
+
+	// CreateAgent events are recorded when a new agent identity is
+	// created.
+	CreateAgent EventType = "create-agent"
+
+	// DeleteUser events are recorded when a user or agent identity
+	// is deleted. The groups the identity was a member of are
+	// recorded in Remove.
+	DeleteUser EventType = "delete-user"
+
+	// SuspendUser events are recorded when a user is suspended by
+	// the reaper for being inactive.
+	SuspendUser EventType = "suspend-user"
+
+	// FlagUser events are recorded when the reaper finds an
+	// inactive user that it has been configured to report, but not
+	// otherwise act upon.
+	FlagUser EventType = "flag-user"
+
+	// ProvisionUser events are recorded when a user is created or
+	// updated by a SCIM provisioning client.
+	ProvisionUser EventType = "provision-user"
+
+	// DeprovisionUser events are recorded when a user is
+	// deprovisioned by a SCIM provisioning client.
+	DeprovisionUser EventType = "deprovision-user"
+
+	// CreateGroup events are recorded when a new group is created.
+	CreateGroup EventType = "create-group"
+
+	// DeleteGroup events are recorded when a group is deleted.
+	DeleteGroup EventType = "delete-group"
+
+	// SetGroupDescription events are recorded when the description
+	// of a group is changed.
+	SetGroupDescription EventType = "set-group-description"
+
+	// SetGroupOwners events are recorded when the owners of a group
+	// are replaced.
+	SetGroupOwners EventType = "set-group-owners"
+
+	// ModifyMemberGroups events are recorded when member groups are
+	// added to, or removed from, a group.
+	ModifyMemberGroups EventType = "modify-member-groups"
+
+	// RequestElevation events are recorded when a user requests
+	// temporary membership of a group.
+	RequestElevation EventType = "request-elevation"
+
+	// ApproveElevation events are recorded when an elevation
+	// request is approved. Until holds the time at which the
+	// elevation expires.
+	ApproveElevation EventType = "approve-elevation"
+
+	// DenyElevation events are recorded when an elevation request
+	// is refused.
+	DenyElevation EventType = "deny-elevation"
+
+	// RevokeElevation events are recorded when an approved
+	// elevation is withdrawn before it expires.
+	RevokeElevation EventType = "revoke-elevation"
+
+	// SetACL events are recorded when the members of an ACL are
+	// replaced.
+	SetACL EventType = "set-acl"
+
+	// ModifyACL events are recorded when members are added to, or
+	// removed from, an ACL.
+	ModifyACL EventType = "modify-acl"
+)
+
+// An Event is a single entry in the audit log.
+type Event struct {
+	// Time holds the time at which the event occurred.
+	Time time.Time `json:"time"`
+
+	// Type holds the type of the event.
+	Type EventType `json:"type"`
+
+	// Actor holds the username of the user that performed the
+	// action, if known.
+	Actor string `json:"actor,omitempty"`
+
+	// User holds the username, or for a failed login the identity
+	// provider's name for the user, of the user that the action
+	// was applied to.
+	User string `json:"user,omitempty"`
+
+	// IDP holds the name of the identity provider used in a login
+	// event.
+	IDP string `json:"idp,omitempty"`
+
+	// Caveat holds the condition of the third-party caveat in a
+	// discharge event.
+	Caveat string `json:"caveat,omitempty"`
+
+	// ACL holds the name of the ACL in an ACL event.
+	ACL string `json:"acl,omitempty"`
+
+	// Group holds the name of the group in a group event, or the group
+	// granted by an active elevation in a discharge event.
+	Group string `json:"group,omitempty"`
+
+	// Set holds the values that replaced the previous values of a
+	// set, such as a user's groups or the members of an ACL.
+	Set []string `json:"set,omitempty"`
+
+	// Add holds the values that were added to a set.
+	Add []string `json:"add,omitempty"`
+
+	// Remove holds the values that were removed from a set.
+	Remove []string `json:"remove,omitempty"`
+
+	// Until holds the time at which the values that were added to
+	// a set, such as a user's groups, expire.
+	Until *time.Time `json:"until,omitempty"`
+
+	// Error holds the error message if the action failed.
+	Error string `json:"error,omitempty"`
+}
+
+// A Sink records audit events.
+type Sink interface {
+	// Log records the given event.
+	Log(ctx context.Context, e Event) error
+}
+
+// A Store is a Sink that can also retrieve the events it has
+// recorded.
+type Store interface {
+	Sink
+
+	// Events returns the recorded events that match the given
+	// filter in the order in which they occurred.
+	Events(ctx context.Context, f Filter) ([]Event, error)
+}
+
+// A Filter selects events from a Store.
+type Filter struct {
+	// Type, if not empty, restricts the events to those of the
+	// given type.
+	Type EventType
+
+	// User, if not empty, restricts the events to those with the
+	// given user as either the actor or the user.
+	User string
+
+	// After, if not zero, restricts the events to those that
+	// occurred at or after the given time.
+	After time.Time
+
+	// Before, if not zero, restricts the events to those that
+	// occurred before the given time.
+	Before time.Time
+
+	// Limit, if greater than zero, holds the maximum number of
+	// events to return.
+	Limit int
+}
+
+// Match reports whether the given event is selected by the filter,
+// ignoring the limit.
+func (f Filter) Match(e Event) bool {
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	if f.User != "" && e.Actor != f.User && e.User != f.User {
+		return false
+	}
+	if !f.After.IsZero() && e.Time.Before(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !e.Time.Before(f.Before) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// NewWriterSink returns a Sink that writes each event to w as a single
+// line of JSON. Each event is written with a single call to w.Write, so
+// w may be a log file or a syslog-style writer that treats each write
+// as a separate message.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Log implements Sink.Log.
+func (s *writerSink) Log(_ context.Context, e Event) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	buf = append(buf, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(buf)
+	return errgo.Mask(err)
+}
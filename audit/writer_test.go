@@ -0,0 +1,97 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/candid/audit"
+)
+
+func TestWriterSink(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	sink := audit.NewWriterSink(&buf)
+	err := sink.Log(context.Background(), audit.Event{
+		Time:  time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Type:  audit.SetGroups,
+		Actor: "admin@candid",
+		User:  "bob",
+		Set:   []string{"g1", "g2"},
+	})
+	c.Assert(err, qt.IsNil)
+	err = sink.Log(context.Background(), audit.Event{
+		Time:  time.Date(2021, 1, 2, 3, 4, 6, 0, time.UTC),
+		Type:  audit.Login,
+		User:  "bob",
+		IDP:   "test",
+		Error: "login failed",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(buf.String(), qt.Equals, `{"time":"2021-01-02T03:04:05Z","type":"set-groups","actor":"admin@candid","user":"bob","set":["g1","g2"]}
+{"time":"2021-01-02T03:04:06Z","type":"login","user":"bob","idp":"test","error":"login failed"}
+`)
+}
+
+var filterMatchTests = []struct {
+	about  string
+	filter audit.Filter
+	expect bool
+}{{
+	about:  "empty filter",
+	expect: true,
+}, {
+	about:  "matching type",
+	filter: audit.Filter{Type: audit.Login},
+	expect: true,
+}, {
+	about:  "different type",
+	filter: audit.Filter{Type: audit.Discharge},
+}, {
+	about:  "matching actor",
+	filter: audit.Filter{User: "admin@candid"},
+	expect: true,
+}, {
+	about:  "matching user",
+	filter: audit.Filter{User: "bob"},
+	expect: true,
+}, {
+	about:  "different user",
+	filter: audit.Filter{User: "alice"},
+}, {
+	about:  "after",
+	filter: audit.Filter{After: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)},
+	expect: true,
+}, {
+	about:  "not after",
+	filter: audit.Filter{After: time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+}, {
+	about:  "before",
+	filter: audit.Filter{Before: time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+	expect: true,
+}, {
+	about:  "not before",
+	filter: audit.Filter{Before: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)},
+}}
+
+func TestFilterMatch(t *testing.T) {
+	c := qt.New(t)
+	e := audit.Event{
+		Time:  time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Type:  audit.Login,
+		Actor: "admin@candid",
+		User:  "bob",
+	}
+	for _, test := range filterMatchTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(test.filter.Match(e), qt.Equals, test.expect)
+		})
+	}
+}
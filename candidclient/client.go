@@ -7,6 +7,7 @@ import (
 	"context"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"gopkg.in/errgo.v1"
@@ -42,6 +43,10 @@ type Client struct {
 	permChecker *PermChecker
 
 	useUserID bool
+
+	// declaredAttrs holds the user attributes that the identity
+	// server is asked to declare.
+	declaredAttrs []string
 }
 
 var _ identchecker.IdentityClient = (*Client)(nil)
@@ -68,6 +73,15 @@ type NewParams struct {
 	// If UseUserID is true then the macaroons will use unique user
 	// ID to transfer identity information rather than usernames.
 	UseUserID bool
+
+	// DeclaredAttributes holds the user attributes (for example
+	// AttrGroups) that the identity server is asked to declare in
+	// discharge macaroons, as IdentityAttributeCaveats does. Only
+	// these attributes are taken from the declarations in a
+	// discharge macaroon, as any other declaration may have been
+	// added by the holder of the macaroon. It is not used if
+	// UseUserID is true.
+	DeclaredAttributes []string
 }
 
 // New returns a new client.
@@ -93,15 +107,21 @@ func New(p NewParams) (*Client, error) {
 	c.Client.Doer = p.Client
 	c.Client.UnmarshalError = httprequest.ErrorUnmarshaler(new(params.Error))
 	c.useUserID = p.UseUserID
+	c.declaredAttrs = p.DeclaredAttributes
 	return &c, nil
 }
 
 // IdentityFromContext implements identchecker.IdentityClient.IdentityFromContext
-// by returning caveats created by IdentityCaveats.
+// by returning caveats created by IdentityCaveats, or by
+// IdentityAttributeCaveats if the client was created with any
+// DeclaredAttributes.
 func (c *Client) IdentityFromContext(ctx context.Context) (identchecker.Identity, []checkers.Caveat, error) {
 	if c.useUserID {
 		return nil, IdentityUserIDCaveats(c.Client.BaseURL), nil
 	}
+	if len(c.declaredAttrs) > 0 {
+		return nil, IdentityAttributeCaveats(c.Client.BaseURL, c.declaredAttrs...), nil
+	}
 	return nil, IdentityCaveats(c.Client.BaseURL), nil
 }
 
@@ -121,9 +141,25 @@ func (c *Client) DeclaredIdentity(ctx context.Context, declared map[string]strin
 	return &usernameIdentity{
 		client:   c,
 		username: username,
+		declared: c.declaredAttributes(declared),
 	}, nil
 }
 
+// declaredAttributes returns the user attributes found in the given
+// declarations that the identity server was asked to declare.
+func (c *Client) declaredAttributes(declared map[string]string) map[string]string {
+	var attrs map[string]string
+	for _, name := range c.declaredAttrs {
+		if v, ok := declared[name]; ok {
+			if attrs == nil {
+				attrs = make(map[string]string)
+			}
+			attrs[name] = v
+		}
+	}
+	return attrs
+}
+
 func (c *Client) declaredUserIDIdentity(ctx context.Context, declared map[string]string) (identchecker.Identity, error) {
 	userid := declared["userid"]
 	if userid == "" {
@@ -203,6 +239,25 @@ func UserDeclaration(username string) checkers.Caveat {
 	return checkers.DeclaredCaveat("username", username)
 }
 
+// IdentityAttributeCaveats returns a slice containing a third party
+// "is-authenticated-user-with" caveat addressed to the identity server
+// at the given URL that will authenticate the user and declare the
+// given attributes (for example AttrEmail or AttrGroups) as well as
+// their user name. The identity returned by Client.DeclaredIdentity
+// implements AttributeIdentity, which gives access to the attributes
+// if the client was created with the same NewParams.DeclaredAttributes.
+func IdentityAttributeCaveats(url string, attrs ...string) []checkers.Caveat {
+	return []checkers.Caveat{
+		checkers.NeedDeclaredCaveat(
+			checkers.Caveat{
+				Location:  url,
+				Condition: "is-authenticated-user-with " + strings.Join(attrs, ","),
+			},
+			append([]string{"username"}, attrs...)...,
+		),
+	}
+}
+
 // IdentityUserIDCaveats returns a slice containing a third party
 // "is-authenticated-userid" caveat addressed to the identity server at
 // the given URL that will authenticate the user with discharged. The
@@ -226,4 +281,73 @@ func UserIDDeclaration(id string) checkers.Caveat {
 	return checkers.DeclaredCaveat("userid", id)
 }
 
+// IdentityProviderCaveats returns a slice containing a third party
+// "is-authenticated-user-in-idp" caveat addressed to the identity
+// server at the given URL that will only be discharged for a user that
+// has authenticated with the named identity provider. The user can be
+// determined by calling Client.DeclaredIdentity on the declarations
+// made by the discharge macaroon.
+func IdentityProviderCaveats(url, idp string) []checkers.Caveat {
+	return []checkers.Caveat{
+		checkers.NeedDeclaredCaveat(
+			checkers.Caveat{
+				Location:  url,
+				Condition: "is-authenticated-user-in-idp " + idp,
+			},
+			"username",
+		),
+	}
+}
+
+// RecentIdentityCaveats returns a slice containing a third party
+// "is-authenticated-user-within" caveat addressed to the identity
+// server at the given URL that will only be discharged for a user that
+// has interactively logged in within the given duration. If the user
+// has not, they will be required to log in again. The discharge
+// macaroon expires once the login is no longer within the duration. The
+// user can be determined by calling Client.DeclaredIdentity on the
+// declarations made by the discharge macaroon.
+func RecentIdentityCaveats(url string, within time.Duration) []checkers.Caveat {
+	return []checkers.Caveat{
+		checkers.NeedDeclaredCaveat(
+			checkers.Caveat{
+				Location:  url,
+				Condition: "is-authenticated-user-within " + within.String(),
+			},
+			"username",
+		),
+	}
+}
+
+// MemberOfCaveat returns a third party "is-member-of" caveat addressed
+// to the identity server at the given URL that will only be discharged
+// for a user that is a member of at least one of the given groups.
+func MemberOfCaveat(url string, groups ...string) checkers.Caveat {
+	return groupsCaveat(url, "is-member-of", groups)
+}
+
+// MemberOfAllCaveat returns a third party "is-member-of-all" caveat
+// addressed to the identity server at the given URL that will only be
+// discharged for a user that is a member of every one of the given
+// groups.
+func MemberOfAllCaveat(url string, groups ...string) checkers.Caveat {
+	return groupsCaveat(url, "is-member-of-all", groups)
+}
+
+// NotMemberOfCaveat returns a third party "is-not-member-of" caveat
+// addressed to the identity server at the given URL that will only be
+// discharged for a user that is not a member of any of the given
+// groups. The caveat is not discharged if the identity server cannot
+// determine all of the user's groups.
+func NotMemberOfCaveat(url string, groups ...string) checkers.Caveat {
+	return groupsCaveat(url, "is-not-member-of", groups)
+}
+
+func groupsCaveat(url, cond string, groups []string) checkers.Caveat {
+	return checkers.Caveat{
+		Location:  url,
+		Condition: cond + " " + strings.Join(groups, " "),
+	}
+}
+
 //go:generate httprequest-generate-client ../internal/v1 handler client
@@ -15,6 +15,22 @@ type client struct {
 	Client httprequest.Client
 }
 
+// ApproveElevation approves a pending elevation. Users may not approve
+// their own elevations.
+func (c *client) ApproveElevation(ctx context.Context, p *params.ApproveElevationRequest) (*params.Elevation, error) {
+	var r *params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// AuditEvents returns the events recorded in the audit log that match
+// the given request.
+func (c *client) AuditEvents(ctx context.Context, p *params.AuditEventsRequest) ([]params.AuditEvent, error) {
+	var r []params.AuditEvent
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // CreateAgent creates a new agent and returns the newly chosen username
 // for the agent.
 func (c *client) CreateAgent(ctx context.Context, p *params.CreateAgentRequest) (*params.CreateAgentResponse, error) {
@@ -23,6 +39,28 @@ func (c *client) CreateAgent(ctx context.Context, p *params.CreateAgentRequest)
 	return r, err
 }
 
+// CreateGroup creates a new group.
+func (c *client) CreateGroup(ctx context.Context, p *params.CreateGroupRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// CreateLocalUser creates a new user in a local identity provider.
+func (c *client) CreateLocalUser(ctx context.Context, p *params.CreateLocalUserRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// DeleteGroup deletes the given group. Any users that are members of
+// the group remain so.
+func (c *client) DeleteGroup(ctx context.Context, p *params.DeleteGroupRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// DeleteLocalUser removes a user from a local identity provider. The
+// user will no longer be able to log in.
+func (c *client) DeleteLocalUser(ctx context.Context, p *params.DeleteLocalUserRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
 // DeleteSSHKeys removes all of the ssh keys specified from the keys
 // stored for the given user. It is not an error to attempt to remove a
 // key that is not associated with the user.
@@ -30,6 +68,19 @@ func (c *client) DeleteSSHKeys(ctx context.Context, p *params.DeleteSSHKeysReque
 	return c.Client.Call(ctx, p, nil)
 }
 
+// DeleteUser deletes the given user. Any agents owned by the user are
+// deleted first so that no agent is left without an owner.
+func (c *client) DeleteUser(ctx context.Context, p *params.DeleteUserRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// DenyElevation refuses a pending elevation.
+func (c *client) DenyElevation(ctx context.Context, p *params.DenyElevationRequest) (*params.Elevation, error) {
+	var r *params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // DischargeTokenForUser allows an administrator to create a discharge
 // token for the specified user.
 func (c *client) DischargeTokenForUser(ctx context.Context, p *params.DischargeTokenForUserRequest) (params.DischargeTokenForUserResponse, error) {
@@ -38,6 +89,14 @@ func (c *client) DischargeTokenForUser(ctx context.Context, p *params.DischargeT
 	return r, err
 }
 
+// Elevations returns the elevations that match the given request, in
+// the order in which they were requested.
+func (c *client) Elevations(ctx context.Context, p *params.ElevationsRequest) ([]params.Elevation, error) {
+	var r []params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // GetSSHKeys returns any SSH keys stored for the given user.
 func (c *client) GetSSHKeys(ctx context.Context, p *params.SSHKeysRequest) (params.SSHKeysResponse, error) {
 	var r params.SSHKeysResponse
@@ -59,9 +118,40 @@ func (c *client) GetUserWithID(ctx context.Context, p *params.GetUserWithIDReque
 	return r, err
 }
 
+// Group returns the details of the given group.
+func (c *client) Group(ctx context.Context, p *params.GroupRequest) (*params.Group, error) {
+	var r *params.Group
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// GroupHistory returns the changes that have been made to the members
+// and member groups of the given group, in the order in which they were
+// made. The history is kept after the group has been deleted.
+func (c *client) GroupHistory(ctx context.Context, p *params.GroupHistoryRequest) ([]params.GroupChange, error) {
+	var r []params.GroupChange
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// ModifyGroupMembers adds users to, or removes users from, the given
+// group. This allows the owners of a group to manage its membership
+// without being able to change any other groups of the users.
+func (c *client) ModifyGroupMembers(ctx context.Context, p *params.ModifyGroupMembersRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// ModifyMemberGroups adds groups to, or removes groups from, the member
+// groups of the given group.
+func (c *client) ModifyMemberGroups(ctx context.Context, p *params.ModifyMemberGroupsRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
 // ModifyUserGroups updates the groups stored for the given user. Groups
 // can be either added or removed in a single query. It is an error to
-// try and both add and remove groups at the same time.
+// try and both add and remove groups at the same time. Groups that are
+// added may be given a time at which the user's membership expires,
+// groups added without an expiry time are kept until they are removed.
 func (c *client) ModifyUserGroups(ctx context.Context, p *params.ModifyUserGroupsRequest) error {
 	return c.Client.Call(ctx, p, nil)
 }
@@ -73,6 +163,13 @@ func (c *client) PutSSHKeys(ctx context.Context, p *params.PutSSHKeysRequest) er
 	return c.Client.Call(ctx, p, nil)
 }
 
+// QueryGroups lists all the groups stored in the identity server.
+func (c *client) QueryGroups(ctx context.Context, p *params.QueryGroupsRequest) ([]params.Group, error) {
+	var r []params.Group
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // QueryUsers filters the user database for users that match the given
 // request. If no filters are requested all usernames will be returned.
 func (c *client) QueryUsers(ctx context.Context, p *params.QueryUsersRequest) ([]string, error) {
@@ -81,6 +178,75 @@ func (c *client) QueryUsers(ctx context.Context, p *params.QueryUsersRequest) ([
 	return r, err
 }
 
+// ReaperReport returns the actions that the identity reaper would take
+// if it were run now, without performing any of them.
+func (c *client) ReaperReport(ctx context.Context, p *params.ReaperReportRequest) ([]params.ReaperEntry, error) {
+	var r []params.ReaperEntry
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// RequestElevation records a request from the authenticated user to be
+// treated as a member of a group for a limited time. The request has no
+// effect until it is approved.
+func (c *client) RequestElevation(ctx context.Context, p *params.RequestElevationRequest) (*params.Elevation, error) {
+	var r *params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// ResetUserTOTP removes any second factor authentication secret
+// enrolled by the given user. If the user's identity provider uses a
+// second factor they will be asked to enroll again the next time they
+// log in.
+func (c *client) ResetUserTOTP(ctx context.Context, p *params.ResetUserTOTPRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// RevokeElevation withdraws an approved elevation. The elevation stops
+// granting membership of its group immediately.
+func (c *client) RevokeElevation(ctx context.Context, p *params.RevokeElevationRequest) (*params.Elevation, error) {
+	var r *params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// RevokeUserTokens revokes all of the discharge macaroons and discharge
+// tokens that have been issued for the given user. The user will have
+// to log in again before any further discharges are made.
+func (c *client) RevokeUserTokens(ctx context.Context, p *params.RevokeUserTokensRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// RevokeUserTokensWithID revokes all of the discharge macaroons and
+// discharge tokens that have been issued for the user with the given
+// ID.
+func (c *client) RevokeUserTokensWithID(ctx context.Context, p *params.RevokeUserTokensWithIDRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// SetGroupDescription sets the description of the given group.
+func (c *client) SetGroupDescription(ctx context.Context, p *params.SetGroupDescriptionRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// SetGroupOwners replaces the owners of the given group.
+func (c *client) SetGroupOwners(ctx context.Context, p *params.SetGroupOwnersRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// SetLocalUserDisabled disables, or re-enables, a user in a local
+// identity provider. A disabled user cannot log in.
+func (c *client) SetLocalUserDisabled(ctx context.Context, p *params.SetLocalUserDisabledRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// SetLocalUserPassword sets the password of a user in a local identity
+// provider.
+func (c *client) SetLocalUserPassword(ctx context.Context, p *params.SetLocalUserPasswordRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
 // SetUserDeprecated creates or updates the user with the given username. If the
 // user already exists then any IDPGroups or SSHKeys specified in the
 // request will be ignored. See SetUserGroups, ModifyUserGroups,
@@ -110,6 +276,13 @@ func (c *client) SetUserGroups(ctx context.Context, p *params.SetUserGroupsReque
 	return c.Client.Call(ctx, p, nil)
 }
 
+// SetUserSuspended suspends, or unsuspends, the given user. While a user
+// is suspended neither they, nor any agent that they own, can be
+// authenticated.
+func (c *client) SetUserSuspended(ctx context.Context, p *params.SetUserSuspendedRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
 // User returns the user information for the request user.
 func (c *client) User(ctx context.Context, p *params.UserRequest) (*params.User, error) {
 	var r *params.User
@@ -117,6 +290,14 @@ func (c *client) User(ctx context.Context, p *params.UserRequest) (*params.User,
 	return r, err
 }
 
+// UserElevations returns the elevations requested by the given user, in
+// the order in which they were requested.
+func (c *client) UserElevations(ctx context.Context, p *params.UserElevationsRequest) ([]params.Elevation, error) {
+	var r []params.Elevation
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // UserExtraInfo returns any stored extra-info for the given user.
 func (c *client) UserExtraInfo(ctx context.Context, p *params.UserExtraInfoRequest) (map[string]interface{}, error) {
 	var r map[string]interface{}
@@ -132,6 +313,15 @@ func (c *client) UserExtraInfoItem(ctx context.Context, p *params.UserExtraInfoI
 	return r, err
 }
 
+// UserGroupHistory returns the changes that have been made to the group
+// memberships of the given user, in the order in which they were made.
+// The history is kept after the user has been deleted.
+func (c *client) UserGroupHistory(ctx context.Context, p *params.UserGroupHistoryRequest) ([]params.GroupChange, error) {
+	var r []params.GroupChange
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // UserGroups returns the list of groups associated with the requested
 // user.
 func (c *client) UserGroups(ctx context.Context, p *params.UserGroupsRequest) ([]string, error) {
@@ -164,6 +354,14 @@ func (c *client) VerifyToken(ctx context.Context, p *params.VerifyTokenRequest)
 	return r, err
 }
 
+// WebAuthnRegistration returns a URL at which the user can register a
+// WebAuthn authenticator.
+func (c *client) WebAuthnRegistration(ctx context.Context, p *params.WebAuthnRegistrationRequest) (*params.WebAuthnRegistrationResponse, error) {
+	var r *params.WebAuthnRegistrationResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // WhoAmI returns details of the authenticated user.
 func (c *client) WhoAmI(ctx context.Context, p *params.WhoAmIRequest) (params.WhoAmIResponse, error) {
 	var r params.WhoAmIResponse
@@ -8,8 +8,10 @@ import (
 	qt "github.com/frankban/quicktest"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	"gopkg.in/macaroon.v2"
 
 	"github.com/canonical/candid/candidclient"
 	"github.com/canonical/candid/candidtest"
@@ -94,3 +96,145 @@ func testIdentityClient(c *qt.C, candidClient identchecker.IdentityClient, bclie
 	sort.Strings(groups)
 	c.Assert(groups, qt.DeepEquals, expectGroups)
 }
+
+func TestDeclaredIdentityAttributes(t *testing.T) {
+	c := qt.New(t)
+	client, err := candidclient.New(candidclient.NewParams{
+		BaseURL: "https://candid.example.com",
+		Client:  httpbakery.NewClient(),
+		DeclaredAttributes: []string{
+			candidclient.AttrEmail,
+			candidclient.AttrFullName,
+			candidclient.AttrGroups,
+			candidclient.AttrIdentityProvider,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	ident, err := client.DeclaredIdentity(context.Background(), map[string]string{
+		"username": "bob@usso",
+		"email":    "bob@example.com",
+		"fullname": "Bob Robertson",
+		"groups":   "alice@usso charlie",
+		"idp":      "usso",
+	})
+	c.Assert(err, qt.IsNil)
+	aid := ident.(candidclient.AttributeIdentity)
+	for _, test := range []struct {
+		name  string
+		value string
+	}{
+		{candidclient.AttrEmail, "bob@example.com"},
+		{candidclient.AttrFullName, "Bob Robertson"},
+		{candidclient.AttrGroups, "alice@usso charlie"},
+		{candidclient.AttrIdentityProvider, "usso"},
+	} {
+		v, ok := aid.Attribute(test.name)
+		c.Assert(ok, qt.Equals, true)
+		c.Assert(v, qt.Equals, test.value)
+	}
+	groups, err := aid.Groups()
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"alice@usso", "charlie"})
+	for _, test := range []struct {
+		acl    []string
+		expect bool
+	}{
+		{[]string{"charlie"}, true},
+		{[]string{"bob@usso"}, true},
+		{[]string{"everyone@usso"}, true},
+		{[]string{"david", "alice@usso"}, true},
+		{[]string{"david"}, false},
+		{nil, false},
+	} {
+		ok, err := aid.Allow(context.Background(), test.acl)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.Equals, test.expect, qt.Commentf("acl %q", test.acl))
+	}
+
+	// The domain stripping client strips declared groups too.
+	sident, err := candidclient.StripDomain(client, "usso").DeclaredIdentity(context.Background(), map[string]string{
+		"username": "bob@usso",
+		"groups":   "alice@usso charlie",
+	})
+	c.Assert(err, qt.IsNil)
+	v, ok := sident.(candidclient.AttributeIdentity).Attribute(candidclient.AttrGroups)
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(v, qt.Equals, "alice charlie")
+	_, ok = sident.(candidclient.AttributeIdentity).Attribute(candidclient.AttrEmail)
+	c.Assert(ok, qt.Equals, false)
+}
+
+func TestDeclaredIdentityIgnoresUnrequestedAttributes(t *testing.T) {
+	c := qt.New(t)
+	client, err := candidclient.New(candidclient.NewParams{
+		BaseURL:            "https://candid.example.com",
+		Client:             httpbakery.NewClient(),
+		DeclaredAttributes: []string{candidclient.AttrEmail},
+	})
+	c.Assert(err, qt.IsNil)
+	_, caveats, err := client.IdentityFromContext(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Assert(caveats, qt.DeepEquals, candidclient.IdentityAttributeCaveats("https://candid.example.com", candidclient.AttrEmail))
+	ident, err := client.DeclaredIdentity(context.Background(), map[string]string{
+		"username": "bob",
+		"email":    "bob@example.com",
+		"groups":   "admin",
+	})
+	c.Assert(err, qt.IsNil)
+	aid := ident.(candidclient.AttributeIdentity)
+	v, ok := aid.Attribute(candidclient.AttrEmail)
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(v, qt.Equals, "bob@example.com")
+	_, ok = aid.Attribute(candidclient.AttrGroups)
+	c.Assert(ok, qt.Equals, false)
+	groups, err := aid.Groups()
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+	ok, err = aid.Allow(context.Background(), []string{"admin"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, false)
+}
+
+func TestForgedDeclaredGroups(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	srv := candidtest.NewServer()
+	srv.AddUser("bob", "alice")
+	srv.AddUser("admin")
+
+	kr := httpbakery.NewThirdPartyLocator(nil, nil)
+	kr.AllowInsecure()
+	b := identchecker.NewBakery(identchecker.BakeryParams{
+		Locator:        kr,
+		Key:            bakery.MustGenerateKey(),
+		IdentityClient: srv.CandidClient("bob"),
+	})
+	_, authErr := b.Checker.Auth().Allow(context.TODO(), identchecker.LoginOp)
+	derr := errgo.Cause(authErr).(*bakery.DischargeRequiredError)
+	m, err := b.Oven.NewMacaroon(context.TODO(), bakery.LatestVersion, derr.Caveats, derr.Ops...)
+	c.Assert(err, qt.IsNil)
+	client := srv.Client("bob")
+	ms, err := bakery.DischargeAll(context.TODO(), m, func(ctx context.Context, cav macaroon.Caveat, payload []byte) (*bakery.Macaroon, error) {
+		dm, err := client.AcquireDischarge(ctx, cav, payload)
+		if err != nil {
+			return nil, err
+		}
+		// The holder of the discharge macaroon adds their own
+		// declaration of the groups.
+		if err := dm.AddCaveat(ctx, checkers.DeclaredCaveat("groups", "admin"), nil, nil); err != nil {
+			return nil, err
+		}
+		return dm, nil
+	})
+	c.Assert(err, qt.IsNil)
+
+	authInfo, err := b.Checker.Auth(ms).Allow(context.TODO(), identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	user := authInfo.Identity.(candidclient.Identity)
+	ok, err := user.Allow(context.TODO(), []string{"admin"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, false)
+	groups, err := user.Groups()
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"alice"})
+}
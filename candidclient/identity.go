@@ -5,6 +5,7 @@ package candidclient
 
 import (
 	"context"
+	"strings"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
@@ -32,11 +33,41 @@ type Identity interface {
 	Groups() ([]string, error)
 }
 
-var _ Identity = (*usernameIdentity)(nil)
+// The following constants name the user attributes that may be
+// declared in a discharge macaroon in addition to the user name (see
+// IdentityAttributeCaveats).
+const (
+	AttrEmail            = "email"
+	AttrFullName         = "fullname"
+	AttrGroups           = "groups"
+	AttrIdentityProvider = "idp"
+)
+
+// AttributeIdentity is implemented by the identities returned from
+// Client.DeclaredIdentity. It gives access to the user attributes that
+// were declared in the discharge macaroon, without contacting the
+// identity manager. Only the attributes named in
+// NewParams.DeclaredAttributes are available.
+type AttributeIdentity interface {
+	Identity
+
+	// Attribute returns the declared value of the given attribute,
+	// and reports whether it was declared.
+	Attribute(name string) (string, bool)
+}
+
+var _ AttributeIdentity = (*usernameIdentity)(nil)
 
 type usernameIdentity struct {
 	client   *Client
 	username string
+	declared map[string]string
+}
+
+// Attribute implements AttributeIdentity.Attribute.
+func (id *usernameIdentity) Attribute(name string) (string, bool) {
+	v, ok := id.declared[name]
+	return v, ok
 }
 
 // Username implements Identity.Username.
@@ -44,8 +75,13 @@ func (id *usernameIdentity) Username() (string, error) {
 	return id.username, nil
 }
 
-// Groups implements Identity.Groups.
+// Groups implements Identity.Groups. If the client asked for the groups
+// to be declared in the discharge macaroon then they are returned
+// without contacting the identity manager.
 func (id *usernameIdentity) Groups() ([]string, error) {
+	if groups, ok := id.declared[AttrGroups]; ok {
+		return strings.Fields(groups), nil
+	}
 	if id.client.permChecker != nil {
 		return id.client.permChecker.cache.Groups(id.username)
 	}
@@ -54,6 +90,19 @@ func (id *usernameIdentity) Groups() ([]string, error) {
 
 // Allow implements Identity.Allow.
 func (id *usernameIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	if groups, ok := id.declared[AttrGroups]; ok {
+		if ok, isTrivial := trivialAllow(id.username, acl); isTrivial {
+			return ok, nil
+		}
+		for _, g := range strings.Fields(groups) {
+			for _, a := range acl {
+				if g == a {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
 	if id.client.permChecker != nil {
 		return id.client.permChecker.Allow(id.username, acl)
 	}
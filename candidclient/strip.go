@@ -52,7 +52,7 @@ func (c *domainStrippingClient) IdentityFromContext(ctx context.Context) (identc
 	return c.c.IdentityFromContext(ctx)
 }
 
-var _ Identity = (*domainStrippingIdentity)(nil)
+var _ AttributeIdentity = (*domainStrippingIdentity)(nil)
 
 type domainStrippingIdentity struct {
 	domain string
@@ -80,6 +80,24 @@ func (u *domainStrippingIdentity) Groups() ([]string, error) {
 	return groups, nil
 }
 
+// Attribute implements AttributeIdentity.Attribute. Any declared groups
+// have the domain stripped.
+func (u *domainStrippingIdentity) Attribute(name string) (string, bool) {
+	aid, ok := u.Identity.(AttributeIdentity)
+	if !ok {
+		return "", false
+	}
+	v, ok := aid.Attribute(name)
+	if !ok || name != AttrGroups {
+		return v, ok
+	}
+	groups := strings.Fields(v)
+	for i, g := range groups {
+		groups[i] = strings.TrimSuffix(g, u.domain)
+	}
+	return strings.Join(groups, " "), true
+}
+
 // Allow implements ACLUser.Allow by adding stripped
 // domain to all names in acl that don't have a domain
 // before calling the underlying Allow method.
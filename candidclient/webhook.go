@@ -0,0 +1,116 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE.client file for details.
+
+package candidclient
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/candid/params"
+)
+
+const (
+	// defaultWebhookMaxAge is the default maximum age of a webhook
+	// request accepted by a webhook handler.
+	defaultWebhookMaxAge = 5 * time.Minute
+
+	// maxWebhookBodySize is the largest webhook request body that
+	// will be read.
+	maxWebhookBodySize = 1 << 20
+)
+
+// A CacheEvicter holds cached information about users. Both GroupCache
+// and PermChecker implement CacheEvicter.
+type CacheEvicter interface {
+	// CacheEvict removes any cached information about the given
+	// user.
+	CacheEvict(username string)
+}
+
+// WebhookHandlerParams holds the parameters for NewWebhookHandler.
+type WebhookHandlerParams struct {
+	// Secret holds the secret shared with the identity server that
+	// is used to sign the events.
+	Secret string
+
+	// Caches holds the caches from which users are evicted when an
+	// event is received for them.
+	Caches []CacheEvicter
+
+	// MaxAge holds the maximum age of a request that will be
+	// accepted. Older requests are rejected to prevent them being
+	// replayed. If this is zero a default of 5 minutes is used.
+	MaxAge time.Duration
+
+	// Handle, if not nil, is called with each received event after
+	// the user has been evicted from the caches.
+	Handle func(params.IdentityEvent)
+}
+
+// NewWebhookHandler returns an http.Handler that receives the identity
+// change events sent by the identity server to a webhook. The
+// signature of each request is checked and the changed user is evicted
+// from the given caches, so that subsequent checks see the change
+// without waiting for the cached values to expire.
+func NewWebhookHandler(p WebhookHandlerParams) http.Handler {
+	if p.MaxAge == 0 {
+		p.MaxAge = defaultWebhookMaxAge
+	}
+	return &webhookHandler{
+		p:   p,
+		now: time.Now,
+	}
+}
+
+type webhookHandler struct {
+	p   WebhookHandlerParams
+	now func() time.Time
+}
+
+// ServeHTTP implements http.Handler.
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, "cannot read request body", http.StatusBadRequest)
+		return
+	}
+	timestamp := req.Header.Get(params.WebhookTimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid timestamp", http.StatusBadRequest)
+		return
+	}
+	if age := h.now().Sub(time.Unix(sent, 0)); age > h.p.MaxAge || age < -h.p.MaxAge {
+		http.Error(w, "request too old", http.StatusUnauthorized)
+		return
+	}
+	sig := params.WebhookSignature(h.p.Secret, timestamp, body)
+	if !hmac.Equal([]byte(sig), []byte(req.Header.Get(params.WebhookSignatureHeader))) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var ev params.IdentityEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	if ev.User != "" {
+		for _, c := range h.p.Caches {
+			c.CacheEvict(string(ev.User))
+		}
+	}
+	if h.p.Handle != nil {
+		h.p.Handle(ev)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
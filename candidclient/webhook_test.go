@@ -0,0 +1,148 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE.client file for details.
+
+package candidclient_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/params"
+)
+
+func TestWebhookHandlerEvictsUser(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+	srv := candidtest.NewServer()
+	srv.AddUser("server-user", candidtest.GroupListGroup)
+	srv.AddUser("alice", "g1")
+
+	client, err := candidclient.New(candidclient.NewParams{
+		BaseURL: srv.URL.String(),
+		Client:  srv.Client("server-user"),
+	})
+	c.Assert(err, qt.IsNil)
+	cache := candidclient.NewGroupCache(client, time.Hour)
+
+	groups, err := cache.Groups("alice")
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"g1"})
+
+	// The change is not seen while the groups are cached.
+	srv.AddUser("alice", "g2")
+	groups, err = cache.Groups("alice")
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"g1"})
+
+	var events []params.IdentityEvent
+	h := candidclient.NewWebhookHandler(candidclient.WebhookHandlerParams{
+		Secret: "s3cret",
+		Caches: []candidclient.CacheEvicter{cache},
+		Handle: func(ev params.IdentityEvent) {
+			events = append(events, ev)
+		},
+	})
+	body := `{"id":"1","type":"groups-changed","user":"alice","groups":["g1","g2"]}`
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newWebhookRequest("s3cret", time.Now(), body))
+	c.Assert(rr.Code, qt.Equals, http.StatusNoContent)
+	c.Assert(events, qt.DeepEquals, []params.IdentityEvent{{
+		ID:     "1",
+		Type:   params.IdentityGroupsChanged,
+		User:   "alice",
+		Groups: []string{"g1", "g2"},
+	}})
+
+	groups, err = cache.Groups("alice")
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"g1", "g2"})
+}
+
+var webhookHandlerErrorTests = []struct {
+	about        string
+	method       string
+	secret       string
+	sent         time.Time
+	body         string
+	expectStatus int
+	expectBody   string
+}{{
+	about:        "bad method",
+	method:       "GET",
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody:   "method not allowed\n",
+}, {
+	about:        "bad signature",
+	secret:       "wrong",
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   "invalid signature\n",
+}, {
+	about:        "too old",
+	sent:         time.Now().Add(-time.Hour),
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   "request too old\n",
+}, {
+	about:        "invalid event",
+	body:         "{",
+	expectStatus: http.StatusBadRequest,
+	expectBody:   "invalid event\n",
+}}
+
+func TestWebhookHandlerErrors(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	evicted := make(evicter)
+	h := candidclient.NewWebhookHandler(candidclient.WebhookHandlerParams{
+		Secret: "s3cret",
+		Caches: []candidclient.CacheEvicter{evicted},
+	})
+	for _, test := range webhookHandlerErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			if test.secret == "" {
+				test.secret = "s3cret"
+			}
+			if test.sent.IsZero() {
+				test.sent = time.Now()
+			}
+			if test.body == "" {
+				test.body = `{"id":"1","type":"identity-deleted","user":"alice"}`
+			}
+			req := newWebhookRequest(test.secret, test.sent, test.body)
+			if test.method != "" {
+				req.Method = test.method
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			c.Assert(rr.Code, qt.Equals, test.expectStatus)
+			c.Assert(rr.Body.String(), qt.Equals, test.expectBody)
+			c.Assert(evicted, qt.HasLen, 0)
+		})
+	}
+}
+
+// evicter is a CacheEvicter that records the evicted users.
+type evicter map[string]bool
+
+func (e evicter) CacheEvict(username string) {
+	e[username] = true
+}
+
+// newWebhookRequest creates a webhook request with the given body, sent
+// at the given time and signed with the given secret.
+func newWebhookRequest(secret string, sent time.Time, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/candid-events", strings.NewReader(body))
+	timestamp := strconv.FormatInt(sent.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(params.WebhookTimestampHeader, timestamp)
+	req.Header.Set(params.WebhookSignatureHeader, params.WebhookSignature(secret, timestamp, []byte(body)))
+	return req
+}
@@ -131,6 +131,15 @@ func AddIdentity(ctx context.Context, st store.Store, identity *store.Identity)
 	if identity.Owner != "" {
 		update[store.Owner] = store.Set
 	}
+	if !identity.TokensRevoked.IsZero() {
+		update[store.TokensRevoked] = store.Set
+	}
+	if identity.Suspended {
+		update[store.Suspended] = store.Set
+	}
+	if len(identity.GroupExpiry) > 0 {
+		update[store.GroupExpiry] = store.Set
+	}
 	if err := st.UpdateIdentity(ctx, identity, update); err != nil {
 		panic(err)
 	}
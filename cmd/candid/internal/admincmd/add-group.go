@@ -5,8 +5,10 @@ package admincmd
 
 import (
 	"context"
+	"time"
 
 	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
 	"gopkg.in/errgo.v1"
 
 	"github.com/canonical/candid/params"
@@ -15,7 +17,9 @@ import (
 type addGroupCommand struct {
 	userCommand
 
-	groups []string
+	groups    []string
+	until     string
+	untilTime *time.Time
 }
 
 func newAddGroupCommand(cc *candidCommand) cmd.Command {
@@ -34,6 +38,15 @@ To add the group-1 and group-2 groups to the user bob:
 To add the group-1 and group-2 groups to the user with the email
 address bob@example.com:
     candid add-group -e bob@example.com group-1 group-2
+
+To add the ops-oncall group to the user bob until the start of
+December 2026:
+    candid add-group -u bob --until 2026-12-01 ops-oncall
+
+The --until flag accepts either a date, which is taken to be midnight
+UTC, or an RFC 3339 time. Once that time has passed the user is no
+longer a member of the groups. Adding a group without --until makes
+the membership permanent.
 `
 
 func (c *addGroupCommand) Info() *cmd.Info {
@@ -45,8 +58,21 @@ func (c *addGroupCommand) Info() *cmd.Info {
 	}
 }
 
+func (c *addGroupCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.userCommand.SetFlags(f)
+
+	f.StringVar(&c.until, "until", "", "time at which the group membership expires")
+}
+
 func (c *addGroupCommand) Init(args []string) error {
 	c.groups = args
+	if c.until != "" {
+		t, err := parseTime(c.until)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		c.untilTime = &t
+	}
 	return errgo.Mask(c.userCommand.Init(nil))
 }
 
@@ -64,8 +90,22 @@ func (c *addGroupCommand) Run(ctxt *cmd.Context) error {
 	err = client.ModifyUserGroups(ctx, &params.ModifyUserGroupsRequest{
 		Username: username,
 		Groups: params.ModifyGroups{
-			Add: c.groups,
+			Add:   c.groups,
+			Until: c.untilTime,
 		},
 	})
 	return errgo.Mask(err)
 }
+
+// parseTime parses a time given on the command line, which may be
+// either a date or an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errgo.Newf("invalid time %q, expected a date (YYYY-MM-DD) or an RFC 3339 time", s)
+	}
+	return t, nil
+}
@@ -6,6 +6,7 @@ package admincmd_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
@@ -41,6 +42,47 @@ func (s *addGroupSuite) TestAddGroup(c *qt.C) {
 	c.Assert(identity.Groups, qt.DeepEquals, []string{"test1", "test2"})
 }
 
+func (s *addGroupSuite) TestAddGroupUntil(c *qt.C) {
+	ctx := context.Background()
+	candidtest.AddIdentity(ctx, s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	})
+	s.fixture.CheckNoOutput(c, "add-group", "-a", "admin.agent", "-u", "bob", "--until", "2099-12-01", "ops-oncall")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+	}
+	err := s.fixture.store.Identity(ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.Groups, qt.DeepEquals, []string{"ops-oncall"})
+	c.Assert(identity.GroupExpiry, qt.HasLen, 1)
+	c.Assert(identity.GroupExpiry["ops-oncall"].Equal(time.Date(2099, 12, 1, 0, 0, 0, 0, time.UTC)), qt.Equals, true)
+}
+
+func (s *addGroupSuite) TestAddGroupUntilRFC3339(c *qt.C) {
+	ctx := context.Background()
+	candidtest.AddIdentity(ctx, s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	})
+	s.fixture.CheckNoOutput(c, "add-group", "-a", "admin.agent", "-u", "bob", "--until", "2099-12-01T12:30:00+01:00", "ops-oncall")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+	}
+	err := s.fixture.store.Identity(ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.GroupExpiry["ops-oncall"].Equal(time.Date(2099, 12, 1, 11, 30, 0, 0, time.UTC)), qt.Equals, true)
+}
+
+func (s *addGroupSuite) TestAddGroupInvalidUntil(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`invalid time "next week", expected a date \(YYYY-MM-DD\) or an RFC 3339 time`,
+		"add-group", "-a", "admin.agent", "-u", "bob", "--until", "next week", "ops-oncall",
+	)
+}
+
 func (s *addGroupSuite) TestAddGroupForEmail(c *qt.C) {
 	ctx := context.Background()
 	candidtest.AddIdentity(ctx, s.fixture.store, &store.Identity{
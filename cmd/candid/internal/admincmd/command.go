@@ -68,9 +68,23 @@ func New() cmd.Command {
 	supercmd.Register(newACLCommand(c))
 	supercmd.Register(newAddGroupCommand(c))
 	supercmd.Register(newCreateAgentCommand(c))
+	supercmd.Register(newCreateLocalUserCommand(c))
+	supercmd.Register(newDeleteAgentCommand(c))
+	supercmd.Register(newDeleteLocalUserCommand(c))
+	supercmd.Register(newDeleteUserCommand(c))
+	supercmd.Register(newDisableLocalUserCommand(c))
+	supercmd.Register(newEnableLocalUserCommand(c))
 	supercmd.Register(newFindCommand(c))
+	supercmd.Register(newGroupCommand(c))
+	supercmd.Register(newHashPasswordCommand(c))
+	supercmd.Register(newRegisterWebAuthnCommand(c))
 	supercmd.Register(newRemoveGroupCommand(c))
+	supercmd.Register(newResetPasswordCommand(c))
+	supercmd.Register(newResetTOTPCommand(c))
+	supercmd.Register(newRevokeCommand(c))
 	supercmd.Register(newShowCommand(c))
+	supercmd.Register(newSuspendCommand(c))
+	supercmd.Register(newUnsuspendCommand(c))
 	return supercmd
 }
 
@@ -23,7 +23,9 @@ import (
 	"github.com/canonical/candid/candidtest"
 	"github.com/canonical/candid/cmd/candid/internal/admincmd"
 	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/local"
 	"github.com/canonical/candid/idp/static"
+	"github.com/canonical/candid/idp/webauthn"
 	internalcandidtest "github.com/canonical/candid/internal/candidtest"
 	"github.com/canonical/candid/store"
 	"github.com/canonical/candid/store/memstore"
@@ -36,6 +38,7 @@ type fixture struct {
 
 	aclStore aclstore.ACLStore
 	store    store.Store
+	localIDP *local.IdentityProvider
 	server   *httptest.Server
 }
 
@@ -47,6 +50,9 @@ func newFixture(c *qt.C) *fixture {
 
 	f.aclStore = aclstore.NewACLStore(memsimplekv.NewStore())
 	f.store = memstore.NewStore()
+	f.localIDP = local.NewIdentityProvider(local.Params{
+		Name: "local",
+	})
 
 	t, ok := c.TB.(candidtest.Testing)
 	if !ok {
@@ -61,6 +67,10 @@ func newFixture(c *qt.C) *fixture {
 			static.NewIdentityProvider(static.Params{
 				Name: "static",
 			}),
+			f.localIDP,
+			webauthn.NewIdentityProvider(webauthn.Params{
+				Name: "webauthn",
+			}),
 		},
 	})
 	c.Assert(err, qt.IsNil)
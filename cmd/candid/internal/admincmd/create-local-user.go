@@ -0,0 +1,93 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type createLocalUserCommand struct {
+	*candidCommand
+
+	username string
+	idp      string
+	name     string
+	email    string
+	groups   []string
+}
+
+func newCreateLocalUserCommand(cc *candidCommand) cmd.Command {
+	c := &createLocalUserCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var createLocalUserDoc = `
+The create-local-user command creates a new user in a local identity
+provider. The user will be made a member of any of the specified groups.
+
+The password for the new user is read from standard input. If standard
+input is a terminal the password will be prompted for.
+
+If more than one local identity provider is configured in the identity
+server then the one to use must be specified with the --idp flag.
+
+To create the user bob, in the groups group-1 and group-2:
+    candid create-local-user --name "Bob Smith" --email bob@example.com bob group-1 group-2
+`
+
+func (c *createLocalUserCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "create-local-user",
+		Args:    "username [group...]",
+		Purpose: "create a user in a local identity provider",
+		Doc:     createLocalUserDoc,
+	}
+}
+
+func (c *createLocalUserCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+
+	f.StringVar(&c.idp, "idp", "", "name of the local identity provider")
+	f.StringVar(&c.name, "name", "", "full name of the user")
+	f.StringVar(&c.email, "email", "", "email address of the user")
+}
+
+func (c *createLocalUserCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errgo.New("no username specified")
+	}
+	c.username, c.groups = args[0], args[1:]
+	return errgo.Mask(c.candidCommand.Init(nil))
+}
+
+func (c *createLocalUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	pw, err := readPassword(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.CreateLocalUser(ctx, &params.CreateLocalUserRequest{
+		Username: params.Username(c.username),
+		Body: params.CreateLocalUserBody{
+			IDP:      c.idp,
+			FullName: c.name,
+			Email:    c.email,
+			Groups:   c.groups,
+			Password: pw,
+		},
+	})
+	return errgo.Mask(err)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type deleteLocalUserCommand struct {
+	userCommand
+}
+
+func newDeleteLocalUserCommand(cc *candidCommand) cmd.Command {
+	c := &deleteLocalUserCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var deleteLocalUserDoc = `
+The delete-local-user command removes a user from a local identity
+provider. The user will no longer be able to log in.
+
+    candid delete-local-user -u bob
+`
+
+func (c *deleteLocalUserCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "delete-local-user",
+		Purpose: "remove a user from a local identity provider",
+		Doc:     deleteLocalUserDoc,
+	}
+}
+
+func (c *deleteLocalUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.DeleteLocalUser(ctx, &params.DeleteLocalUserRequest{
+		Username: username,
+	})
+	return errgo.Mask(err)
+}
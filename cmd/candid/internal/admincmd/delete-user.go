@@ -0,0 +1,116 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type deleteUserCommand struct {
+	userCommand
+}
+
+func newDeleteUserCommand(cc *candidCommand) cmd.Command {
+	c := &deleteUserCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var deleteUserDoc = `
+The delete-user command removes a user, and any agents owned by the
+user, from candid. If the user logs in again a new identity will be
+created for them with none of their previous groups or other details.
+Tokens issued to the deleted user are not accepted for the new identity.
+
+    candid delete-user -u bob
+`
+
+func (c *deleteUserCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "delete-user",
+		Purpose: "delete a user",
+		Doc:     deleteUserDoc,
+	}
+}
+
+func (c *deleteUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.DeleteUser(ctx, &params.DeleteUserRequest{
+		Username: username,
+	})
+	return errgo.Mask(err)
+}
+
+type deleteAgentCommand struct {
+	*candidCommand
+	agents []string
+}
+
+func newDeleteAgentCommand(cc *candidCommand) cmd.Command {
+	return &deleteAgentCommand{
+		candidCommand: cc,
+	}
+}
+
+var deleteAgentDoc = `
+The delete-agent command removes the specified agents from candid. Any
+agents owned by a deleted parent agent are also removed.
+
+    candid delete-agent a-1234abcd@candid a-5678ef01@candid
+`
+
+func (c *deleteAgentCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "delete-agent",
+		Args:    "agent [agent...]",
+		Purpose: "delete agents",
+		Doc:     deleteAgentDoc,
+	}
+}
+
+func (c *deleteAgentCommand) Init(args []string) error {
+	if len(args) == 0 {
+		return errgo.New("no agent specified")
+	}
+	for _, a := range args {
+		if !strings.HasSuffix(a, "@candid") {
+			return errgo.Newf("%q is not an agent username", a)
+		}
+	}
+	c.agents = args
+	return errgo.Mask(c.candidCommand.Init(nil))
+}
+
+func (c *deleteAgentCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, a := range c.agents {
+		err := client.DeleteUser(ctx, &params.DeleteUserRequest{
+			Username: params.Username(a),
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
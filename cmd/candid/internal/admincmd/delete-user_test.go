@@ -0,0 +1,93 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+type deleteUserSuite struct {
+	fixture *fixture
+}
+
+func TestDeleteUser(t *testing.T) {
+	qtsuite.Run(qt.New(t), &deleteUserSuite{})
+}
+
+func (s *deleteUserSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	})
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "a-1"),
+		Username:   "a-1@candid",
+		Owner:      store.MakeProviderIdentity("test", "bob"),
+	})
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "a-2"),
+		Username:   "a-2@candid",
+	})
+}
+
+func (s *deleteUserSuite) TestDeleteUser(c *qt.C) {
+	s.fixture.CheckNoOutput(c, "delete-user", "-a", "admin.agent", "-u", "bob")
+	c.Assert(s.exists(c, "bob"), qt.Equals, false)
+	c.Assert(s.exists(c, "a-1@candid"), qt.Equals, false)
+	c.Assert(s.exists(c, "a-2@candid"), qt.Equals, true)
+}
+
+func (s *deleteUserSuite) TestDeleteUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Delete http://.*/v1/u/alice: user alice not found`,
+		"delete-user", "-a", "admin.agent", "-u", "alice",
+	)
+}
+
+func (s *deleteUserSuite) TestDeleteUserNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"delete-user", "-a", "admin.agent",
+	)
+}
+
+func (s *deleteUserSuite) TestDeleteAgent(c *qt.C) {
+	s.fixture.CheckNoOutput(c, "delete-agent", "-a", "admin.agent", "a-1@candid", "a-2@candid")
+	c.Assert(s.exists(c, "bob"), qt.Equals, true)
+	c.Assert(s.exists(c, "a-1@candid"), qt.Equals, false)
+	c.Assert(s.exists(c, "a-2@candid"), qt.Equals, false)
+}
+
+func (s *deleteUserSuite) TestDeleteAgentNoAgent(c *qt.C) {
+	s.fixture.CheckError(c, 2, `no agent specified`, "delete-agent", "-a", "admin.agent")
+}
+
+func (s *deleteUserSuite) TestDeleteAgentNotAgent(c *qt.C) {
+	s.fixture.CheckError(c, 2, `"bob" is not an agent username`, "delete-agent", "-a", "admin.agent", "bob")
+	c.Assert(s.exists(c, "bob"), qt.Equals, true)
+}
+
+func (s *deleteUserSuite) exists(c *qt.C, username string) bool {
+	err := s.fixture.store.Identity(context.Background(), &store.Identity{
+		Username: username,
+	})
+	if errgo.Cause(err) == store.ErrNotFound {
+		return false
+	}
+	c.Assert(err, qt.IsNil)
+	return true
+}
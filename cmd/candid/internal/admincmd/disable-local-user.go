@@ -0,0 +1,83 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type disableLocalUserCommand struct {
+	userCommand
+
+	// enable is set when the command re-enables the user.
+	enable bool
+}
+
+func newDisableLocalUserCommand(cc *candidCommand) cmd.Command {
+	c := &disableLocalUserCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+func newEnableLocalUserCommand(cc *candidCommand) cmd.Command {
+	c := &disableLocalUserCommand{enable: true}
+	c.candidCommand = cc
+	return c
+}
+
+var disableLocalUserDoc = `
+The disable-local-user command stops a user in a local identity
+provider from logging in. The user can be re-enabled with the
+enable-local-user command.
+
+    candid disable-local-user -u bob
+`
+
+var enableLocalUserDoc = `
+The enable-local-user command allows a user in a local identity
+provider that was disabled with the disable-local-user command to log
+in again.
+
+    candid enable-local-user -u bob
+`
+
+func (c *disableLocalUserCommand) Info() *cmd.Info {
+	if c.enable {
+		return &cmd.Info{
+			Name:    "enable-local-user",
+			Purpose: "enable a local user",
+			Doc:     enableLocalUserDoc,
+		}
+	}
+	return &cmd.Info{
+		Name:    "disable-local-user",
+		Purpose: "disable a local user",
+		Doc:     disableLocalUserDoc,
+	}
+}
+
+func (c *disableLocalUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.SetLocalUserDisabled(ctx, &params.SetLocalUserDisabledRequest{
+		Username: username,
+		Body: params.SetLocalUserDisabledBody{
+			Disabled: !c.enable,
+		},
+	})
+	return errgo.Mask(err)
+}
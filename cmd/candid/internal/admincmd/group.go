@@ -0,0 +1,506 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+var groupCmdDoc = `
+The group command is used to manage groups. A group has a description,
+a set of owners and a set of member groups. The owners of a group, which
+may be users or groups, can change its description, its member groups
+and the users that are members of it. Any member of a member group is
+also a member of the group.
+`
+
+func newGroupCommand(cc *candidCommand) cmd.Command {
+	supercmd := cmd.NewSuperCommand(cmd.SuperCommandParams{
+		Name:    "group",
+		Doc:     groupCmdDoc,
+		Purpose: "manage candid groups",
+	})
+
+	supercmd.Register(&groupAddMemberGroupCommand{candidCommand: cc})
+	supercmd.Register(&groupAddUserCommand{candidCommand: cc})
+	supercmd.Register(&groupCreateCommand{candidCommand: cc})
+	supercmd.Register(&groupDeleteCommand{candidCommand: cc})
+	supercmd.Register(&groupListCommand{candidCommand: cc})
+	supercmd.Register(&groupAddMemberGroupCommand{candidCommand: cc, remove: true})
+	supercmd.Register(&groupAddUserCommand{candidCommand: cc, remove: true})
+	supercmd.Register(&groupSetDescriptionCommand{candidCommand: cc})
+	supercmd.Register(&groupSetOwnersCommand{candidCommand: cc})
+	supercmd.Register(&groupShowCommand{candidCommand: cc})
+
+	return supercmd
+}
+
+// group represents a group in the system.
+type group struct {
+	Name         string   `json:"name" yaml:"name"`
+	Description  string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Owners       []string `json:"owners" yaml:"owners"`
+	MemberGroups []string `json:"member-groups" yaml:"member-groups"`
+}
+
+var groupListDoc = `
+The list command lists the names of all groups.
+
+    candid group list
+`
+
+type groupListCommand struct {
+	*candidCommand
+	out cmd.Output
+}
+
+func (c *groupListCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "list",
+		Purpose: "list groups",
+		Doc:     groupListDoc,
+	}
+}
+
+func (c *groupListCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+	c.out.AddFlags(f, "smart", cmd.DefaultFormatters)
+}
+
+func (c *groupListCommand) Init(args []string) error {
+	return errgo.Mask(c.candidCommand.Init(args))
+}
+
+func (c *groupListCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	groups, err := client.QueryGroups(context.Background(), &params.QueryGroupsRequest{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.Name
+	}
+	return errgo.Mask(c.out.Write(ctxt, names))
+}
+
+var groupShowDoc = `
+The show command shows the details of the specified group.
+
+    candid group show staff
+`
+
+type groupShowCommand struct {
+	*candidCommand
+	name string
+	out  cmd.Output
+}
+
+func (c *groupShowCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "show",
+		Args:    "<group>",
+		Purpose: "show group details",
+		Doc:     groupShowDoc,
+	}
+}
+
+func (c *groupShowCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+	c.out.AddFlags(f, "smart", cmd.DefaultFormatters)
+}
+
+func (c *groupShowCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 1 {
+		return errgo.New("group name required")
+	}
+	if len(args) > 1 {
+		return errgo.New("only one group may be specified")
+	}
+	c.name = args[0]
+	return nil
+}
+
+func (c *groupShowCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	g, err := client.Group(context.Background(), &params.GroupRequest{
+		Name: c.name,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	grp := group{
+		Name:         g.Name,
+		Description:  g.Description,
+		Owners:       []string{},
+		MemberGroups: []string{},
+	}
+	if len(g.Owners) > 0 {
+		grp.Owners = g.Owners
+	}
+	if len(g.MemberGroups) > 0 {
+		grp.MemberGroups = g.MemberGroups
+	}
+	return errgo.Mask(c.out.Write(ctxt, grp))
+}
+
+var groupCreateDoc = `
+The create command creates a new group. Any additional arguments are
+the names of the member groups of the new group. The description of
+the group can be set with the set-description command.
+
+To create the staff group, owned by alice, that contains all the
+members of the engineering and sales groups:
+
+    candid group create --owner alice staff engineering sales
+`
+
+type groupCreateCommand struct {
+	*candidCommand
+	owners       []string
+	name         string
+	memberGroups []string
+}
+
+func (c *groupCreateCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "create",
+		Args:    "<group> [<member-group>...]",
+		Purpose: "create a group",
+		Doc:     groupCreateDoc,
+	}
+}
+
+func (c *groupCreateCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+	f.Var(cmd.NewAppendStringsValue(&c.owners), "owner", "owner of the group, may be specified more than once")
+}
+
+func (c *groupCreateCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 1 {
+		return errgo.New("group name required")
+	}
+	c.name = args[0]
+	c.memberGroups = args[1:]
+	return nil
+}
+
+func (c *groupCreateCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.CreateGroup(context.Background(), &params.CreateGroupRequest{
+		Group: params.Group{
+			Name:         c.name,
+			Owners:       c.owners,
+			MemberGroups: c.memberGroups,
+		},
+	}))
+}
+
+var groupDeleteDoc = `
+The delete command deletes the specified group. Users that are members
+of the group are not changed.
+
+    candid group delete staff
+`
+
+type groupDeleteCommand struct {
+	*candidCommand
+	name string
+}
+
+func (c *groupDeleteCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "delete",
+		Args:    "<group>",
+		Purpose: "delete a group",
+		Doc:     groupDeleteDoc,
+	}
+}
+
+func (c *groupDeleteCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 1 {
+		return errgo.New("group name required")
+	}
+	if len(args) > 1 {
+		return errgo.New("only one group may be specified")
+	}
+	c.name = args[0]
+	return nil
+}
+
+func (c *groupDeleteCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.DeleteGroup(context.Background(), &params.DeleteGroupRequest{
+		Name: c.name,
+	}))
+}
+
+var groupSetDescriptionDoc = `
+The set-description command sets the description of the specified
+group.
+
+    candid group set-description staff "All staff"
+`
+
+type groupSetDescriptionCommand struct {
+	*candidCommand
+	name        string
+	description string
+}
+
+func (c *groupSetDescriptionCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "set-description",
+		Args:    "<group> <description>",
+		Purpose: "set the description of a group",
+		Doc:     groupSetDescriptionDoc,
+	}
+}
+
+func (c *groupSetDescriptionCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) != 2 {
+		return errgo.New("group name and description required")
+	}
+	c.name = args[0]
+	c.description = args[1]
+	return nil
+}
+
+func (c *groupSetDescriptionCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.SetGroupDescription(context.Background(), &params.SetGroupDescriptionRequest{
+		Name: c.name,
+		Body: params.GroupDescription{
+			Description: c.description,
+		},
+	}))
+}
+
+var groupSetOwnersDoc = `
+The set-owners command replaces the owners of the specified group. If
+no owners are specified then the group will only be manageable by
+administrators.
+
+    candid group set-owners staff alice managers
+`
+
+type groupSetOwnersCommand struct {
+	*candidCommand
+	name   string
+	owners []string
+}
+
+func (c *groupSetOwnersCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "set-owners",
+		Args:    "<group> [<owner>...]",
+		Purpose: "set the owners of a group",
+		Doc:     groupSetOwnersDoc,
+	}
+}
+
+func (c *groupSetOwnersCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 1 {
+		return errgo.New("group name required")
+	}
+	c.name = args[0]
+	c.owners = args[1:]
+	return nil
+}
+
+func (c *groupSetOwnersCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.SetGroupOwners(context.Background(), &params.SetGroupOwnersRequest{
+		Name: c.name,
+		Body: params.GroupOwners{
+			Owners: c.owners,
+		},
+	}))
+}
+
+var groupAddMemberGroupDoc = `
+The add-member-group command adds member groups to the specified group.
+All members of a member group are also members of the group.
+
+    candid group add-member-group staff engineering sales
+`
+
+var groupRemoveMemberGroupDoc = `
+The remove-member-group command removes member groups from the
+specified group.
+
+    candid group remove-member-group staff sales
+`
+
+type groupAddMemberGroupCommand struct {
+	*candidCommand
+	name   string
+	groups []string
+
+	// remove is set when the command removes member groups.
+	remove bool
+}
+
+func (c *groupAddMemberGroupCommand) Info() *cmd.Info {
+	if c.remove {
+		return &cmd.Info{
+			Name:    "remove-member-group",
+			Args:    "<group> <member-group>...",
+			Purpose: "remove member groups from a group",
+			Doc:     groupRemoveMemberGroupDoc,
+		}
+	}
+	return &cmd.Info{
+		Name:    "add-member-group",
+		Args:    "<group> <member-group>...",
+		Purpose: "add member groups to a group",
+		Doc:     groupAddMemberGroupDoc,
+	}
+}
+
+func (c *groupAddMemberGroupCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 2 {
+		return errgo.New("group name and at least one member group required")
+	}
+	c.name = args[0]
+	c.groups = args[1:]
+	return nil
+}
+
+func (c *groupAddMemberGroupCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req := params.ModifyMemberGroupsRequest{
+		Name: c.name,
+	}
+	if c.remove {
+		req.Groups.Remove = c.groups
+	} else {
+		req.Groups.Add = c.groups
+	}
+	return errgo.Mask(client.ModifyMemberGroups(context.Background(), &req))
+}
+
+var groupAddUserDoc = `
+The add-user command adds users to the specified group. The owners of
+a group may use this command without being able to change any other
+groups of the users.
+
+    candid group add-user staff bob charlie
+`
+
+var groupRemoveUserDoc = `
+The remove-user command removes users from the specified group.
+
+    candid group remove-user staff charlie
+`
+
+type groupAddUserCommand struct {
+	*candidCommand
+	name  string
+	users []params.Username
+
+	// remove is set when the command removes users.
+	remove bool
+}
+
+func (c *groupAddUserCommand) Info() *cmd.Info {
+	if c.remove {
+		return &cmd.Info{
+			Name:    "remove-user",
+			Args:    "<group> <username>...",
+			Purpose: "remove users from a group",
+			Doc:     groupRemoveUserDoc,
+		}
+	}
+	return &cmd.Info{
+		Name:    "add-user",
+		Args:    "<group> <username>...",
+		Purpose: "add users to a group",
+		Doc:     groupAddUserDoc,
+	}
+}
+
+func (c *groupAddUserCommand) Init(args []string) error {
+	if err := c.candidCommand.Init(nil); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(args) < 2 {
+		return errgo.New("group name and at least one user required")
+	}
+	c.name = args[0]
+	c.users = make([]params.Username, len(args)-1)
+	for i, u := range args[1:] {
+		c.users[i] = params.Username(u)
+	}
+	return nil
+}
+
+func (c *groupAddUserCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req := params.ModifyGroupMembersRequest{
+		Name: c.name,
+	}
+	if c.remove {
+		req.Members.Remove = c.users
+	} else {
+		req.Members.Add = c.users
+	}
+	return errgo.Mask(client.ModifyGroupMembers(context.Background(), &req))
+}
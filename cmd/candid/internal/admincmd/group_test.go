@@ -0,0 +1,159 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+type groupSuite struct {
+	fixture *fixture
+}
+
+func TestGroup(t *testing.T) {
+	qtsuite.Run(qt.New(t), &groupSuite{})
+}
+
+func (s *groupSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *groupSuite) TestCreate(c *qt.C) {
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "create", "--owner", "alice", "--owner", "managers", "staff", "engineering", "sales")
+	g := s.group(c, "staff")
+	c.Assert(g, qt.DeepEquals, store.Group{
+		Name:         "staff",
+		Owners:       []string{"alice", "managers"},
+		MemberGroups: []string{"engineering", "sales"},
+	})
+}
+
+func (s *groupSuite) TestShow(c *qt.C) {
+	s.addGroup(c, store.Group{
+		Name:         "staff",
+		Description:  "All staff",
+		Owners:       []string{"alice", "managers"},
+		MemberGroups: []string{"engineering", "sales"},
+	})
+	stdout := s.fixture.CheckSuccess(c, "-a", "admin.agent", "group", "show", "staff")
+	c.Assert(stdout, qt.Equals, `
+name: staff
+description: All staff
+owners:
+- alice
+- managers
+member-groups:
+- engineering
+- sales
+`[1:])
+}
+
+func (s *groupSuite) TestCreateNoName(c *qt.C) {
+	s.fixture.CheckError(c, 2, `group name required`, "-a", "admin.agent", "group", "create")
+}
+
+func (s *groupSuite) TestShowNotFound(c *qt.C) {
+	s.fixture.CheckError(c, 1, `Get http://.*/v1/g/staff: group staff not found`, "-a", "admin.agent", "group", "show", "staff")
+}
+
+func (s *groupSuite) TestList(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff"})
+	s.addGroup(c, store.Group{Name: "engineering"})
+	stdout := s.fixture.CheckSuccess(c, "-a", "admin.agent", "group", "list")
+	c.Assert(stdout, qt.Equals, `
+engineering
+staff
+`[1:])
+}
+
+func (s *groupSuite) TestDelete(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff"})
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "delete", "staff")
+	err := s.fixture.store.Group(context.Background(), &store.Group{Name: "staff"})
+	c.Assert(err, qt.ErrorMatches, `group staff not found`)
+}
+
+func (s *groupSuite) TestSetDescription(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff", Owners: []string{"alice"}})
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "set-description", "staff", "All staff")
+	g := s.group(c, "staff")
+	c.Assert(g, qt.DeepEquals, store.Group{
+		Name:        "staff",
+		Description: "All staff",
+		Owners:      []string{"alice"},
+	})
+}
+
+func (s *groupSuite) TestSetOwners(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff", Owners: []string{"alice"}})
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "set-owners", "staff", "bob", "managers")
+	g := s.group(c, "staff")
+	c.Assert(g.Owners, qt.DeepEquals, []string{"bob", "managers"})
+}
+
+func (s *groupSuite) TestAddMemberGroup(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff", MemberGroups: []string{"sales"}})
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "add-member-group", "staff", "engineering", "support")
+	g := s.group(c, "staff")
+	c.Assert(g.MemberGroups, qt.DeepEquals, []string{"sales", "engineering", "support"})
+}
+
+func (s *groupSuite) TestRemoveMemberGroup(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff", MemberGroups: []string{"engineering", "sales"}})
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "remove-member-group", "staff", "sales")
+	g := s.group(c, "staff")
+	c.Assert(g.MemberGroups, qt.DeepEquals, []string{"engineering"})
+}
+
+func (s *groupSuite) TestMemberGroupsNoGroups(c *qt.C) {
+	s.fixture.CheckError(c, 2, `group name and at least one member group required`, "-a", "admin.agent", "group", "add-member-group", "staff")
+}
+
+func (s *groupSuite) TestAddUser(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff"})
+	s.addIdentity(c, "bob", "other")
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "add-user", "staff", "bob")
+	c.Assert(s.identityGroups(c, "bob"), qt.DeepEquals, []string{"other", "staff"})
+}
+
+func (s *groupSuite) TestRemoveUser(c *qt.C) {
+	s.addGroup(c, store.Group{Name: "staff"})
+	s.addIdentity(c, "bob", "other", "staff")
+	s.fixture.CheckNoOutput(c, "-a", "admin.agent", "group", "remove-user", "staff", "bob")
+	c.Assert(s.identityGroups(c, "bob"), qt.DeepEquals, []string{"other"})
+}
+
+func (s *groupSuite) addGroup(c *qt.C, g store.Group) {
+	err := s.fixture.store.AddGroup(context.Background(), &g)
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *groupSuite) group(c *qt.C, name string) store.Group {
+	g := store.Group{Name: name}
+	err := s.fixture.store.Group(context.Background(), &g)
+	c.Assert(err, qt.IsNil)
+	return g
+}
+
+func (s *groupSuite) addIdentity(c *qt.C, username string, groups ...string) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", username),
+		Username:   username,
+		Groups:     groups,
+	})
+}
+
+func (s *groupSuite) identityGroups(c *qt.C, username string) []string {
+	identity := store.Identity{Username: username}
+	err := s.fixture.store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	return identity.Groups
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp/idputil/password"
+)
+
+type hashPasswordCommand struct {
+	*candidCommand
+
+	algorithm string
+}
+
+func newHashPasswordCommand(cc *candidCommand) cmd.Command {
+	c := &hashPasswordCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var hashPasswordDoc = `
+The hash-password command reads a password and prints a hash of it
+that is suitable for use as a password in the configuration of a
+static identity provider. The identity server is not contacted.
+
+If standard input is a terminal the password will be prompted for,
+otherwise the first line of standard input will be used as the password.
+
+The hashing algorithm can be chosen with the --algorithm flag, which
+may be one of bcrypt (the default), argon2id or scrypt.
+
+    candid hash-password --algorithm argon2id
+`
+
+func (c *hashPasswordCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "hash-password",
+		Purpose: "generate a password hash",
+		Doc:     hashPasswordDoc,
+	}
+}
+
+func (c *hashPasswordCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.candidCommand.SetFlags(f)
+
+	f.StringVar(&c.algorithm, "algorithm", string(password.DefaultAlgorithm), "password hash algorithm (bcrypt, argon2id or scrypt)")
+}
+
+func (c *hashPasswordCommand) Init(args []string) error {
+	switch password.Algorithm(c.algorithm) {
+	case password.Bcrypt, password.Argon2id, password.Scrypt:
+	default:
+		return errgo.Newf("unsupported algorithm %q", c.algorithm)
+	}
+	return errgo.Mask(c.candidCommand.Init(args))
+}
+
+func (c *hashPasswordCommand) Run(ctxt *cmd.Context) error {
+	pw, err := readPassword(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if pw == "" {
+		return errgo.New("empty password")
+	}
+	h, err := password.Hash(password.Algorithm(c.algorithm), pw)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	fmt.Fprintln(ctxt.Stdout, h)
+	return nil
+}
+
+// readPassword reads a password from the command's standard input. If
+// standard input is a terminal then the user is prompted for the
+// password twice without it being echoed.
+func readPassword(ctxt *cmd.Context) (string, error) {
+	if f, ok := ctxt.Stdin.(*os.File); ok && terminal.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(ctxt.Stderr, "password: ")
+		pw1, err := terminal.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(ctxt.Stderr)
+		if err != nil {
+			return "", errgo.Notef(err, "cannot read password")
+		}
+		fmt.Fprint(ctxt.Stderr, "confirm password: ")
+		pw2, err := terminal.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(ctxt.Stderr)
+		if err != nil {
+			return "", errgo.Notef(err, "cannot read password")
+		}
+		if string(pw1) != string(pw2) {
+			return "", errgo.New("passwords do not match")
+		}
+		return string(pw1), nil
+	}
+	line, err := bufio.NewReader(ctxt.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errgo.Notef(err, "cannot read password")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"github.com/juju/cmd"
+
+	"github.com/canonical/candid/idp/idputil/password"
+)
+
+type hashPasswordSuite struct {
+	fixture *fixture
+}
+
+func TestHashPassword(t *testing.T) {
+	qtsuite.Run(qt.New(t), &hashPasswordSuite{})
+}
+
+func (s *hashPasswordSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *hashPasswordSuite) run(stdin string, args ...string) (code int, stdout, stderr string) {
+	outbuf := new(bytes.Buffer)
+	errbuf := new(bytes.Buffer)
+	ctxt := &cmd.Context{
+		Dir:    s.fixture.Dir,
+		Stdin:  strings.NewReader(stdin),
+		Stdout: outbuf,
+		Stderr: errbuf,
+	}
+	code = s.fixture.RunContext(ctxt, append([]string{"hash-password"}, args...)...)
+	return code, outbuf.String(), errbuf.String()
+}
+
+func (s *hashPasswordSuite) TestHashPassword(c *qt.C) {
+	s.checkHashPassword(c, "$2a$")
+}
+
+func (s *hashPasswordSuite) TestHashPasswordBcrypt(c *qt.C) {
+	s.checkHashPassword(c, "$2a$", "--algorithm", "bcrypt")
+}
+
+func (s *hashPasswordSuite) TestHashPasswordArgon2id(c *qt.C) {
+	s.checkHashPassword(c, "$argon2id$", "--algorithm", "argon2id")
+}
+
+func (s *hashPasswordSuite) TestHashPasswordScrypt(c *qt.C) {
+	s.checkHashPassword(c, "$scrypt$", "--algorithm", "scrypt")
+}
+
+func (s *hashPasswordSuite) checkHashPassword(c *qt.C, expectPrefix string, args ...string) {
+	code, stdout, stderr := s.run("pass1\n", args...)
+	c.Assert(code, qt.Equals, 0, qt.Commentf("%s", stderr))
+	c.Assert(stderr, qt.Equals, "")
+	c.Assert(strings.HasSuffix(stdout, "\n"), qt.Equals, true)
+	h := strings.TrimSuffix(stdout, "\n")
+	c.Assert(strings.HasPrefix(h, expectPrefix), qt.Equals, true, qt.Commentf("%s", h))
+	c.Assert(password.Verify(h, "pass1"), qt.IsNil)
+}
+
+func (s *hashPasswordSuite) TestHashPasswordNoNewline(c *qt.C) {
+	code, stdout, stderr := s.run("pass1")
+	c.Assert(code, qt.Equals, 0, qt.Commentf("%s", stderr))
+	c.Assert(password.Verify(strings.TrimSuffix(stdout, "\n"), "pass1"), qt.IsNil)
+}
+
+func (s *hashPasswordSuite) TestHashPasswordEmpty(c *qt.C) {
+	code, stdout, stderr := s.run("\n")
+	c.Assert(code, qt.Equals, 1)
+	c.Assert(stderr, qt.Equals, "ERROR empty password\n")
+	c.Assert(stdout, qt.Equals, "")
+}
+
+func (s *hashPasswordSuite) TestHashPasswordUnsupportedAlgorithm(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`unsupported algorithm "md5"`,
+		"hash-password", "--algorithm", "md5",
+	)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"github.com/juju/cmd"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/idp/local"
+	"github.com/canonical/candid/store"
+)
+
+type localUserSuite struct {
+	fixture *fixture
+}
+
+func TestLocalUser(t *testing.T) {
+	qtsuite.Run(qt.New(t), &localUserSuite{})
+}
+
+func (s *localUserSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+// runWithStdin runs the given command with the given standard input.
+func (s *localUserSuite) runWithStdin(stdin string, args ...string) (code int, stdout, stderr string) {
+	outbuf := new(bytes.Buffer)
+	errbuf := new(bytes.Buffer)
+	ctxt := &cmd.Context{
+		Dir:    s.fixture.Dir,
+		Stdin:  strings.NewReader(stdin),
+		Stdout: outbuf,
+		Stderr: errbuf,
+	}
+	code = s.fixture.RunContext(ctxt, args...)
+	return code, outbuf.String(), errbuf.String()
+}
+
+func (s *localUserSuite) createUser(c *qt.C, username string) {
+	err := s.fixture.localIDP.CreateUser(context.Background(), local.User{
+		Username: username,
+		Password: username + "password",
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localUserSuite) TestCreateLocalUser(c *qt.C) {
+	code, stdout, stderr := s.runWithStdin("bobpassword\n",
+		"create-local-user", "-a", "admin.agent",
+		"--name", "Bob Smith",
+		"--email", "bob@example.com",
+		"bob", "group1", "group2",
+	)
+	c.Assert(code, qt.Equals, 0, qt.Commentf("%s", stderr))
+	c.Assert(stdout, qt.Equals, "")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("local", "bob"),
+	}
+	err := s.fixture.store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.Username, qt.Equals, "bob")
+	c.Assert(identity.Name, qt.Equals, "Bob Smith")
+	c.Assert(identity.Email, qt.Equals, "bob@example.com")
+	c.Assert(identity.Groups, qt.DeepEquals, []string{"group1", "group2"})
+}
+
+func (s *localUserSuite) TestCreateLocalUserNoUsername(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no username specified`,
+		"create-local-user", "-a", "admin.agent",
+	)
+}
+
+func (s *localUserSuite) TestCreateLocalUserExists(c *qt.C) {
+	s.createUser(c, "bob")
+	code, stdout, stderr := s.runWithStdin("bobpassword\n",
+		"create-local-user", "-a", "admin.agent", "bob",
+	)
+	c.Assert(code, qt.Equals, 1)
+	c.Assert(stderr, qt.Matches, `ERROR Put http://.*/v1/u/bob/local: user "bob" already exists\n`)
+	c.Assert(stdout, qt.Equals, "")
+}
+
+func (s *localUserSuite) TestResetPassword(c *qt.C) {
+	s.createUser(c, "bob")
+	code, stdout, stderr := s.runWithStdin("newpassword\n",
+		"reset-password", "-a", "admin.agent", "-u", "bob",
+	)
+	c.Assert(code, qt.Equals, 0, qt.Commentf("%s", stderr))
+	c.Assert(stdout, qt.Equals, "")
+}
+
+func (s *localUserSuite) TestResetPasswordNotLocal(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "alice"),
+		Username:   "alice",
+	})
+	code, stdout, stderr := s.runWithStdin("newpassword\n",
+		"reset-password", "-a", "admin.agent", "-u", "alice",
+	)
+	c.Assert(code, qt.Equals, 1)
+	c.Assert(stderr, qt.Matches, `ERROR Put http://.*/v1/u/alice/local/password: "alice" is not a local user\n`)
+	c.Assert(stdout, qt.Equals, "")
+}
+
+func (s *localUserSuite) TestDisableLocalUser(c *qt.C) {
+	s.createUser(c, "bob")
+	s.fixture.CheckNoOutput(c, "disable-local-user", "-a", "admin.agent", "-u", "bob")
+}
+
+func (s *localUserSuite) TestEnableLocalUser(c *qt.C) {
+	s.createUser(c, "bob")
+	err := s.fixture.localIDP.SetDisabled(context.Background(), "bob", true)
+	c.Assert(err, qt.IsNil)
+	s.fixture.CheckNoOutput(c, "enable-local-user", "-a", "admin.agent", "-u", "bob")
+}
+
+func (s *localUserSuite) TestDisableLocalUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Put http://.*/v1/u/bob/local/disabled: user bob not found`,
+		"disable-local-user", "-a", "admin.agent", "-u", "bob",
+	)
+}
+
+func (s *localUserSuite) TestDeleteLocalUser(c *qt.C) {
+	s.createUser(c, "bob")
+	s.fixture.CheckNoOutput(c, "delete-local-user", "-a", "admin.agent", "-u", "bob")
+	err := s.fixture.localIDP.SetDisabled(context.Background(), "bob", true)
+	c.Assert(err, qt.ErrorMatches, `user "bob" not found`)
+}
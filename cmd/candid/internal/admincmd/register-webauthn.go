@@ -0,0 +1,80 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type registerWebAuthnCommand struct {
+	userCommand
+
+	idp string
+}
+
+func newRegisterWebAuthnCommand(cc *candidCommand) cmd.Command {
+	c := &registerWebAuthnCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var registerWebAuthnDoc = `
+The register-webauthn command prints a URL that a user can visit in a
+web browser to register a WebAuthn authenticator, such as a security
+key, which they can then use to log in. The URL is valid for one hour.
+
+Users may obtain a registration URL for themselves, administrators may
+obtain one for any user.
+
+If more than one webauthn identity provider is configured in the
+identity server then the one to use must be specified with the --idp
+flag.
+
+    candid register-webauthn -u bob
+`
+
+func (c *registerWebAuthnCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "register-webauthn",
+		Purpose: "get a URL to register a WebAuthn authenticator",
+		Doc:     registerWebAuthnDoc,
+	}
+}
+
+func (c *registerWebAuthnCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.userCommand.SetFlags(f)
+
+	f.StringVar(&c.idp, "idp", "", "name of the webauthn identity provider")
+}
+
+func (c *registerWebAuthnCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp, err := client.WebAuthnRegistration(ctx, &params.WebAuthnRegistrationRequest{
+		Username: username,
+		Body: params.WebAuthnRegistrationBody{
+			IDP: c.idp,
+		},
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	fmt.Fprintln(ctxt.Stdout, resp.URL)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+type registerWebAuthnSuite struct {
+	fixture *fixture
+}
+
+func TestRegisterWebAuthn(t *testing.T) {
+	qtsuite.Run(qt.New(t), &registerWebAuthnSuite{})
+}
+
+func (s *registerWebAuthnSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *registerWebAuthnSuite) TestRegisterWebAuthn(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+		Username:   "bob",
+	})
+	stdout := s.fixture.CheckSuccess(c, "register-webauthn", "-a", "admin.agent", "-u", "bob")
+	c.Assert(stdout, qt.Matches, `http://.*/login/webauthn/register\?token=.+\n`)
+}
+
+func (s *registerWebAuthnSuite) TestRegisterWebAuthnUnknownIDP(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+		Username:   "bob",
+	})
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post http://.*/v1/u/bob/webauthn/register: "local" is not a webauthn identity provider`,
+		"register-webauthn", "-a", "admin.agent", "-u", "bob", "--idp", "local",
+	)
+}
+
+func (s *registerWebAuthnSuite) TestRegisterWebAuthnUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post http://.*/v1/u/bob/webauthn/register: user bob not found`,
+		"register-webauthn", "-a", "admin.agent", "-u", "bob",
+	)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type resetPasswordCommand struct {
+	userCommand
+}
+
+func newResetPasswordCommand(cc *candidCommand) cmd.Command {
+	c := &resetPasswordCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var resetPasswordDoc = `
+The reset-password command sets a new password for a user in a local
+identity provider.
+
+The new password is read from standard input. If standard input is a
+terminal the password will be prompted for.
+
+    candid reset-password -u bob
+`
+
+func (c *resetPasswordCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "reset-password",
+		Purpose: "set the password of a local user",
+		Doc:     resetPasswordDoc,
+	}
+}
+
+func (c *resetPasswordCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	pw, err := readPassword(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.SetLocalUserPassword(ctx, &params.SetLocalUserPasswordRequest{
+		Username: username,
+		Body: params.SetLocalUserPasswordBody{
+			Password: pw,
+		},
+	})
+	return errgo.Mask(err)
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type resetTOTPCommand struct {
+	userCommand
+}
+
+func newResetTOTPCommand(cc *candidCommand) cmd.Command {
+	c := &resetTOTPCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var resetTOTPDoc = `
+The reset-totp command removes the authenticator secret that a user
+enrolled as a second authentication factor, for example if the user
+has lost their device. If the user's identity provider uses a second
+factor they will be asked to enroll again the next time they log in.
+
+    candid reset-totp -u bob
+`
+
+func (c *resetTOTPCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "reset-totp",
+		Purpose: "remove a user's second factor enrollment",
+		Doc:     resetTOTPDoc,
+	}
+}
+
+func (c *resetTOTPCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.ResetUserTOTP(ctx, &params.ResetUserTOTPRequest{
+		Username: username,
+	})
+	return errgo.Mask(err)
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/idp/idputil/totp"
+	"github.com/canonical/candid/store"
+)
+
+type resetTOTPSuite struct {
+	fixture *fixture
+}
+
+func TestResetTOTP(t *testing.T) {
+	qtsuite.Run(qt.New(t), &resetTOTPSuite{})
+}
+
+func (s *resetTOTPSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *resetTOTPSuite) TestResetTOTP(c *qt.C) {
+	ctx := context.Background()
+	candidtest.AddIdentity(ctx, s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+		Username:   "bob",
+		ProviderInfo: map[string][]string{
+			totp.SecretKey: {"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+			"other":        {"value"},
+		},
+	})
+	s.fixture.CheckNoOutput(c, "reset-totp", "-a", "admin.agent", "-u", "bob")
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+	}
+	err := s.fixture.store.Identity(ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.ProviderInfo, qt.DeepEquals, map[string][]string{
+		"other": {"value"},
+	})
+}
+
+func (s *resetTOTPSuite) TestResetTOTPUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Delete http://.*/v1/u/bob/totp: user bob not found`,
+		"reset-totp", "-a", "admin.agent", "-u", "bob",
+	)
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"github.com/juju/gnuflag"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type revokeCommand struct {
+	userCommand
+
+	userID string
+}
+
+func newRevokeCommand(cc *candidCommand) cmd.Command {
+	c := &revokeCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+var revokeDoc = `
+The revoke command revokes all of the discharge macaroons and discharge
+tokens that have been issued for a user, for example if a device
+holding their credentials has been lost or stolen. The user will need
+to log in again before they can be authenticated.
+
+The user can be specified by username, email address or identity ID.
+
+    candid revoke -u bob
+    candid revoke --id static:bob
+`
+
+func (c *revokeCommand) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "revoke",
+		Purpose: "revoke a user's outstanding tokens",
+		Doc:     revokeDoc,
+	}
+}
+
+func (c *revokeCommand) SetFlags(f *gnuflag.FlagSet) {
+	c.userCommand.SetFlags(f)
+
+	f.StringVar(&c.userID, "id", "", "identity ID of the user")
+}
+
+func (c *revokeCommand) Init(args []string) error {
+	if c.userID == "" {
+		return errgo.Mask(c.userCommand.Init(args))
+	}
+	if c.username != "" || c.email != "" {
+		return errgo.New("identity ID specified with username or email, please specify only one")
+	}
+	return errgo.Mask(c.candidCommand.Init(args))
+}
+
+func (c *revokeCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if c.userID != "" {
+		return errgo.Mask(client.RevokeUserTokensWithID(ctx, &params.RevokeUserTokensWithIDRequest{
+			UserID: c.userID,
+		}))
+	}
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(client.RevokeUserTokens(ctx, &params.RevokeUserTokensRequest{
+		Username: username,
+	}))
+}
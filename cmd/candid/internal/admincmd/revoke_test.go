@@ -0,0 +1,96 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+type revokeSuite struct {
+	fixture *fixture
+}
+
+func TestRevoke(t *testing.T) {
+	qtsuite.Run(qt.New(t), &revokeSuite{})
+}
+
+func (s *revokeSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+		Username:   "bob",
+		Email:      "bob@example.com",
+	})
+}
+
+func (s *revokeSuite) TestRevokeUsername(c *qt.C) {
+	before := time.Now()
+	s.fixture.CheckNoOutput(c, "revoke", "-a", "admin.agent", "-u", "bob")
+	s.assertRevokedAfter(c, before)
+}
+
+func (s *revokeSuite) TestRevokeEmail(c *qt.C) {
+	before := time.Now()
+	s.fixture.CheckNoOutput(c, "revoke", "-a", "admin.agent", "-e", "bob@example.com")
+	s.assertRevokedAfter(c, before)
+}
+
+func (s *revokeSuite) TestRevokeID(c *qt.C) {
+	before := time.Now()
+	s.fixture.CheckNoOutput(c, "revoke", "-a", "admin.agent", "--id", "static:bob")
+	s.assertRevokedAfter(c, before)
+}
+
+func (s *revokeSuite) TestRevokeUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post http://.*/v1/u/alice/revoke: user alice not found`,
+		"revoke", "-a", "admin.agent", "-u", "alice",
+	)
+}
+
+func (s *revokeSuite) TestRevokeIDNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Post http://.*/v1/uid/revoke\?id=static%3Aalice: identity "static:alice" not found`,
+		"revoke", "-a", "admin.agent", "--id", "static:alice",
+	)
+}
+
+func (s *revokeSuite) TestRevokeNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"revoke", "-a", "admin.agent",
+	)
+}
+
+func (s *revokeSuite) TestRevokeIDAndUsername(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`identity ID specified with username or email, please specify only one`,
+		"revoke", "-a", "admin.agent", "-u", "bob", "--id", "static:bob",
+	)
+}
+
+func (s *revokeSuite) assertRevokedAfter(c *qt.C, t time.Time) {
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("static", "bob"),
+	}
+	err := s.fixture.store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.TokensRevoked.After(t), qt.Equals, true)
+}
@@ -72,6 +72,7 @@ func (c *showCommand) Run(ctxt *cmd.Context) error {
 		SSHKeys:       []string{},
 		LastLogin:     timeString(u.LastLogin),
 		LastDischarge: timeString(u.LastDischarge),
+		Suspended:     u.Suspended,
 	}
 	if u.ExternalID != "" {
 		user.Name = &u.FullName
@@ -107,4 +108,5 @@ type user struct {
 	SSHKeys       []string            `json:"ssh-keys" yaml:"ssh-keys"`
 	LastLogin     string              `json:"last-login" yaml:"last-login"`
 	LastDischarge string              `json:"last-discharge" yaml:"last-discharge"`
+	Suspended     bool                `json:"suspended,omitempty" yaml:"suspended,omitempty"`
 }
@@ -0,0 +1,82 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd
+
+import (
+	"context"
+
+	"github.com/juju/cmd"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+type suspendCommand struct {
+	userCommand
+
+	// unsuspend is set when the command unsuspends the user.
+	unsuspend bool
+}
+
+func newSuspendCommand(cc *candidCommand) cmd.Command {
+	c := &suspendCommand{}
+	c.candidCommand = cc
+	return c
+}
+
+func newUnsuspendCommand(cc *candidCommand) cmd.Command {
+	c := &suspendCommand{unsuspend: true}
+	c.candidCommand = cc
+	return c
+}
+
+var suspendDoc = `
+The suspend command stops a user, and any agents owned by the user,
+from being authenticated. The user's groups and other details are left
+unchanged. The user can be unsuspended with the unsuspend command.
+
+    candid suspend -u bob
+`
+
+var unsuspendDoc = `
+The unsuspend command allows a user that was suspended with the
+suspend command to be authenticated again.
+
+    candid unsuspend -u bob
+`
+
+func (c *suspendCommand) Info() *cmd.Info {
+	if c.unsuspend {
+		return &cmd.Info{
+			Name:    "unsuspend",
+			Purpose: "unsuspend a user",
+			Doc:     unsuspendDoc,
+		}
+	}
+	return &cmd.Info{
+		Name:    "suspend",
+		Purpose: "suspend a user",
+		Doc:     suspendDoc,
+	}
+}
+
+func (c *suspendCommand) Run(ctxt *cmd.Context) error {
+	defer c.Close(ctxt)
+	ctx := context.Background()
+	username, err := c.lookupUser(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	client, err := c.Client(ctxt)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = client.SetUserSuspended(ctx, &params.SetUserSuspendedRequest{
+		Username: username,
+		Body: params.SetUserSuspendedBody{
+			Suspended: !c.unsuspend,
+		},
+	})
+	return errgo.Mask(err)
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package admincmd_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+type suspendSuite struct {
+	fixture *fixture
+}
+
+func TestSuspend(t *testing.T) {
+	qtsuite.Run(qt.New(t), &suspendSuite{})
+}
+
+func (s *suspendSuite) Init(c *qt.C) {
+	s.fixture = newFixture(c)
+}
+
+func (s *suspendSuite) TestSuspend(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	})
+	s.fixture.CheckNoOutput(c, "suspend", "-a", "admin.agent", "-u", "bob")
+	c.Assert(s.suspended(c, "bob"), qt.Equals, true)
+}
+
+func (s *suspendSuite) TestUnsuspend(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Suspended:  true,
+	})
+	s.fixture.CheckNoOutput(c, "unsuspend", "-a", "admin.agent", "-u", "bob")
+	c.Assert(s.suspended(c, "bob"), qt.Equals, false)
+}
+
+func (s *suspendSuite) TestShowSuspended(c *qt.C) {
+	candidtest.AddIdentity(context.Background(), s.fixture.store, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Suspended:  true,
+	})
+	stdout := s.fixture.CheckSuccess(c, "show", "-a", "admin.agent", "-u", "bob")
+	c.Assert(stdout, qt.Equals, `
+username: bob
+external-id: test:bob
+name: ""
+email: ""
+groups: []
+ssh-keys: []
+last-login: never
+last-discharge: never
+suspended: true
+`[1:])
+}
+
+func (s *suspendSuite) TestSuspendUserNotFound(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		1,
+		`Put http://.*/v1/u/bob/suspended: user bob not found`,
+		"suspend", "-a", "admin.agent", "-u", "bob",
+	)
+}
+
+func (s *suspendSuite) TestSuspendNoUser(c *qt.C) {
+	s.fixture.CheckError(
+		c,
+		2,
+		`no user specified, please specify either username or email`,
+		"suspend", "-a", "admin.agent",
+	)
+}
+
+func (s *suspendSuite) suspended(c *qt.C, username string) bool {
+	identity := store.Identity{
+		Username: username,
+	}
+	err := s.fixture.store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	return identity.Suspended
+}
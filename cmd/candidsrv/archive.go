@@ -0,0 +1,152 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/config"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/archive"
+)
+
+// commands holds the subcommands supported by candidsrv. Each returns
+// the exit status of the command.
+var commands = map[string]func(args []string) int{
+	"export": exportCmd,
+	"import": importCmd,
+}
+
+// exportCmd writes an archive of the data held in the storage backend
+// configured in the given configuration file.
+func exportCmd(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "write the archive to `file` rather than standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s export [options] <config path>\n", filepath.Base(os.Args[0]))
+		fmt.Fprint(os.Stderr, `
+Write an archive of the identities, groups, ACLs and identity provider
+data held in the configured storage backend.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if err := export(fs.Arg(0), *output); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func export(confPath, output string) (err error) {
+	_, backend, err := openBackend(confPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer backend.Close()
+	rb, ok := backend.(store.RawBackend)
+	if !ok {
+		return errgo.Newf("storage backend does not support export")
+	}
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer func() {
+			if err1 := f.Close(); err == nil {
+				err = errgo.Mask(err1)
+			}
+		}()
+		w = f
+	}
+	return errgo.Mask(archive.Export(context.Background(), w, rb))
+}
+
+// importCmd loads an archive into the storage backend configured in the
+// given configuration file.
+func importCmd(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	onConflict := fs.String("on-conflict", string(archive.ConflictFail), "what to do with identities and groups that already exist: fail, skip or overwrite")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s import [options] <config path> <archive path>\n", filepath.Base(os.Args[0]))
+		fmt.Fprint(os.Stderr, `
+Load an archive written by the export command into the configured
+storage backend. An identity in the archive conflicts with an existing
+identity that has the same provider ID or username. With -on-conflict
+set to "fail" nothing is imported if there are any conflicts, "skip"
+keeps the existing identities and "overwrite" replaces them. An
+existing identity that is replaced by one with a different provider ID
+is deleted along with any agents it owns. The ACLs
+and identity provider data in the archive always replace any existing
+values.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	policy := archive.ConflictPolicy(*onConflict)
+	if !policy.Valid() {
+		fmt.Fprintf(os.Stderr, "invalid -on-conflict value %q\n", *onConflict)
+		return 2
+	}
+	if err := import_(fs.Arg(0), fs.Arg(1), policy); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot import: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func import_(confPath, archivePath string, policy archive.ConflictPolicy) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer f.Close()
+	conf, backend, err := openBackend(confPath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer backend.Close()
+	auditSink, err := newAuditSink(conf.AuditLog, backend)
+	if err != nil {
+		return errgo.Notef(err, "cannot create audit log")
+	}
+	return errgo.Mask(archive.Import(context.Background(), backend, f, policy, auditSink))
+}
+
+// openBackend reads the given configuration file and opens the storage
+// backend it configures.
+func openBackend(confPath string) (*config.Config, store.Backend, error) {
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot read configuration")
+	}
+	if err := loggo.ConfigureLoggers(conf.LoggingConfig); err != nil {
+		return nil, nil, errgo.Notef(err, "cannot configure loggers")
+	}
+	backend, err := conf.Storage.NewBackend()
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot open storage backend")
+	}
+	return conf, backend, nil
+}
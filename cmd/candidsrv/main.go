@@ -6,6 +6,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/syslog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,6 +20,7 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/canonical/candid"
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/config"
 	"github.com/canonical/candid/idp"
 	_ "github.com/canonical/candid/idp/adfs"
@@ -28,10 +30,15 @@ import (
 	_ "github.com/canonical/candid/idp/keycloak"
 	_ "github.com/canonical/candid/idp/keystone"
 	_ "github.com/canonical/candid/idp/ldap"
+	_ "github.com/canonical/candid/idp/local"
+	_ "github.com/canonical/candid/idp/saml"
 	_ "github.com/canonical/candid/idp/static"
 	"github.com/canonical/candid/idp/usso"
 	_ "github.com/canonical/candid/idp/usso/ussodischarge"
 	_ "github.com/canonical/candid/idp/usso/ussooauth"
+	_ "github.com/canonical/candid/idp/webauthn"
+	"github.com/canonical/candid/store"
+	_ "github.com/canonical/candid/store/boltstore"
 	_ "github.com/canonical/candid/store/memstore"
 	_ "github.com/canonical/candid/store/mgostore"
 	_ "github.com/canonical/candid/store/sqlstore"
@@ -39,9 +46,19 @@ import (
 
 var logger = loggo.GetLogger("candidsrv")
 
+// defaultReaperInterval holds the time between runs of the identity
+// reaper if one is configured without an interval.
+const defaultReaperInterval = 24 * time.Hour
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path>\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "       %s export|import [options] <config path> ...\n", filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 		exit(2)
 	}
@@ -91,7 +108,12 @@ func serve(conf *config.Config) error {
 		return errgo.Mask(err)
 	}
 	defer backend.Close()
+	auditSink, err := newAuditSink(conf.AuditLog, backend)
+	if err != nil {
+		return errgo.Notef(err, "cannot create audit log")
+	}
 	return serveIdentity(conf, candid.ServerParams{
+		AuditSink:               auditSink,
 		Store:                   backend.Store(),
 		ProviderDataStore:       backend.ProviderDataStore(),
 		MeetingStore:            backend.MeetingStore(),
@@ -133,11 +155,27 @@ func serveIdentity(conf *config.Config, params candid.ServerParams) error {
 	params.DischargeTokenTimeout = conf.DischargeTokenTimeout.Duration
 	params.SkipLocationForCookiePaths = conf.SkipLocationForCookiePaths
 	params.EnableEmailLogin = conf.EnableEmailLogin
+	params.OIDCClients = conf.OIDCClients
+	params.OIDCTokenTimeout = conf.OIDCTokenTimeout.Duration
+	params.MaxElevationDuration = conf.MaxElevationDuration.Duration
+	params.DischargePolicy = conf.DischargePolicy
+	params.TrustedProxies = conf.TrustedProxies
+	params.Webhooks = conf.Webhooks
+	if conf.Reaper != nil {
+		params.ReaperInterval = conf.Reaper.Interval.Duration
+		if params.ReaperInterval == 0 {
+			params.ReaperInterval = defaultReaperInterval
+		}
+		params.InactiveIdentityPeriod = time.Duration(conf.Reaper.InactiveDays) * 24 * time.Hour
+		params.InactiveIdentityAction = conf.Reaper.InactiveAction
+		params.RemoveOrphanedAgents = conf.Reaper.RemoveOrphanedAgents
+	}
 	srv, err := candid.NewServer(
 		params,
 		candid.V1,
 		candid.Debug,
 		candid.Discharger,
+		candid.SCIM,
 	)
 	if err != nil {
 		return errgo.Notef(err, "cannot create new server at %q", conf.ListenAddress)
@@ -172,6 +210,36 @@ func serveIdentity(conf *config.Config, params candid.ServerParams) error {
 	return httpServer.ListenAndServe()
 }
 
+// newAuditSink returns the audit.Sink described by the given
+// configuration. If conf is nil no audit events will be recorded.
+func newAuditSink(conf *config.AuditLogConfig, backend store.Backend) (audit.Sink, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	switch conf.Type {
+	case config.AuditLogFile:
+		return audit.NewWriterSink(&lumberjack.Logger{
+			Filename:   conf.Filename,
+			MaxSize:    500, // megabytes
+			MaxBackups: 3,
+			MaxAge:     28, //days
+		}), nil
+	case config.AuditLogSyslog:
+		tag := conf.Tag
+		if tag == "" {
+			tag = "candid"
+		}
+		w, err := syslog.Dial(conf.Network, conf.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return audit.NewWriterSink(w), nil
+	case config.AuditLogStore:
+		return backend.AuditStore(), nil
+	}
+	return nil, errgo.Newf("unrecognised audit-log type %q", conf.Type)
+}
+
 var defaultIDPs = []idp.IdentityProvider{
 	usso.NewIdentityProvider(usso.Params{}),
 }
@@ -0,0 +1,296 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/juju/aclstore/v2"
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/store"
+)
+
+// CopyBackend copies all the data held in src to dst. This includes
+// identities, groups, group changes, elevations, audit events, ACLs,
+// bakery root keys and identity provider data. Meetings are
+// short-lived and are not copied.
+//
+// Data that is already present in dst is not copied again, so if a
+// copy is interrupted it can be resumed by calling CopyBackend again
+// with the same arguments.
+//
+// Note that the context is not passed through Store.Context as the
+// stores would share any session held in the context.
+func CopyBackend(ctx context.Context, dst, src store.RawBackend) error {
+	steps := []struct {
+		name string
+		f    func(context.Context, store.RawBackend, store.RawBackend) error
+	}{
+		{"identities", copyIdentities},
+		{"groups", copyGroups},
+		{"group changes", copyGroupChanges},
+		{"elevations", copyElevations},
+		{"audit events", copyAuditEvents},
+		{"ACLs", copyACLs},
+		{"root keys", copyRootKeys},
+		{"provider data", copyProviderData},
+	}
+	for _, step := range steps {
+		log.Printf("copying %s", step.name)
+		if err := step.f(ctx, dst, src); err != nil {
+			return errgo.Notef(err, "cannot copy %s", step.name)
+		}
+	}
+	return nil
+}
+
+func copyIdentities(ctx context.Context, dst, src store.RawBackend) error {
+	return errgo.Mask(Copy(ctx, dst.Store(), NewStoreSource(ctx, src.Store())))
+}
+
+func copyGroups(ctx context.Context, dst, src store.RawBackend) error {
+	groups, err := src.Store().FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	update := store.GroupUpdate{
+		store.GroupDescription:  store.Set,
+		store.GroupOwners:       store.Set,
+		store.GroupMemberGroups: store.Set,
+	}
+	for i := range groups {
+		g := &groups[i]
+		err := dst.Store().AddGroup(ctx, g)
+		if errgo.Cause(err) == store.ErrDuplicateGroup {
+			err = dst.Store().UpdateGroup(ctx, g, update)
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot copy group %s", g.Name)
+		}
+	}
+	return nil
+}
+
+func copyGroupChanges(ctx context.Context, dst, src store.RawBackend) error {
+	srcChanges, err := src.Store().GroupChanges(ctx, store.GroupChangeFilter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	dstChanges, err := dst.Store().GroupChanges(ctx, store.GroupChangeFilter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	have := make(map[string]int)
+	for _, gc := range dstChanges {
+		have[groupChangeKey(gc)]++
+	}
+	var changes []store.GroupChange
+	for _, gc := range srcChanges {
+		if k := groupChangeKey(gc); have[k] > 0 {
+			have[k]--
+			continue
+		}
+		changes = append(changes, gc)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return errgo.Mask(dst.Store().AddGroupChanges(ctx, changes))
+}
+
+func copyElevations(ctx context.Context, dst, src store.RawBackend) error {
+	srcElevations, err := src.Store().FindElevations(ctx, store.ElevationFilter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	dstElevations, err := dst.Store().FindElevations(ctx, store.ElevationFilter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	// Elevations are matched on the details of the request, the
+	// outcome of the request might have changed since it was last
+	// copied.
+	have := make(map[string][]store.Elevation)
+	for _, e := range dstElevations {
+		k := elevationRequestKey(e)
+		have[k] = append(have[k], e)
+	}
+	for i := range srcElevations {
+		e := &srcElevations[i]
+		k := elevationRequestKey(*e)
+		if len(have[k]) == 0 {
+			if err := dst.Store().AddElevation(ctx, e); err != nil {
+				return errgo.Mask(err)
+			}
+			continue
+		}
+		dstE := have[k][0]
+		have[k] = have[k][1:]
+		if elevationKey(dstE) == elevationKey(*e) {
+			continue
+		}
+		from := dstE.State
+		dstE.State = e.State
+		dstE.Approver = e.Approver
+		dstE.Decided = e.Decided
+		dstE.Expires = e.Expires
+		if err := dst.Store().UpdateElevation(ctx, &dstE, from); err != nil {
+			return errgo.Notef(err, "cannot update elevation %s", dstE.ID)
+		}
+	}
+	return nil
+}
+
+func copyAuditEvents(ctx context.Context, dst, src store.RawBackend) error {
+	srcEvents, err := src.AuditStore().Events(ctx, audit.Filter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	dstEvents, err := dst.AuditStore().Events(ctx, audit.Filter{})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	have := make(map[string]int)
+	for _, e := range dstEvents {
+		have[auditEventKey(e)]++
+	}
+	for _, e := range srcEvents {
+		if k := auditEventKey(e); have[k] > 0 {
+			have[k]--
+			continue
+		}
+		if err := dst.AuditStore().Log(ctx, e); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func copyACLs(ctx context.Context, dst, src store.RawBackend) error {
+	for _, name := range aclNames() {
+		users, err := src.ACLStore().Get(ctx, name)
+		if errgo.Cause(err) == aclstore.ErrACLNotFound {
+			continue
+		}
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if err := dst.ACLStore().CreateACL(ctx, name, users); err != nil {
+			return errgo.Notef(err, "cannot create ACL %s", name)
+		}
+		if err := dst.ACLStore().Set(ctx, name, users); err != nil {
+			return errgo.Notef(err, "cannot set ACL %s", name)
+		}
+	}
+	return nil
+}
+
+func copyRootKeys(ctx context.Context, dst, src store.RawBackend) error {
+	keys, err := src.RootKeys(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, key := range keys {
+		if err := dst.AddRootKey(ctx, key); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func copyProviderData(ctx context.Context, dst, src store.RawBackend) error {
+	data, err := src.ProviderData(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	kvs := make(map[string]simplekv.Store)
+	for _, pd := range data {
+		kv := kvs[pd.IDP]
+		if kv == nil {
+			kv, err = dst.ProviderDataStore().KeyValueStore(ctx, pd.IDP)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			kvs[pd.IDP] = kv
+		}
+		if err := kv.Set(ctx, pd.Key, pd.Value, pd.Expire); err != nil {
+			return errgo.Notef(err, "cannot set %s data %q", pd.IDP, pd.Key)
+		}
+	}
+	return nil
+}
+
+// aclNames returns the names of all the ACLs held by the identity
+// server, including the meta-ACLs that control who can change them.
+func aclNames() []string {
+	var names []string
+	for _, name := range auth.ACLs() {
+		names = append(names, name, "_"+name)
+	}
+	return names
+}
+
+// normalizeTime converts t into a form that can be compared between
+// backends. Some backends store times with only millisecond precision.
+func normalizeTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+// groupChangeKey returns a key that identifies the given group change.
+func groupChangeKey(gc store.GroupChange) string {
+	gc.Time = normalizeTime(gc.Time)
+	return jsonKey(gc)
+}
+
+// elevationRequestKey returns a key that identifies the request made
+// by the given elevation.
+func elevationRequestKey(e store.Elevation) string {
+	return jsonKey(store.Elevation{
+		Username:  e.Username,
+		Group:     e.Group,
+		Reason:    e.Reason,
+		Duration:  e.Duration,
+		Requested: normalizeTime(e.Requested),
+	})
+}
+
+// elevationKey returns a key that identifies the given elevation and
+// its current state. The ID of the elevation is specific to the
+// backend and is not included.
+func elevationKey(e store.Elevation) string {
+	e.ID = ""
+	e.Requested = normalizeTime(e.Requested)
+	e.Decided = normalizeTime(e.Decided)
+	e.Expires = normalizeTime(e.Expires)
+	return jsonKey(e)
+}
+
+// auditEventKey returns a key that identifies the given audit event.
+func auditEventKey(e audit.Event) string {
+	e.Time = normalizeTime(e.Time)
+	if e.Until != nil {
+		t := normalizeTime(*e.Until)
+		e.Until = &t
+	}
+	return jsonKey(e)
+}
+
+func jsonKey(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// None of the types used as keys can fail to marshal.
+		panic(err)
+	}
+	return string(data)
+}
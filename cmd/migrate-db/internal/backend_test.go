@@ -0,0 +1,282 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package internal_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/cmd/migrate-db/internal"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/boltstore"
+	"github.com/canonical/candid/store/memstore"
+)
+
+var epoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestCopyBackendMemstore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	testCopyBackend(c, memstore.NewBackend())
+}
+
+func TestCopyBackendBolt(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	testCopyBackend(c, newBoltBackend(c))
+}
+
+func testCopyBackend(c *qt.C, dst store.RawBackend) {
+	ctx := context.Background()
+	src := memstore.NewBackend()
+	populate(c, src)
+
+	err := internal.CopyBackend(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	diffs, err := internal.Verify(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diffs, qt.HasLen, 0)
+
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1"),
+	}
+	err = dst.Store().Identity(ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.Username, qt.Equals, "test1")
+
+	rootKey, err := dst.BakeryRootKeyStore().Get(ctx, []byte("key1"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(rootKey), qt.Equals, "0123456789abcdef0123456789abcdef")
+
+	kv, err := dst.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	v, err := kv.Get(ctx, "k1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(v), qt.Equals, "v1")
+
+	users, err := dst.ACLStore().Get(ctx, "read-user")
+	c.Assert(err, qt.IsNil)
+	c.Assert(users, qt.DeepEquals, []string{"admin@candid", "test1"})
+
+	// Copying again does not duplicate anything.
+	err = internal.CopyBackend(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	diffs, err = internal.Verify(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diffs, qt.HasLen, 0)
+}
+
+func TestCopyBackendResume(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	src := memstore.NewBackend()
+	populate(c, src)
+	dst := memstore.NewBackend()
+	err := internal.CopyBackend(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+
+	// Make some changes to the source after the first copy.
+	err = src.Store().AddGroupChanges(ctx, []store.GroupChange{{
+		Time:     epoch.Add(2 * time.Hour),
+		Actor:    "admin@candid",
+		Username: "test1",
+		Group:    "group2",
+		Op:       store.GroupAdd,
+	}})
+	c.Assert(err, qt.IsNil)
+	elevations, err := src.Store().FindElevations(ctx, store.ElevationFilter{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(elevations, qt.HasLen, 1)
+	e := elevations[0]
+	e.State = store.ElevationApproved
+	e.Approver = "admin@candid"
+	e.Decided = epoch.Add(time.Hour)
+	e.Expires = epoch.Add(2 * time.Hour)
+	err = src.Store().UpdateElevation(ctx, &e, store.ElevationPending)
+	c.Assert(err, qt.IsNil)
+
+	diffs, err := internal.Verify(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diffs, qt.DeepEquals, []string{
+		"1 group change(s) missing from destination",
+		"1 elevation(s) missing from destination",
+		"1 elevation(s) not in source",
+	})
+
+	err = internal.CopyBackend(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	diffs, err = internal.Verify(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diffs, qt.HasLen, 0)
+	elevations, err = dst.Store().FindElevations(ctx, store.ElevationFilter{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(elevations, qt.HasLen, 1)
+	c.Assert(elevations[0].State, qt.Equals, store.ElevationApproved)
+}
+
+func TestVerifyDifferences(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	src := memstore.NewBackend()
+	populate(c, src)
+	dst := memstore.NewBackend()
+	err := internal.CopyBackend(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+
+	err = dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1"),
+		Email:      "changed@example.com",
+	}, store.Update{
+		store.Email: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "3"),
+		Username:   "test3",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.ACLStore().Set(ctx, "read-user", []string{"admin@candid"})
+	c.Assert(err, qt.IsNil)
+	err = dst.AuditStore().Log(ctx, audit.Event{
+		Time: epoch,
+		Type: audit.Login,
+		User: "test3",
+	})
+	c.Assert(err, qt.IsNil)
+	kv, err := dst.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "k1", []byte("changed"), time.Time{})
+	c.Assert(err, qt.IsNil)
+
+	diffs, err := internal.Verify(ctx, dst, src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(diffs, qt.DeepEquals, []string{
+		"identity test:1 differs",
+		"identity test:3 not in source",
+		"1 audit event(s) not in source",
+		"ACL read-user differs",
+		`provider data test "k1" differs`,
+	})
+}
+
+// populate adds data of every kind copied by CopyBackend to the given
+// backend.
+func populate(c *qt.C, b store.RawBackend) {
+	ctx := context.Background()
+	k1 := bakery.MustGenerateKey()
+	err := b.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1"),
+		Username:   "test1",
+		Name:       "Test User",
+		Email:      "test1@example.com",
+		Groups:     []string{"group1", "group2"},
+		PublicKeys: []bakery.PublicKey{k1.Public},
+		LastLogin:  epoch,
+		ProviderInfo: map[string][]string{
+			"p1": {"p1v1", "p1v2"},
+		},
+		ExtraInfo: map[string][]string{
+			"e1": {"e1v1"},
+		},
+		GroupExpiry: map[string]time.Time{
+			"group2": epoch.Add(24 * time.Hour),
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.Name:         store.Set,
+		store.Email:        store.Set,
+		store.Groups:       store.Set,
+		store.PublicKeys:   store.Set,
+		store.LastLogin:    store.Set,
+		store.ProviderInfo: store.Set,
+		store.ExtraInfo:    store.Set,
+		store.GroupExpiry:  store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = b.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "2"),
+		Username:   "test2",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = b.Store().AddGroup(ctx, &store.Group{
+		Name:         "group1",
+		Description:  "Group One",
+		Owners:       []string{"test1"},
+		MemberGroups: []string{"group2"},
+	})
+	c.Assert(err, qt.IsNil)
+	err = b.Store().AddGroupChanges(ctx, []store.GroupChange{{
+		Time:     epoch,
+		Actor:    "admin@candid",
+		Username: "test1",
+		Group:    "group1",
+		Op:       store.GroupAdd,
+	}, {
+		Time:     epoch.Add(time.Hour),
+		Username: "test2",
+		Group:    "group1",
+		Op:       store.GroupRemove,
+	}})
+	c.Assert(err, qt.IsNil)
+	err = b.Store().AddElevation(ctx, &store.Elevation{
+		Username:  "test1",
+		Group:     "group3",
+		Reason:    "testing",
+		Duration:  time.Hour,
+		State:     store.ElevationPending,
+		Requested: epoch,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = b.AuditStore().Log(ctx, audit.Event{
+		Time: epoch,
+		Type: audit.Login,
+		User: "test1",
+		IDP:  "test",
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = b.ACLStore().CreateACL(ctx, "read-user", []string{"admin@candid", "test1"})
+	c.Assert(err, qt.IsNil)
+
+	err = b.AddRootKey(ctx, dbrootkeystore.RootKey{
+		Id:      []byte("key1"),
+		Created: epoch,
+		Expires: time.Now().Add(24 * time.Hour),
+		RootKey: []byte("0123456789abcdef0123456789abcdef"),
+	})
+	c.Assert(err, qt.IsNil)
+
+	kv, err := b.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "k1", []byte("v1"), time.Time{})
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "k2", []byte("v2"), time.Now().Add(time.Hour))
+	c.Assert(err, qt.IsNil)
+}
+
+func newBoltBackend(c *qt.C) store.RawBackend {
+	backend, err := boltstore.Params{Path: filepath.Join(c.Mkdir(), "candid.db")}.NewBackend()
+	c.Assert(err, qt.IsNil)
+	c.Defer(backend.Close)
+	return backend.(store.RawBackend)
+}
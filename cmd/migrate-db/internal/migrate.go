@@ -54,6 +54,9 @@ func Copy(ctx context.Context, dst store.Store, src Source) error {
 		store.ProviderInfo:  store.Set,
 		store.ExtraInfo:     store.Set,
 		store.Owner:         store.Set,
+		store.TokensRevoked: store.Set,
+		store.Suspended:     store.Set,
+		store.GroupExpiry:   store.Set,
 	}
 	for src.Next() {
 		identity := src.Identity()
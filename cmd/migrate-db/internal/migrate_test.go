@@ -131,6 +131,74 @@ func (s errorStore) IdentityCounts(_ context.Context) (map[string]int, error) {
 	return nil, s.err
 }
 
+func (s errorStore) DeleteIdentity(_ context.Context, _ *store.Identity) error {
+	return s.err
+}
+
+func (s errorStore) Group(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
+func (s errorStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	return nil, s.err
+}
+
+func (s errorStore) AddGroup(_ context.Context, _ *store.Group) error {
+	return s.err
+}
+
+func (s errorStore) UpdateGroup(_ context.Context, _ *store.Group, _ store.GroupUpdate) error {
+	return s.err
+}
+
+func (s errorStore) RemoveGroup(_ context.Context, _ string) error {
+	return s.err
+}
+
+func (s errorStore) AddGroupChanges(_ context.Context, _ []store.GroupChange) error {
+	return s.err
+}
+
+func (s errorStore) GroupChanges(_ context.Context, _ store.GroupChangeFilter) ([]store.GroupChange, error) {
+	return nil, s.err
+}
+
+func (s errorStore) AddElevation(_ context.Context, _ *store.Elevation) error {
+	return s.err
+}
+
+func (s errorStore) Elevation(_ context.Context, _ *store.Elevation) error {
+	return s.err
+}
+
+func (s errorStore) FindElevations(_ context.Context, _ store.ElevationFilter) ([]store.Elevation, error) {
+	return nil, s.err
+}
+
+func (s errorStore) UpdateElevation(_ context.Context, _ *store.Elevation, _ store.ElevationState) error {
+	return s.err
+}
+
+func (s errorStore) AddOutboxEntries(_ context.Context, _ []store.OutboxEntry) error {
+	return s.err
+}
+
+func (s errorStore) OutboxEntries(_ context.Context, _ time.Time, _ int) ([]store.OutboxEntry, error) {
+	return nil, s.err
+}
+
+func (s errorStore) UpdateOutboxEntry(_ context.Context, _ *store.OutboxEntry) error {
+	return s.err
+}
+
+func (s errorStore) ClaimOutboxEntry(_ context.Context, _ string, _, _ time.Time) error {
+	return s.err
+}
+
+func (s errorStore) RemoveOutboxEntry(_ context.Context, _ string) error {
+	return s.err
+}
+
 func TestCopy(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
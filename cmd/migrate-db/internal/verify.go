@@ -0,0 +1,268 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/juju/aclstore/v2"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/store"
+)
+
+// Verify compares the data held in src with that held in dst and
+// returns a description of each difference found. Only the data that
+// would be copied by CopyBackend is compared.
+func Verify(ctx context.Context, dst, src store.RawBackend) ([]string, error) {
+	var diffs []string
+	steps := []struct {
+		name string
+		f    func(context.Context, store.RawBackend) (map[string]string, []string, error)
+	}{
+		{"identity", identityKeys},
+		{"group", groupKeys},
+		{"group change", groupChangeKeys},
+		{"elevation", elevationKeys},
+		{"audit event", auditEventKeys},
+		{"ACL", aclKeys},
+		{"root key", rootKeyKeys},
+		{"provider data", providerDataKeys},
+	}
+	for _, step := range steps {
+		srcKeyed, srcCounted, err := step.f(ctx, src)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read source")
+		}
+		dstKeyed, dstCounted, err := step.f(ctx, dst)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read destination")
+		}
+		diffs = append(diffs, compareKeyed(step.name, srcKeyed, dstKeyed)...)
+		diffs = append(diffs, compareCounted(step.name, srcCounted, dstCounted)...)
+	}
+	return diffs, nil
+}
+
+// compareKeyed compares two sets of values indexed by key.
+func compareKeyed(kind string, src, dst map[string]string) []string {
+	var diffs []string
+	for _, k := range sortedKeys(src) {
+		v, ok := dst[k]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s %s missing from destination", kind, k))
+		case v != src[k]:
+			diffs = append(diffs, fmt.Sprintf("%s %s differs", kind, k))
+		}
+	}
+	for _, k := range sortedKeys(dst) {
+		if _, ok := src[k]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s %s not in source", kind, k))
+		}
+	}
+	return diffs
+}
+
+// compareCounted compares two collections of values that have no
+// natural key.
+func compareCounted(kind string, src, dst []string) []string {
+	counts := make(map[string]int)
+	for _, v := range src {
+		counts[v]++
+	}
+	for _, v := range dst {
+		counts[v]--
+	}
+	var missing, extra int
+	for _, n := range counts {
+		if n > 0 {
+			missing += n
+		} else {
+			extra -= n
+		}
+	}
+	var diffs []string
+	if missing > 0 {
+		diffs = append(diffs, fmt.Sprintf("%d %s(s) missing from destination", missing, kind))
+	}
+	if extra > 0 {
+		diffs = append(diffs, fmt.Sprintf("%d %s(s) not in source", extra, kind))
+	}
+	return diffs
+}
+
+func identityKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	identities, err := b.Store().FindIdentities(ctx, nil, store.Filter{}, nil, 0, 0)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	keys := make(map[string]string)
+	for _, id := range identities {
+		keys[string(id.ProviderID)] = jsonKey(normalizeIdentity(id))
+	}
+	return keys, nil, nil
+}
+
+func groupKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	groups, err := b.Store().FindGroups(ctx)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	keys := make(map[string]string)
+	for _, g := range groups {
+		keys[g.Name] = jsonKey(store.Group{
+			Name:         g.Name,
+			Description:  g.Description,
+			Owners:       sortedStrings(g.Owners),
+			MemberGroups: sortedStrings(g.MemberGroups),
+		})
+	}
+	return keys, nil, nil
+}
+
+func groupChangeKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	changes, err := b.Store().GroupChanges(ctx, store.GroupChangeFilter{})
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	var keys []string
+	for _, gc := range changes {
+		keys = append(keys, groupChangeKey(gc))
+	}
+	return nil, keys, nil
+}
+
+func elevationKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	elevations, err := b.Store().FindElevations(ctx, store.ElevationFilter{})
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	var keys []string
+	for _, e := range elevations {
+		keys = append(keys, elevationKey(e))
+	}
+	return nil, keys, nil
+}
+
+func auditEventKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	events, err := b.AuditStore().Events(ctx, audit.Filter{})
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	var keys []string
+	for _, e := range events {
+		keys = append(keys, auditEventKey(e))
+	}
+	return nil, keys, nil
+}
+
+func aclKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	keys := make(map[string]string)
+	for _, name := range aclNames() {
+		users, err := b.ACLStore().Get(ctx, name)
+		if errgo.Cause(err) == aclstore.ErrACLNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		keys[name] = jsonKey(sortedStrings(users))
+	}
+	return keys, nil, nil
+}
+
+func rootKeyKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	rootKeys, err := b.RootKeys(ctx)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	keys := make(map[string]string)
+	for _, k := range rootKeys {
+		k.Created = normalizeTime(k.Created)
+		k.Expires = normalizeTime(k.Expires)
+		keys[fmt.Sprintf("%x", k.Id)] = jsonKey(k)
+	}
+	return keys, nil, nil
+}
+
+func providerDataKeys(ctx context.Context, b store.RawBackend) (map[string]string, []string, error) {
+	data, err := b.ProviderData(ctx)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	keys := make(map[string]string)
+	for _, pd := range data {
+		pd.Expire = normalizeTime(pd.Expire)
+		keys[fmt.Sprintf("%s %q", pd.IDP, pd.Key)] = jsonKey(pd)
+	}
+	return keys, nil, nil
+}
+
+// normalizeIdentity returns a copy of the given identity in a form that
+// can be compared between backends.
+func normalizeIdentity(id store.Identity) store.Identity {
+	id.ID = ""
+	id.LastLogin = normalizeTime(id.LastLogin)
+	id.LastDischarge = normalizeTime(id.LastDischarge)
+	id.TokensRevoked = normalizeTime(id.TokensRevoked)
+	id.Groups = sortedStrings(id.Groups)
+	var publicKeys []bakery.PublicKey
+	if len(id.PublicKeys) > 0 {
+		publicKeys = append(publicKeys, id.PublicKeys...)
+		sort.Slice(publicKeys, func(i, j int) bool {
+			return publicKeys[i].String() < publicKeys[j].String()
+		})
+	}
+	id.PublicKeys = publicKeys
+	id.ProviderInfo = normalizeInfo(id.ProviderInfo)
+	id.ExtraInfo = normalizeInfo(id.ExtraInfo)
+	var groupExpiry map[string]time.Time
+	for k, v := range id.GroupExpiry {
+		if groupExpiry == nil {
+			groupExpiry = make(map[string]time.Time)
+		}
+		groupExpiry[k] = normalizeTime(v)
+	}
+	id.GroupExpiry = groupExpiry
+	return id
+}
+
+func normalizeInfo(info map[string][]string) map[string][]string {
+	var info1 map[string][]string
+	for k, v := range info {
+		if len(v) == 0 {
+			continue
+		}
+		if info1 == nil {
+			info1 = make(map[string][]string)
+		}
+		info1[k] = sortedStrings(v)
+	}
+	return info1
+}
+
+// sortedStrings returns a sorted copy of ss, or nil if ss is empty.
+func sortedStrings(ss []string) []string {
+	if len(ss) == 0 {
+		return nil
+	}
+	ss1 := append([]string(nil), ss...)
+	sort.Strings(ss1)
+	return ss1
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
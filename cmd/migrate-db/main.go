@@ -17,13 +17,16 @@ import (
 
 	"github.com/canonical/candid/cmd/migrate-db/internal"
 	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/boltstore"
+	"github.com/canonical/candid/store/memstore"
 	"github.com/canonical/candid/store/mgostore"
 	"github.com/canonical/candid/store/sqlstore"
 )
 
 var (
-	from = flag.String("from", "legacy:mongodb://localhost/identity", "store `specification` to copy the identities from.")
-	to   = flag.String("to", "mgo:mongodb://localhost/idm", "store `specification` to copy the identities to.")
+	from   = flag.String("from", "legacy:mongodb://localhost/identity", "store `specification` to copy the data from.")
+	to     = flag.String("to", "mgo:mongodb://localhost/idm", "store `specification` to copy the data to.")
+	verify = flag.Bool("verify", false, "compare the data in the two stores rather than copying it.")
 )
 
 func main() {
@@ -38,98 +41,141 @@ func main() {
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprint(os.Stderr, `
-Migrate all of the identities from one store to another. Stores are
-specified by a string containing the store type, a colon, and connection
-information specific to the store type. For the -from store the valid
-prefixes are:
+Migrate all of the data from one store to another. Stores are specified
+by a string containing the store type, a colon, and connection
+information specific to the store type. The valid prefixes are:
 
-	"legacy" - old style mgo based store
-	"mgo" - new style mgo based store 
+	"legacy" - old style mgo based store (-from only)
+	"mgo" - new style mgo based store
 	"postgres" - postgres based store
-
-The -to store only supports "mgo" and "postgres".
+	"sqlite" - sqlite based store
+	"bolt" - bolt based store
+	"memory" - in-memory store, discarded on exit
 
 For "legacy" and "mgo" type stores the connection string is a mgo URL
 (see https://godoc.org/gopkg.in/mgo.v2#Dial). For "postgres" type
 stores the connection string is as documented in
-https://godoc.org/github.com/lib/pq.
+https://godoc.org/github.com/lib/pq. For "sqlite" and "bolt" type
+stores the connection string is the path of the database file. The
+"memory" type takes no connection string, copying to it checks that all
+the data in the source store can be read.
+
+Only identities are copied from a "legacy" store. For all other stores
+the identities, groups, group changes, elevations, audit events, ACLs,
+bakery root keys and identity provider data are copied. Undelivered
+webhook events are not copied. Data that is already in the destination
+store is not copied again, so an interrupted migration can be resumed
+by running the same command again.
+
+With -verify nothing is copied. Instead the data in the two stores is
+compared and any differences are printed, in which case the command
+exits with a non-zero status.
 
 `)
 	flag.PrintDefaults()
 }
 
 func migrate(ctx context.Context) error {
-	var source internal.Source
-	type_, addr := internal.SplitStoreSpecification(*from)
-	switch type_ {
-	case "legacy":
-		s, err := mgo.Dial(addr)
-		if err != nil {
-			return errgo.Notef(err, "cannot connnect to mongodb server")
+	fromType, fromAddr := internal.SplitStoreSpecification(*from)
+	if fromType == "legacy" {
+		if *verify {
+			return errgo.Newf("cannot verify a legacy store")
 		}
-		defer s.Close()
-		source = internal.NewLegacySource(s.DB(""))
+		return migrateLegacy(ctx, fromAddr)
+	}
+	src, err := openBackend(fromType, fromAddr)
+	if err != nil {
+		return errgo.Notef(err, "cannot open source")
+	}
+	defer src.Close()
+	dst, err := openBackend(internal.SplitStoreSpecification(*to))
+	if err != nil {
+		return errgo.Notef(err, "cannot open destination")
+	}
+	defer dst.Close()
+
+	if !*verify {
+		return errgo.Mask(internal.CopyBackend(ctx, dst, src))
+	}
+	diffs, err := internal.Verify(ctx, dst, src)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return errgo.Newf("found %d differences", len(diffs))
+	}
+	return nil
+}
+
+// migrateLegacy copies the identities from the legacy store at the
+// given address to the -to store.
+func migrateLegacy(ctx context.Context, addr string) error {
+	s, err := mgo.Dial(addr)
+	if err != nil {
+		return errgo.Notef(err, "cannot connnect to mongodb server")
+	}
+	defer s.Close()
+	source := internal.NewLegacySource(s.DB(""))
+
+	backend, err := openBackend(internal.SplitStoreSpecification(*to))
+	if err != nil {
+		return errgo.Notef(err, "cannot open destination")
+	}
+	defer backend.Close()
+	ctx, close := backend.Store().Context(ctx)
+	defer close()
+
+	return errgo.Mask(internal.Copy(ctx, backend.Store(), source))
+}
+
+// openBackend opens the backend of the given type at the given address.
+func openBackend(type_, addr string) (store.RawBackend, error) {
+	var backend store.Backend
+	var err error
+	switch type_ {
 	case "mgo":
 		s, err := mgo.Dial(addr)
 		if err != nil {
-			return errgo.Notef(err, "cannot connnect to mongodb server")
+			return nil, errgo.Notef(err, "cannot connnect to mongodb server")
 		}
+		// The backend uses its own copy of the session.
 		defer s.Close()
-		backend, err := mgostore.NewBackend(s.DB(""))
+		backend, err = mgostore.NewBackend(s.DB(""))
 		if err != nil {
-			return errgo.Notef(err, "cannot initialize mgo store")
+			return nil, errgo.Notef(err, "cannot initialize mgo store")
 		}
-		defer backend.Close()
-		source = internal.NewStoreSource(ctx, backend.Store())
 	case "postgres":
 		sqldb, err := sql.Open("postgres", addr)
 		if err != nil {
-			return errgo.Notef(err, "cannot connect to postgresql server")
+			return nil, errgo.Notef(err, "cannot connect to postgresql server")
 		}
-		defer sqldb.Close()
-		backend, err := sqlstore.NewBackend("postgres", sqldb)
+		backend, err = sqlstore.NewBackend("postgres", sqldb)
 		if err != nil {
-			return errgo.Notef(err, "cannot initialize postgresql database")
+			sqldb.Close()
+			return nil, errgo.Notef(err, "cannot initialize postgresql database")
 		}
-		defer backend.Close()
-		source = internal.NewStoreSource(ctx, backend.Store())
-	default:
-		return errgo.Newf("invalid source type %q", type_)
-	}
-
-	var store store.Store
-	type_, addr = internal.SplitStoreSpecification(*to)
-	switch type_ {
-	case "mgo":
-		s, err := mgo.Dial(addr)
+	case "sqlite":
+		backend, err = sqlstore.SQLiteParams{Path: addr}.NewBackend()
 		if err != nil {
-			return errgo.Notef(err, "cannot connnect to mongodb server")
+			return nil, errgo.Notef(err, "cannot initialize sqlite database")
 		}
-		defer s.Close()
-		backend, err := mgostore.NewBackend(s.DB(""))
+	case "bolt":
+		backend, err = boltstore.Params{Path: addr}.NewBackend()
 		if err != nil {
-			return errgo.Notef(err, "cannot initialize mgo store")
+			return nil, errgo.Notef(err, "cannot initialize bolt database")
 		}
-		defer backend.Close()
-		store = backend.Store()
-	case "postgres":
-		sqldb, err := sql.Open("postgres", addr)
-		if err != nil {
-			return errgo.Notef(err, "cannot connect to postgresql server")
-		}
-		defer sqldb.Close()
-		backend, err := sqlstore.NewBackend("postgres", sqldb)
-		if err != nil {
-			return errgo.Notef(err, "cannot initialize postgresql database")
-		}
-		defer backend.Close()
-		store = backend.Store()
+	case "memory":
+		backend = memstore.NewBackend()
 	default:
-		return errgo.Newf("invalid destination type %q", type_)
+		return nil, errgo.Newf("invalid store type %q", type_)
 	}
-
-	ctx, close := store.Context(ctx)
-	defer close()
-
-	return errgo.Mask(internal.Copy(ctx, store, source))
+	rb, ok := backend.(store.RawBackend)
+	if !ok {
+		backend.Close()
+		return nil, errgo.Newf("%s store does not support migration", type_)
+	}
+	return rb, nil
 }
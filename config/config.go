@@ -17,7 +17,10 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/oidcissuer"
+	"github.com/canonical/candid/policy"
 	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/webhook"
 )
 
 var logger = loggo.GetLogger("candid.config")
@@ -116,6 +119,127 @@ type Config struct {
 	// EnableEmailLogin enables the login with email address link on the
 	// authentication required page.
 	EnableEmailLogin bool `yaml:"enable-email-login"`
+
+	// AuditLog holds the configuration of the audit log. If this is
+	// not specified no audit events will be recorded.
+	AuditLog *AuditLogConfig `yaml:"audit-log"`
+
+	// OIDCClients holds the relying parties that may use Candid as
+	// an OpenID Connect provider.
+	OIDCClients []oidcissuer.Client `yaml:"oidc-clients"`
+
+	// OIDCTokenTimeout is the maximum age an OpenID Connect ID token
+	// or access token can get before it becomes invalid.
+	OIDCTokenTimeout DurationString `yaml:"oidc-token-timeout"`
+
+	// MaxElevationDuration is the longest time for which an approved
+	// elevation may grant membership of a group.
+	MaxElevationDuration DurationString `yaml:"max-elevation-duration"`
+
+	// DischargePolicy holds rules that decide whether a discharge is
+	// allowed and which additional caveats the discharge macaroon
+	// gets. The rules are evaluated in order and the first matching
+	// rule is used.
+	DischargePolicy []policy.Rule `yaml:"discharge-policy"`
+
+	// TrustedProxies holds the IP addresses, or CIDR networks, of
+	// the reverse proxies that are trusted to report the address
+	// of the client in the X-Forwarded-For header.
+	TrustedProxies []string `yaml:"trusted-proxies"`
+
+	// Reaper holds the policy for acting on inactive identities
+	// and orphaned agents. If this is not specified no action is
+	// taken.
+	Reaper *ReaperConfig `yaml:"reaper"`
+
+	// Webhooks holds the webhooks to which identity change events
+	// are sent.
+	Webhooks []webhook.Webhook `yaml:"webhooks"`
+}
+
+// Audit log types.
+const (
+	AuditLogFile   = "file"
+	AuditLogSyslog = "syslog"
+	AuditLogStore  = "store"
+)
+
+// AuditLogConfig holds the configuration of the audit log.
+type AuditLogConfig struct {
+	// Type holds where audit events are recorded. It must be one of
+	// "file", "syslog" or "store". Events recorded in the store can
+	// be retrieved with the /v1/audit endpoint.
+	Type string `yaml:"type"`
+
+	// Filename holds the name of the file that events are written
+	// to when the type is "file".
+	Filename string `yaml:"filename"`
+
+	// Network and Address hold the address of the syslog server
+	// that events are sent to when the type is "syslog", as used
+	// by syslog.Dial. If these are empty the local syslog server
+	// is used.
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+
+	// Tag holds the tag used for syslog messages. If this is empty
+	// "candid" is used.
+	Tag string `yaml:"tag"`
+}
+
+func (c *AuditLogConfig) validate() error {
+	switch c.Type {
+	case AuditLogFile:
+		if c.Filename == "" {
+			return errgo.Newf("missing audit-log filename in config file")
+		}
+	case AuditLogSyslog, AuditLogStore:
+	default:
+		return errgo.Newf("unrecognised audit-log type %q", c.Type)
+	}
+	return nil
+}
+
+// Reaper actions.
+const (
+	ReaperFlag    = "flag"
+	ReaperSuspend = "suspend"
+	ReaperDelete  = "delete"
+)
+
+// ReaperConfig holds the policy used by the identity reaper.
+type ReaperConfig struct {
+	// Interval holds the time between runs of the reaper. If this
+	// is not specified the reaper runs once a day.
+	Interval DurationString `yaml:"interval"`
+
+	// InactiveDays holds the number of days after which an
+	// identity that has neither logged in nor been discharged is
+	// considered inactive. If this is zero inactive identities are
+	// ignored.
+	InactiveDays int `yaml:"inactive-days"`
+
+	// InactiveAction holds the action taken on inactive
+	// identities. It must be one of "flag", "suspend" or "delete".
+	// If this is not specified "flag" is used, which only records
+	// the identity in the audit log and metrics.
+	InactiveAction string `yaml:"inactive-action"`
+
+	// RemoveOrphanedAgents holds whether agents whose owner no
+	// longer exists are deleted.
+	RemoveOrphanedAgents bool `yaml:"remove-orphaned-agents"`
+}
+
+func (c *ReaperConfig) validate() error {
+	if c.InactiveDays < 0 {
+		return errgo.Newf("invalid reaper inactive-days %d", c.InactiveDays)
+	}
+	switch c.InactiveAction {
+	case "", ReaperFlag, ReaperSuspend, ReaperDelete:
+	default:
+		return errgo.Newf("unrecognised reaper inactive-action %q", c.InactiveAction)
+	}
+	return nil
 }
 
 // TLSConfig returns a TLS configuration to be used for serving
@@ -162,6 +286,41 @@ func (c *Config) validate() error {
 	if len(missing) != 0 {
 		return errgo.Newf("missing fields %s in config file", strings.Join(missing, ", "))
 	}
+	if c.AuditLog != nil {
+		if err := c.AuditLog.validate(); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if c.Reaper != nil {
+		if err := c.Reaper.validate(); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if _, err := policy.New(c.DischargePolicy); err != nil {
+		return errgo.Notef(err, "invalid discharge-policy")
+	}
+	if _, err := policy.ParseNetworks(c.TrustedProxies); err != nil {
+		return errgo.Notef(err, "invalid trusted-proxies")
+	}
+	if err := webhook.Validate(c.Webhooks); err != nil {
+		return errgo.Notef(err, "invalid webhooks")
+	}
+	clientIDs := make(map[string]bool)
+	for _, oc := range c.OIDCClients {
+		if oc.ID == "" {
+			return errgo.Newf("missing oidc-clients client-id in config file")
+		}
+		if clientIDs[oc.ID] {
+			return errgo.Newf("duplicate oidc-clients client-id %q in config file", oc.ID)
+		}
+		clientIDs[oc.ID] = true
+		if oc.Secret == "" {
+			return errgo.Newf("missing client-secret for oidc client %q in config file", oc.ID)
+		}
+		if len(oc.RedirectURIs) == 0 {
+			return errgo.Newf("missing redirect-uris for oidc client %q in config file", oc.ID)
+		}
+	}
 	return nil
 }
 
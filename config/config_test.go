@@ -14,8 +14,12 @@ import (
 
 	"github.com/canonical/candid/config"
 	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/oidcissuer"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/policy"
 	"github.com/canonical/candid/store"
 	_ "github.com/canonical/candid/store/memstore"
+	"github.com/canonical/candid/webhook"
 )
 
 const testConfig = `
@@ -95,6 +99,38 @@ api-macaroon-timeout: 2h
 discharge-macaroon-timeout: 24h
 discharge-token-timeout: 6h
 enable-email-login: true
+audit-log:
+  type: file
+  filename: /var/log/candid/audit.log
+oidc-clients:
+- client-id: myservice
+  client-secret: s3cret
+  redirect-uris:
+  - https://myservice.example.com/callback
+oidc-token-timeout: 30m
+max-elevation-duration: 4h
+discharge-policy:
+- name: external
+  domains: [external]
+  source-addresses: [10.0.0.0/8]
+  time-of-day: 09:00-17:00
+  expiry: 1h
+  caveats:
+  - declared source external
+- name: contractors
+  groups: [contractors]
+  action: deny
+trusted-proxies: [10.1.0.1, 10.2.0.0/16]
+reaper:
+  interval: 12h
+  inactive-days: 90
+  inactive-action: suspend
+  remove-orphaned-agents: true
+webhooks:
+- name: groupcache
+  url: https://myservice.example.com/candid-events
+  secret: s3cret
+  events: [groups-changed, identity-deleted]
 `
 
 func readConfig(c *qt.C, content string) (*config.Config, error) {
@@ -175,6 +211,42 @@ func TestRead(t *testing.T) {
 		DischargeMacaroonTimeout: config.DurationString{Duration: 24 * time.Hour},
 		DischargeTokenTimeout:    config.DurationString{Duration: 6 * time.Hour},
 		EnableEmailLogin:         true,
+		AuditLog: &config.AuditLogConfig{
+			Type:     "file",
+			Filename: "/var/log/candid/audit.log",
+		},
+		OIDCClients: []oidcissuer.Client{{
+			ID:           "myservice",
+			Secret:       "s3cret",
+			RedirectURIs: []string{"https://myservice.example.com/callback"},
+		}},
+		OIDCTokenTimeout:     config.DurationString{Duration: 30 * time.Minute},
+		MaxElevationDuration: config.DurationString{Duration: 4 * time.Hour},
+		DischargePolicy: []policy.Rule{{
+			Name:            "external",
+			Domains:         []string{"external"},
+			SourceAddresses: []string{"10.0.0.0/8"},
+			TimeOfDay:       "09:00-17:00",
+			Expiry:          time.Hour,
+			Caveats:         []string{"declared source external"},
+		}, {
+			Name:   "contractors",
+			Groups: []string{"contractors"},
+			Action: "deny",
+		}},
+		TrustedProxies: []string{"10.1.0.1", "10.2.0.0/16"},
+		Reaper: &config.ReaperConfig{
+			Interval:             config.DurationString{Duration: 12 * time.Hour},
+			InactiveDays:         90,
+			InactiveAction:       "suspend",
+			RemoveOrphanedAgents: true,
+		},
+		Webhooks: []webhook.Webhook{{
+			Name:   "groupcache",
+			URL:    "https://myservice.example.com/candid-events",
+			Secret: "s3cret",
+			Events: []params.IdentityEventType{params.IdentityGroupsChanged, params.IdentityDeleted},
+		}},
 	})
 }
 
@@ -217,6 +289,198 @@ identity-providers:
 	c.Assert(cfg, qt.IsNil)
 }
 
+type configErrorTest struct {
+	about       string
+	config      string
+	expectError string
+}
+
+var auditLogErrorTests = []configErrorTest{{
+	about: "unknown type",
+	config: `
+audit-log:
+  type: nosuch
+`,
+	expectError: `unrecognised audit-log type "nosuch"`,
+}, {
+	about: "file without filename",
+	config: `
+audit-log:
+  type: file
+`,
+	expectError: `missing audit-log filename in config file`,
+}}
+
+func TestAuditLogErrors(t *testing.T) {
+	testConfigErrors(t, auditLogErrorTests)
+}
+
+var oidcClientErrorTests = []configErrorTest{{
+	about: "missing client-id",
+	config: `
+oidc-clients:
+- client-secret: s3cret
+  redirect-uris: [https://example.com/callback]
+`,
+	expectError: `missing oidc-clients client-id in config file`,
+}, {
+	about: "duplicate client-id",
+	config: `
+oidc-clients:
+- client-id: a
+  client-secret: s3cret
+  redirect-uris: [https://example.com/callback]
+- client-id: a
+  client-secret: s3cret
+  redirect-uris: [https://example.com/callback]
+`,
+	expectError: `duplicate oidc-clients client-id "a" in config file`,
+}, {
+	about: "missing client-secret",
+	config: `
+oidc-clients:
+- client-id: a
+  redirect-uris: [https://example.com/callback]
+`,
+	expectError: `missing client-secret for oidc client "a" in config file`,
+}, {
+	about: "missing redirect-uris",
+	config: `
+oidc-clients:
+- client-id: a
+  client-secret: s3cret
+`,
+	expectError: `missing redirect-uris for oidc client "a" in config file`,
+}}
+
+func TestOIDCClientErrors(t *testing.T) {
+	testConfigErrors(t, oidcClientErrorTests)
+}
+
+var reaperErrorTests = []configErrorTest{{
+	about: "unknown action",
+	config: `
+reaper:
+  inactive-days: 30
+  inactive-action: nosuch
+`,
+	expectError: `unrecognised reaper inactive-action "nosuch"`,
+}, {
+	about: "negative inactive-days",
+	config: `
+reaper:
+  inactive-days: -1
+`,
+	expectError: `invalid reaper inactive-days -1`,
+}}
+
+func TestReaperErrors(t *testing.T) {
+	testConfigErrors(t, reaperErrorTests)
+}
+
+var dischargePolicyErrorTests = []configErrorTest{{
+	about: "unknown action",
+	config: `
+discharge-policy:
+- name: r1
+  action: nosuch
+`,
+	expectError: `invalid discharge-policy: invalid rule r1: unrecognised action "nosuch"`,
+}, {
+	about: "invalid source address",
+	config: `
+discharge-policy:
+- name: r1
+- source-addresses: [nowhere]
+`,
+	expectError: `invalid discharge-policy: invalid rule #2: invalid source address "nowhere"`,
+}, {
+	about: "invalid trusted proxy",
+	config: `
+trusted-proxies: [10.0.0.0/33]
+`,
+	expectError: `invalid trusted-proxies: invalid source address "10.0.0.0/33"`,
+}}
+
+func TestDischargePolicyErrors(t *testing.T) {
+	testConfigErrors(t, dischargePolicyErrorTests)
+}
+
+var webhookErrorTests = []configErrorTest{{
+	about: "missing name",
+	config: `
+webhooks:
+- url: https://example.com/hook
+  secret: s3cret
+`,
+	expectError: `invalid webhooks: webhook 0: missing name`,
+}, {
+	about: "duplicate name",
+	config: `
+webhooks:
+- name: h1
+  url: https://example.com/hook1
+  secret: s3cret
+- name: h1
+  url: https://example.com/hook2
+  secret: s3cret
+`,
+	expectError: `invalid webhooks: webhook h1: duplicate name`,
+}, {
+	about: "invalid url",
+	config: `
+webhooks:
+- name: h1
+  url: example.com/hook
+  secret: s3cret
+`,
+	expectError: `invalid webhooks: webhook h1: invalid url "example.com/hook"`,
+}, {
+	about: "missing secret",
+	config: `
+webhooks:
+- name: h1
+  url: https://example.com/hook
+`,
+	expectError: `invalid webhooks: webhook h1: missing secret`,
+}, {
+	about: "unknown event",
+	config: `
+webhooks:
+- name: h1
+  url: https://example.com/hook
+  secret: s3cret
+  events: [nosuch]
+`,
+	expectError: `invalid webhooks: webhook h1: unknown event type "nosuch"`,
+}}
+
+func TestWebhookErrors(t *testing.T) {
+	testConfigErrors(t, webhookErrorTests)
+}
+
+func testConfigErrors(t *testing.T, tests []configErrorTest) {
+	c := qt.New(t)
+	defer c.Done()
+
+	store.Register("test", testStorageBackend)
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			cfg, err := readConfig(c, `
+listen-address: 1.2.3.4:5678
+private-key: 8PjzjakvIlh3BVFKe8axinRDutF6EDIfjtuf4+JaNow=
+public-key: CIdWcEUN+0OZnKW9KwruRQnQDY/qqzVdD30CijwiWCk=
+location: http://foo.com:1234
+private-addr: localhost
+storage:
+  type: test
+`+test.config)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(cfg, qt.IsNil)
+		})
+	}
+}
+
 type identityProvider struct {
 	idp.IdentityProvider
 	Params map[string]string
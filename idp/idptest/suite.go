@@ -246,6 +246,12 @@ func (s *Fixture) AssertLoginNotComplete(c *qt.C) {
 	c.Assert(s.visitCompleter.called, qt.Equals, false)
 }
 
+// ResetLogin clears the result of any previous login attempt so that
+// another login can be attempted.
+func (s *Fixture) ResetLogin() {
+	*s.visitCompleter = visitCompleter{c: s.visitCompleter.c}
+}
+
 type visitCompleter struct {
 	c           *qt.C
 	called      bool
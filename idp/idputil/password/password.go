@@ -0,0 +1,300 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package password contains functions for creating and verifying
+// password hashes. Hashes are encoded as strings with a prefix that
+// identifies the algorithm used to create them, so that they can be
+// stored in configuration files alongside (or instead of) plaintext
+// passwords.
+//
+// The following encodings are understood:
+//
+//	$2a$, $2b$, $2y$    bcrypt (see golang.org/x/crypto/bcrypt).
+//	$argon2id$          argon2id, in the PHC string format
+//	                    ($argon2id$v=19$m=<mem>,t=<time>,p=<threads>$<salt>$<hash>).
+//	$scrypt$            scrypt, in the PHC string format
+//	                    ($scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>).
+//
+// Salts and hashes in the PHC encodings are base64 encoded without
+// padding.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/errgo.v1"
+)
+
+var (
+	// ErrMismatch is the cause of the error returned from Verify
+	// when the password does not match the hash.
+	ErrMismatch = errgo.New("password does not match")
+
+	// ErrUnknownFormat is the cause of the error returned when a hash
+	// is not in a recognised format.
+	ErrUnknownFormat = errgo.New("unrecognised password hash format")
+)
+
+// An Algorithm is the name of a password hashing algorithm.
+type Algorithm string
+
+const (
+	Bcrypt   Algorithm = "bcrypt"
+	Argon2id Algorithm = "argon2id"
+	Scrypt   Algorithm = "scrypt"
+)
+
+// DefaultAlgorithm is the algorithm used when none is specified.
+const DefaultAlgorithm = Bcrypt
+
+// Parameters used when creating new hashes. These follow the current
+// recommendations for interactive logins.
+const (
+	bcryptCost = 12
+
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+
+	scryptLogN   = 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+
+	saltLen = 16
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hash returns an encoded hash of the given password using the given
+// algorithm. If alg is empty then DefaultAlgorithm is used.
+func Hash(alg Algorithm, password string) (string, error) {
+	if alg == "" {
+		alg = DefaultAlgorithm
+	}
+	switch alg {
+	case Bcrypt:
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		return string(h), nil
+	case Argon2id:
+		salt, err := newSalt()
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case Scrypt:
+		salt, err := newSalt()
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		key, err := scrypt.Key([]byte(password), salt, 1<<scryptLogN, scryptR, scryptP, scryptKeyLen)
+		if err != nil {
+			return "", errgo.Mask(err)
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", scryptLogN, scryptR, scryptP, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	}
+	return "", errgo.Newf("unsupported password hash algorithm %q", alg)
+}
+
+// IsHash reports whether the given string has the prefix of a
+// recognised password hash encoding. It does not check that the rest
+// of the hash is well formed.
+func IsHash(s string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$", "$scrypt$"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the given password against the given encoded hash. If
+// the password does not match then an error with a cause of
+// ErrMismatch is returned. If the hash is not in a recognised format
+// then an error with a cause of ErrUnknownFormat is returned. The
+// comparison of the derived key is always performed in constant time.
+func Verify(hash, password string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return errgo.WithCausef(nil, ErrMismatch, "")
+		}
+		if err != nil {
+			return errgo.WithCausef(err, ErrUnknownFormat, "invalid bcrypt hash")
+		}
+		return nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return errgo.Mask(verifyArgon2id(hash, password), errgo.Is(ErrMismatch), errgo.Is(ErrUnknownFormat))
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return errgo.Mask(verifyScrypt(hash, password), errgo.Is(ErrMismatch), errgo.Is(ErrUnknownFormat))
+	}
+	return errgo.WithCausef(nil, ErrUnknownFormat, "")
+}
+
+// Limits on the parameters accepted in argon2id and scrypt hashes.
+// Verifying a hash with larger parameters would use an unreasonable
+// amount of time or memory, so such hashes are rejected as being in an
+// unknown format.
+const (
+	maxArgon2Time    = 16
+	maxArgon2Memory  = 1024 * 1024 // KiB
+	maxArgon2Threads = 64
+
+	maxScryptLogN = 20
+	maxScryptR    = 32
+	maxScryptP    = 16
+
+	// maxScryptMemory is the largest amount of memory, in bytes,
+	// that scrypt may use (128 * r * N).
+	maxScryptMemory = 1 << 30
+
+	maxKeyLen = 128
+)
+
+// Validate checks that the given string is an encoded hash in a
+// recognised format with acceptable parameters, without verifying a
+// password against it. If it is not then an error with a cause of
+// ErrUnknownFormat is returned.
+func Validate(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return errgo.WithCausef(err, ErrUnknownFormat, "invalid bcrypt hash")
+		}
+		return nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, err := parseArgon2id(hash)
+		return errgo.Mask(err, errgo.Is(ErrUnknownFormat))
+	case strings.HasPrefix(hash, "$scrypt$"):
+		_, err := parseScrypt(hash)
+		return errgo.Mask(err, errgo.Is(ErrUnknownFormat))
+	}
+	return errgo.WithCausef(nil, ErrUnknownFormat, "")
+}
+
+// argon2idHash holds the parsed form of an argon2id hash.
+type argon2idHash struct {
+	memory, time uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+func parseArgon2id(hash string) (*argon2idHash, error) {
+	// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "unsupported argon2id version %q", parts[2])
+	}
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "invalid argon2id parameters %q", parts[3])
+	}
+	if h.time == 0 || h.time > maxArgon2Time || h.threads == 0 || h.threads > maxArgon2Threads || h.memory == 0 || h.memory > maxArgon2Memory {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "unsupported argon2id parameters %q", parts[3])
+	}
+	var err error
+	h.salt, h.key, err = decodeSaltAndKey(parts[4], parts[5])
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrUnknownFormat, "invalid argon2id hash")
+	}
+	return &h, nil
+}
+
+func verifyArgon2id(hash, password string) error {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrUnknownFormat))
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return compareKeys(h.key, key)
+}
+
+// scryptHash holds the parsed form of an scrypt hash.
+type scryptHash struct {
+	logN      uint
+	r, p      int
+	salt, key []byte
+}
+
+func parseScrypt(hash string) (*scryptHash, error) {
+	// $scrypt$ln=15,r=8,p=1$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "invalid scrypt hash")
+	}
+	var h scryptHash
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &h.logN, &h.r, &h.p); err != nil {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "invalid scrypt parameters %q", parts[2])
+	}
+	if h.logN == 0 || h.logN > maxScryptLogN || h.r <= 0 || h.r > maxScryptR || h.p <= 0 || h.p > maxScryptP || 128*h.r<<h.logN > maxScryptMemory {
+		return nil, errgo.WithCausef(nil, ErrUnknownFormat, "unsupported scrypt parameters %q", parts[2])
+	}
+	var err error
+	h.salt, h.key, err = decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return nil, errgo.WithCausef(err, ErrUnknownFormat, "invalid scrypt hash")
+	}
+	return &h, nil
+}
+
+func verifyScrypt(hash, password string) error {
+	h, err := parseScrypt(hash)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrUnknownFormat))
+	}
+	key, err := scrypt.Key([]byte(password), h.salt, 1<<h.logN, h.r, h.p, len(h.key))
+	if err != nil {
+		return errgo.WithCausef(err, ErrUnknownFormat, "invalid scrypt hash")
+	}
+	return compareKeys(h.key, key)
+}
+
+func decodeSaltAndKey(salt, key string) ([]byte, []byte, error) {
+	salt1, err := b64.DecodeString(salt)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot decode salt")
+	}
+	key1, err := b64.DecodeString(key)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot decode key")
+	}
+	if len(key1) == 0 {
+		return nil, nil, errgo.New("empty key")
+	}
+	if len(key1) > maxKeyLen {
+		return nil, nil, errgo.New("key too long")
+	}
+	return salt1, key1, nil
+}
+
+func compareKeys(expect, got []byte) error {
+	if subtle.ConstantTimeCompare(expect, got) != 1 {
+		return errgo.WithCausef(nil, ErrMismatch, "")
+	}
+	return nil
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errgo.Notef(err, "cannot generate salt")
+	}
+	return salt, nil
+}
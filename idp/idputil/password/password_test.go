@@ -0,0 +1,217 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package password_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp/idputil/password"
+)
+
+var hashTests = []struct {
+	alg          password.Algorithm
+	expectPrefix string
+}{{
+	alg:          "",
+	expectPrefix: "$2a$12$",
+}, {
+	alg:          password.Bcrypt,
+	expectPrefix: "$2a$12$",
+}, {
+	alg:          password.Argon2id,
+	expectPrefix: "$argon2id$v=19$m=65536,t=1,p=4$",
+}, {
+	alg:          password.Scrypt,
+	expectPrefix: "$scrypt$ln=15,r=8,p=1$",
+}}
+
+func TestHash(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range hashTests {
+		c.Run(string(test.alg), func(c *qt.C) {
+			h, err := password.Hash(test.alg, "pass1")
+			c.Assert(err, qt.IsNil)
+			c.Assert(h[:len(test.expectPrefix)], qt.Equals, test.expectPrefix)
+			c.Assert(password.IsHash(h), qt.Equals, true)
+			err = password.Verify(h, "pass1")
+			c.Assert(err, qt.IsNil)
+			err = password.Verify(h, "pass2")
+			c.Assert(errgo.Cause(err), qt.Equals, password.ErrMismatch)
+
+			// Hashing the same password again uses a new salt.
+			h2, err := password.Hash(test.alg, "pass1")
+			c.Assert(err, qt.IsNil)
+			c.Assert(h2, qt.Not(qt.Equals), h)
+		})
+	}
+}
+
+func TestHashUnsupportedAlgorithm(t *testing.T) {
+	c := qt.New(t)
+	_, err := password.Hash("md5", "pass1")
+	c.Assert(err, qt.ErrorMatches, `unsupported password hash algorithm "md5"`)
+}
+
+var verifyTests = []struct {
+	about       string
+	hash        string
+	password    string
+	expectError string
+	expectCause error
+}{{
+	about:    "bcrypt 2b",
+	hash:     "$2b$04$/YOjG9lmrq36e7jgjKSCDusin3RbbiFpUoj6O9KtGq68WHINmgaT2",
+	password: "pass1",
+}, {
+	about:    "bcrypt 2y",
+	hash:     "$2y$04$/YOjG9lmrq36e7jgjKSCDusin3RbbiFpUoj6O9KtGq68WHINmgaT2",
+	password: "pass1",
+}, {
+	about:       "bcrypt mismatch",
+	hash:        "$2b$04$/YOjG9lmrq36e7jgjKSCDusin3RbbiFpUoj6O9KtGq68WHINmgaT2",
+	password:    "pass2",
+	expectError: `password does not match`,
+	expectCause: password.ErrMismatch,
+}, {
+	about:       "bcrypt invalid",
+	hash:        "$2b$04$short",
+	password:    "pass1",
+	expectError: `invalid bcrypt hash: .*`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:    "argon2id",
+	hash:     "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password: "pass1",
+}, {
+	about:       "argon2id mismatch",
+	hash:        "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass2",
+	expectError: `password does not match`,
+	expectCause: password.ErrMismatch,
+}, {
+	about:       "argon2id bad version",
+	hash:        "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `unsupported argon2id version "v=16"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id bad parameters",
+	hash:        "$argon2id$v=19$m=1024$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `invalid argon2id parameters "m=1024"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id bad salt",
+	hash:        "$argon2id$v=19$m=1024,t=1,p=1$!!!$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `invalid argon2id hash: cannot decode salt: .*`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id wrong number of fields",
+	hash:        "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0",
+	password:    "pass1",
+	expectError: `invalid argon2id hash`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id zero time",
+	hash:        "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `unsupported argon2id parameters "m=1024,t=0,p=1"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id zero threads",
+	hash:        "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `unsupported argon2id parameters "m=1024,t=1,p=0"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "argon2id too much memory",
+	hash:        "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	password:    "pass1",
+	expectError: `unsupported argon2id parameters "m=4294967295,t=1,p=1"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:    "scrypt",
+	hash:     "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+	password: "pass1",
+}, {
+	about:       "scrypt mismatch",
+	hash:        "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+	password:    "pass2",
+	expectError: `password does not match`,
+	expectCause: password.ErrMismatch,
+}, {
+	about:       "scrypt bad parameters",
+	hash:        "$scrypt$ln=4$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+	password:    "pass1",
+	expectError: `invalid scrypt parameters "ln=4"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "scrypt too much memory",
+	hash:        "$scrypt$ln=20,r=32,p=1$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+	password:    "pass1",
+	expectError: `unsupported scrypt parameters "ln=20,r=32,p=1"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "scrypt zero p",
+	hash:        "$scrypt$ln=4,r=8,p=0$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+	password:    "pass1",
+	expectError: `unsupported scrypt parameters "ln=4,r=8,p=0"`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "scrypt empty key",
+	hash:        "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0$",
+	password:    "pass1",
+	expectError: `invalid scrypt hash: empty key`,
+	expectCause: password.ErrUnknownFormat,
+}, {
+	about:       "plaintext",
+	hash:        "pass1",
+	password:    "pass1",
+	expectError: `unrecognised password hash format`,
+	expectCause: password.ErrUnknownFormat,
+}}
+
+func TestVerify(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range verifyTests {
+		c.Run(test.about, func(c *qt.C) {
+			err := password.Verify(test.hash, test.password)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range verifyTests {
+		c.Run(test.about, func(c *qt.C) {
+			err := password.Validate(test.hash)
+			if test.expectCause == password.ErrUnknownFormat {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, password.ErrUnknownFormat)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func TestIsHash(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(password.IsHash("$2b$04$4nKdgUu2wgUdMCSO.mtpQe"), qt.Equals, true)
+	c.Assert(password.IsHash("$argon2id$v=19$"), qt.Equals, true)
+	c.Assert(password.IsHash("$scrypt$ln=4"), qt.Equals, true)
+	c.Assert(password.IsHash("$argon2i$v=19$"), qt.Equals, false)
+	c.Assert(password.IsHash("pass1"), qt.Equals, false)
+	c.Assert(password.IsHash(""), qt.Equals, false)
+}
@@ -0,0 +1,428 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.idp.idputil.totp")
+
+const (
+	// SecretKey is the ProviderInfo key that holds the secret of an
+	// enrolled identity.
+	SecretKey = "totp-secret"
+
+	// Path is the path, relative to the identity provider's
+	// URLPrefix, of the second factor form.
+	Path = "/totp"
+
+	// formTemplate is the name of the template used to render the
+	// second factor form.
+	formTemplate = "totp-form"
+
+	// defaultIssuer is the issuer shown in authenticator applications
+	// if none is configured.
+	defaultIssuer = "Candid"
+
+	// maxAttempts is the number of codes that may be submitted for a
+	// single login before it is aborted.
+	maxAttempts = 5
+
+	// checkWindow is the period over which codes submitted for an
+	// identity in non-interactive logins are counted. No more than
+	// maxAttempts consecutive codes may be rejected within the window.
+	checkWindow = 15 * time.Minute
+
+	// lastCounterPrefix, attemptsPrefix and checkAttemptsPrefix are
+	// the prefixes of the keys in the key-value store that hold the
+	// counter of the most recently used code for an identity, the
+	// number of codes submitted for a login, and the number of codes
+	// submitted for an identity in non-interactive logins.
+	lastCounterPrefix   = "totp-last-counter-"
+	attemptsPrefix      = "totp-attempts-"
+	checkAttemptsPrefix = "totp-check-attempts-"
+)
+
+// Mode determines whether a second factor is used.
+type Mode string
+
+const (
+	// Disabled is the default mode, in which no second factor is
+	// used.
+	Disabled Mode = ""
+
+	// Optional allows users to enroll a second factor. Users that
+	// have enrolled must provide a code when they log in, other users
+	// are offered enrollment each time they log in.
+	Optional Mode = "optional"
+
+	// Required requires all users to enroll a second factor, and
+	// provide a code each time they log in.
+	Required Mode = "required"
+)
+
+// UnmarshalYAML implements yaml.Unmarshaler by checking that the mode
+// is a known value.
+func (m *Mode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return errgo.Mask(err)
+	}
+	switch Mode(s) {
+	case Disabled, Optional, Required:
+		*m = Mode(s)
+	case "disabled":
+		*m = Disabled
+	default:
+		return errgo.Newf("invalid totp mode %q", s)
+	}
+	return nil
+}
+
+// Params holds the second factor configuration of an identity
+// provider.
+type Params struct {
+	// Mode determines whether a second factor is used.
+	Mode Mode `yaml:"mode"`
+
+	// RequiredGroups contains a list of groups, the members of which
+	// are required to use a second factor even when the Mode is
+	// Optional.
+	RequiredGroups []string `yaml:"required-groups"`
+
+	// Issuer is the name shown against codes in authenticator
+	// applications. If this is not set "Candid" is used.
+	Issuer string `yaml:"issuer"`
+}
+
+// FormParams contains the parameters sent to the totp-form template.
+type FormParams struct {
+	// Action contains the action parameter for the form.
+	Action string
+
+	// Token contains an opaque value that must be returned in the
+	// "token" form field.
+	Token string
+
+	// Error contains an error message from the previous, failed,
+	// attempt.
+	Error string
+
+	// Secret contains the newly generated secret when the user is
+	// enrolling. If this is empty the user has already enrolled.
+	Secret string
+
+	// KeyURI contains an otpauth URI containing the newly generated
+	// secret, suitable for use as a link or rendering as a QR code,
+	// when the user is enrolling.
+	KeyURI template.URL
+
+	// Optional is set when the user may skip enrollment by
+	// submitting a non-empty "skip" form field.
+	Optional bool
+}
+
+// An Authenticator performs the second factor step of a login for an
+// identity provider. A password based identity provider that supports
+// a second factor should call Login once the password has been
+// verified and pass any requests for Path to Handle.
+type Authenticator struct {
+	params     Params
+	initParams idp.InitParams
+	getGroups  func(context.Context, *store.Identity) ([]string, error)
+}
+
+// NewAuthenticator creates a new Authenticator for an identity provider
+// initialized with the given parameters. The getGroups function is
+// used to determine group membership for the RequiredGroups parameter,
+// it would normally be the identity provider's GetGroups method.
+func NewAuthenticator(p Params, initParams idp.InitParams, getGroups func(context.Context, *store.Identity) ([]string, error)) *Authenticator {
+	if p.Issuer == "" {
+		p.Issuer = defaultIssuer
+	}
+	return &Authenticator{
+		params:     p,
+		initParams: initParams,
+		getGroups:  getGroups,
+	}
+}
+
+// loginToken holds the state of a partially completed login between
+// the first and second factors. It is encoded into the form so that
+// the second factor can only be attempted by the client that completed
+// the first.
+type loginToken struct {
+	ProviderID store.ProviderIdentity `json:"pid"`
+	State      string                 `json:"state"`
+	Expires    time.Time              `json:"expires"`
+	Secret     string                 `json:"secret,omitempty"`
+	Optional   bool                   `json:"optional,omitempty"`
+}
+
+// Login continues a login for the given identity, which has completed
+// the first factor. If no second factor is needed the login is
+// completed, otherwise the second factor form is written to w.
+func (a *Authenticator) Login(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState, id *store.Identity) {
+	if a.params.Mode == Disabled {
+		a.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+		return
+	}
+	if err := a.login(ctx, w, req, ls, id); err != nil {
+		a.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+	}
+}
+
+func (a *Authenticator) login(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState, id *store.Identity) error {
+	identity := store.Identity{
+		ProviderID: id.ProviderID,
+	}
+	if err := a.initParams.Store.Identity(ctx, &identity); err != nil {
+		return errgo.Mask(err)
+	}
+	t := loginToken{
+		ProviderID: id.ProviderID,
+		State:      ls.State,
+		Expires:    ls.Expires,
+	}
+	if secret(&identity) == "" {
+		required, err := a.required(ctx, &identity)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		t.Secret, err = NewSecret()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		t.Optional = !required
+	}
+	return errgo.Mask(a.writeForm(w, req.Form.Get("state"), &identity, t, ""))
+}
+
+// Handle handles a submission of the second factor form. If the
+// submission is successful the login is completed.
+func (a *Authenticator) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState) {
+	id, err := a.handle(ctx, w, req, ls)
+	if err != nil {
+		a.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+		return
+	}
+	if id != nil {
+		a.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+	}
+}
+
+func (a *Authenticator) handle(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState) (*store.Identity, error) {
+	if req.Method != "POST" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	}
+	var t loginToken
+	if err := a.initParams.Codec.Decode(req.Form.Get("token"), &t); err != nil {
+		logger.Infof("invalid login token: %s", err)
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid login token")
+	}
+	if t.State != ls.State || !t.Expires.Equal(ls.Expires) || time.Now().After(t.Expires) {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid login token")
+	}
+	id := store.Identity{
+		ProviderID: t.ProviderID,
+	}
+	if err := a.initParams.Store.Identity(ctx, &id); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !t.Optional || req.Form.Get("skip") == "" {
+		key := fmt.Sprintf("%s%s-%s-%d", attemptsPrefix, t.ProviderID, t.State, t.Expires.UnixNano())
+		if err := a.attempt(ctx, key, t.Expires); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+		}
+	}
+	if t.Secret == "" && secret(&id) == "" {
+		// The second factor was reset after the form was written,
+		// the user must enroll again.
+		required, err := a.required(ctx, &id)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		t.Secret, err = NewSecret()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		t.Optional = !required
+		return nil, errgo.Mask(a.writeForm(w, req.Form.Get("state"), &id, t, "second factor reset, enroll again"))
+	}
+	if t.Secret == "" {
+		err := a.check(ctx, &id, secret(&id), req.Form.Get("code"))
+		if err == nil {
+			return &id, nil
+		}
+		if errgo.Cause(err) != ErrInvalidCode {
+			return nil, errgo.Mask(err)
+		}
+		return nil, errgo.Mask(a.writeForm(w, req.Form.Get("state"), &id, t, err.Error()))
+	}
+	if t.Optional && req.Form.Get("skip") != "" {
+		return &id, nil
+	}
+	if err := a.check(ctx, &id, t.Secret, req.Form.Get("code")); err != nil {
+		if errgo.Cause(err) != ErrInvalidCode {
+			return nil, errgo.Mask(err)
+		}
+		return nil, errgo.Mask(a.writeForm(w, req.Form.Get("state"), &id, t, err.Error()))
+	}
+	id.ProviderInfo = map[string][]string{
+		SecretKey: {t.Secret},
+	}
+	if err := a.initParams.Store.UpdateIdentity(ctx, &id, store.Update{
+		store.ProviderInfo: store.Set,
+	}); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &id, nil
+}
+
+// Check checks the given code for the given identity when logging in
+// non-interactively. If the identity has not enrolled then the check
+// only succeeds if a second factor is not required. Once maxAttempts
+// consecutive codes have been rejected for an identity no further
+// codes are accepted until checkWindow has passed.
+func (a *Authenticator) Check(ctx context.Context, id *store.Identity, code string) error {
+	if a.params.Mode == Disabled {
+		return nil
+	}
+	identity := store.Identity{
+		ProviderID: id.ProviderID,
+	}
+	if err := a.initParams.Store.Identity(ctx, &identity); err != nil {
+		return errgo.Mask(err)
+	}
+	if s := secret(&identity); s != "" {
+		key := checkAttemptsPrefix + string(identity.ProviderID)
+		expire := time.Now().Add(checkWindow)
+		if err := a.attempt(ctx, key, expire); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+		}
+		err := a.check(ctx, &identity, s, code)
+		if err == nil {
+			// Only consecutive failures count towards the limit.
+			err = a.initParams.KeyValueStore.Set(ctx, key, []byte("0"), expire)
+			return errgo.Mask(err)
+		}
+		if errgo.Cause(err) == ErrInvalidCode {
+			return errgo.WithCausef(nil, params.ErrUnauthorized, "%s", err.Error())
+		}
+		return errgo.Mask(err)
+	}
+	required, err := a.required(ctx, &identity)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if required {
+		return errgo.WithCausef(nil, params.ErrUnauthorized, "second factor enrollment required, log in interactively to enroll")
+	}
+	return nil
+}
+
+// attempt records the submission of a code against the attempt counter
+// held in the given key, which expires at the given time. If
+// maxAttempts codes have already been submitted an error with a cause
+// of params.ErrUnauthorized is returned.
+func (a *Authenticator) attempt(ctx context.Context, key string, expire time.Time) error {
+	err := a.initParams.KeyValueStore.Update(ctx, key, expire, func(old []byte) ([]byte, error) {
+		n, _ := strconv.Atoi(string(old))
+		if n >= maxAttempts {
+			return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "too many attempts")
+		}
+		return []byte(strconv.Itoa(n + 1)), nil
+	})
+	return errgo.Mask(err, errgo.Is(params.ErrUnauthorized))
+}
+
+// check validates the given code against the given secret and records
+// its use so that it cannot be used again. The counter of the code is
+// compared with, and replaced by, a single atomic update so that
+// concurrent logins cannot both use the same code.
+func (a *Authenticator) check(ctx context.Context, id *store.Identity, secret, code string) error {
+	now := time.Now()
+	counter, err := Validate(secret, code, now)
+	if err != nil {
+		if errgo.Cause(err) == ErrInvalidCode {
+			return errgo.WithCausef(nil, ErrInvalidCode, "invalid code")
+		}
+		return errgo.Mask(err)
+	}
+	// Once the counter has expired no code it would reject can be
+	// valid.
+	expire := now.Add((2*Skew + 2) * Period)
+	err = a.initParams.KeyValueStore.Update(ctx, lastCounterPrefix+string(id.ProviderID), expire, func(old []byte) ([]byte, error) {
+		if n, err := strconv.ParseInt(string(old), 10, 64); err == nil && counter <= n {
+			return nil, errgo.WithCausef(nil, ErrInvalidCode, "code already used")
+		}
+		return []byte(strconv.FormatInt(counter, 10)), nil
+	})
+	return errgo.Mask(err, errgo.Is(ErrInvalidCode))
+}
+
+// required determines whether the given identity must use a second
+// factor.
+func (a *Authenticator) required(ctx context.Context, id *store.Identity) (bool, error) {
+	if a.params.Mode == Required {
+		return true, nil
+	}
+	if len(a.params.RequiredGroups) == 0 {
+		return false, nil
+	}
+	groups, err := a.getGroups(ctx, id)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	groups = append(groups, id.Groups...)
+	for _, rg := range a.params.RequiredGroups {
+		for _, g := range groups {
+			if g == rg {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (a *Authenticator) writeForm(w http.ResponseWriter, state string, id *store.Identity, t loginToken, errorMessage string) error {
+	token, err := a.initParams.Codec.Encode(t)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data := FormParams{
+		Action:   idputil.RedirectURL(a.initParams.URLPrefix, Path, state),
+		Token:    token,
+		Error:    errorMessage,
+		Optional: t.Optional,
+	}
+	if t.Secret != "" {
+		data.Secret = t.Secret
+		data.KeyURI = template.URL(KeyURI(a.params.Issuer, id.Username, t.Secret))
+	}
+	return errgo.Mask(a.initParams.Template.ExecuteTemplate(w, formTemplate, data))
+}
+
+// secret returns the enrolled secret of the given identity, or an empty
+// string if the identity has not enrolled.
+func secret(id *store.Identity) string {
+	if vs := id.ProviderInfo[SecretKey]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/yaml.v2"
+
+	"github.com/canonical/candid/idp/idputil/totp"
+)
+
+var unmarshalParamsTests = []struct {
+	about        string
+	yaml         string
+	expectParams totp.Params
+	expectError  string
+}{{
+	about:        "empty",
+	yaml:         `{}`,
+	expectParams: totp.Params{},
+}, {
+	about: "disabled",
+	yaml:  `mode: disabled`,
+	expectParams: totp.Params{
+		Mode: totp.Disabled,
+	},
+}, {
+	about: "optional",
+	yaml: `
+mode: optional
+required-groups: [admin, ops]
+issuer: Example
+`,
+	expectParams: totp.Params{
+		Mode:           totp.Optional,
+		RequiredGroups: []string{"admin", "ops"},
+		Issuer:         "Example",
+	},
+}, {
+	about: "required",
+	yaml:  `mode: required`,
+	expectParams: totp.Params{
+		Mode: totp.Required,
+	},
+}, {
+	about:       "invalid mode",
+	yaml:        `mode: sometimes`,
+	expectError: `invalid totp mode "sometimes"`,
+}}
+
+func TestUnmarshalParams(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range unmarshalParamsTests {
+		c.Run(test.about, func(c *qt.C) {
+			var p totp.Params
+			err := yaml.Unmarshal([]byte(test.yaml), &p)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(p, qt.DeepEquals, test.expectParams)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package totp implements time-based one-time passwords (RFC 6238) for
+// use as a second authentication factor by the password based identity
+// providers.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	// Period is the length of time for which each code is valid.
+	Period = 30 * time.Second
+
+	// Digits is the number of digits in each code.
+	Digits = 6
+
+	// Skew is the number of periods either side of the current one
+	// for which a code will still be accepted, to allow for clock
+	// drift and user delay.
+	Skew = 1
+
+	// modulus is 10^Digits.
+	modulus = 1000000
+
+	// secretLen is the length, in bytes, of generated secrets.
+	secretLen = 20
+)
+
+// ErrInvalidCode is the error cause returned when a code does not
+// match the secret.
+var ErrInvalidCode = errgo.New("invalid code")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a new random secret, encoded in base32 as
+// expected by authenticator applications.
+func NewSecret() (string, error) {
+	buf := make([]byte, secretLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errgo.Notef(err, "cannot generate secret")
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// Code returns the code generated from the given secret for the period
+// containing the given time.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate checks that the given code was generated from the given
+// secret within Skew periods of the given time. If it was, the counter
+// value of the period that matched is returned, this can be used to
+// prevent a code being used more than once. If the code does not match
+// an error with a cause of ErrInvalidCode is returned.
+func Validate(secret, c string, t time.Time) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	c = strings.Replace(c, " ", "", -1)
+	if len(c) != Digits {
+		return 0, errgo.WithCausef(nil, ErrInvalidCode, "")
+	}
+	now := counter(t)
+	for i := now - Skew; i <= now+Skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, i)), []byte(c)) == 1 {
+			return i, nil
+		}
+	}
+	return 0, errgo.WithCausef(nil, ErrInvalidCode, "")
+}
+
+// KeyURI returns an otpauth URI that can be used to configure an
+// authenticator application with the given secret. The issuer and
+// account are used by the application to label the code.
+func KeyURI(issuer, account, secret string) string {
+	v := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid secret")
+	}
+	if len(key) == 0 {
+		return nil, errgo.Newf("invalid secret: empty secret")
+	}
+	return key, nil
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// code implements the HOTP algorithm from RFC 4226.
+func code(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, v%modulus)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package totp_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp/idputil/totp"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 secret used in the test
+// vectors in RFC 6238 appendix B.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var codeTests = []struct {
+	t          int64
+	expectCode string
+}{{
+	t:          59,
+	expectCode: "287082",
+}, {
+	t:          1111111109,
+	expectCode: "081804",
+}, {
+	t:          1111111111,
+	expectCode: "050471",
+}, {
+	t:          1234567890,
+	expectCode: "005924",
+}, {
+	t:          2000000000,
+	expectCode: "279037",
+}, {
+	t:          20000000000,
+	expectCode: "353130",
+}}
+
+func TestCode(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range codeTests {
+		code, err := totp.Code(rfcSecret, time.Unix(test.t, 0))
+		c.Assert(err, qt.IsNil)
+		c.Check(code, qt.Equals, test.expectCode, qt.Commentf("t=%d", test.t))
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	c := qt.New(t)
+	_, err := totp.Code("not base32!", time.Now())
+	c.Assert(err, qt.ErrorMatches, `invalid secret: .*`)
+}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+	now := time.Unix(1111111109, 0)
+	counter, err := totp.Validate(rfcSecret, "081804", now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(counter, qt.Equals, int64(37037036))
+
+	// Codes from adjacent periods are accepted.
+	counter, err = totp.Validate(rfcSecret, "081804", now.Add(totp.Period))
+	c.Assert(err, qt.IsNil)
+	c.Assert(counter, qt.Equals, int64(37037036))
+	counter, err = totp.Validate(rfcSecret, "081804", now.Add(-totp.Period))
+	c.Assert(err, qt.IsNil)
+	c.Assert(counter, qt.Equals, int64(37037036))
+
+	// Spaces are ignored.
+	_, err = totp.Validate(rfcSecret, "081 804", now)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestValidateInvalidCode(t *testing.T) {
+	c := qt.New(t)
+	now := time.Unix(1111111109, 0)
+	for _, code := range []string{"", "081805", "81804", "0818040"} {
+		_, err := totp.Validate(rfcSecret, code, now)
+		c.Check(errgo.Cause(err), qt.Equals, totp.ErrInvalidCode, qt.Commentf("code %q", code))
+	}
+	_, err := totp.Validate(rfcSecret, "081804", now.Add(2*totp.Period))
+	c.Assert(errgo.Cause(err), qt.Equals, totp.ErrInvalidCode)
+}
+
+func TestValidateEmptySecret(t *testing.T) {
+	c := qt.New(t)
+	now := time.Now()
+	code, err := totp.Code("", now)
+	c.Assert(err, qt.ErrorMatches, `invalid secret: empty secret`)
+	_, err = totp.Validate("", code, now)
+	c.Assert(err, qt.ErrorMatches, `invalid secret: empty secret`)
+}
+
+func TestNewSecret(t *testing.T) {
+	c := qt.New(t)
+	s1, err := totp.NewSecret()
+	c.Assert(err, qt.IsNil)
+	c.Assert(s1, qt.HasLen, 32)
+	s2, err := totp.NewSecret()
+	c.Assert(err, qt.IsNil)
+	c.Assert(s2, qt.Not(qt.Equals), s1)
+
+	now := time.Now()
+	code, err := totp.Code(s1, now)
+	c.Assert(err, qt.IsNil)
+	_, err = totp.Validate(s1, code, now)
+	c.Assert(err, qt.IsNil)
+}
+
+func TestKeyURI(t *testing.T) {
+	c := qt.New(t)
+	u, err := url.Parse(totp.KeyURI("Candid", "bob", rfcSecret))
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.Scheme, qt.Equals, "otpauth")
+	c.Assert(u.Host, qt.Equals, "totp")
+	c.Assert(u.Path, qt.Equals, "/Candid:bob")
+	c.Assert(u.Query(), qt.DeepEquals, url.Values{
+		"secret":    {rfcSecret},
+		"issuer":    {"Candid"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	})
+}
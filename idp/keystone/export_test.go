@@ -3,4 +3,7 @@
 
 package keystone
 
-var KeystoneSchemaResponse = keystoneSchemaResponse
+var (
+	KeystoneSchemaResponse     = keystoneSchemaResponse
+	KeystoneTOTPSchemaResponse = keystoneTOTPSchemaResponse
+)
@@ -16,6 +16,7 @@ import (
 
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/idp/keystone/internal/keystone"
 	"github.com/canonical/candid/params"
 	"github.com/canonical/candid/store"
@@ -71,6 +72,10 @@ type Params struct {
 	// Hidden is set if the IDP should be hidden from interactive
 	// prompts.
 	Hidden bool `yaml:"hidden"`
+
+	// TOTP configures an optional second authentication factor. It
+	// is not used by the keystone_token identity provider.
+	TOTP totp.Params `yaml:"totp"`
 }
 
 // NewIdentityProvider creates an interactive keystone identity provider
@@ -101,6 +106,7 @@ type identityProvider struct {
 	params     Params
 	initParams idp.InitParams
 	client     *keystone.Client
+	totp       *totp.Authenticator
 }
 
 // Name implements idp.IdentityProvider.Name.
@@ -136,6 +142,7 @@ func (idp *identityProvider) Hidden() bool {
 // Init implements idp.IdentityProvider.Init.
 func (idp *identityProvider) Init(_ context.Context, params idp.InitParams) error {
 	idp.initParams = params
+	idp.totp = totp.NewAuthenticator(idp.params.TOTP, params, idp.GetGroups)
 	return nil
 }
 
@@ -177,8 +184,10 @@ func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter,
 			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
 		}
 		if id != nil {
-			idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+			idp.totp.Login(ctx, w, req, ls, id)
 		}
+	case totp.Path:
+		idp.totp.Handle(ctx, w, req, ls)
 	}
 }
 
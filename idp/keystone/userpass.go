@@ -18,6 +18,7 @@ import (
 
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/idp/keystone/internal/keystone"
 	"github.com/canonical/candid/params"
 )
@@ -57,7 +58,11 @@ func (idp *userpassIdentityProvider) SetInteraction(ierr *httpbakery.Error, disc
 // Handle implements idp.IdentityProvider.Handle.
 func (idp *userpassIdentityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
-		httprequest.WriteJSON(w, http.StatusOK, keystoneSchemaResponse)
+		if idp.params.TOTP.Mode == totp.Disabled {
+			httprequest.WriteJSON(w, http.StatusOK, keystoneSchemaResponse)
+		} else {
+			httprequest.WriteJSON(w, http.StatusOK, keystoneTOTPSchemaResponse)
+		}
 		return
 	}
 	var lr form.LoginRequest
@@ -65,7 +70,11 @@ func (idp *userpassIdentityProvider) Handle(ctx context.Context, w http.Response
 		idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal login request"))
 		return
 	}
-	frm, err := keystoneFieldsChecker.Coerce(lr.Body.Form, nil)
+	checker := keystoneFieldsChecker
+	if idp.params.TOTP.Mode != totp.Disabled {
+		checker = keystoneTOTPFieldsChecker
+	}
+	frm, err := checker.Coerce(lr.Body.Form, nil)
 	if err != nil {
 		idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), errgo.Notef(err, "cannot validate form"))
 		return
@@ -81,6 +90,11 @@ func (idp *userpassIdentityProvider) Handle(ctx context.Context, w http.Response
 		idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), errgo.Notef(err, "cannot validate form"))
 		return
 	}
+	code, _ := m["totp-code"].(string)
+	if err := idp.totp.Check(ctx, user, code); err != nil {
+		idp.initParams.VisitCompleter.Failure(ctx, w, req, idputil.DischargeID(req), errgo.Mask(err, errgo.Is(params.ErrUnauthorized)))
+		return
+	}
 	if strings.TrimPrefix(req.URL.Path, idp.initParams.URLPrefix) == "/interact" {
 		dt, err := idp.initParams.DischargeTokenCreator.DischargeToken(ctx, user)
 		if err != nil {
@@ -117,6 +131,24 @@ var keystoneFields = environschema.Fields{
 
 var keystoneFieldsChecker = schema.FieldMap(mustValidationSchema(keystoneFields))
 
+// keystoneTOTPFields are the fields used when a second factor is
+// configured.
+var keystoneTOTPFields = environschema.Fields{
+	"username": keystoneFields["username"],
+	"password": keystoneFields["password"],
+	"totp-code": environschema.Attr{
+		Description: "authenticator code (if enrolled)",
+		Type:        environschema.Tstring,
+		Secret:      true,
+	},
+}
+
+var keystoneTOTPSchemaResponse = form.SchemaResponse{
+	Schema: keystoneTOTPFields,
+}
+
+var keystoneTOTPFieldsChecker = schema.FieldMap(mustValidationSchema(keystoneTOTPFields))
+
 func mustValidationSchema(fields environschema.Fields) (schema.Fields, schema.Defaults) {
 	f, d, err := fields.ValidationSchema()
 	if err != nil {
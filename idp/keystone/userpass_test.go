@@ -10,6 +10,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
@@ -18,6 +19,8 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/canonical/candid/config"
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idputil/totp"
 	keystoneidp "github.com/canonical/candid/idp/keystone"
 	"github.com/canonical/candid/store"
 )
@@ -107,6 +110,114 @@ func (s *userpassSuite) TestKeystoneUserpassIdentityProviderHandleNoUsername(c *
 	s.idptest.AssertLoginFailureMatches(c, `cannot validate form: username: expected string, got nothing`)
 }
 
+// totpIDP creates a keystone_userpass identity provider that requires
+// a second factor.
+func (s *userpassSuite) totpIDP(c *qt.C) idp.IdentityProvider {
+	p := s.params
+	p.TOTP.Mode = totp.Required
+	i := keystoneidp.NewUserpassIdentityProvider(p)
+	err := i.Init(s.idptest.Ctx, s.idptest.InitParams(c, idpPrefix))
+	c.Assert(err, qt.IsNil)
+	return i
+}
+
+// enrollTOTP enrolls testuser with a new second factor secret, which
+// is returned.
+func (s *userpassSuite) enrollTOTP(c *qt.C) string {
+	secret, err := totp.NewSecret()
+	c.Assert(err, qt.IsNil)
+	err = s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("openstack", "abc@openstack"),
+		Username:   "testuser@openstack",
+		ProviderInfo: map[string][]string{
+			totp.SecretKey: {secret},
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	return secret
+}
+
+func (s *userpassSuite) postLogin(c *qt.C, i idp.IdentityProvider, login map[string]interface{}) {
+	body, err := json.Marshal(form.LoginBody{
+		Form: login,
+	})
+	c.Assert(err, qt.IsNil)
+	req, err := http.NewRequest("POST", "/login?did=1", bytes.NewReader(body))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	i.Handle(s.idptest.Ctx, rr, req)
+}
+
+func (s *userpassSuite) TestHandleTOTPSchema(c *qt.C) {
+	i := s.totpIDP(c)
+	req, err := http.NewRequest("GET", "https://idp.test/login?did=1", nil)
+	c.Assert(err, qt.IsNil)
+	rr := httptest.NewRecorder()
+	i.Handle(s.idptest.Ctx, rr, req)
+	s.idptest.AssertLoginNotComplete(c)
+	qthttptest.AssertJSONResponse(c, rr, http.StatusOK, keystoneidp.KeystoneTOTPSchemaResponse)
+}
+
+func (s *userpassSuite) TestHandleTOTP(c *qt.C) {
+	i := s.totpIDP(c)
+	secret := s.enrollTOTP(c)
+	code, err := totp.Code(secret, time.Now())
+	c.Assert(err, qt.IsNil)
+	s.postLogin(c, i, map[string]interface{}{
+		"username":  "testuser",
+		"password":  "testpass",
+		"totp-code": code,
+	})
+	s.idptest.AssertLoginSuccess(c, "testuser@openstack")
+}
+
+func (s *userpassSuite) TestHandleTOTPInvalidCode(c *qt.C) {
+	i := s.totpIDP(c)
+	s.enrollTOTP(c)
+	s.postLogin(c, i, map[string]interface{}{
+		"username":  "testuser",
+		"password":  "testpass",
+		"totp-code": "000000x",
+	})
+	s.idptest.AssertLoginFailureMatches(c, `invalid code`)
+}
+
+func (s *userpassSuite) TestHandleTOTPTooManyAttempts(c *qt.C) {
+	i := s.totpIDP(c)
+	secret := s.enrollTOTP(c)
+	for n := 0; n < 5; n++ {
+		s.postLogin(c, i, map[string]interface{}{
+			"username":  "testuser",
+			"password":  "testpass",
+			"totp-code": "000000x",
+		})
+		s.idptest.AssertLoginFailureMatches(c, `invalid code`)
+		s.idptest.ResetLogin()
+	}
+	// Even a valid code is rejected once the limit is reached.
+	code, err := totp.Code(secret, time.Now())
+	c.Assert(err, qt.IsNil)
+	s.postLogin(c, i, map[string]interface{}{
+		"username":  "testuser",
+		"password":  "testpass",
+		"totp-code": code,
+	})
+	s.idptest.AssertLoginFailureMatches(c, `too many attempts`)
+}
+
+func (s *userpassSuite) TestHandleTOTPNotEnrolled(c *qt.C) {
+	i := s.totpIDP(c)
+	s.postLogin(c, i, map[string]interface{}{
+		"username": "testuser",
+		"password": "testpass",
+	})
+	s.idptest.AssertLoginFailureMatches(c, `second factor enrollment required, log in interactively to enroll`)
+}
+
 func (s *userpassSuite) TestRegisterConfig(c *qt.C) {
 	input := `
 identity-providers:
@@ -24,6 +24,7 @@ import (
 
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/params"
 	"github.com/canonical/candid/store"
 )
@@ -95,6 +96,9 @@ type Params struct {
 	// Hidden is set if the IDP should be hidden from interactive
 	// prompts.
 	Hidden bool `yaml:"hidden"`
+
+	// TOTP configures an optional second authentication factor.
+	TOTP totp.Params `yaml:"totp"`
 }
 
 // UserQueryAttrs defines how user attributes are mapped to attributes in the
@@ -194,6 +198,7 @@ func NewIdentityProvider(p Params) (idp.IdentityProvider, error) {
 type identityProvider struct {
 	params     Params
 	initParams idp.InitParams
+	totp       *totp.Authenticator
 
 	dialLDAP  func(network, addr string) (ldapConn, error)
 	network   string
@@ -238,6 +243,7 @@ func (idp *identityProvider) Hidden() bool {
 // Init implements idp.IdentityProvider.Init.
 func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
 	idp.initParams = params
+	idp.totp = totp.NewAuthenticator(idp.params.TOTP, params, idp.GetGroups)
 	return nil
 }
 
@@ -311,8 +317,10 @@ func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter,
 			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
 		}
 		if id != nil {
-			idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+			idp.totp.Login(ctx, w, req, ls, id)
 		}
+	case totp.Path:
+		idp.totp.Handle(ctx, w, req, ls)
 	}
 }
 
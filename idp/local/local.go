@@ -0,0 +1,425 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package local contains an identity provider that validates users
+// against a database of users that is maintained by the candid
+// administrator. The user details are held in the identity store and
+// the password hashes in the provider's key value store, so users can
+// be added and removed without restarting the server.
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/password"
+	"github.com/canonical/candid/idp/idputil/totp"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.idp.local")
+
+func init() {
+	idp.Register("local", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal local parameters")
+		}
+		if p.Name == "" {
+			p.Name = "local"
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	Name string `yaml:"name"`
+
+	// Description is the description of the IDP shown to the user on
+	// the IDP selection page.
+	Description string `yaml:"description"`
+
+	// Icon contains the URL or path of an icon.
+	Icon string `yaml:"icon"`
+
+	// Domain is the domain with which all identities created by this
+	// identity provider will be tagged (not including the @ separator).
+	Domain string `yaml:"domain"`
+
+	// Hidden is set if the IDP should be hidden from interactive
+	// prompts.
+	Hidden bool `yaml:"hidden"`
+
+	// MatchEmailAddr is a regular expression that is used to determine if
+	// this identity provider can be used for a particular user email.
+	MatchEmailAddr string `yaml:"match-email-addr"`
+
+	// PasswordAlgorithm holds the algorithm used to hash new
+	// passwords. If this is empty then password.DefaultAlgorithm
+	// is used.
+	PasswordAlgorithm password.Algorithm `yaml:"password-algorithm"`
+
+	// TOTP configures an optional second authentication factor.
+	TOTP totp.Params `yaml:"totp"`
+}
+
+// NewIdentityProvider creates a new local identity provider.
+func NewIdentityProvider(p Params) *IdentityProvider {
+	if p.Description == "" {
+		p.Description = p.Name
+	}
+	if p.Icon == "" {
+		p.Icon = "/static/images/icons/default.svg"
+	}
+	var matchEmailAddr *regexp.Regexp
+	if p.MatchEmailAddr != "" {
+		var err error
+		matchEmailAddr, err = regexp.Compile(p.MatchEmailAddr)
+		if err != nil {
+			// if the email address matcher doesn't compile log the error but
+			// carry on. A regular expression that doesn't compile also doesn't
+			// match anything.
+			logger.Errorf("cannot compile match-email-addr regular expression: %s", err)
+		}
+	}
+	return &IdentityProvider{
+		params:         p,
+		matchEmailAddr: matchEmailAddr,
+	}
+}
+
+// IdentityProvider is an identity provider that authenticates users
+// held in a local user database. As well as implementing
+// idp.IdentityProvider it provides methods for managing the users in
+// the database.
+type IdentityProvider struct {
+	params         Params
+	initParams     idp.InitParams
+	matchEmailAddr *regexp.Regexp
+	totp           *totp.Authenticator
+}
+
+var _ idp.IdentityProvider = (*IdentityProvider)(nil)
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *IdentityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain.
+func (idp *IdentityProvider) Domain() string {
+	return idp.params.Domain
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *IdentityProvider) Description() string {
+	return idp.params.Description
+}
+
+// IconURL returns the URL of an icon for the identity provider.
+func (idp *IdentityProvider) IconURL() string {
+	return idputil.ServiceURL(idp.initParams.Location, idp.params.Icon)
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*IdentityProvider) Interactive() bool {
+	return true
+}
+
+// Hidden implements idp.IdentityProvider.Hidden.
+func (idp *IdentityProvider) Hidden() bool {
+	return idp.params.Hidden
+}
+
+// IsForEmailAddr returns true when the identity provider should be used
+// to identify a user with the given email address.
+func (idp *IdentityProvider) IsForEmailAddr(addr string) bool {
+	if idp.matchEmailAddr == nil {
+		return false
+	}
+	return idp.matchEmailAddr.MatchString(addr)
+}
+
+// Init implements idp.IdentityProvider.Init.
+func (idp *IdentityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	idp.totp = totp.NewAuthenticator(idp.params.TOTP, params, idp.GetGroups)
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *IdentityProvider) URL(state string) string {
+	return idputil.RedirectURL(idp.initParams.URLPrefix, "/login", state)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *IdentityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups. The local
+// identity provider has no groups of its own, all group membership is
+// held in the identity store.
+func (idp *IdentityProvider) GetGroups(ctx context.Context, identity *store.Identity) ([]string, error) {
+	return nil, nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *IdentityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var ls idputil.LoginState
+	if err := idp.initParams.Codec.Cookie(req, idputil.LoginCookieName, req.Form.Get("state"), &ls); err != nil {
+		logger.Infof("Invalid login state: %s", err)
+		idputil.BadRequestf(w, "Login failed: invalid login state")
+		return
+	}
+
+	switch strings.TrimPrefix(req.URL.Path, idp.initParams.URLPrefix) {
+	case "/login":
+		idpChoice := params.IDPChoiceDetails{
+			Domain:      idp.params.Domain,
+			Description: idp.params.Description,
+			Name:        idp.params.Name,
+			URL:         idp.URL(req.Form.Get("state")),
+		}
+		id, err := idputil.HandleLoginForm(ctx, w, req, idpChoice, idp.initParams.Template, idp.loginUser)
+		if err != nil {
+			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+		}
+		if id != nil {
+			idp.totp.Login(ctx, w, req, ls, id)
+		}
+	case totp.Path:
+		idp.totp.Handle(ctx, w, req, ls)
+	}
+}
+
+// dummyHash is verified against when a user does not exist so that the
+// time taken to reject a login does not reveal whether the user exists.
+const dummyHash = "$2a$12$ChyFYudDm/B5xfkDGFvdE.VBuv/wmlfL1hM254iWhMdKJcejcp/nC"
+
+func (idp *IdentityProvider) loginUser(ctx context.Context, user, pw string) (*store.Identity, error) {
+	cred, err := idp.credentials(ctx, user)
+	if err != nil && errgo.Cause(err) != params.ErrNotFound {
+		return nil, errgo.Mask(err)
+	}
+	if cred == nil {
+		password.Verify(dummyHash, pw)
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %q", user)
+	}
+	if err := password.Verify(cred.PasswordHash, pw); err != nil {
+		if errgo.Cause(err) != password.ErrMismatch {
+			logger.Errorf("cannot verify password for %q: %s", user, err)
+		}
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %q", user)
+	}
+	if cred.Disabled {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "user %q is disabled", user)
+	}
+	id := &store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.params.Name, user),
+	}
+	if err := idp.initParams.Store.Identity(ctx, id); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %q", user)
+		}
+		return nil, errgo.Mask(err)
+	}
+	return id, nil
+}
+
+// User holds the details of a user in a local identity provider.
+type User struct {
+	// Username holds the candid username of the user. If the
+	// identity provider has a domain then this must have the suffix
+	// "@" + domain.
+	Username string
+
+	// Name holds the full name of the user.
+	Name string
+
+	// Email holds the email address of the user.
+	Email string
+
+	// Groups holds the groups the user is a member of.
+	Groups []string
+
+	// Password holds the user's password. It is hashed before
+	// being stored.
+	Password string
+}
+
+// credentials holds the data stored for each user in the identity
+// provider's key value store.
+type credentials struct {
+	PasswordHash string `json:"password-hash"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// CreateUser adds a new user to the identity provider. If there is
+// already a user with the same username then an error with a cause of
+// params.ErrAlreadyExists is returned.
+func (idp *IdentityProvider) CreateUser(ctx context.Context, u User) error {
+	user, err := idp.localName(u.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if u.Password == "" {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "no password specified")
+	}
+	h, err := password.Hash(idp.params.PasswordAlgorithm, u.Password)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	buf, err := json.Marshal(credentials{PasswordHash: h})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = idp.initParams.KeyValueStore.Update(ctx, user, time.Time{}, func(old []byte) ([]byte, error) {
+		if len(old) > 0 {
+			return nil, errgo.WithCausef(nil, params.ErrAlreadyExists, "user %q already exists", u.Username)
+		}
+		return buf, nil
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrAlreadyExists))
+	}
+	err = idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.params.Name, user),
+		Username:   u.Username,
+		Name:       u.Name,
+		Email:      u.Email,
+		Groups:     u.Groups,
+	}, store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+		store.Email:    store.Set,
+		store.Groups:   store.Set,
+	})
+	if err != nil {
+		// Remove the credentials so that the user can be created
+		// again once the problem has been resolved.
+		if err := idp.removeCredentials(ctx, user); err != nil {
+			logger.Errorf("cannot remove credentials for %q: %s", u.Username, err)
+		}
+		if errgo.Cause(err) == store.ErrDuplicateUsername {
+			return errgo.WithCausef(err, params.ErrAlreadyExists, "")
+		}
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// SetPassword sets the password of the user with the given username.
+func (idp *IdentityProvider) SetPassword(ctx context.Context, username, pw string) error {
+	if pw == "" {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "no password specified")
+	}
+	h, err := password.Hash(idp.params.PasswordAlgorithm, pw)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = idp.updateCredentials(ctx, username, func(c *credentials) {
+		c.PasswordHash = h
+	})
+	return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+}
+
+// SetDisabled sets whether the user with the given username is
+// disabled. A disabled user cannot log in.
+func (idp *IdentityProvider) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	err := idp.updateCredentials(ctx, username, func(c *credentials) {
+		c.Disabled = disabled
+	})
+	return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+}
+
+// DeleteUser removes the user with the given username from the
+// identity provider. After this the user will no longer be able to log
+// in, the identity record for the user is retained in the identity
+// store.
+func (idp *IdentityProvider) DeleteUser(ctx context.Context, username string) error {
+	user, err := idp.localName(username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if _, err := idp.credentials(ctx, user); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	return errgo.Mask(idp.removeCredentials(ctx, user))
+}
+
+func (idp *IdentityProvider) updateCredentials(ctx context.Context, username string, f func(*credentials)) error {
+	user, err := idp.localName(username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	err = idp.initParams.KeyValueStore.Update(ctx, user, time.Time{}, func(old []byte) ([]byte, error) {
+		if len(old) == 0 {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", username)
+		}
+		var c credentials
+		if err := json.Unmarshal(old, &c); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal credentials")
+		}
+		f(&c)
+		return json.Marshal(c)
+	})
+	return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+}
+
+// credentials retrieves the stored credentials for the given local
+// user name. If there is no such user then an error with a cause of
+// params.ErrNotFound is returned.
+func (idp *IdentityProvider) credentials(ctx context.Context, user string) (*credentials, error) {
+	buf, err := idp.initParams.KeyValueStore.Get(ctx, user)
+	if errgo.Cause(err) == simplekv.ErrNotFound || err == nil && len(buf) == 0 {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", user)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var c credentials
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal credentials")
+	}
+	return &c, nil
+}
+
+// removeCredentials removes the stored credentials for the given local
+// user name. The key value store has no way to delete keys, so an empty
+// value is stored, which is treated as being not found, and the entry
+// is set to expire immediately.
+func (idp *IdentityProvider) removeCredentials(ctx context.Context, user string) error {
+	return errgo.Mask(idp.initParams.KeyValueStore.Set(ctx, user, nil, time.Now()))
+}
+
+// localName determines the name of the user within the identity
+// provider from the given candid username.
+func (idp *IdentityProvider) localName(username string) (string, error) {
+	user := username
+	if idp.params.Domain != "" {
+		user = strings.TrimSuffix(username, "@"+idp.params.Domain)
+		if user == username {
+			return "", errgo.WithCausef(nil, params.ErrBadRequest, `invalid username %q: must have the suffix "@%s"`, username, idp.params.Domain)
+		}
+	}
+	if user == "" || strings.Contains(user, "@") {
+		return "", errgo.WithCausef(nil, params.ErrBadRequest, "invalid username %q", username)
+	}
+	if idputil.ReservedUsernames[user] {
+		return "", errgo.WithCausef(nil, params.ErrBadRequest, "username %q is reserved", username)
+	}
+	return user, nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package local_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/canonical/candid/config"
+	"github.com/canonical/candid/idp/idptest"
+	"github.com/canonical/candid/idp/local"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+const idpPrefix = "https://idp.example.com"
+
+type localSuite struct {
+	idptest *idptest.Fixture
+}
+
+func TestLocal(t *testing.T) {
+	qtsuite.Run(qt.New(t), &localSuite{})
+}
+
+func (s *localSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+}
+
+func (s *localSuite) setupIdp(c *qt.C, params local.Params) *local.IdentityProvider {
+	i := local.NewIdentityProvider(params)
+	i.Init(context.TODO(), s.idptest.InitParams(c, idpPrefix))
+	return i
+}
+
+func (s *localSuite) createUser(c *qt.C, i *local.IdentityProvider, username string) {
+	err := i.CreateUser(s.idptest.Ctx, local.User{
+		Username: username,
+		Name:     "User One",
+		Email:    "user1@example.com",
+		Groups:   []string{"group1", "group2"},
+		Password: "pass1",
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestConfig(c *qt.C) {
+	configYaml := `
+identity-providers:
+ - type: local
+   domain: example
+`
+	var conf config.Config
+	err := yaml.Unmarshal([]byte(configYaml), &conf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+	c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "local")
+	c.Assert(conf.IdentityProviders[0].Domain(), qt.Equals, "example")
+	c.Assert(conf.IdentityProviders[0].Description(), qt.Equals, "local")
+}
+
+func (s *localSuite) TestInteractive(c *qt.C) {
+	i := local.NewIdentityProvider(local.Params{Name: "test"})
+	c.Assert(i.Interactive(), qt.Equals, true)
+}
+
+func (s *localSuite) TestHandle(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.IsNil)
+	candidtest.AssertEqualIdentity(c, id, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+		Username:   "user1",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		Groups:     []string{"group1", "group2"},
+	})
+}
+
+func (s *localSuite) TestHandleWithDomain(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test", Domain: "example"})
+	s.createUser(c, i, "user1@example")
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.IsNil)
+	candidtest.AssertEqualIdentity(c, id, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+		Username:   "user1@example",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		Groups:     []string{"group1", "group2"},
+	})
+}
+
+func (s *localSuite) TestHandleFailedLoginWrongPassword(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "wrong-pass"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+}
+
+func (s *localSuite) TestHandleFailedLoginUnknownUser(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("unknown", "pass1"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;unknown&#34;`)
+}
+
+func (s *localSuite) TestCreateUserPasswordAlgorithm(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test", PasswordAlgorithm: "argon2id"})
+	s.createUser(c, i, "user1")
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestCreateUserAlreadyExists(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	err := i.CreateUser(s.idptest.Ctx, local.User{
+		Username: "user1",
+		Password: "pass2",
+	})
+	c.Assert(err, qt.ErrorMatches, `user "user1" already exists`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+
+	// The original password is unchanged.
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestCreateUserDuplicateUsername(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "user1"),
+		Username:   "user1",
+	}, store.Update{store.Username: store.Set})
+	c.Assert(err, qt.IsNil)
+	err = i.CreateUser(s.idptest.Ctx, local.User{
+		Username: "user1",
+		Password: "pass1",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+
+	// The failed user can be created once the other identity has
+	// been renamed.
+	err = s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "user1"),
+		Username:   "user2",
+	}, store.Update{store.Username: store.Set})
+	c.Assert(err, qt.IsNil)
+	s.createUser(c, i, "user1")
+}
+
+var createUserErrorTests = []struct {
+	about       string
+	domain      string
+	user        local.User
+	expectError string
+}{{
+	about:       "no password",
+	user:        local.User{Username: "user1"},
+	expectError: `no password specified`,
+}, {
+	about:       "missing domain",
+	domain:      "example",
+	user:        local.User{Username: "user1", Password: "pass1"},
+	expectError: `invalid username "user1": must have the suffix "@example"`,
+}, {
+	about:       "wrong domain",
+	user:        local.User{Username: "user1@example", Password: "pass1"},
+	expectError: `invalid username "user1@example"`,
+}, {
+	about:       "empty username",
+	domain:      "example",
+	user:        local.User{Username: "@example", Password: "pass1"},
+	expectError: `invalid username "@example"`,
+}, {
+	about:       "reserved username",
+	user:        local.User{Username: "everyone", Password: "pass1"},
+	expectError: `username "everyone" is reserved`,
+}}
+
+func (s *localSuite) TestCreateUserError(c *qt.C) {
+	for _, test := range createUserErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			i := s.setupIdp(c, local.Params{Name: "test", Domain: test.domain})
+			err := i.CreateUser(s.idptest.Ctx, test.user)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+		})
+	}
+}
+
+func (s *localSuite) TestSetPassword(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	err := i.SetPassword(s.idptest.Ctx, "user1", "pass2")
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass2"))
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestSetPasswordNotFound(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	err := i.SetPassword(s.idptest.Ctx, "user1", "pass2")
+	c.Assert(err, qt.ErrorMatches, `user "user1" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *localSuite) TestSetPasswordEmpty(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	err := i.SetPassword(s.idptest.Ctx, "user1", "")
+	c.Assert(err, qt.ErrorMatches, `no password specified`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *localSuite) TestSetDisabled(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	err := i.SetDisabled(s.idptest.Ctx, "user1", true)
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.ErrorMatches, `user &#34;user1&#34; is disabled`)
+
+	// A disabled user doesn't learn that they are disabled without
+	// the correct password.
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass2"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+
+	err = i.SetDisabled(s.idptest.Ctx, "user1", false)
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestSetDisabledNotFound(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	err := i.SetDisabled(s.idptest.Ctx, "user1", true)
+	c.Assert(err, qt.ErrorMatches, `user "user1" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *localSuite) TestDeleteUser(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	err := i.DeleteUser(s.idptest.Ctx, "user1")
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+
+	err = i.DeleteUser(s.idptest.Ctx, "user1")
+	c.Assert(err, qt.ErrorMatches, `user "user1" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+
+	// The user can be created again.
+	err = i.CreateUser(s.idptest.Ctx, local.User{
+		Username: "user1",
+		Password: "pass2",
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass2"))
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *localSuite) TestGetGroups(c *qt.C) {
+	i := s.setupIdp(c, local.Params{Name: "test"})
+	s.createUser(c, i, "user1")
+	groups, err := i.GetGroups(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// XML namespaces and other well-known identifiers used by SAML.
+const (
+	protocolNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	httpRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	httpPostBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+)
+
+// entityDescriptor holds the parts of a SAML metadata document that are
+// used by the identity provider.
+type entityDescriptor struct {
+	XMLName           xml.Name           `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID          string             `xml:"entityID,attr"`
+	IDPSSODescriptors []idpSSODescriptor `xml:"IDPSSODescriptor"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors       []keyDescriptor `xml:"KeyDescriptor"`
+	SingleSignOnServices []endpoint      `xml:"SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// idpMetadata holds the details of the remote identity provider needed
+// to send requests to it and validate its responses.
+type idpMetadata struct {
+	entityID     string
+	certificates []*x509.Certificate
+	redirectURL  string
+	postURL      string
+}
+
+// fetchMetadata retrieves and parses the SAML metadata document at the
+// given URL.
+func fetchMetadata(ctx context.Context, url string) (*idpMetadata, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get metadata")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errgo.Newf("cannot get metadata: %s", resp.Status)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get metadata")
+	}
+	return parseMetadata(buf)
+}
+
+// parseMetadata parses the given SAML metadata document for an identity
+// provider.
+func parseMetadata(buf []byte) (*idpMetadata, error) {
+	var ed entityDescriptor
+	if err := xml.Unmarshal(buf, &ed); err != nil {
+		return nil, errgo.Notef(err, "cannot parse metadata")
+	}
+	if len(ed.IDPSSODescriptors) == 0 {
+		return nil, errgo.Newf("no IDPSSODescriptor in metadata")
+	}
+	md := &idpMetadata{
+		entityID: ed.EntityID,
+	}
+	for _, d := range ed.IDPSSODescriptors {
+		for _, kd := range d.KeyDescriptors {
+			if kd.Use != "" && kd.Use != "signing" {
+				continue
+			}
+			for _, data := range kd.Certificates {
+				cert, err := parseCertificate(data)
+				if err != nil {
+					return nil, errgo.Notef(err, "invalid certificate in metadata")
+				}
+				md.certificates = append(md.certificates, cert)
+			}
+		}
+		for _, ep := range d.SingleSignOnServices {
+			switch ep.Binding {
+			case httpRedirectBinding:
+				if md.redirectURL == "" {
+					md.redirectURL = ep.Location
+				}
+			case httpPostBinding:
+				if md.postURL == "" {
+					md.postURL = ep.Location
+				}
+			}
+		}
+	}
+	if len(md.certificates) == 0 {
+		return nil, errgo.Newf("no signing certificate in metadata")
+	}
+	if md.redirectURL == "" && md.postURL == "" {
+		return nil, errgo.Newf("no supported SingleSignOnService in metadata")
+	}
+	return md, nil
+}
+
+// parseCertificate parses a base64 encoded DER certificate, as found in
+// an X509Certificate element.
+func parseCertificate(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, errgo.Mask(err)
+}
+
+// parsePEMCertificates parses all the certificates in the given PEM
+// encoded data.
+func parsePEMCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errgo.Newf("no certificates found")
+	}
+	return certs, nil
+}
+
+// spEntityDescriptor is the metadata document describing candid as a
+// SAML service provider.
+type spEntityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool                     `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool                     `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string                   `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormat               string                   `xml:"NameIDFormat,omitempty"`
+	AssertionConsumerService   assertionConsumerService `xml:"AssertionConsumerService"`
+}
+
+type assertionConsumerService struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// authnRequest is a SAML AuthnRequest message.
+type authnRequest struct {
+	XMLName                     xml.Name      `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string        `xml:"ID,attr"`
+	Version                     string        `xml:"Version,attr"`
+	IssueInstant                time.Time     `xml:"IssueInstant,attr"`
+	Destination                 string        `xml:"Destination,attr"`
+	ProtocolBinding             string        `xml:"ProtocolBinding,attr"`
+	AssertionConsumerServiceURL string        `xml:"AssertionConsumerServiceURL,attr"`
+	Issuer                      issuer        `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                *nameIDPolicy `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy,omitempty"`
+}
+
+type issuer struct {
+	Value string `xml:",chardata"`
+}
+
+type nameIDPolicy struct {
+	Format      string `xml:"Format,attr,omitempty"`
+	AllowCreate bool   `xml:"AllowCreate,attr"`
+}
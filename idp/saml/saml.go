@@ -0,0 +1,901 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package saml is an identity provider that authenticates users using
+// a SAML 2.0 identity provider.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/juju/loggo"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.idp.saml")
+
+func init() {
+	idp.Register("saml", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal saml parameters")
+		}
+		if p.Name == "" {
+			return nil, errgo.Newf("name not specified")
+		}
+		if p.MetadataURL == "" {
+			if p.SSOURL == "" {
+				return nil, errgo.Newf("metadata-url or sso-url not specified")
+			}
+			if p.IDPEntityID == "" {
+				return nil, errgo.Newf("idp-entity-id not specified")
+			}
+			if p.Certificate == "" {
+				return nil, errgo.Newf("certificate not specified")
+			}
+		}
+		switch p.Binding {
+		case "", "redirect", "post":
+		default:
+			return nil, errgo.Newf("unsupported binding %q", p.Binding)
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+// Params holds the parameters for a SAML identity provider.
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	Name string `yaml:"name"`
+
+	// Description is the description that will be used with the
+	// identity provider. If this is not set then Name will be used.
+	Description string `yaml:"description"`
+
+	// Icon contains the URL or path of an icon.
+	Icon string `yaml:"icon"`
+
+	// Domain is the domain with which all identities created by this
+	// identity provider will be tagged (not including the @ separator).
+	Domain string `yaml:"domain"`
+
+	// Hidden is set if the IDP should be hidden from interactive
+	// prompts.
+	Hidden bool `yaml:"hidden"`
+
+	// MatchEmailAddr is a regular expression that is used to determine if
+	// this identity provider can be used for a particular user email.
+	MatchEmailAddr string `yaml:"match-email-addr"`
+
+	// EntityID is the entity ID that candid uses to identify itself as
+	// a service provider. If this is not set the URL of the service
+	// provider metadata will be used.
+	EntityID string `yaml:"entity-id"`
+
+	// MetadataURL is the URL of the SAML metadata published by the
+	// identity provider. If this is set then IDPEntityID, SSOURL
+	// and Certificate are determined from the metadata.
+	MetadataURL string `yaml:"metadata-url"`
+
+	// IDPEntityID is the entity ID of the identity provider. This is
+	// the expected issuer of all responses.
+	IDPEntityID string `yaml:"idp-entity-id"`
+
+	// SSOURL is the URL of the single sign-on service of the
+	// identity provider.
+	SSOURL string `yaml:"sso-url"`
+
+	// Certificate contains one or more PEM encoded certificates that
+	// the identity provider uses to sign its responses.
+	Certificate string `yaml:"certificate"`
+
+	// Binding is the binding used to send authentication requests to
+	// the identity provider, either "redirect" or "post". If this is
+	// not set then "redirect" will be used if the identity provider
+	// supports it. Responses are always received using the HTTP-POST
+	// binding.
+	Binding string `yaml:"binding"`
+
+	// NameIDFormat is the format of name identifier to request from
+	// the identity provider. If this is not set then no particular
+	// format is requested.
+	NameIDFormat string `yaml:"name-id-format"`
+
+	// UsernameAttribute is the name of the attribute that contains
+	// the user's preferred username. The default is "uid".
+	UsernameAttribute string `yaml:"username-attribute"`
+
+	// EmailAttribute is the name of the attribute that contains the
+	// user's email address. The default is "mail".
+	EmailAttribute string `yaml:"email-attribute"`
+
+	// NameAttribute is the name of the attribute that contains the
+	// user's full name. The default is "displayName".
+	NameAttribute string `yaml:"name-attribute"`
+
+	// GroupsAttribute is the name of the attribute that contains the
+	// groups that the user is a member of. If this is not set then
+	// no groups are taken from the identity provider.
+	GroupsAttribute string `yaml:"groups-attribute"`
+}
+
+// maxClockSkew is the tolerance allowed when checking the validity
+// period of an assertion.
+const maxClockSkew = 3 * time.Minute
+
+// requestTimeout is the length of time a user has to complete
+// authentication with the identity provider.
+const requestTimeout = 15 * time.Minute
+
+// requestKeyPrefix is the prefix for keys in the KeyValueStore that
+// hold the state of outstanding authentication requests.
+const requestKeyPrefix = "request-"
+
+// assertionKeyPrefix is the prefix for keys in the KeyValueStore that
+// record the IDs of assertions that have been used to log in.
+const assertionKeyPrefix = "assertion-"
+
+// NewIdentityProvider creates a new SAML identity provider.
+func NewIdentityProvider(params Params) idp.IdentityProvider {
+	if params.Description == "" {
+		params.Description = params.Name
+	}
+	if params.Icon == "" {
+		params.Icon = "/static/images/icons/default.svg"
+	}
+	if params.UsernameAttribute == "" {
+		params.UsernameAttribute = "uid"
+	}
+	if params.EmailAttribute == "" {
+		params.EmailAttribute = "mail"
+	}
+	if params.NameAttribute == "" {
+		params.NameAttribute = "displayName"
+	}
+
+	var matchEmailAddr *regexp.Regexp
+	if params.MatchEmailAddr != "" {
+		var err error
+		matchEmailAddr, err = regexp.Compile(params.MatchEmailAddr)
+		if err != nil {
+			// if the email address matcher doesn't compile log the error but
+			// carry on. A regular expression that doesn't compile also doesn't
+			// match anything.
+			logger.Errorf("cannot compile match-email-addr regular expression: %s", err)
+		}
+	}
+
+	return &identityProvider{
+		params:         params,
+		matchEmailAddr: matchEmailAddr,
+	}
+}
+
+type identityProvider struct {
+	params         Params
+	initParams     idp.InitParams
+	matchEmailAddr *regexp.Regexp
+	metadata       *idpMetadata
+}
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *identityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain.
+func (idp *identityProvider) Domain() string {
+	return idp.params.Domain
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *identityProvider) Description() string {
+	return idp.params.Description
+}
+
+// IconURL returns the URL of an icon for the identity provider.
+func (idp *identityProvider) IconURL() string {
+	return idputil.ServiceURL(idp.initParams.Location, idp.params.Icon)
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*identityProvider) Interactive() bool {
+	return true
+}
+
+// Hidden implements idp.IdentityProvider.Hidden.
+func (idp *identityProvider) Hidden() bool {
+	return idp.params.Hidden
+}
+
+// IsForEmailAddr returns true when the identity provider should be used
+// to identify a user with the given email address.
+func (idp *identityProvider) IsForEmailAddr(addr string) bool {
+	if idp.matchEmailAddr == nil {
+		return false
+	}
+	return idp.matchEmailAddr.MatchString(addr)
+}
+
+// Init implements idp.IdentityProvider.Init by determining the
+// details of the remote identity provider, either from its metadata
+// or from the configured parameters.
+func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	if idp.params.EntityID == "" {
+		idp.params.EntityID = idp.metadataURL()
+	}
+	if idp.params.MetadataURL != "" {
+		md, err := fetchMetadata(ctx, idp.params.MetadataURL)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		idp.metadata = md
+	} else {
+		certs, err := parsePEMCertificates(idp.params.Certificate)
+		if err != nil {
+			return errgo.Notef(err, "invalid certificate")
+		}
+		idp.metadata = &idpMetadata{
+			entityID:     idp.params.IDPEntityID,
+			certificates: certs,
+		}
+		if idp.params.Binding == "post" {
+			idp.metadata.postURL = idp.params.SSOURL
+		} else {
+			idp.metadata.redirectURL = idp.params.SSOURL
+		}
+	}
+	if idp.params.IDPEntityID != "" {
+		idp.metadata.entityID = idp.params.IDPEntityID
+	}
+	switch {
+	case idp.params.Binding == "redirect" && idp.metadata.redirectURL == "":
+		return errgo.Newf("identity provider does not support the redirect binding")
+	case idp.params.Binding == "post" && idp.metadata.postURL == "":
+		return errgo.Newf("identity provider does not support the post binding")
+	case idp.params.Binding == "" && idp.metadata.redirectURL != "":
+		idp.params.Binding = "redirect"
+	case idp.params.Binding == "":
+		idp.params.Binding = "post"
+	}
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *identityProvider) URL(state string) string {
+	return idputil.RedirectURL(idp.initParams.URLPrefix, "/login", state)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (*identityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups by returning the
+// groups sent by the identity provider when the user last logged in.
+func (*identityProvider) GetGroups(_ context.Context, identity *store.Identity) ([]string, error) {
+	return identity.ProviderInfo["groups"], nil
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/metadata":
+		idp.serveMetadata(w)
+		return
+	case "/acs":
+		// The identity provider posts its response from a different
+		// site, so the login cookie is not available. The login state
+		// is recovered from the outstanding request instead.
+		idp.acs(ctx, w, req)
+		return
+	}
+	var rs registrationState
+	if err := idp.initParams.Codec.Cookie(req, idputil.LoginCookieName, req.Form.Get("state"), &rs); err != nil {
+		logger.Infof("Invalid login state: %s", err)
+		idputil.BadRequestf(w, "Login failed: invalid login state")
+		return
+	}
+	switch req.URL.Path {
+	case "/register":
+		if err := idp.register(ctx, w, req, rs); err != nil {
+			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, rs.ReturnTo, rs.State, err)
+		}
+	default:
+		if err := idp.login(ctx, w, req, rs.LoginState); err != nil {
+			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, rs.ReturnTo, rs.State, err)
+		}
+	}
+}
+
+// registrationState holds the state of a login that requires the user
+// to register. It is stored in the login cookie.
+type registrationState struct {
+	idputil.LoginState
+
+	// Groups holds the groups sent by the identity provider, these
+	// are stored with the identity once it has been registered.
+	Groups []string `json:",omitempty"`
+}
+
+func (idp *identityProvider) metadataURL() string {
+	return idp.initParams.URLPrefix + "/metadata"
+}
+
+func (idp *identityProvider) acsURL() string {
+	return idp.initParams.URLPrefix + "/acs"
+}
+
+// serveMetadata writes the SAML metadata describing candid as a
+// service provider.
+func (idp *identityProvider) serveMetadata(w http.ResponseWriter) {
+	md := spEntityDescriptor{
+		EntityID: idp.params.EntityID,
+		SPSSODescriptor: spSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: protocolNamespace,
+			NameIDFormat:               idp.params.NameIDFormat,
+			AssertionConsumerService: assertionConsumerService{
+				Binding:  httpPostBinding,
+				Location: idp.acsURL(),
+				Index:    1,
+			},
+		},
+	}
+	buf, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		logger.Errorf("cannot marshal metadata: %s", err)
+		http.Error(w, "cannot marshal metadata", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(buf)
+}
+
+// login starts a login attempt by sending an authentication request
+// to the identity provider.
+func (idp *identityProvider) login(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState) error {
+	id, err := newRequestID()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	buf, err := json.Marshal(ls)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := idp.initParams.KeyValueStore.Set(ctx, requestKeyPrefix+id, buf, time.Now().Add(requestTimeout)); err != nil {
+		return errgo.Mask(err)
+	}
+	ar := authnRequest{
+		ID:                          id,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC(),
+		ProtocolBinding:             httpPostBinding,
+		AssertionConsumerServiceURL: idp.acsURL(),
+		Issuer:                      issuer{Value: idp.params.EntityID},
+		NameIDPolicy: &nameIDPolicy{
+			Format:      idp.params.NameIDFormat,
+			AllowCreate: true,
+		},
+	}
+	if idp.params.Binding == "post" {
+		ar.Destination = idp.metadata.postURL
+		buf, err := xml.Marshal(ar)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		w.Header().Set("Content-Type", "text/html;charset=utf-8")
+		return errgo.Mask(postFormTemplate.Execute(w, postForm{
+			URL:         ar.Destination,
+			SAMLRequest: base64.StdEncoding.EncodeToString(buf),
+			RelayState:  id,
+		}))
+	}
+	ar.Destination = idp.metadata.redirectURL
+	buf, err = xml.Marshal(ar)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	fw.Write(buf)
+	if err := fw.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	u, err := url.Parse(ar.Destination)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	q.Set("RelayState", id)
+	u.RawQuery = q.Encode()
+	http.Redirect(w, req, u.String(), http.StatusFound)
+	return nil
+}
+
+// acs is the assertion consumer service, it processes the response
+// sent by the identity provider.
+func (idp *identityProvider) acs(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	requestID := req.Form.Get("RelayState")
+	ls, err := idp.loginState(ctx, requestID)
+	if err != nil {
+		logger.Infof("Invalid login state: %s", err)
+		idputil.BadRequestf(w, "Login failed: invalid login state")
+		return
+	}
+	if err := idp.completeLogin(ctx, w, req, requestID, ls); err != nil {
+		idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+	}
+}
+
+// loginState retrieves the login state stored for the outstanding
+// request with the given ID. A request can only be used once.
+func (idp *identityProvider) loginState(ctx context.Context, requestID string) (idputil.LoginState, error) {
+	var ls idputil.LoginState
+	if requestID == "" {
+		return ls, errgo.Newf("no RelayState")
+	}
+	err := idp.initParams.KeyValueStore.Update(ctx, requestKeyPrefix+requestID, time.Now().Add(requestTimeout), func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, errgo.Newf("unknown request %q", requestID)
+		}
+		if err := json.Unmarshal(old, &ls); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if ls.ReturnTo == "" {
+			return nil, errgo.Newf("request %q already used", requestID)
+		}
+		return []byte("{}"), nil
+	})
+	if err != nil {
+		return idputil.LoginState{}, errgo.Mask(err)
+	}
+	if ls.Expires.Before(time.Now()) {
+		return idputil.LoginState{}, errgo.Newf("login expired")
+	}
+	return ls, nil
+}
+
+func (idp *identityProvider) completeLogin(ctx context.Context, w http.ResponseWriter, req *http.Request, requestID string, ls idputil.LoginState) error {
+	a, err := idp.parseResponse(req.Form.Get("SAMLResponse"), requestID, time.Now())
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := idp.consumeAssertion(ctx, a); err != nil {
+		return errgo.Mask(err)
+	}
+	user := store.Identity{
+		ProviderID: store.MakeProviderIdentity(idp.Name(), fmt.Sprintf("%s:%s", a.issuer, a.nameID)),
+		Name:       a.attribute(idp.params.NameAttribute),
+		Email:      a.attribute(idp.params.EmailAttribute),
+	}
+	if username := a.attribute(idp.params.UsernameAttribute); names.IsValidUserName(username) {
+		user.Username = joinDomain(username, idp.params.Domain)
+	}
+	var groups []string
+	if idp.params.GroupsAttribute != "" {
+		groups = a.attributes[idp.params.GroupsAttribute]
+		user.ProviderInfo = map[string][]string{"groups": groups}
+	}
+
+	existingUser := store.Identity{
+		ProviderID: user.ProviderID,
+	}
+	err = idp.initParams.Store.Identity(ctx, &existingUser)
+	if err == nil {
+		var upd store.Update
+		// A user exists check if it needs updating.
+		if user.Name != "" && existingUser.Name != user.Name {
+			existingUser.Name = user.Name
+			upd[store.Name] = store.Set
+		}
+		if user.Email != "" && existingUser.Email != user.Email {
+			existingUser.Email = user.Email
+			upd[store.Email] = store.Set
+		}
+		if user.ProviderInfo != nil {
+			existingUser.ProviderInfo = user.ProviderInfo
+			upd[store.ProviderInfo] = store.Set
+		}
+		if (upd != store.Update{}) {
+			err = idp.initParams.Store.UpdateIdentity(ctx, &existingUser, upd)
+		}
+		if err == nil {
+			idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, &existingUser)
+			return nil
+		}
+	}
+	if errgo.Cause(err) != store.ErrNotFound {
+		return errgo.Mask(err)
+	}
+
+	// The user needs to be created.
+	if user.Username != "" {
+		// Attempt to create a user with the preferred username.
+		err := idp.initParams.Store.UpdateIdentity(ctx, &user, store.Update{
+			store.Username:     store.Set,
+			store.Name:         store.Set,
+			store.Email:        store.Set,
+			store.ProviderInfo: store.Set,
+		})
+		if err == nil {
+			idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, &user)
+			return nil
+		}
+		if errgo.Cause(err) != store.ErrDuplicateUsername {
+			return errgo.Mask(err)
+		}
+	}
+
+	// The user needs to register.
+	ls.ProviderID = user.ProviderID
+	cookiePath := idputil.CookiePathRelativeToLocation(idputil.LoginCookiePath, idp.initParams.Location, idp.initParams.SkipLocationForCookiePaths)
+	state, err := idp.initParams.Codec.SetCookie(w, idputil.LoginCookieName, cookiePath, registrationState{
+		LoginState: ls,
+		Groups:     groups,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    state,
+		Domain:   idp.params.Domain,
+		FullName: user.Name,
+		Email:    user.Email,
+	}, idp.initParams.Template))
+}
+
+func (idp *identityProvider) register(ctx context.Context, w http.ResponseWriter, req *http.Request, rs registrationState) error {
+	u := &store.Identity{
+		ProviderID: rs.ProviderID,
+		Name:       req.Form.Get("fullname"),
+		Email:      req.Form.Get("email"),
+	}
+	if idp.params.GroupsAttribute != "" {
+		u.ProviderInfo = map[string][]string{"groups": rs.Groups}
+	}
+	err := idp.registerUser(ctx, req.Form.Get("username"), u)
+	if err == nil {
+		idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, rs.ReturnTo, rs.State, u)
+		return nil
+	}
+	if errgo.Cause(err) != errInvalidUser {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(idputil.RegistrationForm(ctx, w, idputil.RegistrationParams{
+		State:    req.Form.Get("state"),
+		Error:    err.Error(),
+		Username: req.Form.Get("username"),
+		Domain:   idp.params.Domain,
+		FullName: req.Form.Get("fullname"),
+		Email:    req.Form.Get("email"),
+	}, idp.initParams.Template))
+}
+
+var errInvalidUser = errgo.New("invalid user")
+
+func (idp *identityProvider) registerUser(ctx context.Context, username string, u *store.Identity) error {
+	if !names.IsValidUserName(username) {
+		return errgo.WithCausef(nil, errInvalidUser, "invalid user name. The username must contain only A-Z, a-z, 0-9, '.', '-', & '+', and must start and end with a letter or number.")
+	}
+	if idputil.ReservedUsernames[username] {
+		return errgo.WithCausef(nil, errInvalidUser, "username %s is not allowed, please choose another.", username)
+	}
+	u.Username = joinDomain(username, idp.params.Domain)
+	err := idp.initParams.Store.UpdateIdentity(ctx, u, store.Update{
+		store.Username:     store.Set,
+		store.Name:         store.Set,
+		store.Email:        store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	if err == nil {
+		return nil
+	}
+	if errgo.Cause(err) != store.ErrDuplicateUsername {
+		return errgo.Mask(err)
+	}
+	return errgo.WithCausef(nil, errInvalidUser, "Username already taken, please pick a different one.")
+}
+
+// consumeAssertion records that the given assertion has been used. An
+// assertion can only be used once, the record is kept until the
+// assertion can no longer be confirmed.
+func (idp *identityProvider) consumeAssertion(ctx context.Context, a *assertion) error {
+	err := idp.initParams.KeyValueStore.Update(ctx, assertionKeyPrefix+a.id, a.expires.Add(maxClockSkew), func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, errgo.Newf("assertion %q already used", a.id)
+		}
+		return []byte(a.expires.Format(time.RFC3339)), nil
+	})
+	return errgo.Mask(err)
+}
+
+// assertion holds the information taken from a validated SAML
+// assertion.
+type assertion struct {
+	id         string
+	issuer     string
+	nameID     string
+	expires    time.Time
+	attributes map[string][]string
+}
+
+// attribute returns the first value of the given attribute.
+func (a *assertion) attribute(name string) string {
+	if vs := a.attributes[name]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// parseResponse decodes and validates the given base64 encoded SAML
+// response, which must be in response to the request with the given ID.
+// Only information from a signed part of the response is returned.
+func (idp *identityProvider) parseResponse(samlResponse, requestID string, now time.Time) (*assertion, error) {
+	if samlResponse == "" {
+		return nil, errgo.Newf("no SAMLResponse")
+	}
+	buf, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(samlResponse), ""))
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot decode SAMLResponse")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf); err != nil {
+		return nil, errgo.Notef(err, "cannot parse SAMLResponse")
+	}
+	resp := doc.Root()
+	if resp == nil || resp.Tag != "Response" {
+		return nil, errgo.Newf("SAMLResponse does not contain a Response")
+	}
+	if dest := resp.SelectAttrValue("Destination", ""); dest != "" && dest != idp.acsURL() {
+		return nil, errgo.Newf("unexpected response destination %q", dest)
+	}
+	if irt := resp.SelectAttrValue("InResponseTo", ""); irt != requestID {
+		return nil, errgo.Newf("unexpected response to request %q", irt)
+	}
+	if iss := childText(resp, "Issuer"); iss != "" && iss != idp.metadata.entityID {
+		return nil, errgo.Newf("unexpected response issuer %q", iss)
+	}
+	if code := resp.FindElement("./Status/StatusCode"); code == nil || code.SelectAttrValue("Value", "") != statusSuccess {
+		status := "unknown"
+		if code != nil {
+			status = code.SelectAttrValue("Value", "")
+		}
+		if msg := resp.FindElement("./Status/StatusMessage"); msg != nil {
+			status += ": " + msg.Text()
+		}
+		return nil, errgo.Newf("login failed: %s", status)
+	}
+	if resp.SelectElement("EncryptedAssertion") != nil {
+		return nil, errgo.Newf("encrypted assertions are not supported")
+	}
+
+	vctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: idp.metadata.certificates,
+	})
+	var el *etree.Element
+	if resp.SelectElement("Signature") != nil {
+		vresp, err := vctx.Validate(resp)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid response signature")
+		}
+		el = vresp.SelectElement("Assertion")
+		if el == nil {
+			return nil, errgo.Newf("response does not contain an assertion")
+		}
+	} else {
+		assertions := resp.SelectElements("Assertion")
+		if len(assertions) != 1 {
+			return nil, errgo.Newf("response must contain exactly one assertion")
+		}
+		// Copy all the namespace declarations in scope into the
+		// assertion so that it can be validated on its own.
+		nsctx, err := etreeutils.NSBuildParentContext(assertions[0])
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		detached, err := etreeutils.NSDetatch(nsctx, assertions[0])
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if detached.SelectElement("Signature") == nil {
+			return nil, errgo.Newf("assertion is not signed")
+		}
+		el, err = vctx.Validate(detached)
+		if err != nil {
+			return nil, errgo.Notef(err, "invalid assertion signature")
+		}
+	}
+	return idp.parseAssertion(el, requestID, now)
+}
+
+// parseAssertion checks the conditions in the given validated assertion
+// and extracts the user information from it.
+func (idp *identityProvider) parseAssertion(el *etree.Element, requestID string, now time.Time) (*assertion, error) {
+	a := &assertion{
+		id:         el.SelectAttrValue("ID", ""),
+		issuer:     childText(el, "Issuer"),
+		attributes: make(map[string][]string),
+	}
+	if a.id == "" {
+		return nil, errgo.Newf("assertion has no ID")
+	}
+	if a.issuer != idp.metadata.entityID {
+		return nil, errgo.Newf("unexpected assertion issuer %q", a.issuer)
+	}
+
+	cond := el.SelectElement("Conditions")
+	if cond == nil {
+		return nil, errgo.Newf("assertion has no conditions")
+	}
+	if v := cond.SelectAttrValue("NotBefore", ""); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if now.Add(maxClockSkew).Before(t) {
+			return nil, errgo.Newf("assertion not yet valid")
+		}
+	}
+	if v := cond.SelectAttrValue("NotOnOrAfter", ""); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if !now.Before(t.Add(maxClockSkew)) {
+			return nil, errgo.Newf("assertion has expired")
+		}
+	}
+	restrictions := cond.SelectElements("AudienceRestriction")
+	if len(restrictions) == 0 {
+		return nil, errgo.Newf("assertion has no audience restriction")
+	}
+	for _, ar := range restrictions {
+		var found bool
+		for _, aud := range ar.SelectElements("Audience") {
+			if strings.TrimSpace(aud.Text()) == idp.params.EntityID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errgo.Newf("assertion not intended for %q", idp.params.EntityID)
+		}
+	}
+
+	subject := el.SelectElement("Subject")
+	if subject == nil {
+		return nil, errgo.Newf("assertion has no subject")
+	}
+	a.nameID = childText(subject, "NameID")
+	if a.nameID == "" {
+		return nil, errgo.Newf("assertion has no NameID")
+	}
+	var confirmed bool
+	for _, sc := range subject.SelectElements("SubjectConfirmation") {
+		if sc.SelectAttrValue("Method", "") != "urn:oasis:names:tc:SAML:2.0:cm:bearer" {
+			continue
+		}
+		scd := sc.SelectElement("SubjectConfirmationData")
+		if scd == nil {
+			continue
+		}
+		if r := scd.SelectAttrValue("Recipient", ""); r != "" && r != idp.acsURL() {
+			continue
+		}
+		// The assertion must have been issued for this request,
+		// otherwise it could be presented again with a response to
+		// a new request.
+		if scd.SelectAttrValue("InResponseTo", "") != requestID {
+			continue
+		}
+		t, err := parseTime(scd.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(t.Add(maxClockSkew)) {
+			continue
+		}
+		a.expires = t
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, errgo.Newf("assertion subject not confirmed")
+	}
+
+	for _, as := range el.SelectElements("AttributeStatement") {
+		for _, attr := range as.SelectElements("Attribute") {
+			var values []string
+			for _, v := range attr.SelectElements("AttributeValue") {
+				values = append(values, strings.TrimSpace(v.Text()))
+			}
+			for _, name := range []string{attr.SelectAttrValue("Name", ""), attr.SelectAttrValue("FriendlyName", "")} {
+				if name != "" {
+					a.attributes[name] = append(a.attributes[name], values...)
+				}
+			}
+		}
+	}
+	return a, nil
+}
+
+// childText returns the trimmed text of the first child of el with the
+// given tag.
+func childText(el *etree.Element, tag string) string {
+	child := el.SelectElement(tag)
+	if child == nil {
+		return ""
+	}
+	return strings.TrimSpace(child.Text())
+}
+
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errgo.Newf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// newRequestID creates a new random ID for a request. The ID starts
+// with a letter so that it is a valid xsd:ID.
+func newRequestID() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return "id-" + hex.EncodeToString(buf), nil
+}
+
+// joinDomain creates a new params.Username with the given name and
+// (optional) domain.
+func joinDomain(name, domain string) string {
+	if domain == "" {
+		return name
+	}
+	return fmt.Sprintf("%s@%s", name, domain)
+}
+
+type postForm struct {
+	URL         string
+	SAMLRequest string
+	RelayState  string
+}
+
+var postFormTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="SAMLRequest" value="{{.SAMLRequest}}" />
+<input type="hidden" name="RelayState" value="{{.RelayState}}" />
+<noscript><input type="submit" value="Continue" /></noscript>
+</form>
+</body>
+</html>
+`))
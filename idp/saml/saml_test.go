@@ -0,0 +1,837 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package saml_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gopkg.in/yaml.v2"
+
+	"github.com/canonical/candid/config"
+	idppkg "github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idptest"
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/saml"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+var configTests = []struct {
+	name        string
+	yaml        string
+	expectError string
+}{{
+	name: "OKMetadata",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+  metadata-url: https://idp.example.com/metadata
+`[1:],
+}, {
+	name: "OKManual",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+  idp-entity-id: https://idp.example.com
+  sso-url: https://idp.example.com/sso
+  certificate: cert
+  binding: post
+`[1:],
+}, {
+	name: "NoName",
+	yaml: `
+identity-providers:
+- type: saml
+  metadata-url: https://idp.example.com/metadata
+`[1:],
+	expectError: "cannot unmarshal saml configuration: name not specified",
+}, {
+	name: "NoMetadataOrSSOURL",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+`[1:],
+	expectError: "cannot unmarshal saml configuration: metadata-url or sso-url not specified",
+}, {
+	name: "NoIDPEntityID",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+  sso-url: https://idp.example.com/sso
+  certificate: cert
+`[1:],
+	expectError: "cannot unmarshal saml configuration: idp-entity-id not specified",
+}, {
+	name: "NoCertificate",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+  idp-entity-id: https://idp.example.com
+  sso-url: https://idp.example.com/sso
+`[1:],
+	expectError: "cannot unmarshal saml configuration: certificate not specified",
+}, {
+	name: "BadBinding",
+	yaml: `
+identity-providers:
+- type: saml
+  name: test
+  metadata-url: https://idp.example.com/metadata
+  binding: artifact
+`[1:],
+	expectError: `cannot unmarshal saml configuration: unsupported binding "artifact"`,
+}}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range configTests {
+		c.Run(test.name, func(c *qt.C) {
+			var conf config.Config
+			err := yaml.Unmarshal([]byte(test.yaml), &conf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+			c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "test")
+		})
+	}
+}
+
+func TestInitBadCertificate(t *testing.T) {
+	c := qt.New(t)
+	idp := saml.NewIdentityProvider(saml.Params{
+		Name:        "saml",
+		IDPEntityID: testIDPEntityID,
+		SSOURL:      "https://idp.example.com/sso",
+		Certificate: "not a certificate",
+	})
+	err := idp.Init(context.Background(), idppkg.InitParams{})
+	c.Assert(err, qt.ErrorMatches, `invalid certificate: no certificates found`)
+}
+
+func TestMetadata(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	idp, _ := tidp.newIdentityProvider(c, saml.Params{
+		NameIDFormat: "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+	})
+
+	cl := idptest.NewClient(idp, nil)
+	resp, err := cl.Get("/metadata")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/samlmetadata+xml")
+
+	doc := etree.NewDocument()
+	_, err = doc.ReadFrom(resp.Body)
+	c.Assert(err, qt.IsNil)
+	root := doc.Root()
+	c.Assert(root.Tag, qt.Equals, "EntityDescriptor")
+	c.Assert(root.SelectAttrValue("entityID", ""), qt.Equals, testPrefix+"/metadata")
+	sp := root.SelectElement("SPSSODescriptor")
+	c.Assert(sp, qt.Not(qt.IsNil))
+	c.Assert(sp.SelectAttrValue("WantAssertionsSigned", ""), qt.Equals, "true")
+	c.Assert(sp.SelectElement("NameIDFormat").Text(), qt.Equals, "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent")
+	acs := sp.SelectElement("AssertionConsumerService")
+	c.Assert(acs.SelectAttrValue("Binding", ""), qt.Equals, "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST")
+	c.Assert(acs.SelectAttrValue("Location", ""), qt.Equals, testPrefix+"/acs")
+}
+
+func TestLoginRedirectBinding(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	idp, ip := tidp.newIdentityProvider(c, saml.Params{})
+
+	cl := idptest.NewClient(idp, ip.Codec)
+	cl.SetLoginState(idputil.LoginState{
+		ReturnTo: "https://example.com/callback",
+		State:    "1234",
+		Expires:  time.Now().Add(10 * time.Minute),
+	})
+	resp, err := cl.Get("/login")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusFound)
+	u, err := url.Parse(resp.Header.Get("Location"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.Scheme+"://"+u.Host+u.Path, qt.Equals, "https://idp.example.com/sso")
+	buf, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	c.Assert(err, qt.IsNil)
+	buf, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(buf)))
+	c.Assert(err, qt.IsNil)
+	req := parseAuthnRequest(c, buf)
+	c.Assert(req.SelectAttrValue("ID", ""), qt.Equals, u.Query().Get("RelayState"))
+	c.Assert(req.SelectAttrValue("Destination", ""), qt.Equals, "https://idp.example.com/sso")
+	c.Assert(req.SelectAttrValue("AssertionConsumerServiceURL", ""), qt.Equals, testPrefix+"/acs")
+	c.Assert(req.SelectElement("Issuer").Text(), qt.Equals, testPrefix+"/metadata")
+}
+
+func TestLoginPostBinding(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	idp, ip := tidp.newIdentityProvider(c, saml.Params{
+		Binding: "post",
+	})
+
+	cl := idptest.NewClient(idp, ip.Codec)
+	cl.SetLoginState(idputil.LoginState{
+		ReturnTo: "https://example.com/callback",
+		State:    "1234",
+		Expires:  time.Now().Add(10 * time.Minute),
+	})
+	resp, err := cl.Get("/login")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	doc := etree.NewDocument()
+	_, err = doc.ReadFrom(resp.Body)
+	c.Assert(err, qt.IsNil)
+	form := doc.FindElement("//form")
+	c.Assert(form, qt.Not(qt.IsNil))
+	c.Assert(form.SelectAttrValue("action", ""), qt.Equals, "https://idp.example.com/sso")
+	values := make(map[string]string)
+	for _, input := range form.SelectElements("input") {
+		values[input.SelectAttrValue("name", "")] = input.SelectAttrValue("value", "")
+	}
+	buf, err := base64.StdEncoding.DecodeString(values["SAMLRequest"])
+	c.Assert(err, qt.IsNil)
+	req := parseAuthnRequest(c, buf)
+	c.Assert(req.SelectAttrValue("ID", ""), qt.Equals, values["RelayState"])
+}
+
+func TestInitFromMetadata(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+        <ds:X509Data>
+          <ds:X509Certificate>%s</ds:X509Certificate>
+        </ds:X509Data>
+      </ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://idp.example.com/sso/post"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>
+`, testIDPEntityID, base64.StdEncoding.EncodeToString(tidp.cert))
+	}))
+	defer srv.Close()
+
+	idp := saml.NewIdentityProvider(saml.Params{
+		Name:            "saml",
+		MetadataURL:     srv.URL,
+		GroupsAttribute: "groups",
+	})
+	f := idptest.NewFixture(c, candidtest.NewStore())
+	ip := f.InitParams(c, testPrefix)
+	err := idp.Init(context.Background(), ip)
+	c.Assert(err, qt.IsNil)
+
+	// Only the post binding is available so it is used.
+	cl := idptest.NewClient(idp, ip.Codec)
+	cl.SetLoginState(idputil.LoginState{
+		ReturnTo: "https://example.com/callback",
+		State:    "1234",
+		Expires:  time.Now().Add(10 * time.Minute),
+	})
+	resp, err := cl.Get("/login")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	doc := etree.NewDocument()
+	_, err = doc.ReadFrom(resp.Body)
+	c.Assert(err, qt.IsNil)
+	form := doc.FindElement("//form")
+	c.Assert(form.SelectAttrValue("action", ""), qt.Equals, "https://idp.example.com/sso/post")
+	requestID := form.FindElement("./input[@name='RelayState']").SelectAttrValue("value", "")
+
+	// The certificate from the metadata is used to validate responses.
+	rp := tidp.responseParams(requestID)
+	resp, err = postResponse(idp, tidp.response(c, rp), requestID)
+	c.Assert(err, qt.IsNil)
+	id, err := f.ParseResponse(c, resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+}
+
+var acsTests = []struct {
+	name             string
+	storedIdentities []store.Identity
+	modify           func(*testIDP, *responseParams)
+	expectIdentity   *store.Identity
+	expectError      string
+}{{
+	name: "NewUser",
+	expectIdentity: &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-1"),
+		Username:   "user1",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	},
+}, {
+	name: "SignedResponse",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.signResponse = true
+		p.signAssertion = false
+	},
+	expectIdentity: &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-1"),
+		Username:   "user1",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	},
+}, {
+	name: "ExistingUserUpdated",
+	storedIdentities: []store.Identity{{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-1"),
+		Username:   "existing-user",
+		Name:       "Old Name",
+		Email:      "old@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group3"},
+		},
+	}},
+	expectIdentity: &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-1"),
+		Username:   "existing-user",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	},
+}, {
+	name: "NoUsernameRequiresRegistration",
+	modify: func(_ *testIDP, p *responseParams) {
+		delete(p.attributes, "uid")
+	},
+}, {
+	name: "UsernameTakenRequiresRegistration",
+	storedIdentities: []store.Identity{{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-2"),
+		Username:   "user1",
+	}},
+}, {
+	name: "Unsigned",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.signAssertion = false
+	},
+	expectError: `assertion is not signed`,
+}, {
+	name: "UntrustedSigner",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.signer = newTestIDP()
+	},
+	expectError: `invalid assertion signature: Could not verify certificate against trusted certs`,
+}, {
+	name: "TamperedAssertion",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.tamper = true
+	},
+	expectError: `invalid assertion signature: Signature could not be verified`,
+}, {
+	name: "WrongAudience",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.audience = "https://other.example.com"
+	},
+	expectError: `assertion not intended for "` + testPrefix + `/metadata"`,
+}, {
+	name: "Expired",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.notOnOrAfter = time.Now().Add(-10 * time.Minute)
+	},
+	expectError: `assertion has expired`,
+}, {
+	name: "NotYetValid",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.notBefore = time.Now().Add(10 * time.Minute)
+	},
+	expectError: `assertion not yet valid`,
+}, {
+	name: "WrongRecipient",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.recipient = "https://other.example.com/acs"
+	},
+	expectError: `assertion subject not confirmed`,
+}, {
+	name: "WrongIssuer",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.issuer = "https://other-idp.example.com"
+	},
+	expectError: `unexpected response issuer "https://other-idp.example.com"`,
+}, {
+	name: "WrongInResponseTo",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.inResponseTo = "id-other"
+	},
+	expectError: `unexpected response to request "id-other"`,
+}, {
+	name: "NoConfirmationInResponseTo",
+	modify: func(_ *testIDP, p *responseParams) {
+		irt := ""
+		p.confirmationInResponseTo = &irt
+	},
+	expectError: `assertion subject not confirmed`,
+}, {
+	name: "WrongConfirmationInResponseTo",
+	modify: func(_ *testIDP, p *responseParams) {
+		irt := "id-other"
+		p.confirmationInResponseTo = &irt
+	},
+	expectError: `assertion subject not confirmed`,
+}, {
+	name: "NoAssertionID",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.assertionID = ""
+	},
+	expectError: `assertion has no ID`,
+}, {
+	name: "FailureStatus",
+	modify: func(_ *testIDP, p *responseParams) {
+		p.status = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	},
+	expectError: `login failed: urn:oasis:names:tc:SAML:2.0:status:Responder`,
+}}
+
+func TestACS(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	for _, test := range acsTests {
+		c.Run(test.name, func(c *qt.C) {
+			st := candidtest.NewStore()
+			f := idptest.NewFixture(c, st)
+			idp := saml.NewIdentityProvider(saml.Params{
+				Name:            "saml",
+				IDPEntityID:     testIDPEntityID,
+				SSOURL:          "https://idp.example.com/sso",
+				Certificate:     tidp.certPEM(),
+				GroupsAttribute: "groups",
+			})
+			ip := f.InitParams(c, testPrefix)
+			ip.Template = template.New("")
+			template.Must(ip.Template.New("register").Parse("{{.State}}\n{{.Error}}"))
+			err := idp.Init(context.Background(), ip)
+			c.Assert(err, qt.IsNil)
+
+			for _, id := range test.storedIdentities {
+				id := id
+				err := st.Store.UpdateIdentity(context.Background(), &id, store.Update{
+					store.Username:     store.Set,
+					store.Name:         store.Set,
+					store.Email:        store.Set,
+					store.ProviderInfo: store.Set,
+				})
+				c.Assert(err, qt.IsNil)
+			}
+
+			requestID := startLogin(c, idp, ip)
+			rp := tidp.responseParams(requestID)
+			if test.modify != nil {
+				test.modify(tidp, &rp)
+			}
+			resp, err := postResponse(idp, tidp.response(c, rp), requestID)
+			c.Assert(err, qt.IsNil)
+			id, err := f.ParseResponse(c, resp)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			if test.expectIdentity == nil {
+				c.Check(id, qt.IsNil)
+				return
+			}
+			id.ID = ""
+			c.Check(id, qt.CmpEquals(cmpopts.EquateEmpty()), test.expectIdentity)
+			groups, err := idp.GetGroups(context.Background(), id)
+			c.Assert(err, qt.IsNil)
+			c.Check(groups, qt.DeepEquals, test.expectIdentity.ProviderInfo["groups"])
+		})
+	}
+}
+
+func TestACSRequestReplay(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	idp, ip := tidp.newIdentityProvider(c, saml.Params{})
+	requestID := startLogin(c, idp, ip)
+	samlResponse := tidp.response(c, tidp.responseParams(requestID))
+
+	resp, err := postResponse(idp, samlResponse, requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusSeeOther)
+
+	resp, err = postResponse(idp, samlResponse, requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(buf), qt.Equals, "Login failed: invalid login state")
+}
+
+func TestACSAssertionReplay(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	f := idptest.NewFixture(c, candidtest.NewStore())
+	idp := saml.NewIdentityProvider(saml.Params{
+		Name:        "saml",
+		IDPEntityID: testIDPEntityID,
+		SSOURL:      "https://idp.example.com/sso",
+		Certificate: tidp.certPEM(),
+	})
+	ip := f.InitParams(c, testPrefix)
+	err := idp.Init(context.Background(), ip)
+	c.Assert(err, qt.IsNil)
+	requestID := startLogin(c, idp, ip)
+	resp, err := postResponse(idp, tidp.response(c, tidp.responseParams(requestID)), requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusSeeOther)
+	c.Assert(redirectError(c, resp), qt.Equals, "")
+	f.ResetLogin()
+
+	// An assertion that has already been used cannot be used again,
+	// even in a response to a new request.
+	requestID = startLogin(c, idp, ip)
+	resp, err = postResponse(idp, tidp.response(c, tidp.responseParams(requestID)), requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusSeeOther)
+	c.Assert(redirectError(c, resp), qt.Equals, `assertion "id-assertion" already used`)
+	f.ResetLogin()
+
+	// A new assertion is accepted.
+	requestID = startLogin(c, idp, ip)
+	rp := tidp.responseParams(requestID)
+	rp.assertionID = "id-assertion-2"
+	resp, err = postResponse(idp, tidp.response(c, rp), requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusSeeOther)
+	c.Assert(redirectError(c, resp), qt.Equals, "")
+}
+
+// redirectError returns the error message in the redirect URL of the
+// given response.
+func redirectError(c *qt.C, resp *http.Response) string {
+	u, err := url.Parse(resp.Header.Get("Location"))
+	c.Assert(err, qt.IsNil)
+	return u.Query().Get("error")
+}
+
+func TestRegister(t *testing.T) {
+	c := qt.New(t)
+	tidp := newTestIDP()
+	st := candidtest.NewStore()
+	f := idptest.NewFixture(c, st)
+	idp := saml.NewIdentityProvider(saml.Params{
+		Name:            "saml",
+		Domain:          "example",
+		IDPEntityID:     testIDPEntityID,
+		SSOURL:          "https://idp.example.com/sso",
+		Certificate:     tidp.certPEM(),
+		GroupsAttribute: "groups",
+	})
+	ip := f.InitParams(c, testPrefix)
+	ip.Template = template.New("")
+	template.Must(ip.Template.New("register").Parse("{{.State}}\n{{.Error}}"))
+	err := idp.Init(context.Background(), ip)
+	c.Assert(err, qt.IsNil)
+
+	requestID := startLogin(c, idp, ip)
+	rp := tidp.responseParams(requestID)
+	delete(rp.attributes, "uid")
+	resp, err := postResponse(idp, tidp.response(c, rp), requestID)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	state := strings.Split(string(buf), "\n")[0]
+	cookies := resp.Cookies()
+	c.Assert(cookies, qt.HasLen, 1)
+
+	req, err := http.NewRequest("POST", "/register", strings.NewReader(url.Values{
+		"state":    {state},
+		"username": {"user1"},
+		"fullname": {"User One"},
+		"email":    {"user1@example.com"},
+	}.Encode()))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	resp, err = idptest.NewClient(idp, ip.Codec).Do(req)
+	c.Assert(err, qt.IsNil)
+	id, err := f.ParseResponse(c, resp)
+	c.Assert(err, qt.IsNil)
+	id.ID = ""
+	c.Check(id, qt.DeepEquals, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("saml", testIDPEntityID+":user-id-1"),
+		Username:   "user1@example",
+		Name:       "User One",
+		Email:      "user1@example.com",
+		ProviderInfo: map[string][]string{
+			"groups": {"group1", "group2"},
+		},
+	})
+}
+
+const (
+	testPrefix      = "https://candid.example.com/login/saml"
+	testIDPEntityID = "https://idp.example.com"
+)
+
+// testIDP holds the key and certificate of a test SAML identity
+// provider.
+type testIDP struct {
+	key  *rsa.PrivateKey
+	cert []byte
+}
+
+func newTestIDP() *testIDP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return &testIDP{
+		key:  key,
+		cert: cert,
+	}
+}
+
+// GetKeyPair implements dsig.X509KeyStore.
+func (idp *testIDP) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return idp.key, idp.cert, nil
+}
+
+// signingContext returns a context for signing XML documents using
+// exclusive canonicalization, as SAML identity providers do.
+func (idp *testIDP) signingContext() *dsig.SigningContext {
+	ctx := dsig.NewDefaultSigningContext(idp)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return ctx
+}
+
+func (idp *testIDP) certPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: idp.cert,
+	}))
+}
+
+// newIdentityProvider creates and initializes a SAML identity provider
+// that trusts the test identity provider. Any fields not set in p are
+// given default values.
+func (idp *testIDP) newIdentityProvider(c *qt.C, p saml.Params) (idppkg.IdentityProvider, idppkg.InitParams) {
+	p.Name = "saml"
+	p.IDPEntityID = testIDPEntityID
+	p.SSOURL = "https://idp.example.com/sso"
+	p.Certificate = idp.certPEM()
+	ip := saml.NewIdentityProvider(p)
+	f := idptest.NewFixture(c, candidtest.NewStore())
+	params := f.InitParams(c, testPrefix)
+	err := ip.Init(context.Background(), params)
+	c.Assert(err, qt.IsNil)
+	return ip, params
+}
+
+// responseParams holds the parameters used to create a SAML response.
+type responseParams struct {
+	inResponseTo  string
+	assertionID   string
+	issuer        string
+	status        string
+	audience      string
+	recipient     string
+	nameID        string
+	notBefore     time.Time
+	notOnOrAfter  time.Time
+	attributes    map[string][]string
+	signAssertion bool
+	signResponse  bool
+	signer        *testIDP
+	tamper        bool
+
+	// confirmationInResponseTo holds the InResponseTo attribute of
+	// the subject confirmation, if it is different from
+	// inResponseTo. An empty value omits the attribute.
+	confirmationInResponseTo *string
+}
+
+// responseParams returns the parameters for a valid response to the
+// given request.
+func (idp *testIDP) responseParams(requestID string) responseParams {
+	now := time.Now()
+	return responseParams{
+		inResponseTo: requestID,
+		assertionID:  "id-assertion",
+		issuer:       testIDPEntityID,
+		status:       "urn:oasis:names:tc:SAML:2.0:status:Success",
+		audience:     testPrefix + "/metadata",
+		recipient:    testPrefix + "/acs",
+		nameID:       "user-id-1",
+		notBefore:    now.Add(-time.Minute),
+		notOnOrAfter: now.Add(5 * time.Minute),
+		attributes: map[string][]string{
+			"uid":         {"user1"},
+			"mail":        {"user1@example.com"},
+			"displayName": {"User One"},
+			"groups":      {"group1", "group2"},
+		},
+		signAssertion: true,
+		signer:        idp,
+	}
+}
+
+// response creates a base64 encoded SAML response using the given
+// parameters.
+func (idp *testIDP) response(c *qt.C, p responseParams) string {
+	now := time.Now().UTC().Format(time.RFC3339)
+	resp := etree.NewElement("samlp:Response")
+	resp.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	resp.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	resp.CreateAttr("ID", "id-response")
+	resp.CreateAttr("Version", "2.0")
+	resp.CreateAttr("IssueInstant", now)
+	resp.CreateAttr("Destination", testPrefix+"/acs")
+	resp.CreateAttr("InResponseTo", p.inResponseTo)
+	resp.CreateElement("saml:Issuer").SetText(p.issuer)
+	resp.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", p.status)
+
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	a.CreateAttr("ID", p.assertionID)
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", now)
+	a.CreateElement("saml:Issuer").SetText(p.issuer)
+	subject := a.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText(p.nameID)
+	sc := subject.CreateElement("saml:SubjectConfirmation")
+	sc.CreateAttr("Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer")
+	scd := sc.CreateElement("saml:SubjectConfirmationData")
+	if p.confirmationInResponseTo == nil {
+		scd.CreateAttr("InResponseTo", p.inResponseTo)
+	} else if *p.confirmationInResponseTo != "" {
+		scd.CreateAttr("InResponseTo", *p.confirmationInResponseTo)
+	}
+	scd.CreateAttr("Recipient", p.recipient)
+	scd.CreateAttr("NotOnOrAfter", p.notOnOrAfter.UTC().Format(time.RFC3339))
+	cond := a.CreateElement("saml:Conditions")
+	cond.CreateAttr("NotBefore", p.notBefore.UTC().Format(time.RFC3339))
+	cond.CreateAttr("NotOnOrAfter", p.notOnOrAfter.UTC().Format(time.RFC3339))
+	cond.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(p.audience)
+	as := a.CreateElement("saml:AttributeStatement")
+	for name, values := range p.attributes {
+		attr := as.CreateElement("saml:Attribute")
+		attr.CreateAttr("Name", name)
+		for _, v := range values {
+			attr.CreateElement("saml:AttributeValue").SetText(v)
+		}
+	}
+	if p.signAssertion {
+		var err error
+		a, err = p.signer.signingContext().SignEnveloped(a)
+		c.Assert(err, qt.IsNil)
+	}
+	if p.tamper {
+		a.FindElement("./Subject/NameID").SetText("user-id-2")
+	}
+	resp.AddChild(a)
+	if p.signResponse {
+		var err error
+		resp, err = p.signer.signingContext().SignEnveloped(resp)
+		c.Assert(err, qt.IsNil)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(resp)
+	buf, err := doc.WriteToBytes()
+	c.Assert(err, qt.IsNil)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// startLogin starts a login with the given identity provider and
+// returns the ID of the generated authentication request.
+func startLogin(c *qt.C, idp idppkg.IdentityProvider, ip idppkg.InitParams) string {
+	cl := idptest.NewClient(idp, ip.Codec)
+	cl.SetLoginState(idputil.LoginState{
+		ReturnTo: "https://example.com/callback",
+		State:    "1234",
+		Expires:  time.Now().Add(10 * time.Minute),
+	})
+	resp, err := cl.Get("/login")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusFound)
+	u, err := url.Parse(resp.Header.Get("Location"))
+	c.Assert(err, qt.IsNil)
+	return u.Query().Get("RelayState")
+}
+
+// postResponse posts the given SAML response to the assertion consumer
+// service of the given identity provider.
+func postResponse(idp idppkg.IdentityProvider, samlResponse, relayState string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", "/acs", strings.NewReader(url.Values{
+		"SAMLResponse": {samlResponse},
+		"RelayState":   {relayState},
+	}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return idptest.NewClient(idp, nil).Do(req)
+}
+
+func parseAuthnRequest(c *qt.C, buf []byte) *etree.Element {
+	doc := etree.NewDocument()
+	err := doc.ReadFromBytes(buf)
+	c.Assert(err, qt.IsNil)
+	req := doc.Root()
+	c.Assert(req.Tag, qt.Equals, "AuthnRequest")
+	return req
+}
@@ -7,8 +7,10 @@ package static
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/juju/loggo"
@@ -17,6 +19,8 @@ import (
 
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/password"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/params"
 	"github.com/canonical/candid/store"
 )
@@ -63,10 +67,17 @@ type Params struct {
 	// MatchEmailAddr is a regular expression that is used to determine if
 	// this identity provider can be used for a particular user email.
 	MatchEmailAddr string `yaml:"match-email-addr"`
+
+	// TOTP configures an optional second authentication factor.
+	TOTP totp.Params `yaml:"totp"`
 }
 
 type UserInfo struct {
-	// Password is the password for the user.
+	// Password is the password for the user. This may either be the
+	// plaintext password or a password hash as generated by the
+	// "candid hash-password" command. See package
+	// github.com/canonical/candid/idp/idputil/password for the
+	// supported hash formats.
 	Password string `yaml:"password"`
 	// Name is the full name of the user.
 	Name string `yaml:"name"`
@@ -106,6 +117,7 @@ type identityProvider struct {
 	params         Params
 	initParams     idp.InitParams
 	matchEmailAddr *regexp.Regexp
+	totp           *totp.Authenticator
 }
 
 // Name implements idp.IdentityProvider.Name.
@@ -149,7 +161,24 @@ func (idp *identityProvider) IsForEmailAddr(addr string) bool {
 
 // Init implements idp.IdentityProvider.Init.
 func (idp *identityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	// Check the password hashes now so that a mistake in the
+	// configuration is found at startup rather than at login.
+	usernames := make([]string, 0, len(idp.params.Users))
+	for u := range idp.params.Users {
+		usernames = append(usernames, u)
+	}
+	sort.Strings(usernames)
+	for _, u := range usernames {
+		pw := idp.params.Users[u].Password
+		if !password.IsHash(pw) {
+			continue
+		}
+		if err := password.Validate(pw); err != nil {
+			return errgo.Notef(err, "invalid password hash for user %q", u)
+		}
+	}
 	idp.initParams = params
+	idp.totp = totp.NewAuthenticator(idp.params.TOTP, params, idp.GetGroups)
 	return nil
 }
 
@@ -196,14 +225,16 @@ func (idp *identityProvider) Handle(ctx context.Context, w http.ResponseWriter,
 			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
 		}
 		if id != nil {
-			idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+			idp.totp.Login(ctx, w, req, ls, id)
 		}
+	case totp.Path:
+		idp.totp.Handle(ctx, w, req, ls)
 	}
 }
 
 func (idp *identityProvider) loginUser(ctx context.Context, user, password string) (*store.Identity, error) {
 	if userData, ok := idp.params.Users[user]; ok {
-		if userData.Password == password {
+		if checkPassword(userData.Password, password) {
 			username := idputil.NameWithDomain(user, idp.params.Domain)
 			id := &store.Identity{
 				ProviderID: store.MakeProviderIdentity(idp.params.Name, username),
@@ -224,3 +255,19 @@ func (idp *identityProvider) loginUser(ctx context.Context, user, password strin
 	}
 	return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %q", user)
 }
+
+// checkPassword reports whether the given password matches the stored
+// password. If the stored password is a recognised hash then the
+// password is verified against the hash, otherwise the stored password
+// is treated as plaintext. In both cases the comparison is performed in
+// constant time.
+func checkPassword(stored, pw string) bool {
+	if !password.IsHash(stored) {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(pw)) == 1
+	}
+	err := password.Verify(stored, pw)
+	if err != nil && errgo.Cause(err) != password.ErrMismatch {
+		logger.Errorf("cannot verify password: %s", err)
+	}
+	return err == nil
+}
@@ -5,13 +5,19 @@ package static_test
 
 import (
 	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
 
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idptest"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/idp/static"
 	"github.com/canonical/candid/internal/candidtest"
 	"github.com/canonical/candid/store"
@@ -83,6 +89,36 @@ func (s *staticSuite) TestIconURL(c *qt.C) {
 	c.Assert(i.IconURL(), qt.Equals, "https://www.example.com/candid/static/images/icons/static.svg")
 }
 
+var initInvalidPasswordHashTests = []struct {
+	about       string
+	password    string
+	expectError string
+}{{
+	about:       "bcrypt",
+	password:    "$2b$04$invalid",
+	expectError: `invalid password hash for user "user2": invalid bcrypt hash: .*`,
+}, {
+	about:       "argon2id",
+	password:    "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+	expectError: `invalid password hash for user "user2": unsupported argon2id parameters "m=1024,t=0,p=1"`,
+}}
+
+func (s *staticSuite) TestInitInvalidPasswordHash(c *qt.C) {
+	for _, test := range initInvalidPasswordHashTests {
+		c.Run(test.about, func(c *qt.C) {
+			params := getSampleParams()
+			params.Users["user2"] = static.UserInfo{
+				Password: test.password,
+			}
+			i := static.NewIdentityProvider(params)
+			err := i.Init(context.Background(), idp.InitParams{
+				Location: "https://www.example.com/candid",
+			})
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
 func (s *staticSuite) TestAbsoluteIconURL(c *qt.C) {
 	params := getSampleParams()
 	params.Icon = "https://www.example.com/icon.bmp"
@@ -198,8 +234,309 @@ func (s *staticSuite) TestHandleFailedLoginWrongPassword(c *qt.C) {
 	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
 }
 
+var hashedPasswordTests = []struct {
+	about    string
+	password string
+}{{
+	about:    "bcrypt",
+	password: "$2b$04$/YOjG9lmrq36e7jgjKSCDusin3RbbiFpUoj6O9KtGq68WHINmgaT2",
+}, {
+	about:    "argon2id",
+	password: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$X1NN05T4/WDjLcMTBEx15vLK3poQYZ4QwO2q58luZOg",
+}, {
+	about:    "scrypt",
+	password: "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHRzYWx0$zG0Ri9DCELJWVAosVj/h1yON0YULNqanW5eM37nA8lo",
+}}
+
+func (s *staticSuite) TestHandleHashedPassword(c *qt.C) {
+	for _, test := range hashedPasswordTests {
+		c.Run(test.about, func(c *qt.C) {
+			params := getSampleParams()
+			user := params.Users["user1"]
+			user.Password = test.password
+			params.Users["user1"] = user
+			i := s.setupIdp(c, params)
+			id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass1"))
+			c.Assert(err, qt.IsNil)
+			candidtest.AssertEqualIdentity(c, id, &store.Identity{
+				ProviderID: store.MakeProviderIdentity("test", "user1"),
+				Username:   "user1",
+				Name:       "User One",
+				Email:      "user1@example.com",
+			})
+
+			_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", "pass2"))
+			c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+
+			// The hash itself is not accepted as a password.
+			_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("user1", test.password))
+			c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;user1&#34;`)
+		})
+	}
+}
+
 func (s *staticSuite) TestHandleFailedLoginUnknownUser(c *qt.C) {
 	i := s.setupIdp(c, getSampleParams())
 	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", candidtest.PostLoginForm("unknown", "pass"))
 	c.Assert(err, qt.ErrorMatches, `authentication failed for user &#34;unknown&#34;`)
 }
+
+// loginWithTOTP returns a response handler that logs in as user1 and
+// then processes the second factor form with the given handler.
+func loginWithTOTP(rh candidtest.ResponseHandler) candidtest.ResponseHandler {
+	return func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		resp, err := candidtest.PostLoginForm("user1", "pass1")(client, resp)
+		if err != nil {
+			return nil, err
+		}
+		return rh(client, resp)
+	}
+}
+
+func (s *staticSuite) enrollTOTP(c *qt.C) string {
+	secret, err := totp.NewSecret()
+	c.Assert(err, qt.IsNil)
+	err = s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+		Username:   "user1",
+		ProviderInfo: map[string][]string{
+			totp.SecretKey: {secret},
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.ProviderInfo: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	return secret
+}
+
+func (s *staticSuite) TestHandleTOTPEnroll(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Required
+	i := s.setupIdp(c, params)
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm("", time.Now())))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+	}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	secret := identity.ProviderInfo[totp.SecretKey]
+	c.Assert(secret, qt.HasLen, 1)
+
+	// Subsequent logins use the enrolled secret.
+	id, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret[0], time.Now().Add(totp.Period))))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+}
+
+func (s *staticSuite) TestHandleTOTPEnrollInvalidCode(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Required
+	i := s.setupIdp(c, params)
+	secret, err := totp.NewSecret()
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret, time.Now())))
+	c.Assert(err, qt.ErrorMatches, `invalid code`)
+
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+	}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.ProviderInfo[totp.SecretKey], qt.HasLen, 0)
+}
+
+func (s *staticSuite) TestHandleTOTPRequiredCannotSkip(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Required
+	i := s.setupIdp(c, params)
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.SkipTOTPEnrollment))
+	c.Assert(err, qt.ErrorMatches, `invalid code`)
+}
+
+func (s *staticSuite) TestHandleTOTPOptionalSkip(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.SkipTOTPEnrollment))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+	}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.ProviderInfo[totp.SecretKey], qt.HasLen, 0)
+}
+
+func (s *staticSuite) TestHandleTOTPRequiredGroup(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	params.TOTP.RequiredGroups = []string{"group2"}
+	i := s.setupIdp(c, params)
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.SkipTOTPEnrollment))
+	c.Assert(err, qt.ErrorMatches, `invalid code`)
+}
+
+func (s *staticSuite) TestHandleTOTPNotRequiredGroup(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	params.TOTP.RequiredGroups = []string{"group3"}
+	i := s.setupIdp(c, params)
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.SkipTOTPEnrollment))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+}
+
+func (s *staticSuite) TestHandleTOTPEnrolled(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	secret := s.enrollTOTP(c)
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret, time.Now())))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+}
+
+func (s *staticSuite) TestHandleTOTPEnrolledInvalidCode(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	secret := s.enrollTOTP(c)
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret, time.Now().Add(-time.Hour))))
+	c.Assert(err, qt.ErrorMatches, `invalid code`)
+}
+
+func (s *staticSuite) TestHandleTOTPCodeReused(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	secret := s.enrollTOTP(c)
+	now := time.Now()
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret, now)))
+	c.Assert(err, qt.IsNil)
+	_, err = s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(candidtest.PostTOTPForm(secret, now)))
+	c.Assert(err, qt.ErrorMatches, `code already used`)
+}
+
+func (s *staticSuite) TestHandleTOTPTooManyAttempts(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	secret := s.enrollTOTP(c)
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(string(buf), "\n")
+		c.Assert(len(parts) > 2, qt.IsTrue)
+		purl, token := parts[0], parts[2]
+		for n := 0; n < 5; n++ {
+			resp, err := client.PostForm(purl, url.Values{
+				"token": {token},
+				"code":  {"nocode"},
+			})
+			c.Assert(err, qt.IsNil)
+			buf, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.Assert(err, qt.IsNil)
+			c.Assert(strings.Split(string(buf), "\n")[1], qt.Equals, "invalid code")
+		}
+		// Even a valid code is rejected once the limit is reached.
+		code, err := totp.Code(secret, time.Now())
+		c.Assert(err, qt.IsNil)
+		return client.PostForm(purl, url.Values{
+			"token": {token},
+			"code":  {code},
+		})
+	}))
+	c.Assert(err, qt.ErrorMatches, `too many attempts`)
+}
+
+func (s *staticSuite) TestHandleTOTPResetDuringLogin(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	secret := s.enrollTOTP(c)
+	var newSecret string
+	id, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(string(buf), "\n")
+		c.Assert(len(parts) > 3, qt.IsTrue)
+		purl, token := parts[0], parts[2]
+		c.Assert(parts[3], qt.Equals, "")
+
+		err = s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+			ProviderID: store.MakeProviderIdentity("test", "user1"),
+			ProviderInfo: map[string][]string{
+				totp.SecretKey: nil,
+			},
+		}, store.Update{
+			store.ProviderInfo: store.Clear,
+		})
+		c.Assert(err, qt.IsNil)
+
+		// A code from the old secret must not log the user in, they
+		// are asked to enroll again instead.
+		code, err := totp.Code(secret, time.Now())
+		c.Assert(err, qt.IsNil)
+		resp, err = client.PostForm(purl, url.Values{
+			"token": {token},
+			"code":  {code},
+		})
+		c.Assert(err, qt.IsNil)
+		buf, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, qt.IsNil)
+		parts = strings.Split(string(buf), "\n")
+		c.Assert(len(parts) > 3, qt.IsTrue)
+		c.Assert(parts[1], qt.Equals, "second factor reset, enroll again")
+		newSecret = parts[3]
+		c.Assert(newSecret, qt.Not(qt.Equals), "")
+
+		code, err = totp.Code(newSecret, time.Now())
+		c.Assert(err, qt.IsNil)
+		return client.PostForm(parts[0], url.Values{
+			"token": {parts[2]},
+			"code":  {code},
+		})
+	}))
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.Username, qt.Equals, "user1")
+
+	identity := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "user1"),
+	}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.ProviderInfo[totp.SecretKey], qt.DeepEquals, []string{newSecret})
+}
+
+func (s *staticSuite) TestHandleTOTPInvalidToken(c *qt.C) {
+	params := getSampleParams()
+	params.TOTP.Mode = totp.Optional
+	i := s.setupIdp(c, params)
+	s.enrollTOTP(c)
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", loginWithTOTP(func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		defer resp.Body.Close()
+		purl, err := candidtest.LoginFormAction(resp)
+		if err != nil {
+			return nil, err
+		}
+		return client.PostForm(purl, url.Values{
+			"token": {"invalid"},
+			"code":  {"123456"},
+		})
+	}))
+	c.Assert(err, qt.ErrorMatches, `invalid login token`)
+}
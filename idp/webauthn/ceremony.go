@@ -0,0 +1,301 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp/webauthn/internal/cbor"
+)
+
+// COSE algorithm identifiers for the supported public key types.
+const (
+	algES256 = -7
+	algEdDSA = -8
+	algRS256 = -257
+)
+
+// authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// ceremony holds the expected values that are common to registration
+// and authentication responses.
+type ceremony struct {
+	// rpID holds the relying party ID that the authenticator must
+	// have scoped the credential to.
+	rpID string
+
+	// origin holds the origin of the page that must have made the
+	// request.
+	origin string
+
+	// challenge holds the challenge that was sent to the client.
+	challenge []byte
+
+	// userVerification holds whether the authenticator must have
+	// verified the user.
+	userVerification bool
+}
+
+// clientData holds the fields of the client data JSON that are checked.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData checks that the given client data JSON is for the
+// given type of ceremony.
+func (c ceremony) verifyClientData(buf []byte, typ string) error {
+	var cd clientData
+	if err := json.Unmarshal(buf, &cd); err != nil {
+		return errgo.Notef(err, "invalid client data")
+	}
+	if cd.Type != typ {
+		return errgo.Newf("unexpected client data type %q", cd.Type)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(challenge, c.challenge) != 1 {
+		return errgo.New("challenge mismatch")
+	}
+	if cd.Origin != c.origin {
+		return errgo.Newf("unexpected origin %q", cd.Origin)
+	}
+	return nil
+}
+
+// authenticatorData holds the parsed authenticator data.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses the authenticator data structure
+// described in section 6.1 of the WebAuthn specification.
+func parseAuthenticatorData(buf []byte) (*authenticatorData, error) {
+	if len(buf) < 37 {
+		return nil, errgo.New("authenticator data too short")
+	}
+	ad := authenticatorData{
+		rpIDHash:  buf[:32],
+		flags:     buf[32],
+		signCount: binary.BigEndian.Uint32(buf[33:37]),
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return &ad, nil
+	}
+	buf = buf[37:]
+	// Skip the 16 byte AAGUID.
+	if len(buf) < 18 {
+		return nil, errgo.New("attested credential data too short")
+	}
+	n := int(binary.BigEndian.Uint16(buf[16:18]))
+	buf = buf[18:]
+	if len(buf) < n {
+		return nil, errgo.New("attested credential data too short")
+	}
+	ad.credentialID = buf[:n]
+	buf = buf[n:]
+	_, rest, err := cbor.Unmarshal(buf)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid credential public key")
+	}
+	ad.publicKey = buf[:len(buf)-len(rest)]
+	return &ad, nil
+}
+
+// verifyAuthenticatorData checks the flags and relying party in the
+// given authenticator data.
+func (c ceremony) verifyAuthenticatorData(ad *authenticatorData) error {
+	hash := sha256.Sum256([]byte(c.rpID))
+	if !bytes.Equal(ad.rpIDHash, hash[:]) {
+		return errgo.New("relying party mismatch")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return errgo.New("user not present")
+	}
+	if c.userVerification && ad.flags&flagUserVerified == 0 {
+		return errgo.New("user not verified")
+	}
+	return nil
+}
+
+// verifyRegistration verifies the response to a credential creation
+// request and returns the new credential. Attestation statements are
+// not verified, so nothing is assumed about the authenticator itself.
+func (c ceremony) verifyRegistration(clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create"); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	v, _, err := cbor.Unmarshal(attestationObject)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid attestation object")
+	}
+	obj, _ := v.(map[interface{}]interface{})
+	authData, _ := obj["authData"].([]byte)
+	if authData == nil {
+		return nil, errgo.New("invalid attestation object: no authenticator data")
+	}
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if ad.credentialID == nil {
+		return nil, errgo.New("no attested credential data")
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// verifyAssertion verifies the response to a credential request made
+// using the given credential. If the response is valid the new
+// signature counter is returned.
+func (c ceremony) verifyAssertion(cred *Credential, clientDataJSON, authData, signature []byte) (uint32, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.get"); err != nil {
+		return 0, errgo.Mask(err)
+	}
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	if err := c.verifyAuthenticatorData(ad); err != nil {
+		return 0, errgo.Mask(err)
+	}
+	pk, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	msg := append(append([]byte(nil), authData...), hash[:]...)
+	if err := pk.verify(msg, signature); err != nil {
+		return 0, errgo.Mask(err)
+	}
+	// Authenticators that do not implement a signature counter
+	// always return 0. Otherwise the counter must increase, if it
+	// doesn't the credential may have been cloned.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, errgo.New("signature counter did not increase")
+	}
+	return ad.signCount, nil
+}
+
+// publicKey is a credential public key.
+type publicKey interface {
+	verify(msg, sig []byte) error
+}
+
+// parsePublicKey parses a COSE_Key encoded public key.
+func parsePublicKey(buf []byte) (publicKey, error) {
+	v, _, err := cbor.Unmarshal(buf)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid public key")
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errgo.New("invalid public key")
+	}
+	alg, _ := m[int64(3)].(int64)
+	switch alg {
+	case algES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errgo.New("invalid ES256 public key")
+		}
+		pk := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, errgo.New("invalid ES256 public key")
+		}
+		return ecdsaKey{pk}, nil
+	case algEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errgo.New("invalid EdDSA public key")
+		}
+		return ed25519Key(x), nil
+	case algRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errgo.New("invalid RS256 public key")
+		}
+		var exp int
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return rsaKey{&rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exp,
+		}}, nil
+	}
+	return nil, errgo.Newf("unsupported public key algorithm %d", alg)
+}
+
+type ecdsaKey struct {
+	*ecdsa.PublicKey
+}
+
+func (k ecdsaKey) verify(msg, sig []byte) error {
+	hash := sha256.Sum256(msg)
+	if !ecdsa.VerifyASN1(k.PublicKey, hash[:], sig) {
+		return errgo.New("invalid signature")
+	}
+	return nil
+}
+
+type ed25519Key ed25519.PublicKey
+
+func (k ed25519Key) verify(msg, sig []byte) error {
+	if !ed25519.Verify(ed25519.PublicKey(k), msg, sig) {
+		return errgo.New("invalid signature")
+	}
+	return nil
+}
+
+type rsaKey struct {
+	*rsa.PublicKey
+}
+
+func (k rsaKey) verify(msg, sig []byte) error {
+	hash := sha256.Sum256(msg)
+	if err := rsa.VerifyPKCS1v15(k.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+		return errgo.New("invalid signature")
+	}
+	return nil
+}
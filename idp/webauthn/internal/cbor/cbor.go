@@ -0,0 +1,337 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package cbor implements the subset of CBOR (RFC 7049) needed to
+// process the attestation objects and public keys used in WebAuthn.
+package cbor
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+)
+
+// maxDepth is the maximum nesting of arrays and maps that will be
+// decoded.
+const maxDepth = 16
+
+// Unmarshal decodes the first CBOR data item in buf. The decoded value
+// will be one of the following types:
+//
+//	int64                          for integers
+//	[]byte                         for byte strings
+//	string                         for text strings
+//	[]interface{}                  for arrays
+//	map[interface{}]interface{}    for maps
+//	bool                           for true and false
+//	float64                        for floating point numbers
+//	nil                            for null and undefined
+//
+// Tags are ignored, the tagged value is returned. Any data following
+// the first item is returned as rest.
+func Unmarshal(buf []byte) (v interface{}, rest []byte, err error) {
+	d := decoder{buf: buf}
+	v, err = d.decode(0)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	return v, d.buf, nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errgo.New("cbor: data nested too deeply")
+	}
+	if len(d.buf) == 0 {
+		return nil, errgo.New("cbor: unexpected end of data")
+	}
+	major := d.buf[0] >> 5
+	info := d.buf[0] & 0x1f
+	d.buf = d.buf[1:]
+	if major == majorSimple {
+		return d.simple(info)
+	}
+	n, err := d.argument(info)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	switch major {
+	case majorUnsigned:
+		if n > math.MaxInt64 {
+			return nil, errgo.New("cbor: integer overflow")
+		}
+		return int64(n), nil
+	case majorNegative:
+		if n > math.MaxInt64 {
+			return nil, errgo.New("cbor: integer overflow")
+		}
+		return -1 - int64(n), nil
+	case majorBytes:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return append([]byte(nil), b...), nil
+	case majorText:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return string(b), nil
+	case majorArray:
+		if n > uint64(len(d.buf)) {
+			return nil, errgo.New("cbor: unexpected end of data")
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = d.decode(depth + 1); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		return a, nil
+	case majorMap:
+		if n > uint64(len(d.buf)) {
+			return nil, errgo.New("cbor: unexpected end of data")
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errgo.Newf("cbor: unsupported map key type %T", k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, errgo.Newf("cbor: duplicate map key %v", k)
+			}
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		return m, nil
+	case majorTag:
+		return d.decode(depth + 1)
+	}
+	panic("unreachable")
+}
+
+// argument reads the argument to a data item with the given additional
+// information.
+func (d *decoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		return binary.BigEndian.Uint64(b), nil
+	case info == 31:
+		return 0, errgo.New("cbor: indefinite length items not supported")
+	}
+	return 0, errgo.Newf("cbor: invalid additional information %d", info)
+}
+
+func (d *decoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, errgo.Newf("cbor: unsupported simple value %d", info)
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, errgo.New("cbor: unexpected end of data")
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
+
+// Marshal encodes the given value as CBOR. The value may contain any of
+// the types returned by Unmarshal, except float64, as well as int.
+// Maps are encoded with their keys in the canonical order.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+	if err := e.encode(v); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v interface{}) error {
+	switch v := v.(type) {
+	case int:
+		e.encodeInt(int64(v))
+	case int64:
+		e.encodeInt(v)
+	case []byte:
+		e.head(majorBytes, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	case string:
+		e.head(majorText, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	case []interface{}:
+		e.head(majorArray, uint64(len(v)))
+		for _, item := range v {
+			if err := e.encode(item); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	case map[interface{}]interface{}:
+		type entry struct {
+			key   []byte
+			value interface{}
+		}
+		entries := make([]entry, 0, len(v))
+		for k, item := range v {
+			var ke encoder
+			if err := ke.encode(k); err != nil {
+				return errgo.Mask(err)
+			}
+			entries = append(entries, entry{ke.buf, item})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			ki, kj := entries[i].key, entries[j].key
+			if len(ki) != len(kj) {
+				return len(ki) < len(kj)
+			}
+			return string(ki) < string(kj)
+		})
+		e.head(majorMap, uint64(len(v)))
+		for _, ent := range entries {
+			e.buf = append(e.buf, ent.key...)
+			if err := e.encode(ent.value); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	case bool:
+		if v {
+			e.buf = append(e.buf, majorSimple<<5|21)
+		} else {
+			e.buf = append(e.buf, majorSimple<<5|20)
+		}
+	case nil:
+		e.buf = append(e.buf, majorSimple<<5|22)
+	default:
+		return errgo.Newf("cbor: cannot marshal %T", v)
+	}
+	return nil
+}
+
+func (e *encoder) encodeInt(n int64) {
+	if n < 0 {
+		e.head(majorNegative, uint64(-1-n))
+		return
+	}
+	e.head(majorUnsigned, uint64(n))
+}
+
+func (e *encoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, major<<5|25)
+		e.buf = append(e.buf, 0, 0)
+		binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, major<<5|26)
+		e.buf = append(e.buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], uint32(n))
+	default:
+		e.buf = append(e.buf, major<<5|27)
+		e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], n)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cbor_test
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/candid/idp/webauthn/internal/cbor"
+)
+
+// unmarshalTests are taken from the examples in RFC 7049 appendix A.
+var unmarshalTests = []struct {
+	hex         string
+	expect      interface{}
+	expectError string
+}{{
+	hex:    "00",
+	expect: int64(0),
+}, {
+	hex:    "17",
+	expect: int64(23),
+}, {
+	hex:    "1818",
+	expect: int64(24),
+}, {
+	hex:    "1903e8",
+	expect: int64(1000),
+}, {
+	hex:    "1a000f4240",
+	expect: int64(1000000),
+}, {
+	hex:    "1b000000e8d4a51000",
+	expect: int64(1000000000000),
+}, {
+	hex:         "1bffffffffffffffff",
+	expectError: "cbor: integer overflow",
+}, {
+	hex:    "20",
+	expect: int64(-1),
+}, {
+	hex:    "3903e7",
+	expect: int64(-1000),
+}, {
+	hex:    "f90001",
+	expect: 5.960464477539063e-8,
+}, {
+	hex:    "f93e00",
+	expect: 1.5,
+}, {
+	hex:    "f9c400",
+	expect: -4.0,
+}, {
+	hex:    "fa47c35000",
+	expect: 100000.0,
+}, {
+	hex:    "fb3ff199999999999a",
+	expect: 1.1,
+}, {
+	hex:    "f4",
+	expect: false,
+}, {
+	hex:    "f5",
+	expect: true,
+}, {
+	hex:    "f6",
+	expect: nil,
+}, {
+	hex:    "c11a514b67b0",
+	expect: int64(1363896240),
+}, {
+	hex:    "4401020304",
+	expect: []byte{1, 2, 3, 4},
+}, {
+	hex:    "6449455446",
+	expect: "IETF",
+}, {
+	hex:    "83010203",
+	expect: []interface{}{int64(1), int64(2), int64(3)},
+}, {
+	hex: "a201020304",
+	expect: map[interface{}]interface{}{
+		int64(1): int64(2),
+		int64(3): int64(4),
+	},
+}, {
+	hex: "a26161016162820203",
+	expect: map[interface{}]interface{}{
+		"a": int64(1),
+		"b": []interface{}{int64(2), int64(3)},
+	},
+}, {
+	hex:         "5f42010243030405ff",
+	expectError: "cbor: indefinite length items not supported",
+}, {
+	hex:         "a201020102",
+	expectError: "cbor: duplicate map key 1",
+}, {
+	hex:         "a1810102",
+	expectError: `cbor: unsupported map key type \[\]interface {}`,
+}, {
+	hex:         "4401",
+	expectError: "cbor: unexpected end of data",
+}, {
+	hex:         "9bffffffffffffffff",
+	expectError: "cbor: unexpected end of data",
+}, {
+	hex:         "",
+	expectError: "cbor: unexpected end of data",
+}}
+
+func TestUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range unmarshalTests {
+		c.Run(test.hex, func(c *qt.C) {
+			buf, err := hex.DecodeString(test.hex)
+			c.Assert(err, qt.IsNil)
+			v, rest, err := cbor.Unmarshal(buf)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(rest, qt.HasLen, 0)
+			c.Assert(v, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestUnmarshalRest(t *testing.T) {
+	c := qt.New(t)
+	v, rest, err := cbor.Unmarshal([]byte{0x01, 0x02, 0x03})
+	c.Assert(err, qt.IsNil)
+	c.Assert(v, qt.Equals, int64(1))
+	c.Assert(rest, qt.DeepEquals, []byte{0x02, 0x03})
+}
+
+func TestUnmarshalTooDeep(t *testing.T) {
+	c := qt.New(t)
+	buf := make([]byte, 100)
+	for i := range buf {
+		buf[i] = 0x81
+	}
+	_, _, err := cbor.Unmarshal(buf)
+	c.Assert(err, qt.ErrorMatches, "cbor: data nested too deeply")
+}
+
+var marshalTests = []struct {
+	v      interface{}
+	expect string
+}{{
+	v:      0,
+	expect: "00",
+}, {
+	v:      int64(1000000000000),
+	expect: "1b000000e8d4a51000",
+}, {
+	v:      -1000,
+	expect: "3903e7",
+}, {
+	v:      []byte{1, 2, 3, 4},
+	expect: "4401020304",
+}, {
+	v:      "IETF",
+	expect: "6449455446",
+}, {
+	v:      []interface{}{1, 2, 3},
+	expect: "83010203",
+}, {
+	v: map[interface{}]interface{}{
+		"b": []interface{}{2, 3},
+		"a": 1,
+	},
+	expect: "a26161016162820203",
+}, {
+	v: map[interface{}]interface{}{
+		-1: 1,
+		3:  -7,
+		1:  2,
+	},
+	expect: "a3010203262001",
+}, {
+	v:      true,
+	expect: "f5",
+}, {
+	v:      nil,
+	expect: "f6",
+}}
+
+func TestMarshal(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range marshalTests {
+		buf, err := cbor.Marshal(test.v)
+		c.Assert(err, qt.IsNil)
+		c.Check(hex.EncodeToString(buf), qt.Equals, test.expect, qt.Commentf("%#v", test.v))
+	}
+}
+
+func TestMarshalUnsupportedType(t *testing.T) {
+	c := qt.New(t)
+	_, err := cbor.Marshal(math.Pi)
+	c.Assert(err, qt.ErrorMatches, "cbor: cannot marshal float64")
+}
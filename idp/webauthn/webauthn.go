@@ -0,0 +1,756 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package webauthn contains an identity provider that authenticates
+// existing candid users with WebAuthn (FIDO2) authenticators, such as
+// security keys or platform passkeys. Users register authenticators
+// using a registration link obtained from the candid API, the
+// registered credentials are held against the user's identity in the
+// identity store.
+package webauthn
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.idp.webauthn")
+
+func init() {
+	idp.Register("webauthn", func(unmarshal func(interface{}) error) (idp.IdentityProvider, error) {
+		var p Params
+		if err := unmarshal(&p); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal webauthn parameters")
+		}
+		if p.Name == "" {
+			p.Name = "webauthn"
+		}
+		switch p.UserVerification {
+		case "", "preferred", "required", "discouraged":
+		default:
+			return nil, errgo.Newf("invalid user-verification %q", p.UserVerification)
+		}
+		return NewIdentityProvider(p), nil
+	})
+}
+
+const (
+	// CredentialsKey is the ProviderInfo key that holds the
+	// credentials registered by an identity. Each value is a JSON
+	// encoded Credential.
+	CredentialsKey = "webauthn-credentials"
+
+	// loginTemplate is the name of the template used to render the
+	// login pages.
+	loginTemplate = "webauthn-login"
+
+	// registerTemplate is the name of the template used to render the
+	// registration pages.
+	registerTemplate = "webauthn-register"
+
+	// registrationTimeout is the length of time for which a
+	// registration link is valid.
+	registrationTimeout = time.Hour
+
+	// ceremonyTimeout is the length of time the user has to complete
+	// a registration once the page has been loaded.
+	ceremonyTimeout = 5 * time.Minute
+
+	// challengePrefix is the prefix of the keys in the key-value
+	// store that record the login challenges that have been issued
+	// and not yet used.
+	challengePrefix = "webauthn-challenge-"
+
+	// challengeIssued and challengeUsed are the values stored
+	// against a login challenge when it is issued and once it has
+	// been used.
+	challengeIssued = "issued"
+	challengeUsed   = "used"
+
+	defaultRPName = "Candid"
+)
+
+type Params struct {
+	// Name is the name that will be given to the identity provider.
+	Name string `yaml:"name"`
+
+	// Description is the description of the IDP shown to the user on
+	// the IDP selection page.
+	Description string `yaml:"description"`
+
+	// Icon contains the URL or path of an icon.
+	Icon string `yaml:"icon"`
+
+	// Hidden is set if the IDP should be hidden from interactive
+	// prompts.
+	Hidden bool `yaml:"hidden"`
+
+	// RPID holds the WebAuthn relying party ID to which credentials
+	// are scoped. If this is not set the host name of the candid
+	// location is used. Changing this invalidates all registered
+	// credentials.
+	RPID string `yaml:"rp-id"`
+
+	// RPName holds the relying party name shown to users by their
+	// authenticators. If this is not set "Candid" is used.
+	RPName string `yaml:"rp-name"`
+
+	// Origin holds the origin from which login pages are served. If
+	// this is not set the origin of the candid location is used.
+	Origin string `yaml:"origin"`
+
+	// UserVerification holds the WebAuthn user verification
+	// requirement, one of "preferred" (the default), "required" or
+	// "discouraged". If this is "required" then only authenticators
+	// that verify the user, for example with a PIN or biometric,
+	// can be used.
+	UserVerification string `yaml:"user-verification"`
+}
+
+// NewIdentityProvider creates a new WebAuthn identity provider.
+func NewIdentityProvider(p Params) *IdentityProvider {
+	if p.Description == "" {
+		p.Description = p.Name
+	}
+	if p.Icon == "" {
+		p.Icon = "/static/images/icons/default.svg"
+	}
+	if p.RPName == "" {
+		p.RPName = defaultRPName
+	}
+	if p.UserVerification == "" {
+		p.UserVerification = "preferred"
+	}
+	return &IdentityProvider{
+		params: p,
+	}
+}
+
+// IdentityProvider is an identity provider that authenticates users with
+// WebAuthn authenticators that they have previously registered.
+type IdentityProvider struct {
+	params     Params
+	initParams idp.InitParams
+}
+
+var _ idp.IdentityProvider = (*IdentityProvider)(nil)
+
+// Name implements idp.IdentityProvider.Name.
+func (idp *IdentityProvider) Name() string {
+	return idp.params.Name
+}
+
+// Domain implements idp.IdentityProvider.Domain. The WebAuthn identity
+// provider authenticates users created by other identity providers, so
+// has no domain of its own.
+func (idp *IdentityProvider) Domain() string {
+	return ""
+}
+
+// Description implements idp.IdentityProvider.Description.
+func (idp *IdentityProvider) Description() string {
+	return idp.params.Description
+}
+
+// IconURL returns the URL of an icon for the identity provider.
+func (idp *IdentityProvider) IconURL() string {
+	return idputil.ServiceURL(idp.initParams.Location, idp.params.Icon)
+}
+
+// Interactive implements idp.IdentityProvider.Interactive.
+func (*IdentityProvider) Interactive() bool {
+	return true
+}
+
+// Hidden implements idp.IdentityProvider.Hidden.
+func (idp *IdentityProvider) Hidden() bool {
+	return idp.params.Hidden
+}
+
+// Init implements idp.IdentityProvider.Init.
+func (idp *IdentityProvider) Init(ctx context.Context, params idp.InitParams) error {
+	idp.initParams = params
+	if idp.params.RPID == "" || idp.params.Origin == "" {
+		u, err := url.Parse(params.Location)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse location")
+		}
+		if idp.params.RPID == "" {
+			idp.params.RPID = u.Hostname()
+		}
+		if idp.params.Origin == "" {
+			idp.params.Origin = u.Scheme + "://" + u.Host
+		}
+	}
+	return nil
+}
+
+// URL implements idp.IdentityProvider.URL.
+func (idp *IdentityProvider) URL(state string) string {
+	return idputil.RedirectURL(idp.initParams.URLPrefix, "/login", state)
+}
+
+// SetInteraction implements idp.IdentityProvider.SetInteraction.
+func (idp *IdentityProvider) SetInteraction(ierr *httpbakery.Error, dischargeID string) {
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups.
+func (idp *IdentityProvider) GetGroups(ctx context.Context, identity *store.Identity) ([]string, error) {
+	return nil, nil
+}
+
+// FormParams contains the parameters sent to the webauthn-login and
+// webauthn-register templates.
+type FormParams struct {
+	// Action contains the action parameter for the form.
+	Action string
+
+	// Error contains an error message from the previous, failed,
+	// attempt.
+	Error string
+
+	// Username contains the username of the user that is logging in
+	// or registering, if known.
+	Username string
+
+	// Token contains an opaque value that must be returned in the
+	// "token" form field.
+	Token string
+
+	// Options contains the JSON encoded options that should be passed
+	// to navigator.credentials.create or navigator.credentials.get.
+	// Binary values are encoded as unpadded base64url strings. If
+	// this is empty on the login page then the user should be asked
+	// for their username, which is submitted in the "username" form
+	// field.
+	Options string
+
+	// Complete is set on the registration page once an authenticator
+	// has been successfully registered.
+	Complete bool
+}
+
+// Handle implements idp.IdentityProvider.Handle.
+func (idp *IdentityProvider) Handle(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	switch strings.TrimPrefix(req.URL.Path, idp.initParams.URLPrefix) {
+	case "/register":
+		err := idp.register(ctx, w, req)
+		if errgo.Cause(err) == params.ErrBadRequest {
+			idputil.BadRequestf(w, "Registration failed: %s", err)
+		} else if err != nil {
+			logger.Errorf("registration failed: %s", err)
+			http.Error(w, "Registration failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var ls idputil.LoginState
+	if err := idp.initParams.Codec.Cookie(req, idputil.LoginCookieName, req.Form.Get("state"), &ls); err != nil {
+		logger.Infof("Invalid login state: %s", err)
+		idputil.BadRequestf(w, "Login failed: invalid login state")
+		return
+	}
+	switch strings.TrimPrefix(req.URL.Path, idp.initParams.URLPrefix) {
+	case "/login":
+		if err := idp.login(ctx, w, req, ls); err != nil {
+			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+		}
+	case "/authenticate":
+		id, err := idp.authenticate(ctx, req, ls)
+		if err != nil {
+			idp.initParams.VisitCompleter.RedirectFailure(ctx, w, req, ls.ReturnTo, ls.State, err)
+			return
+		}
+		idp.initParams.VisitCompleter.RedirectSuccess(ctx, w, req, ls.ReturnTo, ls.State, id)
+	}
+}
+
+// Token purposes, these ensure that a token issued for one step cannot
+// be used in another.
+const (
+	purposeLogin            = "webauthn-login"
+	purposeRegistrationLink = "webauthn-registration-link"
+	purposeRegister         = "webauthn-register"
+)
+
+// token holds the state that is carried between the steps of a login
+// or registration.
+type token struct {
+	Purpose    string                 `json:"purpose"`
+	Identity   store.ProviderIdentity `json:"identity"`
+	Username   string                 `json:"username,omitempty"`
+	State      string                 `json:"state,omitempty"`
+	Expires    time.Time              `json:"expires"`
+	Challenge  []byte                 `json:"challenge,omitempty"`
+	Credential []byte                 `json:"credential,omitempty"`
+}
+
+// login handles the login form. On a GET request the username form is
+// shown, once a username is submitted the authentication page is shown.
+// The authentication page is shown even if the user does not exist or
+// has no registered authenticators so that the response does not reveal
+// which users exist, the authentication then fails in the same way as
+// for an unregistered authenticator.
+func (idp *IdentityProvider) login(ctx context.Context, w http.ResponseWriter, req *http.Request, ls idputil.LoginState) error {
+	state := req.Form.Get("state")
+	data := FormParams{
+		Action: idputil.RedirectURL(idp.initParams.URLPrefix, "/login", state),
+	}
+	if req.Method != "POST" {
+		return errgo.Mask(idp.initParams.Template.ExecuteTemplate(w, loginTemplate, data))
+	}
+	data.Username = req.Form.Get("username")
+	id := store.Identity{
+		Username: data.Username,
+	}
+	err := idp.initParams.Store.Identity(ctx, &id)
+	if err != nil && errgo.Cause(err) != store.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	creds, err := credentials(&id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	challenge, err := newChallenge()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	// The challenge is recorded so that it can only be used once.
+	if err := idp.initParams.KeyValueStore.Set(ctx, challengeKey(challenge), []byte(challengeIssued), ls.Expires); err != nil {
+		return errgo.Mask(err)
+	}
+	allow := make([]credentialDescriptor, len(creds))
+	for i, cred := range creds {
+		allow[i] = credentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(cred.ID),
+		}
+	}
+	options, err := json.Marshal(map[string]interface{}{
+		"publicKey": requestOptions{
+			Challenge:        base64.RawURLEncoding.EncodeToString(challenge),
+			Timeout:          int(ceremonyTimeout / time.Millisecond),
+			RPID:             idp.params.RPID,
+			AllowCredentials: allow,
+			UserVerification: idp.params.UserVerification,
+		},
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data.Token, err = idp.initParams.Codec.Encode(token{
+		Purpose:   purposeLogin,
+		Identity:  id.ProviderID,
+		Username:  data.Username,
+		State:     ls.State,
+		Expires:   ls.Expires,
+		Challenge: challenge,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data.Action = idputil.RedirectURL(idp.initParams.URLPrefix, "/authenticate", state)
+	data.Options = string(options)
+	return errgo.Mask(idp.initParams.Template.ExecuteTemplate(w, loginTemplate, data))
+}
+
+// authenticate verifies the authenticator's response to the challenge
+// issued by login and returns the authenticated identity.
+func (idp *IdentityProvider) authenticate(ctx context.Context, req *http.Request, ls idputil.LoginState) (*store.Identity, error) {
+	if req.Method != "POST" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unsupported method %q", req.Method)
+	}
+	t, err := idp.decodeToken(req.Form.Get("token"), purposeLogin)
+	if err != nil || t.State != ls.State || !t.Expires.Equal(ls.Expires) {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid login token")
+	}
+	if err := idp.useChallenge(ctx, t); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	id := store.Identity{
+		ProviderID: t.Identity,
+	}
+	if t.Identity != "" {
+		if err := idp.initParams.Store.Identity(ctx, &id); err != nil && errgo.Cause(err) != store.ErrNotFound {
+			return nil, errgo.Mask(err)
+		}
+	}
+	creds, err := credentials(&id)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	credID, err := formBytes(req, "id")
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	var cred *Credential
+	for i := range creds {
+		if string(creds[i].ID) == string(credID) {
+			cred = &creds[i]
+			break
+		}
+	}
+	if cred == nil {
+		logger.Infof("authentication failed for %s: authenticator not registered", t.Username)
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %s", t.Username)
+	}
+	clientData, err := formBytes(req, "client-data")
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	authData, err := formBytes(req, "authenticator-data")
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	sig, err := formBytes(req, "signature")
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	signCount, err := idp.ceremony(t.Challenge).verifyAssertion(cred, clientData, authData, sig)
+	if err != nil {
+		logger.Infof("authentication failed for %s: %s", id.Username, err)
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "authentication failed for user %s", id.Username)
+	}
+	cred.SignCount = signCount
+	cred.LastUsed = time.Now()
+	if err := idp.setCredentials(ctx, &id, creds); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &id, nil
+}
+
+// useChallenge marks the challenge in the given login token as used. If
+// the challenge has already been used, or was not issued by this
+// server, an error with a cause of params.ErrBadRequest is returned.
+func (idp *IdentityProvider) useChallenge(ctx context.Context, t *token) error {
+	err := idp.initParams.KeyValueStore.Update(ctx, challengeKey(t.Challenge), t.Expires, func(old []byte) ([]byte, error) {
+		if string(old) != challengeIssued {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "login challenge already used")
+		}
+		return []byte(challengeUsed), nil
+	})
+	return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+}
+
+// challengeKey returns the key used to record the given login challenge
+// in the key-value store.
+func challengeKey(challenge []byte) string {
+	return challengePrefix + base64.RawURLEncoding.EncodeToString(challenge)
+}
+
+// RegistrationURL returns a URL that the user with the given username
+// can visit to register a new authenticator. The URL is valid for one
+// hour. If there is no such user an error with a cause of
+// params.ErrNotFound is returned.
+func (idp *IdentityProvider) RegistrationURL(ctx context.Context, username string) (string, error) {
+	id := store.Identity{
+		Username: username,
+	}
+	if err := idp.initParams.Store.Identity(ctx, &id); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return "", errgo.WithCausef(nil, params.ErrNotFound, "user %s not found", username)
+		}
+		return "", errgo.Mask(err)
+	}
+	t, err := idp.initParams.Codec.Encode(token{
+		Purpose:  purposeRegistrationLink,
+		Identity: id.ProviderID,
+		Expires:  time.Now().Add(registrationTimeout),
+	})
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	return idp.initParams.URLPrefix + "/register?" + url.Values{"token": {t}}.Encode(), nil
+}
+
+// register handles the registration page. A GET request with a
+// registration link token shows the registration page, which POSTs the
+// new credential back.
+func (idp *IdentityProvider) register(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	purpose := purposeRegistrationLink
+	if req.Method == "POST" {
+		purpose = purposeRegister
+	}
+	t, err := idp.decodeToken(req.Form.Get("token"), purpose)
+	if err != nil {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid registration token")
+	}
+	id := store.Identity{
+		ProviderID: t.Identity,
+	}
+	if err := idp.initParams.Store.Identity(ctx, &id); err != nil {
+		return errgo.Mask(err)
+	}
+	creds, err := credentials(&id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data := FormParams{
+		Action:   idp.initParams.URLPrefix + "/register",
+		Username: id.Username,
+	}
+	if req.Method == "POST" {
+		cred, err := idp.verifyRegistration(req, t)
+		if err == nil {
+			for _, c := range creds {
+				if string(c.ID) == string(cred.ID) {
+					err = errgo.New("authenticator already registered")
+				}
+			}
+		}
+		if err == nil {
+			if err := idp.setCredentials(ctx, &id, append(creds, *cred)); err != nil {
+				return errgo.Mask(err)
+			}
+			data.Complete = true
+			return errgo.Mask(idp.initParams.Template.ExecuteTemplate(w, registerTemplate, data))
+		}
+		logger.Infof("registration failed for %s: %s", id.Username, err)
+		data.Error = "registration failed: " + err.Error()
+	}
+	challenge, err := newChallenge()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	exclude := make([]credentialDescriptor, len(creds))
+	for i, cred := range creds {
+		exclude[i] = credentialDescriptor{
+			Type: "public-key",
+			ID:   base64.RawURLEncoding.EncodeToString(cred.ID),
+		}
+	}
+	userHandle := sha256.Sum256([]byte(id.ProviderID))
+	options, err := json.Marshal(map[string]interface{}{
+		"publicKey": creationOptions{
+			RP: rpEntity{
+				ID:   idp.params.RPID,
+				Name: idp.params.RPName,
+			},
+			User: userEntity{
+				ID:          base64.RawURLEncoding.EncodeToString(userHandle[:]),
+				Name:        id.Username,
+				DisplayName: displayName(&id),
+			},
+			Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+			PubKeyCredParams: []credentialParameters{
+				{Type: "public-key", Alg: algES256},
+				{Type: "public-key", Alg: algEdDSA},
+				{Type: "public-key", Alg: algRS256},
+			},
+			Timeout:            int(ceremonyTimeout / time.Millisecond),
+			ExcludeCredentials: exclude,
+			AuthenticatorSelection: authenticatorSelection{
+				ResidentKey:      "discouraged",
+				UserVerification: idp.params.UserVerification,
+			},
+			Attestation: "none",
+		},
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data.Token, err = idp.initParams.Codec.Encode(token{
+		Purpose:   purposeRegister,
+		Identity:  id.ProviderID,
+		Expires:   time.Now().Add(ceremonyTimeout),
+		Challenge: challenge,
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data.Options = string(options)
+	return errgo.Mask(idp.initParams.Template.ExecuteTemplate(w, registerTemplate, data))
+}
+
+func (idp *IdentityProvider) verifyRegistration(req *http.Request, t *token) (*Credential, error) {
+	clientData, err := formBytes(req, "client-data")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	attestationObject, err := formBytes(req, "attestation-object")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	cred, err := idp.ceremony(t.Challenge).verifyRegistration(clientData, attestationObject)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	cred.Name = req.Form.Get("name")
+	cred.Created = time.Now()
+	return cred, nil
+}
+
+// decodeToken decodes the given token, checking it has the given
+// purpose and has not expired.
+func (idp *IdentityProvider) decodeToken(s, purpose string) (*token, error) {
+	var t token
+	if err := idp.initParams.Codec.Decode(s, &t); err != nil {
+		logger.Infof("invalid token: %s", err)
+		return nil, errgo.Mask(err)
+	}
+	if t.Purpose != purpose {
+		return nil, errgo.Newf("unexpected token purpose %q", t.Purpose)
+	}
+	if time.Now().After(t.Expires) {
+		return nil, errgo.New("token expired")
+	}
+	return &t, nil
+}
+
+func (idp *IdentityProvider) ceremony(challenge []byte) ceremony {
+	return ceremony{
+		rpID:             idp.params.RPID,
+		origin:           idp.params.Origin,
+		challenge:        challenge,
+		userVerification: idp.params.UserVerification == "required",
+	}
+}
+
+// setCredentials replaces the credentials stored for the given identity.
+func (idp *IdentityProvider) setCredentials(ctx context.Context, id *store.Identity, creds []Credential) error {
+	vs := make([]string, len(creds))
+	for i, cred := range creds {
+		buf, err := json.Marshal(cred)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		vs[i] = string(buf)
+	}
+	return errgo.Mask(idp.initParams.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: id.ProviderID,
+		ProviderInfo: map[string][]string{
+			CredentialsKey: vs,
+		},
+	}, store.Update{
+		store.ProviderInfo: store.Set,
+	}))
+}
+
+// A Credential is a WebAuthn credential registered by a user.
+type Credential struct {
+	// ID holds the credential ID assigned by the authenticator.
+	ID []byte `json:"id"`
+
+	// PublicKey holds the COSE_Key encoded public key of the
+	// credential.
+	PublicKey []byte `json:"public-key"`
+
+	// SignCount holds the most recent signature counter value
+	// returned by the authenticator.
+	SignCount uint32 `json:"sign-count"`
+
+	// Name holds an optional name given to the credential by the
+	// user.
+	Name string `json:"name,omitempty"`
+
+	// Created holds the time the credential was registered.
+	Created time.Time `json:"created"`
+
+	// LastUsed holds the time the credential was last used to log
+	// in.
+	LastUsed time.Time `json:"last-used,omitempty"`
+}
+
+// credentials returns the credentials registered by the given identity.
+func credentials(id *store.Identity) ([]Credential, error) {
+	vs := id.ProviderInfo[CredentialsKey]
+	creds := make([]Credential, len(vs))
+	for i, v := range vs {
+		if err := json.Unmarshal([]byte(v), &creds[i]); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal credential")
+		}
+	}
+	return creds, nil
+}
+
+func formBytes(req *http.Request, key string) ([]byte, error) {
+	v := req.Form.Get(key)
+	if v == "" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%s not specified", key)
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+	if err != nil {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid %s", key)
+	}
+	return buf, nil
+}
+
+func newChallenge() ([]byte, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errgo.Notef(err, "cannot generate challenge")
+	}
+	return buf, nil
+}
+
+func displayName(id *store.Identity) string {
+	if id.Name != "" {
+		return id.Name
+	}
+	return id.Username
+}
+
+// The following types are the JSON forms of the WebAuthn
+// PublicKeyCredentialCreationOptions and
+// PublicKeyCredentialRequestOptions dictionaries.
+
+type creationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credentialParameters `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type requestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
@@ -0,0 +1,588 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package webauthn_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/yaml.v2"
+
+	"github.com/canonical/candid/config"
+	"github.com/canonical/candid/idp/idptest"
+	"github.com/canonical/candid/idp/webauthn"
+	"github.com/canonical/candid/idp/webauthn/internal/cbor"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/store"
+)
+
+const (
+	idpPrefix = "https://idp.example.com"
+	rpID      = "idp.example.com"
+	origin    = "https://idp.example.com"
+)
+
+type webauthnSuite struct {
+	idptest *idptest.Fixture
+}
+
+func TestWebAuthn(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webauthnSuite{})
+}
+
+func (s *webauthnSuite) Init(c *qt.C) {
+	s.idptest = idptest.NewFixture(c, candidtest.NewStore())
+	err := s.idptest.Store.Store.UpdateIdentity(s.idptest.Ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Name:       "Bob Robertson",
+	}, store.Update{
+		store.Username: store.Set,
+		store.Name:     store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *webauthnSuite) setupIdp(c *qt.C, p webauthn.Params) *webauthn.IdentityProvider {
+	if p.Name == "" {
+		p.Name = "webauthn"
+	}
+	if p.RPID == "" {
+		p.RPID = rpID
+	}
+	if p.Origin == "" {
+		p.Origin = origin
+	}
+	i := webauthn.NewIdentityProvider(p)
+	err := i.Init(context.Background(), s.idptest.InitParams(c, idpPrefix))
+	c.Assert(err, qt.IsNil)
+	return i
+}
+
+// register registers the given authenticator for the given user, and
+// returns the final registration page.
+func (s *webauthnSuite) register(c *qt.C, i *webauthn.IdentityProvider, username string, a *authenticator) page {
+	u, err := i.RegistrationURL(s.idptest.Ctx, username)
+	c.Assert(err, qt.IsNil)
+	client := idptest.NewClient(i, s.idptest.Codec)
+	resp, err := client.Get(u)
+	c.Assert(err, qt.IsNil)
+	p := parsePage(c, resp)
+	c.Assert(p.Error, qt.Equals, "")
+	c.Assert(p.Complete, qt.Equals, false)
+	v := a.create(c, p.Options)
+	v.Set("token", p.Token)
+	v.Set("name", "test key")
+	resp, err = client.Do(postRequest(c, p.Action, v))
+	c.Assert(err, qt.IsNil)
+	return parsePage(c, resp)
+}
+
+// login performs a complete login as the given user using the given
+// authenticator.
+func (s *webauthnSuite) login(c *qt.C, i *webauthn.IdentityProvider, username string, a *authenticator) (*store.Identity, error) {
+	s.idptest.Reset()
+	return s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		p := parsePage(c, resp)
+		c.Assert(p.Options, qt.Equals, "")
+		resp, err := client.PostForm(p.Action, url.Values{
+			"username": {username},
+		})
+		c.Assert(err, qt.IsNil)
+		p = parsePage(c, resp)
+		if p.Error != "" {
+			return errorPage(p.Error), nil
+		}
+		v := a.get(c, p.Options)
+		v.Set("token", p.Token)
+		return client.PostForm(p.Action, v)
+	})
+}
+
+func (s *webauthnSuite) TestConfig(c *qt.C) {
+	configYaml := `
+identity-providers:
+ - type: webauthn
+   rp-id: example.com
+   user-verification: required
+`
+	var conf config.Config
+	err := yaml.Unmarshal([]byte(configYaml), &conf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(conf.IdentityProviders, qt.HasLen, 1)
+	c.Assert(conf.IdentityProviders[0].Name(), qt.Equals, "webauthn")
+	c.Assert(conf.IdentityProviders[0].Description(), qt.Equals, "webauthn")
+	c.Assert(conf.IdentityProviders[0].Interactive(), qt.Equals, true)
+}
+
+func (s *webauthnSuite) TestConfigInvalidUserVerification(c *qt.C) {
+	configYaml := `
+identity-providers:
+ - type: webauthn
+   user-verification: sometimes
+`
+	var conf config.Config
+	err := yaml.Unmarshal([]byte(configYaml), &conf)
+	c.Assert(err, qt.ErrorMatches, `cannot unmarshal webauthn configuration: invalid user-verification "sometimes"`)
+}
+
+func (s *webauthnSuite) TestDefaultRelyingParty(c *qt.C) {
+	i := webauthn.NewIdentityProvider(webauthn.Params{Name: "webauthn"})
+	ip := s.idptest.InitParams(c, idpPrefix)
+	ip.Location = "https://candid.example.com:8081/candid"
+	err := i.Init(context.Background(), ip)
+	c.Assert(err, qt.IsNil)
+	a := newAuthenticator(c, "candid.example.com", "https://candid.example.com:8081", "ES256")
+	p := s.register(c, i, "bob", a)
+	c.Assert(p.Error, qt.Equals, "")
+	c.Assert(p.Complete, qt.Equals, true)
+}
+
+func (s *webauthnSuite) TestRegisterAndLogin(c *qt.C) {
+	for _, alg := range []string{"ES256", "EdDSA", "RS256"} {
+		c.Run(alg, func(c *qt.C) {
+			i := s.setupIdp(c, webauthn.Params{})
+			a := newAuthenticator(c, rpID, origin, alg)
+			p := s.register(c, i, "bob", a)
+			c.Assert(p.Error, qt.Equals, "")
+			c.Assert(p.Complete, qt.Equals, true)
+
+			id, err := s.login(c, i, "bob", a)
+			c.Assert(err, qt.IsNil)
+			c.Assert(id.Username, qt.Equals, "bob")
+			c.Assert(id.ProviderID, qt.Equals, store.MakeProviderIdentity("test", "bob"))
+		})
+	}
+}
+
+func (s *webauthnSuite) TestCredentialStoredAgainstIdentity(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.IsNil)
+
+	id := store.Identity{Username: "bob"}
+	err = s.idptest.Store.Store.Identity(s.idptest.Ctx, &id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id.ProviderInfo[webauthn.CredentialsKey], qt.HasLen, 1)
+	var cred webauthn.Credential
+	err = json.Unmarshal([]byte(id.ProviderInfo[webauthn.CredentialsKey][0]), &cred)
+	c.Assert(err, qt.IsNil)
+	c.Assert(cred.ID, qt.DeepEquals, a.credID)
+	c.Assert(cred.Name, qt.Equals, "test key")
+	c.Assert(cred.SignCount, qt.Equals, a.signCount)
+	c.Assert(cred.Created.IsZero(), qt.Equals, false)
+	c.Assert(cred.LastUsed.IsZero(), qt.Equals, false)
+}
+
+func (s *webauthnSuite) TestRegisterMultipleAuthenticators(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a1 := newAuthenticator(c, rpID, origin, "ES256")
+	a2 := newAuthenticator(c, rpID, origin, "EdDSA")
+	s.register(c, i, "bob", a1)
+	p := s.register(c, i, "bob", a2)
+	c.Assert(p.Complete, qt.Equals, true)
+
+	_, err := s.login(c, i, "bob", a1)
+	c.Assert(err, qt.IsNil)
+	_, err = s.login(c, i, "bob", a2)
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *webauthnSuite) TestRegisterSameAuthenticatorTwice(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	p := s.register(c, i, "bob", a)
+	c.Assert(p.Error, qt.Equals, "registration failed: authenticator already registered")
+	c.Assert(p.Complete, qt.Equals, false)
+}
+
+func (s *webauthnSuite) TestRegisterWrongOrigin(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, "https://evil.example.com", "ES256")
+	p := s.register(c, i, "bob", a)
+	c.Assert(p.Error, qt.Equals, `registration failed: unexpected origin "https://evil.example.com"`)
+	c.Assert(p.Complete, qt.Equals, false)
+	// A new challenge is issued so the user can try again.
+	c.Assert(p.Options, qt.Not(qt.Equals), "")
+}
+
+func (s *webauthnSuite) TestRegisterWrongRelyingParty(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, "evil.example.com", origin, "ES256")
+	p := s.register(c, i, "bob", a)
+	c.Assert(p.Error, qt.Equals, `registration failed: relying party mismatch`)
+}
+
+func (s *webauthnSuite) TestRegisterUserVerificationRequired(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{
+		UserVerification: "required",
+	})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	p := s.register(c, i, "bob", a)
+	c.Assert(p.Error, qt.Equals, `registration failed: user not verified`)
+
+	a.userVerified = true
+	p = s.register(c, i, "bob", a)
+	c.Assert(p.Complete, qt.Equals, true)
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *webauthnSuite) TestRegistrationURLUnknownUser(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	_, err := i.RegistrationURL(s.idptest.Ctx, "alice")
+	c.Assert(err, qt.ErrorMatches, `user alice not found`)
+}
+
+func (s *webauthnSuite) TestRegisterInvalidToken(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	client := idptest.NewClient(i, s.idptest.Codec)
+	resp, err := client.Get(idpPrefix + "/register?token=bad")
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+}
+
+func (s *webauthnSuite) TestRegisterWithLoginToken(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+
+	// A token obtained by starting a login, which anyone can do, must
+	// not be usable to register an authenticator.
+	var loginToken string
+	s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		p := parsePage(c, resp)
+		resp, err := client.PostForm(p.Action, url.Values{
+			"username": {"bob"},
+		})
+		c.Assert(err, qt.IsNil)
+		p = parsePage(c, resp)
+		loginToken = p.Token
+		return errorPage("stop"), nil
+	})
+	c.Assert(loginToken, qt.Not(qt.Equals), "")
+	client := idptest.NewClient(i, s.idptest.Codec)
+	resp, err := client.Get(idpPrefix + "/register?" + url.Values{"token": {loginToken}}.Encode())
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+}
+
+func (s *webauthnSuite) TestLoginNoCredentials(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	// Unknown users and users without authenticators fail in the
+	// same way as an unregistered authenticator.
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+	_, err = s.login(c, i, "alice", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user alice`)
+}
+
+func (s *webauthnSuite) TestLoginUnregisteredAuthenticator(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	s.register(c, i, "bob", newAuthenticator(c, rpID, origin, "ES256"))
+	_, err := s.login(c, i, "bob", newAuthenticator(c, rpID, origin, "ES256"))
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+}
+
+func (s *webauthnSuite) TestLoginReplay(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	s.idptest.Reset()
+	_, err := s.idptest.DoInteractiveLogin(c, i, idpPrefix+"/login", func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		p := parsePage(c, resp)
+		resp, err := client.PostForm(p.Action, url.Values{
+			"username": {"bob"},
+		})
+		c.Assert(err, qt.IsNil)
+		p = parsePage(c, resp)
+		v := a.get(c, p.Options)
+		v.Set("token", p.Token)
+		resp, err = client.PostForm(p.Action, v)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+
+		// Replaying the same response is rejected.
+		s.idptest.Reset()
+		return client.PostForm(p.Action, v)
+	})
+	c.Assert(err, qt.ErrorMatches, `login challenge already used`)
+}
+
+func (s *webauthnSuite) TestLoginInvalidSignature(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	a.corruptSignature = true
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+}
+
+func (s *webauthnSuite) TestLoginWrongOrigin(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	a.origin = "https://evil.example.com"
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+}
+
+func (s *webauthnSuite) TestLoginSignCountMustIncrease(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.IsNil)
+	// Simulate a cloned authenticator.
+	a.signCount--
+	_, err = s.login(c, i, "bob", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+}
+
+func (s *webauthnSuite) TestLoginUserVerificationRequired(c *qt.C) {
+	i := s.setupIdp(c, webauthn.Params{})
+	a := newAuthenticator(c, rpID, origin, "ES256")
+	s.register(c, i, "bob", a)
+	i = s.setupIdp(c, webauthn.Params{
+		UserVerification: "required",
+	})
+	_, err := s.login(c, i, "bob", a)
+	c.Assert(err, qt.ErrorMatches, `authentication failed for user bob`)
+	a.userVerified = true
+	_, err = s.login(c, i, "bob", a)
+	c.Assert(err, qt.IsNil)
+}
+
+// page holds the fields of a page rendered with the test templates.
+type page struct {
+	Action   string
+	Error    string
+	Token    string
+	Options  string
+	Complete bool
+}
+
+func parsePage(c *qt.C, resp *http.Response) page {
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK, qt.Commentf("%s", buf))
+	parts := strings.Split(string(buf), "\n")
+	c.Assert(parts, qt.HasLen, 6, qt.Commentf("%s", buf))
+	return page{
+		Action:   html.UnescapeString(parts[0]),
+		Error:    html.UnescapeString(parts[1]),
+		Token:    html.UnescapeString(parts[2]),
+		Options:  html.UnescapeString(parts[3]),
+		Complete: parts[4] == "true",
+	}
+}
+
+// errorPage returns a response containing a page with the given error,
+// which DoInteractiveLogin will interpret as a failed login.
+func errorPage(msg string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("\n" + msg + "\n")),
+	}
+}
+
+func postRequest(c *qt.C, u string, v url.Values) *http.Request {
+	req, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// authenticator is a software WebAuthn authenticator that responds to
+// the options produced by the identity provider in the same way a
+// browser would.
+type authenticator struct {
+	rpID             string
+	origin           string
+	alg              string
+	key              crypto.Signer
+	credID           []byte
+	signCount        uint32
+	userVerified     bool
+	corruptSignature bool
+}
+
+func newAuthenticator(c *qt.C, rpID, origin, alg string) *authenticator {
+	a := &authenticator{
+		rpID:   rpID,
+		origin: origin,
+		alg:    alg,
+		credID: make([]byte, 16),
+	}
+	_, err := rand.Read(a.credID)
+	c.Assert(err, qt.IsNil)
+	switch alg {
+	case "ES256":
+		a.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, a.key, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		a.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	c.Assert(err, qt.IsNil)
+	return a
+}
+
+func (a *authenticator) coseKey(c *qt.C) []byte {
+	var key map[interface{}]interface{}
+	switch k := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		key = map[interface{}]interface{}{
+			1:  2,
+			3:  -7,
+			-1: 1,
+			-2: k.X.FillBytes(make([]byte, 32)),
+			-3: k.Y.FillBytes(make([]byte, 32)),
+		}
+	case ed25519.PublicKey:
+		key = map[interface{}]interface{}{
+			1:  1,
+			3:  -8,
+			-1: 6,
+			-2: []byte(k),
+		}
+	case *rsa.PublicKey:
+		e := make([]byte, 4)
+		binary.BigEndian.PutUint32(e, uint32(k.E))
+		key = map[interface{}]interface{}{
+			1:  3,
+			3:  -257,
+			-1: k.N.Bytes(),
+			-2: bytes.TrimLeft(e, "\x00"),
+		}
+	}
+	buf, err := cbor.Marshal(key)
+	c.Assert(err, qt.IsNil)
+	return buf
+}
+
+func (a *authenticator) authData(attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(0x01)
+	if a.userVerified {
+		flags |= 0x04
+	}
+	if attested != nil {
+		flags |= 0x40
+	}
+	buf := append([]byte(nil), rpHash[:]...)
+	buf = append(buf, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[33:], a.signCount)
+	return append(buf, attested...)
+}
+
+func (a *authenticator) clientData(c *qt.C, typ, challenge string) []byte {
+	buf, err := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	c.Assert(err, qt.IsNil)
+	return buf
+}
+
+// create responds to a navigator.credentials.create call with the given
+// options.
+func (a *authenticator) create(c *qt.C, options string) url.Values {
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RP        struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal([]byte(options), &opts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(opts.PublicKey.User.Name, qt.Equals, "bob")
+
+	attested := make([]byte, 16, 128)
+	attested = append(attested, byte(len(a.credID)>>8), byte(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, a.coseKey(c)...)
+	attestationObject, err := cbor.Marshal(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(attested),
+	})
+	c.Assert(err, qt.IsNil)
+	return url.Values{
+		"client-data":        {b64(a.clientData(c, "webauthn.create", opts.PublicKey.Challenge))},
+		"attestation-object": {b64(attestationObject)},
+	}
+}
+
+// get responds to a navigator.credentials.get call with the given
+// options.
+func (a *authenticator) get(c *qt.C, options string) url.Values {
+	var opts struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RPID      string `json:"rpId"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal([]byte(options), &opts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(opts.PublicKey.RPID, qt.Equals, rpID)
+
+	a.signCount++
+	authData := a.authData(nil)
+	clientData := a.clientData(c, "webauthn.get", opts.PublicKey.Challenge)
+	hash := sha256.Sum256(clientData)
+	msg := append(append([]byte(nil), authData...), hash[:]...)
+	var sig []byte
+	switch a.alg {
+	case "EdDSA":
+		sig, err = a.key.Sign(rand.Reader, msg, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(msg)
+		sig, err = a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	c.Assert(err, qt.IsNil)
+	if a.corruptSignature {
+		sig[len(sig)-1] ^= 0xff
+	}
+	return url.Values{
+		"id":                 {b64(a.credID)},
+		"client-data":        {b64(clientData)},
+		"authenticator-data": {b64(authData)},
+		"signature":          {b64(sig)},
+	}
+}
+
+func b64(buf []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
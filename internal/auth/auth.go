@@ -7,9 +7,11 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
@@ -36,6 +38,7 @@ const (
 	kindGlobal = "global"
 	kindUser   = "u"
 	kindUserID = "uid"
+	kindGroup  = "g"
 )
 
 // The following constants define possible operation actions.
@@ -52,12 +55,21 @@ const (
 	ActionWriteGroups        = "writeGroups"
 	ActionReadSSHKeys        = "readSSHKeys"
 	ActionWriteSSHKeys       = "writeSSHKeys"
+	ActionWriteCredentials   = "writeCredentials"
 	ActionLogin              = "login"
 	ActionReadDischargeToken = "read-discharge-token"
+	ActionReadAudit          = "readAudit"
+	ActionProvision          = "provision"
+	ActionCreateGroup        = "createGroup"
+	ActionDelete             = "delete"
+	ActionApproveElevation   = "approveElevation"
 )
 
 const (
+	approveElevationACL = "approve-elevation"
 	dischargeForUserACL = "discharge-for-user"
+	provisionUserACL    = "provision-user"
+	readAuditACL        = "read-audit"
 	readUserACL         = "read-user"
 	readUserGroupsACL   = "read-user-groups"
 	readUserSSHKeysACL  = "read-user-ssh-keys"
@@ -66,7 +78,10 @@ const (
 )
 
 var aclDefaults = map[string][]string{
+	approveElevationACL: {AdminUsername},
 	dischargeForUserACL: {AdminUsername},
+	provisionUserACL:    {AdminUsername},
+	readAuditACL:        {AdminUsername},
 	readUserACL:         {AdminUsername, UserInformationGroup},
 	readUserGroupsACL:   {AdminUsername, GroupListGroup, UserInformationGroup},
 	readUserSSHKeysACL:  {AdminUsername, SSHKeyGetterGroup, UserInformationGroup},
@@ -74,6 +89,17 @@ var aclDefaults = map[string][]string{
 	writeUserSSHKeysACL: {AdminUsername},
 }
 
+// ACLs returns the names of the ACLs that are used to authorize
+// operations in the identity server, in lexical order.
+func ACLs() []string {
+	names := make([]string, 0, len(aclDefaults))
+	for name := range aclDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // An Authorizer is used to authorize operations in the identity server.
 type Authorizer struct {
 	adminPassword  string
@@ -82,6 +108,7 @@ type Authorizer struct {
 	store          store.Store
 	groupResolvers map[string]groupResolver
 	aclManager     *aclstore.Manager
+	deletions      simplekv.Store
 }
 
 // Params specifify the configuration parameters for a new Authroizer.
@@ -109,6 +136,12 @@ type Params struct {
 
 	// ACLStore is the acl store.
 	ACLManager *aclstore.Manager
+
+	// DeletionStore holds the key-value store in which deleted
+	// identities are recorded. Tokens issued to a deleted identity
+	// are not accepted for a later identity with the same username
+	// or provider ID. If this is nil deletions are not checked.
+	DeletionStore simplekv.Store
 }
 
 // New creates a new Authorizer for authorizing identity server
@@ -124,6 +157,7 @@ func New(params Params) (*Authorizer, error) {
 		location:      params.Location,
 		store:         params.Store,
 		aclManager:    params.ACLManager,
+		deletions:     params.DeletionStore,
 	}
 	resolvers := make(map[string]groupResolver)
 	for _, idp := range params.IdentityProviders {
@@ -181,6 +215,21 @@ func (a *Authorizer) aclForOp(ctx context.Context, op bakery.Op) (acl []string,
 		case ActionCreateParentAgent:
 			acl, err := a.aclManager.ACL(ctx, writeUserACL)
 			return acl, false, errgo.Mask(err)
+		case ActionReadAudit:
+			acl, err := a.aclManager.ACL(ctx, readAuditACL)
+			return acl, false, errgo.Mask(err)
+		case ActionProvision:
+			acl, err := a.aclManager.ACL(ctx, provisionUserACL)
+			return acl, false, errgo.Mask(err)
+		case ActionReadGroups:
+			acl, err := a.aclManager.ACL(ctx, readUserGroupsACL)
+			return acl, false, errgo.Mask(err)
+		case ActionCreateGroup:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			return acl, false, errgo.Mask(err)
+		case ActionApproveElevation:
+			acl, err := a.aclManager.ACL(ctx, approveElevationACL)
+			return acl, false, errgo.Mask(err)
 		}
 	case kindUser:
 		if name == "" {
@@ -209,6 +258,20 @@ func (a *Authorizer) aclForOp(ctx context.Context, op bakery.Op) (acl []string,
 		case ActionWriteSSHKeys:
 			acl, err := a.aclManager.ACL(ctx, writeUserSSHKeysACL)
 			return append(acl, username), false, errgo.Mask(err)
+		case ActionWriteCredentials:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			return append(acl, username), false, errgo.Mask(err)
+		case ActionDelete:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			if err != nil {
+				return nil, false, errgo.Mask(err)
+			}
+			// The owner of an agent is allowed to delete it.
+			owner, err := a.ownerUsername(ctx, username)
+			if owner != "" {
+				acl = append(acl, owner)
+			}
+			return acl, false, errgo.Mask(err)
 		}
 	case kindUserID:
 		if name == "" {
@@ -242,6 +305,42 @@ func (a *Authorizer) aclForOp(ctx context.Context, op bakery.Op) (acl []string,
 				err = sterr
 			}
 			return append(acl, acl1...), false, errgo.Mask(err)
+		case ActionWriteAdmin:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			if err == nil {
+				err = sterr
+			}
+			return acl, false, errgo.Mask(err)
+		}
+	case kindGroup:
+		if name == "" {
+			return nil, false, nil
+		}
+		group := store.Group{
+			Name: name,
+		}
+		sterr := a.store.Group(ctx, &group)
+		if errgo.Cause(sterr) == store.ErrNotFound {
+			// As with user IDs, the operation will fail with
+			// the same error if the group doesn't exist.
+			sterr = nil
+		}
+		switch op.Action {
+		case ActionRead:
+			acl, err := a.aclManager.ACL(ctx, readUserGroupsACL)
+			if err == nil {
+				err = sterr
+			}
+			return append(acl, group.Owners...), false, errgo.Mask(err)
+		case ActionWriteGroups:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			if err == nil {
+				err = sterr
+			}
+			return append(acl, group.Owners...), false, errgo.Mask(err)
+		case ActionWriteAdmin:
+			acl, err := a.aclManager.ACL(ctx, writeUserACL)
+			return acl, false, errgo.Mask(err)
 		}
 	case "groups":
 		switch op.Action {
@@ -253,6 +352,34 @@ func (a *Authorizer) aclForOp(ctx context.Context, op bakery.Op) (acl []string,
 	return nil, false, nil
 }
 
+// ownerUsername returns the username of the owner of the identity with
+// the given username. If the identity, or its owner, cannot be found
+// then an empty string is returned.
+func (a *Authorizer) ownerUsername(ctx context.Context, username string) (string, error) {
+	id := store.Identity{
+		Username: username,
+	}
+	if err := a.store.Identity(ctx, &id); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return "", nil
+		}
+		return "", errgo.Mask(err)
+	}
+	if id.Owner == "" {
+		return "", nil
+	}
+	owner := store.Identity{
+		ProviderID: id.Owner,
+	}
+	if err := a.store.Identity(ctx, &owner); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return "", nil
+		}
+		return "", errgo.Mask(err)
+	}
+	return owner.Username, nil
+}
+
 // SetAdminPublicKey configures the public key on the admin user. This is
 // to allow agent login as the admin user.
 func (a *Authorizer) SetAdminPublicKey(ctx context.Context, pk *bakery.PublicKey) error {
@@ -279,15 +406,22 @@ func (a *Authorizer) SetAdminPublicKey(ctx context.Context, pk *bakery.PublicKey
 // macaroons, is authorized to perform the given operations. It may
 // return an bakery.DischargeRequiredError when further checks are
 // required, or params.ErrUnauthorized if the user is authenticated but
-// does not have the required authorization.
+// does not have the required authorization. If the authenticated user
+// has been suspended then an error with a cause of params.ErrForbidden
+// is returned.
 func (a *Authorizer) Auth(ctx context.Context, mss []macaroon.Slice, ops ...bakery.Op) (*identchecker.AuthInfo, error) {
-	authInfo, err := a.checker.Auth(mss...).Allow(ctx, ops...)
+	authInfo, err := a.checker.Auth(a.withoutRevoked(ctx, mss)...).Allow(ctx, ops...)
 	if err != nil {
 		if errgo.Cause(err) == bakery.ErrPermissionDenied {
 			return nil, errgo.WithCausef(err, params.ErrUnauthorized, "")
 		}
 		return nil, errgo.Mask(err, isDischargeRequiredError)
 	}
+	if id, ok := authInfo.Identity.(*Identity); ok {
+		if err := a.CheckNotSuspended(ctx, &id.Identity); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrForbidden))
+		}
+	}
 	return authInfo, nil
 }
 
@@ -437,25 +571,90 @@ func (id *Identity) Allow(ctx context.Context, acl []string) (bool, error) {
 
 // Groups returns all the groups associated with the user. The groups
 // include those stored in the identity server's database along with any
-// retrieved by the relevent identity provider's GetGroups method. Once
-// the set of groups has been determined it is cached in the Identity.
+// retrieved by the relevent identity provider's GetGroups method, and
+// any group that has one of those groups as a member group. Once the
+// set of groups has been determined it is cached in the Identity. Any
+// error resolving the groups is logged and the groups that could be
+// resolved are returned.
 func (id *Identity) Groups(ctx context.Context) ([]string, error) {
+	groups, err := id.ResolveGroups(ctx)
+	if err != nil {
+		logger.Warningf("%s", err)
+	}
+	return groups, nil
+}
+
+// ResolveGroups is like Groups except that if the groups cannot all be
+// resolved then the groups that could be resolved are returned along
+// with an error. It should be used when a missing group could grant
+// access, such as when checking that the user is not a member of a
+// group.
+func (id *Identity) ResolveGroups(ctx context.Context) ([]string, error) {
 	if id.resolvedGroups != nil {
 		return id.resolvedGroups, nil
 	}
-	groups := id.Identity.Groups
+	// Resolve the groups using a copy of the identity that has
+	// any expired group memberships removed.
+	identity := id.Identity
+	identity.Groups = identity.CurrentGroups(time.Now())
+	groups := identity.Groups
+	var resolveErr error
 	if gr := id.authorizer.groupResolvers[id.ProviderID.Provider()]; gr != nil {
 		var err error
-		groups, err = gr.resolveGroups(ctx, &id.Identity)
+		groups, err = gr.resolveGroups(ctx, &identity)
 		if err != nil {
-			logger.Warningf("error resolving groups: %s", err)
-		} else {
-			id.resolvedGroups = groups
+			resolveErr = errgo.Notef(err, "error resolving groups")
 		}
 	}
+	groups, err := expandGroups(ctx, id.authorizer.store, groups)
+	if err != nil && resolveErr == nil {
+		resolveErr = errgo.Notef(err, "error expanding groups")
+	}
+	if resolveErr != nil {
+		return groups, resolveErr
+	}
+	id.resolvedGroups = groups
 	return groups, nil
 }
 
+// expandGroups returns the given groups along with every stored group
+// that, directly or indirectly, has any of them as a member group. The
+// given groups are returned first and in their original order. If the
+// stored groups cannot be read then the given groups are returned
+// along with the error.
+func expandGroups(ctx context.Context, st store.Store, groups []string) ([]string, error) {
+	if len(groups) == 0 {
+		return groups, nil
+	}
+	stored, err := st.FindGroups(ctx)
+	if err != nil {
+		return groups, errgo.Mask(err)
+	}
+	parents := make(map[string][]string)
+	for _, g := range stored {
+		for _, m := range g.MemberGroups {
+			parents[m] = append(parents[m], g.Name)
+		}
+	}
+	if len(parents) == 0 {
+		return groups, nil
+	}
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		seen[g] = true
+	}
+	expanded := append([]string(nil), groups...)
+	for i := 0; i < len(expanded); i++ {
+		for _, p := range parents[expanded[i]] {
+			if !seen[p] {
+				seen[p] = true
+				expanded = append(expanded, p)
+			}
+		}
+	}
+	return expanded, nil
+}
+
 // trivialAllow reports whether the username should be allowed
 // access to the given ACL based on a superficial inspection
 // of the ACL. If there is a definite answer, it will return
@@ -494,6 +693,11 @@ func UserIDOp(uid string, action string) bakery.Op {
 	return op(kindUserID+"-"+uid, action)
 }
 
+// GroupOp is an operation specific to a group.
+func GroupOp(name string, action string) bakery.Op {
+	return op(kindGroup+"-"+name, action)
+}
+
 // GlobalOp is an operation that is not specific to a user.
 func GlobalOp(action string) bakery.Op {
 	return op(kindGlobal, action)
@@ -536,14 +740,16 @@ type candidGroupResolver struct {
 // owner is also still a member are returned. The result is effectively
 // the union between the agent's groups and the owner's groups.
 func (r candidGroupResolver) resolveGroups(ctx context.Context, identity *store.Identity) ([]string, error) {
+	now := time.Now()
+	groups := identity.CurrentGroups(now)
 	if identity.Owner == "" {
 		// No owner implies a parent agent. These agents are
 		// members of only the specified groups.
-		return identity.Groups, nil
+		return groups, nil
 	}
 	if identity.Owner == AdminProviderID {
 		// The admin user is a member of all groups by definition.
-		return identity.Groups, nil
+		return groups, nil
 	}
 	ownerIdentity := store.Identity{
 		ProviderID: identity.Owner,
@@ -554,6 +760,7 @@ func (r candidGroupResolver) resolveGroups(ctx context.Context, identity *store.
 		}
 		return nil, nil
 	}
+	ownerIdentity.Groups = ownerIdentity.CurrentGroups(now)
 	resolver := r.resolvers[identity.Owner.Provider()]
 	if resolver == nil {
 		// Owner is somehow in an unknown provider.
@@ -564,8 +771,12 @@ func (r candidGroupResolver) resolveGroups(ctx context.Context, identity *store.
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
-	allowedGroups := make([]string, 0, len(identity.Groups))
-	for _, g1 := range identity.Groups {
+	ownerGroups, err = expandGroups(ctx, r.store, ownerGroups)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	allowedGroups := make([]string, 0, len(groups))
+	for _, g1 := range groups {
 		for _, g2 := range ownerGroups {
 			if g2 == g1 {
 				allowedGroups = append(allowedGroups, g1)
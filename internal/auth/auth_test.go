@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"sort"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
@@ -265,6 +266,23 @@ var aclForOpTests = []struct {
 }, {
 	op:     auth.UserOp("bob", "writeSSHKeys"),
 	expect: []string{"bob", auth.AdminUsername},
+}, {
+	op:     auth.UserOp("bob", "delete"),
+	expect: []string{auth.AdminUsername},
+}, {
+	op:     auth.GlobalOp("readGroups"),
+	expect: []string{auth.AdminUsername, auth.GroupListGroup, auth.UserInformationGroup},
+}, {
+	op:     auth.GlobalOp("createGroup"),
+	expect: []string{auth.AdminUsername},
+}, {
+	op: auth.GroupOp("", "read"),
+}, {
+	op:     auth.GroupOp("no-such-group", "read"),
+	expect: []string{auth.AdminUsername, auth.GroupListGroup, auth.UserInformationGroup},
+}, {
+	op:     auth.GroupOp("no-such-group", "writeGroups"),
+	expect: []string{auth.AdminUsername},
 }}
 
 func (s *authSuite) TestACLForOp(c *qt.C) {
@@ -280,6 +298,91 @@ func (s *authSuite) TestACLForOp(c *qt.C) {
 	}
 }
 
+func (s *authSuite) TestGroupACLForOp(c *qt.C) {
+	err := s.store.Store.AddGroup(s.context, &store.Group{
+		Name:   "group-1",
+		Owners: []string{"alice", "managers"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	tests := []struct {
+		action string
+		expect []string
+	}{{
+		action: "read",
+		expect: []string{auth.AdminUsername, "alice", auth.GroupListGroup, "managers", auth.UserInformationGroup},
+	}, {
+		action: "writeGroups",
+		expect: []string{auth.AdminUsername, "alice", "managers"},
+	}, {
+		action: "writeAdmin",
+		expect: []string{auth.AdminUsername},
+	}}
+	for _, test := range tests {
+		c.Run(test.action, func(c *qt.C) {
+			acl, public, err := auth.AuthorizerACLForOp(s.authorizer, s.context, auth.GroupOp("group-1", test.action))
+			c.Assert(err, qt.IsNil)
+			sort.Strings(acl)
+			c.Assert(acl, qt.DeepEquals, test.expect)
+			c.Assert(public, qt.Equals, false)
+		})
+	}
+}
+
+func (s *authSuite) TestDeleteAgentACLForOp(c *qt.C) {
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+		Username:   "agent1@candid",
+		Owner:      store.MakeProviderIdentity("test", "alice"),
+	}, store.Update{
+		store.Username: store.Set,
+		store.Owner:    store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+
+	acl, public, err := auth.AuthorizerACLForOp(s.authorizer, s.context, auth.UserOp("agent1@candid", "delete"))
+	c.Assert(err, qt.IsNil)
+	sort.Strings(acl)
+	c.Assert(acl, qt.DeepEquals, []string{auth.AdminUsername, "alice"})
+	c.Assert(public, qt.Equals, false)
+}
+
+func (s *authSuite) TestNestedGroups(c *qt.C) {
+	for _, g := range []store.Group{{
+		Name:         "staff",
+		MemberGroups: []string{"engineering", "sales"},
+	}, {
+		Name:         "engineering",
+		MemberGroups: []string{"test-group1", "staff"},
+	}, {
+		Name:         "everybody",
+		MemberGroups: []string{"staff"},
+	}, {
+		Name:         "unrelated",
+		MemberGroups: []string{"other"},
+	}} {
+		g := g
+		err := s.store.Store.AddGroup(s.context, &g)
+		c.Assert(err, qt.IsNil)
+	}
+	s.createIdentity(c, "test", nil, "test-group1", "test-group2")
+	m := s.identityMacaroon(c, "test")
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	assertAuthorizedGroups(c, authInfo, []string{"test-group1", "test-group2", "engineering", "staff", "everybody"})
+
+	ok, err := authInfo.Identity.(*auth.Identity).Allow(s.context, []string{"everybody"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(ok, qt.Equals, true)
+}
+
 func (s *authSuite) TestAdminUserGroups(c *qt.C) {
 	ctx := auth.ContextWithUserCredentials(context.Background(), "admin", "password")
 	authInfo, err := s.authorizer.Auth(ctx, nil, identchecker.LoginOp)
@@ -302,6 +405,57 @@ func (s *authSuite) TestExistingUserGroups(c *qt.C) {
 	assertAuthorizedGroups(c, authInfo, []string{"test-group1", "test-group2"})
 }
 
+func (s *authSuite) TestExpiredGroups(c *qt.C) {
+	s.createIdentity(c, "test", nil, "test-group1", "test-group2", "test-group3")
+	s.setGroupExpiry(c, store.MakeProviderIdentity("test", "test"), map[string]time.Time{
+		"test-group1": time.Now().Add(-time.Minute),
+		"test-group2": time.Now().Add(time.Hour),
+	})
+	m := s.identityMacaroon(c, "test")
+	authInfo, err := s.authorizer.Auth(s.context, []macaroon.Slice{{m.M()}}, identchecker.LoginOp)
+	c.Assert(err, qt.IsNil)
+	assertAuthorizedGroups(c, authInfo, []string{"test-group2", "test-group3"})
+}
+
+func (s *authSuite) TestAgentExpiredGroups(c *qt.C) {
+	s.createIdentity(c, "alice", nil, "g1", "g2", "g3")
+	s.setGroupExpiry(c, store.MakeProviderIdentity("test", "alice"), map[string]time.Time{
+		"g1": time.Now().Add(-time.Minute),
+	})
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+		Username:   "agent1@candid",
+		Owner:      store.MakeProviderIdentity("test", "alice"),
+		Groups:     []string{"g1", "g2", "g3"},
+		GroupExpiry: map[string]time.Time{
+			"g3": time.Now().Add(-time.Minute),
+		},
+	}, store.Update{
+		store.Username:    store.Set,
+		store.Owner:       store.Set,
+		store.Groups:      store.Set,
+		store.GroupExpiry: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	id, err := s.authorizer.Identity(s.context, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+	})
+	c.Assert(err, qt.IsNil)
+	groups, err := id.Groups(s.context)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"g2"})
+}
+
+func (s *authSuite) setGroupExpiry(c *qt.C, pid store.ProviderIdentity, expiry map[string]time.Time) {
+	err := s.store.Store.UpdateIdentity(s.context, &store.Identity{
+		ProviderID:  pid,
+		GroupExpiry: expiry,
+	}, store.Update{
+		store.GroupExpiry: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+}
+
 func assertAuthorizedGroups(c *qt.C, authInfo *identchecker.AuthInfo, expectGroups []string) {
 	c.Assert(authInfo.Identity, qt.Not(qt.IsNil))
 	ident := authInfo.Identity.(*auth.Identity)
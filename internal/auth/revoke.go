@@ -0,0 +1,83 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/store"
+)
+
+// issuedKey is the key of the declaration that records when a
+// discharge macaroon or discharge token was issued.
+const issuedKey = "issued"
+
+// IssuedDeclaration returns a first party caveat that declares the time
+// at which a discharge macaroon or discharge token was issued. This
+// allows the token to be rejected if the tokens for the declared
+// identity are revoked after it was issued.
+func IssuedDeclaration(t time.Time) checkers.Caveat {
+	return checkers.DeclaredCaveat(issuedKey, t.UTC().Format(time.RFC3339Nano))
+}
+
+// withoutRevoked returns the given macaroons with any that declare an
+// identity whose tokens have been revoked since they were issued
+// removed. Removing the macaroons, rather than failing the
+// authorization, means that the client will be asked to log in again.
+func (a *Authorizer) withoutRevoked(ctx context.Context, mss []macaroon.Slice) []macaroon.Slice {
+	var valid []macaroon.Slice
+	for _, ms := range mss {
+		if err := a.checkNotRevoked(ctx, checkers.InferDeclared(Namespace, ms)); err != nil {
+			logger.Infof("ignoring macaroon: %s", err)
+			continue
+		}
+		valid = append(valid, ms)
+	}
+	return valid
+}
+
+// checkNotRevoked checks that a token making the given declarations has
+// not been revoked. Tokens that do not declare an issue time are
+// treated as revoked once any revocation has been made for the
+// declared identity. Deleting an identity revokes its tokens, so a
+// token issued before the deletion of an identity with the same
+// username or provider ID is not accepted.
+func (a *Authorizer) checkNotRevoked(ctx context.Context, declared map[string]string) error {
+	id := store.Identity{
+		ProviderID: store.ProviderIdentity(declared["userid"]),
+		Username:   declared["username"],
+	}
+	if id.ProviderID == "" && id.Username == "" {
+		return nil
+	}
+	if err := a.store.Identity(ctx, &id); err != nil {
+		// Any problem finding the identity will be reported when
+		// the macaroon is checked.
+		return nil
+	}
+	revoked := id.TokensRevoked
+	if a.deletions != nil {
+		deleted, err := deletion.Revoked(ctx, a.deletions, &id)
+		if err != nil {
+			return errgo.Notef(err, "cannot check deleted identities")
+		}
+		if deleted.After(revoked) {
+			revoked = deleted
+		}
+	}
+	if revoked.IsZero() {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, declared[issuedKey])
+	if err != nil || !t.After(revoked) {
+		return errgo.Newf("tokens for %q have been revoked", id.Username)
+	}
+	return nil
+}
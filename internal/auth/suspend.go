@@ -0,0 +1,42 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package auth
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// maxOwnerDepth holds the maximum number of owners that will be
+// followed when checking whether an agent's owner is suspended.
+const maxOwnerDepth = 10
+
+// CheckNotSuspended checks that neither the given identity, nor any
+// identity that owns it, has been suspended. If one has then an error
+// with a cause of params.ErrForbidden is returned.
+func (a *Authorizer) CheckNotSuspended(ctx context.Context, id *store.Identity) error {
+	if id.Suspended {
+		return errgo.WithCausef(nil, params.ErrForbidden, "user %s is suspended", id.Username)
+	}
+	owner := id
+	for i := 0; i < maxOwnerDepth && owner.Owner != ""; i++ {
+		owner = &store.Identity{
+			ProviderID: owner.Owner,
+		}
+		if err := a.store.Identity(ctx, owner); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				return nil
+			}
+			return errgo.Mask(err)
+		}
+		if owner.Suspended {
+			return errgo.WithCausef(nil, params.ErrForbidden, "user %s is owned by suspended user %s", id.Username, owner.Username)
+		}
+	}
+	return nil
+}
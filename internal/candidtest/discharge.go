@@ -21,6 +21,8 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/canonical/candid/idp/idputil/totp"
 )
 
 // DischargeCreator represents a third party service
@@ -190,6 +192,65 @@ func PostLoginForm(username, password string) ResponseHandler {
 	}
 }
 
+// PostTOTPForm returns a ResponseHandler that can be passed to
+// OpenWebBrowser which will complete a second factor form with a code
+// generated from the given secret for the given time, and return the
+// result. If secret is empty then the secret being enrolled, as shown
+// on the form, is used.
+func PostTOTPForm(secret string, t time.Time) ResponseHandler {
+	return func(client *http.Client, resp *http.Response) (*http.Response, error) {
+		defer resp.Body.Close()
+		purl, token, enrollSecret, err := totpForm(resp)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if secret == "" {
+			secret = enrollSecret
+		}
+		code, err := totp.Code(secret, t)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		resp, err = client.PostForm(purl, url.Values{
+			"token": {token},
+			"code":  {code},
+		})
+		return resp, errgo.Mask(err, errgo.Any)
+	}
+}
+
+// SkipTOTPEnrollment is a ResponseHandler that skips an optional second
+// factor enrollment form, and returns the result.
+func SkipTOTPEnrollment(client *http.Client, resp *http.Response) (*http.Response, error) {
+	defer resp.Body.Close()
+	purl, token, _, err := totpForm(resp)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp, err = client.PostForm(purl, url.Values{
+		"token": {token},
+		"skip":  {"skip"},
+	})
+	return resp, errgo.Mask(err, errgo.Any)
+}
+
+// totpForm reads the action, token and secret from a form generated by
+// the "totp-form" template in this package.
+func totpForm(resp *http.Response) (action, token, secret string, err error) {
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", "", errgo.Mask(err, errgo.Any)
+	}
+	parts := bytes.Split(buf, []byte("\n"))
+	if len(parts) < 4 {
+		return "", "", "", errgo.Newf("unexpected second factor form %q", buf)
+	}
+	if len(parts[1]) > 0 {
+		return "", "", "", errgo.New(string(parts[1]))
+	}
+	return string(parts[0]), string(parts[2]), string(parts[3]), nil
+}
+
 // SelectInteractiveLogin is a ResponseHandler that processes the list of
 // login methods in the incoming response and performs a GET on that URL.
 // If rh is non-nil it will be used to further process the response
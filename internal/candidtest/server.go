@@ -31,6 +31,9 @@ func init() {
 	template.Must(DefaultTemplate.New("authentication-required").Parse(authenticationRequiredTemplate))
 	template.Must(DefaultTemplate.New("login").Parse(loginTemplate))
 	template.Must(DefaultTemplate.New("login-form").Parse(loginFormTemplate))
+	template.Must(DefaultTemplate.New("totp-form").Parse(totpFormTemplate))
+	template.Must(DefaultTemplate.New("webauthn-login").Parse(webauthnTemplate))
+	template.Must(DefaultTemplate.New("webauthn-register").Parse(webauthnTemplate))
 }
 
 const (
@@ -38,6 +41,9 @@ const (
 	authenticationRequiredTemplate = "{{range .IDPs}}{{.URL}}\n{{end}}"
 	loginTemplate                  = "login successful as user {{.Username}}\n"
 	loginFormTemplate              = "{{.Action}}\n{{.Error}}\n"
+	// This format is interpretted by PostTOTPForm.
+	totpFormTemplate = "{{.Action}}\n{{.Error}}\n{{.Token}}\n{{.Secret}}\n"
+	webauthnTemplate = "{{.Action}}\n{{.Error}}\n{{.Token}}\n{{.Options}}\n{{.Complete}}\n"
 )
 
 // Server implements a test fixture that contains a candid server.
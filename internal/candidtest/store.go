@@ -13,6 +13,7 @@ import (
 	"github.com/juju/simplekv/memsimplekv"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/internal/identity"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
@@ -27,6 +28,7 @@ type Store struct {
 	MeetingStore       meeting.Store
 	BakeryRootKeyStore bakery.RootKeyStore
 	ACLStore           aclstore.ACLStore
+	AuditStore         audit.Store
 }
 
 // NewStore returns a new Store that uses in-memory storage.
@@ -37,6 +39,7 @@ func NewStore() *Store {
 		MeetingStore:       memstore.NewMeetingStore(),
 		BakeryRootKeyStore: bakery.NewMemRootKeyStore(),
 		ACLStore:           aclstore.NewACLStore(memsimplekv.NewStore()),
+		AuditStore:         memstore.NewAuditStore(),
 	}
 }
 
@@ -49,6 +52,7 @@ func (s *Store) ServerParams() identity.ServerParams {
 		MeetingStore:      s.MeetingStore,
 		RootKeyStore:      s.BakeryRootKeyStore,
 		ACLStore:          s.ACLStore,
+		AuditSink:         s.AuditStore,
 	}
 }
 
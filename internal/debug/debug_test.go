@@ -106,6 +106,7 @@ func newFixture(c *qt.C) *fixture {
 		MeetingStore:            backend.MeetingStore(),
 		RootKeyStore:            backend.BakeryRootKeyStore(),
 		Store:                   backend.Store(),
+		ProviderDataStore:       backend.ProviderDataStore(),
 		DebugStatusCheckerFuncs: backend.DebugStatusCheckerFuncs(),
 		DebugTeams:              []string{"debuggers"},
 	}
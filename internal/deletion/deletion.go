@@ -0,0 +1,130 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package deletion deletes identities along with the agents they own,
+// and records each deletion so that tokens issued to a deleted identity
+// are not accepted for a later identity with the same username or
+// provider ID.
+package deletion
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/store"
+)
+
+// KeyValueStore is the name of the key-value store, in the
+// ProviderDataStore, that holds the record of deleted identities.
+const KeyValueStore = "_deleted"
+
+// Params holds the parameters used when deleting identities.
+type Params struct {
+	// Store holds the store containing the identities.
+	Store store.Store
+
+	// KeyValueStore holds the store in which deletions are recorded.
+	KeyValueStore simplekv.Store
+
+	// Audit, if not nil, is called to record an audit event for
+	// each deleted identity.
+	Audit func(context.Context, audit.Event)
+}
+
+// Delete deletes the given identity after first deleting all of the
+// agents it owns, so that no agent is left without an owner. The
+// tokens of every deleted identity are revoked before it is removed.
+// If the identity cannot be found an error with a cause of
+// store.ErrNotFound is returned.
+func Delete(ctx context.Context, p Params, identity *store.Identity) error {
+	agents, err := p.Store.FindIdentities(ctx, &store.Identity{
+		Owner: identity.ProviderID,
+	}, store.Filter{
+		store.Owner: store.Equal,
+	}, nil, 0, 0)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range agents {
+		if err := Delete(ctx, p, &agents[i]); err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+	}
+	err = record(ctx, p.KeyValueStore, identity, time.Now())
+	if err == nil {
+		err = p.Store.DeleteIdentity(ctx, &store.Identity{
+			ID: identity.ID,
+		})
+	}
+	if p.Audit != nil {
+		e := audit.Event{
+			Time:   time.Now(),
+			Type:   audit.DeleteUser,
+			User:   identity.Username,
+			Remove: identity.Groups,
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		p.Audit(ctx, e)
+	}
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// Revoked returns the time at which the tokens of the most recently
+// deleted identity with the same username or provider ID as the given
+// identity were revoked. If no such identity has been deleted the zero
+// time is returned.
+func Revoked(ctx context.Context, kv simplekv.Store, identity *store.Identity) (time.Time, error) {
+	var revoked time.Time
+	for _, key := range keys(identity) {
+		buf, err := kv.Get(ctx, key)
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, errgo.Mask(err)
+		}
+		var t time.Time
+		if err := t.UnmarshalText(buf); err != nil {
+			return time.Time{}, errgo.Notef(err, "invalid deletion record %q", key)
+		}
+		if t.After(revoked) {
+			revoked = t
+		}
+	}
+	return revoked, nil
+}
+
+// record records the deletion of the given identity at the given time.
+// The record is kept indefinitely as tokens do not declare when they
+// expire.
+func record(ctx context.Context, kv simplekv.Store, identity *store.Identity, t time.Time) error {
+	buf, err := t.UTC().MarshalText()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, key := range keys(identity) {
+		if err := kv.Set(ctx, key, buf, time.Time{}); err != nil {
+			return errgo.Notef(err, "cannot record deletion")
+		}
+	}
+	return nil
+}
+
+// keys returns the keys under which deletions of the given identity
+// are recorded.
+func keys(identity *store.Identity) []string {
+	var keys []string
+	if identity.Username != "" {
+		keys = append(keys, "username:"+identity.Username)
+	}
+	if identity.ProviderID != "" {
+		keys = append(keys, "providerid:"+string(identity.ProviderID))
+	}
+	return keys
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package deletion_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/memstore"
+)
+
+func TestDelete(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	st := memstore.NewStore()
+	kv, err := memstore.NewProviderDataStore().KeyValueStore(ctx, deletion.KeyValueStore)
+	c.Assert(err, qt.IsNil)
+
+	alice := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Groups:     []string{"g1"},
+	}
+	candidtest.AddIdentity(ctx, st, &alice)
+	agent := store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+		Username:   "agent1@candid",
+		Owner:      alice.ProviderID,
+	}
+	candidtest.AddIdentity(ctx, st, &agent)
+	bob := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+	}
+	candidtest.AddIdentity(ctx, st, &bob)
+
+	var events []audit.Event
+	p := deletion.Params{
+		Store:         st,
+		KeyValueStore: kv,
+		Audit: func(_ context.Context, e audit.Event) {
+			e.Time = time.Time{}
+			events = append(events, e)
+		},
+	}
+	before := time.Now()
+	err = deletion.Delete(ctx, p, &alice)
+	c.Assert(err, qt.IsNil)
+
+	for _, id := range []store.Identity{alice, agent} {
+		err := st.Identity(ctx, &store.Identity{ProviderID: id.ProviderID})
+		c.Check(errgo.Cause(err), qt.Equals, store.ErrNotFound, qt.Commentf("%s", id.ProviderID))
+	}
+	err = st.Identity(ctx, &store.Identity{ProviderID: bob.ProviderID})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type: audit.DeleteUser,
+		User: "agent1@candid",
+	}, {
+		Type:   audit.DeleteUser,
+		User:   "alice",
+		Remove: []string{"g1"},
+	}})
+
+	// The deletion is found by either the username or the provider
+	// ID.
+	for _, id := range []store.Identity{
+		{Username: "alice"},
+		{ProviderID: alice.ProviderID},
+		{Username: "agent1@candid"},
+	} {
+		revoked, err := deletion.Revoked(ctx, kv, &id)
+		c.Assert(err, qt.IsNil)
+		c.Check(revoked.Before(before), qt.IsFalse, qt.Commentf("%#v", id))
+	}
+	revoked, err := deletion.Revoked(ctx, kv, &bob)
+	c.Assert(err, qt.IsNil)
+	c.Assert(revoked.IsZero(), qt.IsTrue)
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv, err := memstore.NewProviderDataStore().KeyValueStore(ctx, deletion.KeyValueStore)
+	c.Assert(err, qt.IsNil)
+	var events []audit.Event
+	err = deletion.Delete(ctx, deletion.Params{
+		Store:         memstore.NewStore(),
+		KeyValueStore: kv,
+		Audit: func(_ context.Context, e audit.Event) {
+			events = append(events, e)
+		},
+	}, &store.Identity{
+		ID:       "1234",
+		Username: "alice",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Error, qt.Not(qt.Equals), "")
+}
@@ -89,6 +89,7 @@ func (h *handler) agentMacaroon(ctx context.Context, vers bakery.Version, op bak
 		[]checkers.Caveat{
 			checkers.TimeBeforeCaveat(time.Now().Add(agentLoginMacaroonDuration)),
 			candidclient.UserDeclaration(user),
+			auth.IssuedDeclaration(time.Now()),
 			bakery.LocalThirdPartyCaveat(key, vers),
 			auth.UserHasPublicKeyCaveat(params.Username(user), key),
 		},
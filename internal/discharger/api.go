@@ -82,6 +82,18 @@ func NewAPIHandler(params identity.HandlerParams) ([]httprequest.Handler, error)
 		})
 	}
 	handlers = append(handlers, idpHandlers(params)...)
+
+	oidcKVStore, err := params.ProviderDataStore.KeyValueStore(context.Background(), "_oidc")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	oi := &oidcIssuer{
+		params:        params,
+		identityStore: idstore,
+		codec:         codec,
+		kvstore:       oidcKVStore,
+	}
+	handlers = append(handlers, oi.handlers()...)
 	return handlers, nil
 }
 
@@ -19,16 +19,19 @@ import (
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	"gopkg.in/macaroon-bakery.v2/httpbakery/agent"
 	"gopkg.in/macaroon.v2"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/candidclient"
 	"github.com/canonical/candid/candidclient/redirect"
 	"github.com/canonical/candid/internal/auth"
 	"github.com/canonical/candid/internal/auth/httpauth"
 	"github.com/canonical/candid/internal/identity"
 	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/policy"
 	"github.com/canonical/candid/store"
 )
 
@@ -72,6 +75,7 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		forceLegacy = true
 	}
 	var op bakery.Op
+	var within time.Duration
 	switch cond {
 	case "is-authenticated-user", "is-authenticated-userid":
 		op = auth.GlobalOp(auth.ActionDischarge)
@@ -88,17 +92,43 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		ctx = auth.ContextWithRequiredDomain(ctx, domain)
 	case "is-member-of":
 		op = auth.GroupsDischargeOp(strings.Fields(args))
+	case "is-member-of-all", "is-not-member-of":
+		if len(strings.Fields(args)) == 0 {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "no groups specified")
+		}
+		op = auth.GlobalOp(auth.ActionDischarge)
+	case "is-authenticated-user-in-idp":
+		if args == "" {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "no identity provider specified")
+		}
+		op = auth.GlobalOp(auth.ActionDischarge)
+	case "is-authenticated-user-with":
+		if err := checkAttributes(args); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		op = auth.GlobalOp(auth.ActionDischarge)
+	case "is-authenticated-user-within":
+		within, err = time.ParseDuration(args)
+		if err != nil || within <= 0 {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid duration %q", args)
+		}
+		op = auth.GlobalOp(auth.ActionDischarge)
 	default:
 		return nil, checkers.ErrCaveatNotRecognized
 	}
 
 	var mss []macaroon.Slice
-	if user := p.Request.Form.Get("discharge-for-user"); user != "" {
-		_, err = c.reqAuth.Auth(ctx, p.Request, auth.GlobalOp(auth.ActionDischargeFor))
+	var actor string
+	dischargeForUser := p.Request.Form.Get("discharge-for-user")
+	if dischargeForUser != "" {
+		actorAuthInfo, err := c.reqAuth.Auth(ctx, p.Request, auth.GlobalOp(auth.ActionDischargeFor))
 		if err != nil {
 			return nil, errgo.Mask(err, errgo.Is(params.ErrUnauthorized), isDischargeRequiredError)
 		}
-		ctx = auth.ContextWithUsername(ctx, user)
+		if actorAuthInfo.Identity != nil {
+			actor = actorAuthInfo.Identity.Id()
+		}
+		ctx = auth.ContextWithUsername(ctx, dischargeForUser)
 	} else if p.Token != nil {
 		tokenMacaroons, err := macaroonsFromDischargeToken(ctx, p.Token)
 		if err != nil {
@@ -113,6 +143,19 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 	}
 
 	authInfo, err := c.params.Authorizer.Auth(ctx, mss, op)
+	var elevation *store.Elevation
+	var elevatedGroup string
+	if cond == "is-member-of" && errgo.Cause(err) == params.ErrUnauthorized {
+		// The user is not a member of any of the groups, but may
+		// have an approved elevation into one of them.
+		if authInfo1, e := c.elevatedAuth(ctx, mss, strings.Fields(args)); authInfo1 != nil {
+			authInfo, err = authInfo1, nil
+			elevation, elevatedGroup = e, e.Group
+		}
+	}
+	if err == nil && within != 0 {
+		err = checkRecentLogin(authInfo, within, dischargeForUser != "")
+	}
 	if _, ok := errgo.Cause(err).(*bakery.DischargeRequiredError); ok {
 		return nil, c.interactionRequiredError(ctx, interactionRequiredParams{
 			why:         err,
@@ -128,13 +171,45 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		})
 	}
 	if err != nil {
+		c.auditDischarge(ctx, string(p.Caveat.Condition), actor, dischargeForUser, "", err)
 		// TODO return appropriate error code when permission denied.
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, errgo.Is(params.ErrForbidden))
+	}
+	if err := c.checkIdentity(ctx, cond, args, authInfo); err != nil {
+		c.auditDischarge(ctx, string(p.Caveat.Condition), actor, authInfo.Identity.Id(), "", err)
+		return nil, errgo.Mask(err, errgo.Is(params.ErrForbidden))
+	}
+	decision, err := c.checkPolicy(ctx, p.Request, cond, authInfo)
+	if err != nil {
+		c.auditDischarge(ctx, string(p.Caveat.Condition), actor, authInfo.Identity.Id(), elevatedGroup, err)
+		return nil, errgo.Mask(err, errgo.Is(params.ErrForbidden))
 	}
 	logger.Debugf("authorization for %#v succeeded", authInfo.Identity)
+	c.auditDischarge(ctx, string(p.Caveat.Condition), actor, authInfo.Identity.Id(), elevatedGroup, nil)
 	c.updateDischargeTime(ctx, authInfo.Identity.Id())
-	if cond == "is-member-of" {
-		return nil, nil
+	now := time.Now()
+	switch cond {
+	case "is-member-of", "is-member-of-all", "is-not-member-of":
+		caveats := decision.Caveats
+		if decision.Expiry != 0 {
+			caveats = append(caveats, checkers.TimeBeforeCaveat(now.Add(decision.Expiry)))
+		}
+		// Membership granted by an elevation must not outlive
+		// the elevation.
+		var expires time.Time
+		if elevation != nil {
+			expires = elevation.Expires
+		}
+		if cond == "is-member-of-all" {
+			expires, err = c.elevationExpiry(ctx, authInfo.Identity.Id(), strings.Fields(args))
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		if !expires.IsZero() {
+			caveats = append(caveats, checkers.TimeBeforeCaveat(expires))
+		}
+		return caveats, nil
 	}
 	if p.Token != nil && len(mss) > 0 {
 		// As well as discharging the original third party caveat, also
@@ -146,22 +221,292 @@ func (c *thirdPartyCaveatChecker) checkThirdPartyCaveat(ctx context.Context, p h
 		}
 	}
 
-	var declaration checkers.Caveat
+	var declarations []checkers.Caveat
 	switch cond {
-	case "is-authenticated-user":
-		declaration = candidclient.UserDeclaration(authInfo.Identity.Id())
+	case "is-authenticated-user", "is-authenticated-user-in-idp", "is-authenticated-user-within":
+		declarations = append(declarations, candidclient.UserDeclaration(authInfo.Identity.Id()))
+	case "is-authenticated-user-with":
+		declarations = append(declarations, candidclient.UserDeclaration(authInfo.Identity.Id()))
+		attrs, err := attributeDeclarations(ctx, authInfo, strings.Split(args, ","))
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		declarations = append(declarations, attrs...)
 	case "is-authenticated-userid":
 		id, ok := authInfo.Identity.(*auth.Identity)
 		if !ok {
 			return nil, errgo.Newf("unexpected authinfo type %T", authInfo)
 		}
-		declaration = candidclient.UserIDDeclaration(string(id.ProviderID))
+		declarations = append(declarations, candidclient.UserIDDeclaration(string(id.ProviderID)))
+	}
+
+	expiry := c.params.DischargeMacaroonTimeout
+	if decision.Expiry != 0 && decision.Expiry < expiry {
+		expiry = decision.Expiry
+	}
+	expires := now.Add(expiry)
+	if id, ok := authInfo.Identity.(*auth.Identity); ok && within != 0 {
+		// The discharge only proves a recent login for as long
+		// as the login remains recent.
+		if t := id.LastLogin.Add(within); t.Before(expires) {
+			expires = t
+		}
+	}
+	caveats := append(declarations,
+		auth.IssuedDeclaration(now),
+		checkers.TimeBeforeCaveat(expires),
+	)
+	return append(caveats, decision.Caveats...), nil
+}
+
+// checkAttributes checks that the given comma-separated list of user
+// attributes only contains attributes that can be declared.
+func checkAttributes(attrs string) error {
+	if attrs == "" {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "no attributes specified")
+	}
+	for _, attr := range strings.Split(attrs, ",") {
+		switch attr {
+		case candidclient.AttrEmail, candidclient.AttrFullName, candidclient.AttrGroups, candidclient.AttrIdentityProvider:
+		default:
+			return errgo.WithCausef(nil, params.ErrBadRequest, "unknown attribute %q", attr)
+		}
+	}
+	return nil
+}
+
+// attributeDeclarations returns declaration caveats for the given
+// attributes of the authenticated identity. Groups that the identity is
+// only temporarily a member of through an elevation are not declared.
+func attributeDeclarations(ctx context.Context, authInfo *identchecker.AuthInfo, attrs []string) ([]checkers.Caveat, error) {
+	id, ok := authInfo.Identity.(*auth.Identity)
+	if !ok {
+		return nil, errgo.Newf("unexpected identity type %T", authInfo.Identity)
+	}
+	caveats := make([]checkers.Caveat, 0, len(attrs))
+	for _, attr := range attrs {
+		var value string
+		switch attr {
+		case candidclient.AttrEmail:
+			value = id.Email
+		case candidclient.AttrFullName:
+			value = id.Name
+		case candidclient.AttrGroups:
+			groups, err := id.Groups(ctx)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			value = strings.Join(groups, " ")
+		case candidclient.AttrIdentityProvider:
+			value = id.ProviderID.Provider()
+		}
+		caveats = append(caveats, checkers.DeclaredCaveat(attr, value))
+	}
+	return caveats, nil
+}
+
+// checkPolicy evaluates the discharge policy for a discharge of a
+// caveat with the given condition to the given identity. If the policy
+// denies the discharge then an error with a cause of
+// params.ErrForbidden is returned.
+func (c *thirdPartyCaveatChecker) checkPolicy(ctx context.Context, req *http.Request, cond string, authInfo *identchecker.AuthInfo) (policy.Decision, error) {
+	if len(c.params.DischargePolicy) == 0 {
+		return policy.Decision{Allow: true}, nil
+	}
+	preq := policy.Request{
+		Condition: cond,
+		Username:  authInfo.Identity.Id(),
+		Time:      time.Now(),
+	}
+	if id, ok := authInfo.Identity.(*auth.Identity); ok {
+		preq.IdentityProvider = id.ProviderID.Provider()
+	}
+	groups, complete, err := c.identityGroups(ctx, authInfo)
+	if err != nil {
+		return policy.Decision{}, errgo.Mask(err)
+	}
+	preq.Groups = groups
+	preq.GroupsIncomplete = !complete
+	preq.SourceAddress = policy.SourceAddress(req, c.params.TrustedProxies)
+	d := c.params.Policy.Evaluate(preq)
+	if !d.Allow {
+		return d, errgo.WithCausef(nil, params.ErrForbidden, "discharge denied by policy rule %q", d.Rule)
+	}
+	if d.Rule != "" {
+		logger.Debugf("discharge for %s allowed by policy rule %q", preq.Username, d.Rule)
+	}
+	return d, nil
+}
+
+// elevatedAuth authenticates the user making a discharge request. If
+// the user has an active elevation into one of the given groups then
+// the authentication information is returned along with that
+// elevation, otherwise a nil AuthInfo is returned.
+func (c *thirdPartyCaveatChecker) elevatedAuth(ctx context.Context, mss []macaroon.Slice, groups []string) (*identchecker.AuthInfo, *store.Elevation) {
+	authInfo, err := c.params.Authorizer.Auth(ctx, mss, auth.GlobalOp(auth.ActionDischarge))
+	if err != nil || authInfo.Identity == nil {
+		return nil, nil
+	}
+	elevations, err := c.activeElevations(ctx, authInfo.Identity.Id())
+	if err != nil {
+		logger.Errorf("cannot find elevations for %s: %s", authInfo.Identity.Id(), err)
+		return nil, nil
+	}
+	for i, e := range elevations {
+		for _, g := range groups {
+			if g == e.Group {
+				logger.Infof("discharging %s as a member of %s using elevation %s", e.Username, e.Group, e.ID)
+				return authInfo, &elevations[i]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// elevationExpiry returns the earliest time at which an active
+// elevation of the given user into one of the given groups expires. If
+// there is no such elevation the zero time is returned.
+func (c *thirdPartyCaveatChecker) elevationExpiry(ctx context.Context, username string, groups []string) (time.Time, error) {
+	elevations, err := c.activeElevations(ctx, username)
+	if err != nil {
+		return time.Time{}, errgo.Mask(err)
+	}
+	var expires time.Time
+	for _, e := range elevations {
+		for _, g := range groups {
+			if g == e.Group && (expires.IsZero() || e.Expires.Before(expires)) {
+				expires = e.Expires
+			}
+		}
+	}
+	return expires, nil
+}
+
+// checkRecentLogin checks that the authenticated identity has logged in
+// within the given duration. If it has not then an error is returned
+// that will force the user to log in again. A login cannot be forced
+// when discharging on behalf of another user, so in that case an error
+// with a cause of params.ErrForbidden is returned instead.
+func checkRecentLogin(authInfo *identchecker.AuthInfo, within time.Duration, forUser bool) error {
+	id, ok := authInfo.Identity.(*auth.Identity)
+	if !ok {
+		return errgo.Newf("unexpected identity type %T", authInfo.Identity)
+	}
+	if time.Since(id.LastLogin) <= within {
+		return nil
+	}
+	msg := fmt.Sprintf("user %s has not logged in within %v", id.Id(), within)
+	if forUser {
+		return errgo.WithCausef(nil, params.ErrForbidden, "%s", msg)
+	}
+	return &bakery.DischargeRequiredError{
+		Message:           msg,
+		ForAuthentication: true,
+	}
+}
+
+// activeElevations returns the elevations that currently grant the
+// given user temporary membership of a group.
+func (c *thirdPartyCaveatChecker) activeElevations(ctx context.Context, username string) ([]store.Elevation, error) {
+	elevations, err := c.params.Store.FindElevations(ctx, store.ElevationFilter{
+		Username: username,
+		State:    store.ElevationApproved,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	now := time.Now()
+	active := elevations[:0]
+	for _, e := range elevations {
+		if e.Active(now) {
+			active = append(active, e)
+		}
+	}
+	return active, nil
+}
+
+// checkIdentity checks that the authenticated identity satisfies the
+// given caveat condition, for those conditions that cannot be checked
+// by the authorizer alone.
+func (c *thirdPartyCaveatChecker) checkIdentity(ctx context.Context, cond, args string, authInfo *identchecker.AuthInfo) error {
+	switch cond {
+	case "is-member-of-all", "is-not-member-of":
+	case "is-authenticated-user-in-idp":
+		id, ok := authInfo.Identity.(*auth.Identity)
+		if !ok || id.ProviderID.Provider() != args {
+			return errgo.WithCausef(nil, params.ErrForbidden, "user %s is not authenticated by identity provider %q", authInfo.Identity.Id(), args)
+		}
+		return nil
+	default:
+		return nil
+	}
+	groups, complete, err := c.identityGroups(ctx, authInfo)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !complete && cond == "is-not-member-of" {
+		// The user may be a member of one of the groups that
+		// could not be resolved.
+		return errgo.WithCausef(nil, params.ErrForbidden, "cannot determine the groups of user %s", authInfo.Identity.Id())
+	}
+	isMember := make(map[string]bool)
+	for _, g := range groups {
+		isMember[g] = true
+	}
+	for _, g := range strings.Fields(args) {
+		if isMember[g] != (cond == "is-member-of-all") {
+			return errgo.WithCausef(nil, params.ErrForbidden, "permission denied")
+		}
 	}
+	return nil
+}
 
-	return []checkers.Caveat{
-		declaration,
-		checkers.TimeBeforeCaveat(time.Now().Add(c.params.DischargeMacaroonTimeout)),
-	}, nil
+// identityGroups returns all the groups that the given identity is a
+// member of, including any that it is temporarily a member of through
+// an active elevation. If the groups could not all be resolved then
+// the groups that could be resolved are returned and the returned bool
+// is false.
+func (c *thirdPartyCaveatChecker) identityGroups(ctx context.Context, authInfo *identchecker.AuthInfo) ([]string, bool, error) {
+	var groups []string
+	complete := true
+	if id, ok := authInfo.Identity.(*auth.Identity); ok {
+		var err error
+		groups, err = id.ResolveGroups(ctx)
+		if err != nil {
+			logger.Warningf("cannot resolve all groups for %s: %s", id.Id(), err)
+			complete = false
+		}
+	}
+	elevations, err := c.activeElevations(ctx, authInfo.Identity.Id())
+	if err != nil {
+		return nil, false, errgo.Mask(err)
+	}
+	if len(elevations) == 0 {
+		return groups, complete, nil
+	}
+	groups = append([]string(nil), groups...)
+	for _, e := range elevations {
+		groups = append(groups, e.Group)
+	}
+	return groups, complete, nil
+}
+
+// auditDischarge records the result of a discharge in the audit log.
+// The actor is the user that requested a discharge on behalf of
+// another user, if any. The group is the group the user was
+// temporarily treated as a member of by an elevation, if any.
+func (c *thirdPartyCaveatChecker) auditDischarge(ctx context.Context, condition, actor, user, group string, err error) {
+	e := audit.Event{
+		Type:   audit.Discharge,
+		Actor:  actor,
+		User:   user,
+		Caveat: condition,
+		Group:  group,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	c.params.Audit(ctx, e)
 }
 
 func macaroonsFromDischargeToken(ctx context.Context, token *httpbakery.DischargeToken) (macaroon.Slice, error) {
@@ -29,6 +29,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	"gopkg.in/macaroon.v2"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/candidclient"
 	"github.com/canonical/candid/candidclient/redirect"
 	"github.com/canonical/candid/idp"
@@ -62,7 +63,7 @@ func (s *dischargeSuite) Init(c *qt.C) {
 	sp := s.store.ServerParams()
 	sp.AdminPassword = "test-password"
 	sp.IdentityProviders = []idp.IdentityProvider{
-		static.NewIdentityProvider(static.Params{
+		flakyGroupsIDP{static.NewIdentityProvider(static.Params{
 			Name:   "test",
 			Domain: "",
 			Users: map[string]static.UserInfo{
@@ -77,9 +78,13 @@ func (s *dischargeSuite) Init(c *qt.C) {
 					Name:     "Test User II",
 					Email:    "test2@example.com",
 				},
+				"flaky": {
+					Password: "password",
+					Groups:   []string{"test3"},
+				},
 			},
 			Icon: "/static/idp.pcx",
-		}),
+		})},
 		static.NewIdentityProvider(static.Params{
 			Name:   "test-domain",
 			Domain: "test-domain",
@@ -573,6 +578,103 @@ func (s *dischargeSuite) TestDischargeMemberOf(c *qt.C) {
 	}
 }
 
+func (s *dischargeSuite) TestDischargeMemberOfElevation(c *qt.C) {
+	now := time.Now()
+	for _, e := range []store.Elevation{{
+		Username:  "test",
+		Group:     "elevated",
+		Duration:  time.Hour,
+		State:     store.ElevationApproved,
+		Requested: now.Add(-time.Minute),
+		Expires:   now.Add(time.Hour),
+	}, {
+		Username:  "test",
+		Group:     "expired",
+		Duration:  time.Hour,
+		State:     store.ElevationApproved,
+		Requested: now.Add(-2 * time.Hour),
+		Expires:   now.Add(-time.Hour),
+	}, {
+		Username:  "test",
+		Group:     "pending",
+		Duration:  time.Hour,
+		State:     store.ElevationPending,
+		Requested: now.Add(-time.Minute),
+	}, {
+		Username:  "test",
+		Group:     "revoked",
+		Duration:  time.Hour,
+		State:     store.ElevationRevoked,
+		Requested: now.Add(-time.Minute),
+		Expires:   now.Add(time.Hour),
+	}} {
+		e := e
+		err := s.store.Store.AddElevation(testContext, &e)
+		c.Assert(err, qt.IsNil)
+	}
+	client := s.srv.Client(s.interactor)
+	m := s.dischargeCreator.NewMacaroon(c, "is-member-of test3 elevated", groupOp)
+	ms, err := client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+
+	for _, group := range []string{"expired", "pending", "revoked"} {
+		m := s.dischargeCreator.NewMacaroon(c, "is-member-of "+group, groupOp)
+		_, err := client.DischargeAll(testContext, m)
+		c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: permission denied`, qt.Commentf("group %s", group))
+	}
+
+	events, err := s.store.AuditStore.Events(testContext, audit.Filter{
+		Type: audit.Discharge,
+		User: "test",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Caveat, qt.Equals, "is-member-of test3 elevated")
+	c.Assert(events[0].Group, qt.Equals, "elevated")
+}
+
+func (s *dischargeSuite) TestDischargeMemberOfElevationExpires(c *qt.C) {
+	now := time.Now()
+	err := s.store.Store.AddElevation(testContext, &store.Elevation{
+		Username:  "test",
+		Group:     "elevated",
+		Duration:  30 * time.Second,
+		State:     store.ElevationApproved,
+		Requested: now.Add(-time.Minute),
+		Expires:   now.Add(30 * time.Second),
+	})
+	c.Assert(err, qt.IsNil)
+	client := s.srv.Client(s.interactor)
+	for _, cond := range []string{"is-member-of elevated", "is-member-of-all test1 elevated"} {
+		c.Run(cond, func(c *qt.C) {
+			m := s.dischargeCreator.NewMacaroon(c, cond, groupOp)
+			ms, err := client.DischargeAll(testContext, m)
+			c.Assert(err, qt.IsNil)
+			s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+
+			ctx := checkers.ContextWithClock(testContext, fixedClock(now.Add(15*time.Second)))
+			_, err = s.dischargeCreator.Bakery.Checker.Auth(ms).Allow(ctx, groupOp)
+			c.Assert(err, qt.IsNil)
+
+			// The discharge is not accepted once the
+			// elevation has expired, even though the
+			// macaroon itself has not.
+			ctx = checkers.ContextWithClock(testContext, fixedClock(now.Add(45*time.Second)))
+			_, err = s.dischargeCreator.Bakery.Checker.Auth(ms).Allow(ctx, groupOp)
+			c.Assert(err, qt.ErrorMatches, `macaroon discharge required: authentication required`)
+		})
+	}
+}
+
+// fixedClock is a checkers.Clock that always returns the same time.
+type fixedClock time.Time
+
+// Now implements checkers.Clock.Now.
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
 func (s *dischargeSuite) TestDischargeXMemberOfX(c *qt.C) {
 	// if the user is X member of no group, we must still
 	// discharge is-member-of X.
@@ -586,6 +688,306 @@ func (s *dischargeSuite) TestDischargeXMemberOfX(c *qt.C) {
 	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
 }
 
+var dischargeGroupConditionTests = []struct {
+	name        string
+	condition   string
+	expectError string
+}{{
+	name:      "MemberOfAll",
+	condition: "is-member-of-all test1 test2",
+}, {
+	name:        "MemberOfAllMissingGroup",
+	condition:   "is-member-of-all test1 test3",
+	expectError: `cannot get discharge from ".*": Post http.*: cannot discharge: permission denied`,
+}, {
+	name:        "MemberOfAllNoGroups",
+	condition:   "is-member-of-all",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: no groups specified`,
+}, {
+	name:      "NotMemberOf",
+	condition: "is-not-member-of test3 test4",
+}, {
+	name:        "NotMemberOfMember",
+	condition:   "is-not-member-of test3 test2",
+	expectError: `cannot get discharge from ".*": Post http.*: cannot discharge: permission denied`,
+}, {
+	name:        "NotMemberOfNoGroups",
+	condition:   "is-not-member-of",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: no groups specified`,
+}}
+
+func (s *dischargeSuite) TestDischargeGroupConditions(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	for _, test := range dischargeGroupConditionTests {
+		c.Run(test.name, func(c *qt.C) {
+			m := s.dischargeCreator.NewMacaroon(c, test.condition, groupOp)
+			ms, err := client.DischargeAll(testContext, m)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+		})
+	}
+}
+
+func (s *dischargeSuite) TestDischargeGroupConditionsWithElevation(c *qt.C) {
+	now := time.Now()
+	err := s.store.Store.AddElevation(testContext, &store.Elevation{
+		Username:  "test",
+		Group:     "elevated",
+		Duration:  time.Hour,
+		State:     store.ElevationApproved,
+		Requested: now.Add(-time.Minute),
+		Expires:   now.Add(time.Hour),
+	})
+	c.Assert(err, qt.IsNil)
+	client := s.srv.Client(s.interactor)
+	m := s.dischargeCreator.NewMacaroon(c, "is-member-of-all test1 elevated", groupOp)
+	ms, err := client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+
+	m = s.dischargeCreator.NewMacaroon(c, "is-not-member-of elevated", groupOp)
+	_, err = client.DischargeAll(testContext, m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: cannot discharge: permission denied`)
+}
+
+func (s *dischargeSuite) TestDischargeGroupConditionsWithUnresolvedGroups(c *qt.C) {
+	client := s.srv.Client(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: candidtest.PasswordLogin(c, "flaky", "password"),
+	})
+	// The user cannot be shown not to be a member of test4 as
+	// their groups cannot be resolved.
+	m := s.dischargeCreator.NewMacaroon(c, "is-not-member-of test4", groupOp)
+	_, err := client.DischargeAll(testContext, m)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: cannot discharge: cannot determine the groups of user flaky`)
+
+	// Groups that are known can still be checked.
+	err = s.store.Store.UpdateIdentity(testContext, &store.Identity{
+		Username: "flaky",
+		Groups:   []string{"test4"},
+	}, store.Update{
+		store.Groups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	m = s.dischargeCreator.NewMacaroon(c, "is-member-of test4", groupOp)
+	ms, err := client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+}
+
+func (s *dischargeSuite) TestDischargeWithCandidClientGroupCaveats(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	for _, cav := range []checkers.Caveat{
+		candidclient.MemberOfCaveat(s.srv.URL, "test3", "test1"),
+		candidclient.MemberOfAllCaveat(s.srv.URL, "test1", "test2"),
+		candidclient.NotMemberOfCaveat(s.srv.URL, "test3"),
+	} {
+		m, err := s.dischargeCreator.Bakery.Oven.NewMacaroon(testContext, bakery.LatestVersion, []checkers.Caveat{cav}, groupOp)
+		c.Assert(err, qt.IsNil)
+		ms, err := client.DischargeAll(testContext, m)
+		c.Assert(err, qt.IsNil, qt.Commentf("%s", cav.Condition))
+		s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+	}
+}
+
+func (s *dischargeSuite) TestDischargeUserInIDP(c *qt.C) {
+	m, err := s.dischargeCreator.Bakery.Oven.NewMacaroon(
+		testContext,
+		bakery.LatestVersion,
+		candidclient.IdentityProviderCaveats(s.srv.URL, "test"),
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.IsNil)
+	ms, err := s.srv.Client(s.interactor).DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+}
+
+func (s *dischargeSuite) TestDischargeUserInIDPWrongIDP(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user-in-idp test-domain", client)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": third party refused discharge: cannot discharge: user test is not authenticated by identity provider "test-domain"`)
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user-in-idp test", client)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+}
+
+func (s *dischargeSuite) TestDischargeUserInIDPNoIDP(c *qt.C) {
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user-in-idp", s.srv.Client(s.interactor))
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": third party refused discharge: cannot discharge: no identity provider specified`)
+}
+
+func (s *dischargeSuite) TestDischargeUserWithin(c *qt.C) {
+	logins := 0
+	login := candidtest.PasswordLogin(c, "test", "password")
+	client := s.srv.Client(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: func(u *url.URL) error {
+			logins++
+			return login(u)
+		},
+	})
+	m, err := s.dischargeCreator.Bakery.Oven.NewMacaroon(
+		testContext,
+		bakery.LatestVersion,
+		candidclient.RecentIdentityCaveats(s.srv.URL, 15*time.Minute),
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.IsNil)
+	ms, err := client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	c.Assert(logins, qt.Equals, 1)
+
+	// The discharge is not accepted once the login is no longer
+	// recent.
+	ctx := checkers.ContextWithClock(testContext, fixedClock(time.Now().Add(16*time.Minute)))
+	_, err = s.dischargeCreator.Bakery.Checker.Auth(ms).Allow(ctx, identchecker.LoginOp)
+	c.Assert(err, qt.ErrorMatches, `macaroon discharge required: authentication required`)
+
+	// A recent login means the existing identity is accepted.
+	ms, err = client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	c.Assert(logins, qt.Equals, 1)
+
+	// Once the last login is too old the user has to log in again,
+	// even though they have a valid identity.
+	err = s.store.Store.UpdateIdentity(testContext, &store.Identity{
+		Username:  "test",
+		LastLogin: time.Now().Add(-time.Hour),
+	}, store.Update{
+		store.LastLogin: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	c.Assert(logins, qt.Equals, 1)
+	ms, err = client.DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	c.Assert(logins, qt.Equals, 2)
+
+	id := store.Identity{Username: "test"}
+	err = s.store.Store.Identity(testContext, &id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(time.Since(id.LastLogin) < time.Minute, qt.Equals, true)
+}
+
+func (s *dischargeSuite) TestDischargeUserWithinForUser(c *qt.C) {
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.srv.Client(s.interactor))
+	c.Assert(err, qt.IsNil)
+	da := &testDischargeAcquirer{
+		client: &httprequest.Client{
+			BaseURL: s.srv.URL,
+		},
+		username:         "admin",
+		password:         "test-password",
+		dischargeForUser: "test",
+	}
+	m := s.dischargeCreator.NewMacaroon(c, "is-authenticated-user-within 15m", identchecker.LoginOp)
+	ms, err := bakery.DischargeAll(testContext, m, da.AcquireDischarge)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+
+	err = s.store.Store.UpdateIdentity(testContext, &store.Identity{
+		Username:  "test",
+		LastLogin: time.Now().Add(-time.Hour),
+	}, store.Update{
+		store.LastLogin: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = bakery.DischargeAll(testContext, m, da.AcquireDischarge)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post .*/discharge: cannot discharge: user test has not logged in within 15m0s`)
+}
+
+func (s *dischargeSuite) TestDischargeUserWithAttributes(c *qt.C) {
+	m, err := s.dischargeCreator.Bakery.Oven.NewMacaroon(
+		testContext,
+		bakery.LatestVersion,
+		candidclient.IdentityAttributeCaveats(
+			s.srv.URL,
+			candidclient.AttrEmail,
+			candidclient.AttrFullName,
+			candidclient.AttrGroups,
+			candidclient.AttrIdentityProvider,
+		),
+		identchecker.LoginOp,
+	)
+	c.Assert(err, qt.IsNil)
+	ms, err := s.srv.Client(s.interactor).DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	declared := checkers.InferDeclared(nil, ms)
+	delete(declared, "issued")
+	c.Assert(declared, qt.DeepEquals, map[string]string{
+		"username": "test",
+		"email":    "test@example.com",
+		"fullname": "Test User",
+		"groups":   "test1 test2",
+		"idp":      "test",
+	})
+}
+
+func (s *dischargeSuite) TestDischargeUserWithSomeAttributes(c *qt.C) {
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user-with email", s.srv.Client(s.interactor))
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	declared := checkers.InferDeclared(nil, ms)
+	delete(declared, "issued")
+	c.Assert(declared, qt.DeepEquals, map[string]string{
+		"username": "test",
+		"email":    "test@example.com",
+	})
+}
+
+var dischargeUserWithErrorTests = []struct {
+	condition   string
+	expectError string
+}{{
+	condition:   "is-authenticated-user-with",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: no attributes specified`,
+}, {
+	condition:   "is-authenticated-user-with email,password",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: unknown attribute "password"`,
+}}
+
+func (s *dischargeSuite) TestDischargeUserWithErrors(c *qt.C) {
+	for _, test := range dischargeUserWithErrorTests {
+		c.Run(test.condition, func(c *qt.C) {
+			_, err := s.dischargeCreator.Discharge(c, test.condition, s.srv.Client(s.interactor))
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+var dischargeUserWithinErrorTests = []struct {
+	condition   string
+	expectError string
+}{{
+	condition:   "is-authenticated-user-within",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: invalid duration ""`,
+}, {
+	condition:   "is-authenticated-user-within soon",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: invalid duration "soon"`,
+}, {
+	condition:   "is-authenticated-user-within -5m",
+	expectError: `cannot get discharge from ".*": third party refused discharge: cannot discharge: invalid duration "-5m"`,
+}}
+
+func (s *dischargeSuite) TestDischargeUserWithinErrors(c *qt.C) {
+	for _, test := range dischargeUserWithinErrorTests {
+		c.Run(test.condition, func(c *qt.C) {
+			_, err := s.dischargeCreator.Discharge(c, test.condition, s.srv.Client(s.interactor))
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
 // This test is not sending the bakery protocol version so it will use the default
 // one and return a 407.
 func (s *dischargeSuite) TestDischargeStatusProxyAuthRequiredResponse(c *qt.C) {
@@ -742,6 +1144,108 @@ func (s *dischargeSuite) TestLastDischargeTimeUpdates(c *qt.C) {
 	c.Assert(id2.LastDischarge.After(id1.LastDischarge), qt.Equals, true)
 }
 
+func (s *dischargeSuite) TestDischargeAfterTokensRevoked(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+
+	// A client without an interactor can discharge using the
+	// identity cookie obtained when logging in.
+	nonInteractiveClient := &httpbakery.Client{
+		Client: client.Client,
+	}
+	ms, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", nonInteractiveClient)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+
+	err = s.srv.AdminIdentityClient(false).RevokeUserTokens(testContext, &params.RevokeUserTokensRequest{
+		Username: "test",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// The identity cookie is no longer accepted.
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", nonInteractiveClient)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": cannot start interactive session: interaction required but not possible`)
+
+	// Logging in again works.
+	ms, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+}
+
+func (s *dischargeSuite) TestDischargeSuspendedUser(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+
+	err = s.srv.AdminIdentityClient(false).SetUserSuspended(testContext, &params.SetUserSuspendedRequest{
+		Username: "test",
+		Body: params.SetUserSuspendedBody{
+			Suspended: true,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	// The existing identity cookie is refused.
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": third party refused discharge: cannot discharge: user test is suspended`)
+
+	// Logging in again is refused.
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", s.srv.Client(s.interactor))
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": cannot acquire discharge token: user test is suspended`)
+}
+
+func (s *dischargeSuite) TestDischargeAudit(c *qt.C) {
+	client := s.srv.Client(s.interactor)
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+
+	err = s.srv.AdminIdentityClient(false).SetUserSuspended(testContext, &params.SetUserSuspendedRequest{
+		Username: "test",
+		Body: params.SetUserSuspendedBody{
+			Suspended: true,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", client)
+	c.Assert(err, qt.Not(qt.IsNil))
+	_, err = s.dischargeCreator.Discharge(c, "is-authenticated-user", s.srv.Client(s.interactor))
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	events, err := s.store.AuditStore.Events(testContext, audit.Filter{})
+	c.Assert(err, qt.IsNil)
+	var dischargeEvents []audit.Event
+	for _, e := range events {
+		c.Assert(e.Time.IsZero(), qt.Equals, false)
+		e.Time = time.Time{}
+		if e.Type == audit.Discharge && e.User == auth.AdminUsername {
+			// Ignore the discharge made for the admin client.
+			continue
+		}
+		dischargeEvents = append(dischargeEvents, e)
+	}
+	c.Assert(dischargeEvents, qt.DeepEquals, []audit.Event{{
+		Type: audit.Login,
+		User: "test",
+		IDP:  "test",
+	}, {
+		Type:   audit.Discharge,
+		User:   "test",
+		Caveat: "is-authenticated-user",
+	}, {
+		Type:   audit.Discharge,
+		Caveat: "is-authenticated-user",
+		Error:  "user test is suspended",
+	}, {
+		Type:  audit.Login,
+		IDP:   "test",
+		Error: "user test is suspended",
+	}})
+}
+
 var domainInteractionURLTests = []struct {
 	about        string
 	condition    string
@@ -20,6 +20,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	macaroon "gopkg.in/macaroon.v2"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/candidclient"
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/idputil/secret"
@@ -70,6 +71,7 @@ func newIDPHandler(params identity.HandlerParams, idp idp.IdentityProvider) http
 		defer close()
 		ctx, close = params.MeetingStore.Context(ctx)
 		defer close()
+		ctx = contextWithIDPName(ctx, idp.Name())
 		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/login/"+idp.Name())
 		req.ParseForm()
 		idp.Handle(ctx, w, req)
@@ -81,12 +83,14 @@ type dischargeTokenCreator struct {
 }
 
 func (d *dischargeTokenCreator) DischargeToken(ctx context.Context, id *store.Identity) (*httpbakery.DischargeToken, error) {
+	now := time.Now()
 	m, err := d.params.Oven.NewMacaroon(
 		ctx,
 		bakery.LatestVersion,
 		[]checkers.Caveat{
-			checkers.TimeBeforeCaveat(time.Now().Add(d.params.DischargeTokenTimeout)),
+			checkers.TimeBeforeCaveat(now.Add(d.params.DischargeTokenTimeout)),
 			candidclient.UserIDDeclaration(string(id.ProviderID)),
+			auth.IssuedDeclaration(now),
 		},
 		identchecker.LoginOp,
 	)
@@ -118,9 +122,20 @@ type visitCompleter struct {
 
 // Success implements idp.VisitCompleter.Success.
 func (c *visitCompleter) Success(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, id *store.Identity) {
+	if err := c.checkNotSuspended(ctx, id); err != nil {
+		c.Failure(ctx, w, req, dischargeID, errgo.Mask(err, errgo.Is(params.ErrForbidden)))
+		return
+	}
+	c.auditLogin(ctx, id, nil)
+	c.complete(ctx, w, req, dischargeID, id)
+}
+
+// complete completes a successful login without recording it in the
+// audit log.
+func (c *visitCompleter) complete(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, id *store.Identity) {
 	if dischargeID != "" {
 		if err := c.place.Done(ctx, dischargeID, &loginInfo{ProviderID: id.ProviderID}); err != nil {
-			c.Failure(ctx, w, req, dischargeID, errgo.Mask(err))
+			c.fail(ctx, w, req, dischargeID, errgo.Mask(err))
 			return
 		}
 	}
@@ -138,6 +153,13 @@ func (c *visitCompleter) Success(ctx context.Context, w http.ResponseWriter, req
 
 // Failure implements idp.VisitCompleter.Failure.
 func (c *visitCompleter) Failure(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, err error) {
+	c.auditLogin(ctx, nil, err)
+	c.fail(ctx, w, req, dischargeID, err)
+}
+
+// fail completes a failed login without recording it in the audit
+// log.
+func (c *visitCompleter) fail(ctx context.Context, w http.ResponseWriter, req *http.Request, dischargeID string, err error) {
 	_, bakeryErr := httpbakery.ErrorToResponse(ctx, err)
 	if dischargeID != "" {
 		c.place.Done(ctx, dischargeID, &loginInfo{
@@ -149,11 +171,16 @@ func (c *visitCompleter) Failure(ctx context.Context, w http.ResponseWriter, req
 
 // RedirectSuccess implements idp.VisitCompleter.RedirectSuccess.
 func (c *visitCompleter) RedirectSuccess(ctx context.Context, w http.ResponseWriter, req *http.Request, returnTo, state string, id *store.Identity) {
+	if err := c.checkNotSuspended(ctx, id); err != nil {
+		c.RedirectFailure(ctx, w, req, returnTo, state, errgo.Mask(err, errgo.Is(params.ErrForbidden)))
+		return
+	}
 	code, err := c.identityStore.Put(ctx, id, time.Now().Add(10*time.Minute))
 	if err != nil {
 		c.RedirectFailure(ctx, w, req, returnTo, state, errgo.Mask(err))
 		return
 	}
+	c.auditLogin(ctx, id, nil)
 	v := url.Values{
 		"code": {code},
 	}
@@ -168,6 +195,7 @@ func (c *visitCompleter) RedirectSuccess(ctx context.Context, w http.ResponseWri
 
 // RedirectFailure implements idp.VisitCompleter.RedirectFailure.
 func (c *visitCompleter) RedirectFailure(ctx context.Context, w http.ResponseWriter, req *http.Request, returnTo, state string, err error) {
+	c.auditLogin(ctx, nil, err)
 	v := url.Values{
 		"error": {err.Error()},
 	}
@@ -183,6 +211,50 @@ func (c *visitCompleter) RedirectFailure(ctx context.Context, w http.ResponseWri
 	identity.WriteError(ctx, w, err)
 }
 
+// checkNotSuspended checks that the identity that has just logged in
+// has not been suspended. The identity is read from the store because
+// identity providers do not necessarily pass a complete identity.
+func (c *visitCompleter) checkNotSuspended(ctx context.Context, id *store.Identity) error {
+	stored := store.Identity{
+		ProviderID: id.ProviderID,
+	}
+	if err := c.params.Store.Identity(ctx, &stored); err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(c.params.Authorizer.CheckNotSuspended(ctx, &stored), errgo.Is(params.ErrForbidden))
+}
+
+// auditLogin records the result of a login in the audit log. If the
+// login failed then id should be nil and err should hold the reason.
+func (c *visitCompleter) auditLogin(ctx context.Context, id *store.Identity, err error) {
+	e := audit.Event{
+		Type: audit.Login,
+		IDP:  idpNameFromContext(ctx),
+	}
+	if id != nil {
+		e.User = id.Username
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	c.params.Audit(ctx, e)
+}
+
+type idpNameKey struct{}
+
+// contextWithIDPName returns a context that records the name of the
+// identity provider that is handling the request.
+func contextWithIDPName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, idpNameKey{}, name)
+}
+
+// idpNameFromContext returns the name of the identity provider stored
+// in the given context by contextWithIDPName.
+func idpNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(idpNameKey{}).(string)
+	return name
+}
+
 // redirect writes a redirect response addressed the the given returnTo
 // address with the given query parameters. If an error is returned it
 // will be because the returnTo address is invalid and therefore it will
@@ -190,7 +262,7 @@ func (c *visitCompleter) RedirectFailure(ctx context.Context, w http.ResponseWri
 func (c *visitCompleter) redirect(w http.ResponseWriter, req *http.Request, returnTo string, query url.Values) error {
 	// Check the return to is a whitelisted address, and is a valid URL.
 	var validReturnTo bool
-	if returnTo == c.params.Location+"/login-complete" {
+	if returnTo == c.params.Location+"/login-complete" || returnTo == c.params.Location+oidcLoginCompletePath {
 		validReturnTo = true
 	} else {
 		for _, rurl := range c.params.RedirectLoginWhitelist {
@@ -259,12 +259,14 @@ func (h *handler) LoginComplete(p httprequest.Params, req *loginCompleteRequest)
 		return
 	}
 
+	// The result of the login has already been recorded in the audit
+	// log by RedirectSuccess or RedirectFailure.
 	if req.Error != "" {
 		err := &params.Error{
 			Message: req.Error,
 			Code:    params.ErrorCode(req.ErrorCode),
 		}
-		h.params.visitCompleter.Failure(ctx, p.Response, p.Request, ws.DischargeID, err)
+		h.params.visitCompleter.fail(ctx, p.Response, p.Request, ws.DischargeID, err)
 		return
 	}
 
@@ -274,7 +276,7 @@ func (h *handler) LoginComplete(p httprequest.Params, req *loginCompleteRequest)
 		return
 	}
 
-	h.params.visitCompleter.Success(ctx, p.Response, p.Request, ws.DischargeID, &id)
+	h.params.visitCompleter.complete(ctx, p.Response, p.Request, ws.DischargeID, &id)
 }
 
 const waitCookieName = "candid-discharge-wait"
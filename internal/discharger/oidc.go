@@ -0,0 +1,724 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/canonical/candid/idp/idputil"
+	"github.com/canonical/candid/idp/idputil/secret"
+	"github.com/canonical/candid/internal/discharger/internal"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/oidcissuer"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+const (
+	oidcAuthorizePath     = "/oidc/authorize"
+	oidcLoginCompletePath = "/oidc/login-complete"
+	oidcTokenPath         = "/oidc/token"
+	oidcUserInfoPath      = "/oidc/userinfo"
+	oidcJWKSPath          = "/oidc/jwks"
+
+	oidcCookieName     = "candid-oidc"
+	oidcSigningKeyKey  = "signing-key"
+	oidcCodeKeyPrefix  = "code-"
+	oidcCodeTimeout    = 10 * time.Minute
+	oidcLoginTimeout   = 15 * time.Minute
+	oidcSigningKeyBits = 2048
+)
+
+// An oidcIssuer serves the endpoints that allow the identity server to
+// act as an OpenID Connect provider. Users log in using the same
+// identity provider choice and redirect login flow as any other
+// redirect based login, the OpenID Connect authorization code is
+// created once that login has completed.
+type oidcIssuer struct {
+	params        identity.HandlerParams
+	identityStore *internal.IdentityStore
+	codec         *secret.Codec
+
+	// kvstore holds the signing key and the outstanding
+	// authorization codes.
+	kvstore simplekv.Store
+
+	// mu guards key, which holds the signing key once it has been
+	// loaded from kvstore.
+	mu  sync.Mutex
+	key *jose.JSONWebKey
+}
+
+// handlers returns the HTTP handlers for the OpenID Connect endpoints.
+// The handlers are not authenticated with macaroons as the clients
+// authenticate using OAuth 2.0 mechanisms.
+func (o *oidcIssuer) handlers() []httprequest.Handler {
+	return []httprequest.Handler{
+		identity.ReqServer.Handle(o.discovery),
+		identity.ReqServer.Handle(o.jwks),
+		identity.ReqServer.Handle(o.authorize),
+		identity.ReqServer.Handle(o.loginComplete),
+		identity.ReqServer.Handle(o.token),
+		identity.ReqServer.Handle(o.userInfo),
+	}
+}
+
+// context returns a context suitable for using with the stores, along
+// with a function that must be called when the context is no longer
+// required.
+func (o *oidcIssuer) context(ctx context.Context) (context.Context, func()) {
+	ctx, close1 := o.params.Store.Context(ctx)
+	ctx, close2 := o.kvstore.Context(ctx)
+	return ctx, func() {
+		close2()
+		close1()
+	}
+}
+
+type oidcDiscoveryRequest struct {
+	httprequest.Route `httprequest:"GET /.well-known/openid-configuration"`
+}
+
+// oidcDiscovery holds the OpenID Provider metadata, as defined in
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
+type oidcDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// discovery serves the OpenID Provider configuration document.
+func (o *oidcIssuer) discovery(p httprequest.Params, req *oidcDiscoveryRequest) (*oidcDiscovery, error) {
+	return &oidcDiscovery{
+		Issuer:                            o.params.Location,
+		AuthorizationEndpoint:             o.params.Location + oidcAuthorizePath,
+		TokenEndpoint:                     o.params.Location + oidcTokenPath,
+		UserInfoEndpoint:                  o.params.Location + oidcUserInfoPath,
+		JWKSURI:                           o.params.Location + oidcJWKSPath,
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{string(jose.RS256)},
+		ScopesSupported:                   []string{"openid", "profile", "email", "groups"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "email", "groups"},
+	}, nil
+}
+
+type oidcJWKSRequest struct {
+	httprequest.Route `httprequest:"GET /oidc/jwks"`
+}
+
+// jwks serves the public keys that can be used to verify tokens issued
+// by the identity server.
+func (o *oidcIssuer) jwks(p httprequest.Params, req *oidcJWKSRequest) (*jose.JSONWebKeySet, error) {
+	ctx, close := o.context(p.Context)
+	defer close()
+	key, err := o.signingKey(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{publicJWK(key)},
+	}, nil
+}
+
+// oidcAuthorizeRequest is an OpenID Connect authentication request
+// using the authorization code flow.
+type oidcAuthorizeRequest struct {
+	httprequest.Route `httprequest:"GET /oidc/authorize"`
+	ResponseType      string `httprequest:"response_type,form"`
+	ClientID          string `httprequest:"client_id,form"`
+	RedirectURI       string `httprequest:"redirect_uri,form"`
+	Scope             string `httprequest:"scope,form"`
+	State             string `httprequest:"state,form"`
+	Nonce             string `httprequest:"nonce,form"`
+}
+
+// oidcAuthState is stored in a cookie while the user logs in, it holds
+// the parameters of the original authentication request.
+type oidcAuthState struct {
+	ClientID    string
+	RedirectURI string
+	Scope       string
+	State       string
+	Nonce       string
+	Expires     time.Time
+}
+
+// authorize starts an OpenID Connect login. The user is sent to the
+// normal identity provider choice page with a return address that
+// completes the OpenID Connect part of the login.
+func (o *oidcIssuer) authorize(p httprequest.Params, req *oidcAuthorizeRequest) error {
+	client, ok := o.client(req.ClientID)
+	if !ok {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "unknown client_id %q", req.ClientID)
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid redirect_uri %q", req.RedirectURI)
+	}
+	as := oidcAuthState{
+		ClientID:    req.ClientID,
+		RedirectURI: req.RedirectURI,
+		Scope:       req.Scope,
+		State:       req.State,
+		Nonce:       req.Nonce,
+		Expires:     time.Now().Add(oidcLoginTimeout),
+	}
+	if req.ResponseType != "code" {
+		o.redirect(p.Response, p.Request, &as, url.Values{
+			"error":             {"unsupported_response_type"},
+			"error_description": {"only the code response type is supported"},
+		})
+		return nil
+	}
+	if !hasScope(req.Scope, "openid") {
+		o.redirect(p.Response, p.Request, &as, url.Values{
+			"error":             {"invalid_scope"},
+			"error_description": {"openid scope required"},
+		})
+		return nil
+	}
+	cookiePath := idputil.CookiePathRelativeToLocation(oidcLoginCompletePath, o.params.Location, o.params.SkipLocationForCookiePaths)
+	state, err := o.codec.SetCookie(p.Response, oidcCookieName, cookiePath, as)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	v := url.Values{
+		"state":     {state},
+		"return_to": {o.params.Location + oidcLoginCompletePath},
+	}
+	http.Redirect(p.Response, p.Request, o.params.Location+"/login-redirect?"+v.Encode(), http.StatusTemporaryRedirect)
+	return nil
+}
+
+// oidcLoginCompleteRequest is the redirect that completes the login
+// started by authorize.
+type oidcLoginCompleteRequest struct {
+	httprequest.Route `httprequest:"GET /oidc/login-complete"`
+	State             string `httprequest:"state,form"`
+	Code              string `httprequest:"code,form"`
+	Error             string `httprequest:"error,form"`
+}
+
+// oidcCode holds the information associated with an authorization
+// code.
+type oidcCode struct {
+	ProviderID  store.ProviderIdentity
+	ClientID    string
+	RedirectURI string
+	Scope       string
+	Nonce       string
+	AuthTime    time.Time
+	Expires     time.Time
+	Used        bool
+}
+
+// loginComplete completes the login started by authorize by sending an
+// authorization code, or an error, to the client's redirect URI.
+func (o *oidcIssuer) loginComplete(p httprequest.Params, req *oidcLoginCompleteRequest) {
+	var as oidcAuthState
+	if err := o.codec.Cookie(p.Request, oidcCookieName, req.State, &as); err != nil {
+		logger.Infof("oidc login error: %s", err)
+		idputil.BadRequestf(p.Response, "invalid login state")
+		return
+	}
+	if as.Expires.Before(time.Now()) {
+		idputil.BadRequestf(p.Response, "login expired")
+		return
+	}
+	if req.Error != "" {
+		o.redirect(p.Response, p.Request, &as, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {req.Error},
+		})
+		return
+	}
+	ctx, close := o.context(p.Context)
+	defer close()
+	var id store.Identity
+	if err := o.identityStore.Get(ctx, req.Code, &id); err != nil {
+		logger.Errorf("cannot get identity for oidc login: %s", err)
+		o.redirect(p.Response, p.Request, &as, url.Values{
+			"error":             {"server_error"},
+			"error_description": {"cannot complete login"},
+		})
+		return
+	}
+	code, err := o.putCode(ctx, &oidcCode{
+		ProviderID:  id.ProviderID,
+		ClientID:    as.ClientID,
+		RedirectURI: as.RedirectURI,
+		Scope:       as.Scope,
+		Nonce:       as.Nonce,
+		AuthTime:    time.Now(),
+		Expires:     time.Now().Add(oidcCodeTimeout),
+	})
+	if err != nil {
+		logger.Errorf("cannot create oidc code: %s", err)
+		o.redirect(p.Response, p.Request, &as, url.Values{
+			"error":             {"server_error"},
+			"error_description": {"cannot complete login"},
+		})
+		return
+	}
+	o.redirect(p.Response, p.Request, &as, url.Values{
+		"code": {code},
+	})
+}
+
+// oidcTokenRequest is a request to exchange an authorization code for
+// tokens. The client may authenticate using either HTTP basic
+// authentication, or the client_id and client_secret parameters.
+type oidcTokenRequest struct {
+	httprequest.Route `httprequest:"POST /oidc/token"`
+	GrantType         string `httprequest:"grant_type,form"`
+	Code              string `httprequest:"code,form"`
+	RedirectURI       string `httprequest:"redirect_uri,form"`
+	ClientID          string `httprequest:"client_id,form"`
+	ClientSecret      string `httprequest:"client_secret,form"`
+}
+
+// oidcTokenResponse is the successful response from the token endpoint.
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+// oidcProfile holds the claims that describe the user in both ID tokens
+// and userinfo responses.
+type oidcProfile struct {
+	PreferredUsername string    `json:"preferred_username"`
+	Name              string    `json:"name,omitempty"`
+	Email             string    `json:"email,omitempty"`
+	Groups            *[]string `json:"groups,omitempty"`
+}
+
+// oidcAccessTokenClaims holds the access token claims that are not
+// described by jwt.Claims.
+type oidcAccessTokenClaims struct {
+	Scope string `json:"scope,omitempty"`
+}
+
+// oidcIDTokenClaims holds the ID token claims that are not described by
+// jwt.Claims or oidcProfile.
+type oidcIDTokenClaims struct {
+	Nonce    string          `json:"nonce,omitempty"`
+	AuthTime jwt.NumericDate `json:"auth_time"`
+}
+
+// token exchanges an authorization code for an ID token and an access
+// token that can be used with the userinfo endpoint.
+func (o *oidcIssuer) token(p httprequest.Params, req *oidcTokenRequest) {
+	w := p.Response
+	clientID, clientSecret := req.ClientID, req.ClientSecret
+	if u, pw, ok := p.Request.BasicAuth(); ok {
+		// RFC 6749 section 2.3.1 requires the credentials to be
+		// form encoded before they are used for basic
+		// authentication.
+		clientID, _ = url.QueryUnescape(u)
+		clientSecret, _ = url.QueryUnescape(pw)
+	}
+	client, ok := o.client(clientID)
+	if !ok || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="candid"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return
+	}
+	if req.GrantType != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant type is supported")
+		return
+	}
+	ctx, close := o.context(p.Context)
+	defer close()
+	code, err := o.redeemCode(ctx, req.Code)
+	if err != nil {
+		if errgo.Cause(err) != store.ErrNotFound {
+			logger.Errorf("cannot redeem oidc code: %s", err)
+		}
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid code")
+		return
+	}
+	if code.ClientID != clientID || code.RedirectURI != req.RedirectURI {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid code")
+		return
+	}
+	id, profile, err := o.profile(ctx, &store.Identity{ProviderID: code.ProviderID}, code.Scope)
+	if err != nil {
+		if errgo.Cause(err) == params.ErrForbidden {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+		logger.Errorf("cannot get oidc profile: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "cannot get user details")
+		return
+	}
+	key, err := o.signingKey(ctx)
+	if err != nil {
+		logger.Errorf("cannot get oidc signing key: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "cannot sign tokens")
+		return
+	}
+	now := time.Now()
+	expires := now.Add(o.params.OIDCTokenTimeout)
+	idToken, err := signJWT(key,
+		jwt.Claims{
+			Issuer:   o.params.Location,
+			Subject:  string(id.ProviderID),
+			Audience: jwt.Audience{clientID},
+			Expiry:   jwt.NewNumericDate(expires),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		profile,
+		oidcIDTokenClaims{
+			Nonce:    code.Nonce,
+			AuthTime: jwt.NewNumericDate(code.AuthTime),
+		},
+	)
+	if err == nil {
+		var accessToken string
+		accessToken, err = signJWT(key,
+			jwt.Claims{
+				Issuer:   o.params.Location,
+				Subject:  string(id.ProviderID),
+				Audience: jwt.Audience{o.params.Location + oidcUserInfoPath},
+				Expiry:   jwt.NewNumericDate(expires),
+				IssuedAt: jwt.NewNumericDate(now),
+			},
+			oidcAccessTokenClaims{
+				Scope: code.Scope,
+			},
+		)
+		if err == nil {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Pragma", "no-cache")
+			httprequest.WriteJSON(w, http.StatusOK, oidcTokenResponse{
+				AccessToken: accessToken,
+				TokenType:   "Bearer",
+				ExpiresIn:   int(o.params.OIDCTokenTimeout / time.Second),
+				IDToken:     idToken,
+			})
+			return
+		}
+	}
+	logger.Errorf("cannot sign oidc token: %s", err)
+	writeOAuthError(w, http.StatusInternalServerError, "server_error", "cannot sign tokens")
+}
+
+type oidcUserInfoRequest struct {
+	httprequest.Route `httprequest:"GET /oidc/userinfo"`
+}
+
+// oidcUserInfo is the response from the userinfo endpoint.
+type oidcUserInfo struct {
+	Subject string `json:"sub"`
+	oidcProfile
+}
+
+// userInfo returns the current details of the user identified by the
+// bearer access token in the request.
+func (o *oidcIssuer) userInfo(p httprequest.Params, req *oidcUserInfoRequest) {
+	w := p.Response
+	ctx, close := o.context(p.Context)
+	defer close()
+	providerID, scope, err := o.verifyAccessToken(ctx, p.Request)
+	if err != nil {
+		logger.Infof("invalid oidc access token: %s", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "invalid access token")
+		return
+	}
+	id, profile, err := o.profile(ctx, &store.Identity{ProviderID: providerID}, scope)
+	if err != nil {
+		if errgo.Cause(err) == params.ErrForbidden || errgo.Cause(err) == params.ErrNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+		logger.Errorf("cannot get oidc profile: %s", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "cannot get user details")
+		return
+	}
+	httprequest.WriteJSON(w, http.StatusOK, oidcUserInfo{
+		Subject:     string(id.ProviderID),
+		oidcProfile: *profile,
+	})
+}
+
+// verifyAccessToken checks the bearer access token in the given request
+// and returns the provider ID of the user it was issued to and the
+// scope that was granted.
+func (o *oidcIssuer) verifyAccessToken(ctx context.Context, req *http.Request) (store.ProviderIdentity, string, error) {
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return "", "", errgo.New("no bearer token")
+	}
+	tok, err := jwt.ParseSigned(strings.TrimPrefix(h, "Bearer "))
+	if err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	key, err := o.signingKey(ctx)
+	if err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	var claims jwt.Claims
+	var atClaims oidcAccessTokenClaims
+	if err := tok.Claims(publicJWK(key).Key, &claims, &atClaims); err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	err = claims.Validate(jwt.Expected{
+		Issuer:   o.params.Location,
+		Audience: jwt.Audience{o.params.Location + oidcUserInfoPath},
+		Time:     time.Now(),
+	})
+	if err != nil {
+		return "", "", errgo.Mask(err)
+	}
+	return store.ProviderIdentity(claims.Subject), atClaims.Scope, nil
+}
+
+// profile retrieves the given identity from the store, checks that it
+// has not been suspended and returns the claims that describe it. The
+// email and groups claims are only included if the given scope contains
+// the "email" and "groups" scopes respectively.
+func (o *oidcIssuer) profile(ctx context.Context, id *store.Identity, scope string) (*store.Identity, *oidcProfile, error) {
+	aid, err := o.params.Authorizer.Identity(ctx, id)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := o.params.Authorizer.CheckNotSuspended(ctx, &aid.Identity); err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(params.ErrForbidden))
+	}
+	profile := oidcProfile{
+		PreferredUsername: aid.Identity.Username,
+		Name:              aid.Identity.Name,
+	}
+	if hasScope(scope, "email") {
+		profile.Email = aid.Identity.Email
+	}
+	if hasScope(scope, "groups") {
+		groups, err := aid.Groups(ctx)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		if groups == nil {
+			groups = []string{}
+		}
+		profile.Groups = &groups
+	}
+	return &aid.Identity, &profile, nil
+}
+
+// client returns the registered client with the given ID.
+func (o *oidcIssuer) client(id string) (oidcissuer.Client, bool) {
+	if id == "" {
+		return oidcissuer.Client{}, false
+	}
+	for _, c := range o.params.OIDCClients {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return oidcissuer.Client{}, false
+}
+
+// redirect sends an authorization response to the redirect URI of the
+// given request. The state of the original request is added to the
+// given parameters.
+func (o *oidcIssuer) redirect(w http.ResponseWriter, req *http.Request, as *oidcAuthState, v url.Values) {
+	u, err := url.Parse(as.RedirectURI)
+	if err != nil {
+		// This should be impossible as the URI has been
+		// checked against the client's registered URIs.
+		identity.WriteError(req.Context(), w, errgo.WithCausef(err, params.ErrBadRequest, "invalid redirect_uri"))
+		return
+	}
+	if as.State != "" {
+		v.Set("state", as.State)
+	}
+	q := u.Query()
+	for k, vs := range v {
+		q[k] = append(q[k], vs...)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, req, u.String(), http.StatusSeeOther)
+}
+
+// putCode stores the given code information and returns the
+// authorization code that refers to it.
+func (o *oidcIssuer) putCode(ctx context.Context, c *oidcCode) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errgo.Mask(err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if err := o.kvstore.Set(ctx, oidcCodeKeyPrefix+code, b, c.Expires); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return code, nil
+}
+
+// redeemCode retrieves the information associated with the given
+// authorization code. A code can only be redeemed once, if the code
+// does not exist, has expired or has been redeemed already an error
+// with a cause of store.ErrNotFound is returned.
+func (o *oidcIssuer) redeemCode(ctx context.Context, code string) (*oidcCode, error) {
+	if code == "" {
+		return nil, errgo.WithCausef(nil, store.ErrNotFound, "")
+	}
+	var c oidcCode
+	err := o.kvstore.Update(ctx, oidcCodeKeyPrefix+code, time.Now().Add(oidcCodeTimeout), func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, errgo.WithCausef(nil, store.ErrNotFound, "")
+		}
+		c = oidcCode{}
+		if err := json.Unmarshal(old, &c); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if c.Used || c.Expires.Before(time.Now()) {
+			return nil, errgo.WithCausef(nil, store.ErrNotFound, "")
+		}
+		c.Used = true
+		b, err := json.Marshal(c)
+		return b, errgo.Mask(err)
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	return &c, nil
+}
+
+// signingKey returns the key used to sign tokens. The key is generated
+// the first time it is required and stored so that it is shared by all
+// identity servers using the same store.
+func (o *oidcIssuer) signingKey(ctx context.Context) (*jose.JSONWebKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.key != nil {
+		return o.key, nil
+	}
+	var newKey, stored []byte
+	err := o.kvstore.Update(ctx, oidcSigningKeyKey, time.Time{}, func(old []byte) ([]byte, error) {
+		if old != nil {
+			stored = old
+			return old, nil
+		}
+		if newKey == nil {
+			pk, err := rsa.GenerateKey(rand.Reader, oidcSigningKeyBits)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			newKey = x509.MarshalPKCS1PrivateKey(pk)
+		}
+		stored = newKey
+		return newKey, nil
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot store signing key")
+	}
+	pk, err := x509.ParsePKCS1PrivateKey(stored)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot parse signing key")
+	}
+	key := &jose.JSONWebKey{
+		Key:       pk,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	o.key = key
+	return o.key, nil
+}
+
+// publicJWK returns the public part of the given signing key.
+func publicJWK(key *jose.JSONWebKey) jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       &key.Key.(*rsa.PrivateKey).PublicKey,
+		KeyID:     key.KeyID,
+		Algorithm: key.Algorithm,
+		Use:       key.Use,
+	}
+}
+
+// signJWT creates a JWT signed with the given key containing the union
+// of the given claims.
+func signJWT(key *jose.JSONWebKey, claims ...interface{}) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       key,
+	}, nil)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	b := jwt.Signed(signer)
+	for _, c := range claims {
+		b = b.Claims(c)
+	}
+	s, err := b.CompactSerialize()
+	return s, errgo.Mask(err)
+}
+
+// hasScope reports whether the given space separated list of scopes
+// contains the given scope.
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// oauthError is the body of an OAuth 2.0 error response.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// writeOAuthError writes an OAuth 2.0 error response with the given
+// status, error code and description.
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	httprequest.WriteJSON(w, status, oauthError{
+		Error:       code,
+		Description: description,
+	})
+}
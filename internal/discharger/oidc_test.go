@@ -0,0 +1,323 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+
+	oidc "github.com/coreos/go-oidc"
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"golang.org/x/oauth2"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/static"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/oidcissuer"
+	"github.com/canonical/candid/store"
+)
+
+func TestOIDC(t *testing.T) {
+	qtsuite.Run(qt.New(t), &oidcSuite{})
+}
+
+type oidcSuite struct {
+	store    *candidtest.Store
+	srv      *candidtest.Server
+	provider *oidc.Provider
+	config   *oauth2.Config
+}
+
+func (s *oidcSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		static.NewIdentityProvider(static.Params{
+			Name: "test",
+			Users: map[string]static.UserInfo{
+				"test": {
+					Password: "testpassword",
+					Name:     "Test User",
+					Email:    "test@example.com",
+					Groups:   []string{"test1", "test2"},
+				},
+			},
+		}),
+	}
+	sp.OIDCClients = []oidcissuer.Client{{
+		ID:           "test-client",
+		Secret:       "s3cret",
+		RedirectURIs: []string{"https://example.com/callback"},
+	}}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	var err error
+	s.provider, err = oidc.NewProvider(context.Background(), s.srv.URL)
+	c.Assert(err, qt.IsNil)
+	s.config = &oauth2.Config{
+		ClientID:     "test-client",
+		ClientSecret: "s3cret",
+		Endpoint:     s.provider.Endpoint(),
+		RedirectURL:  "https://example.com/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "email", "groups"},
+	}
+}
+
+func (s *oidcSuite) TestLogin(c *qt.C) {
+	ctx := context.Background()
+	q := s.login(c, s.config.AuthCodeURL("test-state", oidc.Nonce("test-nonce")))
+	c.Assert(q.Get("error"), qt.Equals, "")
+	c.Assert(q.Get("state"), qt.Equals, "test-state")
+
+	tok, err := s.config.Exchange(ctx, q.Get("code"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(tok.TokenType, qt.Equals, "Bearer")
+
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	c.Assert(ok, qt.IsTrue)
+	idToken, err := s.provider.Verifier(&oidc.Config{
+		ClientID: "test-client",
+		ClaimNonce: func(nonce string) error {
+			c.Check(nonce, qt.Equals, "test-nonce")
+			return nil
+		},
+	}).Verify(ctx, rawIDToken)
+	c.Assert(err, qt.IsNil)
+	c.Assert(idToken.Subject, qt.Equals, "test:test")
+	c.Assert(idToken.Issuer, qt.Equals, s.srv.URL)
+	var claims profileClaims
+	err = idToken.Claims(&claims)
+	c.Assert(err, qt.IsNil)
+	c.Assert(claims, qt.DeepEquals, profileClaims{
+		PreferredUsername: "test",
+		Name:              "Test User",
+		Email:             "test@example.com",
+		Groups:            []string{"test1", "test2"},
+	})
+
+	userInfo, err := s.provider.UserInfo(ctx, oauth2.StaticTokenSource(tok))
+	c.Assert(err, qt.IsNil)
+	c.Assert(userInfo.Subject, qt.Equals, "test:test")
+	c.Assert(userInfo.Email, qt.Equals, "test@example.com")
+	claims = profileClaims{}
+	err = userInfo.Claims(&claims)
+	c.Assert(err, qt.IsNil)
+	c.Assert(claims.Groups, qt.DeepEquals, []string{"test1", "test2"})
+
+	// The code can only be used once.
+	_, err = s.config.Exchange(ctx, q.Get("code"))
+	c.Assert(err, qt.ErrorMatches, `(?s)oauth2: cannot fetch token: 400 Bad Request.*"error":"invalid_grant".*`)
+}
+
+func (s *oidcSuite) TestLoginScope(c *qt.C) {
+	ctx := context.Background()
+	s.config.Scopes = []string{oidc.ScopeOpenID}
+	q := s.login(c, s.config.AuthCodeURL("test-state"))
+	tok, err := s.config.Exchange(ctx, q.Get("code"))
+	c.Assert(err, qt.IsNil)
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	c.Assert(ok, qt.IsTrue)
+	idToken, err := s.provider.Verifier(&oidc.Config{
+		ClientID: "test-client",
+	}).Verify(ctx, rawIDToken)
+	c.Assert(err, qt.IsNil)
+	var claims profileClaims
+	err = idToken.Claims(&claims)
+	c.Assert(err, qt.IsNil)
+	c.Assert(claims, qt.DeepEquals, profileClaims{
+		PreferredUsername: "test",
+		Name:              "Test User",
+	})
+
+	userInfo, err := s.provider.UserInfo(ctx, oauth2.StaticTokenSource(tok))
+	c.Assert(err, qt.IsNil)
+	c.Assert(userInfo.Subject, qt.Equals, "test:test")
+	claims = profileClaims{}
+	err = userInfo.Claims(&claims)
+	c.Assert(err, qt.IsNil)
+	c.Assert(claims, qt.DeepEquals, profileClaims{
+		PreferredUsername: "test",
+		Name:              "Test User",
+	})
+}
+
+func (s *oidcSuite) TestLoginClientSecretPost(c *qt.C) {
+	q := s.login(c, s.config.AuthCodeURL("test-state"))
+	resp, err := http.PostForm(s.srv.URL+"/oidc/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {"https://example.com/callback"},
+		"client_id":     {"test-client"},
+		"client_secret": {"s3cret"},
+	})
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	c.Assert(err, qt.IsNil)
+	c.Assert(tr.AccessToken, qt.Not(qt.Equals), "")
+	c.Assert(tr.IDToken, qt.Not(qt.Equals), "")
+}
+
+func (s *oidcSuite) TestTokenInvalidClientSecret(c *qt.C) {
+	q := s.login(c, s.config.AuthCodeURL("test-state"))
+	s.config.ClientSecret = "bad"
+	_, err := s.config.Exchange(context.Background(), q.Get("code"))
+	c.Assert(err, qt.ErrorMatches, `(?s)oauth2: cannot fetch token: 401 Unauthorized.*"error":"invalid_client".*`)
+}
+
+func (s *oidcSuite) TestTokenWrongRedirectURI(c *qt.C) {
+	q := s.login(c, s.config.AuthCodeURL("test-state"))
+	s.config.RedirectURL = "https://example.com/other"
+	_, err := s.config.Exchange(context.Background(), q.Get("code"))
+	c.Assert(err, qt.ErrorMatches, `(?s)oauth2: cannot fetch token: 400 Bad Request.*"error":"invalid_grant".*`)
+}
+
+func (s *oidcSuite) TestUserInfoSuspendedUser(c *qt.C) {
+	ctx := context.Background()
+	q := s.login(c, s.config.AuthCodeURL("test-state"))
+	tok, err := s.config.Exchange(ctx, q.Get("code"))
+	c.Assert(err, qt.IsNil)
+
+	err = s.store.Store.UpdateIdentity(ctx, &store.Identity{
+		Username:  "test",
+		Suspended: true,
+	}, store.Update{
+		store.Suspended: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.provider.UserInfo(ctx, oauth2.StaticTokenSource(tok))
+	c.Assert(err, qt.ErrorMatches, `401 Unauthorized: {"error":"invalid_token","error_description":"user test is suspended"}`)
+}
+
+func (s *oidcSuite) TestUserInfoInvalidToken(c *qt.C) {
+	req, err := http.NewRequest("GET", "/oidc/userinfo", nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	resp := s.srv.Do(c, req)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusUnauthorized)
+	c.Assert(resp.Header.Get("WWW-Authenticate"), qt.Equals, `Bearer error="invalid_token"`)
+}
+
+var authorizeErrorTests = []struct {
+	about        string
+	query        url.Values
+	expectStatus int
+	expectError  string
+}{{
+	about: "unknown client",
+	query: url.Values{
+		"response_type": {"code"},
+		"client_id":     {"no-such-client"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"scope":         {"openid"},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectError:  `unknown client_id "no-such-client"`,
+}, {
+	about: "unregistered redirect uri",
+	query: url.Values{
+		"response_type": {"code"},
+		"client_id":     {"test-client"},
+		"redirect_uri":  {"https://example.com/other"},
+		"scope":         {"openid"},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectError:  `invalid redirect_uri "https://example.com/other"`,
+}, {
+	about: "unsupported response type",
+	query: url.Values{
+		"response_type": {"token"},
+		"client_id":     {"test-client"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"scope":         {"openid"},
+		"state":         {"test-state"},
+	},
+	expectStatus: http.StatusSeeOther,
+	expectError:  "unsupported_response_type",
+}, {
+	about: "missing openid scope",
+	query: url.Values{
+		"response_type": {"code"},
+		"client_id":     {"test-client"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"scope":         {"email"},
+		"state":         {"test-state"},
+	},
+	expectStatus: http.StatusSeeOther,
+	expectError:  "invalid_scope",
+}}
+
+func (s *oidcSuite) TestAuthorizeErrors(c *qt.C) {
+	for _, test := range authorizeErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			req, err := http.NewRequest("GET", "/oidc/authorize?"+test.query.Encode(), nil)
+			c.Assert(err, qt.IsNil)
+			resp := s.srv.RoundTrip(c, req)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+			if test.expectStatus != http.StatusSeeOther {
+				var perr struct {
+					Message string
+				}
+				err := json.NewDecoder(resp.Body).Decode(&perr)
+				c.Assert(err, qt.IsNil)
+				c.Assert(perr.Message, qt.Equals, test.expectError)
+				return
+			}
+			u, err := url.Parse(resp.Header.Get("Location"))
+			c.Assert(err, qt.IsNil)
+			c.Assert(u.Host+u.Path, qt.Equals, "example.com/callback")
+			c.Assert(u.Query().Get("error"), qt.Equals, test.expectError)
+			c.Assert(u.Query().Get("state"), qt.Equals, "test-state")
+		})
+	}
+}
+
+// login performs an interactive login as the "test" user starting at
+// the given authorization URL. It returns the query parameters of the
+// resulting redirect to the client.
+func (s *oidcSuite) login(c *qt.C, authURL string) url.Values {
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, qt.IsNil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host == "example.com" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(authURL)
+	c.Assert(err, qt.IsNil)
+	resp, err = candidtest.SelectInteractiveLogin(candidtest.PostLoginForm("test", "testpassword"))(client, resp)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusSeeOther)
+	u, err := url.Parse(resp.Header.Get("Location"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.Host+u.Path, qt.Equals, "example.com/callback")
+	return u.Query()
+}
+
+type profileClaims struct {
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	Email             string   `json:"email"`
+	Groups            []string `json:"groups"`
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package discharger_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/static"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/policy"
+	"github.com/canonical/candid/store"
+)
+
+func TestDischargePolicy(t *testing.T) {
+	qtsuite.Run(qt.New(t), &dischargePolicySuite{})
+}
+
+type dischargePolicySuite struct {
+	srv              *candidtest.Server
+	store            *candidtest.Store
+	dischargeCreator *candidtest.DischargeCreator
+}
+
+func (s *dischargePolicySuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.DischargeMacaroonTimeout = 24 * time.Hour
+	sp.IdentityProviders = []idp.IdentityProvider{
+		flakyGroupsIDP{static.NewIdentityProvider(static.Params{
+			Name: "test",
+			Users: map[string]static.UserInfo{
+				"test": {
+					Password: "password",
+					Groups:   []string{"test1"},
+				},
+				"staff": {
+					Password: "password",
+					Groups:   []string{"staff"},
+				},
+				"flaky": {
+					Password: "password",
+					Groups:   []string{"contractors"},
+				},
+				"banned": {
+					Password: "password",
+				},
+				"local": {
+					Password: "password",
+				},
+				"office": {
+					Password: "password",
+				},
+			},
+		})},
+		static.NewIdentityProvider(static.Params{
+			Name:   "external",
+			Domain: "external",
+			Users: map[string]static.UserInfo{
+				"test": {
+					Password: "password",
+				},
+			},
+		}),
+	}
+	sp.DischargePolicy = []policy.Rule{{
+		Name:   "banned",
+		Users:  []string{"banned"},
+		Action: policy.ActionDeny,
+	}, {
+		Name:            "office",
+		Users:           []string{"office"},
+		SourceAddresses: []string{"10.0.0.0/8"},
+	}, {
+		Name:   "not-in-office",
+		Users:  []string{"office"},
+		Action: policy.ActionDeny,
+	}, {
+		Name:            "local",
+		Users:           []string{"local"},
+		SourceAddresses: []string{"127.0.0.0/8", "::1"},
+		Expiry:          5 * time.Minute,
+	}, {
+		Name:   "non-contractors",
+		Users:  []string{"staff", "flaky"},
+		Groups: []string{"!contractors"},
+	}, {
+		Name:   "contractors",
+		Users:  []string{"staff", "flaky"},
+		Action: policy.ActionDeny,
+	}, {
+		Name:       "member-expiry",
+		Conditions: []string{"is-member-of"},
+		Groups:     []string{"test1"},
+		Expiry:     10 * time.Minute,
+	}, {
+		Name:    "external",
+		Domains: []string{"external"},
+		Expiry:  time.Hour,
+		Caveats: []string{"declared source external"},
+	}}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	s.dischargeCreator = candidtest.NewDischargeCreator(s.srv)
+}
+
+func (s *dischargePolicySuite) client(c *qt.C, username string) *httpbakery.Client {
+	return s.srv.Client(httpbakery.WebBrowserInteractor{
+		OpenWebBrowser: candidtest.PasswordLogin(c, username, "password"),
+	})
+}
+
+func (s *dischargePolicySuite) TestNoMatchingRule(c *qt.C) {
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "test"))
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test")
+	assertDischargeExpiry(c, ms, 24*time.Hour)
+}
+
+func (s *dischargePolicySuite) TestDenied(c *qt.C) {
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "banned"))
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: cannot discharge: discharge denied by policy rule "banned"`)
+
+	events, err := s.store.AuditStore.Events(testContext, audit.Filter{
+		Type: audit.Discharge,
+		User: "banned",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Error, qt.Equals, `discharge denied by policy rule "banned"`)
+}
+
+func (s *dischargePolicySuite) TestSourceAddressNotMatched(c *qt.C) {
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "office"))
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: cannot discharge: discharge denied by policy rule "not-in-office"`)
+}
+
+func (s *dischargePolicySuite) TestSourceAddressMatched(c *qt.C) {
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "local"))
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "local")
+	assertDischargeExpiry(c, ms, 5*time.Minute)
+}
+
+func (s *dischargePolicySuite) TestMemberOfExpiry(c *qt.C) {
+	m := s.dischargeCreator.NewMacaroon(c, "is-member-of test1", groupOp)
+	ms, err := s.client(c, "test").DischargeAll(testContext, m)
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, groupOp, "")
+	assertDischargeExpiry(c, ms, 10*time.Minute)
+}
+
+func (s *dischargePolicySuite) TestDomainCaveats(c *qt.C) {
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user @external", s.client(c, "test"))
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "test@external")
+	assertDischargeExpiry(c, ms, time.Hour)
+	var conditions []string
+	for _, cav := range ms[1].Caveats() {
+		conditions = append(conditions, string(cav.Id))
+	}
+	c.Assert(conditions, qt.Contains, "declared source external")
+}
+
+func (s *dischargePolicySuite) TestNegatedGroupMatched(c *qt.C) {
+	ms, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "staff"))
+	c.Assert(err, qt.IsNil)
+	s.dischargeCreator.AssertMacaroon(c, ms, identchecker.LoginOp, "staff")
+}
+
+func (s *dischargePolicySuite) TestNegatedGroupUnresolved(c *qt.C) {
+	// The groups of flaky cannot be resolved, so it cannot be
+	// shown that the user is not a member of contractors.
+	_, err := s.dischargeCreator.Discharge(c, "is-authenticated-user", s.client(c, "flaky"))
+	c.Assert(err, qt.ErrorMatches, `cannot get discharge from ".*": Post http.*: cannot discharge: discharge denied by policy rule "contractors"`)
+}
+
+// flakyGroupsIDP is an identity provider that cannot get the groups of
+// the user "flaky".
+type flakyGroupsIDP struct {
+	idp.IdentityProvider
+}
+
+// GetGroups implements idp.IdentityProvider.GetGroups.
+func (p flakyGroupsIDP) GetGroups(ctx context.Context, id *store.Identity) ([]string, error) {
+	if id.Username == "flaky" {
+		return nil, errgo.New("group server unavailable")
+	}
+	return p.IdentityProvider.GetGroups(ctx, id)
+}
+
+// assertDischargeExpiry asserts that the discharge macaroon in the
+// given slice expires after approximately the given duration.
+func assertDischargeExpiry(c *qt.C, ms macaroon.Slice, d time.Duration) {
+	c.Assert(ms, qt.HasLen, 2)
+	t, ok := checkers.ExpiryTime(nil, ms[1].Caveats())
+	c.Assert(ok, qt.Equals, true)
+	expiry := time.Until(t)
+	c.Assert(expiry <= d, qt.Equals, true, qt.Commentf("expiry %v", expiry))
+	c.Assert(expiry > d-time.Minute, qt.Equals, true, qt.Commentf("expiry %v", expiry))
+}
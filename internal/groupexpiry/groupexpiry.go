@@ -0,0 +1,154 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package groupexpiry periodically removes group memberships that have
+// expired from the stored identities.
+package groupexpiry
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/juju/loggo"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.internal.groupexpiry")
+
+// Params holds the parameters for a Sweeper.
+type Params struct {
+	// Store holds the store containing the identities.
+	Store store.Store
+
+	// Audit, if not nil, is called to record an audit event for
+	// each identity that has expired groups removed.
+	Audit func(context.Context, audit.Event)
+
+	// Interval holds the time between sweeps. If this is zero then
+	// the sweeper will not run in the background.
+	Interval time.Duration
+}
+
+// A Sweeper removes expired group memberships.
+type Sweeper struct {
+	tomb   tomb.Tomb
+	params Params
+}
+
+// New creates a new Sweeper. If the sweeper has a non-zero interval
+// then it is started in the background. The returned Sweeper must be
+// closed when it is no longer required.
+func New(p Params) *Sweeper {
+	if p.Audit == nil {
+		p.Audit = func(context.Context, audit.Event) {}
+	}
+	s := &Sweeper{
+		params: p,
+	}
+	s.tomb.Go(s.loop)
+	return s
+}
+
+// Close stops the sweeper.
+func (s *Sweeper) Close() {
+	s.tomb.Kill(nil)
+	s.tomb.Wait()
+}
+
+// loop sweeps the store at regular intervals until it is stopped.
+func (s *Sweeper) loop() error {
+	if s.params.Interval <= 0 {
+		return nil
+	}
+	for {
+		ctx, close := s.params.Store.Context(context.Background())
+		if _, err := s.Sweep(ctx, time.Now()); err != nil {
+			logger.Errorf("cannot remove expired groups: %v", err)
+		}
+		close()
+		select {
+		case <-time.After(s.params.Interval):
+		case <-s.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// Sweep removes every group membership that has expired at the given
+// time. It returns the number of memberships that were removed.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	identities, err := s.params.Store.FindIdentities(ctx, nil, store.Filter{}, nil, 0, 0)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot find identities")
+	}
+	n := 0
+	for _, identity := range identities {
+		var expired []string
+		expiry := make(map[string]time.Time)
+		for g, t := range identity.GroupExpiry {
+			if !now.Before(t) {
+				expired = append(expired, g)
+				expiry[g] = t
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		sort.Strings(expired)
+		err := s.params.Store.UpdateIdentity(ctx, &store.Identity{
+			ID:          identity.ID,
+			Groups:      expired,
+			GroupExpiry: expiry,
+		}, store.Update{
+			store.Groups:      store.Pull,
+			store.GroupExpiry: store.Clear,
+		})
+		e := audit.Event{
+			Type:   audit.ModifyGroups,
+			User:   identity.Username,
+			Remove: expired,
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		s.params.Audit(ctx, e)
+		if err != nil {
+			logger.Errorf("cannot remove expired groups from %s: %s", identity.Username, err)
+			continue
+		}
+		n += len(expired)
+		// Record each removal in the group history as made by
+		// the identity server at the time the membership
+		// expired, rather than when it was swept.
+		var changes []store.GroupChange
+		for _, g := range expired {
+			if !containsString(identity.Groups, g) {
+				continue
+			}
+			changes = append(changes, store.GroupChange{
+				Time:     expiry[g],
+				Username: identity.Username,
+				Group:    g,
+				Op:       store.GroupRemove,
+			})
+		}
+		if err := s.params.Store.AddGroupChanges(ctx, changes); err != nil {
+			logger.Errorf("cannot record expired groups of %s: %s", identity.Username, err)
+		}
+	}
+	return n, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package groupexpiry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/internal/groupexpiry"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/memstore"
+)
+
+var now = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func newStore() store.Store {
+	ctx := context.Background()
+	st := memstore.NewStore()
+	for _, identity := range []store.Identity{{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Groups:     []string{"g1", "g2", "g3", "g4"},
+		GroupExpiry: map[string]time.Time{
+			"g1": now.Add(-time.Hour),
+			"g2": now,
+			"g3": now.Add(time.Hour),
+		},
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"g1"},
+	}} {
+		identity := identity
+		candidtest.AddIdentity(ctx, st, &identity)
+	}
+	return st
+}
+
+func TestSweep(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := newStore()
+	var events []audit.Event
+	s := groupexpiry.New(groupexpiry.Params{
+		Store: st,
+		Audit: func(_ context.Context, e audit.Event) {
+			events = append(events, e)
+		},
+	})
+	defer s.Close()
+
+	n, err := s.Sweep(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+
+	alice := store.Identity{
+		Username: "alice",
+	}
+	err = st.Identity(ctx, &alice)
+	c.Assert(err, qt.IsNil)
+	c.Assert(alice.Groups, qt.DeepEquals, []string{"g3", "g4"})
+	c.Assert(alice.GroupExpiry, qt.DeepEquals, map[string]time.Time{
+		"g3": now.Add(time.Hour),
+	})
+	bob := store.Identity{
+		Username: "bob",
+	}
+	err = st.Identity(ctx, &bob)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bob.Groups, qt.DeepEquals, []string{"g1"})
+
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type:   audit.ModifyGroups,
+		User:   "alice",
+		Remove: []string{"g1", "g2"},
+	}})
+
+	// The removals are recorded at the time the memberships expired.
+	changes, err := st.GroupChanges(ctx, store.GroupChangeFilter{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.DeepEquals, []store.GroupChange{{
+		Time:     now.Add(-time.Hour),
+		Username: "alice",
+		Group:    "g1",
+		Op:       store.GroupRemove,
+	}, {
+		Time:     now,
+		Username: "alice",
+		Group:    "g2",
+		Op:       store.GroupRemove,
+	}})
+
+	// A second sweep has nothing to do.
+	n, err = s.Sweep(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 0)
+}
+
+func TestBackground(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	st := newStore()
+	swept := make(chan struct{}, 10)
+	s := groupexpiry.New(groupexpiry.Params{
+		Store: st,
+		Audit: func(_ context.Context, e audit.Event) {
+			swept <- struct{}{}
+		},
+		Interval: time.Hour,
+	})
+	defer s.Close()
+
+	// The sweeper runs as soon as it is started. All the expiry times
+	// in the test store have passed, so only the permanent group is
+	// left.
+	select {
+	case <-swept:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("sweeper did not run")
+	}
+	alice := store.Identity{
+		Username: "alice",
+	}
+	err := st.Identity(context.Background(), &alice)
+	c.Assert(err, qt.IsNil)
+	c.Assert(alice.Groups, qt.DeepEquals, []string{"g4"})
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package identity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	aclparams "github.com/juju/aclstore/v2/params"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+)
+
+// Audit records the given event in the configured audit sink, if
+// there is one. If the event does not have a time then the current
+// time is used. A failure to record the event is logged but does not
+// otherwise affect the action being audited.
+func (p ServerParams) Audit(ctx context.Context, e audit.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if p.AuditSink == nil {
+		return
+	}
+	if err := p.AuditSink.Log(ctx, e); err != nil {
+		logger.Errorf("cannot record %s audit event: %s", e.Type, err)
+	}
+}
+
+type aclActorKey struct{}
+
+// setACLActor records the username of the authenticated user in an ACL
+// request prepared by auditACLHandler.
+func setACLActor(req *http.Request, username string) {
+	if actor, ok := req.Context().Value(aclActorKey{}).(*string); ok {
+		*actor = username
+	}
+}
+
+// auditACLHandler returns a handler that serves ACL requests using h
+// and records any changes made to the ACLs by authenticated users in
+// the audit log. The Authenticate function used by h must call
+// setACLActor.
+func auditACLHandler(sp ServerParams, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var e audit.Event
+		switch req.Method {
+		case "PUT":
+			e.Type = audit.SetACL
+		case "POST":
+			e.Type = audit.ModifyACL
+		default:
+			h.ServeHTTP(w, req)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			WriteError(req.Context(), w, errgo.Notef(err, "cannot read request body"))
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		var actor string
+		req = req.WithContext(context.WithValue(req.Context(), aclActorKey{}, &actor))
+		rw := &auditResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		h.ServeHTTP(rw, req)
+		if actor == "" {
+			// The request was not authenticated, so no change
+			// can have been attempted.
+			return
+		}
+		e.Actor = actor
+		e.ACL = strings.TrimPrefix(req.URL.Path, "/acl/")
+		if e.Type == audit.SetACL {
+			var b aclparams.SetACLRequestBody
+			json.Unmarshal(body, &b)
+			e.Set = b.Users
+		} else {
+			var b aclparams.ModifyACLRequestBody
+			json.Unmarshal(body, &b)
+			e.Add = b.Add
+			e.Remove = b.Remove
+		}
+		if rw.status >= http.StatusBadRequest {
+			var rerr httprequest.RemoteError
+			if json.Unmarshal(rw.body.Bytes(), &rerr) == nil && rerr.Message != "" {
+				e.Error = rerr.Message
+			} else {
+				e.Error = http.StatusText(rw.status)
+			}
+		}
+		sp.Audit(req.Context(), e)
+	})
+}
+
+// auditResponseWriter is an http.ResponseWriter that records the status
+// of the response, and the body of any error response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.Write.
+func (w *auditResponseWriter) Write(buf []byte) (int, error) {
+	if w.status >= http.StatusBadRequest {
+		w.body.Write(buf)
+	}
+	return w.ResponseWriter.Write(buf)
+}
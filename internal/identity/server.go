@@ -7,12 +7,14 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"time"
 
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
 	"github.com/juju/utils/debugstatus"
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,19 +24,39 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/bakery/identchecker"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/internal/auth"
 	"github.com/canonical/candid/internal/auth/httpauth"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/internal/groupexpiry"
 	"github.com/canonical/candid/internal/monitoring"
+	"github.com/canonical/candid/internal/notify"
+	"github.com/canonical/candid/internal/reaper"
 	"github.com/canonical/candid/meeting"
+	"github.com/canonical/candid/oidcissuer"
 	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/policy"
 	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/webhook"
 )
 
 const (
 	defaultAPIMacaroonTimeout       = 24 * time.Hour
 	defaultDischargeMacaroonTimeout = 24 * time.Hour
 	defaultDischargeTokenTimeout    = 6 * time.Hour
+	defaultOIDCTokenTimeout         = time.Hour
+	defaultMaxElevationDuration     = 8 * time.Hour
+
+	// groupExpiryInterval holds the time between sweeps for expired
+	// group memberships. Expired memberships are ignored as soon as
+	// they expire, so this only needs to be often enough to stop
+	// them accumulating.
+	groupExpiryInterval = time.Hour
+
+	// webhookInterval holds the time between checks for identity
+	// change events to send to the configured webhooks.
+	webhookInterval = 5 * time.Second
 )
 
 var logger = loggo.GetLogger("candid.internal.identity")
@@ -49,6 +71,11 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	if len(versions) == 0 {
 		return nil, errgo.Newf("identity server must serve at least one version of the API")
 	}
+	if len(sp.Webhooks) > 0 {
+		// Record identity change events for every change made
+		// by the server.
+		sp.Store = notify.NewStore(sp.Store, sp.Webhooks)
+	}
 
 	// Create the bakery parts.
 	if sp.Key == nil {
@@ -85,6 +112,20 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	if sp.DischargeTokenTimeout == 0 {
 		sp.DischargeTokenTimeout = defaultDischargeTokenTimeout
 	}
+	if sp.OIDCTokenTimeout == 0 {
+		sp.OIDCTokenTimeout = defaultOIDCTokenTimeout
+	}
+	if sp.MaxElevationDuration == 0 {
+		sp.MaxElevationDuration = defaultMaxElevationDuration
+	}
+	dischargePolicy, err := policy.New(sp.DischargePolicy)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid discharge policy")
+	}
+	trustedProxies, err := policy.ParseNetworks(sp.TrustedProxies)
+	if err != nil {
+		return nil, errgo.Notef(err, "invalid trusted proxies")
+	}
 	aclManager, err := aclstore.NewManager(context.Background(), aclstore.Params{
 		Store:             sp.ACLStore,
 		InitialAdminUsers: []string{auth.AdminUsername},
@@ -92,6 +133,10 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
+	deletionStore, err := sp.ProviderDataStore.KeyValueStore(context.Background(), deletion.KeyValueStore)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
 	auth, err := auth.New(auth.Params{
 		AdminPassword:     sp.AdminPassword,
 		Location:          sp.Location,
@@ -99,6 +144,7 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 		Store:             sp.Store,
 		IdentityProviders: sp.IdentityProviders,
 		ACLManager:        aclManager,
+		DeletionStore:     deletionStore,
 	})
 	if err != nil {
 		return nil, errgo.Mask(err)
@@ -113,9 +159,11 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 				WriteError(ctx, w, err)
 				return nil, errgo.Mask(err)
 			}
+			setACLActor(req, ai.Identity.Id())
 			return ai.Identity.(aclstore.Identity), nil
 		},
 	})
+	aclHandler = auditACLHandler(sp, aclHandler)
 
 	if err := auth.SetAdminPublicKey(context.Background(), sp.AdminAgentPublicKey); err != nil {
 		return nil, errgo.Mask(err)
@@ -131,6 +179,36 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 		return nil, errgo.Notef(err, "cannot create meeting place")
 	}
 
+	reaper, err := reaper.New(reaper.Params{
+		Store:                sp.Store,
+		DeletionStore:        deletionStore,
+		Audit:                sp.Audit,
+		Metrics:              monitoring.NewReaperMetrics(),
+		Interval:             sp.ReaperInterval,
+		InactivePeriod:       sp.InactiveIdentityPeriod,
+		InactiveAction:       sp.InactiveIdentityAction,
+		RemoveOrphanedAgents: sp.RemoveOrphanedAgents,
+	})
+	if err != nil {
+		place.Close()
+		return nil, errgo.Notef(err, "cannot create reaper")
+	}
+
+	groupExpiry := groupexpiry.New(groupexpiry.Params{
+		Store:    sp.Store,
+		Audit:    sp.Audit,
+		Interval: groupExpiryInterval,
+	})
+
+	var dispatcher *notify.Dispatcher
+	if len(sp.Webhooks) > 0 {
+		dispatcher = notify.NewDispatcher(notify.Params{
+			Store:    sp.Store,
+			Webhooks: sp.Webhooks,
+			Interval: webhookInterval,
+		})
+	}
+
 	storeCollector := monitoring.StoreCollector{Store: sp.Store}
 	prometheus.Register(storeCollector)
 
@@ -138,6 +216,9 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	srv := &Server{
 		router:         httprouter.New(),
 		meetingPlace:   place,
+		reaper:         reaper,
+		groupExpiry:    groupExpiry,
+		dispatcher:     dispatcher,
 		storeCollector: storeCollector,
 	}
 	// Disable the automatic rerouting in order to maintain
@@ -156,10 +237,14 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 	srv.router.Handler("GET", "/static/*path", http.StripPrefix("/static", http.FileServer(sp.StaticFileSystem)))
 	for name, newAPI := range versions {
 		handlers, err := newAPI(HandlerParams{
-			ServerParams: sp,
-			Oven:         oven,
-			Authorizer:   auth,
-			MeetingPlace: place,
+			ServerParams:   sp,
+			Oven:           oven,
+			Authorizer:     auth,
+			MeetingPlace:   place,
+			Reaper:         reaper,
+			Policy:         dischargePolicy,
+			TrustedProxies: trustedProxies,
+			DeletionStore:  deletionStore,
 		})
 		if err != nil {
 			return nil, errgo.Notef(err, "cannot create API %s", name)
@@ -175,6 +260,9 @@ func New(sp ServerParams, versions map[string]NewAPIHandlerFunc) (*Server, error
 type Server struct {
 	router         *httprouter.Router
 	meetingPlace   *meeting.Place
+	reaper         *reaper.Reaper
+	groupExpiry    *groupexpiry.Sweeper
+	dispatcher     *notify.Dispatcher
 	storeCollector monitoring.StoreCollector
 }
 
@@ -199,6 +287,11 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) Close() {
 	logger.Debugf("Closing Server")
 	s.meetingPlace.Close()
+	s.reaper.Close()
+	s.groupExpiry.Close()
+	if s.dispatcher != nil {
+		s.dispatcher.Close()
+	}
 	prometheus.Unregister(s.storeCollector)
 }
 
@@ -210,7 +303,8 @@ type ServerParams struct {
 
 	// ProviderDataStore holds the storeage that can be used by
 	// identity providers to store data that is not associated with
-	// an individual identity.
+	// an individual identity. It is also used to record deleted
+	// identities, so it must be set.
 	ProviderDataStore store.ProviderDataStore
 
 	// RootKeyStore holds the root key store that will be used to
@@ -289,6 +383,61 @@ type ServerParams struct {
 	// EnableEmailLogin enables the login with email address link on the
 	// authentication required page.
 	EnableEmailLogin bool
+
+	// AuditSink holds the sink to which audit events are written.
+	// If this is nil then no audit events will be recorded. If the
+	// sink also implements audit.Store then the recorded events can
+	// be retrieved using the /v1/audit endpoint.
+	AuditSink audit.Sink
+
+	// OIDCClients holds the relying parties that may use the
+	// identity server as an OpenID Connect provider.
+	OIDCClients []oidcissuer.Client
+
+	// OIDCTokenTimeout is the maximum life of an OpenID Connect
+	// ID token or access token.
+	OIDCTokenTimeout time.Duration
+
+	// ReaperInterval holds the time between runs of the identity
+	// reaper. If this is zero the reaper does not run, although
+	// the /v1/reaper endpoint still reports what it would do.
+	ReaperInterval time.Duration
+
+	// InactiveIdentityPeriod holds the length of time after which
+	// an identity that has neither logged in nor been discharged
+	// is considered inactive by the reaper. If this is zero
+	// inactive identities are ignored.
+	InactiveIdentityPeriod time.Duration
+
+	// InactiveIdentityAction holds the action the reaper takes on
+	// inactive identities, it must be one of "flag", "suspend" or
+	// "delete". If this is empty "flag" is used.
+	InactiveIdentityAction string
+
+	// RemoveOrphanedAgents holds whether the reaper deletes agents
+	// whose owner no longer exists.
+	RemoveOrphanedAgents bool
+
+	// MaxElevationDuration holds the longest time for which an
+	// approved elevation may grant membership of a group. If this
+	// is zero a default of 8 hours is used.
+	MaxElevationDuration time.Duration
+
+	// DischargePolicy holds rules that are evaluated, in order,
+	// when a third-party caveat is discharged. The first matching
+	// rule decides whether the discharge is allowed and which
+	// additional caveats are added to the discharge macaroon.
+	DischargePolicy []policy.Rule
+
+	// TrustedProxies holds the IP addresses, or CIDR networks, of
+	// the reverse proxies that are trusted to report the address of
+	// the client in the X-Forwarded-For header. The client address
+	// is used when evaluating the discharge policy.
+	TrustedProxies []string
+
+	// Webhooks holds the webhooks to which identity change events
+	// are sent.
+	Webhooks []webhook.Webhook
 }
 
 type HandlerParams struct {
@@ -305,6 +454,22 @@ type HandlerParams struct {
 	// MeetingPlace contains the meeting place that should be used by
 	// handlers to complete rendezvous.
 	MeetingPlace *meeting.Place
+
+	// Reaper contains the reaper that acts on inactive identities
+	// and orphaned agents.
+	Reaper *reaper.Reaper
+
+	// Policy contains the compiled discharge policy that is consulted
+	// when discharging third-party caveats.
+	Policy *policy.Policy
+
+	// TrustedProxies contains the networks of the reverse proxies
+	// that are trusted to report the address of the client.
+	TrustedProxies []*net.IPNet
+
+	// DeletionStore contains the key-value store in which deleted
+	// identities are recorded.
+	DeletionStore simplekv.Store
 }
 
 // notFound is the handler that is called when a handler cannot be found
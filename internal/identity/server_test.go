@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
@@ -24,6 +25,7 @@ import (
 	"gopkg.in/httprequest.v1"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/static"
 	"github.com/canonical/candid/internal/auth"
@@ -50,8 +52,9 @@ func (s *serverSuite) Init(c *qt.C) {
 
 func (s *serverSuite) TestNewServerWithNoVersions(c *qt.C) {
 	h, err := identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
 	}, nil)
 	c.Assert(err, qt.ErrorMatches, `identity server must serve at least one version of the API`)
 	c.Assert(h, qt.IsNil)
@@ -83,9 +86,10 @@ func (s *serverSuite) TestNewServerWithVersions(c *qt.C) {
 	}
 
 	h, err := identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
-		ACLStore:     s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		ACLStore:          s.store.ACLStore,
 	}, map[string]identity.NewAPIHandlerFunc{
 		"version1": serveVersion("version1"),
 	})
@@ -96,9 +100,10 @@ func (s *serverSuite) TestNewServerWithVersions(c *qt.C) {
 	assertDoesNotServeVersion(c, h, "version3")
 
 	h, err = identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
-		ACLStore:     s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		ACLStore:          s.store.ACLStore,
 	}, map[string]identity.NewAPIHandlerFunc{
 		"version1": serveVersion("version1"),
 		"version2": serveVersion("version2"),
@@ -110,9 +115,10 @@ func (s *serverSuite) TestNewServerWithVersions(c *qt.C) {
 	assertDoesNotServeVersion(c, h, "version3")
 
 	h, err = identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
-		ACLStore:     s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		ACLStore:          s.store.ACLStore,
 	}, map[string]identity.NewAPIHandlerFunc{
 		"version1": serveVersion("version1"),
 		"version2": serveVersion("version2"),
@@ -138,9 +144,10 @@ func (s *serverSuite) TestServerHasAccessControlAllowHeaders(c *qt.C) {
 	}
 
 	h, err := identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
-		ACLStore:     s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		ACLStore:          s.store.ACLStore,
 	}, impl)
 	c.Assert(err, qt.IsNil)
 	defer h.Close()
@@ -186,9 +193,10 @@ func (s *serverSuite) TestServerPanicRecovery(c *qt.C) {
 	}
 
 	h, err := identity.New(identity.ServerParams{
-		Store:        s.store.Store,
-		MeetingStore: s.store.MeetingStore,
-		ACLStore:     s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		ACLStore:          s.store.ACLStore,
 	}, impl)
 	c.Assert(err, qt.IsNil)
 	defer h.Close()
@@ -225,10 +233,11 @@ func (s *serverSuite) TestServerStaticFiles(c *qt.C) {
 	}
 	path := c.Mkdir()
 	h, err := identity.New(identity.ServerParams{
-		Store:            s.store.Store,
-		MeetingStore:     s.store.MeetingStore,
-		StaticFileSystem: http.Dir(path),
-		ACLStore:         s.store.ACLStore,
+		Store:             s.store.Store,
+		ProviderDataStore: s.store.ProviderDataStore,
+		MeetingStore:      s.store.MeetingStore,
+		StaticFileSystem:  http.Dir(path),
+		ACLStore:          s.store.ACLStore,
 	}, map[string]identity.NewAPIHandlerFunc{
 		"version1": serveVersion("version1"),
 	})
@@ -248,6 +257,56 @@ func (s *serverSuite) TestServerStaticFiles(c *qt.C) {
 	c.Assert(rr.Body.String(), qt.Equals, "test file")
 }
 
+func (s *serverSuite) TestACLAudit(c *qt.C) {
+	ctx := context.Background()
+	srv := candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+	})
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL + "/acl",
+		Doer:    srv.AdminClient(),
+	})
+	_, err := client.Get(ctx, "read-user")
+	c.Assert(err, qt.IsNil)
+	err = client.Add(ctx, "read-user", []string{"test-1"})
+	c.Assert(err, qt.IsNil)
+	err = client.Set(ctx, "read-user", []string{"test-2"})
+	c.Assert(err, qt.IsNil)
+	err = client.Add(ctx, "no-such-acl", []string{"test-1"})
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	events, err := s.store.AuditStore.Events(ctx, audit.Filter{})
+	c.Assert(err, qt.IsNil)
+	// Ignore the events recorded when the admin user was
+	// discharged.
+	var aclEvents []audit.Event
+	for _, e := range events {
+		if e.ACL == "" {
+			continue
+		}
+		c.Assert(e.Time.IsZero(), qt.Equals, false)
+		e.Time = time.Time{}
+		aclEvents = append(aclEvents, e)
+	}
+	c.Assert(aclEvents, qt.DeepEquals, []audit.Event{{
+		Type:  audit.ModifyACL,
+		Actor: auth.AdminUsername,
+		ACL:   "read-user",
+		Add:   []string{"test-1"},
+	}, {
+		Type:  audit.SetACL,
+		Actor: auth.AdminUsername,
+		ACL:   "read-user",
+		Set:   []string{"test-2"},
+	}, {
+		Type:  audit.ModifyACL,
+		Actor: auth.AdminUsername,
+		ACL:   "no-such-acl",
+		Add:   []string{"test-1"},
+		Error: `ACL not found`,
+	}})
+}
+
 func assertServesVersion(c *qt.C, h http.Handler, vers string) {
 	path := vers
 	if path != "" {
@@ -0,0 +1,42 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package monitoring
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReaperMetrics reports the actions taken by the identity reaper.
+type ReaperMetrics struct {
+	identitiesReaped *prometheus.CounterVec
+}
+
+// NewReaperMetrics creates a new ReaperMetrics and registers its
+// counters with the default prometheus registerer.
+func NewReaperMetrics() *ReaperMetrics {
+	identitiesReaped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "candid",
+		Subsystem: "reaper",
+		Name:      "identities_total",
+		Help:      "Count of identities acted on by the reaper.",
+	}, []string{"action", "reason"})
+	if err := prometheus.DefaultRegisterer.Register(identitiesReaped); err != nil {
+		// When more than one server is created in the same
+		// process use the counters that are already registered
+		// so that all the actions are reported.
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		if !ok {
+			panic(err)
+		}
+		identitiesReaped = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	return &ReaperMetrics{
+		identitiesReaped: identitiesReaped,
+	}
+}
+
+// IdentityReaped implements reaper.Metrics.
+func (m *ReaperMetrics) IdentityReaped(action, reason string) {
+	m.identitiesReaped.WithLabelValues(action, reason).Inc()
+}
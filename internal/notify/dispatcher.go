@@ -0,0 +1,272 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package notify publishes identity change events to webhooks. Events
+// are recorded in the outbox of the store by a wrapped store.Store and
+// delivered in the background by a Dispatcher, which retries failed
+// deliveries. Each event is delivered at least once.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/loggo"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/webhook"
+)
+
+var logger = loggo.GetLogger("candid.internal.notify")
+
+const (
+	// batchSize is the maximum number of events that are sent in a
+	// single call to Dispatch.
+	batchSize = 100
+
+	// defaultMaxAttempts is the number of delivery attempts made
+	// for each event if no other value is specified.
+	defaultMaxAttempts = 10
+
+	// minRetryDelay and maxRetryDelay bound the time between
+	// delivery attempts of an event. The delay doubles after each
+	// failed attempt.
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = time.Hour
+
+	// requestTimeout is the maximum time a webhook request may
+	// take.
+	requestTimeout = 30 * time.Second
+
+	// claimDuration is the time for which a dispatcher claims an
+	// event while it attempts to deliver it. Other dispatchers using
+	// the same store do not attempt the event until the claim
+	// expires, so this must be longer than requestTimeout.
+	claimDuration = 5 * time.Minute
+)
+
+// Params holds the parameters for a Dispatcher.
+type Params struct {
+	// Store holds the store containing the outbox.
+	Store store.Store
+
+	// Webhooks holds the webhooks to which events are sent.
+	Webhooks []webhook.Webhook
+
+	// Client holds the HTTP client used to send events. If this is
+	// nil http.DefaultClient is used. Requests are abandoned if they
+	// take longer than 30 seconds.
+	Client *http.Client
+
+	// MaxAttempts holds the number of times delivery of an event is
+	// attempted before it is discarded. If this is zero a default
+	// of 10 is used.
+	MaxAttempts int
+
+	// Interval holds the time between checks for events to send.
+	// If this is zero then the dispatcher will not run in the
+	// background.
+	Interval time.Duration
+}
+
+// A Dispatcher delivers the events recorded in the outbox to their
+// webhooks.
+type Dispatcher struct {
+	tomb   tomb.Tomb
+	params Params
+	hooks  map[string]webhook.Webhook
+
+	// ctx is used for requests made in the background, cancel
+	// aborts any such request when the dispatcher is closed.
+	ctx    context.Context
+	cancel func()
+}
+
+// NewDispatcher creates a new Dispatcher. If the dispatcher has a
+// non-zero interval then it is started in the background. The returned
+// Dispatcher must be closed when it is no longer required.
+func NewDispatcher(p Params) *Dispatcher {
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	d := &Dispatcher{
+		params: p,
+		hooks:  make(map[string]webhook.Webhook),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, w := range p.Webhooks {
+		d.hooks[w.Name] = w
+	}
+	d.tomb.Go(d.loop)
+	return d
+}
+
+// Close stops the dispatcher.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.tomb.Kill(nil)
+	d.tomb.Wait()
+}
+
+// loop dispatches events at regular intervals until it is stopped.
+func (d *Dispatcher) loop() error {
+	if d.params.Interval <= 0 {
+		return nil
+	}
+	for {
+		ctx, close := d.params.Store.Context(d.ctx)
+		if _, err := d.Dispatch(ctx, time.Now()); err != nil {
+			logger.Errorf("cannot dispatch events: %v", err)
+		}
+		close()
+		select {
+		case <-time.After(d.params.Interval):
+		case <-d.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// Dispatch attempts to deliver the events in the outbox that are due
+// at the given time. Delivered events are removed from the outbox,
+// others are rescheduled until they have been attempted the maximum
+// number of times. Events are delivered to each webhook concurrently,
+// so that an unresponsive webhook does not delay delivery to the
+// others. It returns the number of events delivered.
+func (d *Dispatcher) Dispatch(ctx context.Context, now time.Time) (int, error) {
+	entries, err := d.params.Store.OutboxEntries(ctx, now, batchSize)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot get outbox entries")
+	}
+	var names []string
+	byWebhook := make(map[string][]store.OutboxEntry)
+	for i := range entries {
+		e := &entries[i]
+		if _, ok := d.hooks[e.Webhook]; !ok {
+			logger.Warningf("discarding event for unknown webhook %s", e.Webhook)
+			d.remove(ctx, e)
+			continue
+		}
+		if _, ok := byWebhook[e.Webhook]; !ok {
+			names = append(names, e.Webhook)
+		}
+		byWebhook[e.Webhook] = append(byWebhook[e.Webhook], *e)
+	}
+	delivered := make([]int, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, w webhook.Webhook, entries []store.OutboxEntry) {
+			defer wg.Done()
+			delivered[i] = d.dispatchWebhook(ctx, w, entries, now)
+		}(i, d.hooks[name], byWebhook[name])
+	}
+	wg.Wait()
+	n := 0
+	for _, dn := range delivered {
+		n += dn
+	}
+	return n, nil
+}
+
+// dispatchWebhook attempts to deliver the given events, in order, to
+// the given webhook. Each event is claimed before it is sent so that
+// it is not sent by any other dispatcher at the same time. After a
+// failed attempt the remaining events are left for a later call to
+// Dispatch. It returns the number of events delivered.
+func (d *Dispatcher) dispatchWebhook(ctx context.Context, w webhook.Webhook, entries []store.OutboxEntry, now time.Time) int {
+	n := 0
+	for i := range entries {
+		e := &entries[i]
+		if err := d.params.Store.ClaimOutboxEntry(ctx, e.ID, now, now.Add(claimDuration)); err != nil {
+			if errgo.Cause(err) == store.ErrNotFound {
+				// The event has been claimed, or delivered, by
+				// another dispatcher.
+				continue
+			}
+			logger.Errorf("cannot claim event for webhook %s: %s", w.Name, err)
+			return n
+		}
+		err := d.send(ctx, w, e.Payload)
+		if err == nil {
+			d.remove(ctx, e)
+			n++
+			continue
+		}
+		if ctx.Err() != nil {
+			// The dispatcher is stopping, the failure was not
+			// the fault of the webhook. The event will be
+			// attempted again once the claim expires.
+			return n
+		}
+		e.Attempts++
+		if e.Attempts >= d.params.MaxAttempts {
+			logger.Errorf("discarding event for webhook %s after %d attempts: %s", w.Name, e.Attempts, err)
+			d.remove(ctx, e)
+			return n
+		}
+		logger.Infof("cannot send event to webhook %s (attempt %d): %s", w.Name, e.Attempts, err)
+		e.NextAttempt = now.Add(retryDelay(e.Attempts))
+		if err := d.params.Store.UpdateOutboxEntry(ctx, e); err != nil {
+			logger.Errorf("cannot reschedule event for webhook %s: %s", w.Name, err)
+		}
+		return n
+	}
+	return n
+}
+
+// send sends the given payload to the given webhook.
+func (d *Dispatcher) send(ctx context.Context, w webhook.Webhook, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	// The timestamp is the time the request is actually sent, which
+	// may be some time after the events were due.
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(params.WebhookTimestampHeader, timestamp)
+	req.Header.Set(params.WebhookSignatureHeader, params.WebhookSignature(w.Secret, timestamp, payload))
+	resp, err := d.params.Client.Do(req)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errgo.Newf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
+
+// remove removes the given entry from the outbox.
+func (d *Dispatcher) remove(ctx context.Context, e *store.OutboxEntry) {
+	err := d.params.Store.RemoveOutboxEntry(ctx, e.ID)
+	if err != nil && errgo.Cause(err) != store.ErrNotFound {
+		logger.Errorf("cannot remove event for webhook %s: %s", e.Webhook, err)
+	}
+}
+
+// retryDelay returns the time to wait before the next delivery attempt
+// after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
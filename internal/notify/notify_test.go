@@ -0,0 +1,675 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/internal/notify"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/memstore"
+	"github.com/canonical/candid/webhook"
+)
+
+var now = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestStoreEvents(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := memstore.NewStore()
+	nst := notify.NewStore(st, []webhook.Webhook{{
+		Name: "all",
+	}, {
+		Name:   "groups",
+		Events: []params.IdentityEventType{params.IdentityGroupsChanged},
+	}})
+
+	// Create an identity.
+	err := nst.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Groups:     []string{"g1"},
+	}, store.Update{
+		store.Username: store.Set,
+		store.Groups:   store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, []string{"all"}, params.IdentityEvent{
+		Type:       params.IdentityCreated,
+		User:       "alice",
+		ProviderID: "test:alice",
+		Groups:     []string{"g1"},
+	})
+
+	// Change the groups.
+	err = nst.UpdateIdentity(ctx, &store.Identity{
+		Username: "alice",
+		Groups:   []string{"g2"},
+	}, store.Update{
+		store.Groups: store.Push,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, []string{"all", "groups"}, params.IdentityEvent{
+		Type:       params.IdentityGroupsChanged,
+		User:       "alice",
+		ProviderID: "test:alice",
+		Groups:     []string{"g1", "g2"},
+	})
+
+	// Any update of the groups is reported, even if it leaves
+	// them unchanged.
+	err = nst.UpdateIdentity(ctx, &store.Identity{
+		Username: "alice",
+		Groups:   []string{"g2", "g1"},
+	}, store.Update{
+		store.Groups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, []string{"all", "groups"}, params.IdentityEvent{
+		Type:       params.IdentityGroupsChanged,
+		User:       "alice",
+		ProviderID: "test:alice",
+		Groups:     []string{"g2", "g1"},
+	})
+
+	// Change the SSH keys.
+	err = nst.UpdateIdentity(ctx, &store.Identity{
+		Username: "alice",
+		ExtraInfo: map[string][]string{
+			"sshkeys": {"ssh-ed25519 AAAA"},
+		},
+	}, store.Update{
+		store.ExtraInfo: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, []string{"all"}, params.IdentityEvent{
+		Type:       params.IdentitySSHKeysChanged,
+		User:       "alice",
+		ProviderID: "test:alice",
+		Groups:     []string{"g2", "g1"},
+	})
+
+	// Other changes cause no events.
+	err = nst.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Name:       "Alice",
+		LastLogin:  now,
+	}, store.Update{
+		store.Username:  store.Set,
+		store.Name:      store.Set,
+		store.LastLogin: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, nil, params.IdentityEvent{})
+	err = nst.UpdateIdentity(ctx, &store.Identity{
+		Username: "alice",
+		ExtraInfo: map[string][]string{
+			"other": {"value"},
+		},
+	}, store.Update{
+		store.ExtraInfo: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, nil, params.IdentityEvent{})
+
+	// Delete the identity.
+	err = nst.DeleteIdentity(ctx, &store.Identity{
+		Username: "alice",
+	})
+	c.Assert(err, qt.IsNil)
+	assertEvents(c, st, []string{"all"}, params.IdentityEvent{
+		Type:       params.IdentityDeleted,
+		User:       "alice",
+		ProviderID: "test:alice",
+	})
+}
+
+func TestStoreFailedChange(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := memstore.NewStore()
+	nst := notify.NewStore(st, []webhook.Webhook{{
+		Name: "all",
+	}})
+	err := nst.UpdateIdentity(ctx, &store.Identity{
+		Username: "bob",
+		Groups:   []string{"g1"},
+	}, store.Update{
+		store.Groups: store.Set,
+	})
+	c.Assert(err, qt.ErrorMatches, `user bob not found`)
+	err = nst.DeleteIdentity(ctx, &store.Identity{
+		Username: "bob",
+	})
+	c.Assert(err, qt.ErrorMatches, `user bob not found`)
+	assertEvents(c, st, nil, params.IdentityEvent{})
+}
+
+func TestStoreGroupEvents(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := memstore.NewStore()
+	nst := notify.NewStore(st, []webhook.Webhook{{
+		Name:   "groups",
+		Events: []params.IdentityEventType{params.IdentityGroupsChanged},
+	}})
+	for _, id := range []store.Identity{{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		Groups:     []string{"dev"},
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"interns"},
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "carol"),
+		Username:   "carol",
+		Groups:     []string{"sales"},
+	}} {
+		err := st.UpdateIdentity(ctx, &id, store.Update{
+			store.Username: store.Set,
+			store.Groups:   store.Set,
+		})
+		c.Assert(err, qt.IsNil)
+	}
+	err := st.AddGroup(ctx, &store.Group{
+		Name:         "dev",
+		MemberGroups: []string{"interns"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	// Adding a group with member groups changes the groups of the
+	// members of the member groups, directly or indirectly.
+	err = nst.AddGroup(ctx, &store.Group{
+		Name:         "staff",
+		MemberGroups: []string{"dev"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"alice", "bob"})
+
+	// Changes that leave the member groups alone cause no events.
+	err = nst.UpdateGroup(ctx, &store.Group{
+		Name:        "staff",
+		Description: "All staff",
+	}, store.GroupUpdate{
+		store.GroupDescription: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.HasLen, 0)
+
+	// Replacing the member groups reports the members of both the
+	// old and the new member groups.
+	err = nst.UpdateGroup(ctx, &store.Group{
+		Name:         "staff",
+		MemberGroups: []string{"sales"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"alice", "bob", "carol"})
+
+	// Removing a member group reports its members.
+	err = nst.UpdateGroup(ctx, &store.Group{
+		Name:         "dev",
+		MemberGroups: []string{"interns"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Pull,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"bob"})
+
+	// Removing a group reports its members.
+	err = nst.RemoveGroup(ctx, "staff")
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"carol"})
+
+	// Failed changes cause no events.
+	err = nst.RemoveGroup(ctx, "staff")
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	err = nst.UpdateGroup(ctx, &store.Group{
+		Name:         "staff",
+		MemberGroups: []string{"dev"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Set,
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(groupsChangedUsers(c, st), qt.HasLen, 0)
+}
+
+func TestStoreElevationEvents(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := memstore.NewStore()
+	nst := notify.NewStore(st, []webhook.Webhook{{
+		Name:   "groups",
+		Events: []params.IdentityEventType{params.IdentityGroupsChanged},
+	}})
+	err := st.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	var elevations []store.Elevation
+	for i := 0; i < 2; i++ {
+		e := store.Elevation{
+			Username:  "alice",
+			Group:     "admin",
+			Duration:  time.Hour,
+			State:     store.ElevationPending,
+			Requested: now,
+		}
+		err := st.AddElevation(ctx, &e)
+		c.Assert(err, qt.IsNil)
+		elevations = append(elevations, e)
+	}
+
+	// Denying an elevation leaves the user's groups unchanged.
+	err = nst.UpdateElevation(ctx, &store.Elevation{
+		ID:       elevations[0].ID,
+		State:    store.ElevationDenied,
+		Approver: "bob",
+		Decided:  now,
+	}, store.ElevationPending)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.HasLen, 0)
+
+	// Approving and revoking an elevation change them.
+	err = nst.UpdateElevation(ctx, &store.Elevation{
+		ID:       elevations[1].ID,
+		State:    store.ElevationApproved,
+		Approver: "bob",
+		Decided:  now,
+		Expires:  now.Add(time.Hour),
+	}, store.ElevationPending)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"alice"})
+	err = nst.UpdateElevation(ctx, &store.Elevation{
+		ID:       elevations[1].ID,
+		State:    store.ElevationRevoked,
+		Approver: "bob",
+		Decided:  now,
+		Expires:  now,
+	}, store.ElevationApproved)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groupsChangedUsers(c, st), qt.DeepEquals, []string{"alice"})
+
+	// A failed change causes no event.
+	err = nst.UpdateElevation(ctx, &store.Elevation{
+		ID:    elevations[1].ID,
+		State: store.ElevationRevoked,
+	}, store.ElevationApproved)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrElevationStateChanged)
+	c.Assert(groupsChangedUsers(c, st), qt.HasLen, 0)
+}
+
+// groupsChangedUsers returns the sorted users of the
+// IdentityGroupsChanged events in the outbox, then empties the outbox.
+func groupsChangedUsers(c *qt.C, st store.Store) []string {
+	ctx := context.Background()
+	entries, err := st.OutboxEntries(ctx, time.Now(), 0)
+	c.Assert(err, qt.IsNil)
+	var users []string
+	for _, e := range entries {
+		var ev params.IdentityEvent
+		err := json.Unmarshal(e.Payload, &ev)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ev.Type, qt.Equals, params.IdentityGroupsChanged)
+		users = append(users, string(ev.User))
+		err = st.RemoveOutboxEntry(ctx, e.ID)
+		c.Assert(err, qt.IsNil)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// assertEvents checks that the outbox holds an entry with the expected
+// event for each of the given webhooks, then empties the outbox.
+func assertEvents(c *qt.C, st store.Store, hooks []string, expect params.IdentityEvent) {
+	ctx := context.Background()
+	entries, err := st.OutboxEntries(ctx, time.Now(), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, len(hooks))
+	var id string
+	for i, e := range entries {
+		c.Assert(e.Webhook, qt.Equals, hooks[i])
+		var ev params.IdentityEvent
+		err := json.Unmarshal(e.Payload, &ev)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ev.ID, qt.Not(qt.Equals), "")
+		if id == "" {
+			id = ev.ID
+		}
+		// Every webhook is sent the same event.
+		c.Assert(ev.ID, qt.Equals, id)
+		c.Assert(ev.Time.IsZero(), qt.Equals, false)
+		ev.ID = ""
+		ev.Time = time.Time{}
+		c.Assert(ev, qt.DeepEquals, expect)
+		err = st.RemoveOutboxEntry(ctx, e.ID)
+		c.Assert(err, qt.IsNil)
+	}
+}
+
+// receiver records the events sent to a webhook.
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	events []params.IdentityEvent
+	errors []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	timestamp := req.Header.Get(params.WebhookTimestampHeader)
+	sig := params.WebhookSignature("s3cret", timestamp, body)
+	if req.Header.Get(params.WebhookSignatureHeader) != sig {
+		r.errors = append(r.errors, "bad signature")
+	}
+	// The timestamp is the time the request was sent.
+	if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		r.errors = append(r.errors, "bad timestamp "+timestamp)
+	}
+	var ev params.IdentityEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		r.errors = append(r.errors, err.Error())
+	}
+	r.events = append(r.events, ev)
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	r := new(receiver)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	st := memstore.NewStore()
+	err := st.AddOutboxEntries(ctx, []store.OutboxEntry{{
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}, {
+		Webhook:     "unknown",
+		Payload:     []byte(`{"id":"2","type":"identity-created","user":"bob"}`),
+		Created:     now,
+		NextAttempt: now,
+	}, {
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"3","type":"identity-deleted","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now.Add(time.Minute),
+	}})
+	c.Assert(err, qt.IsNil)
+
+	d := notify.NewDispatcher(notify.Params{
+		Store: st,
+		Webhooks: []webhook.Webhook{{
+			Name:   "hook",
+			URL:    srv.URL,
+			Secret: "s3cret",
+		}},
+	})
+	defer d.Close()
+
+	n, err := d.Dispatch(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 1)
+	c.Assert(r.errors, qt.HasLen, 0)
+	c.Assert(r.events, qt.DeepEquals, []params.IdentityEvent{{
+		ID:   "1",
+		Type: params.IdentityCreated,
+		User: "alice",
+	}})
+
+	// Only the entry that is not yet due remains.
+	entries, err := st.OutboxEntries(ctx, now.Add(time.Hour), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Payload, qt.DeepEquals, []byte(`{"id":"3","type":"identity-deleted","user":"alice"}`))
+}
+
+func TestDispatchRetry(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	r := &receiver{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	st := memstore.NewStore()
+	err := st.AddOutboxEntries(ctx, []store.OutboxEntry{{
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}})
+	c.Assert(err, qt.IsNil)
+
+	d := notify.NewDispatcher(notify.Params{
+		Store: st,
+		Webhooks: []webhook.Webhook{{
+			Name:   "hook",
+			URL:    srv.URL,
+			Secret: "s3cret",
+		}},
+		MaxAttempts: 3,
+	})
+	defer d.Close()
+
+	t0 := now
+	for i, delay := range []time.Duration{30 * time.Second, time.Minute} {
+		n, err := d.Dispatch(ctx, t0)
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, 0)
+		c.Assert(r.events, qt.HasLen, i+1)
+
+		entries, err := st.OutboxEntries(ctx, t0.Add(time.Hour), 0)
+		c.Assert(err, qt.IsNil)
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].Attempts, qt.Equals, i+1)
+		c.Assert(entries[0].NextAttempt, qt.DeepEquals, t0.Add(delay))
+
+		// Nothing is sent before the next attempt is due.
+		n, err = d.Dispatch(ctx, t0.Add(delay-time.Second))
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.events, qt.HasLen, i+1)
+		t0 = t0.Add(delay)
+	}
+
+	// The final attempt discards the event.
+	n, err := d.Dispatch(ctx, t0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 0)
+	c.Assert(r.events, qt.HasLen, 3)
+	entries, err := st.OutboxEntries(ctx, t0.Add(time.Hour), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 0)
+	c.Assert(r.errors, qt.HasLen, 0)
+}
+
+func TestDispatchClaimed(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	r := new(receiver)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	st := memstore.NewStore()
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}}
+	err := st.AddOutboxEntries(ctx, entries)
+	c.Assert(err, qt.IsNil)
+
+	// Another dispatcher claims the event.
+	err = st.ClaimOutboxEntry(ctx, entries[0].ID, now, now.Add(time.Minute))
+	c.Assert(err, qt.IsNil)
+
+	d := notify.NewDispatcher(notify.Params{
+		Store: st,
+		Webhooks: []webhook.Webhook{{
+			Name:   "hook",
+			URL:    srv.URL,
+			Secret: "s3cret",
+		}},
+	})
+	defer d.Close()
+
+	n, err := d.Dispatch(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 0)
+	c.Assert(r.events, qt.HasLen, 0)
+
+	// The event is sent once the claim expires.
+	n, err = d.Dispatch(ctx, now.Add(time.Minute))
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 1)
+	c.Assert(r.events, qt.HasLen, 1)
+	c.Assert(r.errors, qt.HasLen, 0)
+}
+
+func TestDispatchSlowWebhook(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	fast := new(receiver)
+	received := make(chan struct{})
+	fastSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fast.ServeHTTP(w, req)
+		close(received)
+	}))
+	defer fastSrv.Close()
+	// The slow webhook only responds once the fast webhook has
+	// received its event.
+	slow := new(receiver)
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		slow.ServeHTTP(w, req)
+	}))
+	defer slowSrv.Close()
+
+	st := memstore.NewStore()
+	err := st.AddOutboxEntries(ctx, []store.OutboxEntry{{
+		Webhook:     "slow",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}, {
+		Webhook:     "fast",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}})
+	c.Assert(err, qt.IsNil)
+
+	d := notify.NewDispatcher(notify.Params{
+		Store: st,
+		Webhooks: []webhook.Webhook{{
+			Name:   "slow",
+			URL:    slowSrv.URL,
+			Secret: "s3cret",
+		}, {
+			Name:   "fast",
+			URL:    fastSrv.URL,
+			Secret: "s3cret",
+		}},
+	})
+	defer d.Close()
+
+	n, err := d.Dispatch(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(fast.events, qt.HasLen, 1)
+	c.Assert(slow.events, qt.HasLen, 1)
+}
+
+func TestDispatchStopsAfterFailure(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	r := &receiver{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	st := memstore.NewStore()
+	err := st.AddOutboxEntries(ctx, []store.OutboxEntry{{
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"1","type":"identity-created","user":"alice"}`),
+		Created:     now,
+		NextAttempt: now,
+	}, {
+		Webhook:     "hook",
+		Payload:     []byte(`{"id":"2","type":"identity-created","user":"bob"}`),
+		Created:     now,
+		NextAttempt: now,
+	}})
+	c.Assert(err, qt.IsNil)
+
+	d := notify.NewDispatcher(notify.Params{
+		Store: st,
+		Webhooks: []webhook.Webhook{{
+			Name:   "hook",
+			URL:    srv.URL,
+			Secret: "s3cret",
+		}},
+	})
+	defer d.Close()
+
+	n, err := d.Dispatch(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(n, qt.Equals, 0)
+	c.Assert(r.events, qt.HasLen, 1)
+
+	// The second event has not been attempted.
+	entries, err := st.OutboxEntries(ctx, now, 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Attempts, qt.Equals, 0)
+	c.Assert(entries[0].Payload, qt.DeepEquals, []byte(`{"id":"2","type":"identity-created","user":"bob"}`))
+}
@@ -0,0 +1,347 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/webhook"
+)
+
+// sshKeysKey is the ExtraInfo key that holds an identity's SSH keys.
+const sshKeysKey = "sshkeys"
+
+// NewStore returns a store.Store that behaves like st but also adds an
+// entry to the outbox of st for each identity change event that is
+// wanted by one of the given webhooks. Events are only recorded for
+// changes that succeed. The events are delivered by a Dispatcher.
+func NewStore(st store.Store, hooks []webhook.Webhook) store.Store {
+	return &notifyStore{
+		Store: st,
+		hooks: hooks,
+		now:   time.Now,
+	}
+}
+
+// notifyStore wraps a store.Store to record identity change events.
+type notifyStore struct {
+	store.Store
+	hooks []webhook.Webhook
+	now   func() time.Time
+}
+
+// UpdateIdentity implements store.Store.UpdateIdentity by recording
+// any events caused by the update after it has been made.
+//
+// The events are determined from the update itself, rather than by
+// comparing the identity before and after the update, because a
+// concurrent update could make such a comparison wrong. An update of
+// the groups or SSH keys of an existing identity therefore always
+// causes an event, even if it leaves them unchanged. Whether the update
+// creates the identity is determined by reading it beforehand, so if
+// the same identity is created by two concurrent updates both may be
+// reported as creating it. The event holds the identity as read after
+// the update, which may include later changes.
+func (s *notifyStore) UpdateIdentity(ctx context.Context, identity *store.Identity, update store.Update) error {
+	if !s.mayNotify(identity, update) {
+		return s.Store.UpdateIdentity(ctx, identity, update)
+	}
+	existed := true
+	if mayCreate(identity, update) {
+		before := store.Identity{
+			ProviderID: identity.ProviderID,
+		}
+		if err := s.Store.Identity(ctx, &before); err != nil {
+			if errgo.Cause(err) != store.ErrNotFound {
+				return errgo.Mask(err)
+			}
+			existed = false
+		}
+	}
+	if err := s.Store.UpdateIdentity(ctx, identity, update); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	after := store.Identity{
+		ID:         identity.ID,
+		ProviderID: identity.ProviderID,
+		Username:   identity.Username,
+	}
+	if err := s.Store.Identity(ctx, &after); err != nil {
+		logger.Errorf("cannot read updated identity %s: %s", identity.ProviderID, err)
+		return nil
+	}
+	var events []params.IdentityEventType
+	switch {
+	case !existed:
+		events = append(events, params.IdentityCreated)
+	default:
+		if update[store.Groups] != store.NoUpdate || update[store.GroupExpiry] != store.NoUpdate {
+			events = append(events, params.IdentityGroupsChanged)
+		}
+		if updatesSSHKeys(identity, update) {
+			events = append(events, params.IdentitySSHKeysChanged)
+		}
+	}
+	for _, t := range events {
+		s.notify(ctx, t, &after)
+	}
+	return nil
+}
+
+// DeleteIdentity implements store.Store.DeleteIdentity by recording an
+// IdentityDeleted event after the identity has been removed.
+func (s *notifyStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	if !s.wanted(params.IdentityDeleted) {
+		return s.Store.DeleteIdentity(ctx, identity)
+	}
+	deleted := store.Identity{
+		ID:         identity.ID,
+		ProviderID: identity.ProviderID,
+		Username:   identity.Username,
+	}
+	if err := s.Store.Identity(ctx, &deleted); err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+	}
+	if err := s.Store.DeleteIdentity(ctx, identity); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	deleted.Groups = nil
+	s.notify(ctx, params.IdentityDeleted, &deleted)
+	return nil
+}
+
+// AddGroup implements store.Store.AddGroup by recording an
+// IdentityGroupsChanged event for each identity that becomes a member of
+// the new group through its member groups.
+func (s *notifyStore) AddGroup(ctx context.Context, group *store.Group) error {
+	if !s.wanted(params.IdentityGroupsChanged) || len(group.MemberGroups) == 0 {
+		return s.Store.AddGroup(ctx, group)
+	}
+	groups, err := s.Store.FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.Store.AddGroup(ctx, group); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	s.notifyGroupMembers(ctx, groups, group.MemberGroups)
+	return nil
+}
+
+// UpdateGroup implements store.Store.UpdateGroup by recording an
+// IdentityGroupsChanged event for each identity that is a member of
+// any of the member groups added to or removed from the group.
+//
+// As with UpdateIdentity the affected member groups are determined
+// from the update and the member groups the group had beforehand, so
+// events may be recorded for identities whose groups are unchanged.
+func (s *notifyStore) UpdateGroup(ctx context.Context, group *store.Group, update store.GroupUpdate) error {
+	if !s.wanted(params.IdentityGroupsChanged) || update[store.GroupMemberGroups] == store.NoUpdate {
+		return s.Store.UpdateGroup(ctx, group, update)
+	}
+	groups, err := s.Store.FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.Store.UpdateGroup(ctx, group, update); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	changed := append([]string(nil), group.MemberGroups...)
+	for _, g := range groups {
+		if g.Name == group.Name {
+			changed = append(changed, g.MemberGroups...)
+		}
+	}
+	s.notifyGroupMembers(ctx, groups, changed)
+	return nil
+}
+
+// RemoveGroup implements store.Store.RemoveGroup by recording an
+// IdentityGroupsChanged event for each identity that was a member of
+// the removed group.
+func (s *notifyStore) RemoveGroup(ctx context.Context, name string) error {
+	if !s.wanted(params.IdentityGroupsChanged) {
+		return s.Store.RemoveGroup(ctx, name)
+	}
+	groups, err := s.Store.FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.Store.RemoveGroup(ctx, name); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	s.notifyGroupMembers(ctx, groups, []string{name})
+	return nil
+}
+
+// UpdateElevation implements store.Store.UpdateElevation by recording
+// an IdentityGroupsChanged event for the user of an elevation that is
+// approved or revoked.
+func (s *notifyStore) UpdateElevation(ctx context.Context, e *store.Elevation, from store.ElevationState) error {
+	if err := s.Store.UpdateElevation(ctx, e, from); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if !s.wanted(params.IdentityGroupsChanged) {
+		return nil
+	}
+	if e.State != store.ElevationApproved && e.State != store.ElevationRevoked {
+		return nil
+	}
+	e1 := store.Elevation{ID: e.ID}
+	if err := s.Store.Elevation(ctx, &e1); err != nil {
+		logger.Errorf("cannot read updated elevation %s: %s", e.ID, err)
+		return nil
+	}
+	identity := store.Identity{Username: e1.Username}
+	if err := s.Store.Identity(ctx, &identity); err != nil {
+		logger.Errorf("cannot read identity %s: %s", e1.Username, err)
+		return nil
+	}
+	s.notify(ctx, params.IdentityGroupsChanged, &identity)
+	return nil
+}
+
+// notifyGroupMembers records an IdentityGroupsChanged event for each
+// identity that is a member of any of the given groups, either
+// directly or through the member groups in the given stored groups.
+func (s *notifyStore) notifyGroupMembers(ctx context.Context, groups []store.Group, names []string) {
+	memberGroups := make(map[string][]string)
+	for _, g := range groups {
+		memberGroups[g.Name] = g.MemberGroups
+	}
+	seen := make(map[string]bool)
+	var expanded []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			expanded = append(expanded, name)
+		}
+	}
+	for i := 0; i < len(expanded); i++ {
+		for _, m := range memberGroups[expanded[i]] {
+			if !seen[m] {
+				seen[m] = true
+				expanded = append(expanded, m)
+			}
+		}
+	}
+	notified := make(map[store.ProviderIdentity]bool)
+	for _, g := range expanded {
+		identities, err := s.Store.FindIdentities(ctx, &store.Identity{
+			Groups: []string{g},
+		}, store.Filter{
+			store.Groups: store.Equal,
+		}, nil, 0, 0)
+		if err != nil {
+			logger.Errorf("cannot find members of group %s: %s", g, err)
+			continue
+		}
+		for i := range identities {
+			if notified[identities[i].ProviderID] {
+				continue
+			}
+			notified[identities[i].ProviderID] = true
+			s.notify(ctx, params.IdentityGroupsChanged, &identities[i])
+		}
+	}
+}
+
+// mayNotify reports whether the given update could cause an event that
+// one of the webhooks wants.
+func (s *notifyStore) mayNotify(identity *store.Identity, update store.Update) bool {
+	if mayCreate(identity, update) && s.wanted(params.IdentityCreated) {
+		return true
+	}
+	if (update[store.Groups] != store.NoUpdate || update[store.GroupExpiry] != store.NoUpdate) && s.wanted(params.IdentityGroupsChanged) {
+		return true
+	}
+	if updatesSSHKeys(identity, update) && s.wanted(params.IdentitySSHKeysChanged) {
+		return true
+	}
+	return false
+}
+
+// mayCreate reports whether the given update might create a new
+// identity.
+func mayCreate(identity *store.Identity, update store.Update) bool {
+	return identity.ID == "" && identity.ProviderID != "" && update[store.Username] != store.NoUpdate
+}
+
+// updatesSSHKeys reports whether the given update changes the SSH keys
+// of the identity.
+func updatesSSHKeys(identity *store.Identity, update store.Update) bool {
+	if update[store.ExtraInfo] == store.NoUpdate {
+		return false
+	}
+	_, ok := identity.ExtraInfo[sshKeysKey]
+	return ok
+}
+
+// wanted reports whether any of the webhooks want events of the given
+// type.
+func (s *notifyStore) wanted(t params.IdentityEventType) bool {
+	for _, w := range s.hooks {
+		if w.Wants(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify adds an event of the given type for the given identity to the
+// outbox of every webhook that wants it. The change that caused the
+// event has already been made, so failures are logged rather than
+// returned.
+func (s *notifyStore) notify(ctx context.Context, t params.IdentityEventType, identity *store.Identity) {
+	now := s.now()
+	id, err := newEventID()
+	if err != nil {
+		logger.Errorf("cannot create %s event for %s: %s", t, identity.Username, err)
+		return
+	}
+	payload, err := json.Marshal(params.IdentityEvent{
+		ID:         id,
+		Time:       now,
+		Type:       t,
+		User:       params.Username(identity.Username),
+		ProviderID: string(identity.ProviderID),
+		Groups:     identity.Groups,
+	})
+	if err != nil {
+		logger.Errorf("cannot create %s event for %s: %s", t, identity.Username, err)
+		return
+	}
+	var entries []store.OutboxEntry
+	for _, w := range s.hooks {
+		if !w.Wants(t) {
+			continue
+		}
+		entries = append(entries, store.OutboxEntry{
+			Webhook:     w.Name,
+			Payload:     payload,
+			Created:     now,
+			NextAttempt: now,
+		})
+	}
+	if err := s.Store.AddOutboxEntries(ctx, entries); err != nil {
+		logger.Errorf("cannot record %s event for %s: %s", t, identity.Username, err)
+	}
+}
+
+// newEventID returns a new random event ID.
+func newEventID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
@@ -0,0 +1,355 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package reaper periodically acts on identities that have not been
+// used for a long time and removes agents whose owner no longer
+// exists.
+package reaper
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/juju/loggo"
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.internal.reaper")
+
+// The following constants define the actions that can be taken on an
+// inactive identity.
+const (
+	// ActionFlag reports the identity in the audit log and the
+	// reaper metrics, and records that it has been flagged in its
+	// ExtraInfo, but otherwise leaves it unchanged.
+	ActionFlag = "flag"
+
+	// ActionSuspend suspends the identity.
+	ActionSuspend = "suspend"
+
+	// ActionDelete deletes the identity along with any agents it
+	// owns.
+	ActionDelete = "delete"
+)
+
+// The following constants define the reasons that the reaper acts on
+// an identity.
+const (
+	// ReasonInactive is used when an identity has not logged in, or
+	// been discharged, for longer than the inactive period.
+	ReasonInactive = "inactive"
+
+	// ReasonOrphaned is used when an agent's owner no longer
+	// exists.
+	ReasonOrphaned = "orphaned"
+)
+
+// FlaggedKey is the ExtraInfo key that records the last active time of
+// an identity when it was flagged. An identity is not flagged again
+// until it has been active since.
+const FlaggedKey = "reaper-flagged"
+
+// Metrics represents a way to report metrics information about the
+// reaper. It must be callable concurrently.
+type Metrics interface {
+	// IdentityReaped is called every time the reaper successfully
+	// performs the given action on an identity for the given
+	// reason.
+	IdentityReaped(action, reason string)
+}
+
+// Params holds the parameters for a Reaper.
+type Params struct {
+	// Store holds the store containing the identities.
+	Store store.Store
+
+	// DeletionStore holds the key-value store in which deleted
+	// identities are recorded. It must be set if the reaper deletes
+	// identities.
+	DeletionStore simplekv.Store
+
+	// Audit, if not nil, is called to record audit events for each
+	// action taken by the reaper.
+	Audit func(context.Context, audit.Event)
+
+	// Metrics holds an object that's used to report reaper metrics.
+	// If it's nil, no metrics will be reported.
+	Metrics Metrics
+
+	// Interval holds the time between runs of the reaper. If this
+	// is zero then the reaper will not run in the background, but
+	// Plan can still be used to determine what it would do.
+	Interval time.Duration
+
+	// InactivePeriod holds the length of time after which an
+	// identity that has neither logged in nor been discharged is
+	// considered inactive. If this is zero inactive identities are
+	// ignored.
+	InactivePeriod time.Duration
+
+	// InactiveAction holds the action taken on inactive
+	// identities, it must be one of ActionFlag, ActionSuspend or
+	// ActionDelete. If it is empty ActionFlag is used.
+	InactiveAction string
+
+	// RemoveOrphanedAgents holds whether agents whose owner no
+	// longer exists are deleted.
+	RemoveOrphanedAgents bool
+}
+
+// A Reaper acts on inactive identities and orphaned agents.
+type Reaper struct {
+	tomb   tomb.Tomb
+	params Params
+}
+
+// An Entry describes an action taken, or that would be taken, by the
+// reaper.
+type Entry struct {
+	// Username holds the username of the identity.
+	Username string
+
+	// Action holds the action performed on the identity.
+	Action string
+
+	// Reason holds the reason the identity was selected.
+	Reason string
+
+	// LastActive holds the most recent time the identity either
+	// logged in or was discharged.
+	LastActive time.Time
+}
+
+// New creates a new Reaper. If the reaper has a non-zero interval and
+// anything to do then it is started in the background. The returned
+// Reaper must be closed when it is no longer required.
+func New(p Params) (*Reaper, error) {
+	switch p.InactiveAction {
+	case "":
+		p.InactiveAction = ActionFlag
+	case ActionFlag, ActionSuspend, ActionDelete:
+	default:
+		return nil, errgo.Newf("invalid inactive identity action %q", p.InactiveAction)
+	}
+	if p.Audit == nil {
+		p.Audit = func(context.Context, audit.Event) {}
+	}
+	if p.Metrics == nil {
+		p.Metrics = noMetrics{}
+	}
+	r := &Reaper{
+		params: p,
+	}
+	r.tomb.Go(r.loop)
+	return r, nil
+}
+
+// Close stops the reaper.
+func (r *Reaper) Close() {
+	r.tomb.Kill(nil)
+	r.tomb.Wait()
+}
+
+// loop runs the reaper at regular intervals until it is stopped.
+func (r *Reaper) loop() error {
+	if r.params.Interval <= 0 || (r.params.InactivePeriod == 0 && !r.params.RemoveOrphanedAgents) {
+		return nil
+	}
+	for {
+		ctx, close := r.params.Store.Context(context.Background())
+		if _, err := r.Run(ctx, time.Now()); err != nil {
+			logger.Errorf("reaper: %v", err)
+		}
+		close()
+		select {
+		case <-time.After(r.params.Interval):
+		case <-r.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// Plan returns the actions the reaper would take at the given time
+// without performing any of them.
+func (r *Reaper) Plan(ctx context.Context, now time.Time) ([]Entry, error) {
+	if r.params.InactivePeriod == 0 && !r.params.RemoveOrphanedAgents {
+		return nil, nil
+	}
+	identities, err := r.params.Store.FindIdentities(ctx, nil, store.Filter{}, []store.Sort{{Field: store.Username}}, 0, 0)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find identities")
+	}
+	var entries []Entry
+	deleted := make(map[store.ProviderIdentity]bool)
+	if r.params.InactivePeriod > 0 {
+		cutoff := now.Add(-r.params.InactivePeriod)
+		for _, identity := range identities {
+			if identity.ProviderID == auth.AdminProviderID {
+				continue
+			}
+			lastActive := lastActive(&identity)
+			if lastActive.IsZero() || !lastActive.Before(cutoff) {
+				// Identities that have never been used are
+				// left alone as there is no way to tell
+				// how long ago they were created.
+				continue
+			}
+			if identity.Suspended && r.params.InactiveAction != ActionDelete {
+				continue
+			}
+			if r.params.InactiveAction == ActionFlag && flagged(&identity, lastActive) {
+				continue
+			}
+			entries = append(entries, Entry{
+				Username:   identity.Username,
+				Action:     r.params.InactiveAction,
+				Reason:     ReasonInactive,
+				LastActive: lastActive,
+			})
+			if r.params.InactiveAction == ActionDelete {
+				deleted[identity.ProviderID] = true
+			}
+		}
+	}
+	if r.params.RemoveOrphanedAgents {
+		exists := make(map[store.ProviderIdentity]bool)
+		for _, identity := range identities {
+			exists[identity.ProviderID] = true
+		}
+		for _, identity := range identities {
+			if identity.Owner == "" || deleted[identity.ProviderID] {
+				continue
+			}
+			if exists[identity.Owner] && !deleted[identity.Owner] {
+				continue
+			}
+			entries = append(entries, Entry{
+				Username:   identity.Username,
+				Action:     ActionDelete,
+				Reason:     ReasonOrphaned,
+				LastActive: lastActive(&identity),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// Run performs a single pass of the reaper at the given time. It
+// returns the actions that were successfully performed.
+func (r *Reaper) Run(ctx context.Context, now time.Time) ([]Entry, error) {
+	entries, err := r.Plan(ctx, now)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// Groups removed by deleting identities are recorded in their
+	// group history.
+	ctx = store.ContextWithGroupChanges(ctx, "")
+	done := entries[:0]
+	for _, e := range entries {
+		if err := r.apply(ctx, e); err != nil {
+			logger.Errorf("cannot %s %s identity %s: %s", e.Action, e.Reason, e.Username, err)
+			continue
+		}
+		logger.Infof("%s %s identity %s", e.Action, e.Reason, e.Username)
+		r.params.Metrics.IdentityReaped(e.Action, e.Reason)
+		done = append(done, e)
+	}
+	return done, nil
+}
+
+// apply performs the action described by the given entry.
+func (r *Reaper) apply(ctx context.Context, e Entry) error {
+	if e.Action == ActionDelete {
+		return errgo.Mask(r.delete(ctx, e))
+	}
+	var err error
+	ev := audit.Event{
+		User: e.Username,
+	}
+	switch e.Action {
+	case ActionFlag:
+		ev.Type = audit.FlagUser
+		var buf []byte
+		if buf, err = json.Marshal(e.LastActive); err == nil {
+			err = r.params.Store.UpdateIdentity(ctx, &store.Identity{
+				Username: e.Username,
+				ExtraInfo: map[string][]string{
+					FlaggedKey: {string(buf)},
+				},
+			}, store.Update{
+				store.ExtraInfo: store.Set,
+			})
+		}
+	case ActionSuspend:
+		ev.Type = audit.SuspendUser
+		err = r.params.Store.UpdateIdentity(ctx, &store.Identity{
+			Username:  e.Username,
+			Suspended: true,
+		}, store.Update{
+			store.Suspended: store.Set,
+		})
+	default:
+		return errgo.Newf("unknown action %q", e.Action)
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	r.params.Audit(ctx, ev)
+	return errgo.Mask(err)
+}
+
+// delete deletes the identity described by the given entry, along with
+// any agents it owns. An identity that has already been deleted along
+// with its owner is ignored.
+func (r *Reaper) delete(ctx context.Context, e Entry) error {
+	identity := store.Identity{
+		Username: e.Username,
+	}
+	if err := r.params.Store.Identity(ctx, &identity); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return nil
+		}
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(deletion.Delete(ctx, deletion.Params{
+		Store:         r.params.Store,
+		KeyValueStore: r.params.DeletionStore,
+		Audit:         r.params.Audit,
+	}, &identity))
+}
+
+// lastActive returns the most recent time the given identity either
+// logged in or was discharged.
+func lastActive(identity *store.Identity) time.Time {
+	if identity.LastDischarge.After(identity.LastLogin) {
+		return identity.LastDischarge
+	}
+	return identity.LastLogin
+}
+
+// flagged reports whether the given identity has already been flagged
+// with the given last active time.
+func flagged(identity *store.Identity, lastActive time.Time) bool {
+	vs := identity.ExtraInfo[FlaggedKey]
+	if len(vs) == 0 {
+		return false
+	}
+	var t time.Time
+	if err := json.Unmarshal([]byte(vs[0]), &t); err != nil {
+		return false
+	}
+	return t.Equal(lastActive)
+}
+
+// noMetrics implements Metrics by doing nothing.
+type noMetrics struct{}
+
+func (noMetrics) IdentityReaped(action, reason string) {}
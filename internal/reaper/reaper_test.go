@@ -0,0 +1,487 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package reaper_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/candidtest"
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/internal/reaper"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/memstore"
+)
+
+var now = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+const day = 24 * time.Hour
+
+// newStore creates a store containing the following identities:
+//
+//	active     logged in 10 days ago
+//	inactive   logged in 100 days ago
+//	discharged logged in 100 days ago, discharged 10 days ago
+//	suspended  logged in 100 days ago, suspended
+//	never      never logged in
+//	agent1     owned by active, never logged in
+//	agent2     owned by inactive, logged in 100 days ago
+//	orphan     owned by a deleted user, logged in 10 days ago
+//
+// and the admin identity, which has not logged in for 100 days.
+func newStore() store.Store {
+	ctx := context.Background()
+	st := memstore.NewStore()
+	for _, identity := range []store.Identity{{
+		ProviderID: auth.AdminProviderID,
+		Username:   auth.AdminUsername,
+		LastLogin:  now.Add(-100 * day),
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "active"),
+		Username:   "active",
+		LastLogin:  now.Add(-10 * day),
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "inactive"),
+		Username:   "inactive",
+		LastLogin:  now.Add(-100 * day),
+	}, {
+		ProviderID:    store.MakeProviderIdentity("test", "discharged"),
+		Username:      "discharged",
+		LastLogin:     now.Add(-100 * day),
+		LastDischarge: now.Add(-10 * day),
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "suspended"),
+		Username:   "suspended",
+		LastLogin:  now.Add(-100 * day),
+		Suspended:  true,
+	}, {
+		ProviderID: store.MakeProviderIdentity("test", "never"),
+		Username:   "never",
+	}, {
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+		Username:   "agent1@candid",
+		Owner:      store.MakeProviderIdentity("test", "active"),
+	}, {
+		ProviderID: store.MakeProviderIdentity("idm", "agent2"),
+		Username:   "agent2@candid",
+		Owner:      store.MakeProviderIdentity("test", "inactive"),
+		LastLogin:  now.Add(-100 * day),
+	}, {
+		ProviderID: store.MakeProviderIdentity("idm", "orphan"),
+		Username:   "orphan@candid",
+		Owner:      store.MakeProviderIdentity("test", "deleted"),
+		LastLogin:  now.Add(-10 * day),
+	}} {
+		identity := identity
+		candidtest.AddIdentity(ctx, st, &identity)
+	}
+	return st
+}
+
+var planTests = []struct {
+	about        string
+	params       reaper.Params
+	expectError  string
+	expectResult []reaper.Entry
+}{{
+	about: "nothing to do",
+}, {
+	about: "flag inactive",
+	params: reaper.Params{
+		InactivePeriod: 30 * day,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "agent2@candid",
+		Action:     reaper.ActionFlag,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "inactive",
+		Action:     reaper.ActionFlag,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}},
+}, {
+	about: "suspend inactive",
+	params: reaper.Params{
+		InactivePeriod: 30 * day,
+		InactiveAction: reaper.ActionSuspend,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "agent2@candid",
+		Action:     reaper.ActionSuspend,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "inactive",
+		Action:     reaper.ActionSuspend,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}},
+}, {
+	about: "delete inactive",
+	params: reaper.Params{
+		InactivePeriod: 30 * day,
+		InactiveAction: reaper.ActionDelete,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "agent2@candid",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "inactive",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "suspended",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}},
+}, {
+	about: "longer inactive period",
+	params: reaper.Params{
+		InactivePeriod: 200 * day,
+	},
+}, {
+	about: "orphaned agents",
+	params: reaper.Params{
+		RemoveOrphanedAgents: true,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "orphan@candid",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonOrphaned,
+		LastActive: now.Add(-10 * day),
+	}},
+}, {
+	about: "orphaned agents after deleting inactive",
+	params: reaper.Params{
+		InactivePeriod:       90 * day,
+		InactiveAction:       reaper.ActionDelete,
+		RemoveOrphanedAgents: true,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "agent2@candid",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "inactive",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "suspended",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "orphan@candid",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonOrphaned,
+		LastActive: now.Add(-10 * day),
+	}},
+}, {
+	about: "orphaned agents after suspending inactive",
+	params: reaper.Params{
+		InactivePeriod:       90 * day,
+		InactiveAction:       reaper.ActionSuspend,
+		RemoveOrphanedAgents: true,
+	},
+	expectResult: []reaper.Entry{{
+		Username:   "agent2@candid",
+		Action:     reaper.ActionSuspend,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "inactive",
+		Action:     reaper.ActionSuspend,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-100 * day),
+	}, {
+		Username:   "orphan@candid",
+		Action:     reaper.ActionDelete,
+		Reason:     reaper.ReasonOrphaned,
+		LastActive: now.Add(-10 * day),
+	}},
+}}
+
+func TestPlan(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	for _, test := range planTests {
+		c.Run(test.about, func(c *qt.C) {
+			st := newStore()
+			test.params.Store = st
+			r, err := reaper.New(test.params)
+			c.Assert(err, qt.IsNil)
+			defer r.Close()
+			entries, err := r.Plan(context.Background(), now)
+			c.Assert(err, qt.IsNil)
+			c.Assert(entries, qt.DeepEquals, test.expectResult)
+
+			// Check that nothing was changed.
+			identities, err := st.FindIdentities(context.Background(), nil, store.Filter{}, nil, 0, 0)
+			c.Assert(err, qt.IsNil)
+			c.Assert(identities, qt.HasLen, 9)
+			for _, identity := range identities {
+				c.Assert(identity.Suspended, qt.Equals, identity.Username == "suspended")
+			}
+		})
+	}
+}
+
+func TestNewInvalidAction(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	_, err := reaper.New(reaper.Params{
+		Store:          memstore.NewStore(),
+		InactiveAction: "nosuch",
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid inactive identity action "nosuch"`)
+}
+
+func TestRun(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := newStore()
+	var events []audit.Event
+	metrics := newTestMetrics()
+	r, err := reaper.New(reaper.Params{
+		Store:         st,
+		DeletionStore: newDeletionStore(c),
+		Audit: func(_ context.Context, e audit.Event) {
+			e.Time = time.Time{}
+			events = append(events, e)
+		},
+		Metrics:              metrics,
+		InactivePeriod:       90 * day,
+		InactiveAction:       reaper.ActionSuspend,
+		RemoveOrphanedAgents: true,
+	})
+	c.Assert(err, qt.IsNil)
+	defer r.Close()
+
+	entries, err := r.Run(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 3)
+
+	c.Assert(suspended(c, st, "inactive"), qt.Equals, true)
+	c.Assert(suspended(c, st, "agent2@candid"), qt.Equals, true)
+	c.Assert(suspended(c, st, "active"), qt.Equals, false)
+	err = st.Identity(ctx, &store.Identity{Username: "orphan@candid"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type: audit.SuspendUser,
+		User: "agent2@candid",
+	}, {
+		Type: audit.SuspendUser,
+		User: "inactive",
+	}, {
+		Type: audit.DeleteUser,
+		User: "orphan@candid",
+	}})
+	c.Assert(metrics.counts, qt.DeepEquals, map[[2]string]int{
+		{reaper.ActionSuspend, reaper.ReasonInactive}: 2,
+		{reaper.ActionDelete, reaper.ReasonOrphaned}:  1,
+	})
+
+	// A second run has nothing to do.
+	entries, err = r.Run(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 0)
+}
+
+func TestRunDelete(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	st := newStore()
+	// An active agent owned by an inactive user is deleted along
+	// with its owner.
+	candidtest.AddIdentity(ctx, st, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent3"),
+		Username:   "agent3@candid",
+		Owner:      store.MakeProviderIdentity("test", "inactive"),
+		LastLogin:  now.Add(-10 * day),
+	})
+	kv := newDeletionStore(c)
+	var events []audit.Event
+	metrics := newTestMetrics()
+	r, err := reaper.New(reaper.Params{
+		Store:         st,
+		DeletionStore: kv,
+		Audit: func(_ context.Context, e audit.Event) {
+			e.Time = time.Time{}
+			events = append(events, e)
+		},
+		Metrics:        metrics,
+		InactivePeriod: 90 * day,
+		InactiveAction: reaper.ActionDelete,
+	})
+	c.Assert(err, qt.IsNil)
+	defer r.Close()
+
+	entries, err := r.Run(ctx, now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 3)
+	for _, username := range []string{"agent2@candid", "agent3@candid", "inactive", "suspended"} {
+		err = st.Identity(ctx, &store.Identity{Username: username})
+		c.Check(errgo.Cause(err), qt.Equals, store.ErrNotFound, qt.Commentf("%s", username))
+	}
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type: audit.DeleteUser,
+		User: "agent2@candid",
+	}, {
+		Type: audit.DeleteUser,
+		User: "agent3@candid",
+	}, {
+		Type: audit.DeleteUser,
+		User: "inactive",
+	}, {
+		Type: audit.DeleteUser,
+		User: "suspended",
+	}})
+
+	// The tokens of deleted identities are revoked.
+	revoked, err := deletion.Revoked(ctx, kv, &store.Identity{Username: "agent3@candid"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(revoked.IsZero(), qt.IsFalse)
+}
+
+func TestRunFlag(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	st := newStore()
+	var events []audit.Event
+	r, err := reaper.New(reaper.Params{
+		Store: st,
+		Audit: func(_ context.Context, e audit.Event) {
+			events = append(events, e)
+		},
+		InactivePeriod: 90 * day,
+	})
+	c.Assert(err, qt.IsNil)
+	defer r.Close()
+
+	entries, err := r.Run(context.Background(), now)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(suspended(c, st, "inactive"), qt.Equals, false)
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type: audit.FlagUser,
+		User: "agent2@candid",
+	}, {
+		Type: audit.FlagUser,
+		User: "inactive",
+	}})
+
+	// Identities are not flagged again while they remain inactive.
+	events = nil
+	entries, err = r.Run(context.Background(), now.Add(day))
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 0)
+	c.Assert(events, qt.HasLen, 0)
+
+	// An identity that becomes active and then inactive again is
+	// flagged again.
+	err = st.UpdateIdentity(context.Background(), &store.Identity{
+		Username:  "inactive",
+		LastLogin: now.Add(-95 * day),
+	}, store.Update{
+		store.LastLogin: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	entries, err = r.Run(context.Background(), now.Add(day))
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.DeepEquals, []reaper.Entry{{
+		Username:   "inactive",
+		Action:     reaper.ActionFlag,
+		Reason:     reaper.ReasonInactive,
+		LastActive: now.Add(-95 * day),
+	}})
+	c.Assert(events, qt.DeepEquals, []audit.Event{{
+		Type: audit.FlagUser,
+		User: "inactive",
+	}})
+}
+
+func TestBackground(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	st := newStore()
+	metrics := newTestMetrics()
+	r, err := reaper.New(reaper.Params{
+		Store:                st,
+		DeletionStore:        newDeletionStore(c),
+		Metrics:              metrics,
+		Interval:             time.Hour,
+		RemoveOrphanedAgents: true,
+	})
+	c.Assert(err, qt.IsNil)
+	defer r.Close()
+
+	// The reaper runs as soon as it is started.
+	select {
+	case <-metrics.reaped:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("reaper did not run")
+	}
+	err = st.Identity(context.Background(), &store.Identity{Username: "orphan@candid"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func newDeletionStore(c *qt.C) simplekv.Store {
+	kv, err := memstore.NewProviderDataStore().KeyValueStore(context.Background(), deletion.KeyValueStore)
+	c.Assert(err, qt.IsNil)
+	return kv
+}
+
+func suspended(c *qt.C, st store.Store, username string) bool {
+	identity := store.Identity{
+		Username: username,
+	}
+	err := st.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	return identity.Suspended
+}
+
+type testMetrics struct {
+	mu     sync.Mutex
+	counts map[[2]string]int
+	reaped chan struct{}
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		counts: make(map[[2]string]int),
+		reaped: make(chan struct{}, 100),
+	}
+}
+
+func (m *testMetrics) IdentityReaped(action, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[[2]string{action, reason}]++
+	m.reaped <- struct{}{}
+}
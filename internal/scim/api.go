@@ -0,0 +1,389 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package scim serves a SCIM 2.0 (RFC 7643, RFC 7644) provisioning
+// endpoint that allows external systems, such as an HR system, to
+// manage the users and groups known to the identity server.
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/internal/auth/httpauth"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.internal.scim")
+
+const (
+	basePath    = "/scim/v2"
+	contentType = "application/scim+json"
+
+	userSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	serviceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	resourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	listResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	patchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	errorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// maxResults holds the maximum number of resources that will be
+	// returned in a single list response.
+	maxResults = 1000
+
+	// maxBodySize holds the maximum size of a request body.
+	maxBodySize = 1 << 20
+)
+
+// The following error codes are used as the causes of errors that are
+// reported with the corresponding SCIM error type (RFC 7644 section
+// 3.12).
+const (
+	errInvalidFilter params.ErrorCode = "invalidFilter"
+	errInvalidSyntax params.ErrorCode = "invalidSyntax"
+	errInvalidPath   params.ErrorCode = "invalidPath"
+	errInvalidValue  params.ErrorCode = "invalidValue"
+	errMutability    params.ErrorCode = "mutability"
+	errUniqueness    params.ErrorCode = "uniqueness"
+)
+
+// NewAPIHandler is an identity.NewAPIHandlerFunc.
+func NewAPIHandler(params identity.HandlerParams) ([]httprequest.Handler, error) {
+	h := &handler{
+		params:  params,
+		reqAuth: httpauth.New(params.Oven, params.Authorizer, params.APIMacaroonTimeout),
+	}
+	return []httprequest.Handler{
+		identity.ReqServer.Handle(h.serviceProviderConfig),
+		identity.ReqServer.Handle(h.resourceTypes),
+		identity.ReqServer.Handle(h.listUsers),
+		identity.ReqServer.Handle(h.createUser),
+		identity.ReqServer.Handle(h.getUser),
+		identity.ReqServer.Handle(h.replaceUser),
+		identity.ReqServer.Handle(h.patchUser),
+		identity.ReqServer.Handle(h.deleteUser),
+		identity.ReqServer.Handle(h.listGroups),
+		identity.ReqServer.Handle(h.createGroup),
+		identity.ReqServer.Handle(h.getGroup),
+		identity.ReqServer.Handle(h.replaceGroup),
+		identity.ReqServer.Handle(h.patchGroup),
+		identity.ReqServer.Handle(h.deleteGroup),
+	}, nil
+}
+
+// A handler serves the SCIM endpoints. The SCIM endpoints do not use the
+// usual JSON request and response handling as SCIM uses its own media
+// type and error format.
+type handler struct {
+	params  identity.HandlerParams
+	reqAuth *httpauth.Authorizer
+}
+
+// serve authorizes the request in p and then calls f to perform it. If
+// f succeeds the returned value is written as the response body with
+// the returned status code.
+func (h *handler) serve(p httprequest.Params, f func(ctx context.Context) (int, interface{}, error)) {
+	ctx, close := h.params.Store.Context(p.Context)
+	defer close()
+	authInfo, err := h.reqAuth.Auth(ctx, p.Request, auth.GlobalOp(auth.ActionProvision))
+	if err != nil {
+		if _, ok := errgo.Cause(err).(*httpbakery.Error); ok {
+			// Allow bakery clients to discharge any required
+			// macaroons in the usual way.
+			identity.WriteError(ctx, p.Response, err)
+			return
+		}
+		writeError(p.Response, err)
+		return
+	}
+	if authInfo.Identity != nil {
+		ctx = contextWithActor(ctx, authInfo.Identity.Id())
+		ctx = store.ContextWithGroupChanges(ctx, authInfo.Identity.Id())
+	}
+	status, v, err := f(ctx)
+	if err != nil {
+		writeError(p.Response, err)
+		return
+	}
+	writeResponse(p.Response, status, v)
+}
+
+type actorKey struct{}
+
+func contextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// auditEvent records the given event, performed by the authenticated
+// provisioning client, in the audit log. If err is not nil the event is
+// recorded as having failed.
+func (h *handler) auditEvent(ctx context.Context, e audit.Event, err error) {
+	e.Actor, _ = ctx.Value(actorKey{}).(string)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	h.params.Audit(ctx, e)
+}
+
+// meta holds the resource metadata returned with every resource.
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// listResponse is the response to a query for resources.
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// newListResponse creates the response containing the requested page
+// of the given resources. The startIndex is 1-based, as defined in RFC
+// 7644 section 3.4.2.4. A negative count requests as many resources as
+// are allowed in a single response.
+func newListResponse(resources []interface{}, startIndex, count int) *listResponse {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 || count > maxResults {
+		count = maxResults
+	}
+	resp := &listResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+	if startIndex <= len(resources) {
+		resources = resources[startIndex-1:]
+		if count < len(resources) {
+			resources = resources[:count]
+		}
+		resp.Resources = resources
+	}
+	resp.ItemsPerPage = len(resp.Resources)
+	return resp
+}
+
+// listParams holds the query parameters common to all list requests.
+type listParams struct {
+	Filter     string `httprequest:"filter,form"`
+	StartIndex int    `httprequest:"startIndex,form"`
+	Count      string `httprequest:"count,form"`
+}
+
+// count returns the maximum number of resources requested, or -1 if no
+// limit was requested.
+func (p listParams) count() (int, error) {
+	if p.Count == "" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(p.Count)
+	if err != nil {
+		return 0, errgo.WithCausef(nil, errInvalidValue, "invalid count %q", p.Count)
+	}
+	if n < 0 {
+		// RFC 7644 section 3.4.2.4 specifies that negative
+		// values are interpreted as 0.
+		return 0, nil
+	}
+	return n, nil
+}
+
+// patchOp holds a SCIM PATCH request as defined in RFC 7644 section
+// 3.5.2.
+type patchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []operation `json:"Operations"`
+}
+
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// decodeBody decodes the JSON request body in req into v.
+func decodeBody(req *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(req.Body, maxBodySize))
+	if err := dec.Decode(v); err != nil {
+		return errgo.WithCausef(err, errInvalidSyntax, "cannot unmarshal request body")
+	}
+	return nil
+}
+
+// writeResponse writes v as the body of a SCIM response with the given
+// status. If v is nil then no body is written.
+func writeResponse(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, errgo.Notef(err, "cannot marshal response"))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// scimError is the body of a SCIM error response, as defined in RFC 7644
+// section 3.12.
+type scimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// writeError writes the given error as a SCIM error response.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var scimType string
+	switch code := errgo.Cause(err); code {
+	case errInvalidFilter, errInvalidSyntax, errInvalidPath, errInvalidValue, errMutability:
+		status = http.StatusBadRequest
+		scimType = string(code.(params.ErrorCode))
+	case errUniqueness:
+		status = http.StatusConflict
+		scimType = string(errUniqueness)
+	case params.ErrBadRequest:
+		status = http.StatusBadRequest
+	case params.ErrNotFound:
+		status = http.StatusNotFound
+	case params.ErrUnauthorized, params.ErrNoAdminCredsProvided:
+		status = http.StatusUnauthorized
+	case params.ErrForbidden:
+		status = http.StatusForbidden
+	}
+	if status == http.StatusInternalServerError {
+		logger.Errorf("Internal Server Error: %s (%s)", err, errgo.Details(err))
+	}
+	logger.Debugf("SCIM error response: %d (%s) %s", status, http.StatusText(status), err)
+	buf, _ := json.Marshal(scimError{
+		Schemas:  []string{errorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   err.Error(),
+	})
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+type serviceProviderConfigRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/ServiceProviderConfig"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupported struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type serviceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupported          `json:"bulk"`
+	Filter                filterSupported        `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  meta                   `json:"meta"`
+}
+
+// serviceProviderConfig serves the service provider configuration
+// defined in RFC 7643 section 5.
+func (h *handler) serviceProviderConfig(p httprequest.Params, req *serviceProviderConfigRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		return http.StatusOK, &serviceProviderConfig{
+			Schemas: []string{serviceProviderConfigSchema},
+			Patch:   supported{true},
+			Filter: filterSupported{
+				Supported:  true,
+				MaxResults: maxResults,
+			},
+			AuthenticationSchemes: []authenticationScheme{{
+				Type:        "httpbasic",
+				Name:        "HTTP Basic",
+				Description: "Authentication using the admin credentials.",
+			}},
+			Meta: meta{
+				ResourceType: "ServiceProviderConfig",
+				Location:     h.params.Location + basePath + "/ServiceProviderConfig",
+			},
+		}, nil
+	})
+}
+
+type resourceTypesRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/ResourceTypes"`
+}
+
+type resourceType struct {
+	Schemas  []string `json:"schemas"`
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     meta     `json:"meta"`
+}
+
+// resourceTypes serves the supported resource types defined in RFC 7643
+// section 6.
+func (h *handler) resourceTypes(p httprequest.Params, req *resourceTypesRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var resources []interface{}
+		for _, rt := range []struct{ name, endpoint, schema string }{
+			{"User", "/Users", userSchema},
+			{"Group", "/Groups", groupSchema},
+		} {
+			resources = append(resources, &resourceType{
+				Schemas:  []string{resourceTypeSchema},
+				ID:       rt.name,
+				Name:     rt.name,
+				Endpoint: rt.endpoint,
+				Schema:   rt.schema,
+				Meta: meta{
+					ResourceType: "ResourceType",
+					Location:     h.params.Location + basePath + "/ResourceTypes/" + rt.name,
+				},
+			})
+		}
+		return http.StatusOK, newListResponse(resources, 1, -1), nil
+	})
+}
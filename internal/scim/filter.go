@@ -0,0 +1,186 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+)
+
+// An attribute describes an attribute of a SCIM resource that may be
+// used in a filter.
+type attribute struct {
+	// caseExact holds whether values of the attribute are compared
+	// case-sensitively.
+	caseExact bool
+
+	// values returns the values of the attribute in the given
+	// resource.
+	values func(resource interface{}) []string
+}
+
+// A filter holds a parsed SCIM filter expression (RFC 7644 section
+// 3.4.2.2). Only comparisons of simple attributes, optionally joined
+// with "and", are supported.
+type filter []comparison
+
+type comparison struct {
+	name  string
+	attr  attribute
+	op    string
+	value string
+}
+
+// parseFilter parses the given filter expression, the attributes in the
+// filter are resolved with the given attributes, which must be keyed by
+// the lower-case attribute name. A nil filter, which matches every
+// resource, is returned if s is empty.
+func parseFilter(s string, attrs map[string]attribute) (filter, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(errInvalidFilter))
+	}
+	var f filter
+	for len(toks) > 0 {
+		if len(f) > 0 {
+			if toks[0].quoted || !strings.EqualFold(toks[0].text, "and") {
+				return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported filter expression %q", toks[0].text)
+			}
+			toks = toks[1:]
+		}
+		if len(toks) < 2 || toks[0].quoted || toks[1].quoted {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid filter %q", s)
+		}
+		name := strings.ToLower(toks[0].text)
+		attr, ok := attrs[name]
+		if !ok {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported filter attribute %q", toks[0].text)
+		}
+		c := comparison{
+			name: name,
+			attr: attr,
+			op:   strings.ToLower(toks[1].text),
+		}
+		toks = toks[2:]
+		switch c.op {
+		case "pr":
+		case "eq", "ne", "co", "sw", "ew":
+			if len(toks) == 0 {
+				return nil, errgo.WithCausef(nil, errInvalidFilter, "missing value in filter %q", s)
+			}
+			c.value = toks[0].text
+			if !toks[0].quoted {
+				c.value = strings.ToLower(c.value)
+				if c.value != "true" && c.value != "false" && c.value != "null" {
+					return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid value %q in filter", toks[0].text)
+				}
+			}
+			toks = toks[1:]
+		default:
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unsupported filter operator %q", c.op)
+		}
+		f = append(f, c)
+	}
+	return f, nil
+}
+
+// match reports whether the given resource matches the filter.
+func (f filter) match(resource interface{}) bool {
+	for _, c := range f {
+		if !c.match(resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal returns the value that the attribute with the given lower-case
+// name must equal for a resource to match the filter. If the filter
+// has no such comparison then false is returned.
+func (f filter) equal(name string) (string, bool) {
+	for _, c := range f {
+		if c.name == name && c.op == "eq" {
+			return c.value, true
+		}
+	}
+	return "", false
+}
+
+func (c comparison) match(resource interface{}) bool {
+	values := c.attr.values(resource)
+	if c.op == "pr" {
+		return len(values) > 0
+	}
+	if c.op == "ne" {
+		return !comparison{c.name, c.attr, "eq", c.value}.match(resource)
+	}
+	want := c.value
+	if !c.attr.caseExact {
+		want = strings.ToLower(want)
+	}
+	for _, v := range values {
+		if !c.attr.caseExact {
+			v = strings.ToLower(v)
+		}
+		var ok bool
+		switch c.op {
+		case "eq":
+			ok = v == want
+		case "co":
+			ok = strings.Contains(v, want)
+		case "sw":
+			ok = strings.HasPrefix(v, want)
+		case "ew":
+			ok = strings.HasSuffix(v, want)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits a filter expression into words and quoted strings.
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return toks, nil
+		}
+		if s[0] != '"' {
+			n := strings.IndexFunc(s, unicode.IsSpace)
+			if n == -1 {
+				n = len(s)
+			}
+			toks = append(toks, token{text: s[:n]})
+			s = s[n:]
+			continue
+		}
+		// Find the end of the JSON string.
+		n := 1
+		for ; n < len(s) && s[n] != '"'; n++ {
+			if s[n] == '\\' {
+				n++
+			}
+		}
+		if n >= len(s) {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "unterminated string in filter")
+		}
+		var text string
+		if err := json.Unmarshal([]byte(s[:n+1]), &text); err != nil {
+			return nil, errgo.WithCausef(nil, errInvalidFilter, "invalid string %s in filter", s[:n+1])
+		}
+		toks = append(toks, token{text: text, quoted: true})
+		s = s[n+1:]
+	}
+}
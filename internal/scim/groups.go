@@ -0,0 +1,510 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// group is a SCIM Group resource (RFC 7643 section 4.2). Each Group
+// resource is a group in the store, identified by its name. Membership
+// of a group is stored in the Groups of each member identity.
+type group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []member `json:"members,omitempty"`
+	Meta        *meta    `json:"meta,omitempty"`
+}
+
+type member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// groupAttributes holds the attributes of a group that may be used in a
+// filter.
+var groupAttributes = map[string]attribute{
+	"id": {
+		caseExact: true,
+		values: func(r interface{}) []string {
+			return []string{r.(*group).ID}
+		},
+	},
+	"displayname": {
+		values: func(r interface{}) []string {
+			return []string{r.(*group).DisplayName}
+		},
+	},
+	"members": {
+		caseExact: true,
+		values:    memberValues,
+	},
+	"members.value": {
+		caseExact: true,
+		values:    memberValues,
+	},
+}
+
+func memberValues(r interface{}) []string {
+	var values []string
+	for _, m := range r.(*group).Members {
+		values = append(values, m.Value)
+	}
+	return values
+}
+
+// memberAttributes holds the attributes of a group member that may be
+// used in a value filter in a PATCH path.
+var memberAttributes = map[string]attribute{
+	"value": {
+		caseExact: true,
+		values: func(r interface{}) []string {
+			return []string{r.(member).Value}
+		},
+	},
+	"display": {
+		values: func(r interface{}) []string {
+			return nonEmpty(r.(member).Display)
+		},
+	},
+}
+
+// groupFromMembers creates the Group resource for the group with the
+// given name and members.
+func (h *handler) groupFromMembers(name string, members []*store.Identity) *group {
+	g := &group{
+		Schemas:     []string{groupSchema},
+		ID:          name,
+		DisplayName: name,
+		Meta: &meta{
+			ResourceType: "Group",
+			Location:     h.params.Location + basePath + "/Groups/" + url.PathEscape(name),
+		},
+	}
+	for _, m := range members {
+		g.Members = append(g.Members, member{
+			Value:   m.ID,
+			Display: m.Username,
+			Ref:     h.params.Location + basePath + "/Users/" + url.PathEscape(m.ID),
+			Type:    "User",
+		})
+	}
+	return g
+}
+
+// group returns the members of the group with the given name.
+func (h *handler) group(ctx context.Context, name string) ([]*store.Identity, error) {
+	if err := h.params.Store.Group(ctx, &store.Group{Name: name}); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "group %q not found", name)
+		}
+		return nil, errgo.Mask(err)
+	}
+	return h.groupMembers(ctx, name)
+}
+
+// groupMembers returns the provisionable identities that are members
+// of the group with the given name.
+func (h *handler) groupMembers(ctx context.Context, name string) ([]*store.Identity, error) {
+	identities, err := h.params.Store.FindIdentities(ctx, &store.Identity{
+		Groups: []string{name},
+	}, store.Filter{
+		store.Groups: store.Equal,
+	}, []store.Sort{{Field: store.Username}}, 0, 0)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var members []*store.Identity
+	for i := range identities {
+		if provisionable(&identities[i]) {
+			members = append(members, &identities[i])
+		}
+	}
+	return members, nil
+}
+
+// checkGroupName checks that the given name is valid for a group.
+// Group names may not be empty or contain white space as they are used
+// in space separated lists.
+func checkGroupName(name string) error {
+	if name == "" {
+		return errgo.WithCausef(nil, errInvalidValue, "displayName not specified")
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) != -1 {
+		return errgo.WithCausef(nil, errInvalidValue, "invalid group name %q", name)
+	}
+	return nil
+}
+
+// members retrieves the identities referred to by the given group
+// members.
+func (h *handler) members(ctx context.Context, ms []member) ([]*store.Identity, error) {
+	var identities []*store.Identity
+	seen := make(map[string]bool)
+	for _, m := range ms {
+		if seen[m.Value] {
+			continue
+		}
+		seen[m.Value] = true
+		identity, err := h.identity(ctx, m.Value)
+		if errgo.Cause(err) == params.ErrNotFound {
+			return nil, errgo.WithCausef(nil, errInvalidValue, "unknown member %q", m.Value)
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+// updateMembers changes the members of the group with the given name
+// from oldMembers to newMembers.
+func (h *handler) updateMembers(ctx context.Context, name string, oldMembers, newMembers []*store.Identity) error {
+	current := make(map[string]bool)
+	for _, m := range oldMembers {
+		current[m.ID] = true
+	}
+	for _, m := range newMembers {
+		if current[m.ID] {
+			delete(current, m.ID)
+			continue
+		}
+		if err := h.modifyGroups(ctx, m, name, store.Push); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	for _, m := range oldMembers {
+		if !current[m.ID] {
+			continue
+		}
+		if err := h.modifyGroups(ctx, m, name, store.Pull); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// modifyGroups adds (store.Push) or removes (store.Pull) the given
+// identity to or from the given group.
+func (h *handler) modifyGroups(ctx context.Context, identity *store.Identity, group string, op store.Operation) error {
+	err := h.params.Store.UpdateIdentity(ctx, &store.Identity{
+		ID:          identity.ID,
+		Groups:      []string{group},
+		GroupExpiry: map[string]time.Time{group: {}},
+	}, store.Update{
+		store.Groups: op,
+		// Membership added this way does not expire.
+		store.GroupExpiry: store.Clear,
+	})
+	e := audit.Event{
+		Type: audit.ModifyGroups,
+		User: identity.Username,
+	}
+	if op == store.Push {
+		e.Add = []string{group}
+	} else {
+		e.Remove = []string{group}
+	}
+	h.auditEvent(ctx, e, err)
+	return errgo.Mask(err)
+}
+
+type listGroupsRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Groups"`
+	listParams
+}
+
+// listGroups serves a query for groups, as defined in RFC 7644 section
+// 3.4.2.
+func (h *handler) listGroups(p httprequest.Params, req *listGroupsRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		f, err := parseFilter(req.Filter, groupAttributes)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidFilter))
+		}
+		count, err := req.count()
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		groups, err := h.params.Store.FindGroups(ctx)
+		if err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		var resources []interface{}
+		for _, sg := range groups {
+			members, err := h.groupMembers(ctx, sg.Name)
+			if err != nil {
+				return 0, nil, errgo.Mask(err)
+			}
+			if g := h.groupFromMembers(sg.Name, members); f.match(g) {
+				resources = append(resources, g)
+			}
+		}
+		return http.StatusOK, newListResponse(resources, req.StartIndex, count), nil
+	})
+}
+
+type createGroupRequest struct {
+	httprequest.Route `httprequest:"POST /scim/v2/Groups"`
+}
+
+// createGroup serves a request to create a group, as defined in RFC 7644
+// section 3.3.
+func (h *handler) createGroup(p httprequest.Params, req *createGroupRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var g group
+		if err := decodeBody(p.Request, &g); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		if err := checkGroupName(g.DisplayName); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		members, err := h.members(ctx, g.Members)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		err = h.params.Store.AddGroup(ctx, &store.Group{Name: g.DisplayName})
+		h.auditEvent(ctx, audit.Event{
+			Type:  audit.CreateGroup,
+			Group: g.DisplayName,
+		}, err)
+		if errgo.Cause(err) == store.ErrDuplicateGroup {
+			return 0, nil, errgo.WithCausef(nil, errUniqueness, "group %q already exists", g.DisplayName)
+		}
+		if err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		if err := h.updateMembers(ctx, g.DisplayName, nil, members); err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		return http.StatusCreated, h.groupFromMembers(g.DisplayName, members), nil
+	})
+}
+
+type getGroupRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// getGroup serves a request for a single group.
+func (h *handler) getGroup(p httprequest.Params, req *getGroupRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		members, err := h.group(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return http.StatusOK, h.groupFromMembers(req.ID, members), nil
+	})
+}
+
+type replaceGroupRequest struct {
+	httprequest.Route `httprequest:"PUT /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// replaceGroup serves a request to replace the members of a group, as defined in RFC 7644 section 3.5.1.
+func (h *handler) replaceGroup(p httprequest.Params, req *replaceGroupRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var g group
+		if err := decodeBody(p.Request, &g); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		return h.changeGroup(ctx, req.ID, func(current *group) error {
+			*current = g
+			return nil
+		})
+	})
+}
+
+type patchGroupRequest struct {
+	httprequest.Route `httprequest:"PATCH /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// patchGroup serves a request to modify the members of a group,
+// as defined in RFC 7644 section 3.5.2.
+func (h *handler) patchGroup(p httprequest.Params, req *patchGroupRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var op patchOp
+		if err := decodeBody(p.Request, &op); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		return h.changeGroup(ctx, req.ID, func(g *group) error {
+			for _, o := range op.Operations {
+				if err := patchGroup(g, o); err != nil {
+					return errgo.Mask(err, errgo.Any)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// changeGroup updates the members of the group with the given name. The Group resource
+// for the current state of the group is passed to change, which should
+// modify it to hold the required state.
+func (h *handler) changeGroup(ctx context.Context, name string, change func(*group) error) (int, interface{}, error) {
+	oldMembers, err := h.group(ctx, name)
+	if err != nil {
+		return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	g := h.groupFromMembers(name, oldMembers)
+	if err := change(g); err != nil {
+		return 0, nil, errgo.Mask(err, errgo.Any)
+	}
+	if g.DisplayName == "" {
+		return 0, nil, errgo.WithCausef(nil, errInvalidValue, "displayName not specified")
+	}
+	if g.DisplayName != name {
+		// The name of a group is also its id, which cannot
+		// change.
+		return 0, nil, errgo.WithCausef(nil, errMutability, "displayName cannot be changed")
+	}
+	newMembers, err := h.members(ctx, g.Members)
+	if err != nil {
+		return 0, nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	if err := h.updateMembers(ctx, name, oldMembers, newMembers); err != nil {
+		return 0, nil, errgo.Mask(err)
+	}
+	return http.StatusOK, h.groupFromMembers(name, newMembers), nil
+}
+
+type deleteGroupRequest struct {
+	httprequest.Route `httprequest:"DELETE /scim/v2/Groups/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// deleteGroup serves a request to delete a group. All members are
+// removed from the group before it is deleted.
+func (h *handler) deleteGroup(p httprequest.Params, req *deleteGroupRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		members, err := h.group(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		for _, m := range members {
+			if err := h.modifyGroups(ctx, m, req.ID, store.Pull); err != nil {
+				return 0, nil, errgo.Mask(err)
+			}
+		}
+		err = h.params.Store.RemoveGroup(ctx, req.ID)
+		h.auditEvent(ctx, audit.Event{
+			Type:  audit.DeleteGroup,
+			Group: req.ID,
+		}, err)
+		if errgo.Cause(err) == store.ErrNotFound {
+			return 0, nil, errgo.WithCausef(nil, params.ErrNotFound, "group %q not found", req.ID)
+		}
+		if err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		return http.StatusNoContent, nil, nil
+	})
+}
+
+// patchGroup applies the given PATCH operation to g.
+func patchGroup(g *group, o operation) error {
+	op := strings.ToLower(o.Op)
+	if err := checkOp(op); err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidSyntax))
+	}
+	if o.Path != "" {
+		return errgo.Mask(patchGroupAttribute(g, op, o.Path, o.Value), errgo.Any)
+	}
+	attrs, err := patchAttributes(op, o.Value)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	for _, a := range attrs {
+		if err := patchGroupAttribute(g, op, a.path, a.value); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	}
+	return nil
+}
+
+// patchGroupAttribute applies the given PATCH operation to the attribute
+// of g with the given path.
+func patchGroupAttribute(g *group, op, path string, value json.RawMessage) error {
+	switch p := strings.ToLower(path); {
+	case p == "id", p == "schemas", p == "meta":
+		// These are ignored when replacing all attributes.
+	case p == "displayname":
+		if op == "remove" {
+			return errgo.WithCausef(nil, errInvalidValue, "displayName cannot be removed")
+		}
+		if err := unmarshalValue(value, &g.DisplayName); err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+	case p == "members":
+		var ms []member
+		if op != "remove" || len(value) > 0 && string(value) != "null" {
+			if err := unmarshalValue(value, &ms); err != nil {
+				return errgo.Mask(err, errgo.Is(errInvalidValue))
+			}
+		}
+		switch op {
+		case "add":
+			g.Members = append(g.Members, ms...)
+		case "replace":
+			g.Members = ms
+		case "remove":
+			if len(ms) == 0 {
+				g.Members = nil
+				break
+			}
+			remove := make(map[string]bool)
+			for _, m := range ms {
+				remove[m.Value] = true
+			}
+			g.Members = removeMembers(g.Members, func(m member) bool {
+				return remove[m.Value]
+			})
+		}
+	case strings.HasPrefix(p, "members[") && strings.HasSuffix(p, "]"):
+		if op != "remove" {
+			return errgo.WithCausef(nil, errInvalidPath, "unsupported path %q", path)
+		}
+		f, err := parseFilter(path[len("members["):len(path)-1], memberAttributes)
+		if err != nil {
+			return errgo.WithCausef(err, errInvalidPath, "unsupported path %q", path)
+		}
+		g.Members = removeMembers(g.Members, func(m member) bool {
+			return f.match(m)
+		})
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported path %q", path)
+	}
+	return nil
+}
+
+// removeMembers returns the members in ms for which remove returns
+// false.
+func removeMembers(ms []member, remove func(member) bool) []member {
+	var result []member
+	for _, m := range ms {
+		if !remove(m) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
@@ -0,0 +1,682 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/internal/scim"
+	"github.com/canonical/candid/store"
+)
+
+func TestSCIM(t *testing.T) {
+	qtsuite.Run(qt.New(t), &scimSuite{})
+}
+
+type scimSuite struct {
+	store *candidtest.Store
+	srv   *candidtest.Server
+}
+
+func (s *scimSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.AdminPassword = "test-password"
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"scim": scim.NewAPIHandler,
+	})
+}
+
+type object = map[string]interface{}
+
+// do performs a SCIM request authenticated as the admin user and
+// returns the status code and the decoded response body.
+func (s *scimSuite) do(c *qt.C, method, path string, body interface{}) (int, object) {
+	return s.doWithPassword(c, method, path, body, "test-password")
+}
+
+func (s *scimSuite) doWithPassword(c *qt.C, method, path string, body interface{}, password string) (int, object) {
+	var buf []byte
+	if body != nil {
+		var err error
+		buf, err = json.Marshal(body)
+		c.Assert(err, qt.IsNil)
+	}
+	req, err := http.NewRequest(method, "/scim/v2"+path, bytes.NewReader(buf))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/scim+json")
+	req.SetBasicAuth("admin", password)
+	resp := s.srv.Do(c, req)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/scim+json")
+	var v object
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	c.Assert(err, qt.IsNil)
+	return resp.StatusCode, v
+}
+
+// createUser creates a user with the given user name and returns its
+// id.
+func (s *scimSuite) createUser(c *qt.C, username string) string {
+	status, v := s.do(c, "POST", "/Users", object{
+		"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName": username,
+	})
+	c.Assert(status, qt.Equals, http.StatusCreated, qt.Commentf("%v", v))
+	return v["id"].(string)
+}
+
+func (s *scimSuite) TestUnauthorized(c *qt.C) {
+	status, v := s.doWithPassword(c, "GET", "/Users", nil, "bad-password")
+	c.Assert(status, qt.Equals, http.StatusUnauthorized)
+	c.Assert(v, qt.DeepEquals, object{
+		"schemas": []interface{}{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		"status":  "401",
+		"detail":  "could not determine identity: invalid credentials",
+	})
+}
+
+func (s *scimSuite) TestServiceProviderConfig(c *qt.C) {
+	status, v := s.do(c, "GET", "/ServiceProviderConfig", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["patch"], qt.DeepEquals, object{"supported": true})
+	c.Assert(v["filter"], qt.DeepEquals, object{"supported": true, "maxResults": float64(1000)})
+
+	status, v = s.do(c, "GET", "/ResourceTypes", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["totalResults"], qt.Equals, float64(2))
+}
+
+func (s *scimSuite) TestCreateUser(c *qt.C) {
+	status, v := s.do(c, "POST", "/Users", object{
+		"schemas":    []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"userName":   "bob",
+		"externalId": "E1234",
+		"name": object{
+			"givenName":  "Bob",
+			"familyName": "Smith",
+		},
+		"emails": []object{{
+			"value": "bob@example.com",
+			"type":  "work",
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusCreated, qt.Commentf("%v", v))
+	id := v["id"].(string)
+	c.Assert(v, qt.DeepEquals, object{
+		"schemas":     []interface{}{"urn:ietf:params:scim:schemas:core:2.0:User"},
+		"id":          id,
+		"externalId":  "E1234",
+		"userName":    "bob",
+		"displayName": "Bob Smith",
+		"name":        object{"formatted": "Bob Smith"},
+		"emails":      []interface{}{object{"value": "bob@example.com", "primary": true}},
+		"active":      true,
+		"meta": object{
+			"resourceType": "User",
+			"location":     s.srv.URL + "/scim/v2/Users/" + id,
+		},
+	})
+	s.store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("scim", "E1234"),
+		Username:   "bob",
+		Name:       "Bob Smith",
+		Email:      "bob@example.com",
+	})
+
+	events, err := s.store.AuditStore.Events(context.Background(), audit.Filter{Type: audit.ProvisionUser})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Actor, qt.Equals, "admin@candid")
+	c.Assert(events[0].User, qt.Equals, "bob")
+
+	status, v = s.do(c, "GET", "/Users/"+id, nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["userName"], qt.Equals, "bob")
+}
+
+var createUserErrorTests = []struct {
+	about        string
+	user         object
+	expectStatus int
+	expectType   string
+	expectDetail string
+}{{
+	about:        "no username",
+	user:         object{"externalId": "x"},
+	expectStatus: http.StatusBadRequest,
+	expectType:   "invalidValue",
+	expectDetail: "userName not specified",
+}, {
+	about:        "reserved username",
+	user:         object{"userName": "admin@candid"},
+	expectStatus: http.StatusBadRequest,
+	expectType:   "invalidValue",
+	expectDetail: `userName "admin@candid" is reserved`,
+}, {
+	about:        "externalId specifies identity provider",
+	user:         object{"userName": "alice", "externalId": "ldap:uid=alice,dc=example,dc=com"},
+	expectStatus: http.StatusBadRequest,
+	expectType:   "invalidValue",
+	expectDetail: `externalId "ldap:uid=alice,dc=example,dc=com" must not specify an identity provider`,
+}, {
+	about:        "reserved externalId",
+	user:         object{"userName": "agent", "externalId": "idm:agent"},
+	expectStatus: http.StatusBadRequest,
+	expectType:   "invalidValue",
+	expectDetail: `externalId "idm:agent" must not specify an identity provider`,
+}, {
+	about:        "duplicate username",
+	user:         object{"userName": "bob", "externalId": "other"},
+	expectStatus: http.StatusConflict,
+	expectType:   "uniqueness",
+	expectDetail: `userName "bob" already exists`,
+}, {
+	about:        "duplicate externalId",
+	user:         object{"userName": "bob2", "externalId": "bob"},
+	expectStatus: http.StatusConflict,
+	expectType:   "uniqueness",
+	expectDetail: `externalId "bob" already exists`,
+}}
+
+func (s *scimSuite) TestCreateUserErrors(c *qt.C) {
+	s.createUser(c, "bob")
+	for _, test := range createUserErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			status, v := s.do(c, "POST", "/Users", test.user)
+			c.Assert(status, qt.Equals, test.expectStatus)
+			c.Assert(v["scimType"], qt.Equals, test.expectType)
+			c.Assert(v["detail"], qt.Equals, test.expectDetail)
+		})
+	}
+}
+
+func (s *scimSuite) TestCreateUserInvalidBody(c *qt.C) {
+	req, err := http.NewRequest("POST", "/scim/v2/Users", bytes.NewReader([]byte("{")))
+	c.Assert(err, qt.IsNil)
+	req.SetBasicAuth("admin", "test-password")
+	resp := s.srv.Do(c, req)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	var v object
+	err = json.NewDecoder(resp.Body).Decode(&v)
+	c.Assert(err, qt.IsNil)
+	c.Assert(v["scimType"], qt.Equals, "invalidSyntax")
+}
+
+func (s *scimSuite) TestGetUserNotFound(c *qt.C) {
+	status, v := s.do(c, "GET", "/Users/1000", nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+	c.Assert(v["detail"], qt.Equals, `user "1000" not found`)
+
+	// The admin user cannot be managed with SCIM.
+	admin := store.Identity{Username: "admin@candid"}
+	err := s.store.Store.Identity(context.Background(), &admin)
+	c.Assert(err, qt.IsNil)
+	status, _ = s.do(c, "GET", "/Users/"+admin.ID, nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+}
+
+var listUsersTests = []struct {
+	about        string
+	query        url.Values
+	expectStatus int
+	expectTotal  int
+	expectUsers  []string
+	expectType   string
+}{{
+	about:       "all users",
+	expectTotal: 3,
+	expectUsers: []string{"alice", "bob", "charlie"},
+}, {
+	about:       "username eq",
+	query:       url.Values{"filter": {`userName eq "bob"`}},
+	expectTotal: 1,
+	expectUsers: []string{"bob"},
+}, {
+	about:       "username eq is case sensitive",
+	query:       url.Values{"filter": {`userName eq "BOB"`}},
+	expectTotal: 0,
+	expectUsers: []string{},
+}, {
+	about:      "disjunction",
+	query:      url.Values{"filter": {`userName sw "a" or userName eq "bob"`}},
+	expectType: "invalidFilter",
+}, {
+	about:       "conjunction",
+	query:       url.Values{"filter": {`userName co "l" and externalId ne "charlie"`}},
+	expectTotal: 1,
+	expectUsers: []string{"alice"},
+}, {
+	about:       "externalId",
+	query:       url.Values{"filter": {`externalId eq "charlie"`}},
+	expectTotal: 1,
+	expectUsers: []string{"charlie"},
+}, {
+	about:       "active",
+	query:       url.Values{"filter": {`active eq false`}},
+	expectTotal: 0,
+	expectUsers: []string{},
+}, {
+	about:       "pagination",
+	query:       url.Values{"startIndex": {"2"}, "count": {"1"}},
+	expectTotal: 3,
+	expectUsers: []string{"bob"},
+}, {
+	about:       "zero count",
+	query:       url.Values{"count": {"0"}},
+	expectTotal: 3,
+	expectUsers: []string{},
+}, {
+	about:       "start index past end",
+	query:       url.Values{"startIndex": {"10"}},
+	expectTotal: 3,
+	expectUsers: []string{},
+}, {
+	about:      "unsupported attribute",
+	query:      url.Values{"filter": {`title eq "x"`}},
+	expectType: "invalidFilter",
+}, {
+	about:      "unsupported operator",
+	query:      url.Values{"filter": {`userName gt "x"`}},
+	expectType: "invalidFilter",
+}, {
+	about:      "unterminated string",
+	query:      url.Values{"filter": {`userName eq "x`}},
+	expectType: "invalidFilter",
+}, {
+	about:      "invalid count",
+	query:      url.Values{"count": {"many"}},
+	expectType: "invalidValue",
+}}
+
+func (s *scimSuite) TestListUsers(c *qt.C) {
+	for _, u := range []string{"charlie", "alice", "bob"} {
+		s.createUser(c, u)
+	}
+	for _, test := range listUsersTests {
+		c.Run(test.about, func(c *qt.C) {
+			status, v := s.do(c, "GET", "/Users?"+test.query.Encode(), nil)
+			if test.expectType != "" {
+				c.Assert(status, qt.Equals, http.StatusBadRequest)
+				c.Assert(v["scimType"], qt.Equals, test.expectType)
+				return
+			}
+			c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+			c.Assert(v["totalResults"], qt.Equals, float64(test.expectTotal))
+			c.Assert(v["itemsPerPage"], qt.Equals, float64(len(test.expectUsers)))
+			usernames := []string{}
+			for _, r := range v["Resources"].([]interface{}) {
+				usernames = append(usernames, r.(object)["userName"].(string))
+			}
+			c.Assert(usernames, qt.DeepEquals, test.expectUsers)
+		})
+	}
+}
+
+func (s *scimSuite) TestReplaceUser(c *qt.C) {
+	id := s.createUser(c, "bob")
+	status, v := s.do(c, "PUT", "/Users/"+id, object{
+		"userName":    "bob",
+		"displayName": "Robert",
+		"emails":      []object{{"value": "a@example.com"}, {"value": "b@example.com", "primary": true}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(v["displayName"], qt.Equals, "Robert")
+	s.store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("scim", "bob"),
+		Username:   "bob",
+		Name:       "Robert",
+		Email:      "b@example.com",
+	})
+
+	status, v = s.do(c, "PUT", "/Users/"+id, object{
+		"userName": "robert",
+	})
+	c.Assert(status, qt.Equals, http.StatusBadRequest)
+	c.Assert(v["scimType"], qt.Equals, "mutability")
+	c.Assert(v["detail"], qt.Equals, "userName cannot be changed")
+
+	status, _ = s.do(c, "PUT", "/Users/1000", object{
+		"userName": "robert",
+	})
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+}
+
+func (s *scimSuite) TestPatchUser(c *qt.C) {
+	id := s.createUser(c, "bob")
+	status, v := s.do(c, "PATCH", "/Users/"+id, object{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []object{{
+			"op":    "Replace",
+			"path":  "displayName",
+			"value": "Bob Smith",
+		}, {
+			"op":    "add",
+			"path":  `emails[type eq "work"].value`,
+			"value": "bob@example.com",
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	s.store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("scim", "bob"),
+		Username:   "bob",
+		Name:       "Bob Smith",
+		Email:      "bob@example.com",
+	})
+
+	// Some clients send booleans as strings.
+	status, v = s.do(c, "PATCH", "/Users/"+id, object{
+		"Operations": []object{{
+			"op":    "replace",
+			"path":  "active",
+			"value": "False",
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(v["active"], qt.Equals, false)
+	events, err := s.store.AuditStore.Events(context.Background(), audit.Filter{Type: audit.DeprovisionUser})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+
+	// Operations without a path replace the given attributes.
+	status, v = s.do(c, "PATCH", "/Users/"+id, object{
+		"Operations": []object{{
+			"op": "replace",
+			"value": object{
+				"active":         true,
+				"name.givenName": "Robert",
+			},
+		}, {
+			"op":   "remove",
+			"path": "emails",
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	s.store.AssertUser(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("scim", "bob"),
+		Username:   "bob",
+		Name:       "Robert",
+	})
+}
+
+var patchUserErrorTests = []struct {
+	about        string
+	operation    object
+	expectType   string
+	expectDetail string
+}{{
+	about:        "unsupported operation",
+	operation:    object{"op": "move", "path": "displayName", "value": "x"},
+	expectType:   "invalidSyntax",
+	expectDetail: `unsupported patch operation "move"`,
+}, {
+	about:        "unsupported path",
+	operation:    object{"op": "replace", "path": "title", "value": "x"},
+	expectType:   "invalidPath",
+	expectDetail: `unsupported path "title"`,
+}, {
+	about:        "change username",
+	operation:    object{"op": "replace", "path": "userName", "value": "robert"},
+	expectType:   "mutability",
+	expectDetail: "userName cannot be changed",
+}, {
+	about:        "change externalId",
+	operation:    object{"op": "replace", "value": object{"externalId": "other"}},
+	expectType:   "mutability",
+	expectDetail: "externalId cannot be changed",
+}, {
+	about:        "change groups",
+	operation:    object{"op": "add", "path": "groups", "value": []object{{"value": "g"}}},
+	expectType:   "mutability",
+	expectDetail: "groups must be modified using the Group resource",
+}, {
+	about:        "invalid boolean",
+	operation:    object{"op": "replace", "path": "active", "value": "maybe"},
+	expectType:   "invalidValue",
+	expectDetail: `invalid boolean value "maybe"`,
+}, {
+	about:        "remove without path",
+	operation:    object{"op": "remove"},
+	expectType:   "invalidPath",
+	expectDetail: "path not specified",
+}}
+
+func (s *scimSuite) TestPatchUserErrors(c *qt.C) {
+	id := s.createUser(c, "bob")
+	for _, test := range patchUserErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			status, v := s.do(c, "PATCH", "/Users/"+id, object{
+				"Operations": []object{test.operation},
+			})
+			c.Assert(status, qt.Equals, http.StatusBadRequest)
+			c.Assert(v["scimType"], qt.Equals, test.expectType)
+			c.Assert(v["detail"], qt.Equals, test.expectDetail)
+		})
+	}
+}
+
+func (s *scimSuite) TestDeleteUser(c *qt.C) {
+	id := s.createUser(c, "bob")
+	status, v := s.do(c, "POST", "/Groups", object{
+		"displayName": "staff",
+		"members":     []object{{"value": id}},
+	})
+	c.Assert(status, qt.Equals, http.StatusCreated, qt.Commentf("%v", v))
+
+	status, _ = s.do(c, "DELETE", "/Users/"+id, nil)
+	c.Assert(status, qt.Equals, http.StatusNoContent)
+	err := s.store.Store.Identity(context.Background(), &store.Identity{
+		ProviderID: store.MakeProviderIdentity("scim", "bob"),
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	events, err := s.store.AuditStore.Events(context.Background(), audit.Filter{Type: audit.DeleteUser})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].User, qt.Equals, "bob")
+	c.Assert(events[0].Remove, qt.DeepEquals, []string{"staff"})
+
+	// The user can no longer be retrieved, modified or deleted.
+	status, _ = s.do(c, "GET", "/Users/"+id, nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+	status, _ = s.do(c, "PATCH", "/Users/"+id, object{
+		"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []object{{"op": "replace", "path": "active", "value": false}},
+	})
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+	status, _ = s.do(c, "DELETE", "/Users/"+id, nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+
+	// The group is retained without any members.
+	status, v = s.do(c, "GET", "/Groups/staff", nil)
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(v["members"], qt.IsNil)
+}
+
+func (s *scimSuite) TestGroups(c *qt.C) {
+	alice := s.createUser(c, "alice")
+	bob := s.createUser(c, "bob")
+	charlie := s.createUser(c, "charlie")
+
+	// A group can be created without any members.
+	status, v := s.do(c, "POST", "/Groups", object{
+		"schemas":     []string{"urn:ietf:params:scim:schemas:core:2.0:Group"},
+		"displayName": "engineering",
+	})
+	c.Assert(status, qt.Equals, http.StatusCreated, qt.Commentf("%v", v))
+	c.Assert(v, qt.DeepEquals, object{
+		"schemas":     []interface{}{"urn:ietf:params:scim:schemas:core:2.0:Group"},
+		"id":          "engineering",
+		"displayName": "engineering",
+		"meta": object{
+			"resourceType": "Group",
+			"location":     s.srv.URL + "/scim/v2/Groups/engineering",
+		},
+	})
+	status, v = s.do(c, "POST", "/Groups", object{
+		"displayName": "engineering",
+	})
+	c.Assert(status, qt.Equals, http.StatusConflict)
+	c.Assert(v["scimType"], qt.Equals, "uniqueness")
+
+	status, v = s.do(c, "PATCH", "/Groups/engineering", object{
+		"Operations": []object{{
+			"op":    "add",
+			"path":  "members",
+			"value": []object{{"value": alice}, {"value": bob}, {"value": charlie}},
+		}, {
+			"op":   "remove",
+			"path": `members[value eq "` + charlie + `"]`,
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(memberNames(v), qt.DeepEquals, []string{"alice", "bob"})
+	s.assertGroups(c, "alice", "engineering")
+	s.assertGroups(c, "bob", "engineering")
+	s.assertGroups(c, "charlie")
+
+	status, v = s.do(c, "GET", "/Groups?"+url.Values{"filter": {`members eq "` + bob + `"`}}.Encode(), nil)
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(v["totalResults"], qt.Equals, float64(1))
+
+	status, v = s.do(c, "GET", "/Users/"+alice, nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["groups"], qt.DeepEquals, []interface{}{object{
+		"value":   "engineering",
+		"display": "engineering",
+		"$ref":    s.srv.URL + "/scim/v2/Groups/engineering",
+	}})
+
+	// The group cannot be renamed as its name is its id.
+	status, v = s.do(c, "PUT", "/Groups/engineering", object{
+		"displayName": "developers",
+		"members":     []object{{"value": bob}, {"value": charlie}},
+	})
+	c.Assert(status, qt.Equals, http.StatusBadRequest, qt.Commentf("%v", v))
+	c.Assert(v["scimType"], qt.Equals, "mutability")
+
+	// Replace the members.
+	status, v = s.do(c, "PUT", "/Groups/engineering", object{
+		"displayName": "engineering",
+		"members":     []object{{"value": bob}, {"value": charlie}},
+	})
+	c.Assert(status, qt.Equals, http.StatusOK, qt.Commentf("%v", v))
+	c.Assert(v["id"], qt.Equals, "engineering")
+	c.Assert(memberNames(v), qt.DeepEquals, []string{"bob", "charlie"})
+	s.assertGroups(c, "alice")
+	s.assertGroups(c, "bob", "engineering")
+	s.assertGroups(c, "charlie", "engineering")
+
+	status, _ = s.do(c, "GET", "/Groups/developers", nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+
+	status, v = s.do(c, "GET", "/Groups", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["totalResults"], qt.Equals, float64(1))
+
+	status, v = s.do(c, "PATCH", "/Groups/engineering", object{
+		"Operations": []object{{
+			"op":    "add",
+			"path":  "members",
+			"value": []object{{"value": "1000"}},
+		}},
+	})
+	c.Assert(status, qt.Equals, http.StatusBadRequest)
+	c.Assert(v["detail"], qt.Equals, `unknown member "1000"`)
+
+	status, _ = s.do(c, "DELETE", "/Groups/engineering", nil)
+	c.Assert(status, qt.Equals, http.StatusNoContent)
+	s.assertGroups(c, "bob")
+	s.assertGroups(c, "charlie")
+	status, v = s.do(c, "GET", "/Groups", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["totalResults"], qt.Equals, float64(0))
+	err := s.store.Store.Group(context.Background(), &store.Group{Name: "engineering"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	events, err := s.store.AuditStore.Events(context.Background(), audit.Filter{
+		Type: audit.ModifyGroups,
+		User: "charlie",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 2)
+	c.Assert(events[0].Add, qt.DeepEquals, []string{"engineering"})
+	c.Assert(events[1].Remove, qt.DeepEquals, []string{"engineering"})
+}
+
+func (s *scimSuite) TestGroupsAreStoreGroups(c *qt.C) {
+	ctx := context.Background()
+	id := s.createUser(c, "bob")
+	err := s.store.Store.UpdateIdentity(ctx, &store.Identity{
+		ID:     id,
+		Groups: []string{"existing", "unknown"},
+	}, store.Update{
+		store.Groups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+
+	// Groups only exist once they have been created.
+	status, _ := s.do(c, "GET", "/Groups/existing", nil)
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+
+	// A group created in the store is visible through SCIM, along
+	// with its existing members.
+	err = s.store.Store.AddGroup(ctx, &store.Group{Name: "existing"})
+	c.Assert(err, qt.IsNil)
+	status, v := s.do(c, "GET", "/Groups/existing", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(memberNames(v), qt.DeepEquals, []string{"bob"})
+	status, v = s.do(c, "GET", "/Groups", nil)
+	c.Assert(status, qt.Equals, http.StatusOK)
+	c.Assert(v["totalResults"], qt.Equals, float64(1))
+
+	// A group created through SCIM is a group in the store.
+	status, v = s.do(c, "POST", "/Groups", object{
+		"displayName": "created",
+	})
+	c.Assert(status, qt.Equals, http.StatusCreated, qt.Commentf("%v", v))
+	err = s.store.Store.Group(ctx, &store.Group{Name: "created"})
+	c.Assert(err, qt.IsNil)
+
+	status, v = s.do(c, "POST", "/Groups", object{
+		"displayName": "two words",
+	})
+	c.Assert(status, qt.Equals, http.StatusBadRequest, qt.Commentf("%v", v))
+	c.Assert(v["detail"], qt.Equals, `invalid group name "two words"`)
+}
+
+func (s *scimSuite) assertGroups(c *qt.C, username string, groups ...string) {
+	identity := store.Identity{Username: username}
+	err := s.store.Store.Identity(context.Background(), &identity)
+	c.Assert(err, qt.IsNil)
+	if len(groups) == 0 {
+		c.Assert(identity.Groups, qt.HasLen, 0)
+		return
+	}
+	c.Assert(identity.Groups, qt.DeepEquals, groups)
+}
+
+func memberNames(g object) []string {
+	var names []string
+	members, _ := g["members"].([]interface{})
+	for _, m := range members {
+		names = append(names, m.(object)["display"].(string))
+	}
+	return names
+}
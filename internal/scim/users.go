@@ -0,0 +1,713 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// providerName is the identity provider name used in the ProviderID of
+// users created by provisioning clients.
+const providerName = "scim"
+
+// user is a SCIM User resource (RFC 7643 section 4.1).
+type user struct {
+	Schemas     []string   `json:"schemas"`
+	ID          string     `json:"id,omitempty"`
+	ExternalID  string     `json:"externalId,omitempty"`
+	UserName    string     `json:"userName"`
+	Name        *name      `json:"name,omitempty"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Emails      []email    `json:"emails,omitempty"`
+	Active      *bool      `json:"active,omitempty"`
+	Groups      []groupRef `json:"groups,omitempty"`
+	Meta        *meta      `json:"meta,omitempty"`
+}
+
+type name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type groupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// fullName returns the name of the user to store in the identity.
+func (u *user) fullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name == nil {
+		return ""
+	}
+	if u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+}
+
+// email returns the email address of the user to store in the
+// identity. This is the primary address, if there is one, otherwise the
+// first address.
+func (u *user) email() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// userAttributes holds the attributes of a user that may be used in a
+// filter.
+var userAttributes = map[string]attribute{
+	"id": {
+		caseExact: true,
+		values: func(r interface{}) []string {
+			return []string{r.(*user).ID}
+		},
+	},
+	"externalid": {
+		caseExact: true,
+		values: func(r interface{}) []string {
+			return nonEmpty(r.(*user).ExternalID)
+		},
+	},
+	"username": {
+		// Usernames are case sensitive in the identity server,
+		// unlike the SCIM default.
+		caseExact: true,
+		values: func(r interface{}) []string {
+			return []string{r.(*user).UserName}
+		},
+	},
+	"displayname": {
+		values: func(r interface{}) []string {
+			return nonEmpty(r.(*user).DisplayName)
+		},
+	},
+	"name.formatted": {
+		values: func(r interface{}) []string {
+			return nonEmpty(r.(*user).fullName())
+		},
+	},
+	"emails": {
+		values: emailValues,
+	},
+	"emails.value": {
+		values: emailValues,
+	},
+	"active": {
+		values: func(r interface{}) []string {
+			if u := r.(*user); u.Active != nil && !*u.Active {
+				return []string{"false"}
+			}
+			return []string{"true"}
+		},
+	},
+	"groups": {
+		values: groupValues,
+	},
+	"groups.value": {
+		values: groupValues,
+	},
+	"groups.display": {
+		values: groupValues,
+	},
+}
+
+func emailValues(r interface{}) []string {
+	var values []string
+	for _, e := range r.(*user).Emails {
+		values = append(values, e.Value)
+	}
+	return values
+}
+
+func groupValues(r interface{}) []string {
+	var values []string
+	for _, g := range r.(*user).Groups {
+		values = append(values, g.Value)
+	}
+	return values
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// externalID returns the SCIM externalId of a user with the given
+// ProviderID.
+func externalID(pid store.ProviderIdentity) string {
+	if provider, id := pid.Split(); provider == providerName {
+		return id
+	}
+	return string(pid)
+}
+
+// providerID returns the ProviderID of the user with the given SCIM
+// externalId. This is the inverse of externalID, so an externalId that
+// specifies an identity provider refers to a user in that provider.
+func providerID(externalID string) store.ProviderIdentity {
+	if strings.Contains(externalID, ":") {
+		return store.ProviderIdentity(externalID)
+	}
+	return store.MakeProviderIdentity(providerName, externalID)
+}
+
+// provisionable reports whether the given identity can be managed using
+// SCIM. The admin user and agents, which are managed by the identity
+// server itself, cannot.
+func provisionable(identity *store.Identity) bool {
+	return identity.ProviderID.Provider() != "idm"
+}
+
+// userFromIdentity creates the User resource for the given identity.
+func (h *handler) userFromIdentity(identity *store.Identity) *user {
+	active := !identity.Suspended
+	u := &user{
+		Schemas:     []string{userSchema},
+		ID:          identity.ID,
+		ExternalID:  externalID(identity.ProviderID),
+		UserName:    identity.Username,
+		DisplayName: identity.Name,
+		Active:      &active,
+		Meta: &meta{
+			ResourceType: "User",
+			Location:     h.params.Location + basePath + "/Users/" + url.PathEscape(identity.ID),
+		},
+	}
+	if identity.Name != "" {
+		u.Name = &name{Formatted: identity.Name}
+	}
+	if identity.Email != "" {
+		u.Emails = []email{{Value: identity.Email, Primary: true}}
+	}
+	for _, g := range identity.Groups {
+		u.Groups = append(u.Groups, groupRef{
+			Value:   g,
+			Display: g,
+			Ref:     h.params.Location + basePath + "/Groups/" + url.PathEscape(g),
+		})
+	}
+	return u
+}
+
+// identity retrieves the provisionable identity with the given ID.
+func (h *handler) identity(ctx context.Context, id string) (*store.Identity, error) {
+	identity := store.Identity{ID: id}
+	if err := h.params.Store.Identity(ctx, &identity); err != nil {
+		if errgo.Cause(err) == store.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", id)
+		}
+		return nil, errgo.Mask(err)
+	}
+	if !provisionable(&identity) {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", id)
+	}
+	return &identity, nil
+}
+
+type listUsersRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Users"`
+	listParams
+}
+
+// listUsers serves a query for users, as defined in RFC 7644 section
+// 3.4.2.
+func (h *handler) listUsers(p httprequest.Params, req *listUsersRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		f, err := parseFilter(req.Filter, userAttributes)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidFilter))
+		}
+		count, err := req.count()
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		identities, err := h.findIdentities(ctx, f)
+		if err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		var resources []interface{}
+		for i := range identities {
+			if !provisionable(&identities[i]) {
+				continue
+			}
+			if u := h.userFromIdentity(&identities[i]); f.match(u) {
+				resources = append(resources, u)
+			}
+		}
+		return http.StatusOK, newListResponse(resources, req.StartIndex, count), nil
+	})
+}
+
+// findIdentities returns the identities that may match the given
+// filter, sorted by username. A filter that requires an exact userName
+// or externalId is resolved with a single lookup, the filter must still
+// be applied to the returned identities.
+func (h *handler) findIdentities(ctx context.Context, f filter) ([]store.Identity, error) {
+	var identity store.Identity
+	if v, ok := f.equal("externalid"); ok {
+		identity.ProviderID = providerID(v)
+	} else if v, ok := f.equal("username"); ok {
+		identity.Username = v
+	} else {
+		identities, err := h.params.Store.FindIdentities(ctx, &store.Identity{}, store.Filter{}, []store.Sort{{Field: store.Username}}, 0, 0)
+		return identities, errgo.Mask(err)
+	}
+	err := h.params.Store.Identity(ctx, &identity)
+	if errgo.Cause(err) == store.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return []store.Identity{identity}, nil
+}
+
+type createUserRequest struct {
+	httprequest.Route `httprequest:"POST /scim/v2/Users"`
+}
+
+// createUser serves a request to create a user, as defined in RFC 7644
+// section 3.3.
+func (h *handler) createUser(p httprequest.Params, req *createUserRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var u user
+		if err := decodeBody(p.Request, &u); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		identity, err := h.createIdentity(ctx, &u)
+		h.auditEvent(ctx, audit.Event{
+			Type: audit.ProvisionUser,
+			User: u.UserName,
+		}, err)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Any)
+		}
+		return http.StatusCreated, h.userFromIdentity(identity), nil
+	})
+}
+
+// createIdentity creates a new identity for the given user.
+func (h *handler) createIdentity(ctx context.Context, u *user) (*store.Identity, error) {
+	if u.UserName == "" {
+		return nil, errgo.WithCausef(nil, errInvalidValue, "userName not specified")
+	}
+	if strings.HasSuffix(u.UserName, "@candid") {
+		return nil, errgo.WithCausef(nil, errInvalidValue, "userName %q is reserved", u.UserName)
+	}
+	externalID := u.ExternalID
+	if externalID == "" {
+		externalID = u.UserName
+	}
+	if strings.Contains(externalID, ":") {
+		// Users created by a provisioning client always belong
+		// to the scim provider, so that existing users of
+		// other providers cannot be overwritten.
+		return nil, errgo.WithCausef(nil, errInvalidValue, "externalId %q must not specify an identity provider", externalID)
+	}
+	identity := &store.Identity{
+		ProviderID: store.MakeProviderIdentity(providerName, externalID),
+		Username:   u.UserName,
+		Name:       u.fullName(),
+		Email:      u.email(),
+		Suspended:  u.Active != nil && !*u.Active,
+	}
+	// UpdateIdentity would update any existing identity with the
+	// same ProviderID, rather than fail as a SCIM create should.
+	err := h.params.Store.Identity(ctx, &store.Identity{ProviderID: identity.ProviderID})
+	if err == nil {
+		return nil, errgo.WithCausef(nil, errUniqueness, "externalId %q already exists", externalID)
+	}
+	if errgo.Cause(err) != store.ErrNotFound {
+		return nil, errgo.Mask(err)
+	}
+	err = h.params.Store.UpdateIdentity(ctx, identity, store.Update{
+		store.Username:  store.Set,
+		store.Name:      store.Set,
+		store.Email:     store.Set,
+		store.Suspended: store.Set,
+	})
+	if errgo.Cause(err) == store.ErrDuplicateUsername {
+		return nil, errgo.WithCausef(nil, errUniqueness, "userName %q already exists", u.UserName)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := h.params.Store.Identity(ctx, identity); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return identity, nil
+}
+
+type getUserRequest struct {
+	httprequest.Route `httprequest:"GET /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// getUser serves a request for a single user.
+func (h *handler) getUser(p httprequest.Params, req *getUserRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		identity, err := h.identity(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return http.StatusOK, h.userFromIdentity(identity), nil
+	})
+}
+
+type replaceUserRequest struct {
+	httprequest.Route `httprequest:"PUT /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// replaceUser serves a request to replace the attributes of a user, as
+// defined in RFC 7644 section 3.5.1.
+func (h *handler) replaceUser(p httprequest.Params, req *replaceUserRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var u user
+		if err := decodeBody(p.Request, &u); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		identity, err := h.identity(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		if err := h.updateIdentity(ctx, identity, &u); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Any)
+		}
+		return http.StatusOK, h.userFromIdentity(identity), nil
+	})
+}
+
+type patchUserRequest struct {
+	httprequest.Route `httprequest:"PATCH /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// patchUser serves a request to modify the attributes of a user, as
+// defined in RFC 7644 section 3.5.2.
+func (h *handler) patchUser(p httprequest.Params, req *patchUserRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		var op patchOp
+		if err := decodeBody(p.Request, &op); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(errInvalidSyntax))
+		}
+		identity, err := h.identity(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		u := h.userFromIdentity(identity)
+		for _, o := range op.Operations {
+			if err := patchUser(u, o); err != nil {
+				return 0, nil, errgo.Mask(err, errgo.Any)
+			}
+		}
+		if err := h.updateIdentity(ctx, identity, u); err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Any)
+		}
+		return http.StatusOK, h.userFromIdentity(identity), nil
+	})
+}
+
+// updateIdentity updates the given identity so that it holds the
+// attributes in the given user.
+func (h *handler) updateIdentity(ctx context.Context, identity *store.Identity, u *user) error {
+	if u.UserName != "" && u.UserName != identity.Username {
+		return errgo.WithCausef(nil, errMutability, "userName cannot be changed")
+	}
+	if u.ExternalID != "" && u.ExternalID != externalID(identity.ProviderID) {
+		return errgo.WithCausef(nil, errMutability, "externalId cannot be changed")
+	}
+	update := &store.Identity{
+		ID:        identity.ID,
+		Name:      u.fullName(),
+		Email:     u.email(),
+		Suspended: identity.Suspended,
+	}
+	if u.Active != nil {
+		update.Suspended = !*u.Active
+	}
+	err := h.params.Store.UpdateIdentity(ctx, update, store.Update{
+		store.Name:      store.Set,
+		store.Email:     store.Set,
+		store.Suspended: store.Set,
+	})
+	e := audit.Event{
+		Type: audit.ProvisionUser,
+		User: identity.Username,
+	}
+	if update.Suspended && !identity.Suspended {
+		e.Type = audit.DeprovisionUser
+	}
+	h.auditEvent(ctx, e, err)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	identity.Name = update.Name
+	identity.Email = update.Email
+	identity.Suspended = update.Suspended
+	return nil
+}
+
+type deleteUserRequest struct {
+	httprequest.Route `httprequest:"DELETE /scim/v2/Users/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+// deleteUser serves a request to delete a user, as defined in RFC 7644
+// section 3.6. Any agents owned by the user are deleted along with it.
+func (h *handler) deleteUser(p httprequest.Params, req *deleteUserRequest) {
+	h.serve(p, func(ctx context.Context) (int, interface{}, error) {
+		identity, err := h.identity(ctx, req.ID)
+		if err != nil {
+			return 0, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		err = deletion.Delete(ctx, deletion.Params{
+			Store:         h.params.Store,
+			KeyValueStore: h.params.DeletionStore,
+			Audit: func(ctx context.Context, e audit.Event) {
+				h.auditEvent(ctx, e, nil)
+			},
+		}, identity)
+		if errgo.Cause(err) == store.ErrNotFound {
+			return 0, nil, errgo.WithCausef(nil, params.ErrNotFound, "user %q not found", req.ID)
+		}
+		if err != nil {
+			return 0, nil, errgo.Mask(err)
+		}
+		return http.StatusNoContent, nil, nil
+	})
+}
+
+// patchUser applies the given PATCH operation to u.
+func patchUser(u *user, o operation) error {
+	op := strings.ToLower(o.Op)
+	if err := checkOp(op); err != nil {
+		return errgo.Mask(err, errgo.Is(errInvalidSyntax))
+	}
+	if o.Path != "" {
+		return errgo.Mask(patchUserAttribute(u, op, o.Path, o.Value), errgo.Any)
+	}
+	attrs, err := patchAttributes(op, o.Value)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	for _, a := range attrs {
+		if err := patchUserAttribute(u, op, a.path, a.value); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	}
+	return nil
+}
+
+// patchUserAttribute applies the given PATCH operation to the attribute
+// of u with the given path.
+func patchUserAttribute(u *user, op, path string, value json.RawMessage) error {
+	switch p := strings.ToLower(path); {
+	case p == "id", p == "schemas", p == "meta":
+		// These are ignored when replacing all attributes.
+	case p == "username":
+		s, err := stringValue(op, value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		if s != u.UserName {
+			return errgo.WithCausef(nil, errMutability, "userName cannot be changed")
+		}
+	case p == "externalid":
+		s, err := stringValue(op, value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		if s != u.ExternalID {
+			return errgo.WithCausef(nil, errMutability, "externalId cannot be changed")
+		}
+	case p == "active":
+		if op == "remove" {
+			return errgo.WithCausef(nil, errInvalidValue, "active cannot be removed")
+		}
+		active, err := boolValue(value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		u.Active = &active
+	case p == "displayname":
+		s, err := stringValue(op, value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		u.DisplayName = s
+		u.Name = nil
+	case p == "name":
+		u.Name = nil
+		u.DisplayName = ""
+		if op != "remove" {
+			if err := unmarshalValue(value, &u.Name); err != nil {
+				return errgo.Mask(err, errgo.Is(errInvalidValue))
+			}
+		}
+	case strings.HasPrefix(p, "name."):
+		s, err := stringValue(op, value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		if u.Name == nil {
+			u.Name = new(name)
+		}
+		switch p {
+		case "name.formatted":
+			u.Name.Formatted = s
+		case "name.givenname":
+			u.Name.GivenName = s
+			u.Name.Formatted = ""
+		case "name.familyname":
+			u.Name.FamilyName = s
+			u.Name.Formatted = ""
+		default:
+			return errgo.WithCausef(nil, errInvalidPath, "unsupported path %q", path)
+		}
+		u.DisplayName = ""
+	case p == "emails":
+		var emails []email
+		if op != "remove" {
+			if err := unmarshalValue(value, &emails); err != nil {
+				return errgo.Mask(err, errgo.Is(errInvalidValue))
+			}
+		}
+		if op == "add" {
+			emails = append(u.Emails, emails...)
+		}
+		u.Emails = emails
+	case p == "emails.value", strings.HasPrefix(p, "emails[") && strings.HasSuffix(p, "].value"):
+		// Only a single email address is stored, so any
+		// address replaces it.
+		s, err := stringValue(op, value)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(errInvalidValue))
+		}
+		u.Emails = nil
+		if s != "" {
+			u.Emails = []email{{Value: s, Primary: true}}
+		}
+	case strings.HasPrefix(p, "groups"):
+		return errgo.WithCausef(nil, errMutability, "groups must be modified using the Group resource")
+	default:
+		return errgo.WithCausef(nil, errInvalidPath, "unsupported path %q", path)
+	}
+	return nil
+}
+
+// checkOp checks that the given (lower-case) PATCH operation is
+// supported.
+func checkOp(op string) error {
+	switch op {
+	case "add", "replace", "remove":
+		return nil
+	}
+	return errgo.WithCausef(nil, errInvalidSyntax, "unsupported patch operation %q", op)
+}
+
+type attributeValue struct {
+	path  string
+	value json.RawMessage
+}
+
+// patchAttributes returns the attributes in the value of a PATCH
+// operation that does not specify a path. The attributes are returned
+// in a consistent order.
+func patchAttributes(op string, value json.RawMessage) ([]attributeValue, error) {
+	if op == "remove" {
+		return nil, errgo.WithCausef(nil, errInvalidPath, "path not specified")
+	}
+	var m map[string]json.RawMessage
+	if err := unmarshalValue(value, &m); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	attrs := make([]attributeValue, 0, len(m))
+	for k, v := range m {
+		attrs = append(attrs, attributeValue{k, v})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].path < attrs[j].path
+	})
+	return attrs, nil
+}
+
+// unmarshalValue unmarshals the value of a PATCH operation into v.
+func unmarshalValue(value json.RawMessage, v interface{}) error {
+	if len(value) == 0 {
+		return errgo.WithCausef(nil, errInvalidValue, "value not specified")
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return errgo.WithCausef(err, errInvalidValue, "invalid value")
+	}
+	return nil
+}
+
+// stringValue unmarshals a string value of a PATCH operation. The value
+// of a remove operation is always empty.
+func stringValue(op string, value json.RawMessage) (string, error) {
+	if op == "remove" {
+		return "", nil
+	}
+	var s string
+	if err := unmarshalValue(value, &s); err != nil {
+		return "", errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	return s, nil
+}
+
+// boolValue unmarshals a boolean value of a PATCH operation. Some
+// provisioning clients send booleans as strings, so these are accepted
+// too.
+func boolValue(value json.RawMessage) (bool, error) {
+	var v interface{}
+	if err := unmarshalValue(value, &v); err != nil {
+		return false, errgo.Mask(err, errgo.Is(errInvalidValue))
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, errgo.WithCausef(nil, errInvalidValue, "invalid boolean value %s", value)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/canonical/candid/internal/identity"
 	"github.com/canonical/candid/internal/monitoring"
 	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
 )
 
 var logger = loggo.GetLogger("candid.internal.v1")
@@ -61,6 +62,7 @@ func new(hParams identity.HandlerParams) func(p httprequest.Params, arg interfac
 				return nil, nil, errgo.Newf("unexpected identity type %T", authInfo.Identity)
 			}
 			ctx = contextWithIdentity(ctx, id)
+			ctx = store.ContextWithGroupChanges(ctx, id.Id())
 		}
 		return hnd, ctx, nil
 	}
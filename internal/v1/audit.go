@@ -0,0 +1,79 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/params"
+)
+
+// AuditEvents returns the events recorded in the audit log that match
+// the given request.
+func (h *handler) AuditEvents(p httprequest.Params, r *params.AuditEventsRequest) ([]params.AuditEvent, error) {
+	logger.Tracef("AuditEvents %#v", r)
+	as, ok := h.params.AuditSink.(audit.Store)
+	if !ok {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "audit log is not stored")
+	}
+	f := audit.Filter{
+		Type:  audit.EventType(r.Type),
+		User:  r.User,
+		Limit: r.Limit,
+	}
+	if r.Limit < 0 {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid limit %d", r.Limit)
+	}
+	if r.After != "" {
+		if err := f.After.UnmarshalText([]byte(r.After)); err != nil {
+			return nil, errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal after")
+		}
+	}
+	if r.Before != "" {
+		if err := f.Before.UnmarshalText([]byte(r.Before)); err != nil {
+			return nil, errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal before")
+		}
+	}
+	events, err := as.Events(p.Context, f)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := make([]params.AuditEvent, len(events))
+	for i, e := range events {
+		resp[i] = params.AuditEvent{
+			Time:   e.Time,
+			Type:   string(e.Type),
+			Actor:  e.Actor,
+			User:   e.User,
+			IDP:    e.IDP,
+			Caveat: e.Caveat,
+			ACL:    e.ACL,
+			Group:  e.Group,
+			Set:    e.Set,
+			Add:    e.Add,
+			Remove: e.Remove,
+			Until:  e.Until,
+			Error:  e.Error,
+		}
+	}
+	logger.Tracef("AuditEvents response %#v", resp)
+	return resp, nil
+}
+
+// auditEvent records the given event, performed by the authenticated
+// user, in the audit log. If err is not nil the event is recorded as
+// having failed.
+func (h *handler) auditEvent(ctx context.Context, e audit.Event, err error) {
+	if id := identityFromContext(ctx); id != nil {
+		e.Actor = id.Id()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	h.params.Audit(ctx, e)
+}
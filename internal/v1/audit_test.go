@@ -0,0 +1,240 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestAuditAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &auditSuite{})
+}
+
+type auditSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *auditSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.srv.CreateUser(c, "bob")
+}
+
+func (s *auditSuite) TestGroupsAndSSHKeysEvents(c *qt.C) {
+	err := s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+		Username: "bob",
+		Groups:   params.Groups{Groups: []string{"g1", "g2"}},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "bob",
+		Groups:   params.ModifyGroups{Remove: []string{"g1"}},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.PutSSHKeys(s.srv.Ctx, &params.PutSSHKeysRequest{
+		Username: "bob",
+		Body: params.PutSSHKeysBody{
+			SSHKeys: []string{"key1", "key2"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.DeleteSSHKeys(s.srv.Ctx, &params.DeleteSSHKeysRequest{
+		Username: "bob",
+		Body: params.DeleteSSHKeysBody{
+			SSHKeys: []string{"key1"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+		Username: "charlie",
+		Groups:   params.Groups{Groups: []string{"g1"}},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/u/charlie/groups: user charlie not found`)
+
+	events, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type: "set-groups",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripTimes(c, events), qt.DeepEquals, []params.AuditEvent{{
+		Type:  "set-groups",
+		Actor: "admin@candid",
+		User:  "bob",
+		Set:   []string{"g1", "g2"},
+		Add:   []string{"g1", "g2"},
+	}, {
+		Type:  "set-groups",
+		Actor: "admin@candid",
+		User:  "charlie",
+		Set:   []string{"g1"},
+		Error: `user charlie not found`,
+	}})
+
+	events, err = s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		User: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripTimes(c, events), qt.DeepEquals, []params.AuditEvent{{
+		Type:  "set-groups",
+		Actor: "admin@candid",
+		User:  "bob",
+		Set:   []string{"g1", "g2"},
+		Add:   []string{"g1", "g2"},
+	}, {
+		Type:   "modify-groups",
+		Actor:  "admin@candid",
+		User:   "bob",
+		Remove: []string{"g1"},
+	}, {
+		Type:  "put-ssh-keys",
+		Actor: "admin@candid",
+		User:  "bob",
+		Add:   []string{"key1", "key2"},
+	}, {
+		Type:   "delete-ssh-keys",
+		Actor:  "admin@candid",
+		User:   "bob",
+		Remove: []string{"key1"},
+	}})
+}
+
+func (s *auditSuite) TestModifyGroupsUntil(c *qt.C) {
+	until := time.Now().Add(time.Hour).Round(time.Millisecond).UTC()
+	err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "bob",
+		Groups: params.ModifyGroups{
+			Add:   []string{"g1"},
+			Until: &until,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	events, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type: "modify-groups",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Until, qt.Not(qt.IsNil))
+	c.Assert(events[0].Until.Equal(until), qt.IsTrue, qt.Commentf("got %v, want %v", events[0].Until, until))
+}
+
+func (s *auditSuite) TestCreateAgentEvent(c *qt.C) {
+	resp, err := s.adminClient.CreateAgent(s.srv.Ctx, &params.CreateAgentRequest{
+		CreateAgentBody: params.CreateAgentBody{
+			PublicKeys: []*bakery.PublicKey{&pk1},
+			Groups:     []string{"g1"},
+			Parent:     true,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	events, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type: "create-agent",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripTimes(c, events), qt.DeepEquals, []params.AuditEvent{{
+		Type:  "create-agent",
+		Actor: "admin@candid",
+		User:  string(resp.Username),
+		Set:   []string{"g1"},
+	}})
+}
+
+func (s *auditSuite) TestAuditEventsTimeRangeAndLimit(c *qt.C) {
+	for _, g := range []string{"g1", "g2", "g3"} {
+		err := s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+			Username: "bob",
+			Groups:   params.Groups{Groups: []string{g}},
+		})
+		c.Assert(err, qt.IsNil)
+	}
+	all, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type: "set-groups",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(all, qt.HasLen, 3)
+
+	events, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type:  "set-groups",
+		Limit: 2,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, all[:2])
+
+	after, err := all[1].Time.MarshalText()
+	c.Assert(err, qt.IsNil)
+	events, err = s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type:  "set-groups",
+		After: string(after),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, all[1:])
+
+	events, err = s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Type:   "set-groups",
+		Before: string(after),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, all[:1])
+}
+
+func (s *auditSuite) TestAuditEventsBadRequest(c *qt.C) {
+	_, err := s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		After: "yesterday",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/audit\?after=yesterday: cannot unmarshal after: .*`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+
+	_, err = s.adminClient.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{
+		Limit: -1,
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/audit\?limit=-1: invalid limit -1`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *auditSuite) TestAuditEventsPermissionDenied(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	_, err := client.AuditEvents(s.srv.Ctx, &params.AuditEventsRequest{})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/audit: permission denied`)
+}
+
+func (s *auditSuite) TestAuditEventsNotStored(c *qt.C) {
+	sp := s.store.ServerParams()
+	sp.AuditSink = nil
+	srv := candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	_, err := srv.AdminIdentityClient(false).AuditEvents(srv.Ctx, &params.AuditEventsRequest{})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/audit: audit log is not stored`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+// stripTimes checks that all the given events have a time and then
+// removes it so that the events can be compared.
+func stripTimes(c *qt.C, events []params.AuditEvent) []params.AuditEvent {
+	for i := range events {
+		c.Assert(events[i].Time.IsZero(), qt.Equals, false)
+		events[i].Time = time.Time{}
+	}
+	return events
+}
@@ -36,6 +36,8 @@ func opForRequest(r interface{}) bakery.Op {
 			return auth.GlobalOp(auth.ActionCreateParentAgent)
 		}
 		return auth.GlobalOp(auth.ActionCreateAgent)
+	case *params.DeleteUserRequest:
+		return auth.UserOp(r.Username, auth.ActionDelete)
 	case *params.UserGroupsRequest:
 		return auth.UserOp(r.Username, auth.ActionReadGroups)
 	case *params.SetUserGroupsRequest:
@@ -64,12 +66,66 @@ func opForRequest(r interface{}) bakery.Op {
 		return auth.UserOp(r.Username, auth.ActionReadAdmin)
 	case *params.SetUserExtraInfoItemRequest:
 		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.CreateLocalUserRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.SetLocalUserPasswordRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.SetLocalUserDisabledRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.DeleteLocalUserRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.ResetUserTOTPRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.WebAuthnRegistrationRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteCredentials)
+	case *params.RevokeUserTokensRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
+	case *params.SetUserSuspendedRequest:
+		return auth.UserOp(r.Username, auth.ActionWriteAdmin)
 	case *params.DischargeTokenForUserRequest:
 		return auth.GlobalOp(auth.ActionDischargeFor)
 	case *params.GetUserWithIDRequest:
 		return auth.UserIDOp(r.UserID, auth.ActionRead)
 	case *params.GetUserGroupsWithIDRequest:
 		return auth.UserIDOp(r.UserID, auth.ActionReadGroups)
+	case *params.RevokeUserTokensWithIDRequest:
+		return auth.UserIDOp(r.UserID, auth.ActionWriteAdmin)
+	case *params.AuditEventsRequest:
+		return auth.GlobalOp(auth.ActionReadAudit)
+	case *params.ReaperReportRequest:
+		return auth.GlobalOp(auth.ActionRead)
+	case *params.QueryGroupsRequest:
+		return auth.GlobalOp(auth.ActionReadGroups)
+	case *params.CreateGroupRequest:
+		return auth.GlobalOp(auth.ActionCreateGroup)
+	case *params.GroupRequest:
+		return auth.GroupOp(r.Name, auth.ActionRead)
+	case *params.DeleteGroupRequest:
+		return auth.GroupOp(r.Name, auth.ActionWriteAdmin)
+	case *params.SetGroupDescriptionRequest:
+		return auth.GroupOp(r.Name, auth.ActionWriteGroups)
+	case *params.SetGroupOwnersRequest:
+		return auth.GroupOp(r.Name, auth.ActionWriteAdmin)
+	case *params.ModifyMemberGroupsRequest:
+		return auth.GroupOp(r.Name, auth.ActionWriteGroups)
+	case *params.ModifyGroupMembersRequest:
+		return auth.GroupOp(r.Name, auth.ActionWriteGroups)
+	case *params.UserGroupHistoryRequest:
+		return auth.GlobalOp(auth.ActionReadAudit)
+	case *params.GroupHistoryRequest:
+		return auth.GlobalOp(auth.ActionReadAudit)
+	case *params.RequestElevationRequest:
+		return identchecker.LoginOp
+	case *params.ElevationsRequest:
+		return auth.GlobalOp(auth.ActionApproveElevation)
+	case *params.UserElevationsRequest:
+		return auth.UserOp(r.Username, auth.ActionReadGroups)
+	case *params.ApproveElevationRequest:
+		return auth.GlobalOp(auth.ActionApproveElevation)
+	case *params.DenyElevationRequest:
+		return auth.GlobalOp(auth.ActionApproveElevation)
+	case *params.RevokeElevationRequest:
+		return auth.GlobalOp(auth.ActionApproveElevation)
 	default:
 		logger.Infof("unknown API argument type %#v", r)
 	}
@@ -0,0 +1,246 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// RequestElevation records a request from the authenticated user to be
+// treated as a member of a group for a limited time. The request has no
+// effect until it is approved.
+func (h *handler) RequestElevation(p httprequest.Params, r *params.RequestElevationRequest) (*params.Elevation, error) {
+	logger.Tracef("RequestElevation %#v", r)
+	ctx := p.Context
+	id := identityFromContext(ctx)
+	if id == nil || id.Id() == "" {
+		// Should never happen, as the endpoint should require authentication.
+		return nil, errgo.Newf("no identity")
+	}
+	if r.Body.Group == "" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "no group specified")
+	}
+	d, err := time.ParseDuration(r.Body.Duration)
+	if err != nil || d <= 0 {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid duration %q", r.Body.Duration)
+	}
+	if d > h.params.MaxElevationDuration {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "duration %s exceeds the maximum of %s", d, h.params.MaxElevationDuration)
+	}
+	groups, err := id.Groups(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, g := range groups {
+		if g == r.Body.Group {
+			return nil, errgo.WithCausef(nil, params.ErrBadRequest, "already a member of %s", r.Body.Group)
+		}
+	}
+	now := time.Now()
+	existing, err := h.params.Store.FindElevations(ctx, store.ElevationFilter{
+		Username: id.Id(),
+		Group:    r.Body.Group,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, e := range existing {
+		if e.State == store.ElevationPending || e.Active(now) {
+			return nil, errgo.WithCausef(nil, params.ErrAlreadyExists, "elevation %s to %s is already %s", e.ID, e.Group, e.State)
+		}
+	}
+	e := store.Elevation{
+		Username:  id.Id(),
+		Group:     r.Body.Group,
+		Reason:    r.Body.Reason,
+		Duration:  d,
+		State:     store.ElevationPending,
+		Requested: now,
+	}
+	err = h.params.Store.AddElevation(ctx, &e)
+	h.auditEvent(ctx, audit.Event{
+		Type:  audit.RequestElevation,
+		User:  e.Username,
+		Group: e.Group,
+	}, err)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := elevationParams(e, now)
+	logger.Tracef("RequestElevation response %#v", resp)
+	return &resp, nil
+}
+
+// Elevations returns the elevations that match the given request, in
+// the order in which they were requested.
+func (h *handler) Elevations(p httprequest.Params, r *params.ElevationsRequest) ([]params.Elevation, error) {
+	logger.Tracef("Elevations %#v", r)
+	f := store.ElevationFilter{
+		Username: r.User,
+		Group:    r.Group,
+		State:    store.ElevationState(r.State),
+	}
+	switch f.State {
+	case "", store.ElevationPending, store.ElevationApproved, store.ElevationDenied, store.ElevationRevoked:
+	default:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid state %q", r.State)
+	}
+	resp, err := h.findElevations(p.Context, f)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	logger.Tracef("Elevations response %#v", resp)
+	return resp, nil
+}
+
+// UserElevations returns the elevations requested by the given user, in
+// the order in which they were requested.
+func (h *handler) UserElevations(p httprequest.Params, r *params.UserElevationsRequest) ([]params.Elevation, error) {
+	logger.Tracef("UserElevations %#v", r)
+	resp, err := h.findElevations(p.Context, store.ElevationFilter{
+		Username: string(r.Username),
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	logger.Tracef("UserElevations response %#v", resp)
+	return resp, nil
+}
+
+// ApproveElevation approves a pending elevation. Users may not approve
+// their own elevations.
+func (h *handler) ApproveElevation(p httprequest.Params, r *params.ApproveElevationRequest) (*params.Elevation, error) {
+	logger.Tracef("ApproveElevation %#v", r)
+	return h.decideElevation(p.Context, r.ID, store.ElevationPending, store.ElevationApproved)
+}
+
+// DenyElevation refuses a pending elevation.
+func (h *handler) DenyElevation(p httprequest.Params, r *params.DenyElevationRequest) (*params.Elevation, error) {
+	logger.Tracef("DenyElevation %#v", r)
+	return h.decideElevation(p.Context, r.ID, store.ElevationPending, store.ElevationDenied)
+}
+
+// RevokeElevation withdraws an approved elevation. The elevation stops
+// granting membership of its group immediately.
+func (h *handler) RevokeElevation(p httprequest.Params, r *params.RevokeElevationRequest) (*params.Elevation, error) {
+	logger.Tracef("RevokeElevation %#v", r)
+	return h.decideElevation(p.Context, r.ID, store.ElevationApproved, store.ElevationRevoked)
+}
+
+// decideElevation moves the elevation with the given ID from the state
+// from to the state to on behalf of the authenticated user.
+func (h *handler) decideElevation(ctx context.Context, id string, from, to store.ElevationState) (*params.Elevation, error) {
+	e := store.Elevation{
+		ID: id,
+	}
+	if err := h.params.Store.Elevation(ctx, &e); err != nil {
+		return nil, translateStoreError(err)
+	}
+	if e.State != from {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "elevation %s is %s", e.ID, e.State)
+	}
+	var approver string
+	if id := identityFromContext(ctx); id != nil {
+		approver = id.Id()
+	}
+	if to == store.ElevationApproved && approver == e.Username {
+		return nil, errgo.WithCausef(nil, params.ErrForbidden, "cannot approve own elevation")
+	}
+	now := time.Now()
+	ev := audit.Event{
+		User:  e.Username,
+		Group: e.Group,
+	}
+	// change records the effect of the decision on the user's
+	// membership of the group in the group history.
+	change := store.GroupChange{
+		Time:     now,
+		Actor:    approver,
+		Username: e.Username,
+		Group:    e.Group,
+	}
+	switch to {
+	case store.ElevationApproved:
+		ev.Type = audit.ApproveElevation
+		e.Expires = now.Add(e.Duration)
+		ev.Until = &e.Expires
+		change.Op = store.GroupAdd
+		change.Until = e.Expires
+	case store.ElevationDenied:
+		ev.Type = audit.DenyElevation
+	case store.ElevationRevoked:
+		ev.Type = audit.RevokeElevation
+		// Record when the elevation actually stopped granting
+		// membership.
+		if now.Before(e.Expires) {
+			e.Expires = now
+			change.Op = store.GroupRemove
+		}
+	}
+	e.State = to
+	e.Approver = approver
+	e.Decided = now
+	err := h.params.Store.UpdateElevation(ctx, &e, from)
+	h.auditEvent(ctx, ev, err)
+	if err != nil {
+		return nil, translateStoreError(err)
+	}
+	if change.Op != "" {
+		if err := h.params.Store.AddGroupChanges(ctx, []store.GroupChange{change}); err != nil {
+			// The decision has been made, so don't fail the
+			// request.
+			logger.Errorf("cannot record group change for elevation %s: %s", e.ID, err)
+		}
+	}
+	resp := elevationParams(e, now)
+	return &resp, nil
+}
+
+// findElevations returns the elevations that match the given filter.
+func (h *handler) findElevations(ctx context.Context, f store.ElevationFilter) ([]params.Elevation, error) {
+	elevations, err := h.params.Store.FindElevations(ctx, f)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	now := time.Now()
+	resp := make([]params.Elevation, len(elevations))
+	for i, e := range elevations {
+		resp[i] = elevationParams(e, now)
+	}
+	return resp, nil
+}
+
+// elevationParams converts the given store.Elevation into a
+// params.Elevation. The elevation is reported as active if it grants
+// membership at the given time.
+func elevationParams(e store.Elevation, now time.Time) params.Elevation {
+	pe := params.Elevation{
+		ID:        e.ID,
+		User:      params.Username(e.Username),
+		Group:     e.Group,
+		Reason:    e.Reason,
+		Duration:  e.Duration.String(),
+		State:     string(e.State),
+		Requested: e.Requested,
+		Approver:  e.Approver,
+		Active:    e.Active(now),
+	}
+	if !e.Decided.IsZero() {
+		decided := e.Decided
+		pe.Decided = &decided
+	}
+	if !e.Expires.IsZero() {
+		expires := e.Expires
+		pe.Expires = &expires
+	}
+	return pe
+}
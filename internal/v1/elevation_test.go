@@ -0,0 +1,273 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestElevationAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &elevationSuite{})
+}
+
+type elevationSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+	bobClient   *candidclient.Client
+}
+
+func (s *elevationSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.MaxElevationDuration = 4 * time.Hour
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.bobClient = s.srv.IdentityClient(c, "bob@candid", "dev")
+}
+
+func (s *elevationSuite) TestApproveElevation(c *qt.C) {
+	e, err := s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Reason:   "incident 42",
+			Duration: "2h",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.ID, qt.Not(qt.Equals), "")
+	c.Assert(e.Requested.IsZero(), qt.Equals, false)
+	c.Assert(e, qt.DeepEquals, &params.Elevation{
+		ID:        e.ID,
+		User:      "bob@candid",
+		Group:     "prod-admin",
+		Reason:    "incident 42",
+		Duration:  "2h0m0s",
+		State:     "pending",
+		Requested: e.Requested,
+	})
+
+	// The requester can see their own elevations.
+	elevations, err := s.bobClient.UserElevations(s.srv.Ctx, &params.UserElevationsRequest{
+		Username: "bob@candid",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(elevations, qt.HasLen, 1)
+	c.Assert(elevations[0].ID, qt.Equals, e.ID)
+
+	// Approvers can see the pending elevations.
+	elevations, err = s.adminClient.Elevations(s.srv.Ctx, &params.ElevationsRequest{
+		State: "pending",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(elevations, qt.HasLen, 1)
+	c.Assert(elevations[0].ID, qt.Equals, e.ID)
+
+	before := time.Now()
+	approved, err := s.adminClient.ApproveElevation(s.srv.Ctx, &params.ApproveElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(approved.State, qt.Equals, "approved")
+	c.Assert(approved.Approver, qt.Equals, "admin@candid")
+	c.Assert(approved.Active, qt.Equals, true)
+	c.Assert(approved.Decided, qt.Not(qt.IsNil))
+	c.Assert(approved.Expires, qt.Not(qt.IsNil))
+	c.Assert(approved.Expires.Sub(*approved.Decided), qt.Equals, 2*time.Hour)
+	c.Assert(approved.Decided.Before(before), qt.Equals, false)
+
+	// An elevation can only be decided once.
+	_, err = s.adminClient.DenyElevation(s.srv.Ctx, &params.DenyElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations/.*/deny: elevation .* is approved`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+
+	// A second request for the same group is refused while the
+	// elevation is active.
+	_, err = s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Duration: "1h",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations: elevation .* to prod-admin is already approved`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+
+	revoked, err := s.adminClient.RevokeElevation(s.srv.Ctx, &params.RevokeElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(revoked.State, qt.Equals, "revoked")
+	c.Assert(revoked.Active, qt.Equals, false)
+	c.Assert(revoked.Expires.Before(*approved.Expires), qt.Equals, true)
+
+	// The approval and revocation are recorded in the group
+	// history.
+	changes, err := s.adminClient.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name: "prod-admin",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 2)
+	c.Assert(changes[0].Time.Equal(*approved.Decided), qt.Equals, true)
+	c.Assert(changes[0].Until, qt.Not(qt.IsNil))
+	c.Assert(changes[0].Until.Equal(*approved.Expires), qt.Equals, true)
+	c.Assert(changes[1].Time.Equal(*revoked.Expires), qt.Equals, true)
+	for i := range changes {
+		changes[i].Time = time.Time{}
+	}
+	changes[0].Until = nil
+	c.Assert(changes, qt.DeepEquals, []params.GroupChange{{
+		Actor: "admin@candid",
+		User:  "bob@candid",
+		Group: "prod-admin",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob@candid",
+		Group: "prod-admin",
+		Op:    "remove",
+	}})
+
+	// Once revoked, the group may be requested again.
+	_, err = s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Duration: "1h",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *elevationSuite) TestDenyElevation(c *qt.C) {
+	e, err := s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Duration: "30m",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	denied, err := s.adminClient.DenyElevation(s.srv.Ctx, &params.DenyElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(denied.State, qt.Equals, "denied")
+	c.Assert(denied.Approver, qt.Equals, "admin@candid")
+	c.Assert(denied.Active, qt.Equals, false)
+	c.Assert(denied.Expires, qt.IsNil)
+
+	_, err = s.adminClient.RevokeElevation(s.srv.Ctx, &params.RevokeElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations/.*/revoke: elevation .* is denied`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *elevationSuite) TestApproveOwnElevation(c *qt.C) {
+	e, err := s.adminClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Duration: "1h",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.adminClient.ApproveElevation(s.srv.Ctx, &params.ApproveElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations/.*/approve: cannot approve own elevation`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrForbidden)
+}
+
+func (s *elevationSuite) TestApproveElevationNotFound(c *qt.C) {
+	_, err := s.adminClient.ApproveElevation(s.srv.Ctx, &params.ApproveElevationRequest{
+		ID: "1000",
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations/1000/approve: elevation 1000 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+var requestElevationErrorTests = []struct {
+	about       string
+	body        params.RequestElevationBody
+	expectError string
+}{{
+	about:       "no group",
+	body:        params.RequestElevationBody{Duration: "1h"},
+	expectError: `Post http://.*/v1/elevations: no group specified`,
+}, {
+	about:       "no duration",
+	body:        params.RequestElevationBody{Group: "prod-admin"},
+	expectError: `Post http://.*/v1/elevations: invalid duration ""`,
+}, {
+	about:       "invalid duration",
+	body:        params.RequestElevationBody{Group: "prod-admin", Duration: "a while"},
+	expectError: `Post http://.*/v1/elevations: invalid duration "a while"`,
+}, {
+	about:       "negative duration",
+	body:        params.RequestElevationBody{Group: "prod-admin", Duration: "-1h"},
+	expectError: `Post http://.*/v1/elevations: invalid duration "-1h"`,
+}, {
+	about:       "duration too long",
+	body:        params.RequestElevationBody{Group: "prod-admin", Duration: "5h"},
+	expectError: `Post http://.*/v1/elevations: duration 5h0m0s exceeds the maximum of 4h0m0s`,
+}, {
+	about:       "already a member",
+	body:        params.RequestElevationBody{Group: "dev", Duration: "1h"},
+	expectError: `Post http://.*/v1/elevations: already a member of dev`,
+}}
+
+func (s *elevationSuite) TestRequestElevationErrors(c *qt.C) {
+	for _, test := range requestElevationErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			_, err := s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+				Body: test.body,
+			})
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+		})
+	}
+}
+
+func (s *elevationSuite) TestElevationsInvalidState(c *qt.C) {
+	_, err := s.adminClient.Elevations(s.srv.Ctx, &params.ElevationsRequest{
+		State: "nosuch",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/elevations\?state=nosuch: invalid state "nosuch"`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *elevationSuite) TestElevationsUnauthorized(c *qt.C) {
+	e, err := s.bobClient.RequestElevation(s.srv.Ctx, &params.RequestElevationRequest{
+		Body: params.RequestElevationBody{
+			Group:    "prod-admin",
+			Duration: "1h",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.bobClient.Elevations(s.srv.Ctx, &params.ElevationsRequest{})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/elevations: permission denied`)
+	_, err = s.bobClient.ApproveElevation(s.srv.Ctx, &params.ApproveElevationRequest{
+		ID: e.ID,
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/elevations/.*/approve: permission denied`)
+	_, err = s.bobClient.UserElevations(s.srv.Ctx, &params.UserElevationsRequest{
+		Username: "admin@candid",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/u/admin@candid/elevations: permission denied`)
+}
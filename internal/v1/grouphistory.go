@@ -0,0 +1,85 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// UserGroupHistory returns the changes that have been made to the group
+// memberships of the given user, in the order in which they were made.
+// The history is kept after the user has been deleted.
+func (h *handler) UserGroupHistory(p httprequest.Params, r *params.UserGroupHistoryRequest) ([]params.GroupChange, error) {
+	logger.Tracef("UserGroupHistory %#v", r)
+	f := store.GroupChangeFilter{
+		Username: string(r.Username),
+	}
+	resp, err := h.groupChanges(p.Context, f, r.After, r.Before, r.Limit)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	logger.Tracef("UserGroupHistory response %#v", resp)
+	return resp, nil
+}
+
+// GroupHistory returns the changes that have been made to the members
+// and member groups of the given group, in the order in which they were
+// made. The history is kept after the group has been deleted.
+func (h *handler) GroupHistory(p httprequest.Params, r *params.GroupHistoryRequest) ([]params.GroupChange, error) {
+	logger.Tracef("GroupHistory %#v", r)
+	f := store.GroupChangeFilter{
+		Group: r.Name,
+	}
+	resp, err := h.groupChanges(p.Context, f, r.After, r.Before, r.Limit)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	logger.Tracef("GroupHistory response %#v", resp)
+	return resp, nil
+}
+
+// groupChanges returns the group changes that match the given filter
+// restricted to the given time range and limit.
+func (h *handler) groupChanges(ctx context.Context, f store.GroupChangeFilter, after, before string, limit int) ([]params.GroupChange, error) {
+	if limit < 0 {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid limit %d", limit)
+	}
+	f.Limit = limit
+	if after != "" {
+		if err := f.After.UnmarshalText([]byte(after)); err != nil {
+			return nil, errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal after")
+		}
+	}
+	if before != "" {
+		if err := f.Before.UnmarshalText([]byte(before)); err != nil {
+			return nil, errgo.WithCausef(err, params.ErrBadRequest, "cannot unmarshal before")
+		}
+	}
+	changes, err := h.params.Store.GroupChanges(ctx, f)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := make([]params.GroupChange, len(changes))
+	for i, gc := range changes {
+		resp[i] = params.GroupChange{
+			Time:        gc.Time,
+			Actor:       gc.Actor,
+			User:        params.Username(gc.Username),
+			MemberGroup: gc.MemberGroup,
+			Group:       gc.Group,
+			Op:          string(gc.Op),
+		}
+		if !gc.Until.IsZero() {
+			until := gc.Until
+			resp[i].Until = &until
+		}
+	}
+	return resp, nil
+}
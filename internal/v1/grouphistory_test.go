@@ -0,0 +1,271 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestGroupHistoryAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &groupHistorySuite{})
+}
+
+type groupHistorySuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *groupHistorySuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.srv.CreateUser(c, "bob", "g1")
+	s.srv.CreateUser(c, "alice")
+}
+
+func (s *groupHistorySuite) TestUserGroupHistory(c *qt.C) {
+	err := s.adminClient.SetUserGroups(s.srv.Ctx, &params.SetUserGroupsRequest{
+		Username: "bob",
+		Groups:   params.Groups{Groups: []string{"g2", "g3"}},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "bob",
+		Groups:   params.ModifyGroups{Remove: []string{"g2"}},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "alice",
+		Groups:   params.ModifyGroups{Add: []string{"g3"}},
+	})
+	c.Assert(err, qt.IsNil)
+
+	changes, err := s.adminClient.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripGroupChangeTimes(c, changes), qt.DeepEquals, []params.GroupChange{{
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "g2",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "g3",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "g1",
+		Op:    "remove",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "g2",
+		Op:    "remove",
+	}})
+}
+
+func (s *groupHistorySuite) TestGroupHistory(c *qt.C) {
+	err := s.adminClient.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+		Group: params.Group{Name: "prod-admin"},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyGroupMembers(s.srv.Ctx, &params.ModifyGroupMembersRequest{
+		Name: "prod-admin",
+		Members: params.ModifyGroupMembers{
+			Add: []params.Username{"alice", "bob"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// The history remains after the user has been deleted.
+	changes, err := s.adminClient.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name: "prod-admin",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripGroupChangeTimes(c, changes), qt.DeepEquals, []params.GroupChange{{
+		Actor: "admin@candid",
+		User:  "alice",
+		Group: "prod-admin",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "prod-admin",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "bob",
+		Group: "prod-admin",
+		Op:    "remove",
+	}})
+}
+
+func (s *groupHistorySuite) TestGroupHistoryMemberGroups(c *qt.C) {
+	err := s.adminClient.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+		Group: params.Group{
+			Name:         "prod-admin",
+			MemberGroups: []string{"sre"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyMemberGroups(s.srv.Ctx, &params.ModifyMemberGroupsRequest{
+		Name: "prod-admin",
+		Groups: params.ModifyGroups{
+			Add: []string{"dba"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.ModifyMemberGroups(s.srv.Ctx, &params.ModifyMemberGroupsRequest{
+		Name: "prod-admin",
+		Groups: params.ModifyGroups{
+			Remove: []string{"sre"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.adminClient.DeleteGroup(s.srv.Ctx, &params.DeleteGroupRequest{
+		Name: "prod-admin",
+	})
+	c.Assert(err, qt.IsNil)
+
+	changes, err := s.adminClient.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name: "prod-admin",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripGroupChangeTimes(c, changes), qt.DeepEquals, []params.GroupChange{{
+		Actor:       "admin@candid",
+		MemberGroup: "sre",
+		Group:       "prod-admin",
+		Op:          "add",
+	}, {
+		Actor:       "admin@candid",
+		MemberGroup: "dba",
+		Group:       "prod-admin",
+		Op:          "add",
+	}, {
+		Actor:       "admin@candid",
+		MemberGroup: "sre",
+		Group:       "prod-admin",
+		Op:          "remove",
+	}, {
+		Actor:       "admin@candid",
+		MemberGroup: "dba",
+		Group:       "prod-admin",
+		Op:          "remove",
+	}})
+}
+
+func (s *groupHistorySuite) TestGroupHistoryTimeRange(c *qt.C) {
+	modify := func(m params.ModifyGroups) time.Time {
+		err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+			Username: "alice",
+			Groups:   m,
+		})
+		c.Assert(err, qt.IsNil)
+		changes, err := s.adminClient.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+			Username: "alice",
+		})
+		c.Assert(err, qt.IsNil)
+		return changes[len(changes)-1].Time
+	}
+	t0 := modify(params.ModifyGroups{Add: []string{"g1"}})
+	t1 := modify(params.ModifyGroups{Add: []string{"g2"}})
+	t2 := modify(params.ModifyGroups{Remove: []string{"g1"}})
+
+	after, err := t1.MarshalText()
+	c.Assert(err, qt.IsNil)
+	before, err := t2.MarshalText()
+	c.Assert(err, qt.IsNil)
+	changes, err := s.adminClient.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name:   "g2",
+		After:  string(after),
+		Before: string(before),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 1)
+	c.Assert(changes[0].Time.Equal(t1), qt.Equals, true)
+
+	changes, err = s.adminClient.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+		Username: "alice",
+		After:    string(after),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(stripGroupChangeTimes(c, changes), qt.DeepEquals, []params.GroupChange{{
+		Actor: "admin@candid",
+		User:  "alice",
+		Group: "g2",
+		Op:    "add",
+	}, {
+		Actor: "admin@candid",
+		User:  "alice",
+		Group: "g1",
+		Op:    "remove",
+	}})
+
+	changes, err = s.adminClient.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+		Username: "alice",
+		Limit:    1,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 1)
+	c.Assert(changes[0].Time.Equal(t0), qt.Equals, true)
+}
+
+func (s *groupHistorySuite) TestGroupHistoryBadRequest(c *qt.C) {
+	_, err := s.adminClient.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+		Username: "bob",
+		After:    "yesterday",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/u/bob/groups/history\?after=yesterday: cannot unmarshal after: .*`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+
+	_, err = s.adminClient.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name:  "g1",
+		Limit: -1,
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/g/g1/history\?limit=-1: invalid limit -1`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *groupHistorySuite) TestGroupHistoryUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	_, err := client.UserGroupHistory(s.srv.Ctx, &params.UserGroupHistoryRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/u/bob/groups/history: permission denied`)
+	_, err = client.GroupHistory(s.srv.Ctx, &params.GroupHistoryRequest{
+		Name: "g1",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/g/g1/history: permission denied`)
+}
+
+func stripGroupChangeTimes(c *qt.C, changes []params.GroupChange) []params.GroupChange {
+	for i := range changes {
+		c.Assert(changes[i].Time.IsZero(), qt.Equals, false)
+		changes[i].Time = time.Time{}
+	}
+	return changes
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// QueryGroups lists all the groups stored in the identity server.
+func (h *handler) QueryGroups(p httprequest.Params, r *params.QueryGroupsRequest) ([]params.Group, error) {
+	logger.Tracef("QueryGroups %#v", r)
+	groups, err := h.params.Store.FindGroups(p.Context)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := make([]params.Group, len(groups))
+	for i, g := range groups {
+		resp[i] = groupToParams(g)
+	}
+	logger.Tracef("QueryGroups response %#v", resp)
+	return resp, nil
+}
+
+// CreateGroup creates a new group.
+func (h *handler) CreateGroup(p httprequest.Params, r *params.CreateGroupRequest) error {
+	logger.Tracef("CreateGroup %#v", r)
+	if err := checkGroupName(r.Group.Name); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	if err := checkMemberGroups(r.Group.Name, r.Group.MemberGroups); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	err := h.params.Store.AddGroup(p.Context, &store.Group{
+		Name:         r.Group.Name,
+		Description:  r.Group.Description,
+		Owners:       r.Group.Owners,
+		MemberGroups: r.Group.MemberGroups,
+	})
+	h.auditEvent(p.Context, audit.Event{
+		Type:  audit.CreateGroup,
+		Group: r.Group.Name,
+	}, err)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("CreateGroup complete")
+	return nil
+}
+
+// Group returns the details of the given group.
+func (h *handler) Group(p httprequest.Params, r *params.GroupRequest) (*params.Group, error) {
+	logger.Tracef("Group %#v", r)
+	group := store.Group{
+		Name: r.Name,
+	}
+	if err := h.params.Store.Group(p.Context, &group); err != nil {
+		return nil, translateStoreError(err)
+	}
+	resp := groupToParams(group)
+	logger.Tracef("Group response %#v", resp)
+	return &resp, nil
+}
+
+// DeleteGroup deletes the given group. Any users that are members of
+// the group remain so.
+func (h *handler) DeleteGroup(p httprequest.Params, r *params.DeleteGroupRequest) error {
+	logger.Tracef("DeleteGroup %#v", r)
+	err := h.params.Store.RemoveGroup(p.Context, r.Name)
+	h.auditEvent(p.Context, audit.Event{
+		Type:  audit.DeleteGroup,
+		Group: r.Name,
+	}, err)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("DeleteGroup complete")
+	return nil
+}
+
+// SetGroupDescription sets the description of the given group.
+func (h *handler) SetGroupDescription(p httprequest.Params, r *params.SetGroupDescriptionRequest) error {
+	logger.Tracef("SetGroupDescription %#v", r)
+	var update store.GroupUpdate
+	update[store.GroupDescription] = store.Set
+	if r.Body.Description == "" {
+		update[store.GroupDescription] = store.Clear
+	}
+	err := h.params.Store.UpdateGroup(p.Context, &store.Group{
+		Name:        r.Name,
+		Description: r.Body.Description,
+	}, update)
+	h.auditEvent(p.Context, audit.Event{
+		Type:  audit.SetGroupDescription,
+		Group: r.Name,
+		Set:   []string{r.Body.Description},
+	}, err)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("SetGroupDescription complete")
+	return nil
+}
+
+// SetGroupOwners replaces the owners of the given group.
+func (h *handler) SetGroupOwners(p httprequest.Params, r *params.SetGroupOwnersRequest) error {
+	logger.Tracef("SetGroupOwners %#v", r)
+	err := h.params.Store.UpdateGroup(p.Context, &store.Group{
+		Name:   r.Name,
+		Owners: r.Body.Owners,
+	}, store.GroupUpdate{
+		store.GroupOwners: store.Set,
+	})
+	h.auditEvent(p.Context, audit.Event{
+		Type:  audit.SetGroupOwners,
+		Group: r.Name,
+		Set:   r.Body.Owners,
+	}, err)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("SetGroupOwners complete")
+	return nil
+}
+
+// ModifyMemberGroups adds groups to, or removes groups from, the member
+// groups of the given group.
+func (h *handler) ModifyMemberGroups(p httprequest.Params, r *params.ModifyMemberGroupsRequest) error {
+	logger.Tracef("ModifyMemberGroups %#v", r)
+	group := store.Group{
+		Name: r.Name,
+	}
+	var update store.GroupUpdate
+	if len(r.Groups.Add) > 0 && len(r.Groups.Remove) > 0 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot add and remove groups in the same operation")
+	}
+	if len(r.Groups.Add) > 0 {
+		if err := checkMemberGroups(r.Name, r.Groups.Add); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		group.MemberGroups = r.Groups.Add
+		update[store.GroupMemberGroups] = store.Push
+	} else {
+		group.MemberGroups = r.Groups.Remove
+		update[store.GroupMemberGroups] = store.Pull
+	}
+	err := h.params.Store.UpdateGroup(p.Context, &group, update)
+	h.auditEvent(p.Context, audit.Event{
+		Type:   audit.ModifyMemberGroups,
+		Group:  r.Name,
+		Add:    r.Groups.Add,
+		Remove: r.Groups.Remove,
+	}, err)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("ModifyMemberGroups complete")
+	return nil
+}
+
+// ModifyGroupMembers adds users to, or removes users from, the given
+// group. This allows the owners of a group to manage its membership
+// without being able to change any other groups of the users.
+func (h *handler) ModifyGroupMembers(p httprequest.Params, r *params.ModifyGroupMembersRequest) error {
+	logger.Tracef("ModifyGroupMembers %#v", r)
+	if err := h.params.Store.Group(p.Context, &store.Group{Name: r.Name}); err != nil {
+		return translateStoreError(err)
+	}
+	for _, u := range r.Members.Add {
+		if err := h.modifyGroupMember(p.Context, u, r.Name, store.Push); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+	}
+	for _, u := range r.Members.Remove {
+		if err := h.modifyGroupMember(p.Context, u, r.Name, store.Pull); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+	}
+	logger.Tracef("ModifyGroupMembers complete")
+	return nil
+}
+
+func (h *handler) modifyGroupMember(ctx context.Context, username params.Username, group string, op store.Operation) error {
+	err := h.params.Store.UpdateIdentity(ctx, &store.Identity{
+		Username:    string(username),
+		Groups:      []string{group},
+		GroupExpiry: map[string]time.Time{group: {}},
+	}, store.Update{
+		store.Groups: op,
+		// Membership added this way does not expire.
+		store.GroupExpiry: store.Clear,
+	})
+	e := audit.Event{
+		Type: audit.ModifyGroups,
+		User: string(username),
+	}
+	if op == store.Push {
+		e.Add = []string{group}
+	} else {
+		e.Remove = []string{group}
+	}
+	h.auditEvent(ctx, e, err)
+	return translateStoreError(err)
+}
+
+// checkGroupName checks that the given name is valid for a group.
+// Group names may not be empty or contain white space as they are
+// used in space separated lists.
+func checkGroupName(name string) error {
+	if name == "" {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "group name not specified")
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) != -1 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid group name %q", name)
+	}
+	return nil
+}
+
+// checkMemberGroups checks that the given member groups are valid for
+// the group with the given name.
+func checkMemberGroups(name string, groups []string) error {
+	for _, g := range groups {
+		if g == name {
+			return errgo.WithCausef(nil, params.ErrBadRequest, "group %s cannot be a member of itself", name)
+		}
+		if err := checkGroupName(g); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+	}
+	return nil
+}
+
+func groupToParams(g store.Group) params.Group {
+	return params.Group{
+		Name:         g.Name,
+		Description:  g.Description,
+		Owners:       g.Owners,
+		MemberGroups: g.MemberGroups,
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestGroupsAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &groupsSuite{})
+}
+
+type groupsSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *groupsSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.srv.CreateUser(c, "bob", "existing")
+}
+
+func (s *groupsSuite) TestCreateGroup(c *qt.C) {
+	s.createGroup(c, params.Group{
+		Name:         "group1",
+		Description:  "The first group",
+		Owners:       []string{"alice@candid"},
+		MemberGroups: []string{"group2"},
+	})
+	g, err := s.adminClient.Group(s.srv.Ctx, &params.GroupRequest{
+		Name: "group1",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(g, qt.DeepEquals, &params.Group{
+		Name:         "group1",
+		Description:  "The first group",
+		Owners:       []string{"alice@candid"},
+		MemberGroups: []string{"group2"},
+	})
+
+	events, err := s.store.AuditStore.Events(s.srv.Ctx, audit.Filter{Type: audit.CreateGroup})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 1)
+	c.Assert(events[0].Actor, qt.Equals, "admin@candid")
+	c.Assert(events[0].Group, qt.Equals, "group1")
+}
+
+func (s *groupsSuite) TestCreateGroupDuplicate(c *qt.C) {
+	s.createGroup(c, params.Group{Name: "group1"})
+	err := s.adminClient.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+		Group: params.Group{Name: "group1"},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g: group group1 already exists`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+}
+
+func (s *groupsSuite) TestCreateGroupInvalid(c *qt.C) {
+	tests := []struct {
+		group       params.Group
+		expectError string
+	}{{
+		group:       params.Group{},
+		expectError: `Post http://.*/v1/g: group name not specified`,
+	}, {
+		group:       params.Group{Name: "a group"},
+		expectError: `Post http://.*/v1/g: invalid group name "a group"`,
+	}, {
+		group:       params.Group{Name: "group1", MemberGroups: []string{"group1"}},
+		expectError: `Post http://.*/v1/g: group group1 cannot be a member of itself`,
+	}}
+	for _, test := range tests {
+		err := s.adminClient.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+			Group: test.group,
+		})
+		c.Check(err, qt.ErrorMatches, test.expectError)
+		c.Check(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+	}
+}
+
+func (s *groupsSuite) TestCreateGroupPermissionDenied(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+		Group: params.Group{Name: "group1"},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g: permission denied`)
+}
+
+func (s *groupsSuite) TestQueryGroups(c *qt.C) {
+	groups, err := s.adminClient.QueryGroups(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+
+	s.createGroup(c, params.Group{Name: "b", Description: "group b"})
+	s.createGroup(c, params.Group{Name: "a", MemberGroups: []string{"b"}})
+	groups, err = s.adminClient.QueryGroups(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []params.Group{{
+		Name:         "a",
+		MemberGroups: []string{"b"},
+	}, {
+		Name:        "b",
+		Description: "group b",
+	}})
+}
+
+func (s *groupsSuite) TestGroupNotFound(c *qt.C) {
+	_, err := s.adminClient.Group(s.srv.Ctx, &params.GroupRequest{
+		Name: "no-such-group",
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/g/no-such-group: group no-such-group not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *groupsSuite) TestDeleteGroup(c *qt.C) {
+	s.createGroup(c, params.Group{Name: "existing"})
+	err := s.adminClient.DeleteGroup(s.srv.Ctx, &params.DeleteGroupRequest{
+		Name: "existing",
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.adminClient.Group(s.srv.Ctx, &params.GroupRequest{
+		Name: "existing",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+
+	// Users that were members of the group remain so.
+	groups, err := s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"existing"})
+
+	err = s.adminClient.DeleteGroup(s.srv.Ctx, &params.DeleteGroupRequest{
+		Name: "existing",
+	})
+	c.Assert(err, qt.ErrorMatches, `Delete http://.*/v1/g/existing: group existing not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *groupsSuite) TestNestedGroupMembership(c *qt.C) {
+	s.createGroup(c, params.Group{Name: "staff", MemberGroups: []string{"engineering"}})
+	s.createGroup(c, params.Group{Name: "engineering"})
+	err := s.adminClient.ModifyMemberGroups(s.srv.Ctx, &params.ModifyMemberGroupsRequest{
+		Name: "engineering",
+		Groups: params.ModifyGroups{
+			Add: []string{"existing"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	groups, err := s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"existing", "engineering", "staff"})
+
+	err = s.adminClient.ModifyMemberGroups(s.srv.Ctx, &params.ModifyMemberGroupsRequest{
+		Name: "engineering",
+		Groups: params.ModifyGroups{
+			Remove: []string{"existing"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	groups, err = s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"existing"})
+}
+
+func (s *groupsSuite) TestModifyMemberGroupsAddAndRemove(c *qt.C) {
+	s.createGroup(c, params.Group{Name: "group1"})
+	err := s.adminClient.ModifyMemberGroups(s.srv.Ctx, &params.ModifyMemberGroupsRequest{
+		Name: "group1",
+		Groups: params.ModifyGroups{
+			Add:    []string{"group2"},
+			Remove: []string{"group3"},
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g/group1/groups: cannot add and remove groups in the same operation`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *groupsSuite) TestOwnerCanManageGroup(c *qt.C) {
+	s.srv.CreateUser(c, "charlie")
+	s.createGroup(c, params.Group{
+		Name:   "group1",
+		Owners: []string{"managers"},
+	})
+	s.createGroup(c, params.Group{
+		Name:         "managers",
+		MemberGroups: []string{"leads"},
+	})
+	// alice is an owner by virtue of being in a member group of
+	// the owning group.
+	client := s.srv.IdentityClient(c, "alice@candid", "leads")
+
+	g, err := client.Group(s.srv.Ctx, &params.GroupRequest{
+		Name: "group1",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(g.Name, qt.Equals, "group1")
+
+	err = client.SetGroupDescription(s.srv.Ctx, &params.SetGroupDescriptionRequest{
+		Name: "group1",
+		Body: params.GroupDescription{
+			Description: "managed by alice",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = client.ModifyGroupMembers(s.srv.Ctx, &params.ModifyGroupMembersRequest{
+		Name: "group1",
+		Members: params.ModifyGroupMembers{
+			Add:    []params.Username{"bob", "charlie"},
+			Remove: []params.Username{"charlie"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	groups, err := s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"existing", "group1"})
+	groups, err = s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "charlie",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{})
+
+	// Owners cannot change the ownership of the group, or delete it.
+	err = client.SetGroupOwners(s.srv.Ctx, &params.SetGroupOwnersRequest{
+		Name: "group1",
+		Body: params.GroupOwners{
+			Owners: []string{"alice@candid"},
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/g/group1/owners: permission denied`)
+	err = client.DeleteGroup(s.srv.Ctx, &params.DeleteGroupRequest{
+		Name: "group1",
+	})
+	c.Assert(err, qt.ErrorMatches, `Delete http://.*/v1/g/group1: permission denied`)
+
+	// Owners cannot manage other groups.
+	err = client.ModifyGroupMembers(s.srv.Ctx, &params.ModifyGroupMembersRequest{
+		Name: "managers",
+		Members: params.ModifyGroupMembers{
+			Add: []params.Username{"bob"},
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g/managers/members: permission denied`)
+}
+
+func (s *groupsSuite) TestSetGroupOwners(c *qt.C) {
+	s.createGroup(c, params.Group{
+		Name:   "group1",
+		Owners: []string{"alice@candid"},
+	})
+	err := s.adminClient.SetGroupOwners(s.srv.Ctx, &params.SetGroupOwnersRequest{
+		Name: "group1",
+		Body: params.GroupOwners{
+			Owners: []string{"bob", "charlie"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	g, err := s.adminClient.Group(s.srv.Ctx, &params.GroupRequest{
+		Name: "group1",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(g.Owners, qt.DeepEquals, []string{"bob", "charlie"})
+}
+
+func (s *groupsSuite) TestModifyGroupMembersNotFound(c *qt.C) {
+	err := s.adminClient.ModifyGroupMembers(s.srv.Ctx, &params.ModifyGroupMembersRequest{
+		Name: "no-such-group",
+		Members: params.ModifyGroupMembers{
+			Add: []params.Username{"bob"},
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g/no-such-group/members: group no-such-group not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+
+	s.createGroup(c, params.Group{Name: "group1"})
+	err = s.adminClient.ModifyGroupMembers(s.srv.Ctx, &params.ModifyGroupMembersRequest{
+		Name: "group1",
+		Members: params.ModifyGroupMembers{
+			Add: []params.Username{"nobody"},
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/g/group1/members: user nobody not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *groupsSuite) createGroup(c *qt.C, g params.Group) {
+	err := s.adminClient.CreateGroup(s.srv.Ctx, &params.CreateGroupRequest{
+		Group: g,
+	})
+	c.Assert(err, qt.IsNil)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/idp/local"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// CreateLocalUser creates a new user in a local identity provider.
+func (h *handler) CreateLocalUser(p httprequest.Params, r *params.CreateLocalUserRequest) error {
+	// Note: the request is not logged as it contains a password.
+	logger.Tracef("CreateLocalUser %q", r.Username)
+	if blacklistUsernames[r.Username] {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "username %q is reserved", r.Username)
+	}
+	idp, err := h.localIDP(r.Body.IDP)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	err = idp.CreateUser(p.Context, local.User{
+		Username: string(r.Username),
+		Name:     r.Body.FullName,
+		Email:    r.Body.Email,
+		Groups:   r.Body.Groups,
+		Password: r.Body.Password,
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrAlreadyExists))
+	}
+	logger.Tracef("CreateLocalUser complete")
+	return nil
+}
+
+// SetLocalUserPassword sets the password of a user in a local identity
+// provider.
+func (h *handler) SetLocalUserPassword(p httprequest.Params, r *params.SetLocalUserPasswordRequest) error {
+	// Note: the request is not logged as it contains a password.
+	logger.Tracef("SetLocalUserPassword %q", r.Username)
+	idp, err := h.localIDPForUser(p.Context, r.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	if err := idp.SetPassword(p.Context, string(r.Username), r.Body.Password); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	logger.Tracef("SetLocalUserPassword complete")
+	return nil
+}
+
+// SetLocalUserDisabled disables, or re-enables, a user in a local
+// identity provider. A disabled user cannot log in.
+func (h *handler) SetLocalUserDisabled(p httprequest.Params, r *params.SetLocalUserDisabledRequest) error {
+	logger.Tracef("SetLocalUserDisabled %#v", r)
+	idp, err := h.localIDPForUser(p.Context, r.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	if err := idp.SetDisabled(p.Context, string(r.Username), r.Body.Disabled); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	logger.Tracef("SetLocalUserDisabled complete")
+	return nil
+}
+
+// DeleteLocalUser removes a user from a local identity provider. The
+// user will no longer be able to log in.
+func (h *handler) DeleteLocalUser(p httprequest.Params, r *params.DeleteLocalUserRequest) error {
+	logger.Tracef("DeleteLocalUser %#v", r)
+	idp, err := h.localIDPForUser(p.Context, r.Username)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	if err := idp.DeleteUser(p.Context, string(r.Username)); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	logger.Tracef("DeleteLocalUser complete")
+	return nil
+}
+
+// localIDP returns the local identity provider with the given name. If
+// name is empty and there is exactly one local identity provider
+// configured then that is returned.
+func (h *handler) localIDP(name string) (*local.IdentityProvider, error) {
+	var found []*local.IdentityProvider
+	for _, idp := range h.params.IdentityProviders {
+		lidp, ok := idp.(*local.IdentityProvider)
+		if !ok {
+			continue
+		}
+		if name == "" || lidp.Name() == name {
+			found = append(found, lidp)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case name != "":
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%q is not a local identity provider", name)
+	case len(found) == 0:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "no local identity provider configured")
+	}
+	return nil, errgo.WithCausef(nil, params.ErrBadRequest, "identity provider not specified")
+}
+
+// localIDPForUser returns the local identity provider that holds the
+// user with the given username.
+func (h *handler) localIDPForUser(ctx context.Context, username params.Username) (*local.IdentityProvider, error) {
+	id := store.Identity{
+		Username: string(username),
+	}
+	if err := h.params.Store.Identity(ctx, &id); err != nil {
+		return nil, translateStoreError(err)
+	}
+	name := id.ProviderID.Provider()
+	for _, idp := range h.params.IdentityProviders {
+		if lidp, ok := idp.(*local.IdentityProvider); ok && lidp.Name() == name {
+			return lidp, nil
+		}
+	}
+	return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%q is not a local user", username)
+}
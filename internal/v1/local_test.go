@@ -0,0 +1,230 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/httpbakery"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/local"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestLocalAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &localSuite{})
+}
+
+type localSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *localSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		local.NewIdentityProvider(local.Params{
+			Name: "local",
+		}),
+	}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+}
+
+func (s *localSuite) createUser(c *qt.C, username, password string, groups ...string) {
+	err := s.adminClient.CreateLocalUser(s.srv.Ctx, &params.CreateLocalUserRequest{
+		Username: params.Username(username),
+		Body: params.CreateLocalUserBody{
+			FullName: "Test User",
+			Email:    username + "@example.com",
+			Groups:   groups,
+			Password: password,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+// login attempts to log in as the given user, returning the
+// authenticated username. An unsuccessful login leaves the login form
+// waiting for another attempt, so the attempt is abandoned after a
+// short time.
+func (s *localSuite) login(c *qt.C, username, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(s.srv.Ctx, 5*time.Second)
+	defer cancel()
+	client, err := candidclient.New(candidclient.NewParams{
+		BaseURL: s.srv.URL,
+		Client: s.srv.Client(httpbakery.WebBrowserInteractor{
+			OpenWebBrowser: candidtest.PasswordLogin(c, username, password),
+		}),
+	})
+	c.Assert(err, qt.IsNil)
+	resp, err := client.WhoAmI(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.User, nil
+}
+
+func (s *localSuite) TestCreateLocalUser(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword", "g1", "g2")
+
+	user, err := s.adminClient.User(s.srv.Ctx, &params.UserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(user.Username, qt.Equals, params.Username("bob"))
+	c.Assert(user.ExternalID, qt.Equals, "local:bob")
+	c.Assert(user.FullName, qt.Equals, "Test User")
+	c.Assert(user.Email, qt.Equals, "bob@example.com")
+	c.Assert(user.IDPGroups, qt.DeepEquals, []string{"g1", "g2"})
+
+	username, err := s.login(c, "bob", "bobpassword")
+	c.Assert(err, qt.IsNil)
+	c.Assert(username, qt.Equals, "bob")
+}
+
+func (s *localSuite) TestCreateLocalUserExists(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword")
+	err := s.adminClient.CreateLocalUser(s.srv.Ctx, &params.CreateLocalUserRequest{
+		Username: "bob",
+		Body: params.CreateLocalUserBody{
+			Password: "otherpassword",
+		},
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrAlreadyExists)
+}
+
+func (s *localSuite) TestCreateLocalUserReservedUsername(c *qt.C) {
+	err := s.adminClient.CreateLocalUser(s.srv.Ctx, &params.CreateLocalUserRequest{
+		Username: "everyone",
+		Body: params.CreateLocalUserBody{
+			Password: "password",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http.*: username "everyone" is reserved`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *localSuite) TestCreateLocalUserUnknownIDP(c *qt.C) {
+	err := s.adminClient.CreateLocalUser(s.srv.Ctx, &params.CreateLocalUserRequest{
+		Username: "bob",
+		Body: params.CreateLocalUserBody{
+			IDP:      "nosuchidp",
+			Password: "password",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http.*: "nosuchidp" is not a local identity provider`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *localSuite) TestCreateLocalUserUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	err := client.CreateLocalUser(s.srv.Ctx, &params.CreateLocalUserRequest{
+		Username: "alice",
+		Body: params.CreateLocalUserBody{
+			Password: "password",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/u/alice/local: permission denied`)
+}
+
+func (s *localSuite) TestLoginIncorrectPassword(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword")
+	_, err := s.login(c, "bob", "wrongpassword")
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+func (s *localSuite) TestSetLocalUserPassword(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword")
+	err := s.adminClient.SetLocalUserPassword(s.srv.Ctx, &params.SetLocalUserPasswordRequest{
+		Username: "bob",
+		Body: params.SetLocalUserPasswordBody{
+			Password: "newpassword",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	_, err = s.login(c, "bob", "bobpassword")
+	c.Assert(err, qt.Not(qt.IsNil))
+	username, err := s.login(c, "bob", "newpassword")
+	c.Assert(err, qt.IsNil)
+	c.Assert(username, qt.Equals, "bob")
+}
+
+func (s *localSuite) TestSetLocalUserPasswordNotFound(c *qt.C) {
+	err := s.adminClient.SetLocalUserPassword(s.srv.Ctx, &params.SetLocalUserPasswordRequest{
+		Username: "bob",
+		Body: params.SetLocalUserPasswordBody{
+			Password: "newpassword",
+		},
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *localSuite) TestSetLocalUserPasswordNotLocal(c *qt.C) {
+	s.srv.CreateUser(c, "alice")
+	err := s.adminClient.SetLocalUserPassword(s.srv.Ctx, &params.SetLocalUserPasswordRequest{
+		Username: "alice",
+		Body: params.SetLocalUserPasswordBody{
+			Password: "newpassword",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http.*: "alice" is not a local user`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *localSuite) TestSetLocalUserDisabled(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword")
+	err := s.adminClient.SetLocalUserDisabled(s.srv.Ctx, &params.SetLocalUserDisabledRequest{
+		Username: "bob",
+		Body: params.SetLocalUserDisabledBody{
+			Disabled: true,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.login(c, "bob", "bobpassword")
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	err = s.adminClient.SetLocalUserDisabled(s.srv.Ctx, &params.SetLocalUserDisabledRequest{
+		Username: "bob",
+		Body: params.SetLocalUserDisabledBody{
+			Disabled: false,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	username, err := s.login(c, "bob", "bobpassword")
+	c.Assert(err, qt.IsNil)
+	c.Assert(username, qt.Equals, "bob")
+}
+
+func (s *localSuite) TestDeleteLocalUser(c *qt.C) {
+	s.createUser(c, "bob", "bobpassword")
+	err := s.adminClient.DeleteLocalUser(s.srv.Ctx, &params.DeleteLocalUserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	_, err = s.login(c, "bob", "bobpassword")
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	err = s.adminClient.DeleteLocalUser(s.srv.Ctx, &params.DeleteLocalUserRequest{
+		Username: "bob",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+// ReaperReport returns the actions that the identity reaper would take
+// if it were run now, without performing any of them.
+func (h *handler) ReaperReport(p httprequest.Params, r *params.ReaperReportRequest) ([]params.ReaperEntry, error) {
+	logger.Tracef("ReaperReport %#v", r)
+	entries, err := h.params.Reaper.Plan(p.Context, time.Now())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := make([]params.ReaperEntry, len(entries))
+	for i, e := range entries {
+		resp[i] = params.ReaperEntry{
+			Username: params.Username(e.Username),
+			Action:   e.Action,
+			Reason:   e.Reason,
+		}
+		if !e.LastActive.IsZero() {
+			t := e.LastActive
+			resp[i].LastActive = &t
+		}
+	}
+	logger.Tracef("ReaperReport response %#v", resp)
+	return resp, nil
+}
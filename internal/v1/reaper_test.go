@@ -0,0 +1,107 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/internal/reaper"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+func TestReaperAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &reaperSuite{})
+}
+
+type reaperSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *reaperSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	sp := s.store.ServerParams()
+	sp.InactiveIdentityPeriod = 30 * 24 * time.Hour
+	sp.InactiveIdentityAction = reaper.ActionSuspend
+	sp.RemoveOrphanedAgents = true
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+}
+
+func (s *reaperSuite) TestReaperReport(c *qt.C) {
+	lastLogin := time.Now().Add(-60 * 24 * time.Hour).Truncate(time.Millisecond)
+	s.addIdentity(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		LastLogin:  lastLogin,
+	})
+	s.addIdentity(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "alice"),
+		Username:   "alice",
+		LastLogin:  time.Now(),
+	})
+	s.addIdentity(c, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent"),
+		Username:   "agent@candid",
+		Owner:      store.MakeProviderIdentity("test", "nobody"),
+	})
+
+	entries, err := s.adminClient.ReaperReport(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(entries[0].LastActive, qt.Not(qt.IsNil))
+	c.Assert(entries[0].LastActive.Equal(lastLogin), qt.Equals, true)
+	entries[0].LastActive = nil
+	c.Assert(entries, qt.DeepEquals, []params.ReaperEntry{{
+		Username: "bob",
+		Action:   reaper.ActionSuspend,
+		Reason:   reaper.ReasonInactive,
+	}, {
+		Username: "agent@candid",
+		Action:   reaper.ActionDelete,
+		Reason:   reaper.ReasonOrphaned,
+	}})
+
+	// The report does not change anything.
+	bob := store.Identity{
+		Username: "bob",
+	}
+	err = s.store.Store.Identity(context.Background(), &bob)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bob.Suspended, qt.Equals, false)
+	err = s.store.Store.Identity(context.Background(), &store.Identity{
+		Username: "agent@candid",
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *reaperSuite) TestReaperReportUnauthorized(c *qt.C) {
+	client := s.srv.IdentityClient(c, "bob@candid")
+	_, err := client.ReaperReport(s.srv.Ctx, nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/reaper: permission denied`)
+}
+
+func (s *reaperSuite) addIdentity(c *qt.C, identity *store.Identity) {
+	err := s.store.Store.UpdateIdentity(context.Background(), identity, store.Update{
+		store.Username:  store.Set,
+		store.Owner:     store.Set,
+		store.LastLogin: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+}
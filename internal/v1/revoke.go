@@ -0,0 +1,47 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// RevokeUserTokens revokes all of the discharge macaroons and discharge
+// tokens that have been issued for the given user. The user will have
+// to log in again before any further discharges are made.
+func (h *handler) RevokeUserTokens(p httprequest.Params, r *params.RevokeUserTokensRequest) error {
+	logger.Tracef("RevokeUserTokens %#v", r)
+	if err := h.revokeTokens(p.Context, &store.Identity{Username: string(r.Username)}); err != nil {
+		return err
+	}
+	logger.Tracef("RevokeUserTokens complete")
+	return nil
+}
+
+// RevokeUserTokensWithID revokes all of the discharge macaroons and
+// discharge tokens that have been issued for the user with the given
+// ID.
+func (h *handler) RevokeUserTokensWithID(p httprequest.Params, r *params.RevokeUserTokensWithIDRequest) error {
+	logger.Tracef("RevokeUserTokensWithID %#v", r)
+	if err := h.revokeTokens(p.Context, &store.Identity{ProviderID: store.ProviderIdentity(r.UserID)}); err != nil {
+		return err
+	}
+	logger.Tracef("RevokeUserTokensWithID complete")
+	return nil
+}
+
+// revokeTokens records that all tokens issued for the given identity
+// before now are no longer valid.
+func (h *handler) revokeTokens(ctx context.Context, identity *store.Identity) error {
+	identity.TokensRevoked = time.Now()
+	return translateStoreError(h.params.Store.UpdateIdentity(ctx, identity, store.Update{
+		store.TokensRevoked: store.Set,
+	}))
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon.v2"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestRevokeAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &revokeSuite{})
+}
+
+type revokeSuite struct {
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *revokeSuite) Init(c *qt.C) {
+	store := candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.srv.CreateUser(c, "bob")
+}
+
+func (s *revokeSuite) TestRevokeUserTokens(c *qt.C) {
+	m1 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m1)
+
+	err := s.adminClient.RevokeUserTokens(s.srv.Ctx, &params.RevokeUserTokensRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	s.assertTokenRevoked(c, m1)
+
+	// Tokens issued after the revocation are valid.
+	m2 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m2)
+}
+
+func (s *revokeSuite) TestRevokeUserTokensWithID(c *qt.C) {
+	m1 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m1)
+
+	err := s.adminClient.RevokeUserTokensWithID(s.srv.Ctx, &params.RevokeUserTokensWithIDRequest{
+		UserID: "test:bob",
+	})
+	c.Assert(err, qt.IsNil)
+	s.assertTokenRevoked(c, m1)
+
+	m2 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m2)
+}
+
+func (s *revokeSuite) TestRevokeUserTokensDoesNotAffectOtherUsers(c *qt.C) {
+	s.srv.CreateUser(c, "alice")
+	m := s.userToken(c, "alice")
+
+	err := s.adminClient.RevokeUserTokens(s.srv.Ctx, &params.RevokeUserTokensRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	s.assertTokenValid(c, m)
+}
+
+func (s *revokeSuite) TestDeleteUserRevokesTokens(c *qt.C) {
+	m1 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m1)
+
+	err := s.adminClient.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// A new user with the same username does not inherit the
+	// tokens of the deleted user.
+	s.srv.CreateUser(c, "bob")
+	s.assertTokenRevoked(c, m1)
+
+	m2 := s.userToken(c, "bob")
+	s.assertTokenValid(c, m2)
+}
+
+func (s *revokeSuite) TestRevokeUserTokensNotFound(c *qt.C) {
+	err := s.adminClient.RevokeUserTokens(s.srv.Ctx, &params.RevokeUserTokensRequest{
+		Username: "charlie",
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/u/charlie/revoke: user charlie not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *revokeSuite) TestRevokeUserTokensWithIDNotFound(c *qt.C) {
+	err := s.adminClient.RevokeUserTokensWithID(s.srv.Ctx, &params.RevokeUserTokensWithIDRequest{
+		UserID: "test:charlie",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *revokeSuite) TestRevokeUserTokensPermissionDenied(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.RevokeUserTokens(s.srv.Ctx, &params.RevokeUserTokensRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/u/bob/revoke: permission denied`)
+	err = client.RevokeUserTokensWithID(s.srv.Ctx, &params.RevokeUserTokensWithIDRequest{
+		UserID: "test:bob",
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/uid/revoke\?id=test%3Abob: permission denied`)
+}
+
+func (s *revokeSuite) TestRevokeOwnTokensRequiresLoginAgain(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	resp, err := client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.User, qt.Equals, "alice@candid")
+
+	err = s.adminClient.RevokeUserTokens(s.srv.Ctx, &params.RevokeUserTokensRequest{
+		Username: "alice@candid",
+	})
+	c.Assert(err, qt.IsNil)
+
+	// The agent logs in again to get a new discharge macaroon.
+	resp, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.User, qt.Equals, "alice@candid")
+}
+
+func (s *revokeSuite) userToken(c *qt.C, username params.Username) *macaroon.Macaroon {
+	m, err := s.adminClient.UserToken(s.srv.Ctx, &params.UserTokenRequest{
+		Username: username,
+	})
+	c.Assert(err, qt.IsNil)
+	return m.M()
+}
+
+func (s *revokeSuite) assertTokenValid(c *qt.C, m *macaroon.Macaroon) {
+	_, err := s.adminClient.VerifyToken(s.srv.Ctx, &params.VerifyTokenRequest{
+		Macaroons: macaroon.Slice{m},
+	})
+	c.Assert(err, qt.IsNil)
+}
+
+func (s *revokeSuite) assertTokenRevoked(c *qt.C, m *macaroon.Macaroon) {
+	_, err := s.adminClient.VerifyToken(s.srv.Ctx, &params.VerifyTokenRequest{
+		Macaroons: macaroon.Slice{m},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post .*/v1/verify: verification failure: macaroon discharge required: authentication required`)
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+// SetUserSuspended suspends, or unsuspends, the given user. While a user
+// is suspended neither they, nor any agent that they own, can be
+// authenticated.
+func (h *handler) SetUserSuspended(p httprequest.Params, r *params.SetUserSuspendedRequest) error {
+	logger.Tracef("SetUserSuspended %#v", r)
+	if r.Body.Suspended && r.Username == auth.AdminUsername {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "cannot suspend %s", r.Username)
+	}
+	identity := store.Identity{
+		Username:  string(r.Username),
+		Suspended: r.Body.Suspended,
+	}
+	err := h.params.Store.UpdateIdentity(p.Context, &identity, store.Update{store.Suspended: store.Set})
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("SetUserSuspended complete")
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+	"github.com/canonical/candid/store"
+)
+
+func TestSuspendAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &suspendSuite{})
+}
+
+type suspendSuite struct {
+	store       *candidtest.Store
+	srv         *candidtest.Server
+	adminClient *candidclient.Client
+}
+
+func (s *suspendSuite) Init(c *qt.C) {
+	s.store = candidtest.NewStore()
+	s.srv = candidtest.NewServer(c, s.store.ServerParams(), map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.adminClient = s.srv.AdminIdentityClient(false)
+	s.srv.CreateUser(c, "bob")
+}
+
+func (s *suspendSuite) TestSuspendUser(c *qt.C) {
+	s.setSuspended(c, "bob", true)
+	u, err := s.adminClient.User(s.srv.Ctx, &params.UserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.Suspended, qt.Equals, true)
+
+	s.setSuspended(c, "bob", false)
+	u, err = s.adminClient.User(s.srv.Ctx, &params.UserRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.Suspended, qt.Equals, false)
+}
+
+func (s *suspendSuite) TestSuspendedUserCannotAuthenticate(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	resp, err := client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.User, qt.Equals, "alice@candid")
+
+	s.setSuspended(c, "alice@candid", true)
+	_, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/whoami: user alice@candid is suspended`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrForbidden)
+
+	s.setSuspended(c, "alice@candid", false)
+	resp, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.User, qt.Equals, "alice@candid")
+}
+
+func (s *suspendSuite) TestAgentOfSuspendedUserCannotAuthenticate(c *qt.C) {
+	client := s.srv.IdentityClient(c, "agent@candid")
+	err := s.store.Store.UpdateIdentity(context.Background(), &store.Identity{
+		Username: "agent@candid",
+		Owner:    store.MakeProviderIdentity("test", "bob"),
+	}, store.Update{
+		store.Owner: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	resp, err := client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.User, qt.Equals, "agent@candid")
+
+	s.setSuspended(c, "bob", true)
+	_, err = client.WhoAmI(s.srv.Ctx, nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/v1/whoami: user agent@candid is owned by suspended user bob`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrForbidden)
+}
+
+func (s *suspendSuite) TestSuspendAdmin(c *qt.C) {
+	err := s.adminClient.SetUserSuspended(s.srv.Ctx, &params.SetUserSuspendedRequest{
+		Username: "admin@candid",
+		Body: params.SetUserSuspendedBody{
+			Suspended: true,
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/u/admin@candid/suspended: cannot suspend admin@candid`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
+
+func (s *suspendSuite) TestSuspendUserNotFound(c *qt.C) {
+	err := s.adminClient.SetUserSuspended(s.srv.Ctx, &params.SetUserSuspendedRequest{
+		Username: "charlie",
+		Body: params.SetUserSuspendedBody{
+			Suspended: true,
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/u/charlie/suspended: user charlie not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *suspendSuite) TestSuspendPermissionDenied(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	err := client.SetUserSuspended(s.srv.Ctx, &params.SetUserSuspendedRequest{
+		Username: "bob",
+		Body: params.SetUserSuspendedBody{
+			Suspended: true,
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/v1/u/bob/suspended: permission denied`)
+}
+
+func (s *suspendSuite) setSuspended(c *qt.C, username params.Username, suspended bool) {
+	err := s.adminClient.SetUserSuspended(s.srv.Ctx, &params.SetUserSuspendedRequest{
+		Username: username,
+		Body: params.SetUserSuspendedBody{
+			Suspended: suspended,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+}
@@ -20,8 +20,11 @@ import (
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
 	macaroon "gopkg.in/macaroon.v2"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/candidclient"
+	"github.com/canonical/candid/idp/idputil/totp"
 	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/internal/deletion"
 	"github.com/canonical/candid/params"
 	"github.com/canonical/candid/store"
 )
@@ -172,7 +175,13 @@ func (h *handler) CreateAgent(p httprequest.Params, u *params.CreateAgentRequest
 		update[store.Owner] = store.Set
 	}
 	// TODO add tags to Identity?
-	if err := h.params.Store.UpdateIdentity(p.Context, identity, update); err != nil {
+	err = h.params.Store.UpdateIdentity(p.Context, identity, update)
+	h.auditEvent(ctx, audit.Event{
+		Type: audit.CreateAgent,
+		User: identity.Username,
+		Set:  identity.Groups,
+	}, err)
+	if err != nil {
 		return nil, translateStoreError(err)
 	}
 	resp := &params.CreateAgentResponse{
@@ -182,6 +191,36 @@ func (h *handler) CreateAgent(p httprequest.Params, u *params.CreateAgentRequest
 	return resp, nil
 }
 
+// DeleteUser deletes the given user. Any agents owned by the user are
+// deleted first so that no agent is left without an owner.
+func (h *handler) DeleteUser(p httprequest.Params, r *params.DeleteUserRequest) error {
+	logger.Tracef("DeleteUser %#v", r)
+	ctx := p.Context
+	if r.Username == auth.AdminUsername {
+		return errgo.WithCausef(nil, params.ErrForbidden, "cannot delete the admin user")
+	}
+	identity := store.Identity{
+		Username: string(r.Username),
+	}
+	if err := h.params.Store.Identity(ctx, &identity); err != nil {
+		return translateStoreError(err)
+	}
+	return errgo.Mask(h.deleteIdentity(ctx, &identity), errgo.Is(params.ErrNotFound))
+}
+
+// deleteIdentity deletes the given identity after first deleting all
+// of the agents it owns.
+func (h *handler) deleteIdentity(ctx context.Context, identity *store.Identity) error {
+	err := deletion.Delete(ctx, deletion.Params{
+		Store:         h.params.Store,
+		KeyValueStore: h.params.DeletionStore,
+		Audit: func(ctx context.Context, e audit.Event) {
+			h.auditEvent(ctx, e, nil)
+		},
+	}, identity)
+	return translateStoreError(err)
+}
+
 // SetUserDeprecated creates or updates the user with the given username. If the
 // user already exists then any IDPGroups or SSHKeys specified in the
 // request will be ignored. See SetUserGroups, ModifyUserGroups,
@@ -240,11 +279,31 @@ func (h *handler) UserIDPGroups(p httprequest.Params, r *params.UserIDPGroupsReq
 // given value.
 func (h *handler) SetUserGroups(p httprequest.Params, r *params.SetUserGroupsRequest) error {
 	logger.Tracef("SetUserGroups %#v", r)
-	identity := store.Identity{
+	e := audit.Event{
+		Type: audit.SetGroups,
+		User: string(r.Username),
+		Set:  r.Groups.Groups,
+	}
+	current := store.Identity{
 		Username: string(r.Username),
-		Groups:   r.Groups.Groups,
 	}
-	err := h.params.Store.UpdateIdentity(p.Context, &identity, store.Update{store.Groups: store.Set})
+	if err := h.params.Store.Identity(p.Context, &current); err != nil {
+		h.auditEvent(p.Context, e, err)
+		return translateStoreError(err)
+	}
+	e.Add, e.Remove = diffGroups(current.Groups, r.Groups.Groups)
+	identity := store.Identity{
+		Username:    string(r.Username),
+		Groups:      r.Groups.Groups,
+		GroupExpiry: groupExpiry(r.Groups.Groups, time.Time{}),
+	}
+	// Groups set this way never expire, so clear any expiry time
+	// that remains from an earlier time-limited membership.
+	err := h.params.Store.UpdateIdentity(p.Context, &identity, store.Update{
+		store.Groups:      store.Set,
+		store.GroupExpiry: store.Clear,
+	})
+	h.auditEvent(p.Context, e, err)
 	if err != nil {
 		return translateStoreError(err)
 	}
@@ -254,7 +313,9 @@ func (h *handler) SetUserGroups(p httprequest.Params, r *params.SetUserGroupsReq
 
 // ModifyUserGroups updates the groups stored for the given user. Groups
 // can be either added or removed in a single query. It is an error to
-// try and both add and remove groups at the same time.
+// try and both add and remove groups at the same time. Groups that are
+// added may be given a time at which the user's membership expires,
+// groups added without an expiry time are kept until they are removed.
 func (h *handler) ModifyUserGroups(p httprequest.Params, r *params.ModifyUserGroupsRequest) error {
 	logger.Tracef("ModifyUserGroups %#v", r)
 	identity := store.Identity{
@@ -267,11 +328,33 @@ func (h *handler) ModifyUserGroups(p httprequest.Params, r *params.ModifyUserGro
 	if len(r.Groups.Add) > 0 {
 		identity.Groups = r.Groups.Add
 		update[store.Groups] = store.Push
+		if r.Groups.Until != nil {
+			if !r.Groups.Until.After(time.Now()) {
+				return errgo.WithCausef(nil, params.ErrBadRequest, "expiry time %s is in the past", r.Groups.Until.Format(time.RFC3339))
+			}
+			identity.GroupExpiry = groupExpiry(r.Groups.Add, *r.Groups.Until)
+			update[store.GroupExpiry] = store.Set
+		} else {
+			identity.GroupExpiry = groupExpiry(r.Groups.Add, time.Time{})
+			update[store.GroupExpiry] = store.Clear
+		}
 	} else {
+		if r.Groups.Until != nil {
+			return errgo.WithCausef(nil, params.ErrBadRequest, "cannot set an expiry time when removing groups")
+		}
 		identity.Groups = r.Groups.Remove
+		identity.GroupExpiry = groupExpiry(r.Groups.Remove, time.Time{})
 		update[store.Groups] = store.Pull
+		update[store.GroupExpiry] = store.Clear
 	}
 	err := h.params.Store.UpdateIdentity(p.Context, &identity, update)
+	h.auditEvent(p.Context, audit.Event{
+		Type:   audit.ModifyGroups,
+		User:   string(r.Username),
+		Add:    r.Groups.Add,
+		Remove: r.Groups.Remove,
+		Until:  r.Groups.Until,
+	}, err)
 	if err != nil {
 		return translateStoreError(err)
 	}
@@ -279,6 +362,40 @@ func (h *handler) ModifyUserGroups(p httprequest.Params, r *params.ModifyUserGro
 	return nil
 }
 
+// diffGroups returns the groups in new that are not in old, and the
+// groups in old that are not in new.
+func diffGroups(old, new []string) (add, remove []string) {
+	in := func(g string, groups []string) bool {
+		for _, g1 := range groups {
+			if g1 == g {
+				return true
+			}
+		}
+		return false
+	}
+	for _, g := range new {
+		if !in(g, old) && !in(g, add) {
+			add = append(add, g)
+		}
+	}
+	for _, g := range old {
+		if !in(g, new) {
+			remove = append(remove, g)
+		}
+	}
+	return add, remove
+}
+
+// groupExpiry creates a group expiry map that expires each of the given
+// groups at the given time.
+func groupExpiry(groups []string, t time.Time) map[string]time.Time {
+	m := make(map[string]time.Time, len(groups))
+	for _, g := range groups {
+		m[g] = t
+	}
+	return m
+}
+
 // GetSSHKeys returns any SSH keys stored for the given user.
 func (h *handler) GetSSHKeys(p httprequest.Params, r *params.SSHKeysRequest) (params.SSHKeysResponse, error) {
 	logger.Tracef("GetSSHKeys %#v", r)
@@ -310,6 +427,11 @@ func (h *handler) PutSSHKeys(p httprequest.Params, r *params.PutSSHKeysRequest)
 		store.ExtraInfo: store.Push,
 	}
 	err := h.params.Store.UpdateIdentity(p.Context, &id, update)
+	h.auditEvent(p.Context, audit.Event{
+		Type: audit.PutSSHKeys,
+		User: string(r.Username),
+		Add:  r.Body.SSHKeys,
+	}, err)
 	if err != nil {
 		return translateStoreError(err)
 	}
@@ -332,6 +454,11 @@ func (h *handler) DeleteSSHKeys(p httprequest.Params, r *params.DeleteSSHKeysReq
 		store.ExtraInfo: store.Pull,
 	}
 	err := h.params.Store.UpdateIdentity(p.Context, &id, update)
+	h.auditEvent(p.Context, audit.Event{
+		Type:   audit.DeleteSSHKeys,
+		User:   string(r.Username),
+		Remove: r.Body.SSHKeys,
+	}, err)
 	if err != nil {
 		return translateStoreError(err)
 	}
@@ -339,6 +466,29 @@ func (h *handler) DeleteSSHKeys(p httprequest.Params, r *params.DeleteSSHKeysReq
 	return nil
 }
 
+// ResetUserTOTP removes any second factor authentication secret
+// enrolled by the given user. If the user's identity provider uses a
+// second factor they will be asked to enroll again the next time they
+// log in.
+func (h *handler) ResetUserTOTP(p httprequest.Params, r *params.ResetUserTOTPRequest) error {
+	logger.Tracef("ResetUserTOTP %#v", r)
+	id := store.Identity{
+		Username: string(r.Username),
+		ProviderInfo: map[string][]string{
+			totp.SecretKey: nil,
+		},
+	}
+	update := store.Update{
+		store.ProviderInfo: store.Clear,
+	}
+	err := h.params.Store.UpdateIdentity(p.Context, &id, update)
+	if err != nil {
+		return translateStoreError(err)
+	}
+	logger.Tracef("ResetUserTOTP complete")
+	return nil
+}
+
 // UserToken returns a token, in the form of a macaroon, identifying
 // the user. This token can only be generated by an administrator.
 func (h *handler) UserToken(p httprequest.Params, r *params.UserTokenRequest) (*bakery.Macaroon, error) {
@@ -354,6 +504,7 @@ func (h *handler) UserToken(p httprequest.Params, r *params.UserTokenRequest) (*
 		httpbakery.RequestVersion(p.Request),
 		[]checkers.Caveat{
 			candidclient.UserDeclaration(id.Id()),
+			auth.IssuedDeclaration(time.Now()),
 			checkers.TimeBeforeCaveat(time.Now().Add(h.params.APIMacaroonTimeout)),
 		},
 		identchecker.LoginOp,
@@ -536,6 +687,7 @@ func (h *handler) userFromIdentity(ctx context.Context, id *auth.Identity) (*par
 		SSHKeys:       sshKeys,
 		LastLogin:     lastLogin,
 		LastDischarge: lastDischarge,
+		Suspended:     id.Suspended,
 	}, nil
 }
 
@@ -576,8 +728,10 @@ func translateStoreError(err error) error {
 	switch errgo.Cause(err) {
 	case store.ErrNotFound:
 		cause = params.ErrNotFound
-	case store.ErrDuplicateUsername:
+	case store.ErrDuplicateUsername, store.ErrDuplicateGroup:
 		cause = params.ErrAlreadyExists
+	case store.ErrElevationStateChanged:
+		cause = params.ErrBadRequest
 	case nil:
 		return nil
 	}
@@ -602,6 +756,7 @@ func (h *handler) DischargeTokenForUser(p httprequest.Params, req *params.Discha
 		[]checkers.Caveat{
 			checkers.TimeBeforeCaveat(time.Now().Add(h.params.DischargeTokenTimeout)),
 			candidclient.UserDeclaration(string(req.Username)),
+			auth.IssuedDeclaration(time.Now()),
 		},
 		identchecker.LoginOp,
 	)
@@ -11,6 +11,7 @@ import (
 
 	qt "github.com/frankban/quicktest"
 	"github.com/frankban/quicktest/qtsuite"
+	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 	"gopkg.in/macaroon-bakery.v2/httpbakery"
@@ -364,6 +365,97 @@ func (s *usersSuite) TestCreateAgentAsParentAgent(c *qt.C) {
 	c.Assert(err, qt.ErrorMatches, `Post.*: cannot create an agent using an agent account`)
 }
 
+func (s *usersSuite) TestDeleteUser(c *qt.C) {
+	s.addUser(c, params.User{
+		Username:   "alice",
+		ExternalID: "test:alice",
+	})
+	s.addUser(c, params.User{
+		Username:   "agent1@candid",
+		ExternalID: "idm:agent1",
+		Owner:      "alice",
+	})
+	s.addUser(c, params.User{
+		Username:   "agent2@candid",
+		ExternalID: "idm:agent2",
+		Owner:      "alice",
+	})
+	s.addUser(c, params.User{
+		Username:   "carol",
+		ExternalID: "test:carol",
+	})
+	s.addUser(c, params.User{
+		Username:   "agent3@candid",
+		ExternalID: "idm:agent3",
+		Owner:      "carol",
+	})
+
+	err := s.adminClient.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: "alice",
+	})
+	c.Assert(err, qt.IsNil)
+
+	for _, username := range []string{"alice", "agent1@candid", "agent2@candid"} {
+		err := s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: username})
+		c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound, qt.Commentf("%s", username))
+	}
+	for _, username := range []string{"carol", "agent3@candid"} {
+		err := s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: username})
+		c.Assert(err, qt.IsNil, qt.Commentf("%s", username))
+	}
+}
+
+func (s *usersSuite) TestDeleteUserNotFound(c *qt.C) {
+	err := s.adminClient.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: "not-there",
+	})
+	c.Assert(err, qt.ErrorMatches, `Delete .*/v1/u/not-there: user not-there not found`)
+}
+
+func (s *usersSuite) TestDeleteAdminUser(c *qt.C) {
+	err := s.adminClient.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: auth.AdminUsername,
+	})
+	c.Assert(err, qt.ErrorMatches, `Delete .*/v1/u/admin@candid: cannot delete the admin user`)
+}
+
+func (s *usersSuite) TestDeleteAgentAsOwner(c *qt.C) {
+	client, err := candidclient.New(candidclient.NewParams{
+		BaseURL: s.srv.URL,
+		Client:  s.srv.Client(s.interactor),
+	})
+	c.Assert(err, qt.IsNil)
+	resp, err := client.CreateAgent(s.srv.Ctx, &params.CreateAgentRequest{
+		CreateAgentBody: params.CreateAgentBody{
+			FullName:   "my agent",
+			PublicKeys: []*bakery.PublicKey{&pk1},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = client.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: resp.Username,
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.store.Store.Identity(s.srv.Ctx, &store.Identity{Username: string(resp.Username)})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	// Users cannot delete agents they do not own.
+	s.addUser(c, params.User{
+		Username:   "carol",
+		ExternalID: "test:carol",
+	})
+	s.addUser(c, params.User{
+		Username:   "agent3@candid",
+		ExternalID: "idm:agent3",
+		Owner:      "carol",
+	})
+	err = client.DeleteUser(s.srv.Ctx, &params.DeleteUserRequest{
+		Username: "agent3@candid",
+	})
+	c.Assert(err, qt.ErrorMatches, `Delete http://.*/v1/u/agent3@candid: permission denied`)
+}
+
 func (s *usersSuite) clearIdentities(c *qt.C) {
 	store, ok := s.store.Store.(interface {
 		RemoveAll()
@@ -802,6 +894,7 @@ var modifyUserGroupsTests = []struct {
 	username     params.Username
 	addGroups    []string
 	removeGroups []string
+	until        time.Duration
 	expectGroups []string
 	expectError  string
 }{{
@@ -825,6 +918,24 @@ var modifyUserGroupsTests = []struct {
 	startGroups:  []string{"test1", "test2"},
 	removeGroups: []string{"test5"},
 	expectGroups: []string{"test1", "test2"},
+}, {
+	about:        "add groups with expiry",
+	startGroups:  []string{"test1", "test2"},
+	addGroups:    []string{"test3"},
+	until:        time.Hour,
+	expectGroups: []string{"test1", "test2", "test3"},
+}, {
+	about:       "add groups with expiry in the past",
+	startGroups: []string{"test1", "test2"},
+	addGroups:   []string{"test3"},
+	until:       -time.Hour,
+	expectError: `Post .*/v1/u/.*/groups: expiry time .* is in the past`,
+}, {
+	about:        "remove groups with expiry",
+	startGroups:  []string{"test1", "test2"},
+	removeGroups: []string{"test1"},
+	until:        time.Hour,
+	expectError:  `Post .*/v1/u/.*/groups: cannot set an expiry time when removing groups`,
 }, {
 	about:       "user not found",
 	username:    "not-there",
@@ -844,11 +955,17 @@ func (s *usersSuite) TestModifyUserGroups(c *qt.C) {
 				ExternalID: "test:http://example.com/" + string(username),
 				IDPGroups:  test.startGroups,
 			})
+			var until *time.Time
+			if test.until != 0 {
+				t := time.Now().Add(test.until)
+				until = &t
+			}
 			err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
 				Username: test.username,
 				Groups: params.ModifyGroups{
 					Add:    test.addGroups,
 					Remove: test.removeGroups,
+					Until:  until,
 				},
 			})
 
@@ -867,6 +984,68 @@ func (s *usersSuite) TestModifyUserGroups(c *qt.C) {
 	}
 }
 
+func (s *usersSuite) TestModifyUserGroupsExpiry(c *qt.C) {
+	s.addUser(c, params.User{
+		Username:   "jbloggs",
+		ExternalID: "test:http://example.com/jbloggs",
+		IDPGroups:  []string{"test1"},
+	})
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	err := s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "jbloggs",
+		Groups: params.ModifyGroups{
+			Add:   []string{"oncall"},
+			Until: &until,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	identity := store.Identity{
+		Username: "jbloggs",
+	}
+	err = s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.GroupExpiry, qt.HasLen, 1)
+	c.Assert(identity.GroupExpiry["oncall"].Equal(until), qt.Equals, true)
+
+	// Once the membership has expired the group is no longer
+	// reported.
+	err = s.store.Store.UpdateIdentity(s.srv.Ctx, &store.Identity{
+		Username: "jbloggs",
+		GroupExpiry: map[string]time.Time{
+			"oncall": time.Now().Add(-time.Minute),
+		},
+	}, store.Update{
+		store.GroupExpiry: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	groups, err := s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "jbloggs",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"test1"})
+
+	// Adding the group again without an expiry time makes the
+	// membership permanent.
+	err = s.adminClient.ModifyUserGroups(s.srv.Ctx, &params.ModifyUserGroupsRequest{
+		Username: "jbloggs",
+		Groups: params.ModifyGroups{
+			Add: []string{"oncall"},
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	groups, err = s.adminClient.UserGroups(s.srv.Ctx, &params.UserGroupsRequest{
+		Username: "jbloggs",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []string{"oncall", "test1"})
+	identity = store.Identity{
+		Username: "jbloggs",
+	}
+	err = s.store.Store.Identity(s.srv.Ctx, &identity)
+	c.Assert(err, qt.IsNil)
+	c.Assert(identity.GroupExpiry, qt.HasLen, 0)
+}
+
 func (s *usersSuite) TestUserIDPGroups(c *qt.C) {
 	s.addUser(c, params.User{
 		Username:   "jbloggs",
@@ -0,0 +1,55 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1
+
+import (
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/canonical/candid/idp/webauthn"
+	"github.com/canonical/candid/params"
+)
+
+// WebAuthnRegistration returns a URL at which the user can register a
+// WebAuthn authenticator.
+func (h *handler) WebAuthnRegistration(p httprequest.Params, r *params.WebAuthnRegistrationRequest) (*params.WebAuthnRegistrationResponse, error) {
+	logger.Tracef("WebAuthnRegistration %#v", r)
+	idp, err := h.webauthnIDP(r.Body.IDP)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	u, err := idp.RegistrationURL(p.Context, string(r.Username))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	logger.Tracef("WebAuthnRegistration complete")
+	return &params.WebAuthnRegistrationResponse{
+		URL: u,
+	}, nil
+}
+
+// webauthnIDP returns the WebAuthn identity provider with the given
+// name. If name is empty and there is exactly one WebAuthn identity
+// provider configured then that is returned.
+func (h *handler) webauthnIDP(name string) (*webauthn.IdentityProvider, error) {
+	var found []*webauthn.IdentityProvider
+	for _, idp := range h.params.IdentityProviders {
+		widp, ok := idp.(*webauthn.IdentityProvider)
+		if !ok {
+			continue
+		}
+		if name == "" || widp.Name() == name {
+			found = append(found, widp)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case name != "":
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "%q is not a webauthn identity provider", name)
+	case len(found) == 0:
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "no webauthn identity provider configured")
+	}
+	return nil, errgo.WithCausef(nil, params.ErrBadRequest, "identity provider not specified")
+}
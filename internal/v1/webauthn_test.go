@@ -0,0 +1,92 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v1_test
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/idp"
+	"github.com/canonical/candid/idp/webauthn"
+	"github.com/canonical/candid/internal/candidtest"
+	"github.com/canonical/candid/internal/discharger"
+	"github.com/canonical/candid/internal/identity"
+	v1 "github.com/canonical/candid/internal/v1"
+	"github.com/canonical/candid/params"
+)
+
+func TestWebAuthnAPI(t *testing.T) {
+	qtsuite.Run(qt.New(t), &webauthnSuite{})
+}
+
+type webauthnSuite struct {
+	srv *candidtest.Server
+}
+
+func (s *webauthnSuite) Init(c *qt.C) {
+	store := candidtest.NewStore()
+	sp := store.ServerParams()
+	sp.IdentityProviders = []idp.IdentityProvider{
+		webauthn.NewIdentityProvider(webauthn.Params{
+			Name: "webauthn",
+		}),
+	}
+	s.srv = candidtest.NewServer(c, sp, map[string]identity.NewAPIHandlerFunc{
+		"discharger": discharger.NewAPIHandler,
+		"v1":         v1.NewAPIHandler,
+	})
+	s.srv.CreateUser(c, "bob")
+}
+
+func (s *webauthnSuite) TestRegistrationAsAdmin(c *qt.C) {
+	client := s.srv.AdminIdentityClient(false)
+	resp, err := client.WebAuthnRegistration(s.srv.Ctx, &params.WebAuthnRegistrationRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.URL, qt.Matches, s.srv.URL+`/login/webauthn/register\?token=.+`)
+}
+
+func (s *webauthnSuite) TestRegistrationAsSelf(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	resp, err := client.WebAuthnRegistration(s.srv.Ctx, &params.WebAuthnRegistrationRequest{
+		Username: "alice@candid",
+		Body: params.WebAuthnRegistrationBody{
+			IDP: "webauthn",
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.URL, qt.Matches, s.srv.URL+`/login/webauthn/register\?token=.+`)
+}
+
+func (s *webauthnSuite) TestRegistrationAsOtherUser(c *qt.C) {
+	client := s.srv.IdentityClient(c, "alice@candid")
+	_, err := client.WebAuthnRegistration(s.srv.Ctx, &params.WebAuthnRegistrationRequest{
+		Username: "bob",
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/v1/u/bob/webauthn/register: permission denied`)
+}
+
+func (s *webauthnSuite) TestRegistrationUserNotFound(c *qt.C) {
+	client := s.srv.AdminIdentityClient(false)
+	_, err := client.WebAuthnRegistration(s.srv.Ctx, &params.WebAuthnRegistrationRequest{
+		Username: "charlie",
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrNotFound)
+}
+
+func (s *webauthnSuite) TestRegistrationUnknownIDP(c *qt.C) {
+	client := s.srv.AdminIdentityClient(false)
+	_, err := client.WebAuthnRegistration(s.srv.Ctx, &params.WebAuthnRegistrationRequest{
+		Username: "bob",
+		Body: params.WebAuthnRegistrationBody{
+			IDP: "nosuchidp",
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http.*: "nosuchidp" is not a webauthn identity provider`)
+	c.Assert(errgo.Cause(err), qt.Equals, params.ErrBadRequest)
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package oidcissuer defines the configuration of the relying parties
+// that may use the identity server as an OpenID Connect provider.
+package oidcissuer
+
+// A Client is a relying party that is registered with the identity
+// server. Only registered clients may request tokens.
+type Client struct {
+	// ID holds the client identifier, this is used as the audience
+	// of any ID tokens issued to the client.
+	ID string `yaml:"client-id"`
+
+	// Secret holds the secret that the client uses to authenticate
+	// itself when requesting tokens.
+	Secret string `yaml:"client-secret"`
+
+	// RedirectURIs holds the set of URIs that the client may
+	// request that the authorization response is sent to. A
+	// request must use one of these URIs exactly.
+	RedirectURIs []string `yaml:"redirect-uris"`
+}
+
+// HasRedirectURI reports whether the given URI is one of the client's
+// registered redirect URIs.
+func (c Client) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
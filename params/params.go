@@ -124,6 +124,7 @@ type User struct {
 	SSHKeys       []string            `json:"ssh_keys"`
 	LastLogin     *time.Time          `json:"last_login,omitempty"`
 	LastDischarge *time.Time          `json:"last_discharge,omitempty"`
+	Suspended     bool                `json:"suspended,omitempty"`
 }
 
 // SetUserRequest is a request to set the details of a user.
@@ -161,6 +162,13 @@ type CreateAgentResponse struct {
 	Username Username
 }
 
+// DeleteUserRequest is a request to delete the named user. Any agents
+// owned by the user are also deleted.
+type DeleteUserRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/u/:username"`
+	Username          Username `httprequest:"username,path"`
+}
+
 // UserGroupsRequest is a request for the list of groups associated
 // with the specified user.
 type UserGroupsRequest struct {
@@ -193,6 +201,11 @@ type ModifyUserGroupsRequest struct {
 type ModifyGroups struct {
 	Add    []string `json:"add"`
 	Remove []string `json:"remove"`
+
+	// Until optionally holds the time at which a user's membership
+	// of the groups in Add expires. It is not used when modifying
+	// the member groups of a group.
+	Until *time.Time `json:"until,omitempty"`
 }
 
 // UserIDPGroupsRequest defines the deprecated path for
@@ -287,6 +300,115 @@ type SetUserExtraInfoItemRequest struct {
 	Data              interface{} `httprequest:",body"`
 }
 
+// CreateLocalUserRequest is a request to create a new user in a local
+// identity provider.
+type CreateLocalUserRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/local"`
+	Username          Username            `httprequest:"username,path"`
+	Body              CreateLocalUserBody `httprequest:",body"`
+}
+
+// CreateLocalUserBody holds the body of a CreateLocalUserRequest.
+type CreateLocalUserBody struct {
+	// IDP holds the name of the local identity provider in which the
+	// user will be created. If this is empty and there is exactly
+	// one local identity provider configured then that will be used.
+	IDP string `json:"idp,omitempty"`
+
+	FullName string   `json:"fullname"`
+	Email    string   `json:"email"`
+	Groups   []string `json:"groups"`
+	Password string   `json:"password"`
+}
+
+// SetLocalUserPasswordRequest is a request to set the password of a
+// user in a local identity provider.
+type SetLocalUserPasswordRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/local/password"`
+	Username          Username                 `httprequest:"username,path"`
+	Body              SetLocalUserPasswordBody `httprequest:",body"`
+}
+
+// SetLocalUserPasswordBody holds the body of a
+// SetLocalUserPasswordRequest.
+type SetLocalUserPasswordBody struct {
+	Password string `json:"password"`
+}
+
+// SetLocalUserDisabledRequest is a request to disable, or re-enable, a
+// user in a local identity provider.
+type SetLocalUserDisabledRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/local/disabled"`
+	Username          Username                 `httprequest:"username,path"`
+	Body              SetLocalUserDisabledBody `httprequest:",body"`
+}
+
+// SetLocalUserDisabledBody holds the body of a
+// SetLocalUserDisabledRequest.
+type SetLocalUserDisabledBody struct {
+	Disabled bool `json:"disabled"`
+}
+
+// DeleteLocalUserRequest is a request to remove a user from a local
+// identity provider.
+type DeleteLocalUserRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/u/:username/local"`
+	Username          Username `httprequest:"username,path"`
+}
+
+// ResetUserTOTPRequest is a request to remove the second factor
+// authentication secret enrolled by a user.
+type ResetUserTOTPRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/u/:username/totp"`
+	Username          Username `httprequest:"username,path"`
+}
+
+// WebAuthnRegistrationRequest is a request for a URL at which a user
+// can register a WebAuthn authenticator.
+type WebAuthnRegistrationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/webauthn/register"`
+	Username          Username                 `httprequest:"username,path"`
+	Body              WebAuthnRegistrationBody `httprequest:",body"`
+}
+
+// WebAuthnRegistrationBody holds the body of a
+// WebAuthnRegistrationRequest.
+type WebAuthnRegistrationBody struct {
+	// IDP holds the name of the WebAuthn identity provider with which
+	// to register. If this is empty and there is exactly one WebAuthn
+	// identity provider configured then that will be used.
+	IDP string `json:"idp,omitempty"`
+}
+
+// WebAuthnRegistrationResponse holds the response to a
+// WebAuthnRegistrationRequest.
+type WebAuthnRegistrationResponse struct {
+	// URL holds the URL the user should visit, in a web browser, to
+	// register an authenticator. The URL is valid for a limited time.
+	URL string `json:"url"`
+}
+
+// SetUserSuspendedRequest is a request to suspend, or unsuspend, a
+// user. A suspended user, and any agent owned by them, cannot be
+// authenticated.
+type SetUserSuspendedRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/u/:username/suspended"`
+	Username          Username             `httprequest:"username,path"`
+	Body              SetUserSuspendedBody `httprequest:",body"`
+}
+
+// SetUserSuspendedBody holds the body of a SetUserSuspendedRequest.
+type SetUserSuspendedBody struct {
+	Suspended bool `json:"suspended"`
+}
+
+// RevokeUserTokensRequest is a request to revoke all of the outstanding
+// discharge macaroons and discharge tokens issued for a user.
+type RevokeUserTokensRequest struct {
+	httprequest.Route `httprequest:"POST /v1/u/:username/revoke"`
+	Username          Username `httprequest:"username,path"`
+}
+
 // WhoAmIRequest holds parameters for requesting the current user name.
 type WhoAmIRequest struct {
 	httprequest.Route `httprequest:"GET /v1/whoami"`
@@ -339,7 +461,324 @@ type GetUserGroupsWithIDRequest struct {
 	UserID            string `httprequest:"id,form"`
 }
 
+// RevokeUserTokensWithIDRequest is a request to revoke all of the
+// outstanding discharge macaroons and discharge tokens issued for the
+// user with the given ID.
+type RevokeUserTokensWithIDRequest struct {
+	httprequest.Route `httprequest:"POST /v1/uid/revoke"`
+	UserID            string `httprequest:"id,form"`
+}
+
 // GroupsResponse is the response to a GetUserGroupsWithIDRequest.
 type GroupsResponse struct {
 	Groups []string `json:"groups"`
 }
+
+// AuditEventsRequest is a request for the events recorded in the audit
+// log.
+type AuditEventsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/audit"`
+
+	// Type, if present, matches all events of the given type.
+	Type string `httprequest:"type,form,omitempty"`
+
+	// User, if present, matches all events performed by, or applied
+	// to, the given user.
+	User string `httprequest:"user,form,omitempty"`
+
+	// After, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all events that occurred at or
+	// after the given time.
+	After string `httprequest:"after,form,omitempty"`
+
+	// Before, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all events that occurred before
+	// the given time.
+	Before string `httprequest:"before,form,omitempty"`
+
+	// Limit, if present, holds the maximum number of events to
+	// return.
+	Limit int `httprequest:"limit,form,omitempty"`
+}
+
+// AuditEvent holds an event recorded in the audit log.
+type AuditEvent struct {
+	Time   time.Time  `json:"time"`
+	Type   string     `json:"type"`
+	Actor  string     `json:"actor,omitempty"`
+	User   string     `json:"user,omitempty"`
+	IDP    string     `json:"idp,omitempty"`
+	Caveat string     `json:"caveat,omitempty"`
+	ACL    string     `json:"acl,omitempty"`
+	Group  string     `json:"group,omitempty"`
+	Set    []string   `json:"set,omitempty"`
+	Add    []string   `json:"add,omitempty"`
+	Remove []string   `json:"remove,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// ReaperReportRequest is a request for the actions that the identity
+// reaper would take if it were run now. No action is taken.
+type ReaperReportRequest struct {
+	httprequest.Route `httprequest:"GET /v1/reaper"`
+}
+
+// ReaperEntry describes an action that the identity reaper would take
+// on a single identity.
+type ReaperEntry struct {
+	Username   Username   `json:"username"`
+	Action     string     `json:"action"`
+	Reason     string     `json:"reason"`
+	LastActive *time.Time `json:"last_active,omitempty"`
+}
+
+// Group holds the details of a group.
+type Group struct {
+	// Name holds the name of the group.
+	Name string `json:"name"`
+
+	// Description holds a human readable description of the group.
+	Description string `json:"description,omitempty"`
+
+	// Owners holds the users and groups that may manage the group.
+	Owners []string `json:"owners,omitempty"`
+
+	// MemberGroups holds the groups whose members are also members
+	// of the group.
+	MemberGroups []string `json:"member-groups,omitempty"`
+}
+
+// QueryGroupsRequest is a request for all the groups known to the
+// identity server.
+type QueryGroupsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/g"`
+}
+
+// CreateGroupRequest is a request to create a new group.
+type CreateGroupRequest struct {
+	httprequest.Route `httprequest:"POST /v1/g"`
+	Group             Group `httprequest:",body"`
+}
+
+// GroupRequest is a request for the details of the specified group.
+type GroupRequest struct {
+	httprequest.Route `httprequest:"GET /v1/g/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+// DeleteGroupRequest is a request to delete the specified group. The
+// group is not removed from any users that are members of it.
+type DeleteGroupRequest struct {
+	httprequest.Route `httprequest:"DELETE /v1/g/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+// SetGroupDescriptionRequest is a request to set the description of
+// the specified group.
+type SetGroupDescriptionRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/g/:name/description"`
+	Name              string           `httprequest:"name,path"`
+	Body              GroupDescription `httprequest:",body"`
+}
+
+// GroupDescription holds the description of a group.
+type GroupDescription struct {
+	Description string `json:"description"`
+}
+
+// SetGroupOwnersRequest is a request to set the owners of the
+// specified group.
+type SetGroupOwnersRequest struct {
+	httprequest.Route `httprequest:"PUT /v1/g/:name/owners"`
+	Name              string      `httprequest:"name,path"`
+	Body              GroupOwners `httprequest:",body"`
+}
+
+// GroupOwners holds the owners of a group.
+type GroupOwners struct {
+	Owners []string `json:"owners"`
+}
+
+// ModifyMemberGroupsRequest is a request to add groups to, or remove
+// groups from, the member groups of the specified group.
+type ModifyMemberGroupsRequest struct {
+	httprequest.Route `httprequest:"POST /v1/g/:name/groups"`
+	Name              string       `httprequest:"name,path"`
+	Groups            ModifyGroups `httprequest:",body"`
+}
+
+// ModifyGroupMembersRequest is a request to add users to, or remove
+// users from, the specified group.
+type ModifyGroupMembersRequest struct {
+	httprequest.Route `httprequest:"POST /v1/g/:name/members"`
+	Name              string             `httprequest:"name,path"`
+	Members           ModifyGroupMembers `httprequest:",body"`
+}
+
+// ModifyGroupMembers contains a set of group membership
+// modifications.
+type ModifyGroupMembers struct {
+	Add    []Username `json:"add"`
+	Remove []Username `json:"remove"`
+}
+
+// UserGroupHistoryRequest is a request for the changes that have been
+// made to the group memberships of the specified user.
+type UserGroupHistoryRequest struct {
+	httprequest.Route `httprequest:"GET /v1/u/:username/groups/history"`
+	Username          Username `httprequest:"username,path"`
+
+	// After, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all changes that were made at
+	// or after the given time.
+	After string `httprequest:"after,form,omitempty"`
+
+	// Before, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all changes that were made
+	// before the given time.
+	Before string `httprequest:"before,form,omitempty"`
+
+	// Limit, if present, holds the maximum number of changes to
+	// return.
+	Limit int `httprequest:"limit,form,omitempty"`
+}
+
+// GroupHistoryRequest is a request for the changes that have been made
+// to the membership of the specified group.
+type GroupHistoryRequest struct {
+	httprequest.Route `httprequest:"GET /v1/g/:name/history"`
+	Name              string `httprequest:"name,path"`
+
+	// After, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all changes that were made at
+	// or after the given time.
+	After string `httprequest:"after,form,omitempty"`
+
+	// Before, if present, must contain a time marshaled as if using
+	// Time.MarshalText. It matches all changes that were made
+	// before the given time.
+	Before string `httprequest:"before,form,omitempty"`
+
+	// Limit, if present, holds the maximum number of changes to
+	// return.
+	Limit int `httprequest:"limit,form,omitempty"`
+}
+
+// RequestElevationRequest is a request for the authenticated user to be
+// treated as a member of a group for a limited time. The request must
+// be approved by a user in the approve-elevation ACL before it has any
+// effect.
+type RequestElevationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/elevations"`
+	Body              RequestElevationBody `httprequest:",body"`
+}
+
+// RequestElevationBody holds the details of a requested elevation.
+type RequestElevationBody struct {
+	// Group holds the name of the group.
+	Group string `json:"group"`
+
+	// Reason holds a human readable justification for the request.
+	Reason string `json:"reason,omitempty"`
+
+	// Duration holds the length of time for which membership is
+	// requested, in the form accepted by time.ParseDuration (for
+	// example "2h").
+	Duration string `json:"duration"`
+}
+
+// ElevationsRequest is a request for the elevations that match the
+// given parameters.
+type ElevationsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/elevations"`
+
+	// User, if present, matches all elevations requested by the
+	// given user.
+	User string `httprequest:"user,form,omitempty"`
+
+	// Group, if present, matches all elevations for the given
+	// group.
+	Group string `httprequest:"group,form,omitempty"`
+
+	// State, if present, matches all elevations in the given state,
+	// one of "pending", "approved", "denied" or "revoked".
+	State string `httprequest:"state,form,omitempty"`
+}
+
+// UserElevationsRequest is a request for the elevations requested by
+// the specified user.
+type UserElevationsRequest struct {
+	httprequest.Route `httprequest:"GET /v1/u/:username/elevations"`
+	Username          Username `httprequest:"username,path"`
+}
+
+// ApproveElevationRequest is a request to approve a pending elevation.
+// The elevation grants membership of its group from the time it is
+// approved for its requested duration.
+type ApproveElevationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/elevations/:id/approve"`
+	ID                string `httprequest:"id,path"`
+}
+
+// DenyElevationRequest is a request to refuse a pending elevation.
+type DenyElevationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/elevations/:id/deny"`
+	ID                string `httprequest:"id,path"`
+}
+
+// RevokeElevationRequest is a request to withdraw an approved
+// elevation before it expires.
+type RevokeElevationRequest struct {
+	httprequest.Route `httprequest:"POST /v1/elevations/:id/revoke"`
+	ID                string `httprequest:"id,path"`
+}
+
+// Elevation holds the details of a request for temporary membership of
+// a group.
+type Elevation struct {
+	ID        string     `json:"id"`
+	User      Username   `json:"user"`
+	Group     string     `json:"group"`
+	Reason    string     `json:"reason,omitempty"`
+	Duration  string     `json:"duration"`
+	State     string     `json:"state"`
+	Requested time.Time  `json:"requested"`
+	Approver  string     `json:"approver,omitempty"`
+	Decided   *time.Time `json:"decided,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+
+	// Active holds whether the elevation currently grants
+	// membership of the group.
+	Active bool `json:"active"`
+}
+
+// GroupChange holds a single change to a user's membership of a group,
+// or to the member groups of a group.
+type GroupChange struct {
+	// Time holds the time at which the change was made.
+	Time time.Time `json:"time"`
+
+	// Actor holds the username of the user that made the change. It
+	// is empty if the change was made by the identity server itself.
+	Actor string `json:"actor,omitempty"`
+
+	// User holds the username of the user whose membership
+	// changed. It is empty if the member groups of the group
+	// changed.
+	User Username `json:"user,omitempty"`
+
+	// MemberGroup holds the name of the member group that was added
+	// to or removed from the group.
+	MemberGroup string `json:"member-group,omitempty"`
+
+	// Group holds the name of the group.
+	Group string `json:"group"`
+
+	// Op holds the kind of change, either "add" or "remove".
+	Op string `json:"op"`
+
+	// Until optionally holds the time at which a membership added
+	// by the change expires.
+	Until *time.Time `json:"until,omitempty"`
+}
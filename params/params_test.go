@@ -43,3 +43,11 @@ func TestUsernameTextUnmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestWebhookSignature(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	sig := params.WebhookSignature("s3cret", "1622505600", []byte(`{"id":"1"}`))
+	c.Assert(sig, qt.Equals, "v1=f0a516d56c2a6110ef8d2a97365b9e906ea10ce6e11b3d28d501d7f68cdd84ce")
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE.client file for details.
+
+package params
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// IdentityEventType is the type of change described by an
+// IdentityEvent.
+type IdentityEventType string
+
+const (
+	// IdentityCreated is sent when a new identity is added.
+	IdentityCreated IdentityEventType = "identity-created"
+
+	// IdentityGroupsChanged is sent when the groups of an existing
+	// identity are updated, including through changes to the member
+	// groups of a group or the approval or revocation of an
+	// elevation. It may be sent for an update that leaves the groups
+	// unchanged.
+	IdentityGroupsChanged IdentityEventType = "groups-changed"
+
+	// IdentitySSHKeysChanged is sent when the SSH keys of an
+	// existing identity are updated. It may be sent for an update
+	// that leaves the keys unchanged.
+	IdentitySSHKeysChanged IdentityEventType = "ssh-keys-changed"
+
+	// IdentityDeleted is sent when an identity is removed.
+	IdentityDeleted IdentityEventType = "identity-deleted"
+)
+
+// IdentityEvent is the body of a webhook request sent by the identity
+// server when an identity changes. Events are delivered at least once,
+// a receiver can use the ID to discard duplicates.
+type IdentityEvent struct {
+	// ID holds a unique identifier for the event.
+	ID string `json:"id"`
+
+	// Time holds the time at which the change was made.
+	Time time.Time `json:"time"`
+
+	// Type holds the type of change.
+	Type IdentityEventType `json:"type"`
+
+	// User holds the username of the changed identity.
+	User Username `json:"user"`
+
+	// ProviderID holds the identity provider ID of the changed
+	// identity.
+	ProviderID string `json:"provider-id,omitempty"`
+
+	// Groups holds the groups of the identity after the change.
+	Groups []string `json:"groups,omitempty"`
+}
+
+const (
+	// WebhookTimestampHeader is the header of a webhook request that
+	// holds the time the request was sent, as decimal seconds since
+	// the Unix epoch.
+	WebhookTimestampHeader = "Candid-Webhook-Timestamp"
+
+	// WebhookSignatureHeader is the header of a webhook request that
+	// holds the signature created by WebhookSignature.
+	WebhookSignatureHeader = "Candid-Webhook-Signature"
+)
+
+// WebhookSignature returns the signature of a webhook request sent at
+// the given timestamp with the given body, using the secret shared
+// between the identity server and the receiver. The signature is the
+// hex encoded HMAC-SHA256 of the timestamp, a ".", and the body,
+// prefixed with "v1=".
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return "v1=" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,424 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package policy implements the declarative rules that the identity
+// server consults before discharging a third-party caveat. The rules
+// decide whether the discharge is allowed and which additional
+// first-party caveats are added to the discharge macaroon.
+package policy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+)
+
+// The following constants define the actions that a rule can take.
+const (
+	// ActionAllow allows the discharge to proceed. This is the
+	// default action.
+	ActionAllow = "allow"
+
+	// ActionDeny refuses the discharge.
+	ActionDeny = "deny"
+)
+
+// A Rule is a single rule in a discharge policy. A rule matches a
+// discharge request when every criterion that is specified in the rule
+// matches. Criteria that are not specified match every request.
+//
+// The Conditions, Users, Domains, IdentityProviders and Groups
+// criteria are lists of names. A name starting with "!" is negated. A
+// list matches a value if the value matches at least one of the
+// non-negated names, if there are any, and none of the negated names.
+type Rule struct {
+	// Name holds a name for the rule. This is used in log messages
+	// and in the error returned when a discharge is denied.
+	Name string `yaml:"name"`
+
+	// Conditions holds the caveat conditions that the rule applies
+	// to, for example "is-authenticated-user" or "is-member-of".
+	Conditions []string `yaml:"conditions"`
+
+	// Users holds the usernames that the rule applies to.
+	Users []string `yaml:"users"`
+
+	// Domains holds the user domains that the rule applies to. The
+	// domain of a user is the part of the username after the final
+	// "@". Users without a domain have a domain of "".
+	Domains []string `yaml:"domains"`
+
+	// IdentityProviders holds the names of the identity providers
+	// that the rule applies to.
+	IdentityProviders []string `yaml:"identity-providers"`
+
+	// Groups holds the groups that the rule applies to. A user
+	// matches a non-negated group if they are a member of it, and
+	// matches a negated group if they are not.
+	Groups []string `yaml:"groups"`
+
+	// SourceAddresses holds the IP addresses, or CIDR networks, that
+	// the discharge request may come from.
+	SourceAddresses []string `yaml:"source-addresses"`
+
+	// TimeOfDay holds the range of times, in UTC, that the rule
+	// applies to. It is in the form "HH:MM-HH:MM". The range
+	// includes the start time and excludes the end time. If the end
+	// is earlier than the start the range wraps past midnight.
+	TimeOfDay string `yaml:"time-of-day"`
+
+	// Action holds the action to take when the rule matches, either
+	// "allow" or "deny". The default is "allow".
+	Action string `yaml:"action"`
+
+	// Expiry, if non-zero, holds the maximum lifetime of the
+	// discharge macaroon when the rule allows a discharge.
+	Expiry time.Duration `yaml:"expiry"`
+
+	// Caveats holds the conditions of any additional first-party
+	// caveats to add to the discharge macaroon when the rule allows
+	// a discharge.
+	Caveats []string `yaml:"caveats"`
+}
+
+// A Request holds the details of a discharge request that a policy is
+// evaluated against.
+type Request struct {
+	// Condition holds the condition of the caveat being discharged,
+	// without any arguments.
+	Condition string
+
+	// Username holds the username of the identity being discharged.
+	Username string
+
+	// IdentityProvider holds the name of the identity provider that
+	// authenticated the identity.
+	IdentityProvider string
+
+	// Groups holds all of the groups the identity is a member of.
+	Groups []string
+
+	// GroupsIncomplete holds whether Groups may be missing some of
+	// the groups the identity is a member of, because they could
+	// not all be resolved. A rule whose group criteria cannot be
+	// decided from the known groups is then taken to match if it
+	// denies the discharge and not to match if it allows it.
+	GroupsIncomplete bool
+
+	// SourceAddress holds the IP address the request came from. It
+	// may be nil if the address is not known, in which case no rule
+	// with source addresses will match.
+	SourceAddress net.IP
+
+	// Time holds the time of the request.
+	Time time.Time
+}
+
+// A Decision holds the result of evaluating a policy.
+type Decision struct {
+	// Rule holds the name of the rule that matched the request. If
+	// no rule matched this will be empty.
+	Rule string
+
+	// Allow holds whether the discharge should be allowed.
+	Allow bool
+
+	// Expiry, if non-zero, holds the maximum lifetime of the
+	// discharge macaroon.
+	Expiry time.Duration
+
+	// Caveats holds any additional first-party caveats to add to the
+	// discharge macaroon.
+	Caveats []checkers.Caveat
+}
+
+// A Policy is a compiled set of rules. The rules are evaluated in order
+// and the first rule that matches a request decides the result.
+type Policy struct {
+	rules []rule
+}
+
+// New compiles the given rules into a Policy.
+func New(rules []Rule) (*Policy, error) {
+	p := &Policy{
+		rules: make([]rule, len(rules)),
+	}
+	for i, r := range rules {
+		if err := p.rules[i].init(r); err != nil {
+			name := r.Name
+			if name == "" {
+				name = "#" + strconv.Itoa(i+1)
+			}
+			return nil, errgo.Notef(err, "invalid rule %s", name)
+		}
+	}
+	return p, nil
+}
+
+// Evaluate determines the result of the policy for the given request.
+// If no rule matches the request then the discharge is allowed without
+// any further restriction. It is valid to call Evaluate on a nil
+// Policy.
+func (p *Policy) Evaluate(req Request) Decision {
+	if p == nil {
+		return Decision{Allow: true}
+	}
+	for _, r := range p.rules {
+		if !r.match(req) {
+			continue
+		}
+		d := Decision{
+			Rule:  r.Name,
+			Allow: r.Action != ActionDeny,
+		}
+		if d.Allow {
+			d.Expiry = r.Expiry
+			for _, c := range r.Caveats {
+				d.Caveats = append(d.Caveats, checkers.Caveat{Condition: c})
+			}
+		}
+		return d
+	}
+	return Decision{Allow: true}
+}
+
+// rule is the compiled form of a Rule.
+type rule struct {
+	Rule
+	networks []*net.IPNet
+	hasTime  bool
+	from, to time.Duration
+}
+
+func (r *rule) init(rule Rule) error {
+	r.Rule = rule
+	switch r.Action {
+	case "":
+		r.Action = ActionAllow
+	case ActionAllow, ActionDeny:
+	default:
+		return errgo.Newf("unrecognised action %q", r.Action)
+	}
+	if r.Expiry < 0 {
+		return errgo.Newf("invalid expiry %v", r.Expiry)
+	}
+	for _, c := range r.Caveats {
+		if _, _, err := checkers.ParseCaveat(c); err != nil {
+			return errgo.Notef(err, "invalid caveat %q", c)
+		}
+	}
+	networks, err := ParseNetworks(r.SourceAddresses)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	r.networks = networks
+	if r.TimeOfDay != "" {
+		parts := strings.Split(r.TimeOfDay, "-")
+		if len(parts) != 2 {
+			return errgo.Newf("invalid time-of-day %q", r.TimeOfDay)
+		}
+		if r.from, err = parseClock(parts[0]); err != nil {
+			return errgo.Newf("invalid time-of-day %q", r.TimeOfDay)
+		}
+		if r.to, err = parseClock(parts[1]); err != nil {
+			return errgo.Newf("invalid time-of-day %q", r.TimeOfDay)
+		}
+		r.hasTime = true
+	}
+	return nil
+}
+
+func (r *rule) match(req Request) bool {
+	if !matchNames(r.Conditions, req.Condition) {
+		return false
+	}
+	if !matchNames(r.Users, req.Username) {
+		return false
+	}
+	if !matchNames(r.Domains, domain(req.Username)) {
+		return false
+	}
+	if !matchNames(r.IdentityProviders, req.IdentityProvider) {
+		return false
+	}
+	if !matchGroups(r.Groups, req.Groups, req.GroupsIncomplete, r.Action == ActionDeny) {
+		return false
+	}
+	if len(r.networks) > 0 && !matchAddress(r.networks, req.SourceAddress) {
+		return false
+	}
+	if r.hasTime && !r.matchTime(req.Time) {
+		return false
+	}
+	return true
+}
+
+// matchAddress reports whether the given address is in any of the
+// given networks.
+func matchAddress(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) matchTime(t time.Time) bool {
+	t = t.UTC()
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if r.from <= r.to {
+		return r.from <= d && d < r.to
+	}
+	return r.from <= d || d < r.to
+}
+
+// matchNames reports whether the given value matches the list of
+// names, as described in the documentation for Rule.
+func matchNames(names []string, v string) bool {
+	return matchList(names, func(name string) bool {
+		return name == v
+	})
+}
+
+// matchGroups reports whether a user in the given groups matches the
+// list of group names. If the groups are incomplete, so that the user
+// may also be a member of groups that are not listed, then unknown is
+// returned when the result cannot be determined from the listed
+// groups.
+func matchGroups(names []string, groups []string, incomplete, unknown bool) bool {
+	member := func(name string) bool {
+		for _, g := range groups {
+			if g == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !incomplete {
+		return matchList(names, member)
+	}
+	if len(names) == 0 {
+		return true
+	}
+	positive, matched, negated := false, false, false
+	for _, name := range names {
+		if strings.HasPrefix(name, "!") {
+			if member(name[1:]) {
+				return false
+			}
+			negated = true
+			continue
+		}
+		positive = true
+		if member(name) {
+			matched = true
+		}
+	}
+	if negated || (positive && !matched) {
+		return unknown
+	}
+	return true
+}
+
+func matchList(names []string, f func(string) bool) bool {
+	if len(names) == 0 {
+		return true
+	}
+	positive, matched := false, false
+	for _, name := range names {
+		if strings.HasPrefix(name, "!") {
+			if f(name[1:]) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		if f(name) {
+			matched = true
+		}
+	}
+	return matched || !positive
+}
+
+// domain returns the domain part of the given username.
+func domain(username string) string {
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		return username[i+1:]
+	}
+	return ""
+}
+
+// ParseNetworks parses the given list of IP addresses and CIDR networks.
+// An IP address is treated as a network containing only that address.
+func ParseNetworks(addrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, errgo.Newf("invalid source address %q", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, errgo.Newf("invalid source address %q", a)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// SourceAddress returns the address of the client that made the given
+// request. If the request was received from one of the given trusted
+// proxies then the client address is taken from the X-Forwarded-For
+// header: the last address in the header that is not itself a trusted
+// proxy is used. It returns nil if the address cannot be determined.
+func SourceAddress(req *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !matchAddress(trustedProxies, ip) {
+		return ip
+	}
+	var forwarded []string
+	for _, h := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			return nil
+		}
+		if !matchAddress(trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// parseClock parses a time of day in the form HH:MM.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,487 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package policy_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/macaroon-bakery.v2/bakery/checkers"
+
+	"github.com/canonical/candid/policy"
+)
+
+var noon = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+var evaluateTests = []struct {
+	about          string
+	rules          []policy.Rule
+	req            policy.Request
+	expectDecision policy.Decision
+}{{
+	about: "no rules",
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@candid",
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "deny matching domain",
+	rules: []policy.Rule{{
+		Name:    "no-external",
+		Domains: []string{"external"},
+		Action:  policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@external",
+	},
+	expectDecision: policy.Decision{Rule: "no-external"},
+}, {
+	about: "domain does not match",
+	rules: []policy.Rule{{
+		Name:    "no-external",
+		Domains: []string{"external"},
+		Action:  policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@candid",
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "negated domain",
+	rules: []policy.Rule{{
+		Name:    "short-external",
+		Domains: []string{"!candid"},
+		Expiry:  time.Hour,
+		Caveats: []string{"declared source external"},
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@external",
+	},
+	expectDecision: policy.Decision{
+		Rule:    "short-external",
+		Allow:   true,
+		Expiry:  time.Hour,
+		Caveats: []checkers.Caveat{{Condition: "declared source external"}},
+	},
+}, {
+	about: "negated domain does not match",
+	rules: []policy.Rule{{
+		Name:    "short-external",
+		Domains: []string{"!candid"},
+		Expiry:  time.Hour,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@candid",
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "first matching rule wins",
+	rules: []policy.Rule{{
+		Name:  "admins",
+		Users: []string{"admin@candid"},
+	}, {
+		Name:   "everyone",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "admin@candid",
+	},
+	expectDecision: policy.Decision{Rule: "admins", Allow: true},
+}, {
+	about: "condition",
+	rules: []policy.Rule{{
+		Name:       "no-groups",
+		Conditions: []string{"is-member-of"},
+		Action:     policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "identity provider",
+	rules: []policy.Rule{{
+		Name:              "ldap",
+		IdentityProviders: []string{"ldap"},
+		Expiry:            10 * time.Minute,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		IdentityProvider: "ldap",
+	},
+	expectDecision: policy.Decision{Rule: "ldap", Allow: true, Expiry: 10 * time.Minute},
+}, {
+	about: "group member",
+	rules: []policy.Rule{{
+		Name:   "contractors",
+		Groups: []string{"contractors"},
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Groups:    []string{"dev", "contractors"},
+	},
+	expectDecision: policy.Decision{Rule: "contractors"},
+}, {
+	about: "negated group",
+	rules: []policy.Rule{{
+		Name:   "staff-only",
+		Groups: []string{"!staff"},
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Groups:    []string{"staff"},
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "negated group with incomplete groups denies",
+	rules: []policy.Rule{{
+		Name:   "staff-only",
+		Groups: []string{"!staff"},
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		Groups:           []string{"dev"},
+		GroupsIncomplete: true,
+	},
+	expectDecision: policy.Decision{Rule: "staff-only"},
+}, {
+	about: "known negated group with incomplete groups",
+	rules: []policy.Rule{{
+		Name:   "staff-only",
+		Groups: []string{"!staff"},
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		Groups:           []string{"staff"},
+		GroupsIncomplete: true,
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "group with incomplete groups denies",
+	rules: []policy.Rule{{
+		Name:   "contractors",
+		Groups: []string{"contractors"},
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		GroupsIncomplete: true,
+	},
+	expectDecision: policy.Decision{Rule: "contractors"},
+}, {
+	about: "negated group with incomplete groups does not allow",
+	rules: []policy.Rule{{
+		Name:   "non-contractors",
+		Groups: []string{"!contractors"},
+		Expiry: time.Hour,
+	}, {
+		Name:   "everyone-else",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		Groups:           []string{"dev"},
+		GroupsIncomplete: true,
+	},
+	expectDecision: policy.Decision{Rule: "everyone-else"},
+}, {
+	about: "known group with incomplete groups allows",
+	rules: []policy.Rule{{
+		Name:   "dev",
+		Groups: []string{"dev"},
+		Expiry: time.Hour,
+	}},
+	req: policy.Request{
+		Condition:        "is-authenticated-user",
+		Username:         "bob",
+		Groups:           []string{"dev"},
+		GroupsIncomplete: true,
+	},
+	expectDecision: policy.Decision{Rule: "dev", Allow: true, Expiry: time.Hour},
+}, {
+	about: "source address in network",
+	rules: []policy.Rule{{
+		Name:            "office",
+		SourceAddresses: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, {
+		Name:   "elsewhere",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:     "is-authenticated-user",
+		Username:      "bob",
+		SourceAddress: net.ParseIP("10.1.2.3"),
+	},
+	expectDecision: policy.Decision{Rule: "office", Allow: true},
+}, {
+	about: "source address exact",
+	rules: []policy.Rule{{
+		Name:            "office",
+		SourceAddresses: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, {
+		Name:   "elsewhere",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:     "is-authenticated-user",
+		Username:      "bob",
+		SourceAddress: net.ParseIP("192.168.1.1"),
+	},
+	expectDecision: policy.Decision{Rule: "office", Allow: true},
+}, {
+	about: "source address not matched",
+	rules: []policy.Rule{{
+		Name:            "office",
+		SourceAddresses: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, {
+		Name:   "elsewhere",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition:     "is-authenticated-user",
+		Username:      "bob",
+		SourceAddress: net.ParseIP("192.168.1.2"),
+	},
+	expectDecision: policy.Decision{Rule: "elsewhere"},
+}, {
+	about: "unknown source address",
+	rules: []policy.Rule{{
+		Name:            "office",
+		SourceAddresses: []string{"10.0.0.0/8"},
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "within time of day",
+	rules: []policy.Rule{{
+		Name:      "working-hours",
+		TimeOfDay: "09:00-17:00",
+	}, {
+		Name:   "out-of-hours",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Time:      noon,
+	},
+	expectDecision: policy.Decision{Rule: "working-hours", Allow: true},
+}, {
+	about: "time of day uses UTC",
+	rules: []policy.Rule{{
+		Name:      "working-hours",
+		TimeOfDay: "09:00-17:00",
+	}, {
+		Name:   "out-of-hours",
+		Action: policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Time:      noon.In(time.FixedZone("X", 8*60*60)),
+	},
+	expectDecision: policy.Decision{Rule: "working-hours", Allow: true},
+}, {
+	about: "end of time of day is excluded",
+	rules: []policy.Rule{{
+		Name:      "morning",
+		TimeOfDay: "00:00-12:00",
+		Action:    policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Time:      noon,
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "time of day wrapping midnight",
+	rules: []policy.Rule{{
+		Name:      "night",
+		TimeOfDay: "22:00-06:00",
+		Expiry:    time.Minute,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Time:      noon.Add(11 * time.Hour),
+	},
+	expectDecision: policy.Decision{Rule: "night", Allow: true, Expiry: time.Minute},
+}, {
+	about: "outside time of day wrapping midnight",
+	rules: []policy.Rule{{
+		Name:      "night",
+		TimeOfDay: "22:00-06:00",
+		Expiry:    time.Minute,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob",
+		Time:      noon,
+	},
+	expectDecision: policy.Decision{Allow: true},
+}, {
+	about: "all criteria must match",
+	rules: []policy.Rule{{
+		Name:    "external-contractors",
+		Domains: []string{"external"},
+		Groups:  []string{"contractors"},
+		Action:  policy.ActionDeny,
+	}},
+	req: policy.Request{
+		Condition: "is-authenticated-user",
+		Username:  "bob@external",
+		Groups:    []string{"staff"},
+	},
+	expectDecision: policy.Decision{Allow: true},
+}}
+
+func TestEvaluate(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range evaluateTests {
+		c.Run(test.about, func(c *qt.C) {
+			p, err := policy.New(test.rules)
+			c.Assert(err, qt.IsNil)
+			c.Assert(p.Evaluate(test.req), qt.DeepEquals, test.expectDecision)
+		})
+	}
+}
+
+func TestEvaluateNilPolicy(t *testing.T) {
+	c := qt.New(t)
+	var p *policy.Policy
+	c.Assert(p.Evaluate(policy.Request{Username: "bob"}), qt.DeepEquals, policy.Decision{Allow: true})
+}
+
+var newErrorTests = []struct {
+	about       string
+	rule        policy.Rule
+	expectError string
+}{{
+	about:       "unknown action",
+	rule:        policy.Rule{Name: "r", Action: "maybe"},
+	expectError: `invalid rule r: unrecognised action "maybe"`,
+}, {
+	about:       "negative expiry",
+	rule:        policy.Rule{Name: "r", Expiry: -time.Second},
+	expectError: `invalid rule r: invalid expiry -1s`,
+}, {
+	about:       "invalid caveat",
+	rule:        policy.Rule{Name: "r", Caveats: []string{""}},
+	expectError: `invalid rule r: invalid caveat "": .*`,
+}, {
+	about:       "invalid address",
+	rule:        policy.Rule{Name: "r", SourceAddresses: []string{"10.0.0"}},
+	expectError: `invalid rule r: invalid source address "10.0.0"`,
+}, {
+	about:       "invalid network",
+	rule:        policy.Rule{Name: "r", SourceAddresses: []string{"10.0.0.0/33"}},
+	expectError: `invalid rule r: invalid source address "10.0.0.0/33"`,
+}, {
+	about:       "invalid time of day",
+	rule:        policy.Rule{Name: "r", TimeOfDay: "9am-5pm"},
+	expectError: `invalid rule r: invalid time-of-day "9am-5pm"`,
+}, {
+	about:       "time of day without range",
+	rule:        policy.Rule{Name: "r", TimeOfDay: "09:00"},
+	expectError: `invalid rule r: invalid time-of-day "09:00"`,
+}, {
+	about:       "unnamed rule",
+	rule:        policy.Rule{Action: "maybe"},
+	expectError: `invalid rule #1: unrecognised action "maybe"`,
+}}
+
+func TestNewError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range newErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			_, err := policy.New([]policy.Rule{test.rule})
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+var sourceAddressTests = []struct {
+	about          string
+	remoteAddr     string
+	forwardedFor   []string
+	trustedProxies []string
+	expectAddress  string
+}{{
+	about:         "no proxies",
+	remoteAddr:    "192.0.2.1:1234",
+	forwardedFor:  []string{"198.51.100.1"},
+	expectAddress: "192.0.2.1",
+}, {
+	about:          "untrusted proxy",
+	remoteAddr:     "192.0.2.1:1234",
+	forwardedFor:   []string{"198.51.100.1"},
+	trustedProxies: []string{"10.0.0.0/8"},
+	expectAddress:  "192.0.2.1",
+}, {
+	about:          "trusted proxy",
+	remoteAddr:     "10.0.0.1:1234",
+	forwardedFor:   []string{"198.51.100.1"},
+	trustedProxies: []string{"10.0.0.0/8"},
+	expectAddress:  "198.51.100.1",
+}, {
+	about:          "addresses added by the client are ignored",
+	remoteAddr:     "10.0.0.1:1234",
+	forwardedFor:   []string{"203.0.113.1, 198.51.100.1", "10.0.0.2"},
+	trustedProxies: []string{"10.0.0.0/8"},
+	expectAddress:  "198.51.100.1",
+}, {
+	about:          "invalid forwarded address",
+	remoteAddr:     "10.0.0.1:1234",
+	forwardedFor:   []string{"bad"},
+	trustedProxies: []string{"10.0.0.0/8"},
+}, {
+	about:      "invalid remote address",
+	remoteAddr: "bad",
+}}
+
+func TestSourceAddress(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range sourceAddressTests {
+		c.Run(test.about, func(c *qt.C) {
+			proxies, err := policy.ParseNetworks(test.trustedProxies)
+			c.Assert(err, qt.IsNil)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, h := range test.forwardedFor {
+				req.Header.Add("X-Forwarded-For", h)
+			}
+			ip := policy.SourceAddress(req, proxies)
+			if test.expectAddress == "" {
+				c.Assert(ip, qt.IsNil)
+				return
+			}
+			c.Assert(ip.String(), qt.Equals, test.expectAddress)
+		})
+	}
+}
@@ -14,26 +14,33 @@ import (
 	"gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/idp"
 	"github.com/canonical/candid/idp/agent"
 	"github.com/canonical/candid/internal/debug"
 	"github.com/canonical/candid/internal/discharger"
 	"github.com/canonical/candid/internal/identity"
+	"github.com/canonical/candid/internal/scim"
 	v1 "github.com/canonical/candid/internal/v1"
 	"github.com/canonical/candid/meeting"
+	"github.com/canonical/candid/oidcissuer"
+	"github.com/canonical/candid/policy"
 	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/webhook"
 )
 
 // Versions of the API that can be served.
 const (
 	Debug      = "debug"
 	Discharger = "discharger"
+	SCIM       = "scim"
 	V1         = "v1"
 )
 
 var versions = map[string]identity.NewAPIHandlerFunc{
 	Debug:      debug.NewAPIHandler,
 	Discharger: discharger.NewAPIHandler,
+	SCIM:       scim.NewAPIHandler,
 	V1:         v1.NewAPIHandler,
 }
 
@@ -55,7 +62,8 @@ type ServerParams struct {
 
 	// ProviderDataStore holds the storeage that can be used by
 	// identity providers to store data that is not associated with
-	// an individual identity.
+	// an individual identity. It is also used to record deleted
+	// identities, so it must be set.
 	ProviderDataStore store.ProviderDataStore
 
 	// RootKeyStore holds the root key store that will be used to
@@ -134,6 +142,61 @@ type ServerParams struct {
 	// EnableEmailLogin enables the login with email address link on the
 	// authentication required page.
 	EnableEmailLogin bool
+
+	// AuditSink holds the sink to which audit events are written.
+	// If this is nil then no audit events will be recorded. If the
+	// sink also implements audit.Store then the recorded events can
+	// be retrieved using the /v1/audit endpoint.
+	AuditSink audit.Sink
+
+	// OIDCClients holds the relying parties that may use the
+	// identity server as an OpenID Connect provider.
+	OIDCClients []oidcissuer.Client
+
+	// OIDCTokenTimeout is the maximum life of an OpenID Connect
+	// ID token or access token.
+	OIDCTokenTimeout time.Duration
+
+	// ReaperInterval holds the time between runs of the identity
+	// reaper. If this is zero the reaper does not run, although
+	// the /v1/reaper endpoint still reports what it would do.
+	ReaperInterval time.Duration
+
+	// InactiveIdentityPeriod holds the length of time after which
+	// an identity that has neither logged in nor been discharged
+	// is considered inactive by the reaper. If this is zero
+	// inactive identities are ignored.
+	InactiveIdentityPeriod time.Duration
+
+	// InactiveIdentityAction holds the action the reaper takes on
+	// inactive identities, it must be one of "flag", "suspend" or
+	// "delete". If this is empty "flag" is used.
+	InactiveIdentityAction string
+
+	// RemoveOrphanedAgents holds whether the reaper deletes agents
+	// whose owner no longer exists.
+	RemoveOrphanedAgents bool
+
+	// MaxElevationDuration holds the longest time for which an
+	// approved elevation may grant membership of a group. If this
+	// is zero a default of 8 hours is used.
+	MaxElevationDuration time.Duration
+
+	// DischargePolicy holds rules that are evaluated, in order,
+	// when a third-party caveat is discharged. The first matching
+	// rule decides whether the discharge is allowed and which
+	// additional caveats are added to the discharge macaroon.
+	DischargePolicy []policy.Rule
+
+	// TrustedProxies holds the IP addresses, or CIDR networks, of
+	// the reverse proxies that are trusted to report the address of
+	// the client in the X-Forwarded-For header. The client address
+	// is used when evaluating the discharge policy.
+	TrustedProxies []string
+
+	// Webhooks holds the webhooks to which identity change events
+	// are sent.
+	Webhooks []webhook.Webhook
 }
 
 // NewServer returns a new handler that handles identity service requests and
@@ -0,0 +1,162 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package archive reads and writes archives holding the data from a
+// store.Backend in a form that does not depend on the backend
+// implementation.
+//
+// An archive is a tar file. The first entry, manifest.json, holds the
+// archive format version. It is followed by JSON-lines entries holding
+// the groups, identities, ACLs and identity provider data. Bakery root
+// keys, meetings, group changes, elevations and audit events are not
+// included in an archive.
+package archive
+
+import (
+	"time"
+
+	"github.com/juju/loggo"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.store.archive")
+
+// Version holds the version of the archive format written by Export.
+// Import will refuse archives with a later version.
+const Version = 1
+
+// Names of the entries in an archive.
+const (
+	manifestEntry     = "manifest.json"
+	groupsEntry       = "groups.jsonl"
+	identitiesEntry   = "identities.jsonl"
+	aclsEntry         = "acls.jsonl"
+	providerDataEntry = "provider-data.jsonl"
+)
+
+// sshKeysInfo is the ExtraInfo key in which SSH keys are stored.
+const sshKeysInfo = "sshkeys"
+
+// manifest is the contents of the manifest entry.
+type manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// identity is the archived form of a store.Identity. SSH keys are held
+// separately from the rest of the ExtraInfo.
+type identity struct {
+	ProviderID    string               `json:"provider-id"`
+	Username      string               `json:"username"`
+	Name          string               `json:"name,omitempty"`
+	Email         string               `json:"email,omitempty"`
+	Groups        []string             `json:"groups,omitempty"`
+	GroupExpiry   map[string]time.Time `json:"group-expiry,omitempty"`
+	PublicKeys    []bakery.PublicKey   `json:"public-keys,omitempty"`
+	SSHKeys       []string             `json:"ssh-keys,omitempty"`
+	ProviderInfo  map[string][]string  `json:"provider-info,omitempty"`
+	ExtraInfo     map[string][]string  `json:"extra-info,omitempty"`
+	Owner         string               `json:"owner,omitempty"`
+	LastLogin     *time.Time           `json:"last-login,omitempty"`
+	LastDischarge *time.Time           `json:"last-discharge,omitempty"`
+	TokensRevoked *time.Time           `json:"tokens-revoked,omitempty"`
+	Suspended     bool                 `json:"suspended,omitempty"`
+}
+
+func fromIdentity(id *store.Identity) *identity {
+	aid := &identity{
+		ProviderID:    string(id.ProviderID),
+		Username:      id.Username,
+		Name:          id.Name,
+		Email:         id.Email,
+		Groups:        id.Groups,
+		PublicKeys:    id.PublicKeys,
+		Owner:         string(id.Owner),
+		LastLogin:     timePtr(id.LastLogin),
+		LastDischarge: timePtr(id.LastDischarge),
+		TokensRevoked: timePtr(id.TokensRevoked),
+		Suspended:     id.Suspended,
+	}
+	if len(id.GroupExpiry) > 0 {
+		aid.GroupExpiry = id.GroupExpiry
+	}
+	if len(id.ProviderInfo) > 0 {
+		aid.ProviderInfo = id.ProviderInfo
+	}
+	for k, v := range id.ExtraInfo {
+		if k == sshKeysInfo {
+			aid.SSHKeys = v
+			continue
+		}
+		if aid.ExtraInfo == nil {
+			aid.ExtraInfo = make(map[string][]string)
+		}
+		aid.ExtraInfo[k] = v
+	}
+	return aid
+}
+
+func (aid *identity) toIdentity() *store.Identity {
+	id := &store.Identity{
+		ProviderID:   store.ProviderIdentity(aid.ProviderID),
+		Username:     aid.Username,
+		Name:         aid.Name,
+		Email:        aid.Email,
+		Groups:       aid.Groups,
+		GroupExpiry:  aid.GroupExpiry,
+		PublicKeys:   aid.PublicKeys,
+		ProviderInfo: aid.ProviderInfo,
+		Owner:        store.ProviderIdentity(aid.Owner),
+		Suspended:    aid.Suspended,
+	}
+	if aid.LastLogin != nil {
+		id.LastLogin = *aid.LastLogin
+	}
+	if aid.LastDischarge != nil {
+		id.LastDischarge = *aid.LastDischarge
+	}
+	if aid.TokensRevoked != nil {
+		id.TokensRevoked = *aid.TokensRevoked
+	}
+	if len(aid.ExtraInfo) > 0 || len(aid.SSHKeys) > 0 {
+		id.ExtraInfo = make(map[string][]string)
+		for k, v := range aid.ExtraInfo {
+			id.ExtraInfo[k] = v
+		}
+		if len(aid.SSHKeys) > 0 {
+			id.ExtraInfo[sshKeysInfo] = aid.SSHKeys
+		}
+	}
+	return id
+}
+
+// group is the archived form of a store.Group.
+type group struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Owners       []string `json:"owners,omitempty"`
+	MemberGroups []string `json:"member-groups,omitempty"`
+}
+
+// acl is the archived form of an ACL.
+type acl struct {
+	Name  string   `json:"name"`
+	Users []string `json:"users"`
+}
+
+// providerData is the archived form of a store.ProviderData.
+type providerData struct {
+	IDP    string     `json:"idp"`
+	Key    string     `json:"key"`
+	Value  []byte     `json:"value"`
+	Expire *time.Time `json:"expire,omitempty"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
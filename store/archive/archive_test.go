@@ -0,0 +1,476 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/archive"
+	"github.com/canonical/candid/store/boltstore"
+	"github.com/canonical/candid/store/memstore"
+)
+
+var epoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestExportEntries(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	b := memstore.NewBackend()
+	populate(c, b)
+	var buf bytes.Buffer
+	err := archive.Export(context.Background(), &buf, b)
+	c.Assert(err, qt.IsNil)
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, qt.IsNil)
+		names = append(names, hdr.Name)
+	}
+	c.Assert(names, qt.DeepEquals, []string{
+		"manifest.json",
+		"groups.jsonl",
+		"identities.jsonl",
+		"acls.jsonl",
+		"provider-data.jsonl",
+	})
+}
+
+func TestRoundTripMemstore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	testRoundTrip(c, memstore.NewBackend())
+}
+
+func TestRoundTripBolt(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	backend, err := boltstore.Params{Path: filepath.Join(c.Mkdir(), "candid.db")}.NewBackend()
+	c.Assert(err, qt.IsNil)
+	c.Defer(backend.Close)
+	testRoundTrip(c, backend)
+}
+
+func testRoundTrip(c *qt.C, dst store.Backend) {
+	ctx := context.Background()
+	src := memstore.NewBackend()
+	populate(c, src)
+	var buf bytes.Buffer
+	err := archive.Export(ctx, &buf, src)
+	c.Assert(err, qt.IsNil)
+
+	err = archive.Import(ctx, dst, &buf, archive.ConflictFail, nil)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(identities(c, dst), qt.DeepEquals, identities(c, src))
+
+	groups, err := dst.Store().FindGroups(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.DeepEquals, []store.Group{{
+		Name:         "group1",
+		Description:  "Group One",
+		Owners:       []string{"test1"},
+		MemberGroups: []string{"group2"},
+	}})
+
+	users, err := dst.ACLStore().Get(ctx, "read-user")
+	c.Assert(err, qt.IsNil)
+	c.Assert(users, qt.DeepEquals, []string{"admin@candid", "test1"})
+
+	kv, err := dst.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	v, err := kv.Get(ctx, "k1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(v), qt.Equals, "v1")
+}
+
+func TestImportConflictFail(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	dst := memstore.NewBackend()
+	err := dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "1"),
+		Username:   "test1",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = archive.Import(ctx, dst, exportPopulated(c), archive.ConflictFail, nil)
+	c.Assert(err, qt.ErrorMatches, `identity test1 \(test:1\) conflicts with existing identity test1 \(other:1\)`)
+
+	// Nothing has been imported.
+	groups, err := dst.Store().FindGroups(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+	c.Assert(identities(c, dst), qt.HasLen, 1)
+}
+
+func TestImportConflictSkip(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	dst := memstore.NewBackend()
+	err := dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "1"),
+		Username:   "test1",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.Store().AddGroup(ctx, &store.Group{
+		Name:        "group1",
+		Description: "Existing",
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.ACLStore().CreateACL(ctx, "read-user", []string{"admin@candid"})
+	c.Assert(err, qt.IsNil)
+	dstKV, err := dst.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = dstKV.Set(ctx, "2", []byte("existing"), time.Time{})
+	c.Assert(err, qt.IsNil)
+
+	// Add provider data belonging to the identity that will be
+	// skipped, keyed in the ways identity providers key it.
+	src := memstore.NewBackend()
+	populate(c, src)
+	srcKV, err := src.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	for _, k := range []string{"1", "2", "last-counter-test:1"} {
+		err = srcKV.Set(ctx, k, []byte("archived"), time.Time{})
+		c.Assert(err, qt.IsNil)
+	}
+	var buf bytes.Buffer
+	err = archive.Export(ctx, &buf, src)
+	c.Assert(err, qt.IsNil)
+
+	err = archive.Import(ctx, dst, &buf, archive.ConflictSkip, nil)
+	c.Assert(err, qt.IsNil)
+
+	ids := identities(c, dst)
+	c.Assert(ids, qt.HasLen, 2)
+	c.Assert(ids[0].ProviderID, qt.Equals, store.MakeProviderIdentity("other", "1"))
+	c.Assert(ids[1].ProviderID, qt.Equals, store.MakeProviderIdentity("test", "2"))
+
+	groups, err := dst.Store().FindGroups(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 1)
+	c.Assert(groups[0].Description, qt.Equals, "Existing")
+
+	// The skipped identity's username does not gain the archived
+	// ACL membership.
+	users, err := dst.ACLStore().Get(ctx, "read-user")
+	c.Assert(err, qt.IsNil)
+	c.Assert(users, qt.DeepEquals, []string{"admin@candid"})
+
+	// The skipped identity's provider data is not imported, the
+	// rest is.
+	for k, want := range map[string]string{
+		"k1": "v1",
+		"2":  "archived",
+	} {
+		v, err := dstKV.Get(ctx, k)
+		c.Assert(err, qt.IsNil)
+		c.Check(string(v), qt.Equals, want, qt.Commentf("%s", k))
+	}
+	for _, k := range []string{"1", "last-counter-test:1"} {
+		_, err := dstKV.Get(ctx, k)
+		c.Check(errgo.Cause(err), qt.Equals, simplekv.ErrNotFound, qt.Commentf("%s", k))
+	}
+}
+
+func TestImportConflictSkipKeepsACLMembership(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	dst := memstore.NewBackend()
+	err := dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "1"),
+		Username:   "test1",
+	}, store.Update{
+		store.Username: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.ACLStore().CreateACL(ctx, "read-user", []string{"test1"})
+	c.Assert(err, qt.IsNil)
+
+	err = archive.Import(ctx, dst, exportPopulated(c), archive.ConflictSkip, nil)
+	c.Assert(err, qt.IsNil)
+
+	users, err := dst.ACLStore().Get(ctx, "read-user")
+	c.Assert(err, qt.IsNil)
+	c.Assert(users, qt.DeepEquals, []string{"admin@candid", "test1"})
+}
+
+func TestImportConflictOverwrite(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	ctx := context.Background()
+	dst := memstore.NewBackend()
+	err := dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("other", "1"),
+		Username:   "test1",
+		Groups:     []string{"old-group"},
+	}, store.Update{
+		store.Username: store.Set,
+		store.Groups:   store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("idm", "agent1"),
+		Username:   "agent1@candid",
+		Owner:      store.MakeProviderIdentity("other", "1"),
+	}, store.Update{
+		store.Username: store.Set,
+		store.Owner:    store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "2"),
+		Username:   "test2",
+		Email:      "old@example.com",
+		ExtraInfo: map[string][]string{
+			"stale": {"value"},
+		},
+	}, store.Update{
+		store.Username:  store.Set,
+		store.Email:     store.Set,
+		store.ExtraInfo: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dst.Store().AddGroup(ctx, &store.Group{
+		Name:        "group1",
+		Description: "Existing",
+	})
+	c.Assert(err, qt.IsNil)
+
+	src := memstore.NewBackend()
+	populate(c, src)
+	var buf bytes.Buffer
+	err = archive.Export(ctx, &buf, src)
+	c.Assert(err, qt.IsNil)
+	err = archive.Import(ctx, dst, &buf, archive.ConflictOverwrite, dst.AuditStore())
+	c.Assert(err, qt.IsNil)
+
+	// The replaced identity and the agent it owned have been deleted.
+	c.Assert(identities(c, dst), qt.DeepEquals, identities(c, src))
+	events, err := dst.AuditStore().Events(ctx, audit.Filter{
+		Type: audit.DeleteUser,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.HasLen, 2)
+	c.Assert(events[0].User, qt.Equals, "agent1@candid")
+	c.Assert(events[1].User, qt.Equals, "test1")
+	c.Assert(events[1].Remove, qt.DeepEquals, []string{"old-group"})
+	changes, err := dst.Store().GroupChanges(ctx, store.GroupChangeFilter{
+		Group: "old-group",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 1)
+	c.Assert(changes[0].Username, qt.Equals, "test1")
+	c.Assert(changes[0].Op, qt.Equals, store.GroupRemove)
+	groups, err := dst.Store().FindGroups(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 1)
+	c.Assert(groups[0].Description, qt.Equals, "Group One")
+}
+
+func TestImportInvalidPolicy(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	err := archive.Import(context.Background(), memstore.NewBackend(), exportPopulated(c), "replace", nil)
+	c.Assert(err, qt.ErrorMatches, `invalid conflict policy "replace"`)
+}
+
+func TestImportUnsupportedVersion(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	r := tarArchive(c, map[string]string{
+		"manifest.json": `{"version": 2, "created": "2021-01-01T00:00:00Z"}`,
+	})
+	err := archive.Import(context.Background(), memstore.NewBackend(), r, archive.ConflictFail, nil)
+	c.Assert(err, qt.ErrorMatches, `unsupported archive version 2`)
+}
+
+func TestImportNoManifest(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	r := tarArchive(c, map[string]string{
+		"groups.jsonl": `{"name": "group1"}`,
+	})
+	err := archive.Import(context.Background(), memstore.NewBackend(), r, archive.ConflictFail, nil)
+	c.Assert(err, qt.ErrorMatches, `invalid archive: first entry is "groups.jsonl", not "manifest.json"`)
+}
+
+func TestImportIgnoresUnknownEntries(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	r := tarArchive(c, map[string]string{
+		"manifest.json": `{"version": 1, "created": "2021-01-01T00:00:00Z"}`,
+		"unknown.jsonl": `{"name": "group1"}`,
+	})
+	err := archive.Import(context.Background(), memstore.NewBackend(), r, archive.ConflictFail, nil)
+	c.Assert(err, qt.IsNil)
+}
+
+// populate adds some identities, groups, ACLs and provider data to the
+// given backend.
+func populate(c *qt.C, b store.Backend) {
+	ctx := context.Background()
+	k1 := bakery.MustGenerateKey()
+	err := b.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "1"),
+		Username:   "test1",
+		Name:       "Test User",
+		Email:      "test1@example.com",
+		Groups:     []string{"group1", "group2"},
+		PublicKeys: []bakery.PublicKey{k1.Public},
+		LastLogin:  epoch,
+		ProviderInfo: map[string][]string{
+			"p1": {"p1v1", "p1v2"},
+		},
+		ExtraInfo: map[string][]string{
+			"e1":      {"e1v1"},
+			"sshkeys": {"ssh-rsa AAAA test1@example.com"},
+		},
+		GroupExpiry: map[string]time.Time{
+			"group2": epoch.Add(24 * time.Hour),
+		},
+	}, store.Update{
+		store.Username:     store.Set,
+		store.Name:         store.Set,
+		store.Email:        store.Set,
+		store.Groups:       store.Set,
+		store.PublicKeys:   store.Set,
+		store.LastLogin:    store.Set,
+		store.ProviderInfo: store.Set,
+		store.ExtraInfo:    store.Set,
+		store.GroupExpiry:  store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	err = b.Store().UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "2"),
+		Username:   "test2",
+		Owner:      store.MakeProviderIdentity("test", "1"),
+		Suspended:  true,
+	}, store.Update{
+		store.Username:  store.Set,
+		store.Owner:     store.Set,
+		store.Suspended: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = b.Store().AddGroup(ctx, &store.Group{
+		Name:         "group1",
+		Description:  "Group One",
+		Owners:       []string{"test1"},
+		MemberGroups: []string{"group2"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = b.ACLStore().CreateACL(ctx, "read-user", []string{"admin@candid", "test1"})
+	c.Assert(err, qt.IsNil)
+
+	kv, err := b.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "k1", []byte("v1"), time.Time{})
+	c.Assert(err, qt.IsNil)
+}
+
+func exportPopulated(c *qt.C) io.Reader {
+	b := memstore.NewBackend()
+	populate(c, b)
+	var buf bytes.Buffer
+	err := archive.Export(context.Background(), &buf, b)
+	c.Assert(err, qt.IsNil)
+	return &buf
+}
+
+// identities returns all the identities in the given backend, sorted by
+// provider ID and in a form that can be compared between backends.
+func identities(c *qt.C, b store.Backend) []store.Identity {
+	ids, err := b.Store().FindIdentities(context.Background(), nil, store.Filter{}, nil, 0, 0)
+	c.Assert(err, qt.IsNil)
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].ProviderID < ids[j].ProviderID
+	})
+	for i := range ids {
+		id := &ids[i]
+		id.ID = ""
+		id.LastLogin = id.LastLogin.UTC()
+		for k, v := range id.GroupExpiry {
+			id.GroupExpiry[k] = v.UTC()
+		}
+		if len(id.Groups) == 0 {
+			id.Groups = nil
+		}
+		if len(id.PublicKeys) == 0 {
+			id.PublicKeys = nil
+		}
+		if len(id.ProviderInfo) == 0 {
+			id.ProviderInfo = nil
+		}
+		if len(id.ExtraInfo) == 0 {
+			id.ExtraInfo = nil
+		}
+		if len(id.GroupExpiry) == 0 {
+			id.GroupExpiry = nil
+		}
+	}
+	return ids
+}
+
+// tarArchive returns a tar archive containing the given entries in
+// name order.
+func tarArchive(c *qt.C, entries map[string]string) io.Reader {
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0600,
+			Size: int64(len(entries[name])),
+		})
+		c.Assert(err, qt.IsNil)
+		_, err = tw.Write([]byte(entries[name]))
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(tw.Close(), qt.IsNil)
+	return &buf
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/juju/aclstore/v2"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/internal/auth"
+	"github.com/canonical/candid/store"
+)
+
+// Export writes an archive of the data held in the given backend to w.
+func Export(ctx context.Context, w io.Writer, b store.RawBackend) error {
+	ctx, close := b.Store().Context(ctx)
+	defer close()
+
+	now := time.Now()
+	tw := tar.NewWriter(w)
+	entries := []struct {
+		name string
+		f    func(context.Context, *json.Encoder, store.RawBackend) error
+	}{
+		{manifestEntry, func(_ context.Context, enc *json.Encoder, _ store.RawBackend) error {
+			return enc.Encode(manifest{Version: Version, Created: now.UTC()})
+		}},
+		{groupsEntry, exportGroups},
+		{identitiesEntry, exportIdentities},
+		{aclsEntry, exportACLs},
+		{providerDataEntry, exportProviderData},
+	}
+	for _, e := range entries {
+		// The size of a tar entry must be known before it is
+		// written, so each entry is built in memory first.
+		var buf bytes.Buffer
+		if err := e.f(ctx, json.NewEncoder(&buf), b); err != nil {
+			return errgo.Notef(err, "cannot export %s", e.name)
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     e.name,
+			Mode:     0600,
+			Size:     int64(buf.Len()),
+			ModTime:  now,
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if _, err := tw.Write(buf.Bytes()); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return errgo.Mask(tw.Close())
+}
+
+func exportGroups(ctx context.Context, enc *json.Encoder, b store.RawBackend) error {
+	groups, err := b.Store().FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, g := range groups {
+		err := enc.Encode(group{
+			Name:         g.Name,
+			Description:  g.Description,
+			Owners:       g.Owners,
+			MemberGroups: g.MemberGroups,
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func exportIdentities(ctx context.Context, enc *json.Encoder, b store.RawBackend) error {
+	identities, err := b.Store().FindIdentities(ctx, nil, store.Filter{}, nil, 0, 0)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range identities {
+		if err := enc.Encode(fromIdentity(&identities[i])); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func exportACLs(ctx context.Context, enc *json.Encoder, b store.RawBackend) error {
+	for _, name := range aclNames() {
+		users, err := b.ACLStore().Get(ctx, name)
+		if errgo.Cause(err) == aclstore.ErrACLNotFound {
+			continue
+		}
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if err := enc.Encode(acl{Name: name, Users: users}); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+func exportProviderData(ctx context.Context, enc *json.Encoder, b store.RawBackend) error {
+	data, err := b.ProviderData(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, pd := range data {
+		err := enc.Encode(providerData{
+			IDP:    pd.IDP,
+			Key:    pd.Key,
+			Value:  pd.Value,
+			Expire: timePtr(pd.Expire),
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// aclNames returns the names of all the ACLs held by the identity
+// server, including the meta-ACLs that control who can change them.
+func aclNames() []string {
+	var names []string
+	for _, name := range auth.ACLs() {
+		names = append(names, name, "_"+name)
+	}
+	return names
+}
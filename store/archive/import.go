@@ -0,0 +1,448 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/internal/deletion"
+	"github.com/canonical/candid/store"
+)
+
+// A ConflictPolicy determines what Import does with an archived
+// identity or group that conflicts with one already in the backend.
+// An identity conflicts with an existing identity that has either the
+// same provider ID or the same username. A group conflicts with an
+// existing group of the same name.
+type ConflictPolicy string
+
+const (
+	// ConflictFail causes Import to fail, without making any
+	// changes, if there are any conflicts.
+	ConflictFail ConflictPolicy = "fail"
+
+	// ConflictSkip leaves the existing identity or group unchanged
+	// and does not import the archived one.
+	ConflictSkip ConflictPolicy = "skip"
+
+	// ConflictOverwrite replaces the existing identity or group with
+	// the archived one. Any other identity holding the same username
+	// is deleted along with any agents it owns, in the same way as
+	// deleting the user through the API: the deletions are recorded
+	// in the audit log and the groups removed are recorded in the
+	// group change history.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+// Valid reports whether p is a known conflict policy.
+func (p ConflictPolicy) Valid() bool {
+	switch p {
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+		return true
+	}
+	return false
+}
+
+// contents holds the parsed contents of an archive.
+type contents struct {
+	groups       []group
+	identities   []identity
+	acls         []acl
+	providerData []providerData
+}
+
+// Import loads the archive read from r into the given backend. Identities
+// and groups that conflict with existing ones are handled according to
+// the given policy. The ACLs and identity provider data in the archive
+// replace any existing values, except that, when an identity is skipped,
+// its provider data is not imported and the existing ACL membership of
+// its username is kept. Any identities deleted to resolve conflicts are
+// recorded in the given audit sink, which may be nil.
+func Import(ctx context.Context, b store.Backend, r io.Reader, policy ConflictPolicy, sink audit.Sink) error {
+	if !policy.Valid() {
+		return errgo.Newf("invalid conflict policy %q", policy)
+	}
+	c, err := read(r)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	ctx, close := b.Store().Context(ctx)
+	defer close()
+
+	if policy == ConflictFail {
+		if err := checkConflicts(ctx, b.Store(), c); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if err := importGroups(ctx, b.Store(), c.groups, policy); err != nil {
+		return errgo.Notef(err, "cannot import groups")
+	}
+	kv, err := b.ProviderDataStore().KeyValueStore(ctx, deletion.KeyValueStore)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	dp := deletion.Params{
+		Store:         b.Store(),
+		KeyValueStore: kv,
+		Audit: func(ctx context.Context, e audit.Event) {
+			if sink == nil {
+				return
+			}
+			if err := sink.Log(ctx, e); err != nil {
+				logger.Errorf("cannot record %s audit event: %s", e.Type, err)
+			}
+		},
+	}
+	skipped, err := importIdentities(ctx, dp, c.identities, policy)
+	if err != nil {
+		return errgo.Notef(err, "cannot import identities")
+	}
+	if err := importACLs(ctx, b, c.acls, skipped); err != nil {
+		return errgo.Notef(err, "cannot import ACLs")
+	}
+	if err := importProviderData(ctx, b, c.providerData, skipped); err != nil {
+		return errgo.Notef(err, "cannot import provider data")
+	}
+	return nil
+}
+
+// read reads and parses the archive read from r.
+func read(r io.Reader) (*contents, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive")
+	}
+	if hdr.Name != manifestEntry {
+		return nil, errgo.Newf("invalid archive: first entry is %q, not %q", hdr.Name, manifestEntry)
+	}
+	var m manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, errgo.Notef(err, "cannot read manifest")
+	}
+	if m.Version < 1 || m.Version > Version {
+		return nil, errgo.Newf("unsupported archive version %d", m.Version)
+	}
+	var c contents
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot read archive")
+		}
+		var newRecord func() interface{}
+		switch hdr.Name {
+		case groupsEntry:
+			newRecord = func() interface{} {
+				c.groups = append(c.groups, group{})
+				return &c.groups[len(c.groups)-1]
+			}
+		case identitiesEntry:
+			newRecord = func() interface{} {
+				c.identities = append(c.identities, identity{})
+				return &c.identities[len(c.identities)-1]
+			}
+		case aclsEntry:
+			newRecord = func() interface{} {
+				c.acls = append(c.acls, acl{})
+				return &c.acls[len(c.acls)-1]
+			}
+		case providerDataEntry:
+			newRecord = func() interface{} {
+				c.providerData = append(c.providerData, providerData{})
+				return &c.providerData[len(c.providerData)-1]
+			}
+		default:
+			logger.Warningf("ignoring unknown archive entry %q", hdr.Name)
+			continue
+		}
+		dec := json.NewDecoder(tr)
+		for dec.More() {
+			if err := dec.Decode(newRecord()); err != nil {
+				return nil, errgo.Notef(err, "cannot read %s", hdr.Name)
+			}
+		}
+	}
+	return &c, nil
+}
+
+// checkConflicts returns an error if any of the identities or groups in
+// the given contents conflict with those already in the store.
+func checkConflicts(ctx context.Context, st store.Store, c *contents) error {
+	groups, err := st.FindGroups(ctx)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	existing := make(map[string]bool)
+	for _, g := range groups {
+		existing[g.Name] = true
+	}
+	for _, g := range c.groups {
+		if existing[g.Name] {
+			return errgo.Newf("group %s already exists", g.Name)
+		}
+	}
+	for i := range c.identities {
+		id := c.identities[i].toIdentity()
+		conflicts, err := findConflicts(ctx, st, id)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if len(conflicts) > 0 {
+			return errgo.Newf("identity %s (%s) conflicts with existing identity %s (%s)", id.Username, id.ProviderID, conflicts[0].Username, conflicts[0].ProviderID)
+		}
+	}
+	return nil
+}
+
+func importGroups(ctx context.Context, st store.Store, groups []group, policy ConflictPolicy) error {
+	update := store.GroupUpdate{
+		store.GroupDescription:  store.Set,
+		store.GroupOwners:       store.Set,
+		store.GroupMemberGroups: store.Set,
+	}
+	for _, ag := range groups {
+		g := &store.Group{
+			Name:         ag.Name,
+			Description:  ag.Description,
+			Owners:       ag.Owners,
+			MemberGroups: ag.MemberGroups,
+		}
+		err := st.AddGroup(ctx, g)
+		if errgo.Cause(err) == store.ErrDuplicateGroup {
+			switch policy {
+			case ConflictSkip:
+				logger.Infof("skipping existing group %s", g.Name)
+				continue
+			case ConflictOverwrite:
+				err = st.UpdateGroup(ctx, g, update)
+			}
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot import group %s", g.Name)
+		}
+	}
+	return nil
+}
+
+// identityUpdate is the update used to write an archived identity.
+var identityUpdate = store.Update{
+	store.Username:      store.Set,
+	store.Name:          store.Set,
+	store.Email:         store.Set,
+	store.Groups:        store.Set,
+	store.PublicKeys:    store.Set,
+	store.LastLogin:     store.Set,
+	store.LastDischarge: store.Set,
+	store.ProviderInfo:  store.Set,
+	store.ExtraInfo:     store.Set,
+	store.Owner:         store.Set,
+	store.TokensRevoked: store.Set,
+	store.Suspended:     store.Set,
+	store.GroupExpiry:   store.Set,
+}
+
+// importIdentities imports the given identities and returns those that
+// were skipped because they conflict with existing identities.
+func importIdentities(ctx context.Context, dp deletion.Params, identities []identity, policy ConflictPolicy) ([]*store.Identity, error) {
+	st := dp.Store
+	var skipped []*store.Identity
+	for i := range identities {
+		id := identities[i].toIdentity()
+		conflicts, err := findConflicts(ctx, st, id)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if len(conflicts) > 0 && policy == ConflictSkip {
+			logger.Infof("skipping identity %s (%s): conflicts with existing identity %s (%s)", id.Username, id.ProviderID, conflicts[0].Username, conflicts[0].ProviderID)
+			skipped = append(skipped, id)
+			continue
+		}
+		for j := range conflicts {
+			if err := replaceIdentity(ctx, dp, &conflicts[j], id); err != nil {
+				return nil, errgo.Notef(err, "cannot replace identity %s", conflicts[j].ProviderID)
+			}
+		}
+		if err := st.UpdateIdentity(ctx, id, identityUpdate); err != nil {
+			return nil, errgo.Notef(err, "cannot import identity %s", id.ProviderID)
+		}
+	}
+	return skipped, nil
+}
+
+// findConflicts returns the existing identities that have the same
+// provider ID or username as the given identity.
+func findConflicts(ctx context.Context, st store.Store, id *store.Identity) ([]store.Identity, error) {
+	var conflicts []store.Identity
+	byProviderID := store.Identity{ProviderID: id.ProviderID}
+	err := st.Identity(ctx, &byProviderID)
+	switch errgo.Cause(err) {
+	case nil:
+		conflicts = append(conflicts, byProviderID)
+	case store.ErrNotFound:
+	default:
+		return nil, errgo.Mask(err)
+	}
+	if byProviderID.Username == id.Username {
+		return conflicts, nil
+	}
+	byUsername := store.Identity{Username: id.Username}
+	err = st.Identity(ctx, &byUsername)
+	switch errgo.Cause(err) {
+	case nil:
+		conflicts = append(conflicts, byUsername)
+	case store.ErrNotFound:
+	default:
+		return nil, errgo.Mask(err)
+	}
+	return conflicts, nil
+}
+
+// replaceIdentity prepares the store for the existing identity to be
+// overwritten by id. If the existing identity is for a different
+// provider ID it is deleted, otherwise any values in the existing
+// identity that would not be replaced by id are cleared.
+func replaceIdentity(ctx context.Context, dp deletion.Params, existing, id *store.Identity) error {
+	if existing.ProviderID != id.ProviderID {
+		// The deletion is recorded in the group change history
+		// without an actor, as it is made by the import.
+		return errgo.Mask(deletion.Delete(store.ContextWithGroupChanges(ctx, ""), dp, existing))
+	}
+	clear := store.Identity{ProviderID: existing.ProviderID}
+	var update store.Update
+	for k := range existing.ProviderInfo {
+		if _, ok := id.ProviderInfo[k]; !ok {
+			if clear.ProviderInfo == nil {
+				clear.ProviderInfo = make(map[string][]string)
+			}
+			clear.ProviderInfo[k] = nil
+			update[store.ProviderInfo] = store.Clear
+		}
+	}
+	for k := range existing.ExtraInfo {
+		if _, ok := id.ExtraInfo[k]; !ok {
+			if clear.ExtraInfo == nil {
+				clear.ExtraInfo = make(map[string][]string)
+			}
+			clear.ExtraInfo[k] = nil
+			update[store.ExtraInfo] = store.Clear
+		}
+	}
+	for k := range existing.GroupExpiry {
+		if _, ok := id.GroupExpiry[k]; !ok {
+			if clear.GroupExpiry == nil {
+				clear.GroupExpiry = make(map[string]time.Time)
+			}
+			clear.GroupExpiry[k] = time.Time{}
+			update[store.GroupExpiry] = store.Clear
+		}
+	}
+	if update == (store.Update{}) {
+		return nil
+	}
+	return errgo.Mask(dp.Store.UpdateIdentity(ctx, &clear, update))
+}
+
+// importACLs imports the given ACLs. The usernames of skipped
+// identities are only members of an imported ACL if they are members of
+// the existing ACL.
+func importACLs(ctx context.Context, b store.Backend, acls []acl, skipped []*store.Identity) error {
+	for _, a := range acls {
+		if err := b.ACLStore().CreateACL(ctx, a.Name, a.Users); err != nil {
+			return errgo.Notef(err, "cannot create ACL %s", a.Name)
+		}
+		users := a.Users
+		if len(skipped) > 0 {
+			existing, err := b.ACLStore().Get(ctx, a.Name)
+			if err != nil {
+				return errgo.Notef(err, "cannot get ACL %s", a.Name)
+			}
+			users = aclUsers(a.Users, existing, skipped)
+		}
+		if err := b.ACLStore().Set(ctx, a.Name, users); err != nil {
+			return errgo.Notef(err, "cannot set ACL %s", a.Name)
+		}
+	}
+	return nil
+}
+
+// aclUsers returns the members of an ACL that has the given archived
+// and existing members, keeping the existing membership of the usernames
+// of skipped identities.
+func aclUsers(archived, existing []string, skipped []*store.Identity) []string {
+	skip := make(map[string]bool)
+	for _, id := range skipped {
+		skip[id.Username] = true
+	}
+	var users []string
+	for _, u := range archived {
+		if !skip[u] {
+			users = append(users, u)
+		}
+	}
+	for _, u := range existing {
+		if skip[u] {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// importProviderData imports the given identity provider data, except
+// for any data that belongs to a skipped identity.
+func importProviderData(ctx context.Context, b store.Backend, data []providerData, skipped []*store.Identity) error {
+	kvs := make(map[string]simplekv.Store)
+	for _, pd := range data {
+		if id := ownedBy(pd, skipped); id != nil {
+			logger.Infof("skipping %s data %q of skipped identity %s", pd.IDP, pd.Key, id.ProviderID)
+			continue
+		}
+		kv := kvs[pd.IDP]
+		if kv == nil {
+			var err error
+			kv, err = b.ProviderDataStore().KeyValueStore(ctx, pd.IDP)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			kvs[pd.IDP] = kv
+		}
+		var expire time.Time
+		if pd.Expire != nil {
+			expire = *pd.Expire
+		}
+		if err := kv.Set(ctx, pd.Key, pd.Value, expire); err != nil {
+			return errgo.Notef(err, "cannot set %s data %q", pd.IDP, pd.Key)
+		}
+	}
+	return nil
+}
+
+// ownedBy returns the identity in ids to which the given provider data
+// belongs, or nil if there is none. Identity providers key the data they
+// hold for a user on either the provider-specific part of the user's
+// provider ID, as the local provider does for password hashes, or on a
+// key containing the whole provider ID.
+func ownedBy(pd providerData, ids []*store.Identity) *store.Identity {
+	for _, id := range ids {
+		provider, name := id.ProviderID.Split()
+		if pd.IDP != provider {
+			continue
+		}
+		if pd.Key == name || strings.Contains(pd.Key, string(id.ProviderID)) {
+			return id
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+)
+
+// auditStore implements audit.Store using the audit bucket.
+type auditStore struct {
+	*backend
+}
+
+// Log implements audit.Store.Log.
+func (s *auditStore) Log(_ context.Context, e audit.Event) error {
+	return errgo.Mask(s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		n, err := b.NextSequence()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		// Events are keyed by time so that they are kept in time
+		// order even if they are logged out of order. The sequence
+		// number keeps the key unique and preserves the order of
+		// events logged at the same time.
+		k := make([]byte, 16)
+		binary.BigEndian.PutUint64(k, uint64(e.Time.UnixNano())^(1<<63))
+		binary.BigEndian.PutUint64(k[8:], n)
+		return errgo.Mask(put(b, k, e))
+	}))
+}
+
+// Events implements audit.Store.Events.
+func (s *auditStore) Events(_ context.Context, f audit.Filter) ([]audit.Event, error) {
+	var events []audit.Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e audit.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return errgo.Notef(err, "cannot unmarshal audit event")
+			}
+			if !f.Match(e) {
+				continue
+			}
+			events = append(events, e)
+			if f.Limit > 0 && len(events) == f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return events, nil
+}
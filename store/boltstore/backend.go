@@ -0,0 +1,226 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package boltstore provides an implementation of the store that keeps
+// all of its data in a single file using the bbolt embedded key-value
+// database. It is intended for single node deployments that do not
+// want to run a separate database server.
+package boltstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/juju/aclstore/v2"
+	"github.com/juju/loggo"
+	"github.com/juju/utils/debugstatus"
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/meeting"
+	"github.com/canonical/candid/store"
+)
+
+var logger = loggo.GetLogger("candid.store.boltstore")
+
+// The following names are the top-level buckets used to hold the
+// data. Provider key-value stores are held in buckets named with the
+// prefix kvBucketPrefix followed by the name of the identity provider.
+// The expiry times of the values in all key-value buckets are indexed
+// in kvExpiryBucket.
+var (
+	identitiesBucket   = []byte("identities")
+	providerIDsBucket  = []byte("identity-providerids")
+	usernamesBucket    = []byte("identity-usernames")
+	groupsBucket       = []byte("groups")
+	groupChangesBucket = []byte("group-changes")
+	elevationsBucket   = []byte("elevations")
+	auditBucket        = []byte("audit")
+	meetingsBucket     = []byte("meetings")
+	rootKeysBucket     = []byte("rootkeys")
+	aclsBucket         = []byte("acls")
+	outboxBucket       = []byte("outbox")
+	kvExpiryBucket     = []byte("kv-expiry")
+)
+
+const kvBucketPrefix = "idpkv-"
+
+// allBuckets holds all the buckets that are created when the database
+// is initialised.
+var allBuckets = [][]byte{
+	identitiesBucket,
+	providerIDsBucket,
+	usernamesBucket,
+	groupsBucket,
+	groupChangesBucket,
+	elevationsBucket,
+	auditBucket,
+	meetingsBucket,
+	rootKeysBucket,
+	aclsBucket,
+	outboxBucket,
+	kvExpiryBucket,
+}
+
+// backend provides a wrapper around a bolt database that can be used
+// as the persistent storage for the various types of store required by
+// the identity service.
+type backend struct {
+	db       *bolt.DB
+	rootKeys *dbrootkeystore.RootKeys
+	aclStore aclstore.ACLStore
+}
+
+// NewBackend creates a new store.RawBackend implementation using the
+// given bolt database. Any buckets that are required and do not exist
+// are created.
+//
+// Closing the returned Backend will also close db.
+func NewBackend(db *bolt.DB) (store.RawBackend, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errgo.Notef(err, "cannot create bucket %q", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	b := &backend{
+		db:       db,
+		rootKeys: dbrootkeystore.NewRootKeys(1000, nil),
+	}
+	b.aclStore = aclstore.NewACLStore(&kvStore{
+		db:     db,
+		bucket: aclsBucket,
+	})
+	return b, nil
+}
+
+// Close implements store.Backend.Close.
+func (b *backend) Close() {
+	if err := b.db.Close(); err != nil {
+		logger.Errorf("cannot close database: %s", err)
+	}
+}
+
+// Store implements store.Backend.Store.
+func (b *backend) Store() store.Store {
+	return &identityStore{b}
+}
+
+// BakeryRootKeyStore implements store.Backend.BakeryRootKeyStore.
+func (b *backend) BakeryRootKeyStore() bakery.RootKeyStore {
+	return b.rootKeys.NewStore(rootKeyBacking{b}, dbrootkeystore.Policy{
+		ExpiryDuration: 365 * 24 * time.Hour,
+	})
+}
+
+// ProviderDataStore implements store.Backend.ProviderDataStore.
+func (b *backend) ProviderDataStore() store.ProviderDataStore {
+	return &providerDataStore{b}
+}
+
+// MeetingStore implements store.Backend.MeetingStore.
+func (b *backend) MeetingStore() meeting.Store {
+	return &meetingStore{b}
+}
+
+// ACLStore implements store.Backend.ACLStore.
+func (b *backend) ACLStore() aclstore.ACLStore {
+	return b.aclStore
+}
+
+// AuditStore implements store.Backend.AuditStore.
+func (b *backend) AuditStore() audit.Store {
+	return &auditStore{b}
+}
+
+// DebugStatusCheckerFuncs implements store.Backend.DebugStatusCheckerFuncs.
+func (b *backend) DebugStatusCheckerFuncs() []debugstatus.CheckerFunc {
+	return []debugstatus.CheckerFunc{
+		b.bucketStatus,
+		b.meetingStatus,
+	}
+}
+
+// bucketStatus implements a debugstatus.CheckerFunc that checks that
+// all the required buckets exist in the database.
+func (b *backend) bucketStatus(context.Context) (key string, result debugstatus.CheckResult) {
+	key = "bolt_buckets"
+	result.Name = "bolt buckets"
+	var missing []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if tx.Bucket(name) == nil {
+				missing = append(missing, string(name))
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		result.Value = "Cannot get buckets: " + err.Error()
+	case len(missing) > 0:
+		result.Value = fmt.Sprintf("Missing buckets: %s", missing)
+	default:
+		result.Value = "All required buckets exist"
+		result.Passed = true
+	}
+	return key, result
+}
+
+// seqKey returns the key to use for the given sequence number. The
+// keys sort in the same order as the numbers.
+func seqKey(n uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, n)
+	return k
+}
+
+// idKey converts the given ID, as returned from a previous call to
+// seqID, back into a key. If the ID is not valid then false is returned.
+func idKey(id string) ([]byte, bool) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return seqKey(n), true
+}
+
+// seqID returns the ID that is presented to the user for the given
+// sequence key.
+func seqID(k []byte) string {
+	return strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+}
+
+// get decodes the value held in bucket b with the given key into v. If
+// there is no such value then false is returned.
+func get(b *bolt.Bucket, key []byte, v interface{}) (bool, error) {
+	data := b.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, errgo.Notef(err, "cannot unmarshal %q", key)
+	}
+	return true, nil
+}
+
+// put encodes v and stores it in bucket b with the given key.
+func put(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(b.Put(key, data))
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+)
+
+// rootKeyBacking implements dbrootkeystore.Backing using the rootkeys
+// bucket. Root keys are stored using their ID as the key.
+type rootKeyBacking struct {
+	b *backend
+}
+
+// GetKey implements dbrootkeystore.Backing.GetKey.
+func (b rootKeyBacking) GetKey(id []byte) (dbrootkeystore.RootKey, error) {
+	var key dbrootkeystore.RootKey
+	err := b.b.db.View(func(tx *bolt.Tx) error {
+		ok, err := get(tx.Bucket(rootKeysBucket), id, &key)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return bakery.ErrNotFound
+		}
+		return nil
+	})
+	switch {
+	case err == bakery.ErrNotFound:
+		return dbrootkeystore.RootKey{}, bakery.ErrNotFound
+	case err != nil:
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	return key, nil
+}
+
+// FindLatestKey implements dbrootkeystore.Backing.FindLatestKey.
+func (b rootKeyBacking) FindLatestKey(createdAfter, expiresAfter, expiresBefore time.Time) (dbrootkeystore.RootKey, error) {
+	var latest dbrootkeystore.RootKey
+	err := b.b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(rootKeysBucket).ForEach(func(k, v []byte) error {
+			var key dbrootkeystore.RootKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return errgo.Notef(err, "cannot unmarshal root key")
+			}
+			if key.Created.Before(createdAfter) || key.Expires.Before(expiresAfter) || key.Expires.After(expiresBefore) {
+				return nil
+			}
+			if key.Created.After(latest.Created) {
+				latest = key
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	return latest, nil
+}
+
+// InsertKey implements dbrootkeystore.Backing.InsertKey.
+func (b rootKeyBacking) InsertKey(key dbrootkeystore.RootKey) error {
+	return errgo.Mask(b.b.db.Update(func(tx *bolt.Tx) error {
+		return errgo.Mask(put(tx.Bucket(rootKeysBucket), key.Id, &key))
+	}))
+}
+
+// RootKeys implements store.RawBackend.RootKeys.
+func (b *backend) RootKeys(context.Context) ([]dbrootkeystore.RootKey, error) {
+	now := time.Now()
+	var keys []dbrootkeystore.RootKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(rootKeysBucket).ForEach(func(k, v []byte) error {
+			var key dbrootkeystore.RootKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return errgo.Notef(err, "cannot unmarshal root key")
+			}
+			if key.Expires.After(now) {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return keys, nil
+}
+
+// AddRootKey implements store.RawBackend.AddRootKey.
+func (b *backend) AddRootKey(_ context.Context, key dbrootkeystore.RootKey) error {
+	return errgo.Mask(b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(rootKeysBucket)
+		if bucket.Get(key.Id) != nil {
+			return nil
+		}
+		return errgo.Mask(put(bucket, key.Id, &key))
+	}))
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/simplekv"
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/meeting"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/boltstore"
+	"github.com/canonical/candid/store/storetest"
+)
+
+func TestKeyValueStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestKeyValueStore(c, func(c *qt.C) store.ProviderDataStore {
+		return newBackend(c, "").ProviderDataStore()
+	})
+}
+
+func TestStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestStore(c, func(c *qt.C) store.Store {
+		return newBackend(c, "").Store()
+	})
+}
+
+func TestMeetingStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestMeetingStore(c, func(c *qt.C) meeting.Store {
+		return newBackend(c, "").MeetingStore()
+	}, boltstore.PutAtTime)
+}
+
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) audit.Store {
+		return newBackend(c, "").AuditStore()
+	})
+}
+
+func TestACLStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestACLStore(c, func(c *qt.C) aclstore.ACLStore {
+		return newBackend(c, "").ACLStore()
+	})
+}
+
+func TestConfigUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestUnmarshal(c, `
+storage:
+    type: bolt
+    path: '`+filepath.Join(c.Mkdir(), "candid.db")+`'
+`)
+}
+
+func TestKeyValueExpiredEntriesRemoved(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	path := filepath.Join(c.Mkdir(), "candid.db")
+	ctx := context.Background()
+	b := newBackend(c, path)
+	kv, err := b.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "expired", []byte("value"), time.Now().Add(-time.Minute))
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "current", []byte("value"), time.Now().Add(time.Hour))
+	c.Assert(err, qt.IsNil)
+	b.Close()
+
+	// Data, including expiry times, persists in the database file.
+	b = newBackend(c, path)
+	kv, err = b.ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	_, err = kv.Get(ctx, "expired")
+	c.Assert(errgo.Cause(err), qt.Equals, simplekv.ErrNotFound)
+	v, err := kv.Get(ctx, "current")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(v), qt.Equals, "value")
+
+	// The expired value is removed from the database by the next
+	// write.
+	err = kv.Set(ctx, "new", []byte("value"), time.Time{})
+	c.Assert(err, qt.IsNil)
+	b.Close()
+	c.Assert(bucketKeys(c, path, "idpkv-test"), qt.DeepEquals, []string{"current", "new"})
+}
+
+func TestKeyValueExpiryChanged(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	path := filepath.Join(c.Mkdir(), "candid.db")
+	ctx := context.Background()
+	kv, err := newBackend(c, path).ProviderDataStore().KeyValueStore(ctx, "test")
+	c.Assert(err, qt.IsNil)
+	err = kv.Set(ctx, "k1", []byte("value"), time.Now().Add(10*time.Millisecond))
+	c.Assert(err, qt.IsNil)
+	err = kv.Update(ctx, "k1", time.Time{}, func([]byte) ([]byte, error) {
+		return []byte("new value"), nil
+	})
+	c.Assert(err, qt.IsNil)
+	time.Sleep(20 * time.Millisecond)
+
+	// The value no longer expires, so it is not removed.
+	err = kv.Set(ctx, "k2", []byte("value"), time.Time{})
+	c.Assert(err, qt.IsNil)
+	v, err := kv.Get(ctx, "k1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(v), qt.Equals, "new value")
+}
+
+// bucketKeys returns the keys held in the given bucket of the bolt
+// database at the given path, which must not be open.
+func bucketKeys(c *qt.C, path, bucket string) []string {
+	db, err := bolt.Open(path, 0600, nil)
+	c.Assert(err, qt.IsNil)
+	defer db.Close()
+	var keys []string
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	c.Assert(err, qt.IsNil)
+	return keys
+}
+
+func TestBakeryRootKeyStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	path := filepath.Join(c.Mkdir(), "candid.db")
+	ctx := context.Background()
+	b := newBackend(c, path)
+	key, id, err := b.BakeryRootKeyStore().RootKey(ctx)
+	c.Assert(err, qt.IsNil)
+	b.Close()
+
+	// A new backend using the same database can find the key.
+	b = newBackend(c, path)
+	key1, err := b.BakeryRootKeyStore().Get(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(key1, qt.DeepEquals, key)
+
+	_, err = b.BakeryRootKeyStore().Get(ctx, []byte("no-such-key"))
+	c.Assert(err, qt.Equals, bakery.ErrNotFound)
+}
+
+func TestDebugStatus(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	b := newBackend(c, "")
+	err := boltstore.PutAtTime(context.Background(), b.MeetingStore(), "id", "addr", time.Now())
+	c.Assert(err, qt.IsNil)
+
+	results := make(map[string]string)
+	for _, f := range b.DebugStatusCheckerFuncs() {
+		key, result := f(context.Background())
+		c.Check(result.Passed, qt.Equals, true, qt.Commentf("%s", key))
+		results[key] = result.Value
+	}
+	c.Assert(results, qt.DeepEquals, map[string]string{
+		"bolt_buckets":  "All required buckets exist",
+		"meeting_count": "1",
+	})
+}
+
+// newBackend returns a new backend using the bolt database at the
+// given path. If path is empty a new database is created.
+func newBackend(c *qt.C, path string) store.Backend {
+	if path == "" {
+		path = filepath.Join(c.Mkdir(), "candid.db")
+	}
+	backend, err := boltstore.Params{Path: path}.NewBackend()
+	c.Assert(err, qt.IsNil)
+	c.Defer(backend.Close)
+	return backend
+}
+
+func TestRawBackend(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestRawBackend(c, func(c *qt.C) store.RawBackend {
+		return newBackend(c, "").(store.RawBackend)
+	})
+}
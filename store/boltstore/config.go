@@ -0,0 +1,53 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+// Params holds the specification for the parameters
+// used in the config file.
+type Params struct {
+	// Path holds the path of the database file. The file is created
+	// if it does not exist.
+	Path string `yaml:"path"`
+}
+
+func init() {
+	store.Register("bolt", unmarshalBackend)
+}
+
+func unmarshalBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
+	var p Params
+	if err := unmarshal(&p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if p.Path == "" {
+		return nil, errgo.Newf("no path specified")
+	}
+	return p, nil
+}
+
+// NewBackend implements store.BackendFactory.
+func (p Params) NewBackend() (store.Backend, error) {
+	logger.Infof("opening bolt database %s", p.Path)
+	// Only one process can have the database open at a time, don't
+	// wait forever if another process has it.
+	db, err := bolt.Open(p.Path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open database")
+	}
+	backend, err := NewBackend(db)
+	if err != nil {
+		db.Close()
+		return nil, errgo.Mask(err)
+	}
+	return backend, nil
+}
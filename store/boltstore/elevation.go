@@ -0,0 +1,160 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+// elevationDocument is the form in which an elevation is stored in the
+// elevations bucket. The ID of the elevation is derived from the key it
+// is stored with.
+type elevationDocument struct {
+	Username  string               `json:"username"`
+	Group     string               `json:"group"`
+	Reason    string               `json:"reason,omitempty"`
+	Duration  time.Duration        `json:"duration"`
+	State     store.ElevationState `json:"state"`
+	Requested time.Time            `json:"requested"`
+	Approver  string               `json:"approver,omitempty"`
+	Decided   time.Time            `json:"decided"`
+	Expires   time.Time            `json:"expires"`
+}
+
+// AddElevation implements store.Store.AddElevation.
+func (s *identityStore) AddElevation(_ context.Context, e *store.Elevation) error {
+	return errgo.Mask(s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(elevationsBucket)
+		n, err := b.NextSequence()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		k := seqKey(n)
+		if err := put(b, k, toElevationDocument(e)); err != nil {
+			return errgo.Mask(err)
+		}
+		e.ID = seqID(k)
+		return nil
+	}))
+}
+
+// Elevation implements store.Store.Elevation.
+func (s *identityStore) Elevation(_ context.Context, e *store.Elevation) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var doc elevationDocument
+		ok, err := getElevation(tx, e.ID, &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.ElevationNotFoundError(e.ID)
+		}
+		fromElevationDocument(e, e.ID, &doc)
+		return nil
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// FindElevations implements store.Store.FindElevations.
+func (s *identityStore) FindElevations(_ context.Context, f store.ElevationFilter) ([]store.Elevation, error) {
+	var elevations []store.Elevation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Elevations are keyed by a sequence number, so they are
+		// iterated in the order in which they were requested.
+		return tx.Bucket(elevationsBucket).ForEach(func(k, v []byte) error {
+			var doc elevationDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal elevation")
+			}
+			if f.Username != "" && doc.Username != f.Username {
+				return nil
+			}
+			if f.Group != "" && doc.Group != f.Group {
+				return nil
+			}
+			if f.State != "" && doc.State != f.State {
+				return nil
+			}
+			var e store.Elevation
+			fromElevationDocument(&e, seqID(k), &doc)
+			elevations = append(elevations, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return elevations, nil
+}
+
+// UpdateElevation implements store.Store.UpdateElevation.
+func (s *identityStore) UpdateElevation(_ context.Context, e *store.Elevation, from store.ElevationState) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var doc elevationDocument
+		ok, err := getElevation(tx, e.ID, &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.ElevationNotFoundError(e.ID)
+		}
+		if doc.State != from {
+			return store.ElevationStateChangedError(e.ID, doc.State)
+		}
+		doc.State = e.State
+		doc.Approver = e.Approver
+		doc.Decided = e.Decided
+		doc.Expires = e.Expires
+		k, _ := idKey(e.ID)
+		return errgo.Mask(put(tx.Bucket(elevationsBucket), k, &doc))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound), errgo.Is(store.ErrElevationStateChanged))
+}
+
+// getElevation retrieves the elevation with the given ID into doc. If
+// there is no such elevation then false is returned.
+func getElevation(tx *bolt.Tx, id string, doc *elevationDocument) (bool, error) {
+	k, ok := idKey(id)
+	if !ok {
+		return false, nil
+	}
+	ok, err := get(tx.Bucket(elevationsBucket), k, doc)
+	return ok, errgo.Mask(err)
+}
+
+func toElevationDocument(e *store.Elevation) *elevationDocument {
+	return &elevationDocument{
+		Username:  e.Username,
+		Group:     e.Group,
+		Reason:    e.Reason,
+		Duration:  e.Duration,
+		State:     e.State,
+		Requested: e.Requested,
+		Approver:  e.Approver,
+		Decided:   e.Decided,
+		Expires:   e.Expires,
+	}
+}
+
+func fromElevationDocument(e *store.Elevation, id string, doc *elevationDocument) {
+	*e = store.Elevation{
+		ID:        id,
+		Username:  doc.Username,
+		Group:     doc.Group,
+		Reason:    doc.Reason,
+		Duration:  doc.Duration,
+		State:     doc.State,
+		Requested: doc.Requested,
+		Approver:  doc.Approver,
+		Decided:   doc.Decided,
+		Expires:   doc.Expires,
+	}
+}
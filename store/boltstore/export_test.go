@@ -0,0 +1,15 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/canonical/candid/meeting"
+)
+
+var PutAtTime = func(ctx context.Context, s meeting.Store, id, address string, now time.Time) error {
+	return s.(*meetingStore).put(ctx, id, address, now)
+}
@@ -0,0 +1,232 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+// groupDocument is the form in which a group is stored in the groups
+// bucket. The name of the group is the key it is stored with.
+type groupDocument struct {
+	Description  string   `json:"description,omitempty"`
+	Owners       []string `json:"owners,omitempty"`
+	MemberGroups []string `json:"membergroups,omitempty"`
+}
+
+// groupChangeDocument is the form in which a group change is stored in
+// the group-changes bucket.
+type groupChangeDocument struct {
+	Time        time.Time           `json:"time"`
+	Actor       string              `json:"actor,omitempty"`
+	Username    string              `json:"username"`
+	MemberGroup string              `json:"membergroup,omitempty"`
+	Group       string              `json:"group"`
+	Op          store.GroupChangeOp `json:"op"`
+	Until       time.Time           `json:"until,omitempty"`
+}
+
+// Group implements store.Store.Group.
+func (s *identityStore) Group(_ context.Context, group *store.Group) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var doc groupDocument
+		ok, err := get(tx.Bucket(groupsBucket), []byte(group.Name), &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.GroupNotFoundError(group.Name)
+		}
+		fromGroupDocument(group, group.Name, &doc)
+		return nil
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s *identityStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	var groups []store.Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are held in byte order, so the groups are sorted
+		// by name.
+		return tx.Bucket(groupsBucket).ForEach(func(k, v []byte) error {
+			var doc groupDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal group")
+			}
+			var group store.Group
+			fromGroupDocument(&group, string(k), &doc)
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return groups, nil
+}
+
+// AddGroup implements store.Store.AddGroup.
+func (s *identityStore) AddGroup(ctx context.Context, group *store.Group) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(groupsBucket)
+		if b.Get([]byte(group.Name)) != nil {
+			return store.DuplicateGroupError(group.Name)
+		}
+		doc := groupDocument{
+			Description:  group.Description,
+			Owners:       updateStrings(nil, group.Owners, store.Set),
+			MemberGroups: updateStrings(nil, group.MemberGroups, store.Set),
+		}
+		if err := put(b, []byte(group.Name), &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(recordMemberGroupChanges(ctx, tx, group.Name, nil, doc.MemberGroups))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrDuplicateGroup))
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s *identityStore) UpdateGroup(ctx context.Context, group *store.Group, update store.GroupUpdate) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(groupsBucket)
+		var doc groupDocument
+		ok, err := get(b, []byte(group.Name), &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.GroupNotFoundError(group.Name)
+		}
+		doc.Description = updateString(doc.Description, group.Description, update[store.GroupDescription])
+		doc.Owners = updateStrings(doc.Owners, group.Owners, update[store.GroupOwners])
+		old := doc.MemberGroups
+		doc.MemberGroups = updateStrings(doc.MemberGroups, group.MemberGroups, update[store.GroupMemberGroups])
+		if err := put(b, []byte(group.Name), &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(recordMemberGroupChanges(ctx, tx, group.Name, old, doc.MemberGroups))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s *identityStore) RemoveGroup(ctx context.Context, name string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(groupsBucket)
+		var doc groupDocument
+		ok, err := get(b, []byte(name), &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.GroupNotFoundError(name)
+		}
+		if err := b.Delete([]byte(name)); err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(recordMemberGroupChanges(ctx, tx, name, doc.MemberGroups, nil))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// AddGroupChanges implements store.Store.AddGroupChanges.
+func (s *identityStore) AddGroupChanges(_ context.Context, changes []store.GroupChange) error {
+	return errgo.Mask(s.db.Update(func(tx *bolt.Tx) error {
+		return addGroupChanges(tx, changes)
+	}))
+}
+
+func addGroupChanges(tx *bolt.Tx, changes []store.GroupChange) error {
+	b := tx.Bucket(groupChangesBucket)
+	for _, gc := range changes {
+		n, err := b.NextSequence()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if err := put(b, seqKey(n), groupChangeDocument(gc)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// recordGroupChanges records the change of the groups of the given user
+// from old to new, in the given transaction, if the given context
+// requires it.
+func recordGroupChanges(ctx context.Context, tx *bolt.Tx, username string, old, new []string) error {
+	actor, ok := store.GroupChangeActor(ctx)
+	if !ok {
+		return nil
+	}
+	return errgo.Mask(addGroupChanges(tx, store.DiffGroups(time.Now(), actor, username, old, new)))
+}
+
+// recordMemberGroupChanges records the change of the member groups of
+// the given group from old to new, in the given transaction, if the
+// given context requires it.
+func recordMemberGroupChanges(ctx context.Context, tx *bolt.Tx, group string, old, new []string) error {
+	actor, ok := store.GroupChangeActor(ctx)
+	if !ok {
+		return nil
+	}
+	return errgo.Mask(addGroupChanges(tx, store.DiffMemberGroups(time.Now(), actor, group, old, new)))
+}
+
+// GroupChanges implements store.Store.GroupChanges.
+func (s *identityStore) GroupChanges(_ context.Context, f store.GroupChangeFilter) ([]store.GroupChange, error) {
+	var changes []store.GroupChange
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupChangesBucket).ForEach(func(k, v []byte) error {
+			var doc groupChangeDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal group change")
+			}
+			gc := store.GroupChange(doc)
+			if f.Username != "" && gc.Username != f.Username {
+				return nil
+			}
+			if f.Group != "" && gc.Group != f.Group {
+				return nil
+			}
+			if !f.After.IsZero() && gc.Time.Before(f.After) {
+				return nil
+			}
+			if !f.Before.IsZero() && !gc.Time.Before(f.Before) {
+				return nil
+			}
+			changes = append(changes, gc)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// Changes are held in the order they were added, which might
+	// not be the order in which they occurred.
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Time.Before(changes[j].Time)
+	})
+	if f.Limit > 0 && len(changes) > f.Limit {
+		changes = changes[:f.Limit]
+	}
+	return changes, nil
+}
+
+func fromGroupDocument(group *store.Group, name string, doc *groupDocument) {
+	*group = store.Group{
+		Name:         name,
+		Description:  doc.Description,
+		Owners:       doc.Owners,
+		MemberGroups: doc.MemberGroups,
+	}
+}
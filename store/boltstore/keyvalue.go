@@ -0,0 +1,232 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/juju/simplekv"
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+// providerDataStore implements store.ProviderDataStore by keeping the
+// data for each identity provider in its own bucket.
+type providerDataStore struct {
+	*backend
+}
+
+// KeyValueStore implements store.ProviderDataStore.KeyValueStore.
+func (s *providerDataStore) KeyValueStore(_ context.Context, idp string) (simplekv.Store, error) {
+	bucket := []byte(kvBucketPrefix + idp)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return errgo.Mask(err)
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot create bucket %q", bucket)
+	}
+	return &kvStore{
+		db:     s.db,
+		bucket: bucket,
+	}, nil
+}
+
+// kvDocument is the form in which a value is stored in a key-value
+// bucket.
+type kvDocument struct {
+	Value  []byte    `json:"value"`
+	Expire time.Time `json:"expire,omitempty"`
+}
+
+// expired reports whether the document has expired at the given time.
+func (doc *kvDocument) expired(now time.Time) bool {
+	return !doc.Expire.IsZero() && !doc.Expire.After(now)
+}
+
+// A kvStore implements simplekv.Store using a bolt bucket, which must
+// already exist.
+type kvStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// Context implements simplekv.Store.Context.
+func (s *kvStore) Context(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+// Get implements simplekv.Store.Get.
+func (s *kvStore) Get(_ context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.get(tx, key)
+		value = v
+		return errgo.Mask(err, errgo.Is(simplekv.ErrNotFound))
+	})
+	return value, errgo.Mask(err, errgo.Is(simplekv.ErrNotFound))
+}
+
+// get retrieves the value for the given key. Expired values are
+// treated as though they do not exist.
+func (s *kvStore) get(tx *bolt.Tx, key string) ([]byte, error) {
+	var doc kvDocument
+	ok, err := get(tx.Bucket(s.bucket), []byte(key), &doc)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !ok || doc.expired(time.Now()) {
+		return nil, simplekv.KeyNotFoundError(key)
+	}
+	if doc.Value == nil {
+		doc.Value = []byte{}
+	}
+	return doc.Value, nil
+}
+
+// Set implements simplekv.Store.Set.
+func (s *kvStore) Set(_ context.Context, key string, value []byte, expire time.Time) error {
+	return errgo.Mask(s.db.Update(func(tx *bolt.Tx) error {
+		return errgo.Mask(s.set(tx, key, value, expire))
+	}))
+}
+
+// set stores the given key and value. Any expired values in the
+// key-value buckets are removed at the same time.
+func (s *kvStore) set(tx *bolt.Tx, key string, value []byte, expire time.Time) error {
+	if err := removeExpired(tx, time.Now()); err != nil {
+		return errgo.Mask(err)
+	}
+	b := tx.Bucket(s.bucket)
+	var old kvDocument
+	ok, err := get(b, []byte(key), &old)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	expiries := tx.Bucket(kvExpiryBucket)
+	if ok && !old.Expire.IsZero() {
+		if err := expiries.Delete(expiryKey(old.Expire, s.bucket, key)); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if !expire.IsZero() {
+		if err := expiries.Put(expiryKey(expire, s.bucket, key), []byte{}); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return errgo.Mask(put(b, []byte(key), &kvDocument{
+		Value:  value,
+		Expire: expire,
+	}))
+}
+
+// Update implements simplekv.Store.Update.
+func (s *kvStore) Update(_ context.Context, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		old, err := s.get(tx, key)
+		if err != nil && errgo.Cause(err) != simplekv.ErrNotFound {
+			return errgo.Mask(err)
+		}
+		v, err := getVal(old)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		return errgo.Mask(s.set(tx, key, v, expire))
+	})
+	return errgo.Mask(err, errgo.Any)
+}
+
+// removeExpired deletes all values in the key-value buckets that have
+// expired at the given time. The expiry bucket is ordered by time, so
+// only the expired values are visited.
+func removeExpired(tx *bolt.Tx, now time.Time) error {
+	expiries := tx.Bucket(kvExpiryBucket)
+	var expired [][]byte
+	c := expiries.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		t, _, _, ok := parseExpiryKey(k)
+		if ok && t.After(now) {
+			break
+		}
+		expired = append(expired, append([]byte(nil), k...))
+	}
+	for _, k := range expired {
+		if _, bucket, key, ok := parseExpiryKey(k); ok {
+			if b := tx.Bucket(bucket); b != nil {
+				if err := b.Delete(key); err != nil {
+					return errgo.Mask(err)
+				}
+			}
+		}
+		if err := expiries.Delete(k); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// expiryKey returns the key in the expiry bucket that records that the
+// given key in the given bucket expires at the given time. Keys sort
+// in order of expiry time.
+func expiryKey(t time.Time, bucket []byte, key string) []byte {
+	k := make([]byte, 8, 8+len(bucket)+1+len(key))
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	k = append(k, bucket...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// parseExpiryKey parses a key created by expiryKey. If the key is not
+// valid then false is returned.
+func parseExpiryKey(k []byte) (t time.Time, bucket, key []byte, ok bool) {
+	if len(k) < 8 {
+		return time.Time{}, nil, nil, false
+	}
+	i := bytes.IndexByte(k[8:], 0)
+	if i < 0 {
+		return time.Time{}, nil, nil, false
+	}
+	t = time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+	return t, k[8 : 8+i], k[8+i+1:], true
+}
+
+// ProviderData implements store.RawBackend.ProviderData.
+func (b *backend) ProviderData(context.Context) ([]store.ProviderData, error) {
+	now := time.Now()
+	var data []store.ProviderData
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if !bytes.HasPrefix(name, []byte(kvBucketPrefix)) {
+				return nil
+			}
+			idp := string(name[len(kvBucketPrefix):])
+			return bucket.ForEach(func(k, v []byte) error {
+				var doc kvDocument
+				if err := json.Unmarshal(v, &doc); err != nil {
+					return errgo.Notef(err, "cannot unmarshal %q", k)
+				}
+				if doc.expired(now) {
+					return nil
+				}
+				data = append(data, store.ProviderData{
+					IDP:    idp,
+					Key:    string(k),
+					Value:  doc.Value,
+					Expire: doc.Expire,
+				})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
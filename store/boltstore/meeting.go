@@ -0,0 +1,142 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/juju/utils/debugstatus"
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+)
+
+// meetingDocument is the form in which a meeting is stored in the
+// meetings bucket. The ID of the meeting is the key it is stored with.
+type meetingDocument struct {
+	Address string    `json:"address"`
+	Created time.Time `json:"created"`
+}
+
+// meetingStore implements meeting.Store using the meetings bucket.
+type meetingStore struct {
+	*backend
+}
+
+// Context implements meeting.Store.Context.
+func (s *meetingStore) Context(ctx context.Context) (_ context.Context, close func()) {
+	return ctx, func() {}
+}
+
+// Put implements meeting.Store.Put.
+func (s *meetingStore) Put(ctx context.Context, id, address string) error {
+	return errgo.Mask(s.put(ctx, id, address, time.Now()))
+}
+
+// put is the implementation of Put. The now parameter allows tests to
+// specify the creation time of the meeting.
+func (s *meetingStore) put(_ context.Context, id, address string, now time.Time) error {
+	return errgo.Mask(s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(meetingsBucket)
+		if b.Get([]byte(id)) != nil {
+			return errgo.Newf("duplicate id %q in meeting store", id)
+		}
+		return errgo.Mask(put(b, []byte(id), &meetingDocument{
+			Address: address,
+			Created: now,
+		}))
+	}))
+}
+
+// Get implements meeting.Store.Get.
+func (s *meetingStore) Get(_ context.Context, id string) (address string, _ error) {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var doc meetingDocument
+		ok, err := get(tx.Bucket(meetingsBucket), []byte(id), &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return errgo.New("rendezvous not found, probably expired")
+		}
+		address = doc.Address
+		return nil
+	})
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	return address, nil
+}
+
+// Remove implements meeting.Store.Remove.
+func (s *meetingStore) Remove(_ context.Context, id string) (time.Time, error) {
+	var doc meetingDocument
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(meetingsBucket)
+		if _, err := get(b, []byte(id), &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(b.Delete([]byte(id)))
+	})
+	if err != nil {
+		return time.Time{}, errgo.Mask(err)
+	}
+	return doc.Created, nil
+}
+
+// RemoveOld implements meeting.Store.RemoveOld.
+func (s *meetingStore) RemoveOld(_ context.Context, addr string, olderThan time.Time) (ids []string, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(meetingsBucket)
+		err := b.ForEach(func(k, v []byte) error {
+			var doc meetingDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal meeting")
+			}
+			if addr != "" && doc.Address != addr {
+				return nil
+			}
+			if doc.Created.Before(olderThan) {
+				ids = append(ids, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		// The bucket cannot be modified during ForEach, so
+		// delete the meetings afterwards.
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return ids, nil
+}
+
+// meetingStatus implements a debugstatus.CheckerFunc that reports the
+// number of meetings currently held.
+func (b *backend) meetingStatus(context.Context) (key string, result debugstatus.CheckResult) {
+	result.Name = "count of meeting bucket"
+	result.Passed = true
+	var n int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(meetingsBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		result.Value = err.Error()
+		result.Passed = false
+		return "meeting_count", result
+	}
+	result.Value = strconv.Itoa(n)
+	return "meeting_count", result
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+// outboxDocument is the form in which an outbox entry is stored in the
+// outbox bucket. The ID of the entry is derived from the key it is
+// stored with.
+type outboxDocument struct {
+	Webhook     string    `json:"webhook"`
+	Payload     []byte    `json:"payload"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next-attempt"`
+}
+
+// AddOutboxEntries implements store.Store.AddOutboxEntries.
+func (s *identityStore) AddOutboxEntries(_ context.Context, entries []store.OutboxEntry) error {
+	ids := make([]string, len(entries))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for i, e := range entries {
+			n, err := b.NextSequence()
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			k := seqKey(n)
+			if err := put(b, k, &outboxDocument{
+				Webhook:     e.Webhook,
+				Payload:     e.Payload,
+				Created:     e.Created,
+				Attempts:    e.Attempts,
+				NextAttempt: e.NextAttempt,
+			}); err != nil {
+				return errgo.Mask(err)
+			}
+			ids[i] = seqID(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range entries {
+		entries[i].ID = ids[i]
+	}
+	return nil
+}
+
+// OutboxEntries implements store.Store.OutboxEntries.
+func (s *identityStore) OutboxEntries(_ context.Context, due time.Time, limit int) ([]store.OutboxEntry, error) {
+	var entries []store.OutboxEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Outbox entries are keyed by a sequence number, so they are
+		// iterated in the order in which they were added.
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			var doc outboxDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal outbox entry")
+			}
+			if doc.NextAttempt.After(due) {
+				continue
+			}
+			entries = append(entries, store.OutboxEntry{
+				ID:          seqID(k),
+				Webhook:     doc.Webhook,
+				Payload:     doc.Payload,
+				Created:     doc.Created,
+				Attempts:    doc.Attempts,
+				NextAttempt: doc.NextAttempt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entries, nil
+}
+
+// UpdateOutboxEntry implements store.Store.UpdateOutboxEntry.
+func (s *identityStore) UpdateOutboxEntry(_ context.Context, e *store.OutboxEntry) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		k, ok := idKey(e.ID)
+		if !ok {
+			return store.OutboxEntryNotFoundError(e.ID)
+		}
+		var doc outboxDocument
+		ok, err := get(b, k, &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok {
+			return store.OutboxEntryNotFoundError(e.ID)
+		}
+		doc.Attempts = e.Attempts
+		doc.NextAttempt = e.NextAttempt
+		return errgo.Mask(put(b, k, &doc))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// ClaimOutboxEntry implements store.Store.ClaimOutboxEntry.
+func (s *identityStore) ClaimOutboxEntry(_ context.Context, id string, due, until time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		k, ok := idKey(id)
+		if !ok {
+			return store.OutboxEntryNotFoundError(id)
+		}
+		var doc outboxDocument
+		ok, err := get(b, k, &doc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !ok || doc.NextAttempt.After(due) {
+			return store.OutboxEntryNotFoundError(id)
+		}
+		doc.NextAttempt = until
+		return errgo.Mask(put(b, k, &doc))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// RemoveOutboxEntry implements store.Store.RemoveOutboxEntry.
+func (s *identityStore) RemoveOutboxEntry(_ context.Context, id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		k, ok := idKey(id)
+		if !ok || b.Get(k) == nil {
+			return store.OutboxEntryNotFoundError(id)
+		}
+		return errgo.Mask(b.Delete(k))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
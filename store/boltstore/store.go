@@ -0,0 +1,574 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/store"
+)
+
+// identityDocument is the form in which an identity is stored in the
+// identities bucket. The ID of the identity is the key it is stored
+// with.
+type identityDocument struct {
+	ProviderID    store.ProviderIdentity `json:"providerid"`
+	Username      string                 `json:"username"`
+	Name          string                 `json:"name,omitempty"`
+	Email         string                 `json:"email,omitempty"`
+	Groups        []string               `json:"groups,omitempty"`
+	PublicKeys    []bakery.PublicKey     `json:"publickeys,omitempty"`
+	LastLogin     time.Time              `json:"lastlogin"`
+	LastDischarge time.Time              `json:"lastdischarge"`
+	ProviderInfo  map[string][]string    `json:"providerinfo,omitempty"`
+	ExtraInfo     map[string][]string    `json:"extrainfo,omitempty"`
+	Owner         store.ProviderIdentity `json:"owner,omitempty"`
+	TokensRevoked time.Time              `json:"tokensrevoked"`
+	Suspended     bool                   `json:"suspended,omitempty"`
+	GroupExpiry   map[string]time.Time   `json:"groupexpiry,omitempty"`
+}
+
+// identityStore is an implementation of store.Store that uses the
+// identities bucket, and its indexes, in a bolt database.
+type identityStore struct {
+	*backend
+}
+
+// Context implements store.Store.Context by returning the given context
+// and a NOP close function.
+func (s *identityStore) Context(ctx context.Context) (_ context.Context, close func()) {
+	return ctx, func() {}
+}
+
+// Identity implements store.Store.Identity.
+func (s *identityStore) Identity(_ context.Context, identity *store.Identity) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key, err := identityKey(tx, identity)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		var doc identityDocument
+		if _, err := get(tx.Bucket(identitiesBucket), key, &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		fromDocument(identity, key, &doc)
+		return nil
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// identityKey returns the key of the given identity in the identities
+// bucket. The identity is determined from the first of the ID,
+// ProviderID and Username fields that is set.
+func identityKey(tx *bolt.Tx, identity *store.Identity) ([]byte, error) {
+	var key []byte
+	switch {
+	case identity.ID != "":
+		if k, ok := idKey(identity.ID); ok && tx.Bucket(identitiesBucket).Get(k) != nil {
+			key = k
+		}
+	case identity.ProviderID != "":
+		key = tx.Bucket(providerIDsBucket).Get([]byte(identity.ProviderID))
+	case identity.Username != "":
+		key = tx.Bucket(usernamesBucket).Get([]byte(identity.Username))
+	}
+	if key == nil {
+		return nil, store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+	}
+	// Values returned from a bucket are only valid for the life of
+	// the transaction and may not be used as keys in updates.
+	return append([]byte(nil), key...), nil
+}
+
+// FindIdentities implements store.Store.FindIdentities.
+func (s *identityStore) FindIdentities(_ context.Context, ref *store.Identity, filter store.Filter, sortFields []store.Sort, skip, limit int) ([]store.Identity, error) {
+	var identities []store.Identity
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(identitiesBucket).ForEach(func(k, v []byte) error {
+			var doc identityDocument
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return errgo.Notef(err, "cannot unmarshal identity")
+			}
+			var identity store.Identity
+			fromDocument(&identity, k, &doc)
+			if matchIdentity(&identity, ref, filter) {
+				identities = append(identities, identity)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find identities")
+	}
+	if skip > len(identities) {
+		return nil, nil
+	}
+	if len(sortFields) > 0 {
+		sort.Stable(identitySort{
+			identities: identities,
+			sort:       sortFields,
+		})
+	}
+	identities = identities[skip:]
+	if limit > 0 && limit < len(identities) {
+		identities = identities[:limit]
+	}
+	return identities, nil
+}
+
+// UpdateIdentity implements store.Store.UpdateIdentity.
+func (s *identityStore) UpdateIdentity(ctx context.Context, identity *store.Identity, update store.Update) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var doc identityDocument
+		key, err := identityKey(tx, identity)
+		switch {
+		case err == nil:
+			if _, err := get(tx.Bucket(identitiesBucket), key, &doc); err != nil {
+				return errgo.Mask(err)
+			}
+		case identity.ID == "" && identity.ProviderID != "" && identity.Username != "" && update[store.Username] == store.Set:
+			// Create a new identity.
+			n, err := tx.Bucket(identitiesBucket).NextSequence()
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			key = seqKey(n)
+			doc.ProviderID = identity.ProviderID
+			if err := tx.Bucket(providerIDsBucket).Put([]byte(doc.ProviderID), key); err != nil {
+				return errgo.Mask(err)
+			}
+		default:
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		old := doc.Groups
+		if err := updateIdentity(tx, key, &doc, identity, update); err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrDuplicateUsername))
+		}
+		if err := put(tx.Bucket(identitiesBucket), key, &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		identity.ID = seqID(key)
+		return errgo.Mask(recordGroupChanges(ctx, tx, doc.Username, old, doc.Groups))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrDuplicateUsername), errgo.Is(store.ErrNotFound))
+}
+
+// updateIdentity applies the given update to the document stored with
+// the given key.
+func updateIdentity(tx *bolt.Tx, key []byte, dst *identityDocument, src *store.Identity, update store.Update) error {
+	if update[store.ProviderID] != store.NoUpdate {
+		panic(errgo.Newf("unsupported operation %v requested on ProviderID field", update[store.ProviderID]))
+	}
+	switch update[store.Username] {
+	case store.NoUpdate:
+	case store.Set:
+		usernames := tx.Bucket(usernamesBucket)
+		if k := usernames.Get([]byte(src.Username)); k != nil && string(k) != string(key) {
+			return store.DuplicateUsernameError(src.Username)
+		}
+		if dst.Username != "" && dst.Username != src.Username {
+			if err := usernames.Delete([]byte(dst.Username)); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		if err := usernames.Put([]byte(src.Username), key); err != nil {
+			return errgo.Mask(err)
+		}
+		dst.Username = src.Username
+	default:
+		panic("unsupported operation requested on Username field")
+	}
+	dst.Name = updateString(dst.Name, src.Name, update[store.Name])
+	dst.Email = updateString(dst.Email, src.Email, update[store.Email])
+	dst.Groups = updateStrings(dst.Groups, src.Groups, update[store.Groups])
+	dst.PublicKeys = updateKeys(dst.PublicKeys, src.PublicKeys, update[store.PublicKeys])
+	dst.LastDischarge = updateTime(dst.LastDischarge, src.LastDischarge, update[store.LastDischarge])
+	dst.LastLogin = updateTime(dst.LastLogin, src.LastLogin, update[store.LastLogin])
+	dst.ProviderInfo = updateMap(dst.ProviderInfo, src.ProviderInfo, update[store.ProviderInfo])
+	dst.ExtraInfo = updateMap(dst.ExtraInfo, src.ExtraInfo, update[store.ExtraInfo])
+	dst.Owner = store.ProviderIdentity(updateString(string(dst.Owner), string(src.Owner), update[store.Owner]))
+	dst.TokensRevoked = updateTime(dst.TokensRevoked, src.TokensRevoked, update[store.TokensRevoked])
+	dst.Suspended = updateBool(dst.Suspended, src.Suspended, update[store.Suspended])
+	dst.GroupExpiry = updateTimeMap(dst.GroupExpiry, src.GroupExpiry, update[store.GroupExpiry])
+	return nil
+}
+
+// IdentityCounts implements store.Store.IdentityCounts.
+func (s *identityStore) IdentityCounts(_ context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(providerIDsBucket).ForEach(func(k, _ []byte) error {
+			counts[store.ProviderIdentity(k).Provider()]++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return counts, nil
+}
+
+// DeleteIdentity implements store.Store.DeleteIdentity.
+func (s *identityStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, err := identityKey(tx, identity)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		var doc identityDocument
+		if _, err := get(tx.Bucket(identitiesBucket), key, &doc); err != nil {
+			return errgo.Mask(err)
+		}
+		if err := tx.Bucket(providerIDsBucket).Delete([]byte(doc.ProviderID)); err != nil {
+			return errgo.Mask(err)
+		}
+		if err := tx.Bucket(usernamesBucket).Delete([]byte(doc.Username)); err != nil {
+			return errgo.Mask(err)
+		}
+		if err := tx.Bucket(identitiesBucket).Delete(key); err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(recordGroupChanges(ctx, tx, doc.Username, doc.Groups, nil))
+	})
+	return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+}
+
+// fromDocument fills out the given identity from the document stored
+// with the given key.
+func fromDocument(identity *store.Identity, key []byte, doc *identityDocument) {
+	*identity = store.Identity{
+		ID:            seqID(key),
+		ProviderID:    doc.ProviderID,
+		Username:      doc.Username,
+		Name:          doc.Name,
+		Email:         doc.Email,
+		Groups:        doc.Groups,
+		PublicKeys:    doc.PublicKeys,
+		LastLogin:     doc.LastLogin,
+		LastDischarge: doc.LastDischarge,
+		ProviderInfo:  doc.ProviderInfo,
+		ExtraInfo:     doc.ExtraInfo,
+		Owner:         doc.Owner,
+		TokensRevoked: doc.TokensRevoked,
+		Suspended:     doc.Suspended,
+		GroupExpiry:   doc.GroupExpiry,
+	}
+	if identity.ProviderInfo == nil {
+		identity.ProviderInfo = make(map[string][]string)
+	}
+	if identity.ExtraInfo == nil {
+		identity.ExtraInfo = make(map[string][]string)
+	}
+}
+
+func matchIdentity(a, b *store.Identity, filter store.Filter) bool {
+	for f, c := range filter {
+		if c == store.NoComparison {
+			continue
+		}
+		var r int
+		switch store.Field(f) {
+		case store.ProviderID:
+			r = strings.Compare(string(a.ProviderID), string(b.ProviderID))
+		case store.Username:
+			r = strings.Compare(a.Username, b.Username)
+		case store.Name:
+			r = strings.Compare(a.Name, b.Name)
+		case store.Email:
+			r = strings.Compare(a.Email, b.Email)
+		case store.Groups:
+			if c != store.Equal {
+				panic("unsupported groups comparison")
+			}
+			if !containsAll(a.Groups, b.Groups) {
+				return false
+			}
+			continue
+		case store.LastLogin:
+			r = cmpTime(a.LastLogin, b.LastLogin)
+		case store.LastDischarge:
+			r = cmpTime(a.LastDischarge, b.LastDischarge)
+		case store.Owner:
+			r = strings.Compare(string(a.Owner), string(b.Owner))
+		case store.TokensRevoked:
+			r = cmpTime(a.TokensRevoked, b.TokensRevoked)
+		case store.Suspended:
+			r = cmpBool(a.Suspended, b.Suspended)
+		default:
+			panic("unsupported filter field")
+		}
+		if !matchCmp(r, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCmp determines whether the given value n which is a result of a
+// "cmp" function such as strings.Compare indicates that the compared
+// values have the relationship specified by the given store.Comparison.
+func matchCmp(n int, c store.Comparison) bool {
+	switch c {
+	case store.Equal:
+		return n == 0
+	case store.NotEqual:
+		return n != 0
+	case store.GreaterThan:
+		return n > 0
+	case store.LessThan:
+		return n < 0
+	case store.GreaterThanOrEqual:
+		return n >= 0
+	case store.LessThanOrEqual:
+		return n <= 0
+	default:
+		panic("unsupported comparison")
+	}
+}
+
+// containsAll determines whether every value in vs is also in ss.
+func containsAll(ss, vs []string) bool {
+	for _, v := range vs {
+		found := false
+		for _, s := range ss {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func cmpTime(t, u time.Time) int {
+	if t.After(u) {
+		return 1
+	}
+	if t.Before(u) {
+		return -1
+	}
+	return 0
+}
+
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+type identitySort struct {
+	identities []store.Identity
+	sort       []store.Sort
+}
+
+func (s identitySort) Len() int {
+	return len(s.identities)
+}
+
+func (s identitySort) Swap(i, j int) {
+	s.identities[i], s.identities[j] = s.identities[j], s.identities[i]
+}
+
+func (s identitySort) Less(i, j int) bool {
+	a := &s.identities[i]
+	b := &s.identities[j]
+	for _, sort := range s.sort {
+		switch s.cmp(a, b, sort.Field, sort.Descending) {
+		case 1:
+			return false
+		case -1:
+			return true
+		}
+	}
+	return false
+}
+
+func (s identitySort) cmp(a, b *store.Identity, f store.Field, desc bool) int {
+	cmp := 0
+	switch f {
+	case store.ProviderID:
+		cmp = strings.Compare(string(a.ProviderID), string(b.ProviderID))
+	case store.Username:
+		cmp = strings.Compare(a.Username, b.Username)
+	case store.Name:
+		cmp = strings.Compare(a.Name, b.Name)
+	case store.Email:
+		cmp = strings.Compare(a.Email, b.Email)
+	case store.LastLogin:
+		cmp = cmpTime(a.LastLogin, b.LastLogin)
+	case store.LastDischarge:
+		cmp = cmpTime(a.LastDischarge, b.LastDischarge)
+	default:
+		panic("unsupported sort field")
+	}
+	if desc {
+		return 0 - cmp
+	}
+	return cmp
+}
+
+func updateString(dst, src string, op store.Operation) string {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return src
+	case store.Clear:
+		return ""
+	default:
+		panic("unsupported operation requested on string field")
+	}
+}
+
+func updateTime(dst, src time.Time, op store.Operation) time.Time {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return src
+	case store.Clear:
+		return time.Time{}
+	default:
+		panic("unsupported operation requested on time field")
+	}
+}
+
+func updateBool(dst, src bool, op store.Operation) bool {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return src
+	case store.Clear:
+		return false
+	default:
+		panic("unsupported operation requested on bool field")
+	}
+}
+
+func updateStrings(dst, src []string, op store.Operation) []string {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return append([]string(nil), src...)
+	case store.Clear:
+		return nil
+	case store.Push:
+		for _, s := range src {
+			if !containsString(dst, s) {
+				dst = append(dst, s)
+			}
+		}
+		return dst
+	case store.Pull:
+		var ndst []string
+		for _, s := range dst {
+			if !containsString(src, s) {
+				ndst = append(ndst, s)
+			}
+		}
+		return ndst
+	default:
+		panic("unsupported operation requested on []string field")
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+func updateKeys(dst, src []bakery.PublicKey, op store.Operation) []bakery.PublicKey {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return append([]bakery.PublicKey(nil), src...)
+	case store.Clear:
+		return nil
+	case store.Push:
+		for _, k := range src {
+			if !containsKey(dst, k) {
+				dst = append(dst, k)
+			}
+		}
+		return dst
+	case store.Pull:
+		var ndst []bakery.PublicKey
+		for _, k := range dst {
+			if !containsKey(src, k) {
+				ndst = append(ndst, k)
+			}
+		}
+		return ndst
+	default:
+		panic("unsupported operation requested on []bakery.PublicKey field")
+	}
+}
+
+func containsKey(ks []bakery.PublicKey, k bakery.PublicKey) bool {
+	for _, k1 := range ks {
+		if k == k1 {
+			return true
+		}
+	}
+	return false
+}
+
+func updateMap(dst, src map[string][]string, op store.Operation) map[string][]string {
+	if op == store.NoUpdate {
+		return dst
+	}
+	for k, v := range src {
+		ss := updateStrings(dst[k], v, op)
+		if len(ss) == 0 {
+			delete(dst, k)
+			continue
+		}
+		if dst == nil {
+			dst = make(map[string][]string)
+		}
+		dst[k] = ss
+	}
+	return dst
+}
+
+func updateTimeMap(dst, src map[string]time.Time, op store.Operation) map[string]time.Time {
+	for k, v := range src {
+		switch op {
+		case store.NoUpdate:
+			return dst
+		case store.Set:
+			if dst == nil {
+				dst = make(map[string]time.Time)
+			}
+			dst[k] = v
+		case store.Clear:
+			delete(dst, k)
+		default:
+			panic("unsupported operation requested on map[string]time.Time field")
+		}
+	}
+	return dst
+}
@@ -1,11 +1,15 @@
 package store
 
 import (
+	"context"
+
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/utils/debugstatus"
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 )
 
@@ -39,10 +43,34 @@ type Backend interface {
 	// ACLs for system functions.
 	ACLStore() aclstore.ACLStore
 
+	// AuditStore returns a new audit.Store implementation that
+	// uses the backend.
+	AuditStore() audit.Store
+
 	// Close closes the Backend instance.
 	Close()
 }
 
+// A RawBackend is a Backend that also gives access to the data that is
+// otherwise held opaquely behind the bakery root key store and the
+// identity provider key-value stores. It is used by tools that copy the
+// complete contents of one backend to another.
+type RawBackend interface {
+	Backend
+
+	// RootKeys returns all the unexpired bakery root keys held in
+	// the backend.
+	RootKeys(ctx context.Context) ([]dbrootkeystore.RootKey, error)
+
+	// AddRootKey adds the given bakery root key to the backend. If a
+	// key with the same ID already exists it is left unchanged.
+	AddRootKey(ctx context.Context, key dbrootkeystore.RootKey) error
+
+	// ProviderData returns all the unexpired values held in the
+	// identity provider key-value stores.
+	ProviderData(ctx context.Context) ([]ProviderData, error)
+}
+
 // BackendFactory represents a value that can create new storage
 // backend instances.
 type BackendFactory interface {
@@ -17,6 +17,15 @@ var (
 	// ErrDuplicateUsername is the error cause used when an update
 	// attempts to set a username that is already in use.
 	ErrDuplicateUsername = errgo.New("duplicate username")
+
+	// ErrDuplicateGroup is the error cause used when attempting to
+	// add a group with a name that is already in use.
+	ErrDuplicateGroup = errgo.New("duplicate group")
+
+	// ErrElevationStateChanged is the error cause used when
+	// attempting to update an elevation that is no longer in the
+	// expected state.
+	ErrElevationStateChanged = errgo.New("elevation state changed")
 )
 
 // NotFoundError creates a new error with a cause of ErrNotFound and an
@@ -44,6 +53,22 @@ func DuplicateUsernameError(username string) error {
 	return err
 }
 
+// GroupNotFoundError creates a new error with a cause of ErrNotFound
+// and an appropriate message.
+func GroupNotFoundError(name string) error {
+	err := errgo.WithCausef(nil, ErrNotFound, "group %s not found", name)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
+// DuplicateGroupError creates a new error with a cause of
+// ErrDuplicateGroup and an appropriate message.
+func DuplicateGroupError(name string) error {
+	err := errgo.WithCausef(nil, ErrDuplicateGroup, "group %s already exists", name)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
 // KeyNotFoundError creates a new error with a cause of ErrNotFound and
 // an appropriate message.
 func KeyNotFoundError(key string) error {
@@ -51,3 +76,27 @@ func KeyNotFoundError(key string) error {
 	err.(*errgo.Err).SetLocation(1)
 	return err
 }
+
+// ElevationNotFoundError creates a new error with a cause of
+// ErrNotFound and an appropriate message.
+func ElevationNotFoundError(id string) error {
+	err := errgo.WithCausef(nil, ErrNotFound, "elevation %s not found", id)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
+// ElevationStateChangedError creates a new error with a cause of
+// ErrElevationStateChanged and an appropriate message.
+func ElevationStateChangedError(id string, state ElevationState) error {
+	err := errgo.WithCausef(nil, ErrElevationStateChanged, "elevation %s is %s", id, state)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
+
+// OutboxEntryNotFoundError creates a new error with a cause of
+// ErrNotFound and an appropriate message.
+func OutboxEntryNotFoundError(id string) error {
+	err := errgo.WithCausef(nil, ErrNotFound, "outbox entry %s not found", id)
+	err.(*errgo.Err).SetLocation(1)
+	return err
+}
@@ -5,6 +5,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/juju/simplekv"
 )
@@ -17,3 +18,21 @@ type ProviderDataStore interface {
 	// identity provider.
 	KeyValueStore(ctx context.Context, idp string) (simplekv.Store, error)
 }
+
+// ProviderData holds a single value from the key-value store of an
+// identity provider.
+type ProviderData struct {
+	// IDP holds the name of the identity provider that owns the
+	// value.
+	IDP string
+
+	// Key holds the key of the value.
+	Key string
+
+	// Value holds the stored value.
+	Value []byte
+
+	// Expire holds the time at which the value expires. If it is
+	// zero the value never expires.
+	Expire time.Time
+}
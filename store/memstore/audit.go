@@ -0,0 +1,54 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/canonical/candid/audit"
+)
+
+// NewAuditStore creates a new in-memory audit.Store implementation.
+func NewAuditStore() audit.Store {
+	return &auditStore{}
+}
+
+type auditStore struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+// Log implements audit.Store.Log.
+func (s *auditStore) Log(_ context.Context, e audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Keep the events ordered by time even if events are logged
+	// out of order.
+	i := len(s.events)
+	for i > 0 && s.events[i-1].Time.After(e.Time) {
+		i--
+	}
+	s.events = append(s.events, audit.Event{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = e
+	return nil
+}
+
+// Events implements audit.Store.Events.
+func (s *auditStore) Events(_ context.Context, f audit.Filter) ([]audit.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []audit.Event
+	for _, e := range s.events {
+		if !f.Match(e) {
+			continue
+		}
+		events = append(events, e)
+		if f.Limit > 0 && len(events) == f.Limit {
+			break
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+)
+
+// rootKeyBacking is an in-memory implementation of
+// dbrootkeystore.Backing.
+type rootKeyBacking struct {
+	mu   sync.Mutex
+	keys []dbrootkeystore.RootKey
+}
+
+// GetKey implements dbrootkeystore.Backing.GetKey.
+func (b *rootKeyBacking) GetKey(id []byte) (dbrootkeystore.RootKey, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range b.keys {
+		if string(k.Id) == string(id) {
+			return k, nil
+		}
+	}
+	return dbrootkeystore.RootKey{}, bakery.ErrNotFound
+}
+
+// FindLatestKey implements dbrootkeystore.Backing.FindLatestKey.
+func (b *rootKeyBacking) FindLatestKey(createdAfter, expiresAfter, expiresBefore time.Time) (dbrootkeystore.RootKey, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var latest dbrootkeystore.RootKey
+	for _, k := range b.keys {
+		if k.Created.Before(createdAfter) || k.Expires.Before(expiresAfter) || k.Expires.After(expiresBefore) {
+			continue
+		}
+		if k.Created.After(latest.Created) {
+			latest = k
+		}
+	}
+	return latest, nil
+}
+
+// InsertKey implements dbrootkeystore.Backing.InsertKey.
+func (b *rootKeyBacking) InsertKey(key dbrootkeystore.RootKey) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range b.keys {
+		if string(k.Id) == string(key.Id) {
+			return nil
+		}
+	}
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+// rootKeys returns all the unexpired keys.
+func (b *rootKeyBacking) rootKeys(now time.Time) []dbrootkeystore.RootKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []dbrootkeystore.RootKey
+	for _, k := range b.keys {
+		if k.Expires.After(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
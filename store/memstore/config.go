@@ -1,33 +1,55 @@
 package memstore
 
 import (
+	"context"
+	"time"
+
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/simplekv/memsimplekv"
 	"github.com/juju/utils/debugstatus"
 	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 )
 
 func init() {
 	store.Register("memory", func(func(interface{}) error) (store.BackendFactory, error) {
-		return &backend{
-			store:        NewStore(),
-			rootKeys:     bakery.NewMemRootKeyStore(),
-			providerData: NewProviderDataStore(),
-			meetingStore: NewMeetingStore(),
-			aclStore:     aclstore.NewACLStore(memsimplekv.NewStore()),
-		}, nil
+		return newBackend(), nil
 	})
 }
 
+// NewBackend creates a new in-memory store.RawBackend.
+func NewBackend() store.RawBackend {
+	return newBackend()
+}
+
+func newBackend() *backend {
+	keys := new(rootKeyBacking)
+	rootKeys := dbrootkeystore.NewRootKeys(100, nil).NewStore(keys, dbrootkeystore.Policy{
+		ExpiryDuration: 365 * 24 * time.Hour,
+	})
+	return &backend{
+		store:          NewStore(),
+		rootKeyBacking: keys,
+		rootKeys:       rootKeys,
+		providerData:   newProviderDataStore(),
+		meetingStore:   NewMeetingStore(),
+		aclStore:       aclstore.NewACLStore(memsimplekv.NewStore()),
+		auditStore:     NewAuditStore(),
+	}
+}
+
 type backend struct {
-	store        store.Store
-	providerData store.ProviderDataStore
-	rootKeys     bakery.RootKeyStore
-	meetingStore meeting.Store
-	aclStore     aclstore.ACLStore
+	store          store.Store
+	providerData   *providerDataStore
+	rootKeyBacking *rootKeyBacking
+	rootKeys       bakery.RootKeyStore
+	meetingStore   meeting.Store
+	aclStore       aclstore.ACLStore
+	auditStore     audit.Store
 }
 
 // NewBackend implements store.BackendFactory.NewBackend.
@@ -64,5 +86,25 @@ func (b *backend) ACLStore() aclstore.ACLStore {
 	return b.aclStore
 }
 
+// AuditStore implements store.Backend.AuditStore.
+func (b *backend) AuditStore() audit.Store {
+	return b.auditStore
+}
+
 func (b *backend) Close() {
 }
+
+// RootKeys implements store.RawBackend.RootKeys.
+func (b *backend) RootKeys(context.Context) ([]dbrootkeystore.RootKey, error) {
+	return b.rootKeyBacking.rootKeys(time.Now()), nil
+}
+
+// AddRootKey implements store.RawBackend.AddRootKey.
+func (b *backend) AddRootKey(_ context.Context, key dbrootkeystore.RootKey) error {
+	return b.rootKeyBacking.InsertKey(key)
+}
+
+// ProviderData implements store.RawBackend.ProviderData.
+func (b *backend) ProviderData(context.Context) ([]store.ProviderData, error) {
+	return b.providerData.providerData(), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/canonical/candid/store"
+)
+
+// AddElevation implements store.Store.AddElevation.
+func (s *memStore) AddElevation(_ context.Context, e *store.Elevation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = strconv.Itoa(len(s.elevations))
+	s.elevations = append(s.elevations, *e)
+	return nil
+}
+
+// Elevation implements store.Store.Elevation.
+func (s *memStore) Elevation(_ context.Context, e *store.Elevation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e1 := s.elevation(e.ID)
+	if e1 == nil {
+		return store.ElevationNotFoundError(e.ID)
+	}
+	*e = *e1
+	return nil
+}
+
+// FindElevations implements store.Store.FindElevations.
+func (s *memStore) FindElevations(_ context.Context, f store.ElevationFilter) ([]store.Elevation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var elevations []store.Elevation
+	// Elevations are held in the order they were added, which is
+	// the order in which they were requested.
+	for _, e := range s.elevations {
+		if f.Username != "" && e.Username != f.Username {
+			continue
+		}
+		if f.Group != "" && e.Group != f.Group {
+			continue
+		}
+		if f.State != "" && e.State != f.State {
+			continue
+		}
+		elevations = append(elevations, e)
+	}
+	return elevations, nil
+}
+
+// UpdateElevation implements store.Store.UpdateElevation.
+func (s *memStore) UpdateElevation(_ context.Context, e *store.Elevation, from store.ElevationState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e1 := s.elevation(e.ID)
+	if e1 == nil {
+		return store.ElevationNotFoundError(e.ID)
+	}
+	if e1.State != from {
+		return store.ElevationStateChangedError(e.ID, e1.State)
+	}
+	e1.State = e.State
+	e1.Approver = e.Approver
+	e1.Decided = e.Decided
+	e1.Expires = e.Expires
+	return nil
+}
+
+// elevation returns the elevation with the given ID, or nil if there is
+// no such elevation. s.mu must be held when calling elevation.
+func (s *memStore) elevation(id string) *store.Elevation {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 || n >= len(s.elevations) {
+		return nil
+	}
+	return &s.elevations[n]
+}
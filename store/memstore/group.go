@@ -0,0 +1,134 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/canonical/candid/store"
+)
+
+// Group implements store.Store.Group.
+func (s *memStore) Group(_ context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[group.Name]
+	if !ok {
+		return store.GroupNotFoundError(group.Name)
+	}
+	copyGroup(group, g)
+	return nil
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s *memStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := make([]store.Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		var group store.Group
+		copyGroup(&group, g)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// AddGroup implements store.Store.AddGroup.
+func (s *memStore) AddGroup(ctx context.Context, group *store.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[group.Name]; ok {
+		return store.DuplicateGroupError(group.Name)
+	}
+	g := new(store.Group)
+	copyGroup(g, group)
+	s.groups[group.Name] = g
+	s.recordMemberGroupChanges(ctx, g.Name, nil, g.MemberGroups)
+	return nil
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s *memStore) UpdateGroup(ctx context.Context, group *store.Group, update store.GroupUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[group.Name]
+	if !ok {
+		return store.GroupNotFoundError(group.Name)
+	}
+	g.Description = updateString(g.Description, group.Description, update[store.GroupDescription])
+	g.Owners = updateStrings(g.Owners, group.Owners, update[store.GroupOwners])
+	old := g.MemberGroups
+	g.MemberGroups = updateStrings(g.MemberGroups, group.MemberGroups, update[store.GroupMemberGroups])
+	s.recordMemberGroupChanges(ctx, g.Name, old, g.MemberGroups)
+	return nil
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s *memStore) RemoveGroup(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[name]
+	if !ok {
+		return store.GroupNotFoundError(name)
+	}
+	delete(s.groups, name)
+	s.recordMemberGroupChanges(ctx, name, g.MemberGroups, nil)
+	return nil
+}
+
+// recordMemberGroupChanges records the change of the member groups of
+// the given group from old to new, if the given context requires it.
+func (s *memStore) recordMemberGroupChanges(ctx context.Context, group string, old, new []string) {
+	if actor, ok := store.GroupChangeActor(ctx); ok {
+		s.groupChanges = append(s.groupChanges, store.DiffMemberGroups(time.Now(), actor, group, old, new)...)
+	}
+}
+
+// AddGroupChanges implements store.Store.AddGroupChanges.
+func (s *memStore) AddGroupChanges(_ context.Context, changes []store.GroupChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groupChanges = append(s.groupChanges, changes...)
+	return nil
+}
+
+// GroupChanges implements store.Store.GroupChanges.
+func (s *memStore) GroupChanges(_ context.Context, f store.GroupChangeFilter) ([]store.GroupChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changes []store.GroupChange
+	for _, gc := range s.groupChanges {
+		if f.Username != "" && gc.Username != f.Username {
+			continue
+		}
+		if f.Group != "" && gc.Group != f.Group {
+			continue
+		}
+		if !f.After.IsZero() && gc.Time.Before(f.After) {
+			continue
+		}
+		if !f.Before.IsZero() && !gc.Time.Before(f.Before) {
+			continue
+		}
+		changes = append(changes, gc)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Time.Before(changes[j].Time)
+	})
+	if f.Limit > 0 && len(changes) > f.Limit {
+		changes = changes[:f.Limit]
+	}
+	return changes, nil
+}
+
+func copyGroup(dst, src *store.Group) {
+	*dst = *src
+	dst.Owners = updateStrings(nil, src.Owners, store.Set)
+	dst.MemberGroups = updateStrings(nil, src.MemberGroups, store.Set)
+}
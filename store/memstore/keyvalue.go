@@ -5,24 +5,30 @@ package memstore
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/juju/simplekv"
-	"github.com/juju/simplekv/memsimplekv"
+	errgo "gopkg.in/errgo.v1"
 
 	"github.com/canonical/candid/store"
 )
 
 // NewProviderDataStore creates a new in-memory store.ProviderDataStore.
 func NewProviderDataStore() store.ProviderDataStore {
+	return newProviderDataStore()
+}
+
+func newProviderDataStore() *providerDataStore {
 	return &providerDataStore{
-		stores: make(map[string]simplekv.Store),
+		stores: make(map[string]*kvStore),
 	}
 }
 
 type providerDataStore struct {
 	mu     sync.Mutex
-	stores map[string]simplekv.Store
+	stores map[string]*kvStore
 }
 
 // KeyValueStore implements store.ProviderDataStore.KeyValueStore.
@@ -30,7 +36,103 @@ func (s *providerDataStore) KeyValueStore(_ context.Context, idp string) (simple
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.stores[idp] == nil {
-		s.stores[idp] = memsimplekv.NewStore()
+		s.stores[idp] = &kvStore{
+			data: make(map[string]kvEntry),
+		}
 	}
 	return s.stores[idp], nil
 }
+
+// providerData returns all the unexpired values held in the store,
+// ordered by identity provider and key.
+func (s *providerDataStore) providerData() []store.ProviderData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var data []store.ProviderData
+	for idp, kv := range s.stores {
+		kv.mu.Lock()
+		for k, e := range kv.data {
+			if e.expired(now) {
+				continue
+			}
+			data = append(data, store.ProviderData{
+				IDP:    idp,
+				Key:    k,
+				Value:  e.value,
+				Expire: e.expire,
+			})
+		}
+		kv.mu.Unlock()
+	}
+	sort.Slice(data, func(i, j int) bool {
+		if data[i].IDP != data[j].IDP {
+			return data[i].IDP < data[j].IDP
+		}
+		return data[i].Key < data[j].Key
+	})
+	return data
+}
+
+// A kvStore is an in-memory simplekv.Store. Like memsimplekv, values
+// are never expired, but the expiry time is recorded so that it can be
+// reported by providerData.
+type kvStore struct {
+	mu   sync.Mutex
+	data map[string]kvEntry
+}
+
+type kvEntry struct {
+	value  []byte
+	expire time.Time
+}
+
+func (e kvEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !e.expire.After(now)
+}
+
+// Context implements simplekv.Store.Context.
+func (s *kvStore) Context(ctx context.Context) (_ context.Context, close func()) {
+	return ctx, func() {}
+}
+
+// Get implements simplekv.Store.Get.
+func (s *kvStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.data[key]
+	if !ok {
+		return nil, simplekv.KeyNotFoundError(key)
+	}
+	return e.value, nil
+}
+
+// Set implements simplekv.Store.Set.
+func (s *kvStore) Set(_ context.Context, key string, value []byte, expire time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, value, expire)
+	return nil
+}
+
+// Update implements simplekv.Store.Update.
+func (s *kvStore) Update(_ context.Context, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := getVal(s.data[key].value)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	s.set(key, v, expire)
+	return nil
+}
+
+func (s *kvStore) set(key string, value []byte, expire time.Time) {
+	if value == nil {
+		value = []byte{}
+	}
+	s.data[key] = kvEntry{
+		value:  value,
+		expire: expire,
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/juju/aclstore/v2"
 	"github.com/juju/simplekv/memsimplekv"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 	"github.com/canonical/candid/store/memstore"
@@ -40,6 +41,15 @@ func TestMeetingStore(t *testing.T) {
 	}, memstore.PutAtTime)
 }
 
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) audit.Store {
+		return memstore.NewAuditStore()
+	})
+}
+
 func TestACLStore(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -58,3 +68,12 @@ storage:
     type: memory
 `)
 }
+
+func TestRawBackend(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestRawBackend(c, func(c *qt.C) store.RawBackend {
+		return memstore.NewBackend()
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package memstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/canonical/candid/store"
+)
+
+// AddOutboxEntries implements store.Store.AddOutboxEntries.
+func (s *memStore) AddOutboxEntries(_ context.Context, entries []store.OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range entries {
+		entries[i].ID = strconv.Itoa(s.nextOutboxID)
+		s.nextOutboxID++
+		s.outbox = append(s.outbox, entries[i])
+	}
+	return nil
+}
+
+// OutboxEntries implements store.Store.OutboxEntries.
+func (s *memStore) OutboxEntries(_ context.Context, due time.Time, limit int) ([]store.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []store.OutboxEntry
+	// Entries are held in the order they were added.
+	for _, e := range s.outbox {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		if e.NextAttempt.After(due) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// UpdateOutboxEntry implements store.Store.UpdateOutboxEntry.
+func (s *memStore) UpdateOutboxEntry(_ context.Context, e *store.OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.outboxEntry(e.ID)
+	if n < 0 {
+		return store.OutboxEntryNotFoundError(e.ID)
+	}
+	s.outbox[n].Attempts = e.Attempts
+	s.outbox[n].NextAttempt = e.NextAttempt
+	return nil
+}
+
+// ClaimOutboxEntry implements store.Store.ClaimOutboxEntry.
+func (s *memStore) ClaimOutboxEntry(_ context.Context, id string, due, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.outboxEntry(id)
+	if n < 0 || s.outbox[n].NextAttempt.After(due) {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	s.outbox[n].NextAttempt = until
+	return nil
+}
+
+// RemoveOutboxEntry implements store.Store.RemoveOutboxEntry.
+func (s *memStore) RemoveOutboxEntry(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.outboxEntry(id)
+	if n < 0 {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	s.outbox = append(s.outbox[:n], s.outbox[n+1:]...)
+	return nil
+}
+
+// outboxEntry returns the index of the outbox entry with the given ID,
+// or -1 if there is no such entry. s.mu must be held when calling
+// outboxEntry.
+func (s *memStore) outboxEntry(id string) int {
+	for i, e := range s.outbox {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -21,13 +21,20 @@ import (
 )
 
 type memStore struct {
-	mu         sync.Mutex
-	identities []*store.Identity
+	mu           sync.Mutex
+	identities   []*store.Identity
+	groups       map[string]*store.Group
+	groupChanges []store.GroupChange
+	elevations   []store.Elevation
+	outbox       []store.OutboxEntry
+	nextOutboxID int
 }
 
 // NewStore creates a new in-memory store.Store instance.
 func NewStore() store.Store {
-	return &memStore{}
+	return &memStore{
+		groups: make(map[string]*store.Group),
+	}
 }
 
 // Context implements store.Store.Context by returning the given context
@@ -40,7 +47,8 @@ var adminID = store.MakeProviderIdentity("idm", "admin")
 
 // RemoveAll is implemented so that tests can clear out the data.
 // It removes all identities except the admin identity created at
-// init time.
+// init time, all groups, all recorded group changes, all elevations
+// and all outbox entries.
 // TODO provide a standard store.Store way of removing
 // identities.
 func (s *memStore) RemoveAll() {
@@ -48,11 +56,15 @@ func (s *memStore) RemoveAll() {
 	defer s.mu.Unlock()
 	var identities []*store.Identity
 	for _, identity := range s.identities {
-		if identity.ProviderID == adminID {
+		if identity != nil && identity.ProviderID == adminID {
 			identities = append(identities, identity)
 		}
 	}
 	s.identities = identities
+	s.groups = make(map[string]*store.Group)
+	s.groupChanges = nil
+	s.elevations = nil
+	s.outbox = nil
 }
 
 // Identity implements store.Store.Identity.
@@ -63,7 +75,7 @@ func (s *memStore) Identity(_ context.Context, identity *store.Identity) error {
 	switch {
 	case identity.ID != "":
 		n, err := strconv.Atoi(identity.ID)
-		if err != nil || n >= len(s.identities) {
+		if err != nil || n >= len(s.identities) || s.identities[n] == nil {
 			return store.NotFoundError(identity.ID, "", "")
 		}
 		id = s.identities[n]
@@ -88,7 +100,7 @@ func (s *memStore) Identity(_ context.Context, identity *store.Identity) error {
 // with the given providerID.
 func (s *memStore) identityFromProviderID(providerID store.ProviderIdentity) *store.Identity {
 	for _, id := range s.identities {
-		if id.ProviderID == providerID {
+		if id != nil && id.ProviderID == providerID {
 			return id
 		}
 	}
@@ -99,7 +111,7 @@ func (s *memStore) identityFromProviderID(providerID store.ProviderIdentity) *st
 // with the given username.
 func (s *memStore) identityFromUsername(username string) *store.Identity {
 	for _, id := range s.identities {
-		if id.Username == username {
+		if id != nil && id.Username == username {
 			return id
 		}
 	}
@@ -112,7 +124,7 @@ func (s *memStore) FindIdentities(ctx context.Context, ref *store.Identity, filt
 	defer s.mu.Unlock()
 	identities := make([]store.Identity, 0, len(s.identities))
 	for _, identity := range s.identities {
-		if !matchIdentity(identity, ref, filter) {
+		if identity == nil || !matchIdentity(identity, ref, filter) {
 			continue
 		}
 		var identity1 store.Identity
@@ -150,12 +162,24 @@ func matchIdentity(a, b *store.Identity, filter store.Filter) bool {
 			r = strings.Compare(a.Name, b.Name)
 		case store.Email:
 			r = strings.Compare(a.Email, b.Email)
+		case store.Groups:
+			if c != store.Equal {
+				panic("unsupported groups comparison")
+			}
+			if !containsAll(a.Groups, b.Groups) {
+				return false
+			}
+			continue
 		case store.LastLogin:
 			r = cmpTime(a.LastLogin, b.LastLogin)
 		case store.LastDischarge:
 			r = cmpTime(a.LastDischarge, b.LastDischarge)
 		case store.Owner:
 			r = strings.Compare(string(a.Owner), string(b.Owner))
+		case store.TokensRevoked:
+			r = cmpTime(a.TokensRevoked, b.TokensRevoked)
+		case store.Suspended:
+			r = cmpBool(a.Suspended, b.Suspended)
 		default:
 			panic("unsupported filter field")
 		}
@@ -188,6 +212,23 @@ func matchCmp(n int, c store.Comparison) bool {
 	}
 }
 
+// containsAll determines whether every value in vs is also in ss.
+func containsAll(ss, vs []string) bool {
+	for _, v := range vs {
+		found := false
+		for _, s := range ss {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func cmpTime(t, u time.Time) int {
 	if t.After(u) {
 		return 1
@@ -198,6 +239,16 @@ func cmpTime(t, u time.Time) int {
 	return 0
 }
 
+func cmpBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
 type identitySort struct {
 	identities []store.Identity
 	sort       []store.Sort
@@ -250,14 +301,14 @@ func (s identitySort) cmp(a, b *store.Identity, f store.Field, desc bool) int {
 }
 
 // UpdateIdentity implements store.Store.UpdateIdentity.
-func (s *memStore) UpdateIdentity(_ context.Context, identity *store.Identity, update store.Update) error {
+func (s *memStore) UpdateIdentity(ctx context.Context, identity *store.Identity, update store.Update) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	var id *store.Identity
 	switch {
 	case identity.ID != "":
 		n, err := strconv.Atoi(identity.ID)
-		if err != nil || n >= len(s.identities) {
+		if err != nil || n >= len(s.identities) || s.identities[n] == nil {
 			return store.NotFoundError(identity.ID, "", "")
 		}
 		id = s.identities[n]
@@ -279,6 +330,7 @@ func (s *memStore) UpdateIdentity(_ context.Context, identity *store.Identity, u
 			}
 			s.identities = append(s.identities, id)
 			identity.ID = id.ID
+			s.recordGroupChanges(ctx, id.Username, nil, id.Groups)
 			return nil
 		}
 	case identity.Username != "":
@@ -289,7 +341,21 @@ func (s *memStore) UpdateIdentity(_ context.Context, identity *store.Identity, u
 	default:
 		return store.NotFoundError("", "", "")
 	}
-	return errgo.Mask(s.updateIdentity(id, identity, update), errgo.Is(store.ErrDuplicateUsername))
+	old := id.Groups
+	if err := s.updateIdentity(id, identity, update); err != nil {
+		return errgo.Mask(err, errgo.Is(store.ErrDuplicateUsername))
+	}
+	s.recordGroupChanges(ctx, id.Username, old, id.Groups)
+	return nil
+}
+
+// recordGroupChanges records the change of the groups of the given user
+// from old to new if the given context requires it. It must be called
+// with s.mu held.
+func (s *memStore) recordGroupChanges(ctx context.Context, username string, old, new []string) {
+	if actor, ok := store.GroupChangeActor(ctx); ok {
+		s.groupChanges = append(s.groupChanges, store.DiffGroups(time.Now(), actor, username, old, new)...)
+	}
 }
 
 func (s *memStore) updateIdentity(dst, src *store.Identity, update store.Update) error {
@@ -316,6 +382,9 @@ func (s *memStore) updateIdentity(dst, src *store.Identity, update store.Update)
 	dst.ProviderInfo = updateMap(dst.ProviderInfo, src.ProviderInfo, update[store.ProviderInfo])
 	dst.ExtraInfo = updateMap(dst.ExtraInfo, src.ExtraInfo, update[store.ExtraInfo])
 	dst.Owner = updateProviderIdentity(dst.Owner, src.Owner, update[store.Owner])
+	dst.TokensRevoked = updateTime(dst.TokensRevoked, src.TokensRevoked, update[store.TokensRevoked])
+	dst.Suspended = updateBool(dst.Suspended, src.Suspended, update[store.Suspended])
+	dst.GroupExpiry = updateTimeMap(dst.GroupExpiry, src.GroupExpiry, update[store.GroupExpiry])
 	return nil
 }
 
@@ -358,6 +427,19 @@ func updateTime(dst, src time.Time, op store.Operation) time.Time {
 	}
 }
 
+func updateBool(dst, src bool, op store.Operation) bool {
+	switch op {
+	case store.NoUpdate:
+		return dst
+	case store.Set:
+		return src
+	case store.Clear:
+		return false
+	default:
+		panic("unsupported operation requested on bool field")
+	}
+}
+
 func updateStrings(dst, src []string, op store.Operation) []string {
 	switch op {
 	case store.NoUpdate:
@@ -444,12 +526,32 @@ func updateMap(dst, src map[string][]string, op store.Operation) map[string][]st
 	return dst
 }
 
+func updateTimeMap(dst, src map[string]time.Time, op store.Operation) map[string]time.Time {
+	for k, v := range src {
+		switch op {
+		case store.NoUpdate:
+			return dst
+		case store.Set:
+			if dst == nil {
+				dst = make(map[string]time.Time)
+			}
+			dst[k] = v
+		case store.Clear:
+			delete(dst, k)
+		default:
+			panic("unsupported operation requested on map[string]time.Time field")
+		}
+	}
+	return dst
+}
+
 func copyIdentity(dst, src *store.Identity) {
 	*dst = *src
 	dst.Groups = updateStrings(nil, src.Groups, store.Set)
 	dst.PublicKeys = updateKeys(nil, src.PublicKeys, store.Set)
 	dst.ProviderInfo = updateMap(make(map[string][]string), src.ProviderInfo, store.Set)
 	dst.ExtraInfo = updateMap(make(map[string][]string), src.ExtraInfo, store.Set)
+	dst.GroupExpiry = updateTimeMap(nil, src.GroupExpiry, store.Set)
 }
 
 // IdentityCounts implements store.Store.IdentityCounts.
@@ -458,7 +560,43 @@ func (s *memStore) IdentityCounts(_ context.Context) (map[string]int, error) {
 	defer s.mu.Unlock()
 	counts := make(map[string]int)
 	for _, id := range s.identities {
-		counts[id.ProviderID.Provider()]++
+		if id != nil {
+			counts[id.ProviderID.Provider()]++
+		}
 	}
 	return counts, nil
 }
+
+// DeleteIdentity implements store.Store.DeleteIdentity.
+func (s *memStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id *store.Identity
+	switch {
+	case identity.ID != "":
+		n, err := strconv.Atoi(identity.ID)
+		if err != nil || n >= len(s.identities) || s.identities[n] == nil {
+			return store.NotFoundError(identity.ID, "", "")
+		}
+		id = s.identities[n]
+	case identity.ProviderID != "":
+		id = s.identityFromProviderID(identity.ProviderID)
+		if id == nil {
+			return store.NotFoundError("", identity.ProviderID, "")
+		}
+	case identity.Username != "":
+		id = s.identityFromUsername(identity.Username)
+		if id == nil {
+			return store.NotFoundError("", "", identity.Username)
+		}
+	default:
+		return store.NotFoundError("", "", "")
+	}
+	// The ID of an identity is its index in the identities slice,
+	// so leave a hole rather than shuffling the remaining
+	// identities down.
+	n, _ := strconv.Atoi(id.ID)
+	s.identities[n] = nil
+	s.recordGroupChanges(ctx, id.Username, id.Groups, nil)
+	return nil
+}
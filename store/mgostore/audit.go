@@ -0,0 +1,110 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/canonical/candid/audit"
+)
+
+const auditCollection = "audit"
+
+// auditDocument holds the in-database representation of an
+// audit.Event.
+type auditDocument struct {
+	Time   time.Time       `bson:"time"`
+	Type   audit.EventType `bson:"type"`
+	Actor  string          `bson:"actor,omitempty"`
+	User   string          `bson:"user,omitempty"`
+	IDP    string          `bson:"idp,omitempty"`
+	Caveat string          `bson:"caveat,omitempty"`
+	ACL    string          `bson:"acl,omitempty"`
+	Group  string          `bson:"group,omitempty"`
+	Set    []string        `bson:"set,omitempty"`
+	Add    []string        `bson:"add,omitempty"`
+	Remove []string        `bson:"remove,omitempty"`
+	Until  *time.Time      `bson:"until,omitempty"`
+	Error  string          `bson:"error,omitempty"`
+}
+
+// auditStore is an implementation of audit.Store that uses a mongodb
+// collection for the persistent data store.
+type auditStore struct {
+	b *backend
+}
+
+// Log implements audit.Store.Log.
+func (s *auditStore) Log(ctx context.Context, e audit.Event) error {
+	coll := s.b.c(ctx, auditCollection)
+	defer coll.Database.Session.Close()
+
+	err := coll.Insert(auditDocument(e))
+	return errgo.Mask(err)
+}
+
+// Events implements audit.Store.Events.
+func (s *auditStore) Events(ctx context.Context, f audit.Filter) ([]audit.Event, error) {
+	coll := s.b.c(ctx, auditCollection)
+	defer coll.Database.Session.Close()
+
+	query := make(bson.D, 0, 3)
+	if f.Type != "" {
+		query = append(query, bson.DocElem{"type", f.Type})
+	}
+	if f.User != "" {
+		query = append(query, bson.DocElem{"$or", []bson.D{
+			{{"actor", f.User}},
+			{{"user", f.User}},
+		}})
+	}
+	var timeQuery bson.D
+	if !f.After.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{"$gte", f.After})
+	}
+	if !f.Before.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{"$lt", f.Before})
+	}
+	if len(timeQuery) > 0 {
+		query = append(query, bson.DocElem{"time", timeQuery})
+	}
+	q := coll.Find(query).Sort("time")
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	var events []audit.Event
+	it := q.Iter()
+	var doc auditDocument
+	for it.Next(&doc) {
+		events = append(events, audit.Event(doc))
+		doc = auditDocument{}
+	}
+	if err := it.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return events, nil
+}
+
+var auditIndexes = []mgo.Index{{
+	Key: []string{"time"},
+}, {
+	Key: []string{"actor", "time"},
+}, {
+	Key: []string{"user", "time"},
+}}
+
+func ensureAuditIndexes(db *mgo.Database) error {
+	coll := db.C(auditCollection)
+	for _, idx := range auditIndexes {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
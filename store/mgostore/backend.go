@@ -15,6 +15,7 @@ import (
 	"gopkg.in/macaroon-bakery.v2/bakery/mgorootkeystore"
 	mgo "gopkg.in/mgo.v2"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 )
@@ -33,7 +34,7 @@ type backend struct {
 // NewBackend creates a new Backend instance using the given
 // *mgo.Database. The given Database's underlying session will be
 // copied. The Backend must be closed when finished with.
-func NewBackend(db *mgo.Database) (_ store.Backend, err error) {
+func NewBackend(db *mgo.Database) (_ store.RawBackend, err error) {
 	db = db.With(db.Session.Copy())
 	defer func() {
 		if err != nil {
@@ -47,6 +48,18 @@ func NewBackend(db *mgo.Database) (_ store.Backend, err error) {
 	if err := ensureMeetingIndexes(db); err != nil {
 		return nil, errgo.Mask(err)
 	}
+	if err := ensureAuditIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := ensureGroupChangeIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := ensureElevationIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := ensureOutboxIndexes(db); err != nil {
+		return nil, errgo.Mask(err)
+	}
 	rk := mgorootkeystore.NewRootKeys(1000) // TODO(mhilton) make this configurable?
 	if err := ensureBakeryIndexes(rk, db); err != nil {
 		return nil, errgo.Mask(err)
@@ -109,6 +122,11 @@ func (b *backend) MeetingStore() meeting.Store {
 	return &meetingStore{b}
 }
 
+// AuditStore implements store.Backend.AuditStore.
+func (b *backend) AuditStore() audit.Store {
+	return &auditStore{b}
+}
+
 // BakeryRootKeyStore implements store.Backend.BakeryRootKeyStore.
 func (b *backend) BakeryRootKeyStore() bakery.RootKeyStore {
 	return &rootKeyStore{
@@ -147,7 +165,12 @@ func (c collector) Collections() []*mgo.Collection {
 		c.db.C(macaroonCollection),
 		c.db.C(meetingCollection),
 		c.db.C(identitiesCollection),
+		c.db.C(groupsCollection),
 		c.db.C(aclsCollection),
+		c.db.C(auditCollection),
+		c.db.C(groupChangesCollection),
+		c.db.C(elevationsCollection),
+		c.db.C(outboxCollection),
 	}
 }
 
@@ -5,10 +5,13 @@ package mgostore
 
 import (
 	"context"
+	"time"
 
 	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
 	"gopkg.in/macaroon-bakery.v2/bakery/mgorootkeystore"
 	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 const macaroonCollection = "macaroons"
@@ -36,6 +39,28 @@ func (s rootKeyStore) RootKey(ctx context.Context) ([]byte, []byte, error) {
 	return store.RootKey(ctx)
 }
 
+// RootKeys implements store.RawBackend.RootKeys.
+func (b *backend) RootKeys(ctx context.Context) ([]dbrootkeystore.RootKey, error) {
+	coll := b.c(ctx, macaroonCollection)
+	defer coll.Database.Session.Close()
+	var keys []dbrootkeystore.RootKey
+	err := coll.Find(bson.D{{"expires", bson.D{{"$gt", time.Now()}}}}).Sort("created").All(&keys)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return keys, nil
+}
+
+// AddRootKey implements store.RawBackend.AddRootKey.
+func (b *backend) AddRootKey(ctx context.Context, key dbrootkeystore.RootKey) error {
+	coll := b.c(ctx, macaroonCollection)
+	defer coll.Database.Session.Close()
+	if err := coll.Insert(key); err != nil && !mgo.IsDup(err) {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
 func ensureBakeryIndexes(rk *mgorootkeystore.RootKeys, db *mgo.Database) error {
 	if err := rk.EnsureIndex(db.C(macaroonCollection)); err != nil {
 		return errgo.Mask(err)
@@ -4,6 +4,7 @@
 package mgostore
 
 import (
+	"strings"
 	"time"
 
 	"github.com/juju/loggo"
@@ -29,6 +30,9 @@ var fieldNames = []string{
 	store.ProviderInfo:  "providerinfo",
 	store.ExtraInfo:     "extrainfo",
 	store.Owner:         "owner",
+	store.TokensRevoked: "tokensrevoked",
+	store.Suspended:     "suspended",
+	store.GroupExpiry:   "groupexpiry",
 }
 
 // identityDocument holds the in-database representation of a user in the identities
@@ -72,6 +76,44 @@ type identityDocument struct {
 
 	// Owner holds the provider id of the owner.
 	Owner string
+
+	// TokensRevoked holds the time at which the tokens issued for
+	// this identity were last revoked.
+	TokensRevoked time.Time
+
+	// Suspended holds whether the identity has been suspended.
+	Suspended bool
+
+	// GroupExpiry holds the time at which membership of each group
+	// expires. The keys are group names escaped with
+	// escapeGroupExpiryKey.
+	GroupExpiry map[string]time.Time
+}
+
+// groupExpiryKeyEscaper escapes the characters in a group name that are
+// not allowed, or have a special meaning, in a mongodb field name;
+// groupExpiryKeyUnescaper reverses the escaping.
+var groupExpiryKeyEscaper = strings.NewReplacer("%", "%25", ".", "%2E", "$", "%24")
+
+var groupExpiryKeyUnescaper = strings.NewReplacer("%25", "%", "%2E", ".", "%24", "$")
+
+// escapeGroupExpiryKey returns the key in the GroupExpiry document for
+// the given group.
+func escapeGroupExpiryKey(group string) string {
+	return groupExpiryKeyEscaper.Replace(group)
+}
+
+// groupExpiry returns the expiry times held in the document keyed by
+// group name.
+func (d identityDocument) groupExpiry() map[string]time.Time {
+	if d.GroupExpiry == nil {
+		return nil
+	}
+	groupExpiry := make(map[string]time.Time, len(d.GroupExpiry))
+	for k, v := range d.GroupExpiry {
+		groupExpiry[groupExpiryKeyUnescaper.Replace(k)] = v
+	}
+	return groupExpiry
 }
 
 // PublicKeys converts the stored public keys into the format used by the
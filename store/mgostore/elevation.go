@@ -0,0 +1,166 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/canonical/candid/store"
+)
+
+const elevationsCollection = "elevations"
+
+// elevationDocument holds the in-database representation of a
+// store.Elevation.
+type elevationDocument struct {
+	ID        bson.ObjectId        `bson:"_id"`
+	Username  string               `bson:"username"`
+	Group     string               `bson:"group"`
+	Reason    string               `bson:"reason,omitempty"`
+	Duration  time.Duration        `bson:"duration"`
+	State     store.ElevationState `bson:"state"`
+	Requested time.Time            `bson:"requested"`
+	Approver  string               `bson:"approver,omitempty"`
+	Decided   time.Time            `bson:"decided,omitempty"`
+	Expires   time.Time            `bson:"expires,omitempty"`
+}
+
+func (doc *elevationDocument) elevation() store.Elevation {
+	return store.Elevation{
+		ID:        doc.ID.Hex(),
+		Username:  doc.Username,
+		Group:     doc.Group,
+		Reason:    doc.Reason,
+		Duration:  doc.Duration,
+		State:     doc.State,
+		Requested: doc.Requested,
+		Approver:  doc.Approver,
+		Decided:   doc.Decided,
+		Expires:   doc.Expires,
+	}
+}
+
+// AddElevation implements store.Store.AddElevation.
+func (s *identityStore) AddElevation(ctx context.Context, e *store.Elevation) error {
+	coll := s.b.c(ctx, elevationsCollection)
+	defer coll.Database.Session.Close()
+
+	doc := elevationDocument{
+		ID:        bson.NewObjectId(),
+		Username:  e.Username,
+		Group:     e.Group,
+		Reason:    e.Reason,
+		Duration:  e.Duration,
+		State:     e.State,
+		Requested: e.Requested,
+		Approver:  e.Approver,
+		Decided:   e.Decided,
+		Expires:   e.Expires,
+	}
+	if err := coll.Insert(doc); err != nil {
+		return errgo.Mask(err)
+	}
+	e.ID = doc.ID.Hex()
+	return nil
+}
+
+// Elevation implements store.Store.Elevation.
+func (s *identityStore) Elevation(ctx context.Context, e *store.Elevation) error {
+	coll := s.b.c(ctx, elevationsCollection)
+	defer coll.Database.Session.Close()
+
+	if !bson.IsObjectIdHex(e.ID) {
+		return store.ElevationNotFoundError(e.ID)
+	}
+	var doc elevationDocument
+	if err := coll.FindId(bson.ObjectIdHex(e.ID)).One(&doc); err != nil {
+		if errgo.Cause(err) == mgo.ErrNotFound {
+			return store.ElevationNotFoundError(e.ID)
+		}
+		return errgo.Mask(err)
+	}
+	*e = doc.elevation()
+	return nil
+}
+
+// FindElevations implements store.Store.FindElevations.
+func (s *identityStore) FindElevations(ctx context.Context, f store.ElevationFilter) ([]store.Elevation, error) {
+	coll := s.b.c(ctx, elevationsCollection)
+	defer coll.Database.Session.Close()
+
+	query := make(bson.D, 0, 3)
+	if f.Username != "" {
+		query = append(query, bson.DocElem{"username", f.Username})
+	}
+	if f.Group != "" {
+		query = append(query, bson.DocElem{"group", f.Group})
+	}
+	if f.State != "" {
+		query = append(query, bson.DocElem{"state", f.State})
+	}
+	var elevations []store.Elevation
+	it := coll.Find(query).Sort("requested", "_id").Iter()
+	var doc elevationDocument
+	for it.Next(&doc) {
+		elevations = append(elevations, doc.elevation())
+		doc = elevationDocument{}
+	}
+	if err := it.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return elevations, nil
+}
+
+// UpdateElevation implements store.Store.UpdateElevation.
+func (s *identityStore) UpdateElevation(ctx context.Context, e *store.Elevation, from store.ElevationState) error {
+	coll := s.b.c(ctx, elevationsCollection)
+	defer coll.Database.Session.Close()
+
+	if !bson.IsObjectIdHex(e.ID) {
+		return store.ElevationNotFoundError(e.ID)
+	}
+	id := bson.ObjectIdHex(e.ID)
+	err := coll.Update(bson.D{
+		{"_id", id},
+		{"state", from},
+	}, bson.D{{"$set", bson.D{
+		{"state", e.State},
+		{"approver", e.Approver},
+		{"decided", e.Decided},
+		{"expires", e.Expires},
+	}}})
+	if errgo.Cause(err) != mgo.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	// Find out why the elevation could not be updated.
+	var doc elevationDocument
+	if err := coll.FindId(id).One(&doc); err != nil {
+		if errgo.Cause(err) == mgo.ErrNotFound {
+			return store.ElevationNotFoundError(e.ID)
+		}
+		return errgo.Mask(err)
+	}
+	return store.ElevationStateChangedError(e.ID, doc.State)
+}
+
+var elevationIndexes = []mgo.Index{{
+	Key: []string{"username", "state"},
+}, {
+	Key: []string{"state", "requested"},
+}}
+
+func ensureElevationIndexes(db *mgo.Database) error {
+	coll := db.C(elevationsCollection)
+	for _, idx := range elevationIndexes {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
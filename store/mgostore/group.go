@@ -0,0 +1,163 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+	mgo "gopkg.in/mgo.v2"
+
+	"github.com/canonical/candid/store"
+)
+
+const groupsCollection = "groups"
+
+// groupFieldNames provides the name used in the mongo documents for
+// each group field.
+var groupFieldNames = []string{
+	store.GroupDescription:  "description",
+	store.GroupOwners:       "owners",
+	store.GroupMemberGroups: "membergroups",
+}
+
+// groupDocument holds the in-database representation of a group in the
+// groups collection.
+type groupDocument struct {
+	// Name holds the name of the group, group names are unique.
+	Name string `bson:"_id"`
+
+	// Description holds the description of the group.
+	Description string `bson:"description,omitempty"`
+
+	// Owners holds the ACL of users and groups that manage the
+	// group.
+	Owners []string `bson:"owners,omitempty"`
+
+	// MemberGroups holds the names of the groups that are members of
+	// the group.
+	MemberGroups []string `bson:"membergroups,omitempty"`
+}
+
+// Group implements store.Store.Group by retrieving the specified group
+// from the mongodb database.
+func (s *identityStore) Group(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	var doc groupDocument
+	if err := coll.FindId(group.Name).One(&doc); err != nil {
+		if errgo.Cause(err) == mgo.ErrNotFound {
+			return store.GroupNotFoundError(group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	*group = store.Group(doc)
+	return nil
+}
+
+// FindGroups implements store.Store.FindGroups by retrieving all groups
+// from the mongodb database.
+func (s *identityStore) FindGroups(ctx context.Context) ([]store.Group, error) {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	it := coll.Find(nil).Sort("_id").Iter()
+	var groups []store.Group
+	var doc groupDocument
+	for it.Next(&doc) {
+		groups = append(groups, store.Group(doc))
+		doc = groupDocument{}
+	}
+	if err := it.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return groups, nil
+}
+
+// AddGroup implements store.Store.AddGroup by inserting the group into
+// the mongodb database.
+func (s *identityStore) AddGroup(ctx context.Context, group *store.Group) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	if err := coll.Insert(groupDocument(*group)); err != nil {
+		if mgo.IsDup(err) {
+			return store.DuplicateGroupError(group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.recordMemberGroupChanges(ctx, group.Name, nil, group.MemberGroups))
+}
+
+// UpdateGroup implements store.Store.UpdateGroup by writing the group
+// update to the mongodb database.
+func (s *identityStore) UpdateGroup(ctx context.Context, group *store.Group, update store.GroupUpdate) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	var doc updateDocument
+	doc.addUpdate(update[store.GroupDescription], groupFieldNames[store.GroupDescription], group.Description)
+	doc.addUpdate(update[store.GroupOwners], groupFieldNames[store.GroupOwners], group.Owners)
+	doc.addUpdate(update[store.GroupMemberGroups], groupFieldNames[store.GroupMemberGroups], group.MemberGroups)
+	if doc.IsZero() {
+		n, err := coll.FindId(group.Name).Count()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if n == 0 {
+			return store.GroupNotFoundError(group.Name)
+		}
+		return nil
+	}
+	if _, ok := store.GroupChangeActor(ctx); !ok {
+		if err := coll.UpdateId(group.Name, doc); err != nil {
+			if err == mgo.ErrNotFound {
+				return store.GroupNotFoundError(group.Name)
+			}
+			return errgo.Mask(err)
+		}
+		return nil
+	}
+	// Apply the update with a findAndModify so that the member
+	// groups held immediately before the update are known.
+	var old groupDocument
+	if _, err := coll.FindId(group.Name).Apply(mgo.Change{Update: doc}, &old); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.GroupNotFoundError(group.Name)
+		}
+		return errgo.Mask(err)
+	}
+	memberGroups := updatedGroups(old.MemberGroups, group.MemberGroups, update[store.GroupMemberGroups])
+	return errgo.Mask(s.recordMemberGroupChanges(ctx, group.Name, old.MemberGroups, memberGroups))
+}
+
+// RemoveGroup implements store.Store.RemoveGroup by removing the group
+// from the mongodb database.
+func (s *identityStore) RemoveGroup(ctx context.Context, name string) error {
+	coll := s.b.c(ctx, groupsCollection)
+	defer coll.Database.Session.Close()
+
+	var old groupDocument
+	if _, err := coll.FindId(name).Apply(mgo.Change{Remove: true}, &old); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.GroupNotFoundError(name)
+		}
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.recordMemberGroupChanges(ctx, name, old.MemberGroups, nil))
+}
+
+// recordMemberGroupChanges records the change of the member groups of
+// the given group from old to new, if the given context requires it.
+// The mgo driver does not support transactions, so the changes are
+// recorded after the group has been updated.
+func (s *identityStore) recordMemberGroupChanges(ctx context.Context, group string, old, new []string) error {
+	actor, ok := store.GroupChangeActor(ctx)
+	if !ok {
+		return nil
+	}
+	return errgo.Mask(s.AddGroupChanges(ctx, store.DiffMemberGroups(time.Now(), actor, group, old, new)))
+}
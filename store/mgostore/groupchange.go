@@ -0,0 +1,99 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/canonical/candid/store"
+)
+
+const groupChangesCollection = "groupchanges"
+
+// groupChangeDocument holds the in-database representation of a
+// store.GroupChange.
+type groupChangeDocument struct {
+	Time        time.Time           `bson:"time"`
+	Actor       string              `bson:"actor,omitempty"`
+	Username    string              `bson:"username"`
+	MemberGroup string              `bson:"membergroup,omitempty"`
+	Group       string              `bson:"group"`
+	Op          store.GroupChangeOp `bson:"op"`
+	Until       time.Time           `bson:"until,omitempty"`
+}
+
+// AddGroupChanges implements store.Store.AddGroupChanges.
+func (s *identityStore) AddGroupChanges(ctx context.Context, changes []store.GroupChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	coll := s.b.c(ctx, groupChangesCollection)
+	defer coll.Database.Session.Close()
+
+	docs := make([]interface{}, len(changes))
+	for i, gc := range changes {
+		docs[i] = groupChangeDocument(gc)
+	}
+	return errgo.Mask(coll.Insert(docs...))
+}
+
+// GroupChanges implements store.Store.GroupChanges.
+func (s *identityStore) GroupChanges(ctx context.Context, f store.GroupChangeFilter) ([]store.GroupChange, error) {
+	coll := s.b.c(ctx, groupChangesCollection)
+	defer coll.Database.Session.Close()
+
+	query := make(bson.D, 0, 3)
+	if f.Username != "" {
+		query = append(query, bson.DocElem{"username", f.Username})
+	}
+	if f.Group != "" {
+		query = append(query, bson.DocElem{"group", f.Group})
+	}
+	var timeQuery bson.D
+	if !f.After.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{"$gte", f.After})
+	}
+	if !f.Before.IsZero() {
+		timeQuery = append(timeQuery, bson.DocElem{"$lt", f.Before})
+	}
+	if len(timeQuery) > 0 {
+		query = append(query, bson.DocElem{"time", timeQuery})
+	}
+	q := coll.Find(query).Sort("time", "_id")
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	var changes []store.GroupChange
+	it := q.Iter()
+	var doc groupChangeDocument
+	for it.Next(&doc) {
+		changes = append(changes, store.GroupChange(doc))
+		doc = groupChangeDocument{}
+	}
+	if err := it.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return changes, nil
+}
+
+var groupChangeIndexes = []mgo.Index{{
+	Key: []string{"username", "time"},
+}, {
+	Key: []string{"group", "time"},
+}}
+
+func ensureGroupChangeIndexes(db *mgo.Database) error {
+	coll := db.C(groupChangesCollection)
+	for _, idx := range groupChangeIndexes {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
@@ -5,16 +5,68 @@ package mgostore
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/juju/simplekv"
 	"github.com/juju/simplekv/mgosimplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
 )
 
+// kvCollectionPrefix is the prefix given to the name of the collection
+// holding the key-value store for an identity provider.
+const kvCollectionPrefix = "kv"
+
 // an providerDataStore implements store.ProviderDataStore.
 type providerDataStore struct {
 	backend *backend
 }
 
 func (s *providerDataStore) KeyValueStore(ctx context.Context, idp string) (simplekv.Store, error) {
-	return mgosimplekv.NewStore(s.backend.db.C("kv" + idp))
+	return mgosimplekv.NewStore(s.backend.db.C(kvCollectionPrefix + idp))
+}
+
+// kvDoc is the document stored by mgosimplekv.
+type kvDoc struct {
+	Key    string    `bson:"_id"`
+	Value  []byte    `bson:"value"`
+	Expire time.Time `bson:"expire"`
+}
+
+// ProviderData implements store.RawBackend.ProviderData.
+func (b *backend) ProviderData(ctx context.Context) ([]store.ProviderData, error) {
+	s := b.s(ctx)
+	defer s.Close()
+	db := b.db.With(s)
+	names, err := db.CollectionNames()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	now := time.Now()
+	var data []store.ProviderData
+	for _, name := range names {
+		if !strings.HasPrefix(name, kvCollectionPrefix) {
+			continue
+		}
+		idp := strings.TrimPrefix(name, kvCollectionPrefix)
+		var doc kvDoc
+		iter := db.C(name).Find(nil).Iter()
+		for iter.Next(&doc) {
+			if !doc.Expire.IsZero() && !doc.Expire.After(now) {
+				continue
+			}
+			data = append(data, store.ProviderData{
+				IDP:    idp,
+				Key:    doc.Key,
+				Value:  doc.Value,
+				Expire: doc.Expire,
+			})
+		}
+		if err := iter.Close(); err != nil {
+			return nil, errgo.Notef(err, "cannot read %s", name)
+		}
+	}
+	return data, nil
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/juju/mgotest"
 	errgo "gopkg.in/errgo.v1"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 	"github.com/canonical/candid/store/mgostore"
@@ -44,6 +45,24 @@ func TestMeetingStore(t *testing.T) {
 	}, mgostore.PutAtTime)
 }
 
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) audit.Store {
+		return newFixture(c).backend.AuditStore()
+	})
+}
+
+func TestRawBackend(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestRawBackend(c, func(c *qt.C) store.RawBackend {
+		return newFixture(c).backend
+	})
+}
+
 func TestACLStore(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -73,7 +92,7 @@ func TestRootKeyStore(t *testing.T) {
 }
 
 type fixture struct {
-	backend store.Backend
+	backend store.RawBackend
 	db      *mgotest.Database
 	connStr string
 }
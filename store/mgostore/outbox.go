@@ -0,0 +1,154 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mgostore
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/canonical/candid/store"
+)
+
+const outboxCollection = "outbox"
+
+// outboxDocument holds the in-database representation of a
+// store.OutboxEntry.
+type outboxDocument struct {
+	ID          bson.ObjectId `bson:"_id"`
+	Webhook     string        `bson:"webhook"`
+	Payload     []byte        `bson:"payload"`
+	Created     time.Time     `bson:"created"`
+	Attempts    int           `bson:"attempts"`
+	NextAttempt time.Time     `bson:"nextattempt"`
+}
+
+// AddOutboxEntries implements store.Store.AddOutboxEntries.
+func (s *identityStore) AddOutboxEntries(ctx context.Context, entries []store.OutboxEntry) error {
+	coll := s.b.c(ctx, outboxCollection)
+	defer coll.Database.Session.Close()
+
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		docs[i] = outboxDocument{
+			ID:          bson.NewObjectId(),
+			Webhook:     e.Webhook,
+			Payload:     e.Payload,
+			Created:     e.Created,
+			Attempts:    e.Attempts,
+			NextAttempt: e.NextAttempt,
+		}
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	if err := coll.Insert(docs...); err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range entries {
+		entries[i].ID = docs[i].(outboxDocument).ID.Hex()
+	}
+	return nil
+}
+
+// OutboxEntries implements store.Store.OutboxEntries.
+func (s *identityStore) OutboxEntries(ctx context.Context, due time.Time, limit int) ([]store.OutboxEntry, error) {
+	coll := s.b.c(ctx, outboxCollection)
+	defer coll.Database.Session.Close()
+
+	// Object IDs start with their creation time, so sorting by _id
+	// returns the entries in the order they were added.
+	q := coll.Find(bson.D{{"nextattempt", bson.D{{"$lte", due}}}}).Sort("_id")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var entries []store.OutboxEntry
+	it := q.Iter()
+	var doc outboxDocument
+	for it.Next(&doc) {
+		entries = append(entries, store.OutboxEntry{
+			ID:          doc.ID.Hex(),
+			Webhook:     doc.Webhook,
+			Payload:     doc.Payload,
+			Created:     doc.Created,
+			Attempts:    doc.Attempts,
+			NextAttempt: doc.NextAttempt,
+		})
+		doc = outboxDocument{}
+	}
+	if err := it.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entries, nil
+}
+
+// UpdateOutboxEntry implements store.Store.UpdateOutboxEntry.
+func (s *identityStore) UpdateOutboxEntry(ctx context.Context, e *store.OutboxEntry) error {
+	coll := s.b.c(ctx, outboxCollection)
+	defer coll.Database.Session.Close()
+
+	if !bson.IsObjectIdHex(e.ID) {
+		return store.OutboxEntryNotFoundError(e.ID)
+	}
+	err := coll.UpdateId(bson.ObjectIdHex(e.ID), bson.D{{"$set", bson.D{
+		{"attempts", e.Attempts},
+		{"nextattempt", e.NextAttempt},
+	}}})
+	if errgo.Cause(err) == mgo.ErrNotFound {
+		return store.OutboxEntryNotFoundError(e.ID)
+	}
+	return errgo.Mask(err)
+}
+
+// ClaimOutboxEntry implements store.Store.ClaimOutboxEntry.
+func (s *identityStore) ClaimOutboxEntry(ctx context.Context, id string, due, until time.Time) error {
+	coll := s.b.c(ctx, outboxCollection)
+	defer coll.Database.Session.Close()
+
+	if !bson.IsObjectIdHex(id) {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	err := coll.Update(bson.D{
+		{"_id", bson.ObjectIdHex(id)},
+		{"nextattempt", bson.D{{"$lte", due}}},
+	}, bson.D{{"$set", bson.D{
+		{"nextattempt", until},
+	}}})
+	if errgo.Cause(err) == mgo.ErrNotFound {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	return errgo.Mask(err)
+}
+
+// RemoveOutboxEntry implements store.Store.RemoveOutboxEntry.
+func (s *identityStore) RemoveOutboxEntry(ctx context.Context, id string) error {
+	coll := s.b.c(ctx, outboxCollection)
+	defer coll.Database.Session.Close()
+
+	if !bson.IsObjectIdHex(id) {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	err := coll.RemoveId(bson.ObjectIdHex(id))
+	if errgo.Cause(err) == mgo.ErrNotFound {
+		return store.OutboxEntryNotFoundError(id)
+	}
+	return errgo.Mask(err)
+}
+
+var outboxIndexes = []mgo.Index{{
+	Key: []string{"nextattempt"},
+}}
+
+func ensureOutboxIndexes(db *mgo.Database) error {
+	coll := db.C(outboxCollection)
+	for _, idx := range outboxIndexes {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ package mgostore
 import (
 	"context"
 	"fmt"
+	"time"
 
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
@@ -53,6 +54,9 @@ func (s *identityStore) Identity(ctx context.Context, identity *store.Identity)
 	identity.ProviderInfo = doc.ProviderInfo
 	identity.ExtraInfo = doc.ExtraInfo
 	identity.Owner = store.ProviderIdentity(doc.Owner)
+	identity.TokensRevoked = doc.TokensRevoked
+	identity.Suspended = doc.Suspended
+	identity.GroupExpiry = doc.groupExpiry()
 	return nil
 }
 
@@ -116,6 +120,9 @@ func (s *identityStore) FindIdentities(ctx context.Context, ref *store.Identity,
 			ProviderInfo:  doc.ProviderInfo,
 			ExtraInfo:     doc.ExtraInfo,
 			Owner:         store.ProviderIdentity(doc.Owner),
+			TokensRevoked: doc.TokensRevoked,
+			Suspended:     doc.Suspended,
+			GroupExpiry:   doc.groupExpiry(),
 		})
 	}
 	if err := it.Err(); err != nil {
@@ -130,9 +137,14 @@ func makeQuery(ref *store.Identity, filter store.Filter) bson.D {
 	query = appendComparison(query, fieldNames[store.Username], filter[store.Username], ref.Username)
 	query = appendComparison(query, fieldNames[store.Name], filter[store.Name], ref.Name)
 	query = appendComparison(query, fieldNames[store.Email], filter[store.Email], ref.Email)
+	if filter[store.Groups] == store.Equal {
+		query = append(query, bson.DocElem{fieldNames[store.Groups], bson.D{{"$all", ref.Groups}}})
+	}
 	query = appendComparison(query, fieldNames[store.LastLogin], filter[store.LastLogin], ref.LastLogin)
 	query = appendComparison(query, fieldNames[store.LastDischarge], filter[store.LastDischarge], ref.LastDischarge)
 	query = appendComparison(query, fieldNames[store.Owner], filter[store.Owner], ref.Owner)
+	query = appendComparison(query, fieldNames[store.TokensRevoked], filter[store.TokensRevoked], ref.TokensRevoked)
+	query = appendComparison(query, fieldNames[store.Suspended], filter[store.Suspended], ref.Suspended)
 	return query
 }
 
@@ -164,6 +176,9 @@ func (s *identityStore) UpdateIdentity(ctx context.Context, identity *store.Iden
 	coll := s.b.c(ctx, identitiesCollection)
 	defer coll.Database.Session.Close()
 
+	if actor, ok := store.GroupChangeActor(ctx); ok && update[store.Groups] != store.NoUpdate {
+		return errgo.Mask(s.updateIdentityRecordingGroups(ctx, coll, identity, update, actor), errgo.Is(store.ErrDuplicateUsername), errgo.Is(store.ErrNotFound))
+	}
 	if identity.ID == "" && identity.ProviderID != "" && identity.Username != "" && update[store.Username] == store.Set {
 		return errgo.Mask(s.upsertIdentity(coll, identity, update), errgo.Is(store.ErrDuplicateUsername))
 	}
@@ -204,6 +219,78 @@ func (s *identityStore) upsertIdentity(coll *mgo.Collection, identity *store.Ide
 	return nil
 }
 
+// updateIdentityRecordingGroups performs an identity update with a
+// single findAndModify, so that the groups held immediately before the
+// update are known, and records the resulting changes to the groups.
+// The mgo driver does not support transactions, so the changes are
+// recorded after the update has been made.
+func (s *identityStore) updateIdentityRecordingGroups(ctx context.Context, coll *mgo.Collection, identity *store.Identity, update store.Update, actor string) error {
+	query := identityQuery(identity)
+	change := mgo.Change{
+		Update: identityUpdate(identity, update),
+	}
+	if identity.ID == "" && identity.ProviderID != "" && identity.Username != "" && update[store.Username] == store.Set {
+		query = bson.D{{"providerid", identity.ProviderID}}
+		change.Upsert = true
+	}
+	var old identityDocument
+	info, err := coll.Find(query).Apply(change, &old)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+		}
+		if mgo.IsDup(err) {
+			return store.DuplicateUsernameError(identity.Username)
+		}
+		return errgo.Mask(err)
+	}
+	if id, ok := info.UpsertedId.(bson.ObjectId); ok {
+		identity.ID = id.Hex()
+	}
+	username := old.Username
+	if update[store.Username] == store.Set {
+		username = identity.Username
+	}
+	groups := updatedGroups(old.Groups, identity.Groups, update[store.Groups])
+	return errgo.Mask(s.AddGroupChanges(ctx, store.DiffGroups(time.Now(), actor, username, old.Groups, groups)))
+}
+
+// updatedGroups returns the groups that result from applying the given
+// operation with the given groups to the groups held in old.
+func updatedGroups(old, groups []string, op store.Operation) []string {
+	contains := func(ss []string, s string) bool {
+		for _, t := range ss {
+			if s == t {
+				return true
+			}
+		}
+		return false
+	}
+	switch op {
+	case store.Set:
+		return groups
+	case store.Clear:
+		return nil
+	case store.Push:
+		new := append([]string(nil), old...)
+		for _, g := range groups {
+			if !contains(new, g) {
+				new = append(new, g)
+			}
+		}
+		return new
+	case store.Pull:
+		var new []string
+		for _, g := range old {
+			if !contains(groups, g) {
+				new = append(new, g)
+			}
+		}
+		return new
+	}
+	return old
+}
+
 func identityUpdate(identity *store.Identity, update store.Update) updateDocument {
 	var doc updateDocument
 	doc.addUpdate(update[store.Username], fieldNames[store.Username], identity.Username)
@@ -220,6 +307,11 @@ func identityUpdate(identity *store.Identity, update store.Update) updateDocumen
 		doc.addUpdate(update[store.ExtraInfo], fieldNames[store.ExtraInfo]+"."+k, v)
 	}
 	doc.addUpdate(update[store.Owner], fieldNames[store.Owner], identity.Owner)
+	doc.addUpdate(update[store.TokensRevoked], fieldNames[store.TokensRevoked], identity.TokensRevoked)
+	doc.addUpdate(update[store.Suspended], fieldNames[store.Suspended], identity.Suspended)
+	for k, v := range identity.GroupExpiry {
+		doc.addUpdate(update[store.GroupExpiry], fieldNames[store.GroupExpiry]+"."+escapeGroupExpiryKey(k), v)
+	}
 	return doc
 }
 
@@ -272,3 +364,31 @@ func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, err
 	}
 	return counts, nil
 }
+
+// DeleteIdentity implements store.Store.DeleteIdentity by removing the
+// identity document from the mongodb database. All the data associated
+// with the identity is held in the document, so nothing else needs to
+// be removed.
+func (s *identityStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	coll := s.b.c(ctx, identitiesCollection)
+	defer coll.Database.Session.Close()
+
+	actor, recordGroups := store.GroupChangeActor(ctx)
+	if !recordGroups {
+		if err := coll.Remove(identityQuery(identity)); err != nil {
+			if err == mgo.ErrNotFound {
+				return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+			}
+			return errgo.Mask(err)
+		}
+		return nil
+	}
+	var old identityDocument
+	if _, err := coll.Find(identityQuery(identity)).Apply(mgo.Change{Remove: true}, &old); err != nil {
+		if err == mgo.ErrNotFound {
+			return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+		}
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.AddGroupChanges(ctx, store.DiffGroups(time.Now(), actor, old.Username, old.Groups, nil)))
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/audit"
+)
+
+// auditStore is an implementation of audit.Store that uses an sql
+// table. The fields that can be used to filter events are stored in
+// their own columns, the complete event is stored as JSON.
+type auditStore struct {
+	*backend
+}
+
+type auditParams struct {
+	argBuilder
+	Time   time.Time
+	Type   audit.EventType
+	Actor  string
+	User   string
+	Event  string
+	After  time.Time
+	Before time.Time
+	Limit  int
+}
+
+// Log implements audit.Store.Log.
+func (s *auditStore) Log(_ context.Context, e audit.Event) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	params := &auditParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Time:       e.Time,
+		Type:       e.Type,
+		Actor:      e.Actor,
+		User:       e.User,
+		Event:      string(buf),
+	}
+	_, err = s.driver.exec(s.db, tmplPutAuditEvent, params)
+	return errgo.Mask(err)
+}
+
+// Events implements audit.Store.Events.
+func (s *auditStore) Events(_ context.Context, f audit.Filter) ([]audit.Event, error) {
+	params := &auditParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Type:       f.Type,
+		User:       f.User,
+		After:      f.After,
+		Before:     f.Before,
+		Limit:      f.Limit,
+	}
+	rows, err := s.driver.query(s.db, tmplFindAuditEvents, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var events []audit.Event
+	for rows.Next() {
+		var buf string
+		if err := rows.Scan(&buf); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		var e audit.Event
+		if err := json.Unmarshal([]byte(buf), &e); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal audit event")
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return events, nil
+}
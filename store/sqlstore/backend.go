@@ -2,18 +2,20 @@ package sqlstore
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/juju/aclstore/v2"
-	"github.com/juju/simplekv/sqlsimplekv"
+	"github.com/juju/simplekv"
 	"github.com/juju/utils/debugstatus"
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
-	"gopkg.in/macaroon-bakery.v2/bakery/postgresrootkeystore"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 )
@@ -24,33 +26,38 @@ import (
 type backend struct {
 	db       *sql.DB
 	driver   *driver
-	rootKeys *postgresrootkeystore.RootKeys
+	rootKeys rootKeys
 	aclStore aclstore.ACLStore
 }
 
-// NewBackend creates a new store.Backend implementation using the
+// NewBackend creates a new store.RawBackend implementation using the
 // given driverName and *sql.DB. The driverName must match the value
-// used to open the database.
+// used to open the database. The supported drivers are "postgres" and
+// "sqlite3".
 //
 // Closing the returned Backend will also close db.
-func NewBackend(driverName string, db *sql.DB) (store.Backend, error) {
-	if driverName != "postgres" {
+func NewBackend(driverName string, db *sql.DB) (store.RawBackend, error) {
+	var driver *driver
+	var err error
+	switch driverName {
+	case "postgres":
+		driver, err = newPostgresDriver(db)
+	case "sqlite3":
+		driver, err = newSQLiteDriver(db)
+	default:
 		return nil, errgo.Newf("unsupported database driver %q", driverName)
 	}
-	driver, err := newPostgresDriver(db)
 	if err != nil {
 		return nil, errgo.Notef(err, "cannot initialise database")
 	}
-	rootkeys := postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000)
-	defer rootkeys.Close()
-	aclStore, err := sqlsimplekv.NewStore(driverName, db, "acls")
+	aclStore, err := driver.newKVStore(db, "acls")
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	return &backend{
 		db:       db,
 		driver:   driver,
-		rootKeys: postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000),
+		rootKeys: driver.newRootKeys(db),
 		aclStore: aclstore.NewACLStore(aclStore),
 	}, nil
 }
@@ -67,11 +74,40 @@ func (b *backend) Store() store.Store {
 }
 
 func (b *backend) BakeryRootKeyStore() bakery.RootKeyStore {
-	return b.rootKeys.NewStore(postgresrootkeystore.Policy{
+	return b.rootKeys.NewStore(dbrootkeystore.Policy{
 		ExpiryDuration: 365 * 24 * time.Hour,
 	})
 }
 
+// RootKeys implements store.RawBackend.RootKeys.
+func (b *backend) RootKeys(context.Context) ([]dbrootkeystore.RootKey, error) {
+	keys, err := b.rootKeys.findKeys()
+	return keys, errgo.Mask(err)
+}
+
+// AddRootKey implements store.RawBackend.AddRootKey.
+func (b *backend) AddRootKey(_ context.Context, key dbrootkeystore.RootKey) error {
+	return errgo.Mask(b.rootKeys.addKey(key))
+}
+
+// scanRootKeys reads all the root keys from the given rows, which must
+// contain the id, created, expires and rootkey columns in that order.
+func scanRootKeys(rows *sql.Rows) ([]dbrootkeystore.RootKey, error) {
+	defer rows.Close()
+	var keys []dbrootkeystore.RootKey
+	for rows.Next() {
+		var key dbrootkeystore.RootKey
+		if err := rows.Scan(&key.Id, &key.Created, &key.Expires, &key.RootKey); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return keys, nil
+}
+
 // ProviderDataStore returns a new store.ProviderDataStore implementation
 // using this database for persistent storage.
 func (b *backend) ProviderDataStore() store.ProviderDataStore {
@@ -88,9 +124,18 @@ func (b *backend) ACLStore() aclstore.ACLStore {
 	return b.aclStore
 }
 
+// AuditStore returns a new audit.Store implementation using this
+// database for persistent storage.
+func (b *backend) AuditStore() audit.Store {
+	return &auditStore{b}
+}
+
 // DebugStatusCheckerFuncs implements store.Backend.DebugStatusCheckerFuncs.
 func (b *backend) DebugStatusCheckerFuncs() []debugstatus.CheckerFunc {
-	return nil
+	return []debugstatus.CheckerFunc{
+		debugstatus.Rename("database_connected", "Database is connected", debugstatus.Connection(b.db)),
+		b.meetingStatus,
+	}
 }
 
 // withTx runs f in a new transaction. any error returned by f will not
@@ -121,6 +166,7 @@ const (
 	tmplClearIdentitySet
 	tmplPushIdentitySet
 	tmplPullIdentitySet
+	tmplDeleteIdentity
 	tmplGetProviderData
 	tmplGetProviderDataForUpdate
 	tmplInsertProviderData
@@ -129,6 +175,31 @@ const (
 	tmplFindMeetings
 	tmplRemoveMeetings
 	tmplIdentityCounts
+	tmplPutAuditEvent
+	tmplFindAuditEvents
+	tmplGroup
+	tmplFindGroups
+	tmplSelectGroupSet
+	tmplInsertGroup
+	tmplUpdateGroup
+	tmplGroupID
+	tmplRemoveGroup
+	tmplClearGroupSet
+	tmplPushGroupSet
+	tmplPullGroupSet
+	tmplPutGroupChange
+	tmplFindGroupChanges
+	tmplInsertElevation
+	tmplElevation
+	tmplFindElevations
+	tmplUpdateElevation
+	tmplInsertOutboxEntry
+	tmplFindOutboxEntries
+	tmplUpdateOutboxEntry
+	tmplClaimOutboxEntry
+	tmplRemoveOutboxEntry
+	tmplFindKVTables
+	tmplFindKVData
 	numTmpl
 )
 
@@ -152,11 +223,31 @@ type argBuilder interface {
 	args() []interface{}
 }
 
+// rootKeys is the interface implemented by the root key caches used
+// by the different drivers.
+type rootKeys interface {
+	// NewStore returns a bakery.RootKeyStore that generates keys
+	// according to the given policy.
+	NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore
+
+	// Close releases any resources held by the cache.
+	Close() error
+
+	// findKeys returns all the unexpired root keys.
+	findKeys() ([]dbrootkeystore.RootKey, error)
+
+	// addKey adds the given root key, unless a key with the same ID
+	// already exists.
+	addKey(key dbrootkeystore.RootKey) error
+}
+
 type driver struct {
 	name            string
 	tmpls           [numTmpl]*template.Template
 	argBuilderFunc  func() argBuilder
 	isDuplicateFunc func(error) bool
+	newKVStore      func(db *sql.DB, table string) (simplekv.Store, error)
+	newRootKeys     func(db *sql.DB) rootKeys
 }
 
 // exec performs the Exec method on the given queryer by processing the
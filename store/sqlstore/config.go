@@ -14,8 +14,17 @@ type Params struct {
 	ConnectionString string `yaml:"connection-string"`
 }
 
+// SQLiteParams holds the specification for the parameters used in the
+// config file for an SQLite database.
+type SQLiteParams struct {
+	// Path holds the path of the database file. The file is created
+	// if it does not exist.
+	Path string `yaml:"path"`
+}
+
 func init() {
 	store.Register("postgres", unmarshalBackend)
+	store.Register("sqlite", unmarshalSQLiteBackend)
 }
 
 func unmarshalBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
@@ -39,3 +48,32 @@ func (p Params) NewBackend() (store.Backend, error) {
 	}
 	return backend, nil
 }
+
+func unmarshalSQLiteBackend(unmarshal func(interface{}) error) (store.BackendFactory, error) {
+	var p SQLiteParams
+	if err := unmarshal(&p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if p.Path == "" {
+		return nil, errgo.Newf("no path specified")
+	}
+	return p, nil
+}
+
+// NewBackend implements store.BackendFactory.
+//
+// Every transaction takes the database write lock when it starts, this
+// is the equivalent of the row locks used with postgresql.
+func (p SQLiteParams) NewBackend() (store.Backend, error) {
+	logger.Infof("opening sqlite database %s", p.Path)
+	db, err := sql.Open("sqlite3", "file:"+p.Path+"?_foreign_keys=on&_txlock=immediate&_busy_timeout=10000&_journal_mode=WAL&_loc=UTC")
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot open database")
+	}
+	backend, err := NewBackend("sqlite3", db)
+	if err != nil {
+		db.Close()
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	return backend, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+type elevationParams struct {
+	argBuilder
+
+	ID        string
+	Username  string
+	Group     string
+	Reason    string
+	Duration  int64
+	State     store.ElevationState
+	Requested time.Time
+	Approver  string
+	Decided   nullTime
+	Expires   nullTime
+	ForUpdate bool
+}
+
+// AddElevation implements store.Store.AddElevation.
+func (s *identityStore) AddElevation(_ context.Context, e *store.Elevation) error {
+	params := &elevationParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Username:   e.Username,
+		Group:      e.Group,
+		Reason:     e.Reason,
+		Duration:   int64(e.Duration),
+		State:      e.State,
+		Requested:  e.Requested,
+		Approver:   e.Approver,
+		Decided:    nullTime{e.Decided, !e.Decided.IsZero()},
+		Expires:    nullTime{e.Expires, !e.Expires.IsZero()},
+	}
+	row, err := s.driver.queryRow(s.db, tmplInsertElevation, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var id string
+	if err := row.Scan(&id); err != nil {
+		return errgo.Mask(err)
+	}
+	e.ID = id
+	return nil
+}
+
+// Elevation implements store.Store.Elevation.
+func (s *identityStore) Elevation(_ context.Context, e *store.Elevation) error {
+	return errgo.Mask(s.elevation(s.db, e, false), errgo.Is(store.ErrNotFound))
+}
+
+// FindElevations implements store.Store.FindElevations.
+func (s *identityStore) FindElevations(_ context.Context, f store.ElevationFilter) ([]store.Elevation, error) {
+	params := &elevationParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Username:   f.Username,
+		Group:      f.Group,
+		State:      f.State,
+	}
+	rows, err := s.driver.query(s.db, tmplFindElevations, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var elevations []store.Elevation
+	for rows.Next() {
+		var e store.Elevation
+		if err := scanElevation(rows, &e); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		elevations = append(elevations, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return elevations, nil
+}
+
+// UpdateElevation implements store.Store.UpdateElevation.
+func (s *identityStore) UpdateElevation(_ context.Context, e *store.Elevation, from store.ElevationState) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		current := store.Elevation{
+			ID: e.ID,
+		}
+		if err := s.elevation(tx, &current, true); err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		if current.State != from {
+			return store.ElevationStateChangedError(e.ID, current.State)
+		}
+		params := &elevationParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			ID:         e.ID,
+			State:      e.State,
+			Approver:   e.Approver,
+			Decided:    nullTime{e.Decided, !e.Decided.IsZero()},
+			Expires:    nullTime{e.Expires, !e.Expires.IsZero()},
+		}
+		_, err := s.driver.exec(tx, tmplUpdateElevation, params)
+		return errgo.Mask(err)
+	}), errgo.Is(store.ErrNotFound), errgo.Is(store.ErrElevationStateChanged))
+}
+
+// elevation reads the elevation with the ID in e, locking the row if
+// forUpdate is true.
+func (s *identityStore) elevation(q queryer, e *store.Elevation, forUpdate bool) error {
+	if _, err := strconv.ParseInt(e.ID, 10, 64); err != nil {
+		// The ID cannot have come from this store.
+		return store.ElevationNotFoundError(e.ID)
+	}
+	params := &elevationParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         e.ID,
+		ForUpdate:  forUpdate,
+	}
+	row, err := s.driver.queryRow(q, tmplElevation, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := scanElevation(row, e); err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return store.ElevationNotFoundError(params.ID)
+		}
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+func scanElevation(s scanner, e *store.Elevation) error {
+	var reason, approver sql.NullString
+	var duration int64
+	var decided, expires nullTime
+	err := s.Scan(
+		&e.ID,
+		&e.Username,
+		&e.Group,
+		&reason,
+		&duration,
+		&e.State,
+		&e.Requested,
+		&approver,
+		&decided,
+		&expires,
+	)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(sql.ErrNoRows))
+	}
+	e.Reason = reason.String
+	e.Duration = time.Duration(duration)
+	e.Approver = approver.String
+	e.Decided = decided.Time
+	e.Expires = expires.Time
+	return nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+var groupTables = [store.NumGroupFields]string{
+	store.GroupOwners:       "group_owners",
+	store.GroupMemberGroups: "group_membergroups",
+}
+
+type groupParams struct {
+	argBuilder
+
+	Name        string
+	Description interface{}
+}
+
+// Group implements store.Store.Group.
+func (s *identityStore) Group(_ context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params := &groupParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			Name:       group.Name,
+		}
+		row, err := s.driver.queryRow(tx, tmplGroup, params)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var id string
+		if err := scanGroup(row, &id, group); err != nil {
+			if errgo.Cause(err) == sql.ErrNoRows {
+				return store.GroupNotFoundError(params.Name)
+			}
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(s.completeGroup(tx, id, group))
+	}), errgo.Is(store.ErrNotFound))
+}
+
+// FindGroups implements store.Store.FindGroups.
+func (s *identityStore) FindGroups(_ context.Context) ([]store.Group, error) {
+	var groups []store.Group
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := s.driver.query(tx, tmplFindGroups, s.driver.argBuilderFunc())
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			var group store.Group
+			if err := scanGroup(rows, &id, &group); err != nil {
+				return errgo.Mask(err)
+			}
+			ids = append(ids, id)
+			groups = append(groups, group)
+		}
+		if err := rows.Err(); err != nil {
+			return errgo.Mask(err)
+		}
+		for i := range groups {
+			if err := s.completeGroup(tx, ids[i], &groups[i]); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	})
+	return groups, errgo.Mask(err)
+}
+
+func scanGroup(s scanner, id *string, group *store.Group) error {
+	var description sql.NullString
+	if err := s.Scan(id, &group.Name, &description); err != nil {
+		return errgo.Mask(err, errgo.Is(sql.ErrNoRows))
+	}
+	group.Description = description.String
+	return nil
+}
+
+func (s *identityStore) completeGroup(tx *sql.Tx, id string, group *store.Group) error {
+	var err error
+	group.Owners, err = s.getGroupSet(tx, groupTables[store.GroupOwners], id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	group.MemberGroups, err = s.getGroupSet(tx, groupTables[store.GroupMemberGroups], id)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+type groupSetParams struct {
+	argBuilder
+
+	Table  string
+	ID     string
+	Values []interface{}
+}
+
+func (s *identityStore) getGroupSet(tx *sql.Tx, table, id string) ([]string, error) {
+	params := &groupSetParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Table:      table,
+		ID:         id,
+	}
+	rows, err := s.driver.query(tx, tmplSelectGroupSet, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		values = append(values, v)
+	}
+	return values, errgo.Mask(rows.Err())
+}
+
+// AddGroup implements store.Store.AddGroup.
+func (s *identityStore) AddGroup(ctx context.Context, group *store.Group) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params := &groupParams{
+			argBuilder:  s.driver.argBuilderFunc(),
+			Name:        group.Name,
+			Description: group.Description,
+		}
+		row, err := s.driver.queryRow(tx, tmplInsertGroup, params)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var id string
+		if err := row.Scan(&id); err != nil {
+			if s.driver.isDuplicateFunc(err) {
+				return store.DuplicateGroupError(group.Name)
+			}
+			return errgo.Mask(err)
+		}
+		if err := s.updateGroupSet(tx, store.GroupOwners, id, store.Set, group.Owners); err != nil {
+			return errgo.Mask(err)
+		}
+		if err := s.updateGroupSet(tx, store.GroupMemberGroups, id, store.Set, group.MemberGroups); err != nil {
+			return errgo.Mask(err)
+		}
+		if actor, ok := store.GroupChangeActor(ctx); ok {
+			return errgo.Mask(s.addGroupChanges(tx, store.DiffMemberGroups(time.Now(), actor, group.Name, nil, group.MemberGroups)))
+		}
+		return nil
+	}), errgo.Is(store.ErrDuplicateGroup))
+}
+
+// UpdateGroup implements store.Store.UpdateGroup.
+func (s *identityStore) UpdateGroup(ctx context.Context, group *store.Group, update store.GroupUpdate) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		tmpl := tmplUpdateGroup
+		params := &groupParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			Name:       group.Name,
+		}
+		switch update[store.GroupDescription] {
+		case store.Set:
+			params.Description = group.Description
+		case store.Clear:
+			params.Description = null{}
+		default:
+			tmpl = tmplGroupID
+		}
+		row, err := s.driver.queryRow(tx, tmpl, params)
+		if err != nil {
+			return errgo.Notef(err, "cannot update group")
+		}
+		var id string
+		if err := row.Scan(&id); err != nil {
+			if errgo.Cause(err) == sql.ErrNoRows {
+				return store.GroupNotFoundError(group.Name)
+			}
+			return errgo.Notef(err, "cannot update group")
+		}
+		if err := s.updateGroupSet(tx, store.GroupOwners, id, update[store.GroupOwners], group.Owners); err != nil {
+			return errgo.Notef(err, "cannot update group")
+		}
+		actor, record := store.GroupChangeActor(ctx)
+		record = record && update[store.GroupMemberGroups] != store.NoUpdate
+		var old []string
+		if record {
+			old, err = s.getGroupSet(tx, groupTables[store.GroupMemberGroups], id)
+			if err != nil {
+				return errgo.Notef(err, "cannot update group")
+			}
+		}
+		if err := s.updateGroupSet(tx, store.GroupMemberGroups, id, update[store.GroupMemberGroups], group.MemberGroups); err != nil {
+			return errgo.Notef(err, "cannot update group")
+		}
+		if !record {
+			return nil
+		}
+		new, err := s.getGroupSet(tx, groupTables[store.GroupMemberGroups], id)
+		if err != nil {
+			return errgo.Notef(err, "cannot update group")
+		}
+		return errgo.Mask(s.addGroupChanges(tx, store.DiffMemberGroups(time.Now(), actor, group.Name, old, new)))
+	}), errgo.Is(store.ErrNotFound))
+}
+
+func (s *identityStore) updateGroupSet(tx *sql.Tx, field store.GroupField, id string, op store.Operation, values []string) error {
+	if op == store.NoUpdate {
+		return nil
+	}
+	params := &groupSetParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Table:      groupTables[field],
+		ID:         id,
+	}
+	if op == store.Clear || op == store.Set {
+		if _, err := s.driver.exec(tx, tmplClearGroupSet, params); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	var tmpl tmplID
+	switch op {
+	case store.Set, store.Push:
+		tmpl = tmplPushGroupSet
+	case store.Pull:
+		tmpl = tmplPullGroupSet
+	default:
+		return nil
+	}
+	params.argBuilder = s.driver.argBuilderFunc()
+	for _, v := range values {
+		params.Values = append(params.Values, v)
+	}
+	_, err := s.driver.exec(tx, tmpl, params)
+	return errgo.Mask(err)
+}
+
+// RemoveGroup implements store.Store.RemoveGroup.
+func (s *identityStore) RemoveGroup(ctx context.Context, name string) error {
+	actor, ok := store.GroupChangeActor(ctx)
+	if !ok {
+		return errgo.Mask(s.removeGroup(s.db, name), errgo.Is(store.ErrNotFound))
+	}
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		params := &groupParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			Name:       name,
+		}
+		row, err := s.driver.queryRow(tx, tmplGroupID, params)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var id string
+		if err := row.Scan(&id); err != nil {
+			if errgo.Cause(err) == sql.ErrNoRows {
+				return store.GroupNotFoundError(name)
+			}
+			return errgo.Mask(err)
+		}
+		old, err := s.getGroupSet(tx, groupTables[store.GroupMemberGroups], id)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if err := s.removeGroup(tx, name); err != nil {
+			return errgo.Mask(err, errgo.Is(store.ErrNotFound))
+		}
+		return errgo.Mask(s.addGroupChanges(tx, store.DiffMemberGroups(time.Now(), actor, name, old, nil)))
+	}), errgo.Is(store.ErrNotFound))
+}
+
+func (s *identityStore) removeGroup(q queryer, name string) error {
+	params := &groupParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Name:       name,
+	}
+	res, err := s.driver.exec(q, tmplRemoveGroup, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if n == 0 {
+		return store.GroupNotFoundError(name)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+type groupChangeParams struct {
+	argBuilder
+
+	Time        time.Time
+	Actor       string
+	Username    string
+	MemberGroup string
+	Group       string
+	Op          store.GroupChangeOp
+	Until       nullTime
+	After       time.Time
+	Before      time.Time
+	Limit       int
+}
+
+// AddGroupChanges implements store.Store.AddGroupChanges.
+func (s *identityStore) AddGroupChanges(_ context.Context, changes []store.GroupChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		return s.addGroupChanges(tx, changes)
+	}))
+}
+
+func (s *identityStore) addGroupChanges(tx *sql.Tx, changes []store.GroupChange) error {
+	for _, gc := range changes {
+		params := &groupChangeParams{
+			argBuilder:  s.driver.argBuilderFunc(),
+			Time:        gc.Time,
+			Actor:       gc.Actor,
+			Username:    gc.Username,
+			MemberGroup: gc.MemberGroup,
+			Group:       gc.Group,
+			Op:          gc.Op,
+			Until:       nullTime{gc.Until, !gc.Until.IsZero()},
+		}
+		if _, err := s.driver.exec(tx, tmplPutGroupChange, params); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// recordGroupChanges records, in the given transaction, the change of
+// the groups of the identity with the given ID from old to their current
+// value.
+func (s *identityStore) recordGroupChanges(tx *sql.Tx, actor, id string, old []string) error {
+	identity := store.Identity{
+		ID: id,
+	}
+	if err := s.identity(tx, &identity); err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.addGroupChanges(tx, store.DiffGroups(time.Now(), actor, identity.Username, old, identity.Groups)))
+}
+
+// GroupChanges implements store.Store.GroupChanges.
+func (s *identityStore) GroupChanges(_ context.Context, f store.GroupChangeFilter) ([]store.GroupChange, error) {
+	params := &groupChangeParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Username:   f.Username,
+		Group:      f.Group,
+		After:      f.After,
+		Before:     f.Before,
+		Limit:      f.Limit,
+	}
+	rows, err := s.driver.query(s.db, tmplFindGroupChanges, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var changes []store.GroupChange
+	for rows.Next() {
+		var gc store.GroupChange
+		var actor, memberGroup sql.NullString
+		var until nullTime
+		if err := rows.Scan(&gc.Time, &actor, &gc.Username, &memberGroup, &gc.Group, &gc.Op, &until); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		gc.Actor = actor.String
+		gc.MemberGroup = memberGroup.String
+		gc.Until = until.Time
+		changes = append(changes, gc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return changes, nil
+}
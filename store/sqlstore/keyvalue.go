@@ -5,16 +5,101 @@ package sqlstore
 
 import (
 	"context"
+	"strings"
 
 	"github.com/juju/simplekv"
-	"github.com/juju/simplekv/sqlsimplekv"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
 )
 
+// kvTablePrefix is the prefix given to the name of the table holding
+// the key-value store for an identity provider.
+const kvTablePrefix = "idpkv_"
+
 // A providerDataStore implements store.ProviderDataStore.
 type providerDataStore struct {
 	b *backend
 }
 
 func (s *providerDataStore) KeyValueStore(_ context.Context, idp string) (simplekv.Store, error) {
-	return sqlsimplekv.NewStore(s.b.driver.name, s.b.db, "idpkv_"+idp)
+	return s.b.driver.newKVStore(s.b.db, kvTablePrefix+idp)
+}
+
+type kvTableParams struct {
+	argBuilder
+
+	Table string
+}
+
+// ProviderData implements store.RawBackend.ProviderData.
+func (b *backend) ProviderData(context.Context) ([]store.ProviderData, error) {
+	tables, err := b.kvTables()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var data []store.ProviderData
+	for _, table := range tables {
+		tableData, err := b.kvTableData(table)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		data = append(data, tableData...)
+	}
+	return data, nil
+}
+
+// kvTableData returns all the unexpired values in the given identity
+// provider key-value table.
+func (b *backend) kvTableData(table string) ([]store.ProviderData, error) {
+	params := &kvTableParams{
+		argBuilder: b.driver.argBuilderFunc(),
+		Table:      table,
+	}
+	rows, err := b.driver.query(b.db, tmplFindKVData, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	idp := strings.TrimPrefix(table, kvTablePrefix)
+	var data []store.ProviderData
+	for rows.Next() {
+		pd := store.ProviderData{
+			IDP: idp,
+		}
+		var expire nullTime
+		if err := rows.Scan(&pd.Key, &pd.Value, &expire); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		pd.Expire = expire.Time
+		data = append(data, pd)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+// kvTables returns the names of all the identity provider key-value
+// tables.
+func (b *backend) kvTables() ([]string, error) {
+	rows, err := b.driver.query(b.db, tmplFindKVTables, &kvTableParams{
+		argBuilder: b.driver.argBuilderFunc(),
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return tables, nil
 }
@@ -6,8 +6,10 @@ package sqlstore
 import (
 	"context"
 	"database/sql"
+	"strconv"
 	"time"
 
+	"github.com/juju/utils/debugstatus"
 	"gopkg.in/errgo.v1"
 )
 
@@ -141,3 +143,18 @@ func (s *meetingStore) RemoveOld(_ context.Context, addr string, olderThan time.
 	}
 	return ids, nil
 }
+
+// meetingStatus implements a debugstatus.CheckerFunc that reports the
+// number of meetings in the meetings table.
+func (b *backend) meetingStatus(context.Context) (key string, result debugstatus.CheckResult) {
+	result.Name = "count of meeting table"
+	result.Passed = true
+	var n int
+	if err := b.db.QueryRow("SELECT COUNT(1) FROM meetings").Scan(&n); err != nil {
+		result.Value = err.Error()
+		result.Passed = false
+		return "meeting_count", result
+	}
+	result.Value = strconv.Itoa(n)
+	return "meeting_count", result
+}
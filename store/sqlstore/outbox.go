@@ -0,0 +1,139 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+type outboxParams struct {
+	argBuilder
+
+	ID          string
+	Webhook     string
+	Payload     []byte
+	Created     time.Time
+	Attempts    int
+	NextAttempt time.Time
+	Due         time.Time
+	Limit       int
+}
+
+// AddOutboxEntries implements store.Store.AddOutboxEntries.
+func (s *identityStore) AddOutboxEntries(_ context.Context, entries []store.OutboxEntry) error {
+	ids := make([]string, len(entries))
+	err := s.withTx(func(tx *sql.Tx) error {
+		for i, e := range entries {
+			params := &outboxParams{
+				argBuilder:  s.driver.argBuilderFunc(),
+				Webhook:     e.Webhook,
+				Payload:     e.Payload,
+				Created:     e.Created,
+				Attempts:    e.Attempts,
+				NextAttempt: e.NextAttempt,
+			}
+			row, err := s.driver.queryRow(tx, tmplInsertOutboxEntry, params)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			if err := row.Scan(&ids[i]); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for i := range entries {
+		entries[i].ID = ids[i]
+	}
+	return nil
+}
+
+// OutboxEntries implements store.Store.OutboxEntries.
+func (s *identityStore) OutboxEntries(_ context.Context, due time.Time, limit int) ([]store.OutboxEntry, error) {
+	params := &outboxParams{
+		argBuilder:  s.driver.argBuilderFunc(),
+		NextAttempt: due,
+		Limit:       limit,
+	}
+	rows, err := s.driver.query(s.db, tmplFindOutboxEntries, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var entries []store.OutboxEntry
+	for rows.Next() {
+		var e store.OutboxEntry
+		if err := rows.Scan(&e.ID, &e.Webhook, &e.Payload, &e.Created, &e.Attempts, &e.NextAttempt); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entries, nil
+}
+
+// UpdateOutboxEntry implements store.Store.UpdateOutboxEntry.
+func (s *identityStore) UpdateOutboxEntry(_ context.Context, e *store.OutboxEntry) error {
+	params := &outboxParams{
+		argBuilder:  s.driver.argBuilderFunc(),
+		ID:          e.ID,
+		Attempts:    e.Attempts,
+		NextAttempt: e.NextAttempt,
+	}
+	return errgo.Mask(s.execOutbox(tmplUpdateOutboxEntry, params), errgo.Is(store.ErrNotFound))
+}
+
+// ClaimOutboxEntry implements store.Store.ClaimOutboxEntry.
+func (s *identityStore) ClaimOutboxEntry(_ context.Context, id string, due, until time.Time) error {
+	params := &outboxParams{
+		argBuilder:  s.driver.argBuilderFunc(),
+		ID:          id,
+		NextAttempt: until,
+		Due:         due,
+	}
+	return errgo.Mask(s.execOutbox(tmplClaimOutboxEntry, params), errgo.Is(store.ErrNotFound))
+}
+
+// RemoveOutboxEntry implements store.Store.RemoveOutboxEntry.
+func (s *identityStore) RemoveOutboxEntry(_ context.Context, id string) error {
+	params := &outboxParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         id,
+	}
+	return errgo.Mask(s.execOutbox(tmplRemoveOutboxEntry, params), errgo.Is(store.ErrNotFound))
+}
+
+// execOutbox executes the given template, which must affect the outbox
+// entry with the ID in params. If no entry is affected an error with a
+// cause of store.ErrNotFound is returned.
+func (s *identityStore) execOutbox(tmplID tmplID, params *outboxParams) error {
+	if _, err := strconv.ParseInt(params.ID, 10, 64); err != nil {
+		// The ID cannot have come from this store.
+		return store.OutboxEntryNotFoundError(params.ID)
+	}
+	res, err := s.driver.exec(s.db, tmplID, params)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if n == 0 {
+		return store.OutboxEntryNotFoundError(params.ID)
+	}
+	return nil
+}
@@ -1,11 +1,17 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
+	"github.com/juju/simplekv"
+	"github.com/juju/simplekv/sqlsimplekv"
 	"github.com/lib/pq"
 	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+	"gopkg.in/macaroon-bakery.v2/bakery/postgresrootkeystore"
 )
 
 const postgresInit = `
@@ -31,6 +37,26 @@ DO $$
     END;
 $$;
 
+DO $$ 
+    BEGIN
+        BEGIN
+            ALTER TABLE identities ADD COLUMN tokensrevoked TIMESTAMP WITH TIME ZONE;
+        EXCEPTION
+            WHEN duplicate_column THEN RETURN;
+        END;
+    END;
+$$;
+
+DO $$ 
+    BEGIN
+        BEGIN
+            ALTER TABLE identities ADD COLUMN suspended BOOLEAN DEFAULT FALSE;
+        EXCEPTION
+            WHEN duplicate_column THEN RETURN;
+        END;
+    END;
+$$;
+
 CREATE TABLE IF NOT EXISTS identity_groups ( 
 	identity INTEGER REFERENCES identities NOT NULL,
 	value TEXT NOT NULL,
@@ -57,6 +83,13 @@ CREATE TABLE IF NOT EXISTS identity_extrainfo (
 	UNIQUE (identity, key, value)
 );
 
+CREATE TABLE IF NOT EXISTS identity_groupexpiry ( 
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TIMESTAMP WITH TIME ZONE NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
 CREATE TABLE IF NOT EXISTS provider_data ( 
 	provider TEXT NOT NULL,
 	key TEXT NOT NULL,
@@ -85,19 +118,92 @@ CREATE TABLE IF NOT EXISTS meetings (
 	address TEXT NOT NULL,
 	created TIMESTAMP WITH TIME ZONE NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS audit_events ( 
+	id SERIAL PRIMARY KEY,
+	time TIMESTAMP WITH TIME ZONE NOT NULL,
+	type TEXT NOT NULL,
+	actor TEXT,
+	username TEXT,
+	event TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_time ON audit_events (time);
+CREATE INDEX IF NOT EXISTS audit_events_actor ON audit_events (actor, time);
+CREATE INDEX IF NOT EXISTS audit_events_username ON audit_events (username, time);
+
+CREATE TABLE IF NOT EXISTS groups (
+	id SERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS group_owners (
+	groupid INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (groupid, value)
+);
+
+CREATE TABLE IF NOT EXISTS group_membergroups (
+	groupid INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (groupid, value)
+);
+
+CREATE TABLE IF NOT EXISTS group_changes (
+	id SERIAL PRIMARY KEY,
+	time TIMESTAMP WITH TIME ZONE NOT NULL,
+	actor TEXT,
+	username TEXT NOT NULL,
+	membergroup TEXT,
+	groupname TEXT NOT NULL,
+	op TEXT NOT NULL,
+	until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS group_changes_username ON group_changes (username, time);
+CREATE INDEX IF NOT EXISTS group_changes_groupname ON group_changes (groupname, time);
+
+CREATE TABLE IF NOT EXISTS elevations (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	groupname TEXT NOT NULL,
+	reason TEXT,
+	duration BIGINT NOT NULL,
+	state TEXT NOT NULL,
+	requested TIMESTAMP WITH TIME ZONE NOT NULL,
+	approver TEXT,
+	decided TIMESTAMP WITH TIME ZONE,
+	expires TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS elevations_username ON elevations (username, state);
+CREATE INDEX IF NOT EXISTS elevations_state ON elevations (state, requested);
+
+CREATE TABLE IF NOT EXISTS outbox (
+	id SERIAL PRIMARY KEY,
+	webhook TEXT NOT NULL,
+	payload BYTEA NOT NULL,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+	attempts INTEGER NOT NULL,
+	nextattempt TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_nextattempt ON outbox (nextattempt);
 `
 
 var postgresTmpls = [numTmpl]string{
 	tmplIdentityFrom: `
-		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, tokensrevoked, suspended
 		FROM identities
 		WHERE {{.Column}}={{.Identity | .Arg}}`,
 	tmplSelectIdentitySet: `
 		SELECT {{if .Key}}key, {{end}}value FROM {{.Table}} 
 		WHERE identity={{.Identity | .Arg}}`,
 	tmplFindIdentities: `
-		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner FROM identities
-		{{if .Where}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}{{end}}
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, tokensrevoked, suspended FROM identities
+		{{if or .Where .Groups}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}
+		{{range $i, $g := .Groups}}{{if or $i $.Where}} AND{{end}} id IN (SELECT identity FROM identity_groups WHERE value={{$g | $.Arg}}){{end}}{{end}}
 		{{if .Sort}}ORDER BY {{join .Sort ", "}}{{end}}
 		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}
 		{{if gt .Skip 0}}OFFSET {{.Skip}}{{end}}`,
@@ -127,6 +233,9 @@ var postgresTmpls = [numTmpl]string{
 		DELETE FROM {{.Table}}
 		WHERE identity={{.ID | $.Arg}}{{if .Key}} AND key={{.Key | $.Arg}}{{end}}
 		AND value IN ({{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}{{$v | $.Arg}}{{end}})`,
+	tmplDeleteIdentity: `
+		DELETE FROM identities
+		WHERE id={{.ID | .Arg}}`,
 	tmplGetProviderData: `
 		SELECT value FROM provider_data
 		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > now())`,
@@ -154,6 +263,110 @@ var postgresTmpls = [numTmpl]string{
 	tmplIdentityCounts: `
 		SELECT substring(providerid, '^[^:]*') as idp, COUNT(1) 
 		FROM identities GROUP BY idp`,
+	tmplPutAuditEvent: `
+		INSERT INTO audit_events (time, type, actor, username, event)
+		VALUES ({{.Time | .Arg}}, {{.Type | .Arg}}, {{.Actor | .Arg}}, {{.User | .Arg}}, {{.Event | .Arg}})`,
+	tmplFindAuditEvents: `
+		SELECT event FROM audit_events
+		WHERE TRUE
+		{{if .Type}}AND type={{.Type | .Arg}}{{end}}
+		{{if .User}}AND (actor={{.User | .Arg}} OR username={{.User | .Arg}}){{end}}
+		{{if not .After.IsZero}}AND time>={{.After | .Arg}}{{end}}
+		{{if not .Before.IsZero}}AND time<{{.Before | .Arg}}{{end}}
+		ORDER BY time, id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplGroup: `
+		SELECT id, name, description FROM groups
+		WHERE name={{.Name | .Arg}}`,
+	tmplFindGroups: `
+		SELECT id, name, description FROM groups
+		ORDER BY name`,
+	tmplSelectGroupSet: `
+		SELECT value FROM {{.Table}}
+		WHERE groupid={{.ID | .Arg}}`,
+	tmplInsertGroup: `
+		INSERT INTO groups (name, description)
+		VALUES ({{.Name | .Arg}}, {{.Description | .Arg}})
+		RETURNING id`,
+	tmplUpdateGroup: `
+		UPDATE groups
+		SET description={{.Description | .Arg}}
+		WHERE name={{.Name | .Arg}}
+		RETURNING id`,
+	tmplGroupID: `
+		SELECT id FROM groups
+		WHERE name={{.Name | .Arg}}`,
+	tmplRemoveGroup: `
+		DELETE FROM groups
+		WHERE name={{.Name | .Arg}}`,
+	tmplClearGroupSet: `
+		DELETE FROM {{.Table}}
+		WHERE groupid={{.ID | .Arg}}`,
+	tmplPushGroupSet: `
+		INSERT INTO {{.Table}} (groupid, value)
+		VALUES {{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}({{$.ID | $.Arg}}, {{$v | $.Arg}}){{end}}
+		ON CONFLICT (groupid, value) DO NOTHING`,
+	tmplPullGroupSet: `
+		DELETE FROM {{.Table}}
+		WHERE groupid={{.ID | .Arg}}
+		AND value IN ({{range $i, $v := .Values}}{{if gt $i 0}}, {{end}}{{$v | $.Arg}}{{end}})`,
+	tmplPutGroupChange: `
+		INSERT INTO group_changes (time, actor, username, membergroup, groupname, op, until)
+		VALUES ({{.Time | .Arg}}, {{.Actor | .Arg}}, {{.Username | .Arg}}, {{.MemberGroup | .Arg}}, {{.Group | .Arg}}, {{.Op | .Arg}}, {{.Until | .Arg}})`,
+	tmplFindGroupChanges: `
+		SELECT time, actor, username, membergroup, groupname, op, until FROM group_changes
+		WHERE TRUE
+		{{if .Username}}AND username={{.Username | .Arg}}{{end}}
+		{{if .Group}}AND groupname={{.Group | .Arg}}{{end}}
+		{{if not .After.IsZero}}AND time>={{.After | .Arg}}{{end}}
+		{{if not .Before.IsZero}}AND time<{{.Before | .Arg}}{{end}}
+		ORDER BY time, id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplInsertElevation: `
+		INSERT INTO elevations (username, groupname, reason, duration, state, requested, approver, decided, expires)
+		VALUES ({{.Username | .Arg}}, {{.Group | .Arg}}, {{.Reason | .Arg}}, {{.Duration | .Arg}}, {{.State | .Arg}}, {{.Requested | .Arg}}, {{.Approver | .Arg}}, {{.Decided | .Arg}}, {{.Expires | .Arg}})
+		RETURNING id`,
+	tmplElevation: `
+		SELECT id, username, groupname, reason, duration, state, requested, approver, decided, expires FROM elevations
+		WHERE id={{.ID | .Arg}}
+		{{if .ForUpdate}}FOR UPDATE{{end}}`,
+	tmplFindElevations: `
+		SELECT id, username, groupname, reason, duration, state, requested, approver, decided, expires FROM elevations
+		WHERE TRUE
+		{{if .Username}}AND username={{.Username | .Arg}}{{end}}
+		{{if .Group}}AND groupname={{.Group | .Arg}}{{end}}
+		{{if .State}}AND state={{.State | .Arg}}{{end}}
+		ORDER BY requested, id`,
+	tmplUpdateElevation: `
+		UPDATE elevations
+		SET state={{.State | .Arg}}, approver={{.Approver | .Arg}}, decided={{.Decided | .Arg}}, expires={{.Expires | .Arg}}
+		WHERE id={{.ID | .Arg}}`,
+	tmplInsertOutboxEntry: `
+		INSERT INTO outbox (webhook, payload, created, attempts, nextattempt)
+		VALUES ({{.Webhook | .Arg}}, {{.Payload | .Arg}}, {{.Created | .Arg}}, {{.Attempts | .Arg}}, {{.NextAttempt | .Arg}})
+		RETURNING id`,
+	tmplFindOutboxEntries: `
+		SELECT id, webhook, payload, created, attempts, nextattempt FROM outbox
+		WHERE nextattempt<={{.NextAttempt | .Arg}}
+		ORDER BY id
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{end}}`,
+	tmplUpdateOutboxEntry: `
+		UPDATE outbox
+		SET attempts={{.Attempts | .Arg}}, nextattempt={{.NextAttempt | .Arg}}
+		WHERE id={{.ID | .Arg}}`,
+	tmplClaimOutboxEntry: `
+		UPDATE outbox
+		SET nextattempt={{.NextAttempt | .Arg}}
+		WHERE id={{.ID | .Arg}} AND nextattempt<={{.Due | .Arg}}`,
+	tmplRemoveOutboxEntry: `
+		DELETE FROM outbox
+		WHERE id={{.ID | .Arg}}`,
+	tmplFindKVTables: `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema=current_schema() AND table_name LIKE 'idpkv\_%'`,
+	tmplFindKVData: `
+		SELECT key, value, expire FROM "{{.Table}}"
+		WHERE expire IS NULL OR expire > now()`,
 }
 
 // newPostgresDriver creates a postgres driver using the given DB.
@@ -168,6 +381,15 @@ func newPostgresDriver(db *sql.DB) (*driver, error) {
 			return &postgresArgBuilder{}
 		},
 		isDuplicateFunc: postgresIsDuplicate,
+		newKVStore: func(db *sql.DB, table string) (simplekv.Store, error) {
+			return sqlsimplekv.NewStore("postgres", db, table)
+		},
+		newRootKeys: func(db *sql.DB) rootKeys {
+			return postgresRootKeys{
+				RootKeys: postgresrootkeystore.NewRootKeys(db, "rootkeys", 1000),
+				db:       db,
+			}
+		},
 	}
 	for i, t := range postgresTmpls {
 		if err := d.parseTemplate(tmplID(i), t); err != nil {
@@ -177,6 +399,58 @@ func newPostgresDriver(db *sql.DB) (*driver, error) {
 	return d, nil
 }
 
+// postgresRootKeys implements rootKeys using a postgres table.
+type postgresRootKeys struct {
+	*postgresrootkeystore.RootKeys
+	db *sql.DB
+}
+
+// NewStore implements rootKeys.NewStore.
+func (k postgresRootKeys) NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore {
+	return k.RootKeys.NewStore(postgresrootkeystore.Policy(policy))
+}
+
+// findKeys implements rootKeys.findKeys.
+func (k postgresRootKeys) findKeys() ([]dbrootkeystore.RootKey, error) {
+	rows, err := k.db.Query(`
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE expires > now()
+		ORDER BY created`)
+	if err != nil {
+		if pqerr, ok := err.(*pq.Error); ok && pqerr.Code.Name() == "undefined_table" {
+			// The table is only created when the root key
+			// store is first used, so there are no keys.
+			return nil, nil
+		}
+		return nil, errgo.Mask(err)
+	}
+	keys, err := scanRootKeys(rows)
+	return keys, errgo.Mask(err)
+}
+
+// addKey implements rootKeys.addKey.
+func (k postgresRootKeys) addKey(key dbrootkeystore.RootKey) error {
+	// Looking the key up through the root key store makes sure that
+	// the table has been created.
+	_, err := k.NewStore(dbrootkeystore.Policy{}).Get(context.Background(), key.Id)
+	if err == nil {
+		return nil
+	}
+	if errgo.Cause(err) != bakery.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	_, err = k.db.Exec(`
+		INSERT INTO rootkeys (id, rootkey, created, expires)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING`,
+		key.Id,
+		key.RootKey,
+		key.Created,
+		key.Expires,
+	)
+	return errgo.Mask(err)
+}
+
 func postgresIsDuplicate(err error) bool {
 	if pqerr, ok := err.(*pq.Error); ok && pqerr.Code.Name() == "unique_violation" {
 		return true
@@ -10,6 +10,7 @@ import (
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 
+	"github.com/canonical/candid/audit"
 	"github.com/canonical/candid/meeting"
 	"github.com/canonical/candid/store"
 	"github.com/canonical/candid/store/sqlstore"
@@ -43,6 +44,15 @@ func TestMeetingStore(t *testing.T) {
 	}, sqlstore.PutAtTime)
 }
 
+func TestAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) audit.Store {
+		return newFixture(c).backend.AuditStore()
+	})
+}
+
 func TestACLStore(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -52,6 +62,15 @@ func TestACLStore(t *testing.T) {
 	})
 }
 
+func TestRawBackend(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestRawBackend(c, func(c *qt.C) store.RawBackend {
+		return newFixture(c).backend
+	})
+}
+
 func TestUpdateIDNotFound(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -171,7 +190,7 @@ func TestInitIdempotent(t *testing.T) {
 }
 
 type fixture struct {
-	backend store.Backend
+	backend store.RawBackend
 	pg      *postgrestest.DB
 }
 
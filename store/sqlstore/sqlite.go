@@ -0,0 +1,312 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+)
+
+// sqliteNow is an SQLite expression for the current time in the same
+// format that is used to store time values. All times are stored in
+// UTC so that they can be compared as strings.
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')`
+
+const sqliteInit = `
+CREATE TABLE IF NOT EXISTS identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	providerid TEXT UNIQUE NOT NULL,
+	username TEXT UNIQUE NOT NULL,
+	name TEXT,
+	email TEXT,
+	lastlogin TIMESTAMP,
+	lastdischarge TIMESTAMP,
+	owner TEXT,
+	tokensrevoked TIMESTAMP,
+	suspended BOOLEAN DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS identity_groups (
+	identity INTEGER REFERENCES identities NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_publickeys (
+	identity INTEGER REFERENCES identities NOT NULL,
+	value BLOB NOT NULL,
+	UNIQUE (identity, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_providerinfo (
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_extrainfo (
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
+CREATE TABLE IF NOT EXISTS identity_groupexpiry (
+	identity INTEGER REFERENCES identities NOT NULL,
+	key TEXT NOT NULL,
+	value TIMESTAMP NOT NULL,
+	UNIQUE (identity, key, value)
+);
+
+CREATE TABLE IF NOT EXISTS provider_data (
+	provider TEXT NOT NULL,
+	key TEXT NOT NULL,
+	value BLOB NOT NULL,
+	expire TIMESTAMP,
+	UNIQUE (provider, key)
+);
+
+CREATE INDEX IF NOT EXISTS provider_data_expire ON provider_data (expire);
+CREATE TRIGGER IF NOT EXISTS provider_data_expire_tr
+	BEFORE INSERT ON provider_data
+	BEGIN
+		DELETE FROM provider_data WHERE expire < ` + sqliteNow + `;
+	END;
+
+CREATE TABLE IF NOT EXISTS meetings (
+	id TEXT NOT NULL PRIMARY KEY,
+	address TEXT NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TIMESTAMP NOT NULL,
+	type TEXT NOT NULL,
+	actor TEXT,
+	username TEXT,
+	event TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_time ON audit_events (time);
+CREATE INDEX IF NOT EXISTS audit_events_actor ON audit_events (actor, time);
+CREATE INDEX IF NOT EXISTS audit_events_username ON audit_events (username, time);
+
+CREATE TABLE IF NOT EXISTS groups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS group_owners (
+	groupid INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (groupid, value)
+);
+
+CREATE TABLE IF NOT EXISTS group_membergroups (
+	groupid INTEGER REFERENCES groups ON DELETE CASCADE NOT NULL,
+	value TEXT NOT NULL,
+	UNIQUE (groupid, value)
+);
+
+CREATE TABLE IF NOT EXISTS group_changes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TIMESTAMP NOT NULL,
+	actor TEXT,
+	username TEXT NOT NULL,
+	membergroup TEXT,
+	groupname TEXT NOT NULL,
+	op TEXT NOT NULL,
+	until TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS group_changes_username ON group_changes (username, time);
+CREATE INDEX IF NOT EXISTS group_changes_groupname ON group_changes (groupname, time);
+
+CREATE TABLE IF NOT EXISTS elevations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	groupname TEXT NOT NULL,
+	reason TEXT,
+	duration BIGINT NOT NULL,
+	state TEXT NOT NULL,
+	requested TIMESTAMP NOT NULL,
+	approver TEXT,
+	decided TIMESTAMP,
+	expires TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS elevations_username ON elevations (username, state);
+CREATE INDEX IF NOT EXISTS elevations_state ON elevations (state, requested);
+
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook TEXT NOT NULL,
+	payload BLOB NOT NULL,
+	created TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL,
+	nextattempt TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_nextattempt ON outbox (nextattempt);
+
+CREATE TABLE IF NOT EXISTS rootkeys (
+	id BLOB PRIMARY KEY NOT NULL,
+	rootkey BLOB,
+	created TIMESTAMP NOT NULL,
+	expires TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rootkeys_created ON rootkeys (created);
+CREATE INDEX IF NOT EXISTS rootkeys_expires ON rootkeys (expires);
+CREATE TRIGGER IF NOT EXISTS rootkeys_expire_tr
+	BEFORE INSERT ON rootkeys
+	BEGIN
+		DELETE FROM rootkeys WHERE expires < ` + sqliteNow + `;
+	END;
+`
+
+// sqliteTmpls holds the SQLite versions of the queries. Where the
+// dialects agree these are the same as the postgres queries. SQLite has
+// no "FOR UPDATE", instead the database is expected to be opened so
+// that every transaction takes the write lock when it starts (see
+// SQLiteParams.NewBackend).
+var sqliteTmpls = [numTmpl]string{
+	tmplIdentityFrom:      postgresTmpls[tmplIdentityFrom],
+	tmplSelectIdentitySet: postgresTmpls[tmplSelectIdentitySet],
+	tmplFindIdentities: `
+		SELECT id, providerid, username, name, email, lastlogin, lastdischarge, owner, tokensrevoked, suspended FROM identities
+		{{if or .Where .Groups}}WHERE{{range $i, $w := .Where}}{{if gt $i 0}} AND{{end}} {{$w.Column}}{{$w.Comparison}}{{$w.Value | $.Arg}}{{end}}
+		{{range $i, $g := .Groups}}{{if or $i $.Where}} AND{{end}} id IN (SELECT identity FROM identity_groups WHERE value={{$g | $.Arg}}){{end}}{{end}}
+		{{if .Sort}}ORDER BY {{join .Sort ", "}}{{end}}
+		{{if gt .Limit 0}}LIMIT {{.Limit}}{{else if gt .Skip 0}}LIMIT -1{{end}}
+		{{if gt .Skip 0}}OFFSET {{.Skip}}{{end}}`,
+	tmplUpdateIdentity:   postgresTmpls[tmplUpdateIdentity],
+	tmplIdentityID:       postgresTmpls[tmplIdentityID],
+	tmplUpsertIdentity:   postgresTmpls[tmplUpsertIdentity],
+	tmplClearIdentitySet: postgresTmpls[tmplClearIdentitySet],
+	tmplPushIdentitySet:  postgresTmpls[tmplPushIdentitySet],
+	tmplPullIdentitySet:  postgresTmpls[tmplPullIdentitySet],
+	tmplDeleteIdentity:   postgresTmpls[tmplDeleteIdentity],
+	tmplGetProviderData: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > ` + sqliteNow + `)`,
+	tmplGetProviderDataForUpdate: `
+		SELECT value FROM provider_data
+		WHERE provider={{.Provider | .Arg}} AND key={{.Key | .Arg}} AND (expire IS NULL OR expire > ` + sqliteNow + `)`,
+	tmplInsertProviderData: postgresTmpls[tmplInsertProviderData],
+	tmplGetMeeting:         postgresTmpls[tmplGetMeeting],
+	tmplPutMeeting:         postgresTmpls[tmplPutMeeting],
+	tmplFindMeetings:       postgresTmpls[tmplFindMeetings],
+	tmplRemoveMeetings:     postgresTmpls[tmplRemoveMeetings],
+	tmplIdentityCounts: `
+		SELECT substr(providerid, 1, instr(providerid || ':', ':') - 1) AS idp, COUNT(1)
+		FROM identities GROUP BY idp`,
+	tmplPutAuditEvent:    postgresTmpls[tmplPutAuditEvent],
+	tmplFindAuditEvents:  postgresTmpls[tmplFindAuditEvents],
+	tmplGroup:            postgresTmpls[tmplGroup],
+	tmplFindGroups:       postgresTmpls[tmplFindGroups],
+	tmplSelectGroupSet:   postgresTmpls[tmplSelectGroupSet],
+	tmplInsertGroup:      postgresTmpls[tmplInsertGroup],
+	tmplUpdateGroup:      postgresTmpls[tmplUpdateGroup],
+	tmplGroupID:          postgresTmpls[tmplGroupID],
+	tmplRemoveGroup:      postgresTmpls[tmplRemoveGroup],
+	tmplClearGroupSet:    postgresTmpls[tmplClearGroupSet],
+	tmplPushGroupSet:     postgresTmpls[tmplPushGroupSet],
+	tmplPullGroupSet:     postgresTmpls[tmplPullGroupSet],
+	tmplPutGroupChange:   postgresTmpls[tmplPutGroupChange],
+	tmplFindGroupChanges: postgresTmpls[tmplFindGroupChanges],
+	tmplInsertElevation:  postgresTmpls[tmplInsertElevation],
+	tmplElevation: `
+		SELECT id, username, groupname, reason, duration, state, requested, approver, decided, expires FROM elevations
+		WHERE id={{.ID | .Arg}}`,
+	tmplFindElevations:    postgresTmpls[tmplFindElevations],
+	tmplUpdateElevation:   postgresTmpls[tmplUpdateElevation],
+	tmplInsertOutboxEntry: postgresTmpls[tmplInsertOutboxEntry],
+	tmplFindOutboxEntries: postgresTmpls[tmplFindOutboxEntries],
+	tmplUpdateOutboxEntry: postgresTmpls[tmplUpdateOutboxEntry],
+	tmplClaimOutboxEntry:  postgresTmpls[tmplClaimOutboxEntry],
+	tmplRemoveOutboxEntry: postgresTmpls[tmplRemoveOutboxEntry],
+	tmplFindKVTables: `
+		SELECT name FROM sqlite_master
+		WHERE type='table' AND name LIKE 'idpkv\_%' ESCAPE '\'`,
+	tmplFindKVData: `
+		SELECT key, value, expire FROM "{{.Table}}"
+		WHERE expire IS NULL OR expire > ` + sqliteNow,
+}
+
+// newSQLiteDriver creates an SQLite driver using the given DB.
+func newSQLiteDriver(db *sql.DB) (*driver, error) {
+	_, err := db.Exec(sqliteInit)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	d := &driver{
+		name: "sqlite3",
+		argBuilderFunc: func() argBuilder {
+			return &sqliteArgBuilder{}
+		},
+		isDuplicateFunc: sqliteIsDuplicate,
+		newKVStore:      newSQLiteKVStore,
+		newRootKeys: func(db *sql.DB) rootKeys {
+			return sqliteRootKeys{
+				keys: dbrootkeystore.NewRootKeys(1000, nil),
+				db:   db,
+			}
+		},
+	}
+	for i, t := range sqliteTmpls {
+		if err := d.parseTemplate(tmplID(i), t); err != nil {
+			return nil, errgo.Notef(err, "cannot parse template %v", t)
+		}
+	}
+	return d, nil
+}
+
+func sqliteIsDuplicate(err error) bool {
+	if sqliteErr, ok := errgo.Cause(err).(sqlite3.Error); ok {
+		switch sqliteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return true
+		}
+	}
+	return false
+}
+
+// sqliteArgBuilder implements an argBuilder that produces placeholders
+// in the the "?n" format. Time values are converted to UTC so that
+// they compare correctly when stored as text.
+type sqliteArgBuilder struct {
+	args_ []interface{}
+}
+
+// Arg implements argbuilder.Arg.
+func (b *sqliteArgBuilder) Arg(a interface{}) string {
+	b.args_ = append(b.args_, sqliteValue(a))
+	return fmt.Sprintf("?%d", len(b.args_))
+}
+
+// args implements argbuilder.args.
+func (b *sqliteArgBuilder) args() []interface{} {
+	return b.args_
+}
+
+// sqliteValue converts any time value in v to UTC.
+func sqliteValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC()
+	case nullTime:
+		v.Time = v.Time.UTC()
+		return v
+	}
+	return v
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	aclstore "github.com/juju/aclstore/v2"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+
+	"github.com/canonical/candid/audit"
+	"github.com/canonical/candid/meeting"
+	"github.com/canonical/candid/store"
+	"github.com/canonical/candid/store/sqlstore"
+	"github.com/canonical/candid/store/storetest"
+)
+
+func TestSQLiteKeyValueStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestKeyValueStore(c, func(c *qt.C) store.ProviderDataStore {
+		return newSQLiteBackend(c, "").ProviderDataStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestStore(c, func(c *qt.C) store.Store {
+		return newSQLiteBackend(c, "").Store()
+	})
+}
+
+func TestSQLiteMeetingStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestMeetingStore(c, func(c *qt.C) meeting.Store {
+		return newSQLiteBackend(c, "").MeetingStore()
+	}, sqlstore.PutAtTime)
+}
+
+func TestSQLiteAuditStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestAuditStore(c, func(c *qt.C) audit.Store {
+		return newSQLiteBackend(c, "").AuditStore()
+	})
+}
+
+func TestSQLiteACLStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestACLStore(c, func(c *qt.C) aclstore.ACLStore {
+		return newSQLiteBackend(c, "").ACLStore()
+	})
+}
+
+func TestSQLiteRawBackend(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestRawBackend(c, func(c *qt.C) store.RawBackend {
+		return newSQLiteBackend(c, "").(store.RawBackend)
+	})
+}
+
+func TestSQLiteConfigUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	storetest.TestUnmarshal(c, `
+storage:
+    type: sqlite
+    path: '`+filepath.Join(c.Mkdir(), "candid.db")+`'
+`)
+}
+
+func TestSQLiteBakeryRootKeyStore(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	path := filepath.Join(c.Mkdir(), "candid.db")
+	ctx := context.Background()
+	key, id, err := newSQLiteBackend(c, path).BakeryRootKeyStore().RootKey(ctx)
+	c.Assert(err, qt.IsNil)
+
+	// A new backend using the same database can find the key.
+	key1, err := newSQLiteBackend(c, path).BakeryRootKeyStore().Get(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(key1, qt.DeepEquals, key)
+
+	_, err = newSQLiteBackend(c, path).BakeryRootKeyStore().Get(ctx, []byte("no-such-key"))
+	c.Assert(err, qt.Equals, bakery.ErrNotFound)
+}
+
+func TestSQLiteInitIdempotent(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	path := filepath.Join(c.Mkdir(), "candid.db")
+	id1 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+		Username:   "test-1",
+		Name:       "Test User",
+		Email:      "test-1@example.com",
+		Groups:     []string{"g1", "g2"},
+		ProviderInfo: map[string][]string{
+			"pk1": {"pk1v1", "pk1v2"},
+		},
+		ExtraInfo: map[string][]string{
+			"ek1": {"ek1v1", "ek1v2"},
+		},
+		Owner: store.MakeProviderIdentity("test", "test-0"),
+	}
+	err := newSQLiteBackend(c, path).Store().UpdateIdentity(
+		context.Background(),
+		&id1,
+		store.Update{
+			store.Username:     store.Set,
+			store.Name:         store.Set,
+			store.Email:        store.Set,
+			store.Groups:       store.Set,
+			store.ProviderInfo: store.Set,
+			store.ExtraInfo:    store.Set,
+			store.Owner:        store.Set,
+		},
+	)
+	c.Assert(err, qt.IsNil)
+
+	id2 := store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "test-1"),
+	}
+	err = newSQLiteBackend(c, path).Store().Identity(context.Background(), &id2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id2, qt.DeepEquals, id1)
+}
+
+func TestSQLiteDebugStatus(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	b := newSQLiteBackend(c, "")
+	err := sqlstore.PutAtTime(context.Background(), b.MeetingStore(), "id", "addr", time.Now())
+	c.Assert(err, qt.IsNil)
+
+	results := make(map[string]string)
+	for _, f := range b.DebugStatusCheckerFuncs() {
+		key, result := f(context.Background())
+		c.Check(result.Passed, qt.Equals, true, qt.Commentf("%s", key))
+		results[key] = result.Value
+	}
+	c.Assert(results, qt.DeepEquals, map[string]string{
+		"database_connected": "Connected",
+		"meeting_count":      "1",
+	})
+}
+
+// newSQLiteBackend returns a new backend using the SQLite database at
+// the given path. If path is empty a new database is created.
+func newSQLiteBackend(c *qt.C, path string) store.Backend {
+	if path == "" {
+		path = filepath.Join(c.Mkdir(), "candid.db")
+	}
+	backend, err := sqlstore.SQLiteParams{Path: path}.NewBackend()
+	c.Assert(err, qt.IsNil)
+	c.Defer(backend.Close)
+	return backend
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/juju/simplekv"
+	errgo "gopkg.in/errgo.v1"
+	"gopkg.in/macaroon-bakery.v2/bakery"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+)
+
+var sqliteKVInitTmpl = template.Must(template.New("").Parse(`
+CREATE TABLE IF NOT EXISTS "{{.}}" (
+	key TEXT NOT NULL PRIMARY KEY,
+	value BLOB NOT NULL,
+	expire TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "{{.}}_expire" ON "{{.}}" (expire);
+CREATE TRIGGER IF NOT EXISTS "{{.}}_expire_tr"
+	BEFORE INSERT ON "{{.}}"
+	BEGIN
+		DELETE FROM "{{.}}" WHERE expire < ` + sqliteNow + `;
+	END;
+`))
+
+// newSQLiteKVStore returns a simplekv.Store that stores its values in
+// the given table of an SQLite database. The table is created if it
+// does not already exist.
+func newSQLiteKVStore(db *sql.DB, table string) (simplekv.Store, error) {
+	buf := new(bytes.Buffer)
+	if err := sqliteKVInitTmpl.Execute(buf, table); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if _, err := db.Exec(buf.String()); err != nil {
+		return nil, errgo.Notef(err, "cannot initialise database")
+	}
+	return &sqliteKVStore{
+		db:    db,
+		table: table,
+	}, nil
+}
+
+// An sqliteKVStore implements simplekv.Store using an SQLite table.
+type sqliteKVStore struct {
+	db    *sql.DB
+	table string
+}
+
+// Context implements simplekv.Store.Context.
+func (s *sqliteKVStore) Context(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+// Get implements simplekv.Store.Get.
+func (s *sqliteKVStore) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := s.get(s.db, key)
+	return v, errgo.Mask(err, errgo.Is(simplekv.ErrNotFound))
+}
+
+func (s *sqliteKVStore) get(q queryer, key string) ([]byte, error) {
+	var value []byte
+	err := q.QueryRow(
+		fmt.Sprintf(`SELECT value FROM "%s" WHERE key=?1 AND (expire IS NULL OR expire > %s)`, s.table, sqliteNow),
+		key,
+	).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, simplekv.KeyNotFoundError(key)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return value, nil
+}
+
+// Set implements simplekv.Store.Set.
+func (s *sqliteKVStore) Set(ctx context.Context, key string, value []byte, expire time.Time) error {
+	return errgo.Mask(s.set(s.db, key, value, expire))
+}
+
+// set upserts the given key and value.
+func (s *sqliteKVStore) set(q queryer, key string, value []byte, expire time.Time) error {
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := q.Exec(
+		fmt.Sprintf(`INSERT INTO "%s" (key, value, expire) VALUES (?1, ?2, ?3) ON CONFLICT (key) DO UPDATE SET value=?2, expire=?3`, s.table),
+		key,
+		value,
+		sqliteValue(nullTime{expire, !expire.IsZero()}),
+	)
+	return errgo.Mask(err)
+}
+
+// Update implements simplekv.Store.Update.
+func (s *sqliteKVStore) Update(ctx context.Context, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.update(tx, key, expire, getVal); err != nil {
+		if err := tx.Rollback(); err != nil {
+			logger.Errorf("failed to rollback transaction: %s", err)
+		}
+		return errgo.Mask(err, errgo.Any)
+	}
+	return errgo.Mask(tx.Commit())
+}
+
+func (s *sqliteKVStore) update(tx *sql.Tx, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	old, err := s.get(tx, key)
+	if err != nil && errgo.Cause(err) != simplekv.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	if err == nil && old == nil {
+		old = []byte{}
+	}
+	v, err := getVal(old)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	return errgo.Mask(s.set(tx, key, v, expire))
+}
+
+// sqliteRootKeys implements rootKeys using the rootkeys table of an
+// SQLite database.
+type sqliteRootKeys struct {
+	keys *dbrootkeystore.RootKeys
+	db   *sql.DB
+}
+
+// NewStore implements rootKeys.NewStore.
+func (k sqliteRootKeys) NewStore(policy dbrootkeystore.Policy) bakery.RootKeyStore {
+	return k.keys.NewStore(sqliteRootKeyBacking{k.db}, policy)
+}
+
+// Close implements rootKeys.Close.
+func (k sqliteRootKeys) Close() error {
+	return nil
+}
+
+// findKeys implements rootKeys.findKeys.
+func (k sqliteRootKeys) findKeys() ([]dbrootkeystore.RootKey, error) {
+	rows, err := k.db.Query(`
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE expires > ` + sqliteNow + `
+		ORDER BY created`)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	keys, err := scanRootKeys(rows)
+	return keys, errgo.Mask(err)
+}
+
+// addKey implements rootKeys.addKey.
+func (k sqliteRootKeys) addKey(key dbrootkeystore.RootKey) error {
+	_, err := k.db.Exec(`
+		INSERT INTO rootkeys (id, rootkey, created, expires)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (id) DO NOTHING`,
+		key.Id,
+		key.RootKey,
+		key.Created.UTC(),
+		key.Expires.UTC(),
+	)
+	return errgo.Mask(err)
+}
+
+// sqliteRootKeyBacking implements dbrootkeystore.Backing using the
+// rootkeys table of an SQLite database.
+type sqliteRootKeyBacking struct {
+	db *sql.DB
+}
+
+// GetKey implements dbrootkeystore.Backing.GetKey.
+func (b sqliteRootKeyBacking) GetKey(id []byte) (dbrootkeystore.RootKey, error) {
+	var key dbrootkeystore.RootKey
+	err := b.db.QueryRow(`
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE id=?1`,
+		id,
+	).Scan(&key.Id, &key.Created, &key.Expires, &key.RootKey)
+	switch {
+	case err == sql.ErrNoRows:
+		return dbrootkeystore.RootKey{}, bakery.ErrNotFound
+	case err != nil:
+		return dbrootkeystore.RootKey{}, errgo.Mask(err)
+	}
+	return key, nil
+}
+
+// FindLatestKey implements dbrootkeystore.Backing.FindLatestKey.
+func (b sqliteRootKeyBacking) FindLatestKey(createdAfter, expiresAfter, expiresBefore time.Time) (dbrootkeystore.RootKey, error) {
+	var key dbrootkeystore.RootKey
+	err := b.db.QueryRow(`
+		SELECT id, created, expires, rootkey FROM rootkeys
+		WHERE created >= ?1 AND expires >= ?2 AND expires <= ?3
+		ORDER BY created DESC`,
+		createdAfter.UTC(),
+		expiresAfter.UTC(),
+		expiresBefore.UTC(),
+	).Scan(&key.Id, &key.Created, &key.Expires, &key.RootKey)
+	if err == sql.ErrNoRows || err == nil {
+		return key, nil
+	}
+	return dbrootkeystore.RootKey{}, errgo.Mask(err)
+}
+
+// InsertKey implements dbrootkeystore.Backing.InsertKey.
+func (b sqliteRootKeyBacking) InsertKey(key dbrootkeystore.RootKey) error {
+	_, err := b.db.Exec(`
+		INSERT INTO rootkeys (id, rootkey, created, expires)
+		VALUES (?1, ?2, ?3, ?4)`,
+		key.Id,
+		key.RootKey,
+		key.Created.UTC(),
+		key.Expires.UTC(),
+	)
+	return errgo.Mask(err)
+}
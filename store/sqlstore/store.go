@@ -24,6 +24,8 @@ var identityColumns = [store.NumFields]string{
 	store.LastLogin:     "lastlogin",
 	store.LastDischarge: "lastdischarge",
 	store.Owner:         "owner",
+	store.TokensRevoked: "tokensrevoked",
+	store.Suspended:     "suspended",
 }
 
 type identityStore struct {
@@ -105,14 +107,19 @@ type where struct {
 
 type findIdentitiesParams struct {
 	argBuilder
-	Where []where
-	Sort  []string
-	Limit int
-	Skip  int
+	Where  []where
+	Groups []string
+	Sort   []string
+	Limit  int
+	Skip   int
 }
 
 func (s *identityStore) findIdentities(tx *sql.Tx, ref *store.Identity, filter store.Filter, sort []store.Sort, skip, limit int) ([]store.Identity, error) {
 	var wheres []where
+	var groups []string
+	if filter[store.Groups] == store.Equal {
+		groups = ref.Groups
+	}
 	for f, op := range filter {
 		col := identityColumns[f]
 		cond := comparisons[op]
@@ -138,6 +145,7 @@ func (s *identityStore) findIdentities(tx *sql.Tx, ref *store.Identity, filter s
 	params := &findIdentitiesParams{
 		argBuilder: s.driver.argBuilderFunc(),
 		Where:      wheres,
+		Groups:     groups,
 		Sort:       sorts,
 		Limit:      limit,
 		Skip:       skip,
@@ -182,6 +190,10 @@ func fieldValue(f store.Field, id *store.Identity) interface{} {
 		return nullTime{id.LastDischarge, !id.LastDischarge.IsZero()}
 	case store.Owner:
 		return sql.NullString{string(id.Owner), id.Owner != ""}
+	case store.TokensRevoked:
+		return nullTime{id.TokensRevoked, !id.TokensRevoked.IsZero()}
+	case store.Suspended:
+		return sql.NullBool{id.Suspended, true}
 	}
 	return nil
 }
@@ -204,6 +216,10 @@ func (s *identityStore) completeIdentity(tx *sql.Tx, identity *store.Identity) e
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	identity.GroupExpiry, err = s.getGroupExpiry(tx, identity.ID)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	return nil
 }
 
@@ -287,10 +303,37 @@ func (s *identityStore) getInfoMap(tx *sql.Tx, table string, id string) (map[str
 	return info, errgo.Mask(rows.Err())
 }
 
+func (s *identityStore) getGroupExpiry(tx *sql.Tx, id string) (map[string]time.Time, error) {
+	params := selectIdentitySetParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		Table:      "identity_groupexpiry",
+		Identity:   id,
+		Key:        true,
+	}
+	rows, err := s.driver.query(tx, tmplSelectIdentitySet, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer rows.Close()
+	var expiry map[string]time.Time
+	for rows.Next() {
+		var k string
+		var t time.Time
+		if err := rows.Scan(&k, &t); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if expiry == nil {
+			expiry = make(map[string]time.Time)
+		}
+		expiry[k] = t
+	}
+	return expiry, errgo.Mask(rows.Err())
+}
+
 // UpdateIdentity implements store.Store.UpdateIdentity.
-func (s *identityStore) UpdateIdentity(_ context.Context, identity *store.Identity, update store.Update) (err error) {
+func (s *identityStore) UpdateIdentity(ctx context.Context, identity *store.Identity, update store.Update) (err error) {
 	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
-		return s.updateIdentity(tx, identity, update)
+		return s.updateIdentity(ctx, tx, identity, update)
 	}), errgo.Is(store.ErrDuplicateUsername), errgo.Is(store.ErrNotFound))
 }
 
@@ -317,7 +360,7 @@ type updateIdentityParams struct {
 	Updates []update
 }
 
-func (s *identityStore) updateIdentity(tx *sql.Tx, identity *store.Identity, upd store.Update) error {
+func (s *identityStore) updateIdentity(ctx context.Context, tx *sql.Tx, identity *store.Identity, upd store.Update) error {
 	tmpl := tmplUpdateIdentity
 	params := updateIdentityParams{
 		argBuilder: s.driver.argBuilderFunc(),
@@ -380,9 +423,22 @@ func (s *identityStore) updateIdentity(tx *sql.Tx, identity *store.Identity, upd
 		return errgo.Notef(err, "cannot update identity")
 	}
 
+	actor, recordGroups := store.GroupChangeActor(ctx)
+	recordGroups = recordGroups && upd[store.Groups] != store.NoUpdate
+	var oldGroups []string
+	if recordGroups {
+		if oldGroups, err = s.getGroups(tx, identity.ID); err != nil {
+			return errgo.Notef(err, "cannot update identity")
+		}
+	}
 	if err := s.updateGroups(tx, identity.ID, upd[store.Groups], identity.Groups); err != nil {
 		return errgo.Notef(err, "cannot update identity")
 	}
+	if recordGroups {
+		if err := s.recordGroupChanges(tx, actor, identity.ID, oldGroups); err != nil {
+			return errgo.Notef(err, "cannot update identity")
+		}
+	}
 	if err := s.updatePublicKeys(tx, identity.ID, upd[store.PublicKeys], identity.PublicKeys); err != nil {
 		return errgo.Notef(err, "cannot update identity")
 	}
@@ -396,6 +452,11 @@ func (s *identityStore) updateIdentity(tx *sql.Tx, identity *store.Identity, upd
 			return errgo.Notef(err, "cannot update identity")
 		}
 	}
+	for k, t := range identity.GroupExpiry {
+		if err := s.updateGroupExpiry(tx, identity.ID, k, upd[store.GroupExpiry], t); err != nil {
+			return errgo.Notef(err, "cannot update identity")
+		}
+	}
 
 	return nil
 }
@@ -481,6 +542,14 @@ func (s *identityStore) updateExtraInfo(tx *sql.Tx, id, key string, op store.Ope
 	return errgo.Mask(s.updateSet(tx, "identity_extrainfo", id, key, op, vals))
 }
 
+func (s *identityStore) updateGroupExpiry(tx *sql.Tx, id, group string, op store.Operation, t time.Time) error {
+	var vals []interface{}
+	if op == store.Set {
+		vals = []interface{}{t}
+	}
+	return errgo.Mask(s.updateSet(tx, "identity_groupexpiry", id, group, op, vals))
+}
+
 // IdentityCounts implements store.IdentityCounts.
 func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, error) {
 	counts := make(map[string]int)
@@ -500,6 +569,91 @@ func (s *identityStore) IdentityCounts(ctx context.Context) (map[string]int, err
 	return counts, errgo.Mask(rows.Err())
 }
 
+// identitySetTables contains the tables that hold the multi-valued
+// fields of an identity.
+var identitySetTables = []string{
+	"identity_groups",
+	"identity_publickeys",
+	"identity_providerinfo",
+	"identity_extrainfo",
+	"identity_groupexpiry",
+}
+
+type deleteIdentityParams struct {
+	argBuilder
+	ID string
+}
+
+// DeleteIdentity implements store.DeleteIdentity.
+func (s *identityStore) DeleteIdentity(ctx context.Context, identity *store.Identity) error {
+	return errgo.Mask(s.withTx(func(tx *sql.Tx) error {
+		return s.deleteIdentity(ctx, tx, identity)
+	}), errgo.Is(store.ErrNotFound))
+}
+
+func (s *identityStore) deleteIdentity(ctx context.Context, tx *sql.Tx, identity *store.Identity) error {
+	params := updateIdentityParams{
+		argBuilder: s.driver.argBuilderFunc(),
+	}
+	switch {
+	case identity.ID != "":
+		if _, err := strconv.Atoi(identity.ID); err != nil {
+			// By definition if id isn't numeric it won't exist.
+			return store.NotFoundError(identity.ID, "", "")
+		}
+		params.Column = "id"
+		params.Identity = identity.ID
+	case identity.ProviderID != "":
+		params.Column = "providerid"
+		params.Identity = string(identity.ProviderID)
+	case identity.Username != "":
+		params.Column = "username"
+		params.Identity = identity.Username
+	default:
+		return store.NotFoundError("", "", "")
+	}
+	row, err := s.driver.queryRow(tx, tmplIdentityID, params)
+	if err != nil {
+		return errgo.Notef(err, "cannot delete identity")
+	}
+	var id string
+	if err := row.Scan(&id); err != nil {
+		if errgo.Cause(err) == sql.ErrNoRows {
+			return store.NotFoundError(identity.ID, identity.ProviderID, identity.Username)
+		}
+		return errgo.Notef(err, "cannot delete identity")
+	}
+	if actor, ok := store.GroupChangeActor(ctx); ok {
+		deleted := store.Identity{
+			ID: id,
+		}
+		if err := s.identity(tx, &deleted); err != nil {
+			return errgo.Notef(err, "cannot delete identity")
+		}
+		changes := store.DiffGroups(time.Now(), actor, deleted.Username, deleted.Groups, nil)
+		if err := s.addGroupChanges(tx, changes); err != nil {
+			return errgo.Notef(err, "cannot delete identity")
+		}
+	}
+	for _, table := range identitySetTables {
+		params := &updateSetParams{
+			argBuilder: s.driver.argBuilderFunc(),
+			Table:      table,
+			ID:         id,
+		}
+		if _, err := s.driver.exec(tx, tmplClearIdentitySet, params); err != nil {
+			return errgo.Notef(err, "cannot delete identity")
+		}
+	}
+	if _, err := s.driver.exec(tx, tmplDeleteIdentity, &deleteIdentityParams{
+		argBuilder: s.driver.argBuilderFunc(),
+		ID:         id,
+	}); err != nil {
+		return errgo.Notef(err, "cannot delete identity")
+	}
+	return nil
+}
+
 type nullTime struct {
 	Time  time.Time
 	Valid bool
@@ -544,7 +698,8 @@ type scanner interface {
 
 func scanIdentity(s scanner, identity *store.Identity) error {
 	var name, email, owner sql.NullString
-	var lastLogin, lastDischarge nullTime
+	var lastLogin, lastDischarge, tokensRevoked nullTime
+	var suspended sql.NullBool
 	err := s.Scan(
 		&identity.ID,
 		&identity.ProviderID,
@@ -554,6 +709,8 @@ func scanIdentity(s scanner, identity *store.Identity) error {
 		&lastLogin,
 		&lastDischarge,
 		&owner,
+		&tokensRevoked,
+		&suspended,
 	)
 	if err != nil {
 		return errgo.Mask(err, errgo.Any)
@@ -563,5 +720,7 @@ func scanIdentity(s scanner, identity *store.Identity) error {
 	identity.LastLogin = lastLogin.Time
 	identity.LastDischarge = lastDischarge.Time
 	identity.Owner = store.ProviderIdentity(owner.String)
+	identity.TokensRevoked = tokensRevoked.Time
+	identity.Suspended = suspended.Bool
 	return nil
 }
@@ -28,6 +28,9 @@ const (
 	ProviderInfo
 	ExtraInfo
 	Owner
+	TokensRevoked
+	Suspended
+	GroupExpiry
 	NumFields
 )
 
@@ -41,14 +44,14 @@ const (
 
 	// Set overrides the value of the field with the specified value.
 	//
-	// For the ProviderInfo and ExtraInfo fields the values are
-	// replaced on each specified key individually.
+	// For the ProviderInfo, ExtraInfo and GroupExpiry fields the
+	// values are replaced on each specified key individually.
 	Set
 
 	// Clear removes the field from the document.
 	//
-	// For the ProviderInfo and ExtraInfo fields the values are
-	// cleared on each specified key individually.
+	// For the ProviderInfo, ExtraInfo and GroupExpiry fields the
+	// values are cleared on each specified key individually.
 	Clear
 
 	// Push ensures that all the values in the field are added to any
@@ -64,6 +67,8 @@ const (
 	//
 	// For the ProviderInfo and ExtraInfo fields the values are
 	// removed from each specified key individually.
+	//
+	// Neither Push nor Pull may be used with the GroupExpiry field.
 	Pull
 )
 
@@ -123,6 +128,9 @@ type Store interface {
 	// greater than 0 then the results will contain at most that many
 	// identities. If skip is greater than 0 then that many results
 	// will be skipped before those that are returned.
+	//
+	// The Groups field may only be filtered with Equal, which matches
+	// identities that are members of all the groups in ref.Groups.
 	FindIdentities(ctx context.Context, ref *Identity, filter Filter, sort []Sort, skip, limit int) ([]Identity, error)
 
 	// UpdateIdentity stores the data from the given identity in
@@ -146,8 +154,121 @@ type Store interface {
 	// IdentityCounts returns the number of identities stored in the
 	// store split by provider ID.
 	IdentityCounts(ctx context.Context) (map[string]int, error)
+
+	// DeleteIdentity removes the identity matching the first
+	// non-zero value of ID, ProviderID or Username from persistent
+	// storage, along with all of its associated data. If no match
+	// can be found for the given identity then an error with the
+	// cause ErrNotFound will be returned. Any identities owned by
+	// the deleted identity are not removed.
+	DeleteIdentity(ctx context.Context, identity *Identity) error
+
+	// Group reads the group with the name matching the given
+	// group's Name from persistent storage and completes all the
+	// fields. If there is no such group then an error with the cause
+	// ErrNotFound will be returned.
+	Group(ctx context.Context, group *Group) error
+
+	// FindGroups returns all the stored groups sorted by name.
+	FindGroups(ctx context.Context) ([]Group, error)
+
+	// AddGroup creates a new group in persistent storage. If a group
+	// with the same name already exists then an error with the cause
+	// ErrDuplicateGroup will be returned.
+	AddGroup(ctx context.Context, group *Group) error
+
+	// UpdateGroup updates the group with the name matching the given
+	// group's Name. The fields that are changed are dictated by the
+	// given GroupUpdate in the same way as UpdateIdentity. If there
+	// is no such group then an error with the cause ErrNotFound will
+	// be returned.
+	UpdateGroup(ctx context.Context, group *Group, update GroupUpdate) error
+
+	// RemoveGroup removes the group with the given name from
+	// persistent storage. If there is no such group then an error
+	// with the cause ErrNotFound will be returned. The group is not
+	// removed from any identities, or other groups, that refer to
+	// it.
+	RemoveGroup(ctx context.Context, name string) error
+
+	// AddGroupChanges records the given changes to group
+	// memberships in persistent storage.
+	AddGroupChanges(ctx context.Context, changes []GroupChange) error
+
+	// GroupChanges returns the recorded group membership changes
+	// that match the given filter in the order in which they
+	// occurred.
+	GroupChanges(ctx context.Context, f GroupChangeFilter) ([]GroupChange, error)
+
+	// AddElevation stores a new request for the temporary elevation
+	// of a user into a group. The ID of the given elevation is set to
+	// a new unique value.
+	AddElevation(ctx context.Context, e *Elevation) error
+
+	// Elevation reads the elevation with the ID in the given
+	// elevation. If there is no such elevation then an error with a
+	// cause of ErrNotFound will be returned.
+	Elevation(ctx context.Context, e *Elevation) error
+
+	// FindElevations returns the elevations that match the given
+	// filter in the order in which they were requested.
+	FindElevations(ctx context.Context, f ElevationFilter) ([]Elevation, error)
+
+	// UpdateElevation moves the elevation with the ID in the given
+	// elevation from the state "from" to the state in the given
+	// elevation, storing its Approver, Decided and Expires fields.
+	// If there is no such elevation then an error with a cause of
+	// ErrNotFound will be returned. If the elevation is not in the
+	// state "from" then an error with a cause of
+	// ErrElevationStateChanged will be returned.
+	UpdateElevation(ctx context.Context, e *Elevation, from ElevationState) error
+
+	// AddOutboxEntries adds the given entries to the outbox of
+	// messages waiting to be delivered to webhooks. The ID of each
+	// entry is set to a new unique value.
+	AddOutboxEntries(ctx context.Context, entries []OutboxEntry) error
+
+	// OutboxEntries returns the outbox entries whose NextAttempt is
+	// not after the given time in the order in which they were
+	// added. If limit is greater than 0 then at most that many
+	// entries are returned.
+	OutboxEntries(ctx context.Context, due time.Time, limit int) ([]OutboxEntry, error)
+
+	// UpdateOutboxEntry stores the Attempts and NextAttempt fields
+	// of the outbox entry with the ID in the given entry. If there
+	// is no such entry then an error with a cause of ErrNotFound
+	// will be returned.
+	UpdateOutboxEntry(ctx context.Context, e *OutboxEntry) error
+
+	// ClaimOutboxEntry sets the NextAttempt field of the outbox
+	// entry with the given ID to until, provided that its
+	// NextAttempt is not after due. This allows a dispatcher to
+	// claim an entry before attempting to deliver it, so that no
+	// other dispatcher attempts it before until. If there is no
+	// such entry that is due then an error with a cause of
+	// ErrNotFound will be returned.
+	ClaimOutboxEntry(ctx context.Context, id string, due, until time.Time) error
+
+	// RemoveOutboxEntry removes the outbox entry with the given ID.
+	// If there is no such entry then an error with a cause of
+	// ErrNotFound will be returned.
+	RemoveOutboxEntry(ctx context.Context, id string) error
 }
 
+// GroupField represents a field in a group record.
+type GroupField int
+
+const (
+	GroupDescription GroupField = iota
+	GroupOwners
+	GroupMemberGroups
+	NumGroupFields
+)
+
+// A GroupUpdate is used in a Store.UpdateGroup to specify how the group
+// record fields should be changed.
+type GroupUpdate [NumGroupFields]Operation
+
 // A ProviderIdentity is a provider-specific unique identity.
 type ProviderIdentity string
 
@@ -234,4 +355,314 @@ type Identity struct {
 	// Owner contains the ProviderIdentity of the identity that owns
 	// this one.
 	Owner ProviderIdentity
+
+	// TokensRevoked contains the time at which all outstanding
+	// discharge macaroons and discharge tokens for the identity were
+	// revoked. Any such token issued before this time is no longer
+	// accepted.
+	TokensRevoked time.Time
+
+	// Suspended records whether the identity has been suspended. A
+	// suspended identity, and any agent it owns, cannot be
+	// authenticated.
+	Suspended bool
+
+	// GroupExpiry contains the time at which the identity's
+	// membership of each of the given groups expires. Groups in
+	// Groups that have no entry never expire.
+	GroupExpiry map[string]time.Time
+}
+
+// CurrentGroups returns the groups in Groups that have not expired at
+// the given time.
+func (id *Identity) CurrentGroups(now time.Time) []string {
+	if len(id.GroupExpiry) == 0 {
+		return id.Groups
+	}
+	groups := make([]string, 0, len(id.Groups))
+	for _, g := range id.Groups {
+		if t, ok := id.GroupExpiry[g]; ok && !now.Before(t) {
+			continue
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// Group represents a group in the store. Identities become members of
+// a group either by having the group in their Groups field or by being
+// a member of one of the group's member groups.
+type Group struct {
+	// Name contains the name of the group, this is the same name
+	// that is used in Identity.Groups.
+	Name string
+
+	// Description contains a human readable description of the
+	// group.
+	Description string
+
+	// Owners contains an ACL of the users and groups that may manage
+	// the group.
+	Owners []string
+
+	// MemberGroups contains the names of groups whose members are
+	// also members of this group.
+	MemberGroups []string
+}
+
+// A GroupChangeOp identifies the kind of change made to a group
+// membership.
+type GroupChangeOp string
+
+const (
+	// GroupAdd records that an identity was added to a group.
+	GroupAdd GroupChangeOp = "add"
+
+	// GroupRemove records that an identity was removed from a
+	// group.
+	GroupRemove GroupChangeOp = "remove"
+)
+
+// A GroupChange records a single change to an identity's membership of
+// a group, or to the member groups of a group.
+type GroupChange struct {
+	// Time holds the time at which the change was made.
+	Time time.Time
+
+	// Actor holds the username of the user that made the change.
+	// It is empty if the change was made by the identity server
+	// itself, for example when a time-limited membership expires.
+	Actor string
+
+	// Username holds the username of the identity whose membership
+	// changed. It is empty if the change was made to the member
+	// groups of the group.
+	Username string
+
+	// MemberGroup holds the name of the member group that was added
+	// to or removed from the group. It is empty if the change was
+	// made to the membership of an identity.
+	MemberGroup string
+
+	// Group holds the name of the group.
+	Group string
+
+	// Op holds the kind of change that was made.
+	Op GroupChangeOp
+
+	// Until, if not zero, holds the time at which a membership
+	// added by the change expires.
+	Until time.Time
+}
+
+// A GroupChangeFilter selects group changes in a Store.GroupChanges
+// call.
+type GroupChangeFilter struct {
+	// Username, if not empty, restricts the changes to those made
+	// to the given identity.
+	Username string
+
+	// Group, if not empty, restricts the changes to those made to
+	// the given group.
+	Group string
+
+	// After, if not zero, restricts the changes to those made at or
+	// after the given time.
+	After time.Time
+
+	// Before, if not zero, restricts the changes to those made
+	// before the given time.
+	Before time.Time
+
+	// Limit, if greater than zero, holds the maximum number of
+	// changes to return.
+	Limit int
+}
+
+type groupChangeActorKey struct{}
+
+// ContextWithGroupChanges returns a context that causes any changes to
+// the groups of an identity made by Store.UpdateIdentity or
+// Store.DeleteIdentity calls, and any changes to the member groups of a
+// group made by Store.AddGroup, Store.UpdateGroup or Store.RemoveGroup
+// calls, using the context to be recorded in the group change history,
+// as made by the given actor. Where the backend
+// supports it the changes are recorded in the same transaction as the
+// update.
+func ContextWithGroupChanges(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, groupChangeActorKey{}, actor)
+}
+
+// GroupChangeActor returns the actor given to ContextWithGroupChanges
+// for the given context. The returned bool reports whether group
+// changes should be recorded.
+func GroupChangeActor(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(groupChangeActorKey{}).(string)
+	return actor, ok
+}
+
+// DiffGroups returns the changes made at the given time, by the given
+// actor, when the groups of the identity with the given username change
+// from old to new.
+func DiffGroups(t time.Time, actor, username string, old, new []string) []GroupChange {
+	var changes []GroupChange
+	diffStrings(old, new, func(g string, op GroupChangeOp) {
+		changes = append(changes, GroupChange{
+			Time:     t,
+			Actor:    actor,
+			Username: username,
+			Group:    g,
+			Op:       op,
+		})
+	})
+	return changes
+}
+
+// DiffMemberGroups returns the changes made at the given time, by the
+// given actor, when the member groups of the given group change from
+// old to new.
+func DiffMemberGroups(t time.Time, actor, group string, old, new []string) []GroupChange {
+	var changes []GroupChange
+	diffStrings(old, new, func(g string, op GroupChangeOp) {
+		changes = append(changes, GroupChange{
+			Time:        t,
+			Actor:       actor,
+			MemberGroup: g,
+			Group:       group,
+			Op:          op,
+		})
+	})
+	return changes
+}
+
+// diffStrings calls f for each value that is in new but not in old,
+// with GroupAdd, then for each value that is in old but not in new, with
+// GroupRemove. f is called at most once for each value.
+func diffStrings(old, new []string, f func(string, GroupChangeOp)) {
+	oldSet := make(map[string]bool, len(old))
+	for _, g := range old {
+		oldSet[g] = true
+	}
+	newSet := make(map[string]bool, len(new))
+	for _, g := range new {
+		if !oldSet[g] && !newSet[g] {
+			f(g, GroupAdd)
+		}
+		newSet[g] = true
+	}
+	for _, g := range old {
+		if !newSet[g] {
+			f(g, GroupRemove)
+			newSet[g] = true
+		}
+	}
+}
+
+// An ElevationState holds the state of an Elevation.
+type ElevationState string
+
+const (
+	// ElevationPending is the state of an elevation that is
+	// waiting for a decision from an approver.
+	ElevationPending ElevationState = "pending"
+
+	// ElevationApproved is the state of an elevation that has been
+	// approved. The elevation grants membership of its group until
+	// it expires.
+	ElevationApproved ElevationState = "approved"
+
+	// ElevationDenied is the state of an elevation that has been
+	// refused by an approver.
+	ElevationDenied ElevationState = "denied"
+
+	// ElevationRevoked is the state of an approved elevation that
+	// has been withdrawn before it expired.
+	ElevationRevoked ElevationState = "revoked"
+)
+
+// An Elevation is a request for a user to be treated as a member of a
+// group for a limited time.
+type Elevation struct {
+	// ID holds the unique ID of the elevation.
+	ID string
+
+	// Username holds the username of the user requesting the
+	// elevation.
+	Username string
+
+	// Group holds the name of the group the user is requesting
+	// membership of.
+	Group string
+
+	// Reason holds the user's justification for the request.
+	Reason string
+
+	// Duration holds the length of time for which membership is
+	// granted once the request is approved.
+	Duration time.Duration
+
+	// State holds the current state of the elevation.
+	State ElevationState
+
+	// Requested holds the time at which the elevation was
+	// requested.
+	Requested time.Time
+
+	// Approver holds the username of the user that approved,
+	// denied or revoked the elevation.
+	Approver string
+
+	// Decided holds the time at which the elevation last changed
+	// state.
+	Decided time.Time
+
+	// Expires holds the time at which an approved elevation stops
+	// granting membership of the group.
+	Expires time.Time
+}
+
+// Active reports whether the elevation grants membership of its group
+// at the given time.
+func (e *Elevation) Active(now time.Time) bool {
+	return e.State == ElevationApproved && now.Before(e.Expires)
+}
+
+// An ElevationFilter selects elevations in a Store.FindElevations call.
+type ElevationFilter struct {
+	// Username, if not empty, restricts the elevations to those
+	// requested by the given user.
+	Username string
+
+	// Group, if not empty, restricts the elevations to those for
+	// the given group.
+	Group string
+
+	// State, if not empty, restricts the elevations to those in the
+	// given state.
+	State ElevationState
+}
+
+// An OutboxEntry holds a message that is waiting to be delivered to a
+// webhook.
+type OutboxEntry struct {
+	// ID holds the unique ID of the entry.
+	ID string
+
+	// Webhook holds the name of the webhook to which the message
+	// will be delivered.
+	Webhook string
+
+	// Payload holds the message to deliver.
+	Payload []byte
+
+	// Created holds the time at which the entry was added.
+	Created time.Time
+
+	// Attempts holds the number of failed attempts to deliver the
+	// message.
+	Attempts int
+
+	// NextAttempt holds the earliest time at which delivery of the
+	// message should next be attempted.
+	NextAttempt time.Time
 }
@@ -5,6 +5,7 @@ package store_test
 
 import (
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 
@@ -19,3 +20,20 @@ func TestProviderIdentity(t *testing.T) {
 	c.Assert(prov, qt.Equals, "test")
 	c.Assert(id, qt.Equals, "test-id")
 }
+
+func TestCurrentGroups(t *testing.T) {
+	c := qt.New(t)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	id := store.Identity{
+		Groups: []string{"g1", "g2", "g3"},
+	}
+	c.Assert(id.CurrentGroups(now), qt.DeepEquals, []string{"g1", "g2", "g3"})
+	id.GroupExpiry = map[string]time.Time{
+		"g1": now.Add(-time.Hour),
+		"g2": now,
+		"g3": now.Add(time.Hour),
+		"g4": now.Add(-time.Hour),
+	}
+	c.Assert(id.CurrentGroups(now), qt.DeepEquals, []string{"g3"})
+	c.Assert(id.CurrentGroups(now.Add(-2*time.Hour)), qt.DeepEquals, []string{"g1", "g2", "g3"})
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"context"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+
+	"github.com/canonical/candid/audit"
+)
+
+// auditSuite contains a set of tests for audit.Store implementations.
+type auditSuite struct {
+	newStore func(c *qt.C) audit.Store
+
+	Store audit.Store
+}
+
+// TestAuditStore tests the audit.Store returned by newStore.
+func TestAuditStore(c *qt.C, newStore func(c *qt.C) audit.Store) {
+	qtsuite.Run(c, &auditSuite{
+		newStore: newStore,
+	})
+}
+
+func (s *auditSuite) Init(c *qt.C) {
+	s.Store = s.newStore(c)
+}
+
+var auditEpoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var auditEvents = []audit.Event{{
+	Time: auditEpoch,
+	Type: audit.Login,
+	User: "bob",
+	IDP:  "test",
+}, {
+	Time:   auditEpoch.Add(time.Minute),
+	Type:   audit.Discharge,
+	User:   "bob",
+	Caveat: "is-authenticated-user",
+}, {
+	Time:  auditEpoch.Add(2 * time.Minute),
+	Type:  audit.SetGroups,
+	Actor: "admin@candid",
+	User:  "bob",
+	Set:   []string{"g1", "g2"},
+}, {
+	Time:   auditEpoch.Add(3 * time.Minute),
+	Type:   audit.ModifyACL,
+	Actor:  "alice",
+	ACL:    "read-user",
+	Add:    []string{"bob"},
+	Remove: []string{"charlie"},
+}, {
+	Time:  auditEpoch.Add(4 * time.Minute),
+	Type:  audit.Login,
+	User:  "charlie",
+	IDP:   "test",
+	Error: "login failed",
+}}
+
+var auditEventsTests = []struct {
+	about  string
+	filter audit.Filter
+	expect []int
+}{{
+	about:  "all events",
+	expect: []int{0, 1, 2, 3, 4},
+}, {
+	about: "type",
+	filter: audit.Filter{
+		Type: audit.Login,
+	},
+	expect: []int{0, 4},
+}, {
+	about: "user",
+	filter: audit.Filter{
+		User: "bob",
+	},
+	expect: []int{0, 1, 2},
+}, {
+	about: "actor",
+	filter: audit.Filter{
+		User: "alice",
+	},
+	expect: []int{3},
+}, {
+	about: "time range",
+	filter: audit.Filter{
+		After:  auditEpoch.Add(time.Minute),
+		Before: auditEpoch.Add(3 * time.Minute),
+	},
+	expect: []int{1, 2},
+}, {
+	about: "limit",
+	filter: audit.Filter{
+		Limit: 2,
+	},
+	expect: []int{0, 1},
+}, {
+	about: "combined",
+	filter: audit.Filter{
+		Type:  audit.Login,
+		User:  "charlie",
+		After: auditEpoch,
+		Limit: 10,
+	},
+	expect: []int{4},
+}, {
+	about: "no match",
+	filter: audit.Filter{
+		User: "dave",
+	},
+}}
+
+func (s *auditSuite) TestEvents(c *qt.C) {
+	ctx := context.Background()
+	// Log the events out of order to check that they are returned
+	// in time order.
+	for _, i := range []int{3, 0, 4, 2, 1} {
+		err := s.Store.Log(ctx, auditEvents[i])
+		c.Assert(err, qt.IsNil)
+	}
+	for _, test := range auditEventsTests {
+		c.Run(test.about, func(c *qt.C) {
+			events, err := s.Store.Events(ctx, test.filter)
+			c.Assert(err, qt.IsNil)
+			var expect []audit.Event
+			for _, i := range test.expect {
+				expect = append(expect, auditEvents[i])
+			}
+			c.Assert(events, qt.HasLen, len(expect))
+			for i := range events {
+				c.Assert(events[i].Time.Equal(expect[i].Time), qt.Equals, true)
+				events[i].Time = expect[i].Time
+			}
+			c.Assert(events, qt.DeepEquals, expect)
+		})
+	}
+}
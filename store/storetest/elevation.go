@@ -0,0 +1,177 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+var elevationEpoch = time.Date(2021, 3, 3, 9, 0, 0, 0, time.UTC)
+
+func (s *storeSuite) TestAddElevation(c *qt.C) {
+	e := store.Elevation{
+		Username:  "bob",
+		Group:     "prod-admin",
+		Reason:    "incident 42",
+		Duration:  2 * time.Hour,
+		State:     store.ElevationPending,
+		Requested: elevationEpoch,
+	}
+	err := s.Store.AddElevation(s.ctx, &e)
+	c.Assert(err, qt.IsNil)
+	c.Assert(e.ID, qt.Not(qt.Equals), "")
+
+	e1 := store.Elevation{
+		ID: e.ID,
+	}
+	err = s.Store.Elevation(s.ctx, &e1)
+	c.Assert(err, qt.IsNil)
+	assertEqualElevation(c, e1, e)
+}
+
+func (s *storeSuite) TestElevationNotFound(c *qt.C) {
+	err := s.Store.Elevation(s.ctx, &store.Elevation{ID: "1000"})
+	c.Assert(err, qt.ErrorMatches, `elevation 1000 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.Elevation(s.ctx, &store.Elevation{ID: "not-an-id"})
+	c.Assert(err, qt.ErrorMatches, `elevation not-an-id not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestFindElevations(c *qt.C) {
+	elevations := []store.Elevation{{
+		Username:  "bob",
+		Group:     "prod-admin",
+		State:     store.ElevationPending,
+		Requested: elevationEpoch,
+	}, {
+		Username:  "alice",
+		Group:     "prod-admin",
+		State:     store.ElevationApproved,
+		Requested: elevationEpoch.Add(time.Minute),
+		Approver:  "admin@candid",
+		Decided:   elevationEpoch.Add(2 * time.Minute),
+		Expires:   elevationEpoch.Add(time.Hour),
+	}, {
+		Username:  "bob",
+		Group:     "db-admin",
+		State:     store.ElevationApproved,
+		Requested: elevationEpoch.Add(3 * time.Minute),
+	}}
+	for i := range elevations {
+		err := s.Store.AddElevation(s.ctx, &elevations[i])
+		c.Assert(err, qt.IsNil)
+	}
+	tests := []struct {
+		about  string
+		filter store.ElevationFilter
+		expect []int
+	}{{
+		about:  "all",
+		expect: []int{0, 1, 2},
+	}, {
+		about:  "username",
+		filter: store.ElevationFilter{Username: "bob"},
+		expect: []int{0, 2},
+	}, {
+		about:  "group",
+		filter: store.ElevationFilter{Group: "prod-admin"},
+		expect: []int{0, 1},
+	}, {
+		about:  "state",
+		filter: store.ElevationFilter{State: store.ElevationApproved},
+		expect: []int{1, 2},
+	}, {
+		about: "combined",
+		filter: store.ElevationFilter{
+			Username: "bob",
+			State:    store.ElevationApproved,
+		},
+		expect: []int{2},
+	}, {
+		about:  "no match",
+		filter: store.ElevationFilter{State: store.ElevationDenied},
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			found, err := s.Store.FindElevations(s.ctx, test.filter)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.HasLen, len(test.expect))
+			for i, j := range test.expect {
+				assertEqualElevation(c, found[i], elevations[j])
+			}
+		})
+	}
+}
+
+func (s *storeSuite) TestUpdateElevation(c *qt.C) {
+	e := store.Elevation{
+		Username:  "bob",
+		Group:     "prod-admin",
+		Duration:  time.Hour,
+		State:     store.ElevationPending,
+		Requested: elevationEpoch,
+	}
+	err := s.Store.AddElevation(s.ctx, &e)
+	c.Assert(err, qt.IsNil)
+
+	e.State = store.ElevationApproved
+	e.Approver = "alice"
+	e.Decided = elevationEpoch.Add(time.Minute)
+	e.Expires = elevationEpoch.Add(time.Hour + time.Minute)
+	err = s.Store.UpdateElevation(s.ctx, &e, store.ElevationPending)
+	c.Assert(err, qt.IsNil)
+
+	e1 := store.Elevation{
+		ID: e.ID,
+	}
+	err = s.Store.Elevation(s.ctx, &e1)
+	c.Assert(err, qt.IsNil)
+	assertEqualElevation(c, e1, e)
+
+	// A second decision on the pending elevation fails.
+	e2 := e
+	e2.State = store.ElevationDenied
+	e2.Approver = "charlie"
+	err = s.Store.UpdateElevation(s.ctx, &e2, store.ElevationPending)
+	c.Assert(err, qt.ErrorMatches, `elevation .* is approved`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrElevationStateChanged)
+
+	err = s.Store.Elevation(s.ctx, &e1)
+	c.Assert(err, qt.IsNil)
+	assertEqualElevation(c, e1, e)
+}
+
+func (s *storeSuite) TestUpdateElevationNotFound(c *qt.C) {
+	err := s.Store.UpdateElevation(s.ctx, &store.Elevation{
+		ID:    "1000",
+		State: store.ElevationDenied,
+	}, store.ElevationPending)
+	c.Assert(err, qt.ErrorMatches, `elevation 1000 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+// assertEqualElevation checks that the two elevations are equal,
+// allowing for differences in the time zone and precision with which
+// times are stored.
+func assertEqualElevation(c *qt.C, obtained, expected store.Elevation) {
+	for _, t := range []struct {
+		obtained *time.Time
+		expected time.Time
+	}{
+		{&obtained.Requested, expected.Requested},
+		{&obtained.Decided, expected.Decided},
+		{&obtained.Expires, expected.Expires},
+	} {
+		c.Assert(t.obtained.Equal(t.expected), qt.Equals, true, qt.Commentf("obtained %v, expected %v", *t.obtained, t.expected))
+		*t.obtained = t.expected
+	}
+	c.Assert(obtained, qt.DeepEquals, expected)
+}
@@ -0,0 +1,286 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"fmt"
+	"sort"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+func (s *storeSuite) TestAddGroup(c *qt.C) {
+	err := s.Store.AddGroup(s.ctx, &store.Group{
+		Name:         "group1",
+		Description:  "Group One",
+		Owners:       []string{"alice", "admins"},
+		MemberGroups: []string{"group2", "group3"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	group := store.Group{Name: "group1"}
+	err = s.Store.Group(s.ctx, &group)
+	c.Assert(err, qt.IsNil)
+	assertEqualGroup(c, &group, &store.Group{
+		Name:         "group1",
+		Description:  "Group One",
+		Owners:       []string{"admins", "alice"},
+		MemberGroups: []string{"group2", "group3"},
+	})
+}
+
+func (s *storeSuite) TestAddGroupDuplicate(c *qt.C) {
+	err := s.Store.AddGroup(s.ctx, &store.Group{Name: "group1"})
+	c.Assert(err, qt.IsNil)
+
+	err = s.Store.AddGroup(s.ctx, &store.Group{
+		Name:        "group1",
+		Description: "Group One",
+	})
+	c.Assert(err, qt.ErrorMatches, `group group1 already exists`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrDuplicateGroup)
+
+	group := store.Group{Name: "group1"}
+	err = s.Store.Group(s.ctx, &group)
+	c.Assert(err, qt.IsNil)
+	assertEqualGroup(c, &group, &store.Group{Name: "group1"})
+}
+
+func (s *storeSuite) TestGroupNotFound(c *qt.C) {
+	err := s.Store.Group(s.ctx, &store.Group{Name: "no-such-group"})
+	c.Assert(err, qt.ErrorMatches, `group no-such-group not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestFindGroups(c *qt.C) {
+	groups, err := s.Store.FindGroups(s.ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 0)
+
+	for _, name := range []string{"b", "c", "a"} {
+		err := s.Store.AddGroup(s.ctx, &store.Group{
+			Name:         name,
+			Description:  "group " + name,
+			MemberGroups: []string{name + "1"},
+		})
+		c.Assert(err, qt.IsNil)
+	}
+	groups, err = s.Store.FindGroups(s.ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(groups, qt.HasLen, 3)
+	for i, name := range []string{"a", "b", "c"} {
+		assertEqualGroup(c, &groups[i], &store.Group{
+			Name:         name,
+			Description:  "group " + name,
+			MemberGroups: []string{name + "1"},
+		})
+	}
+}
+
+var updateGroupTests = []struct {
+	about       string
+	startGroup  *store.Group
+	updateGroup *store.Group
+	update      store.GroupUpdate
+	expectGroup *store.Group
+	expectError string
+	expectCause error
+}{{
+	about: "set description",
+	startGroup: &store.Group{
+		Description: "old",
+	},
+	updateGroup: &store.Group{
+		Description: "new",
+	},
+	update: store.GroupUpdate{
+		store.GroupDescription: store.Set,
+	},
+	expectGroup: &store.Group{
+		Description: "new",
+	},
+}, {
+	about: "clear description",
+	startGroup: &store.Group{
+		Description: "old",
+		Owners:      []string{"alice"},
+	},
+	updateGroup: &store.Group{},
+	update: store.GroupUpdate{
+		store.GroupDescription: store.Clear,
+	},
+	expectGroup: &store.Group{
+		Owners: []string{"alice"},
+	},
+}, {
+	about: "set owners",
+	startGroup: &store.Group{
+		Owners: []string{"alice", "bob"},
+	},
+	updateGroup: &store.Group{
+		Owners: []string{"charlie"},
+	},
+	update: store.GroupUpdate{
+		store.GroupOwners: store.Set,
+	},
+	expectGroup: &store.Group{
+		Owners: []string{"charlie"},
+	},
+}, {
+	about: "push owners",
+	startGroup: &store.Group{
+		Owners: []string{"alice", "bob"},
+	},
+	updateGroup: &store.Group{
+		Owners: []string{"bob", "charlie"},
+	},
+	update: store.GroupUpdate{
+		store.GroupOwners: store.Push,
+	},
+	expectGroup: &store.Group{
+		Owners: []string{"alice", "bob", "charlie"},
+	},
+}, {
+	about: "pull owners",
+	startGroup: &store.Group{
+		Owners: []string{"alice", "bob"},
+	},
+	updateGroup: &store.Group{
+		Owners: []string{"bob", "charlie"},
+	},
+	update: store.GroupUpdate{
+		store.GroupOwners: store.Pull,
+	},
+	expectGroup: &store.Group{
+		Owners: []string{"alice"},
+	},
+}, {
+	about: "clear member groups",
+	startGroup: &store.Group{
+		Description:  "group",
+		MemberGroups: []string{"g1", "g2"},
+	},
+	updateGroup: &store.Group{},
+	update: store.GroupUpdate{
+		store.GroupMemberGroups: store.Clear,
+	},
+	expectGroup: &store.Group{
+		Description: "group",
+	},
+}, {
+	about: "push and pull member groups",
+	startGroup: &store.Group{
+		Owners:       []string{"alice"},
+		MemberGroups: []string{"g1", "g2"},
+	},
+	updateGroup: &store.Group{
+		Owners:       []string{"alice"},
+		MemberGroups: []string{"g3"},
+	},
+	update: store.GroupUpdate{
+		store.GroupOwners:       store.Pull,
+		store.GroupMemberGroups: store.Push,
+	},
+	expectGroup: &store.Group{
+		MemberGroups: []string{"g1", "g2", "g3"},
+	},
+}, {
+	about:       "no update",
+	startGroup:  &store.Group{Description: "group"},
+	updateGroup: &store.Group{Description: "new"},
+	expectGroup: &store.Group{Description: "group"},
+}, {
+	about:       "not found",
+	updateGroup: &store.Group{Description: "new"},
+	update: store.GroupUpdate{
+		store.GroupDescription: store.Set,
+	},
+	expectError: `group group[0-9]+ not found`,
+	expectCause: store.ErrNotFound,
+}, {
+	about:       "not found no update",
+	updateGroup: &store.Group{},
+	expectError: `group group[0-9]+ not found`,
+	expectCause: store.ErrNotFound,
+}}
+
+func (s *storeSuite) TestUpdateGroup(c *qt.C) {
+	for i, test := range updateGroupTests {
+		c.Run(test.about, func(c *qt.C) {
+			name := fmt.Sprintf("group%d", i)
+			if test.startGroup != nil {
+				test.startGroup.Name = name
+				err := s.Store.AddGroup(s.ctx, test.startGroup)
+				c.Assert(err, qt.IsNil)
+			}
+			test.updateGroup.Name = name
+			err := s.Store.UpdateGroup(s.ctx, test.updateGroup, test.update)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			obtained := store.Group{Name: name}
+			err = s.Store.Group(s.ctx, &obtained)
+			c.Assert(err, qt.IsNil)
+			test.expectGroup.Name = name
+			assertEqualGroup(c, &obtained, test.expectGroup)
+		})
+	}
+}
+
+func (s *storeSuite) TestRemoveGroup(c *qt.C) {
+	err := s.Store.AddGroup(s.ctx, &store.Group{
+		Name:         "group1",
+		Owners:       []string{"alice"},
+		MemberGroups: []string{"group2"},
+	})
+	c.Assert(err, qt.IsNil)
+
+	err = s.Store.RemoveGroup(s.ctx, "group1")
+	c.Assert(err, qt.IsNil)
+
+	err = s.Store.Group(s.ctx, &store.Group{Name: "group1"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.RemoveGroup(s.ctx, "group1")
+	c.Assert(err, qt.ErrorMatches, `group group1 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	// The group can be re-created without any of its previous
+	// values.
+	err = s.Store.AddGroup(s.ctx, &store.Group{Name: "group1"})
+	c.Assert(err, qt.IsNil)
+	group := store.Group{Name: "group1"}
+	err = s.Store.Group(s.ctx, &group)
+	c.Assert(err, qt.IsNil)
+	assertEqualGroup(c, &group, &store.Group{Name: "group1"})
+}
+
+// assertEqualGroup checks that the given groups are equivalent,
+// ignoring the order of owners and member groups.
+func assertEqualGroup(c *qt.C, obtained, expected *store.Group) {
+	c.Helper()
+	c.Assert(normalizeGroup(obtained), qt.DeepEquals, normalizeGroup(expected))
+}
+
+func normalizeGroup(g *store.Group) store.Group {
+	g1 := *g
+	g1.Owners = sortedStrings(g.Owners)
+	g1.MemberGroups = sortedStrings(g.MemberGroups)
+	return g1
+}
+
+func sortedStrings(ss []string) []string {
+	if len(ss) == 0 {
+		return nil
+	}
+	ss = append([]string(nil), ss...)
+	sort.Strings(ss)
+	return ss
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/canonical/candid/store"
+)
+
+var groupChangeEpoch = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+var groupChanges = []store.GroupChange{{
+	Time:     groupChangeEpoch,
+	Actor:    "admin@candid",
+	Username: "bob",
+	Group:    "prod-admin",
+	Op:       store.GroupAdd,
+}, {
+	Time:     groupChangeEpoch.Add(time.Hour),
+	Actor:    "admin@candid",
+	Username: "alice",
+	Group:    "prod-admin",
+	Op:       store.GroupAdd,
+}, {
+	Time:     groupChangeEpoch.Add(2 * time.Hour),
+	Actor:    "alice",
+	Username: "bob",
+	Group:    "dev",
+	Op:       store.GroupAdd,
+}, {
+	Time:     groupChangeEpoch.Add(3 * time.Hour),
+	Username: "bob",
+	Group:    "prod-admin",
+	Op:       store.GroupRemove,
+}, {
+	Time:        groupChangeEpoch.Add(4 * time.Hour),
+	Actor:       "alice",
+	MemberGroup: "interns",
+	Group:       "dev",
+	Op:          store.GroupAdd,
+	Until:       groupChangeEpoch.Add(48 * time.Hour),
+}}
+
+var groupChangesTests = []struct {
+	about  string
+	filter store.GroupChangeFilter
+	expect []int
+}{{
+	about:  "all changes",
+	expect: []int{0, 1, 2, 3, 4},
+}, {
+	about: "username",
+	filter: store.GroupChangeFilter{
+		Username: "bob",
+	},
+	expect: []int{0, 2, 3},
+}, {
+	about: "group",
+	filter: store.GroupChangeFilter{
+		Group: "prod-admin",
+	},
+	expect: []int{0, 1, 3},
+}, {
+	about: "group with member group change",
+	filter: store.GroupChangeFilter{
+		Group: "dev",
+	},
+	expect: []int{2, 4},
+}, {
+	about: "time range",
+	filter: store.GroupChangeFilter{
+		After:  groupChangeEpoch.Add(time.Hour),
+		Before: groupChangeEpoch.Add(3 * time.Hour),
+	},
+	expect: []int{1, 2},
+}, {
+	about: "limit",
+	filter: store.GroupChangeFilter{
+		Limit: 3,
+	},
+	expect: []int{0, 1, 2},
+}, {
+	about: "combined",
+	filter: store.GroupChangeFilter{
+		Username: "bob",
+		Group:    "prod-admin",
+		After:    groupChangeEpoch.Add(time.Minute),
+	},
+	expect: []int{3},
+}, {
+	about: "no match",
+	filter: store.GroupChangeFilter{
+		Username: "dave",
+	},
+}}
+
+func (s *storeSuite) TestGroupChanges(c *qt.C) {
+	// Add the changes out of order to check that they are returned
+	// in time order.
+	err := s.Store.AddGroupChanges(s.ctx, []store.GroupChange{groupChanges[2], groupChanges[0]})
+	c.Assert(err, qt.IsNil)
+	err = s.Store.AddGroupChanges(s.ctx, []store.GroupChange{groupChanges[3], groupChanges[4], groupChanges[1]})
+	c.Assert(err, qt.IsNil)
+	err = s.Store.AddGroupChanges(s.ctx, nil)
+	c.Assert(err, qt.IsNil)
+	for _, test := range groupChangesTests {
+		c.Run(test.about, func(c *qt.C) {
+			changes, err := s.Store.GroupChanges(s.ctx, test.filter)
+			c.Assert(err, qt.IsNil)
+			var expect []store.GroupChange
+			for _, i := range test.expect {
+				expect = append(expect, groupChanges[i])
+			}
+			c.Assert(changes, qt.HasLen, len(expect))
+			for i := range changes {
+				c.Assert(changes[i].Time.Equal(expect[i].Time), qt.Equals, true)
+				changes[i].Time = expect[i].Time
+				c.Assert(changes[i].Until.Equal(expect[i].Until), qt.Equals, true)
+				changes[i].Until = expect[i].Until
+			}
+			c.Assert(changes, qt.DeepEquals, expect)
+		})
+	}
+}
+
+func (s *storeSuite) TestRecordGroupChanges(c *qt.C) {
+	ctx := store.ContextWithGroupChanges(s.ctx, "admin@candid")
+	var n int
+	assertChanges := func(expect ...store.GroupChange) {
+		c.Helper()
+		changes, err := s.Store.GroupChanges(s.ctx, store.GroupChangeFilter{
+			Username: "bob",
+		})
+		c.Assert(err, qt.IsNil)
+		changes = changes[n:]
+		n += len(changes)
+		for i := range changes {
+			c.Assert(changes[i].Time.IsZero(), qt.Equals, false)
+			changes[i].Time = time.Time{}
+		}
+		if len(expect) == 0 {
+			c.Assert(changes, qt.HasLen, 0)
+			return
+		}
+		c.Assert(changes, qt.DeepEquals, expect)
+	}
+	add := func(g string) store.GroupChange {
+		return store.GroupChange{Actor: "admin@candid", Username: "bob", Group: g, Op: store.GroupAdd}
+	}
+	remove := func(g string) store.GroupChange {
+		return store.GroupChange{Actor: "admin@candid", Username: "bob", Group: g, Op: store.GroupRemove}
+	}
+
+	err := s.Store.UpdateIdentity(ctx, &store.Identity{
+		ProviderID: store.MakeProviderIdentity("test", "bob"),
+		Username:   "bob",
+		Groups:     []string{"g1"},
+	}, store.Update{
+		store.Username: store.Set,
+		store.Groups:   store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(add("g1"))
+
+	// Only groups that are gained or lost are recorded.
+	err = s.Store.UpdateIdentity(ctx, &store.Identity{
+		Username: "bob",
+		Groups:   []string{"g1", "g2"},
+	}, store.Update{
+		store.Groups: store.Push,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(add("g2"))
+
+	err = s.Store.UpdateIdentity(ctx, &store.Identity{
+		Username: "bob",
+		Groups:   []string{"g1", "g3"},
+	}, store.Update{
+		store.Groups: store.Pull,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(remove("g1"))
+
+	// Changes are not recorded without the context.
+	err = s.Store.UpdateIdentity(s.ctx, &store.Identity{
+		Username: "bob",
+		Groups:   []string{"g4"},
+	}, store.Update{
+		store.Groups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges()
+
+	err = s.Store.DeleteIdentity(ctx, &store.Identity{
+		Username: "bob",
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(remove("g4"))
+}
+
+func (s *storeSuite) TestRecordMemberGroupChanges(c *qt.C) {
+	ctx := store.ContextWithGroupChanges(s.ctx, "admin@candid")
+	var n int
+	assertChanges := func(expect ...store.GroupChange) {
+		c.Helper()
+		changes, err := s.Store.GroupChanges(s.ctx, store.GroupChangeFilter{
+			Group: "g1",
+		})
+		c.Assert(err, qt.IsNil)
+		changes = changes[n:]
+		n += len(changes)
+		for i := range changes {
+			c.Assert(changes[i].Time.IsZero(), qt.Equals, false)
+			changes[i].Time = time.Time{}
+		}
+		if len(expect) == 0 {
+			c.Assert(changes, qt.HasLen, 0)
+			return
+		}
+		c.Assert(changes, qt.DeepEquals, expect)
+	}
+	add := func(g string) store.GroupChange {
+		return store.GroupChange{Actor: "admin@candid", MemberGroup: g, Group: "g1", Op: store.GroupAdd}
+	}
+	remove := func(g string) store.GroupChange {
+		return store.GroupChange{Actor: "admin@candid", MemberGroup: g, Group: "g1", Op: store.GroupRemove}
+	}
+
+	err := s.Store.AddGroup(ctx, &store.Group{
+		Name:         "g1",
+		MemberGroups: []string{"g2"},
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(add("g2"))
+
+	// Only member groups that are gained or lost are recorded.
+	err = s.Store.UpdateGroup(ctx, &store.Group{
+		Name:         "g1",
+		MemberGroups: []string{"g2", "g3"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Push,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(add("g3"))
+
+	err = s.Store.UpdateGroup(ctx, &store.Group{
+		Name:         "g1",
+		MemberGroups: []string{"g2", "g4"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Pull,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges(remove("g2"))
+
+	// Updates to other fields record nothing.
+	err = s.Store.UpdateGroup(ctx, &store.Group{
+		Name:        "g1",
+		Description: "group one",
+	}, store.GroupUpdate{
+		store.GroupDescription: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges()
+
+	// Changes are not recorded without the context.
+	err = s.Store.UpdateGroup(s.ctx, &store.Group{
+		Name:         "g1",
+		MemberGroups: []string{"g5"},
+	}, store.GroupUpdate{
+		store.GroupMemberGroups: store.Set,
+	})
+	c.Assert(err, qt.IsNil)
+	assertChanges()
+
+	err = s.Store.RemoveGroup(ctx, "g1")
+	c.Assert(err, qt.IsNil)
+	assertChanges(remove("g5"))
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	errgo "gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/store"
+)
+
+var outboxEpoch = time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+
+func (s *storeSuite) TestAddOutboxEntries(c *qt.C) {
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook1",
+		Payload:     []byte(`{"type":"identity-created"}`),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+	}, {
+		Webhook:     "hook2",
+		Payload:     []byte(`{"type":"identity-deleted"}`),
+		Created:     outboxEpoch.Add(time.Second),
+		NextAttempt: outboxEpoch.Add(time.Second),
+	}}
+	err := s.Store.AddOutboxEntries(s.ctx, entries)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries[0].ID, qt.Not(qt.Equals), "")
+	c.Assert(entries[1].ID, qt.Not(qt.Equals), "")
+	c.Assert(entries[0].ID, qt.Not(qt.Equals), entries[1].ID)
+
+	found, err := s.Store.OutboxEntries(s.ctx, outboxEpoch.Add(time.Hour), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.HasLen, 2)
+	assertEqualOutboxEntry(c, found[0], entries[0])
+	assertEqualOutboxEntry(c, found[1], entries[1])
+}
+
+func (s *storeSuite) TestOutboxEntries(c *qt.C) {
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook1",
+		Payload:     []byte("1"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch.Add(time.Hour),
+	}, {
+		Webhook:     "hook1",
+		Payload:     []byte("2"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+	}, {
+		Webhook:     "hook1",
+		Payload:     []byte("3"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch.Add(time.Minute),
+	}}
+	for i := range entries {
+		err := s.Store.AddOutboxEntries(s.ctx, entries[i:i+1])
+		c.Assert(err, qt.IsNil)
+	}
+	tests := []struct {
+		about  string
+		due    time.Time
+		limit  int
+		expect []int
+	}{{
+		about: "none due",
+		due:   outboxEpoch.Add(-time.Second),
+	}, {
+		about:  "some due",
+		due:    outboxEpoch.Add(time.Minute),
+		expect: []int{1, 2},
+	}, {
+		about:  "all due",
+		due:    outboxEpoch.Add(time.Hour),
+		expect: []int{0, 1, 2},
+	}, {
+		about:  "limit",
+		due:    outboxEpoch.Add(time.Hour),
+		limit:  2,
+		expect: []int{0, 1},
+	}}
+	for _, test := range tests {
+		c.Run(test.about, func(c *qt.C) {
+			found, err := s.Store.OutboxEntries(s.ctx, test.due, test.limit)
+			c.Assert(err, qt.IsNil)
+			c.Assert(found, qt.HasLen, len(test.expect))
+			for i, j := range test.expect {
+				assertEqualOutboxEntry(c, found[i], entries[j])
+			}
+		})
+	}
+}
+
+func (s *storeSuite) TestUpdateOutboxEntry(c *qt.C) {
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook1",
+		Payload:     []byte("1"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+	}}
+	err := s.Store.AddOutboxEntries(s.ctx, entries)
+	c.Assert(err, qt.IsNil)
+
+	e := entries[0]
+	e.Attempts = 3
+	e.NextAttempt = outboxEpoch.Add(time.Hour)
+	err = s.Store.UpdateOutboxEntry(s.ctx, &e)
+	c.Assert(err, qt.IsNil)
+
+	found, err := s.Store.OutboxEntries(s.ctx, outboxEpoch.Add(time.Minute), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.HasLen, 0)
+	found, err = s.Store.OutboxEntries(s.ctx, outboxEpoch.Add(time.Hour), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.HasLen, 1)
+	assertEqualOutboxEntry(c, found[0], e)
+}
+
+func (s *storeSuite) TestUpdateOutboxEntryNotFound(c *qt.C) {
+	err := s.Store.UpdateOutboxEntry(s.ctx, &store.OutboxEntry{
+		ID:       "1000",
+		Attempts: 1,
+	})
+	c.Assert(err, qt.ErrorMatches, `outbox entry 1000 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.UpdateOutboxEntry(s.ctx, &store.OutboxEntry{
+		ID:       "not-an-id",
+		Attempts: 1,
+	})
+	c.Assert(err, qt.ErrorMatches, `outbox entry not-an-id not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestClaimOutboxEntry(c *qt.C) {
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook1",
+		Payload:     []byte("1"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+		Attempts:    2,
+	}}
+	err := s.Store.AddOutboxEntries(s.ctx, entries)
+	c.Assert(err, qt.IsNil)
+
+	// An entry that is not yet due cannot be claimed.
+	err = s.Store.ClaimOutboxEntry(s.ctx, entries[0].ID, outboxEpoch.Add(-time.Second), outboxEpoch.Add(time.Minute))
+	c.Assert(err, qt.ErrorMatches, `outbox entry .* not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.ClaimOutboxEntry(s.ctx, entries[0].ID, outboxEpoch, outboxEpoch.Add(time.Minute))
+	c.Assert(err, qt.IsNil)
+
+	// The entry cannot be claimed again until the claim expires.
+	err = s.Store.ClaimOutboxEntry(s.ctx, entries[0].ID, outboxEpoch, outboxEpoch.Add(time.Minute))
+	c.Assert(err, qt.ErrorMatches, `outbox entry .* not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	found, err := s.Store.OutboxEntries(s.ctx, outboxEpoch.Add(time.Hour), 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.HasLen, 1)
+	e := entries[0]
+	e.NextAttempt = outboxEpoch.Add(time.Minute)
+	assertEqualOutboxEntry(c, found[0], e)
+
+	err = s.Store.ClaimOutboxEntry(s.ctx, entries[0].ID, outboxEpoch.Add(time.Minute), outboxEpoch.Add(2*time.Minute))
+	c.Assert(err, qt.IsNil)
+
+	err = s.Store.ClaimOutboxEntry(s.ctx, "1000", outboxEpoch, outboxEpoch)
+	c.Assert(err, qt.ErrorMatches, `outbox entry 1000 not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.ClaimOutboxEntry(s.ctx, "not-an-id", outboxEpoch, outboxEpoch)
+	c.Assert(err, qt.ErrorMatches, `outbox entry not-an-id not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestRemoveOutboxEntry(c *qt.C) {
+	entries := []store.OutboxEntry{{
+		Webhook:     "hook1",
+		Payload:     []byte("1"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+	}, {
+		Webhook:     "hook1",
+		Payload:     []byte("2"),
+		Created:     outboxEpoch,
+		NextAttempt: outboxEpoch,
+	}}
+	err := s.Store.AddOutboxEntries(s.ctx, entries)
+	c.Assert(err, qt.IsNil)
+
+	err = s.Store.RemoveOutboxEntry(s.ctx, entries[0].ID)
+	c.Assert(err, qt.IsNil)
+	found, err := s.Store.OutboxEntries(s.ctx, outboxEpoch, 0)
+	c.Assert(err, qt.IsNil)
+	c.Assert(found, qt.HasLen, 1)
+	assertEqualOutboxEntry(c, found[0], entries[1])
+
+	err = s.Store.RemoveOutboxEntry(s.ctx, entries[0].ID)
+	c.Assert(err, qt.ErrorMatches, `outbox entry .* not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+	err = s.Store.RemoveOutboxEntry(s.ctx, "not-an-id")
+	c.Assert(err, qt.ErrorMatches, `outbox entry not-an-id not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+// assertEqualOutboxEntry checks that the two outbox entries are equal,
+// allowing for differences in the time zone and precision with which
+// times are stored.
+func assertEqualOutboxEntry(c *qt.C, obtained, expected store.OutboxEntry) {
+	for _, t := range []struct {
+		obtained *time.Time
+		expected time.Time
+	}{
+		{&obtained.Created, expected.Created},
+		{&obtained.NextAttempt, expected.NextAttempt},
+	} {
+		c.Assert(t.obtained.Equal(t.expected), qt.Equals, true, qt.Commentf("obtained %v, expected %v", *t.obtained, t.expected))
+		*t.obtained = t.expected
+	}
+	c.Assert(obtained, qt.DeepEquals, expected)
+}
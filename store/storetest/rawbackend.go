@@ -0,0 +1,150 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package storetest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/frankban/quicktest/qtsuite"
+	"gopkg.in/macaroon-bakery.v2/bakery/dbrootkeystore"
+
+	"github.com/canonical/candid/store"
+)
+
+var (
+	rawPast   = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	rawFuture = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// rawBackendSuite contains a set of tests for store.RawBackend
+// implementations.
+type rawBackendSuite struct {
+	newBackend func(c *qt.C) store.RawBackend
+	Backend    store.RawBackend
+}
+
+// TestRawBackend tests the store.RawBackend specific methods of the
+// given backend.
+func TestRawBackend(c *qt.C, newBackend func(c *qt.C) store.RawBackend) {
+	qtsuite.Run(c, &rawBackendSuite{
+		newBackend: newBackend,
+	})
+}
+
+func (s *rawBackendSuite) Init(c *qt.C) {
+	s.Backend = s.newBackend(c)
+}
+
+func (s *rawBackendSuite) TestProviderData(c *qt.C) {
+	ctx := context.Background()
+	kv1, err := s.Backend.ProviderDataStore().KeyValueStore(ctx, "idp1")
+	c.Assert(err, qt.IsNil)
+	kv2, err := s.Backend.ProviderDataStore().KeyValueStore(ctx, "idp2")
+	c.Assert(err, qt.IsNil)
+	err = kv1.Set(ctx, "k1", []byte("v1"), time.Time{})
+	c.Assert(err, qt.IsNil)
+	err = kv1.Set(ctx, "k2", []byte("v2"), rawFuture)
+	c.Assert(err, qt.IsNil)
+	err = kv2.Set(ctx, "k1", []byte("v3"), time.Time{})
+	c.Assert(err, qt.IsNil)
+	err = kv2.Set(ctx, "expired", []byte("v4"), rawPast)
+	c.Assert(err, qt.IsNil)
+
+	data, err := s.Backend.ProviderData(ctx)
+	c.Assert(err, qt.IsNil)
+	sort.Slice(data, func(i, j int) bool {
+		if data[i].IDP != data[j].IDP {
+			return data[i].IDP < data[j].IDP
+		}
+		return data[i].Key < data[j].Key
+	})
+	for i := range data {
+		if !data[i].Expire.IsZero() {
+			data[i].Expire = data[i].Expire.UTC()
+		}
+	}
+	c.Assert(data, qt.DeepEquals, []store.ProviderData{{
+		IDP:   "idp1",
+		Key:   "k1",
+		Value: []byte("v1"),
+	}, {
+		IDP:    "idp1",
+		Key:    "k2",
+		Value:  []byte("v2"),
+		Expire: rawFuture,
+	}, {
+		IDP:   "idp2",
+		Key:   "k1",
+		Value: []byte("v3"),
+	}})
+}
+
+func (s *rawBackendSuite) TestProviderDataEmpty(c *qt.C) {
+	data, err := s.Backend.ProviderData(context.Background())
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, 0)
+}
+
+func (s *rawBackendSuite) TestAddRootKey(c *qt.C) {
+	ctx := context.Background()
+	key := dbrootkeystore.RootKey{
+		Id:      []byte("key1"),
+		Created: rawPast,
+		Expires: rawFuture,
+		RootKey: []byte("0123456789abcdef0123456789abcdef"),
+	}
+	err := s.Backend.AddRootKey(ctx, key)
+	c.Assert(err, qt.IsNil)
+
+	// Adding a key with the same ID leaves the original.
+	err = s.Backend.AddRootKey(ctx, dbrootkeystore.RootKey{
+		Id:      []byte("key1"),
+		Created: rawPast,
+		Expires: rawFuture,
+		RootKey: []byte("fedcba9876543210fedcba9876543210"),
+	})
+	c.Assert(err, qt.IsNil)
+
+	rootKey, err := s.Backend.BakeryRootKeyStore().Get(ctx, []byte("key1"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(rootKey, qt.DeepEquals, key.RootKey)
+
+	keys, err := s.Backend.RootKeys(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(normalizeRootKeys(keys), qt.DeepEquals, []dbrootkeystore.RootKey{key})
+}
+
+func (s *rawBackendSuite) TestRootKeys(c *qt.C) {
+	ctx := context.Background()
+	rootKey, id, err := s.Backend.BakeryRootKeyStore().RootKey(ctx)
+	c.Assert(err, qt.IsNil)
+
+	keys, err := s.Backend.RootKeys(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(keys, qt.HasLen, 1)
+	c.Assert(keys[0].Id, qt.DeepEquals, id)
+	c.Assert(keys[0].RootKey, qt.DeepEquals, rootKey)
+
+	// The key can be added to another backend.
+	b := s.newBackend(c)
+	err = b.AddRootKey(ctx, keys[0])
+	c.Assert(err, qt.IsNil)
+	rootKey1, err := b.BakeryRootKeyStore().Get(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rootKey1, qt.DeepEquals, rootKey)
+}
+
+func normalizeRootKeys(keys []dbrootkeystore.RootKey) []dbrootkeystore.RootKey {
+	sort.Slice(keys, func(i, j int) bool {
+		return string(keys[i].Id) < string(keys[j].Id)
+	})
+	for i := range keys {
+		keys[i].Created = keys[i].Created.UTC()
+		keys[i].Expires = keys[i].Expires.UTC()
+	}
+	return keys
+}
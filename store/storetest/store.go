@@ -690,6 +690,153 @@ var updateIdentityTests = []struct {
 		store.Owner: store.Clear,
 	},
 	expectIdentity: &store.Identity{},
+}, {
+	about: "set tokens revoked",
+	startIdentity: &store.Identity{
+		TokensRevoked: time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+	},
+	updateIdentity: &store.Identity{
+		TokensRevoked: time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+	},
+	update: store.Update{
+		store.TokensRevoked: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		TokensRevoked: time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+	},
+}, {
+	about: "clear tokens revoked",
+	startIdentity: &store.Identity{
+		TokensRevoked: time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+	},
+	updateIdentity: &store.Identity{},
+	update: store.Update{
+		store.TokensRevoked: store.Clear,
+	},
+	expectIdentity: &store.Identity{},
+}, {
+	about:         "set suspended",
+	startIdentity: &store.Identity{},
+	updateIdentity: &store.Identity{
+		Suspended: true,
+	},
+	update: store.Update{
+		store.Suspended: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		Suspended: true,
+	},
+}, {
+	about: "unset suspended",
+	startIdentity: &store.Identity{
+		Suspended: true,
+	},
+	updateIdentity: &store.Identity{
+		Suspended: false,
+	},
+	update: store.Update{
+		store.Suspended: store.Set,
+	},
+	expectIdentity: &store.Identity{},
+}, {
+	about: "set group expiry",
+	startIdentity: &store.Identity{
+		Groups: []string{"g1", "g2"},
+		GroupExpiry: map[string]time.Time{
+			"g1": time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	updateIdentity: &store.Identity{
+		GroupExpiry: map[string]time.Time{
+			"g1": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+			"g2": time.Date(2017, 12, 27, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	update: store.Update{
+		store.GroupExpiry: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		Groups: []string{"g1", "g2"},
+		GroupExpiry: map[string]time.Time{
+			"g1": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+			"g2": time.Date(2017, 12, 27, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	about: "clear group expiry",
+	startIdentity: &store.Identity{
+		Groups: []string{"g1", "g2"},
+		GroupExpiry: map[string]time.Time{
+			"g1": time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+			"g2": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	updateIdentity: &store.Identity{
+		GroupExpiry: map[string]time.Time{
+			"g1": {},
+			"g3": {},
+		},
+	},
+	update: store.Update{
+		store.GroupExpiry: store.Clear,
+	},
+	expectIdentity: &store.Identity{
+		Groups: []string{"g1", "g2"},
+		GroupExpiry: map[string]time.Time{
+			"g2": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	about: "group expiry with special characters",
+	startIdentity: &store.Identity{
+		Groups: []string{"ops.oncall", "$g", "a%2Eb", "other.group"},
+		GroupExpiry: map[string]time.Time{
+			"ops.oncall":  time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+			"other.group": time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	updateIdentity: &store.Identity{
+		GroupExpiry: map[string]time.Time{
+			"ops.oncall": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+			"$g":         time.Date(2017, 12, 27, 0, 0, 0, 0, time.UTC),
+			"a%2Eb":      time.Date(2017, 12, 28, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	update: store.Update{
+		store.GroupExpiry: store.Set,
+	},
+	expectIdentity: &store.Identity{
+		Groups: []string{"ops.oncall", "$g", "a%2Eb", "other.group"},
+		GroupExpiry: map[string]time.Time{
+			"ops.oncall":  time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+			"other.group": time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+			"$g":          time.Date(2017, 12, 27, 0, 0, 0, 0, time.UTC),
+			"a%2Eb":       time.Date(2017, 12, 28, 0, 0, 0, 0, time.UTC),
+		},
+	},
+}, {
+	about: "clear group expiry with special characters",
+	startIdentity: &store.Identity{
+		Groups: []string{"ops.oncall", "other.group"},
+		GroupExpiry: map[string]time.Time{
+			"ops.oncall":  time.Date(2017, 12, 25, 0, 0, 0, 0, time.UTC),
+			"other.group": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+		},
+	},
+	updateIdentity: &store.Identity{
+		GroupExpiry: map[string]time.Time{
+			"ops.oncall": {},
+		},
+	},
+	update: store.Update{
+		store.GroupExpiry: store.Clear,
+	},
+	expectIdentity: &store.Identity{
+		Groups: []string{"ops.oncall", "other.group"},
+		GroupExpiry: map[string]time.Time{
+			"other.group": time.Date(2017, 12, 26, 0, 0, 0, 0, time.UTC),
+		},
+	},
 }, {
 	about: "username not found",
 	updateIdentity: &store.Identity{
@@ -778,6 +925,15 @@ func (s *storeSuite) TestUpdateIdentity(c *qt.C) {
 				if !test.startIdentity.LastLogin.IsZero() {
 					update[store.LastLogin] = store.Set
 				}
+				if !test.startIdentity.TokensRevoked.IsZero() {
+					update[store.TokensRevoked] = store.Set
+				}
+				if test.startIdentity.Suspended {
+					update[store.Suspended] = store.Set
+				}
+				if len(test.startIdentity.GroupExpiry) > 0 {
+					update[store.GroupExpiry] = store.Set
+				}
 				err := s.Store.UpdateIdentity(s.ctx, test.startIdentity, update)
 				c.Assert(err, qt.IsNil)
 			}
@@ -1031,6 +1187,7 @@ var testIdentities = []store.Identity{{
 	Username:      "test2",
 	Name:          "Test User 2",
 	Email:         "test2@example.com",
+	Groups:        []string{"g2"},
 	LastLogin:     time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC),
 	LastDischarge: time.Date(2017, 2, 8, 0, 0, 0, 0, time.UTC),
 }, {
@@ -1054,6 +1211,7 @@ var testIdentities = []store.Identity{{
 	Email:         "test5@example.com",
 	LastLogin:     time.Date(2017, 1, 5, 0, 0, 0, 0, time.UTC),
 	LastDischarge: time.Date(2017, 2, 5, 0, 0, 0, 0, time.UTC),
+	Suspended:     true,
 }, {
 	ProviderID:    store.MakeProviderIdentity("test", "test6"),
 	Username:      "test6",
@@ -1384,6 +1542,36 @@ var findIdentitiesTests = []struct {
 		store.Owner: store.Equal,
 	},
 	expect: []int{5},
+}, {
+	about: "match suspended",
+	ref: store.Identity{
+		Suspended: true,
+	},
+	filter: store.Filter{
+		store.Suspended: store.Equal,
+	},
+	expect: []int{4},
+}, {
+	about: "match group member",
+	ref: store.Identity{
+		Groups: []string{"g2"},
+	},
+	filter: store.Filter{
+		store.Groups: store.Equal,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	expect: []int{0, 1},
+}, {
+	about: "match member of all groups",
+	ref: store.Identity{
+		Groups: []string{"g1", "g2"},
+	},
+	filter: store.Filter{
+		store.Groups:   store.Equal,
+		store.Username: store.NotEqual,
+	},
+	sort:   []store.Sort{{Field: store.Username}},
+	expect: []int{0},
 }}
 
 func (s *storeSuite) TestFindIdentities(c *qt.C) {
@@ -1419,6 +1607,9 @@ func (s *storeSuite) TestFindIdentities(c *qt.C) {
 		if testIdentities[i].Owner != "" {
 			update[store.Owner] = store.Set
 		}
+		if testIdentities[i].Suspended {
+			update[store.Suspended] = store.Set
+		}
 		err := s.Store.UpdateIdentity(s.ctx, &testIdentities[i], update)
 		c.Assert(err, qt.IsNil)
 	}
@@ -1454,3 +1645,112 @@ func (s *storeSuite) TestIdentityCounts(c *qt.C) {
 		"c": 1,
 	})
 }
+
+var deleteIdentityTests = []struct {
+	about string
+	ref   func(identity *store.Identity) *store.Identity
+}{{
+	about: "delete by ID",
+	ref: func(identity *store.Identity) *store.Identity {
+		return &store.Identity{ID: identity.ID}
+	},
+}, {
+	about: "delete by ProviderID",
+	ref: func(identity *store.Identity) *store.Identity {
+		return &store.Identity{ProviderID: identity.ProviderID}
+	},
+}, {
+	about: "delete by Username",
+	ref: func(identity *store.Identity) *store.Identity {
+		return &store.Identity{Username: identity.Username}
+	},
+}}
+
+func (s *storeSuite) TestDeleteIdentity(c *qt.C) {
+	for _, test := range deleteIdentityTests {
+		c.Run(test.about, func(c *qt.C) {
+			k := bakery.MustGenerateKey()
+			identity := store.Identity{
+				ProviderID: store.MakeProviderIdentity("test", "bob"),
+				Username:   "bob",
+				Groups:     []string{"g1", "g2"},
+				PublicKeys: []bakery.PublicKey{k.Public},
+				ProviderInfo: map[string][]string{
+					"pk1": {"pv1"},
+				},
+				ExtraInfo: map[string][]string{
+					"ek1": {"ev1"},
+				},
+			}
+			err := s.Store.UpdateIdentity(s.ctx, &identity, store.Update{
+				store.Username:     store.Set,
+				store.Groups:       store.Set,
+				store.PublicKeys:   store.Set,
+				store.ProviderInfo: store.Set,
+				store.ExtraInfo:    store.Set,
+			})
+			c.Assert(err, qt.IsNil)
+			other := store.Identity{
+				ProviderID: store.MakeProviderIdentity("test", "alice"),
+				Username:   "alice",
+			}
+			err = s.Store.UpdateIdentity(s.ctx, &other, store.Update{
+				store.Username: store.Set,
+			})
+			c.Assert(err, qt.IsNil)
+
+			err = s.Store.DeleteIdentity(s.ctx, test.ref(&identity))
+			c.Assert(err, qt.IsNil)
+			err = s.Store.Identity(s.ctx, &store.Identity{ID: identity.ID})
+			c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+			err = s.Store.Identity(s.ctx, &store.Identity{Username: "bob"})
+			c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+
+			// Other identities are unaffected.
+			err = s.Store.Identity(s.ctx, &store.Identity{ID: other.ID})
+			c.Assert(err, qt.IsNil)
+			identities, err := s.Store.FindIdentities(s.ctx, nil, store.Filter{}, nil, 0, 0)
+			c.Assert(err, qt.IsNil)
+			c.Assert(identities, qt.HasLen, 1)
+			c.Assert(identities[0].Username, qt.Equals, "alice")
+
+			// The identity can be recreated without any of its
+			// previous data.
+			identity2 := store.Identity{
+				ProviderID: store.MakeProviderIdentity("test", "bob"),
+				Username:   "bob",
+			}
+			err = s.Store.UpdateIdentity(s.ctx, &identity2, store.Update{
+				store.Username: store.Set,
+			})
+			c.Assert(err, qt.IsNil)
+			err = s.Store.Identity(s.ctx, &identity2)
+			c.Assert(err, qt.IsNil)
+			c.Assert(identity2.Groups, qt.HasLen, 0)
+			c.Assert(identity2.PublicKeys, qt.HasLen, 0)
+			c.Assert(identity2.ProviderInfo, qt.HasLen, 0)
+			c.Assert(identity2.ExtraInfo, qt.HasLen, 0)
+
+			err = s.Store.DeleteIdentity(s.ctx, &store.Identity{ID: identity2.ID})
+			c.Assert(err, qt.IsNil)
+			err = s.Store.DeleteIdentity(s.ctx, &store.Identity{ID: other.ID})
+			c.Assert(err, qt.IsNil)
+		})
+	}
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFound(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{Username: "bob"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+	c.Assert(err, qt.ErrorMatches, `user bob not found`)
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFoundNoQuery(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
+
+func (s *storeSuite) TestDeleteIdentityNotFoundBadID(c *qt.C) {
+	err := s.Store.DeleteIdentity(s.ctx, &store.Identity{ID: "1000000"})
+	c.Assert(errgo.Cause(err), qt.Equals, store.ErrNotFound)
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package webhook defines the configuration of the webhooks to which
+// the identity server publishes identity change events.
+package webhook
+
+import (
+	"net/url"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/canonical/candid/params"
+)
+
+// A Webhook is an HTTP endpoint that receives identity change events.
+// Each event is sent as a POST request with a JSON encoded
+// params.IdentityEvent body, signed using the webhook's secret.
+type Webhook struct {
+	// Name holds a unique name for the webhook. It is used to
+	// associate undelivered events with the webhook.
+	Name string `yaml:"name"`
+
+	// URL holds the URL to which events are sent.
+	URL string `yaml:"url"`
+
+	// Secret holds the secret shared with the receiver that is
+	// used to sign the events.
+	Secret string `yaml:"secret"`
+
+	// Events holds the types of event that are sent to the
+	// webhook. If this is empty all events are sent.
+	Events []params.IdentityEventType `yaml:"events,omitempty"`
+}
+
+// Wants reports whether events of the given type should be sent to the
+// webhook.
+func (w Webhook) Wants(t params.IdentityEventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, et := range w.Events {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the given webhooks are correctly specified.
+func Validate(hooks []Webhook) error {
+	names := make(map[string]bool)
+	for i, w := range hooks {
+		if w.Name == "" {
+			return errgo.Newf("webhook %d: missing name", i)
+		}
+		if names[w.Name] {
+			return errgo.Newf("webhook %s: duplicate name", w.Name)
+		}
+		names[w.Name] = true
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errgo.Newf("webhook %s: invalid url %q", w.Name, w.URL)
+		}
+		if w.Secret == "" {
+			return errgo.Newf("webhook %s: missing secret", w.Name)
+		}
+		for _, t := range w.Events {
+			switch t {
+			case params.IdentityCreated, params.IdentityGroupsChanged, params.IdentitySSHKeysChanged, params.IdentityDeleted:
+			default:
+				return errgo.Newf("webhook %s: unknown event type %q", w.Name, t)
+			}
+		}
+	}
+	return nil
+}